@@ -2,11 +2,14 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/google/uuid"
@@ -16,45 +19,98 @@ import (
 	"github.com/fenole/szmaterlok/storage"
 )
 
-func run(ctx context.Context) error {
-	log := service.LoggerDefault()
-	log.SetLevel(logrus.DebugLevel)
+func run(ctx context.Context, args []string) error {
+	flags, err := service.ConfigParseFlags(args, os.Stderr)
+	if errors.Is(err, flag.ErrHelp) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if flags.Version {
+		fmt.Println(buildInfo())
+		return nil
+	}
 
 	if err := service.ConfigLoad(ctx); err != nil {
 		return err
 	}
 
+	// Flags take precedence over env variables, which take precedence
+	// over config file.
 	config := service.ConfigDefault()
 	if err := service.ConfigRead(&config); err != nil {
 		return err
 	}
+	if err := flags.Apply(&config); err != nil {
+		return err
+	}
+	if err := service.ConfigValidate(config); err != nil {
+		return fmt.Errorf("invalid config: %w", err)
+	}
 
-	tokenizerFactory := service.SessionTokenizerFactory{
-		Timeout: time.Minute,
-		Logger:  log,
+	log := service.LoggerFromConfig(config)
+
+	var metrics *service.Metrics
+	if config.MetricsEnabled {
+		metrics = service.NewMetrics()
 	}
 
-	tokenizer, err := tokenizerFactory.Tokenizer(&config)
+	storage, err := storage.Open(ctx, config.Database)
 	if err != nil {
 		return err
 	}
 
-	storage, err := storage.NewSQLiteStorage(ctx, config.Database)
+	tokenizerFactory := service.SessionTokenizerFactory{
+		Timeout:   time.Minute,
+		Logger:    log,
+		CacheSize: config.TokenizerCacheSize,
+		Metrics:   metrics,
+	}
+
+	// Session tokens of simple tokenizer survive restarts, when
+	// storage is able to keep them.
+	if tokenStore, ok := storage.(service.SessionTokenStore); ok {
+		tokenizerFactory.TokenStore = tokenStore
+	}
+
+	tokenizer, err := tokenizerFactory.Tokenizer(&config)
 	if err != nil {
 		return err
 	}
 
+	if config.Retention > 0 || config.EphemeralRetention > 0 {
+		go prune(ctx, log, storage, config.Retention, config.EphemeralRetention)
+	}
+
 	stateOnlineUsers := service.NewStateOnlineUsers()
+	stateMessages := service.NewStateMessages(config.MessagesStateSize)
 
 	messageHandler := service.NewBridgeMessageHandler(log)
 	lastMessagesBuffer := service.NewLastMessagesBuffer(config.LastMessagesBufferSize, log)
 
+	// State rebuilt from archive covers messages only. Online users
+	// aren't rebuilt, because archived users are no longer connected.
 	stateEventRouter := service.NewBridgeEventRouter()
+	stateEventRouter.Logger = log
 	stateEventRouter.Hook(service.BridgeMessageSent, lastMessagesBuffer)
+	stateEventRouter.Hook(service.BridgeMessageSent, service.StateMessageSentHook(log, stateMessages))
+	stateEventRouter.Hook(service.BridgeMessageEdited, service.StateMessageEditedHook(log, stateMessages))
+	stateEventRouter.Hook(service.BridgeMessageDeleted, service.StateMessageDeletedHook(log, stateMessages))
+	stateEventRouter.Hook(service.BridgeMessageEdited, lastMessagesBuffer)
+	stateEventRouter.Hook(service.BridgeMessageDeleted, lastMessagesBuffer)
 
 	stateBuilder := service.StateBuilder{
 		Archive: storage,
 		Handler: stateEventRouter,
+		Filter: &service.EventFilter{
+			Types: []service.BridgeEventType{
+				service.BridgeMessageSent,
+				service.BridgeMessageEdited,
+				service.BridgeMessageDeleted,
+			},
+		},
 	}
 
 	log.Println("Rebuilding state.")
@@ -64,54 +120,283 @@ func run(ctx context.Context) error {
 	log.Println("State rebuilding process has succeed.")
 
 	eventRouter := service.NewBridgeEventRouter()
+	eventRouter.Logger = log
+	eventRouter.Timeout = config.HookTimeout
 	eventRouter.Hook(service.BridgeMessageSent, messageHandler)
 	eventRouter.Hook(service.BridgeUserJoin, messageHandler)
 	eventRouter.Hook(service.BridgeUserLeft, messageHandler)
+	eventRouter.Hook(service.BridgeUserTyping, messageHandler)
+	eventRouter.Hook(service.BridgeUserPresence, messageHandler)
+	eventRouter.Hook(service.BridgeUserMuted, messageHandler)
 	eventRouter.Hook(service.BridgeUserJoin, service.StateUserJoinHook(log, stateOnlineUsers))
 	eventRouter.Hook(service.BridgeUserLeft, service.StateUserLeftHook(log, stateOnlineUsers))
+	eventRouter.Hook(service.BridgeMessageSent, service.StateUserActivityHook(log, stateOnlineUsers))
 	eventRouter.Hook(service.BridgeMessageSent, lastMessagesBuffer)
+	eventRouter.Hook(service.BridgeMessageEdited, messageHandler)
+	eventRouter.Hook(service.BridgeMessageEdited, lastMessagesBuffer)
+	eventRouter.Hook(service.BridgeMessageSent, service.StateMessageSentHook(log, stateMessages))
+	eventRouter.Hook(service.BridgeMessageEdited, service.StateMessageEditedHook(log, stateMessages))
+	eventRouter.Hook(service.BridgeMessageDeleted, messageHandler)
+	eventRouter.Hook(service.BridgeMessageDeleted, lastMessagesBuffer)
+	eventRouter.Hook(service.BridgeMessageDeleted, service.StateMessageDeletedHook(log, stateMessages))
+
+	if len(config.WebhookURLs) > 0 {
+		webhooks := service.NewWebhookHandler(service.WebhookHandlerBuilder{
+			URLs:    config.WebhookURLs,
+			Secret:  config.WebhookSecret,
+			Timeout: config.WebhookTimeout,
+			Retries: config.WebhookRetries,
+			Backoff: time.Second,
+			Logger:  log,
+		})
+		for _, t := range config.WebhookEvents {
+			eventRouter.Hook(service.BridgeEventType(t), webhooks)
+		}
+
+		// Deliveries are cancelled with the hook, so retries left after
+		// hook timeout are never made.
+		if d := webhooks.MaxDuration(); config.HookTimeout > 0 && (d == 0 || d > config.HookTimeout) {
+			log.WithFields(logrus.Fields{
+				"hookTimeout":     config.HookTimeout,
+				"webhookDuration": d,
+			}).Warnf("%s doesn't cover all of webhook retries.", service.ConfigHookTimeoutVarName)
+		}
+	}
+
+	messageHandler.SlowClient = config.SlowClient
+	messageHandler.Metrics = metrics
+
+	// Events are numbered after the ones numbered by previous run, so
+	// clients can resume their event streams across restarts.
+	sequence, err := storage.LastSequence(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to read last event sequence number: %w", err)
+	}
 
 	bridge := service.NewBridge(ctx, service.BridgeBuilder{
-		Handler: eventRouter,
-		Logger:  log,
-		Storage: storage,
+		Handler:   eventRouter,
+		Logger:    log,
+		Storage:   storage,
+		Metrics:   metrics,
+		QueueSize: config.BridgeQueueSize,
+		Persist:   config.PersistPolicy.Persist,
+		Sequence:  sequence,
+		Sequences: storage,
 	})
 
 	clock := service.ClockFunc(time.Now)
-	r := service.NewRouter(service.RouterDependencies{
-		MaximumMessageSize: config.MaximumMessageSize,
+	streamShutdown := service.NewStreamShutdown()
+	shutdownAnnouncer := service.NewShutdownAnnouncer(
+		messageHandler,
+		&service.BridgeEventProducer[service.EventUserLeft]{
+			EventBridge: bridge,
+			Type:        service.BridgeUserLeft,
+			Log:         log,
+			Clock:       clock,
+		},
+		clock,
+		service.IDGeneratorFunc(uuid.NewString),
+	)
+
+	// Presence sweeper is stopped before event bridge shuts down, so
+	// it doesn't send events to closed bridge.
+	presenceCtx, stopPresence := context.WithCancel(ctx)
+	defer stopPresence()
+	if config.AwayTimeout > 0 || config.PresenceTTL > 0 {
+		presenceSweeper := &service.PresenceSweeper{
+			Users: stateOnlineUsers,
+			Producer: &service.BridgeEventProducer[service.EventUserPresence]{
+				EventBridge: bridge,
+				Type:        service.BridgeUserPresence,
+				Log:         log,
+				Clock:       clock,
+			},
+			UserLeftProducer: &service.BridgeEventProducer[service.EventUserLeft]{
+				EventBridge: bridge,
+				Type:        service.BridgeUserLeft,
+				Log:         log,
+				Clock:       clock,
+			},
+			IdleTimeout: config.AwayTimeout,
+			TTL:         config.PresenceTTL,
+			Clock:       clock,
+			IDGenerator: service.IDGeneratorFunc(uuid.NewString),
+		}
+		go presenceSweeper.Run(presenceCtx, presenceSweepInterval(config.AwayTimeout, config.PresenceTTL))
+	}
+
+	var messageRateLimiter *service.RateLimiter
+	if config.MessageRate > 0 {
+		messageRateLimiter = service.NewRateLimiter(ctx, service.RateLimiterBuilder{
+			Rate:          config.MessageRate,
+			Burst:         config.MessageBurst,
+			IdleTimeout:   time.Minute * 10,
+			SweepInterval: time.Minute,
+			Clock:         clock,
+		})
+	}
+
+	// Mutes survive restarts, if storage is able to keep them.
+	var mutes service.MuteStore = service.NewMuteStoreMemory(clock)
+	if muteStore, ok := storage.(service.MuteStore); ok {
+		mutes = muteStore
+	}
+
+	var messageFloodGuard *service.FloodGuard
+	if config.FloodViolations > 0 {
+		messageFloodGuard = service.NewFloodGuard(service.FloodGuardBuilder{
+			Mutes:      mutes,
+			Violations: config.FloodViolations,
+			Window:     config.FloodWindow,
+			Cooldown:   config.FloodMute,
+			Clock:      clock,
+		})
+	}
+
+	messageFilters := []service.MessageFilter{}
+	if config.WordFilterFile != "" {
+		wordFilter, err := service.LoadWordFilter(config.WordFilterFile)
+		if err != nil {
+			return err
+		}
+		messageFilters = append(messageFilters, wordFilter)
+	}
+	messageFilters = append(messageFilters, service.MentionFilter{
+		Users: stateOnlineUsers,
+	})
+	if config.Markdown {
+		messageFilters = append(messageFilters, service.NewMarkdownFilter())
+	}
+
+	var revoker service.SessionRevoker
+	if config.SessionRevocation {
+		revoker = storage
+	}
+
+	var uploads service.UploadStore
+	if config.UploadDir != "" {
+		diskStore, err := service.NewUploadDiskStore(config.UploadDir)
+		if err != nil {
+			return fmt.Errorf("failed to open upload directory: %w", err)
+		}
+		uploads = diskStore
+	}
+
+	var messageAcks *service.MessageAcks
+	if config.MaxAcks > 0 {
+		messageAcks = service.NewMessageAcks(config.MaxAcks)
+	}
+
+	messageSize := service.NewMessageSizeLimit(config.MaximumMessageSize)
+	reloader := service.NewConfigReloader(service.ConfigReloaderBuilder{
+		Config:             config,
 		Logger:             log,
+		MessageSize:        messageSize,
+		MessageRateLimiter: messageRateLimiter,
+	})
+
+	// Ban checks of every request are cached, so they don't hit
+	// storage each time.
+	bans := service.NewBanStoreCache(storage, time.Second*30, clock)
+
+	r := service.NewRouter(service.RouterDependencies{
+		BuildInfo:          buildInfo(),
+		Debug:              config.Debug,
+		MaximumMessageSize: messageSize,
+		NicknamePolicy: service.NicknamePolicy{
+			MinLength: config.NicknameMinLength,
+			MaxLength: config.NicknameMaxLength,
+		},
+		AdminPolicy: service.AdminPolicy{
+			Nicknames: config.Admins,
+			Token:     config.AdminToken,
+		},
+		HeartbeatInterval: config.SSEHeartbeatInterval,
+		ReconnectTime:     config.SSEReconnectTime,
+		PresenceTTL:       config.PresenceTTL,
+		StreamBufferSize:  config.SSEBufferSize,
+		Logger:            log,
 		SessionStore: &service.SessionCookieStore{
 			ExpirationTime: time.Hour * 24 * 7,
 			Tokenizer:      tokenizer,
+			Sliding:        config.SessionSliding,
+			SlidingWindow:  config.SessionSlidingWindow,
+			Secure:         config.CookieSecure,
+			SameSite:       config.CookieSameSite,
+			Revoker:        revoker,
+			Bans:           bans,
 			Clock:          clock,
 		},
-		Bridge:            bridge,
-		AllChatUsersStore: stateOnlineUsers,
+		Bridge:             bridge,
+		Storage:            storage,
+		Metrics:            metrics,
+		StreamShutdown:     streamShutdown,
+		ShutdownAnnouncer:  shutdownAnnouncer,
+		StreamConnections:  service.NewConnectionLimiter(config.MaxConns, config.MaxConnsPerUser),
+		MessageAcks:        messageAcks,
+		MessageRateLimiter: messageRateLimiter,
+		MessageFloodGuard:  messageFloodGuard,
+		CORSOrigins:        config.CORSOrigins,
+		AdminToken:         config.AdminToken,
+		APIKeys:            config.APIKeys,
+		IncomingWebhooks:   config.IncomingWebhooks,
+		Uploads:            uploads,
+		UploadMaxSize:      config.UploadMaxSize,
+		UploadContentTypes: config.UploadContentTypes,
+		Archive:            storage,
+		Importer:           storage,
+		ImportMaxSize:      config.ImportMaxSize,
+		UserDisconnecter:   messageHandler,
+		Bans:               bans,
+		Mutes:              mutes,
+		SlowMode:           service.NewSlowModeMemory(clock, config.SlowMode),
+		History:            lastMessagesBuffer,
+		ModerationLog:      storage,
+		MessageFilters:     messageFilters,
+		AllChatUsersStore:  stateOnlineUsers,
+		ChatUsersCounter:   stateOnlineUsers,
+		ChatUserStore:      stateOnlineUsers,
+		PresenceStore:      stateOnlineUsers,
+		MessageStore:       stateMessages,
+		MessageHistory:     storage,
+		MessageSearch:      storage,
 		MessageNotifier: &service.MessageNotifierWithBuffer{
 			Notifier: messageHandler,
 			Buffer:   lastMessagesBuffer,
 			Logger:   log,
+			Users:    stateOnlineUsers,
+			Cursors:  service.NewResumeCursorCodec([]byte(config.SessionSecret), config.ResumeCursorTTL, clock),
+			Acks:     messageAcks,
 		},
-		IDGenerator: service.IDGeneratorFunc(uuid.NewString),
-		Clock:       clock,
+		PresenceHeartbeater: stateOnlineUsers,
+		IDGenerator:         service.IDGeneratorFunc(uuid.NewString),
+		Clock:               clock,
 	})
 
 	c := make(chan os.Signal, 1)
 	errc := make(chan error, 1)
 
 	wait := time.Second * 15
+	grace := time.Second * 2
 	srv := &http.Server{
-		Addr:    config.Address,
-		Handler: r,
-		// TODO(thinkofher): Come back later to setup timeouts.
+		Addr:              config.Address,
+		Handler:           service.ServerHandler(r, config),
+		ReadTimeout:       config.ReadTimeout,
+		ReadHeaderTimeout: config.ReadHeaderTimeout,
+		WriteTimeout:      config.WriteTimeout,
+		IdleTimeout:       config.IdleTimeout,
+	}
+
+	mode := service.ListenModeFromConfig(config)
+	if mode == service.ListenAutocert {
+		service.ServerAutocert(srv, config)
 	}
 
 	log.Println("Starting szmaterlok")
 	// Run our server in a goroutine so that it doesn't block.
 	go func() {
-		log.Printf("Listening at %s", config.Address)
-		if err := srv.ListenAndServe(); err != nil {
+		log.Printf("Listening at %s (%s)", config.Address, mode)
+		if err := service.ServerListen(srv, config); err != nil {
 			errc <- err
 		}
 	}()
@@ -120,30 +405,149 @@ func run(ctx context.Context) error {
 	// SIGKILL, SIGQUIT or SIGTERM (Ctrl+/) will not be caught.
 	signal.Notify(c, os.Interrupt)
 
+	// SIGHUP reloads config values, which can change at runtime.
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
 	// Block until we receive our signal or error from server.
-	select {
-	case <-c:
-		ctx, cancel := context.WithTimeout(ctx, wait)
-		defer cancel()
-		// Doesn't block if no connections, but will otherwise wait
-		// until the timeout deadline.
-		srv.Shutdown(ctx)
-
-		// Wait for bridge to process its jobs.
-		bridge.Shutdown(ctx)
-
-		// Optionally, you could run srv.Shutdown in a goroutine and block on
-		// <-ctx.Done() if your application should wait for other services
-		// to finalize based on context cancellation.
-		log.Println("Shutting down")
-		return nil
-	case err := <-errc:
-		return err
+	for {
+		select {
+		case <-hup:
+			log.Println("Reloading config.")
+			getenv, err := service.ConfigReloadFiles(ctx)
+			if err != nil {
+				log.WithField("error", err.Error()).Error("Failed to reload config files.")
+				continue
+			}
+
+			next := service.ConfigDefault()
+			if err := service.ConfigReadEnv(&next, getenv); err != nil {
+				log.WithField("error", err.Error()).Error("Failed to read reloaded config.")
+				continue
+			}
+			if err := flags.Apply(&next); err != nil {
+				log.WithField("error", err.Error()).Error("Failed to apply flags to reloaded config.")
+				continue
+			}
+			if err := reloader.Reload(next); err != nil {
+				log.WithField("error", err.Error()).Error("Failed to reload config.")
+			}
+		case <-c:
+			ctx, cancel := context.WithTimeout(ctx, wait)
+			defer cancel()
+
+			// Let other clients know that every connected user is
+			// leaving. Streams closed afterwards don't announce their
+			// users again.
+			stopPresence()
+			shutdownAnnouncer.Announce(ctx)
+
+			// Tell clients to reconnect and give their event streams
+			// a moment to close, before server stops accepting them.
+			if !streamShutdown.Shutdown(ctx, grace) {
+				log.Println("Event streams haven't closed in grace period.")
+			}
+
+			// Doesn't block if no connections, but will otherwise wait
+			// until the timeout deadline.
+			srv.Shutdown(ctx)
+
+			// Wait for bridge to process its jobs.
+			bridge.Shutdown(ctx)
+
+			// Optionally, you could run srv.Shutdown in a goroutine and block on
+			// <-ctx.Done() if your application should wait for other services
+			// to finalize based on context cancellation.
+			log.Println("Shutting down")
+			return nil
+		case err := <-errc:
+			return err
+		}
+	}
+}
+
+// prune periodically deletes events older than given retention and
+// ephemeral events older than given ephemeral retention. Zero retention
+// disables pruning of corresponding events. It runs until given
+// context is done.
+func prune(
+	ctx context.Context,
+	log *logrus.Logger,
+	pruner storage.Pruner,
+	retention, ephemeralRetention time.Duration,
+) {
+	// Pruning runs at least every hour, but more often for short
+	// retention periods.
+	interval := time.Hour
+	for _, r := range []time.Duration{retention, ephemeralRetention} {
+		if r > 0 && r < interval {
+			interval = r
+		}
 	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		now := time.Now()
+		if retention > 0 {
+			n, err := pruner.PruneBefore(ctx, now.Add(-retention))
+			if err != nil {
+				log.WithField("error", err.Error()).Error("Failed to prune events.")
+			} else if n > 0 {
+				log.WithField("events", n).Info("Pruned old events.")
+			}
+		}
+		if ephemeralRetention > 0 {
+			n, err := pruner.PruneEphemeralBefore(ctx, now.Add(-ephemeralRetention))
+			if err != nil {
+				log.WithField("error", err.Error()).Error("Failed to prune ephemeral events.")
+			} else if n > 0 {
+				log.WithField("events", n).Info("Pruned old ephemeral events.")
+			}
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// presenceSweepInterval returns interval of presence sweeps. Sweeps run
+// ten times per the shortest of given positive periods.
+func presenceSweepInterval(periods ...time.Duration) time.Duration {
+	shortest := time.Duration(0)
+	for _, p := range periods {
+		if p > 0 && (shortest == 0 || p < shortest) {
+			shortest = p
+		}
+	}
+
+	return shortest / 10
+}
+
+// Build information injected at build time with:
+//
+//	go build -ldflags "-X main.version=... -X main.commit=... -X main.date=..."
+var (
+	version string
+	commit  string
+	date    string
+)
+
+// buildInfo returns build information of szmaterlok binary.
+func buildInfo() service.BuildInfo {
+	return service.BuildInfo{
+		Version: version,
+		Commit:  commit,
+		Date:    date,
+	}.WithDefaults()
 }
 
 func main() {
-	if err := run(context.Background()); err != nil {
+	if err := run(context.Background(), os.Args[1:]); err != nil {
 		log.Fatal("szmaterlok:", err.Error())
 	}
 }
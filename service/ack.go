@@ -0,0 +1,171 @@
+package service
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/sirupsen/logrus"
+)
+
+// MessageAcks holds sequence numbers of the last messages acknowledged
+// by sessions in every chat channel. Clients acknowledge messages after
+// processing them, so acknowledgements are precise resume points and
+// delivery receipts. When number of acknowledgements exceeds capacity,
+// the least recently updated ones are evicted first.
+type MessageAcks struct {
+	capacity int
+	mtx      *sync.Mutex
+	acks     map[messageAckKey]*list.Element
+
+	// lru holds acknowledgements ordered from the most recent one.
+	lru *list.List
+}
+
+// messageAckKey identifies acknowledgements of single session in single
+// chat channel. Sequence numbers are shared by all of the channels, so
+// acknowledgement in one channel says nothing about the others.
+type messageAckKey struct {
+	sessionID string
+	channel   string
+}
+
+type messageAck struct {
+	key      messageAckKey
+	sequence uint64
+}
+
+// NewMessageAcks returns empty acknowledgements store, which holds
+// at most given number of acknowledgements.
+func NewMessageAcks(capacity int) *MessageAcks {
+	return &MessageAcks{
+		capacity: capacity,
+		mtx:      &sync.Mutex{},
+		acks:     make(map[messageAckKey]*list.Element),
+		lru:      list.New(),
+	}
+}
+
+// Ack records that session with given ID has acknowledged message with
+// given sequence number in given chat channel. Acknowledgements never
+// move back, so older messages acknowledged late don't change last
+// acknowledged sequence number. It returns last acknowledged sequence
+// number of the session in the channel.
+func (a *MessageAcks) Ack(ctx context.Context, sessionID, channel string, sequence uint64) uint64 {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	key := messageAckKey{sessionID: sessionID, channel: ChatChannelOrDefault(channel)}
+	if elem, ok := a.acks[key]; ok {
+		ack := elem.Value.(*messageAck)
+		if sequence > ack.sequence {
+			ack.sequence = sequence
+		}
+		a.lru.MoveToFront(elem)
+		return ack.sequence
+	}
+
+	a.acks[key] = a.lru.PushFront(&messageAck{
+		key:      key,
+		sequence: sequence,
+	})
+	if a.lru.Len() > a.capacity {
+		evicted := a.lru.Remove(a.lru.Back()).(*messageAck)
+		delete(a.acks, evicted.key)
+	}
+
+	return sequence
+}
+
+// LastAcked returns sequence number of the last message acknowledged
+// by session with given ID in given chat channel. It returns false,
+// when session hasn't acknowledged any message of the channel or when
+// acks are nil.
+func (a *MessageAcks) LastAcked(ctx context.Context, sessionID, channel string) (uint64, bool) {
+	if a == nil {
+		return 0, false
+	}
+
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	elem, ok := a.acks[messageAckKey{sessionID: sessionID, channel: ChatChannelOrDefault(channel)}]
+	if !ok {
+		return 0, false
+	}
+
+	return elem.Value.(*messageAck).sequence, true
+}
+
+// HandlerAckDependencies holds behavioral dependencies for
+// http handler for acknowledging messages.
+type HandlerAckDependencies struct {
+	Logger   *logrus.Logger
+	Acks     *MessageAcks
+	Messages MessageStore
+}
+
+// HandlerAck records that current session has processed message with
+// given ID in chat channel of the message. Direct messages can be
+// acknowledged only by their sender and recipient.
+func HandlerAck(deps HandlerAckDependencies) http.HandlerFunc {
+	type request struct {
+		MessageID string `json:"messageID"`
+	}
+	type response struct {
+		MessageID string `json:"messageID"`
+		Sequence  uint64 `json:"sequence"`
+		LastAcked uint64 `json:"lastAcked"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		state := SessionContextState(ctx)
+		if state == nil {
+			writeError(w, r, http.StatusForbidden, ErrorReasonUnauthenticated, "Acknowledging messages requires authentication.")
+			return
+		}
+
+		req := &request{}
+
+		defer r.Body.Close()
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			writeError(w, r, http.StatusBadRequest, ErrorReasonInvalidBody, "Failed to parse body.")
+			return
+		}
+
+		msg, err := deps.Messages.Message(ctx, req.MessageID)
+		if errors.Is(err, ErrNoSuchMessage) {
+			writeError(w, r, http.StatusNotFound, ErrorReasonMessageNotFound, "There is no such message.")
+			return
+		}
+		if err != nil {
+			deps.Logger.WithFields(logrus.Fields{
+				"reqID":     middleware.GetReqID(ctx),
+				"messageID": req.MessageID,
+				"error":     err.Error(),
+			}).Error("Failed to find acknowledged message.")
+			writeError(w, r, http.StatusInternalServerError, ErrorReasonInternal, "Failed to find message. Please try again later.")
+			return
+		}
+
+		// Direct messages of other users are reported as missing, so
+		// their IDs can't be probed.
+		if msg.To != nil && msg.To.ID != state.ID && msg.From.ID != state.ID {
+			writeError(w, r, http.StatusNotFound, ErrorReasonMessageNotFound, "There is no such message.")
+			return
+		}
+
+		jsonResponse(w, http.StatusOK, responseWrapper{
+			Data: response{
+				MessageID: msg.ID,
+				Sequence:  msg.Sequence,
+				LastAcked: deps.Acks.Ack(ctx, state.ID, msg.Channel, msg.Sequence),
+			},
+		})
+	}
+}
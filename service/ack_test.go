@@ -0,0 +1,280 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+
+	"github.com/fenole/szmaterlok/service/sse"
+)
+
+func TestMessageAcks(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+
+	acks := NewMessageAcks(2)
+
+	_, ok := acks.LastAcked(ctx, "a", ChatChannelDefault)
+	is.True(!ok)
+
+	is.Equal(acks.Ack(ctx, "a", ChatChannelDefault, 3), uint64(3))
+	is.Equal(acks.Ack(ctx, "a", ChatChannelDefault, 5), uint64(5))
+
+	// Older messages acknowledged late don't move acknowledgement back.
+	is.Equal(acks.Ack(ctx, "a", ChatChannelDefault, 4), uint64(5))
+
+	seq, ok := acks.LastAcked(ctx, "a", ChatChannelDefault)
+	is.True(ok)
+	is.Equal(seq, uint64(5))
+
+	// Session "b" acknowledged least recently, so it's evicted first.
+	acks.Ack(ctx, "b", ChatChannelDefault, 1)
+	acks.Ack(ctx, "a", ChatChannelDefault, 6)
+	acks.Ack(ctx, "c", ChatChannelDefault, 2)
+
+	_, ok = acks.LastAcked(ctx, "b", ChatChannelDefault)
+	is.True(!ok)
+	seq, ok = acks.LastAcked(ctx, "a", ChatChannelDefault)
+	is.True(ok)
+	is.Equal(seq, uint64(6))
+	seq, ok = acks.LastAcked(ctx, "c", ChatChannelDefault)
+	is.True(ok)
+	is.Equal(seq, uint64(2))
+
+	// Acknowledgements of different chat channels are independent and
+	// default channel can be named explicitly.
+	channels := NewMessageAcks(2)
+	channels.Ack(ctx, "a", ChatChannelDefault, 6)
+	is.Equal(channels.Ack(ctx, "a", "random", 1), uint64(1))
+	seq, ok = channels.LastAcked(ctx, "a", "")
+	is.True(ok)
+	is.Equal(seq, uint64(6))
+	seq, ok = channels.LastAcked(ctx, "a", "random")
+	is.True(ok)
+	is.Equal(seq, uint64(1))
+
+	// Nil acks hold no acknowledgements.
+	var none *MessageAcks
+	_, ok = none.LastAcked(ctx, "a", ChatChannelDefault)
+	is.True(!ok)
+}
+
+func TestHandlerAck(t *testing.T) {
+	type testArgs struct {
+		name      string
+		userID    string
+		body      string
+		code      int
+		lastAcked uint64
+	}
+
+	scenario := func(tt testArgs) (string, func(*testing.T)) {
+		return tt.name, func(t *testing.T) {
+			is := is.New(t)
+			ctx := context.Background()
+
+			messages := NewStateMessages(10)
+			is.NoErr(messages.PushMessage(ctx, StateMessage{
+				ID:       "msg",
+				From:     ChatUser{ID: "author", Nickname: "author"},
+				Channel:  ChatChannelDefault,
+				Sequence: 7,
+			}))
+			is.NoErr(messages.PushMessage(ctx, StateMessage{
+				ID:       "dm",
+				From:     ChatUser{ID: "author", Nickname: "author"},
+				To:       &ChatUser{ID: "recipient", Nickname: "recipient"},
+				Channel:  ChatChannelDefault,
+				Sequence: 5,
+			}))
+
+			acks := NewMessageAcks(10)
+			acks.Ack(ctx, "recipient", ChatChannelDefault, 6)
+
+			handler := HandlerAck(HandlerAckDependencies{
+				Logger:   testLogger(),
+				Acks:     acks,
+				Messages: messages,
+			})
+
+			r := requestWithSession(ctx, httptest.NewRequest(
+				http.MethodPost, "/ack", bytes.NewBufferString(tt.body),
+			), &SessionState{
+				ID:       tt.userID,
+				Nickname: tt.userID,
+			})
+			w := httptest.NewRecorder()
+
+			handler(w, r)
+			is.Equal(w.Code, tt.code)
+
+			lastAcked, ok := acks.LastAcked(ctx, tt.userID, ChatChannelDefault)
+			is.Equal(lastAcked, tt.lastAcked)
+			is.Equal(ok, tt.lastAcked > 0)
+
+			if tt.code != http.StatusOK {
+				return
+			}
+
+			res := struct {
+				Data struct {
+					Sequence  uint64 `json:"sequence"`
+					LastAcked uint64 `json:"lastAcked"`
+				} `json:"data"`
+			}{}
+			is.NoErr(json.NewDecoder(w.Body).Decode(&res))
+			is.Equal(res.Data.LastAcked, tt.lastAcked)
+		}
+	}
+
+	t.Run(scenario(testArgs{
+		name:      "message",
+		userID:    "reader",
+		body:      `{"messageID": "msg"}`,
+		code:      http.StatusOK,
+		lastAcked: 7,
+	}))
+	t.Run(scenario(testArgs{
+		name:      "older message",
+		userID:    "recipient",
+		body:      `{"messageID": "dm"}`,
+		code:      http.StatusOK,
+		lastAcked: 6,
+	}))
+	t.Run(scenario(testArgs{
+		name:   "direct message of other users",
+		userID: "reader",
+		body:   `{"messageID": "dm"}`,
+		code:   http.StatusNotFound,
+	}))
+	t.Run(scenario(testArgs{
+		name:   "no such message",
+		userID: "reader",
+		body:   `{"messageID": "missing"}`,
+		code:   http.StatusNotFound,
+	}))
+	t.Run(scenario(testArgs{
+		name:   "invalid body",
+		userID: "reader",
+		body:   `{"messageID":`,
+		code:   http.StatusBadRequest,
+	}))
+}
+
+func TestMessageNotifierWithBufferAcks(t *testing.T) {
+	type testArgs struct {
+		name string
+
+		// acked is sequence number acknowledged by session in
+		// ackChannel, zero means that session hasn't acknowledged
+		// any message. Messages are sent to default channel.
+		acked       uint64
+		ackChannel  string
+		lastEventID string
+
+		want      []uint64
+		lastAcked uint64
+	}
+
+	scenario := func(tt testArgs) (string, func(*testing.T)) {
+		return tt.name, func(t *testing.T) {
+			is := is.New(t)
+			ctx := context.Background()
+			log := testLogger()
+
+			buffer := NewLastMessagesBuffer(5, log)
+			for seq := uint64(1); seq <= 5; seq++ {
+				id := strconv.FormatUint(seq, 10)
+				data, err := json.Marshal(EventSentMessage{ID: "msg-" + id})
+				is.NoErr(err)
+				buffer.EventHook(ctx, BridgeEvent{
+					Name:     BridgeMessageSent,
+					ID:       "msg-" + id,
+					Sequence: seq,
+					Data:     data,
+				})
+			}
+
+			acks := NewMessageAcks(10)
+			if tt.acked > 0 {
+				acks.Ack(ctx, "1", tt.ackChannel, tt.acked)
+			}
+
+			n := &MessageNotifierWithBuffer{
+				Notifier: NewBridgeMessageHandler(log),
+				Buffer:   buffer,
+				Logger:   log,
+				Acks:     acks,
+			}
+
+			evts := make(chan sse.Event, 8)
+			unsubscribe := n.Subscribe(ContextWithLastEventID(ctx, tt.lastEventID), MessageSubscribeRequest{
+				ID:        "1",
+				RequestID: "req",
+				Channel:   evts,
+			})
+			defer unsubscribe()
+
+			got := []uint64{}
+			for {
+				var evt sse.Event
+				select {
+				case evt = <-evts:
+				case <-time.After(time.Second):
+					t.Fatal("event has not been delivered")
+				}
+				if evt.Type == StreamReady {
+					ready := EventStreamReady{}
+					is.NoErr(json.Unmarshal(evt.Data, &ready))
+					is.Equal(ready.LastAcked, tt.lastAcked)
+					break
+				}
+
+				seq, err := strconv.ParseUint(evt.ID, 10, 64)
+				is.NoErr(err)
+				got = append(got, seq)
+			}
+			is.Equal(got, tt.want)
+		}
+	}
+
+	t.Run(scenario(testArgs{
+		name:        "acknowledged before last event",
+		acked:       2,
+		lastEventID: "4",
+		want:        []uint64{5},
+		lastAcked:   2,
+	}))
+	t.Run(scenario(testArgs{
+		name:        "acknowledged after last event",
+		acked:       4,
+		lastEventID: "2",
+		want:        []uint64{5},
+		lastAcked:   4,
+	}))
+	t.Run(scenario(testArgs{
+		name:        "acknowledged in other channel",
+		acked:       4,
+		ackChannel:  "random",
+		lastEventID: "2",
+		want:        []uint64{3, 4, 5},
+	}))
+	t.Run(scenario(testArgs{
+		name:        "nothing acknowledged",
+		lastEventID: "3",
+		want:        []uint64{4, 5},
+	}))
+	t.Run(scenario(testArgs{
+		name:      "first connection",
+		acked:     3,
+		want:      []uint64{1, 2, 3, 4, 5},
+		lastAcked: 3,
+	}))
+}
@@ -0,0 +1,361 @@
+package service
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/sirupsen/logrus"
+)
+
+// ContentTypeNDJSON is content type of newline-delimited JSON.
+const ContentTypeNDJSON = "application/x-ndjson"
+
+// importBatchSize is number of events stored by single StoreEvents
+// call during import.
+const importBatchSize = 500
+
+// AdminRequired guards given handler from being accessed without
+// admin token passed as bearer token in Authorization header.
+func AdminRequired(token string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || got == "" {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				jsonResponse(w, http.StatusUnauthorized, responseWrapper{
+					Error: errorResponse{
+						Code:    http.StatusUnauthorized,
+						Reason:  ErrorReasonInvalidCredentials,
+						Message: "Resource requires admin token.",
+					},
+				})
+				return
+			}
+
+			if subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+				jsonResponse(w, http.StatusForbidden, responseWrapper{
+					Error: errorResponse{
+						Code:    http.StatusForbidden,
+						Reason:  ErrorReasonInvalidCredentials,
+						Message: "Invalid admin token.",
+					},
+				})
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// HandlerExportDependencies holds arguments for HandlerExport.
+type HandlerExportDependencies struct {
+	Logger  *logrus.Logger
+	Archive StateArchive
+}
+
+// HandlerExport streams all events from state archive as
+// newline-delimited JSON. Events are written as soon as they're
+// read from archive, so export of large archive isn't buffered.
+func HandlerExport(deps HandlerExportDependencies) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithCancel(r.Context())
+		defer cancel()
+
+		log := deps.Logger.WithFields(logrus.Fields{
+			"reqID": middleware.GetReqID(ctx),
+		})
+
+		// Export of large archive can take longer than server write
+		// timeout. Errors are ignored on purpose: writers without
+		// deadlines support have nothing to reset.
+		rc := http.NewResponseController(w)
+		_ = rc.SetWriteDeadline(time.Time{})
+
+		errc := make(chan error, 1)
+		evtc := make(chan BridgeEvent)
+		go func() {
+			defer close(evtc)
+			errc <- deps.Archive.Events(ctx, evtc)
+		}()
+
+		w.Header().Set("Content-Type", ContentTypeNDJSON)
+		w.WriteHeader(http.StatusOK)
+
+		exported := 0
+		enc := json.NewEncoder(w)
+		for evt := range evtc {
+			if err := enc.Encode(evt); err != nil {
+				log.WithField("error", err.Error()).Error("Failed to write exported event.")
+
+				// Stop reading from archive and drain events, which
+				// have been already read.
+				cancel()
+				for range evtc {
+				}
+				break
+			}
+			exported++
+		}
+
+		if err := <-errc; err != nil && !errors.Is(err, context.Canceled) {
+			// Response status has been already sent, so the only way
+			// to signal failure is to abort the response.
+			log.WithField("error", err.Error()).Error("Failed to read events from archive.")
+			panic(http.ErrAbortHandler)
+		}
+
+		log.WithField("events", exported).Info("Archive has been exported.")
+	}
+}
+
+// EventImporter stores imported events.
+type EventImporter interface {
+	// StoreEvents stores given events within single transaction.
+	StoreEvents(context.Context, []BridgeEvent) error
+}
+
+// HandlerImportDependencies holds arguments for HandlerImport.
+type HandlerImportDependencies struct {
+	Logger   *logrus.Logger
+	Importer EventImporter
+
+	// MaxSize is maximal size of request body in bytes.
+	MaxSize int64
+}
+
+// HandlerImport reads newline-delimited JSON events, produced by
+// HandlerExport, and stores them with event importer in batches.
+// Imported events are not applied to the state of running
+// application and their sequence numbers are dropped.
+func HandlerImport(deps HandlerImportDependencies) http.HandlerFunc {
+	type response struct {
+		Imported int `json:"imported"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		log := deps.Logger.WithFields(logrus.Fields{
+			"reqID": middleware.GetReqID(ctx),
+		})
+
+		imported := 0
+		batch := make([]BridgeEvent, 0, importBatchSize)
+		flush := func() error {
+			if len(batch) == 0 {
+				return nil
+			}
+			if err := deps.Importer.StoreEvents(ctx, batch); err != nil {
+				return err
+			}
+			imported += len(batch)
+			batch = batch[:0]
+			return nil
+		}
+
+		badRequest := func(msg string) {
+			jsonResponse(w, http.StatusBadRequest, responseWrapper{
+				Data: response{Imported: imported},
+				Error: errorResponse{
+					Code:    http.StatusBadRequest,
+					Reason:  ErrorReasonInvalidBody,
+					Message: msg,
+				},
+			})
+		}
+
+		storeFailed := func(err error) {
+			log.WithField("error", err.Error()).Error("Failed to store imported events.")
+			jsonResponse(w, http.StatusInternalServerError, responseWrapper{
+				Data: response{Imported: imported},
+				Error: errorResponse{
+					Code:    http.StatusInternalServerError,
+					Reason:  ErrorReasonInternal,
+					Message: "Failed to store imported events.",
+				},
+			})
+		}
+
+		r.Body = http.MaxBytesReader(w, r.Body, deps.MaxSize)
+		defer r.Body.Close()
+
+		dec := json.NewDecoder(r.Body)
+		for {
+			var evt BridgeEvent
+			err := dec.Decode(&evt)
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			maxBytesErr := &http.MaxBytesError{}
+			if errors.As(err, &maxBytesErr) {
+				jsonResponse(w, http.StatusRequestEntityTooLarge, responseWrapper{
+					Data: response{Imported: imported},
+					Error: errorResponse{
+						Code:    http.StatusRequestEntityTooLarge,
+						Reason:  ErrorReasonBodyTooLarge,
+						Message: fmt.Sprintf("Request body cannot be larger than %d bytes.", deps.MaxSize),
+					},
+				})
+				return
+			}
+			if err != nil {
+				log.WithField("error", err.Error()).Warn("Failed to decode imported event.")
+				badRequest("Invalid event in request body.")
+				return
+			}
+			if evt.ID == "" || evt.Name == "" {
+				badRequest("Imported event requires id and type.")
+				return
+			}
+
+			// Sequence numbers have been assigned by other run of
+			// event bridge, so they could collide with the ones
+			// assigned by the running one.
+			evt.Sequence = 0
+
+			batch = append(batch, evt)
+			if len(batch) < importBatchSize {
+				continue
+			}
+			if err := flush(); err != nil {
+				storeFailed(err)
+				return
+			}
+		}
+
+		if err := flush(); err != nil {
+			storeFailed(err)
+			return
+		}
+
+		log.WithField("events", imported).Info("Archive has been imported.")
+		jsonResponse(w, http.StatusOK, responseWrapper{
+			Data: response{Imported: imported},
+		})
+	}
+}
+
+// UserDisconnecter forcibly disconnects users from event stream.
+type UserDisconnecter interface {
+	// Disconnect closes every event stream of user with given ID and
+	// returns number of closed streams.
+	Disconnect(userID string) int
+
+	// DisconnectNickname closes every event stream of users with given
+	// nickname, compared case-insensitively, and returns number of
+	// closed streams.
+	DisconnectNickname(nickname string) int
+}
+
+// HistoryClearer clears history of recent messages, which is sent to
+// joining users.
+type HistoryClearer interface {
+	// Clear removes all of recent messages.
+	Clear(ctx context.Context)
+}
+
+// HandlerHistoryClearDependencies holds arguments for HandlerHistoryClear.
+type HandlerHistoryClearDependencies struct {
+	Logger  *logrus.Logger
+	History HistoryClearer
+	Audit   *ModerationAudit
+}
+
+// HandlerHistoryClear clears history of recent messages, so they're
+// no longer sent to joining users. Messages are kept in event storage.
+func HandlerHistoryClear(deps HandlerHistoryClearDependencies) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		log := deps.Logger.WithFields(logrus.Fields{
+			"reqID": middleware.GetReqID(ctx),
+		})
+
+		deps.History.Clear(ctx)
+		go deps.Audit.Record(ctx, ModerationHistoryClear, "", "")
+
+		if state := SessionContextState(ctx); state != nil {
+			log = log.WithField("adminID", state.ID)
+		}
+		log.Info("Message history has been cleared.")
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// HandlerBridgeStats sends current statistics of given event bridge.
+func HandlerBridgeStats(b *Bridge) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		jsonResponse(w, http.StatusOK, responseWrapper{
+			Data: b.Stats(),
+		})
+	}
+}
+
+// HandlerKickDependencies holds arguments for HandlerKick.
+type HandlerKickDependencies struct {
+	Logger       *logrus.Logger
+	Disconnecter UserDisconnecter
+	Audit        *ModerationAudit
+}
+
+// HandlerKick disconnects user with given ID from every of their event
+// streams. Other users are notified that kicked user has left the chat.
+func HandlerKick(deps HandlerKickDependencies) http.HandlerFunc {
+	type request struct {
+		UserID string `json:"userID"`
+		Reason string `json:"reason"`
+	}
+	type response struct {
+		Disconnected int `json:"disconnected"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		log := deps.Logger.WithFields(logrus.Fields{
+			"reqID": middleware.GetReqID(ctx),
+		})
+
+		req := &request{}
+
+		defer r.Body.Close()
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil || req.UserID == "" {
+			jsonResponse(w, http.StatusBadRequest, responseWrapper{
+				Error: errorResponse{
+					Code:    http.StatusBadRequest,
+					Reason:  ErrorReasonInvalidBody,
+					Message: "Request requires user ID.",
+				},
+			})
+			return
+		}
+
+		n := deps.Disconnecter.Disconnect(req.UserID)
+		if n == 0 {
+			jsonResponse(w, http.StatusNotFound, responseWrapper{
+				Error: errorResponse{
+					Code:    http.StatusNotFound,
+					Reason:  ErrorReasonUserOffline,
+					Message: "User is not connected.",
+				},
+			})
+			return
+		}
+
+		go deps.Audit.Record(ctx, ModerationKick, req.UserID, strings.TrimSpace(req.Reason))
+
+		log.WithFields(logrus.Fields{
+			"userID":  req.UserID,
+			"streams": n,
+		}).Info("User has been kicked.")
+		jsonResponse(w, http.StatusOK, responseWrapper{
+			Data: response{Disconnected: n},
+		})
+	}
+}
@@ -0,0 +1,402 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+)
+
+// eventImporterMock stores imported events in memory. It fails
+// when given error is set.
+type eventImporterMock struct {
+	events []BridgeEvent
+	calls  int
+	err    error
+}
+
+func (m *eventImporterMock) StoreEvents(ctx context.Context, evts []BridgeEvent) error {
+	m.calls++
+	if m.err != nil {
+		return m.err
+	}
+	m.events = append(m.events, evts...)
+	return nil
+}
+
+func TestAdminRequired(t *testing.T) {
+	type testArgs struct {
+		name          string
+		authorization string
+		code          int
+	}
+
+	h := AdminRequired("secret")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	scenario := func(args testArgs) (string, func(*testing.T)) {
+		return args.name, func(t *testing.T) {
+			is := is.New(t)
+
+			r := httptest.NewRequest(http.MethodGet, "/admin/export", nil)
+			if args.authorization != "" {
+				r.Header.Set("Authorization", args.authorization)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+
+			is.Equal(w.Code, args.code)
+			if args.code == http.StatusUnauthorized {
+				is.Equal(w.Header().Get("WWW-Authenticate"), "Bearer")
+			}
+		}
+	}
+
+	t.Run(scenario(testArgs{
+		name: "missing token",
+		code: http.StatusUnauthorized,
+	}))
+	t.Run(scenario(testArgs{
+		name:          "other scheme",
+		authorization: "Basic secret",
+		code:          http.StatusUnauthorized,
+	}))
+	t.Run(scenario(testArgs{
+		name:          "wrong token",
+		authorization: "Bearer public",
+		code:          http.StatusForbidden,
+	}))
+	t.Run(scenario(testArgs{
+		name:          "valid token",
+		authorization: "Bearer secret",
+		code:          http.StatusOK,
+	}))
+}
+
+func TestAdminExportImport(t *testing.T) {
+	is := is.New(t)
+
+	archive := stateArchiveMock{}
+	for i := 0; i < importBatchSize+10; i++ {
+		archive = append(archive, BridgeEvent{
+			Name:      BridgeMessageSent,
+			ID:        fmt.Sprintf("event-%d", i),
+			CreatedAt: int64(i),
+			Headers:   BridgeHeaders{"Content-Type": "application/json"},
+			Data:      []byte(fmt.Sprintf(`{"content":"message %d"}`, i)),
+		})
+	}
+
+	export := HandlerExport(HandlerExportDependencies{
+		Logger:  testLogger(),
+		Archive: archive,
+	})
+	w := httptest.NewRecorder()
+	export(w, httptest.NewRequest(http.MethodGet, "/admin/export", nil))
+
+	is.Equal(w.Code, http.StatusOK)
+	is.Equal(w.Header().Get("Content-Type"), ContentTypeNDJSON)
+	is.Equal(strings.Count(w.Body.String(), "\n"), len(archive))
+
+	importer := &eventImporterMock{}
+	imp := HandlerImport(HandlerImportDependencies{
+		Logger:   testLogger(),
+		Importer: importer,
+		MaxSize:  int64(w.Body.Len()),
+	})
+	r := httptest.NewRequest(http.MethodPost, "/admin/import", bytes.NewReader(w.Body.Bytes()))
+	w = httptest.NewRecorder()
+	imp(w, r)
+
+	is.Equal(w.Code, http.StatusOK)
+
+	var res struct {
+		Data struct {
+			Imported int `json:"imported"`
+		} `json:"data"`
+	}
+	is.NoErr(json.NewDecoder(w.Body).Decode(&res))
+	is.Equal(res.Data.Imported, len(archive))
+
+	// Events are stored in two batches.
+	is.Equal(importer.calls, 2)
+	is.Equal(len(importer.events), len(archive))
+	for i := range archive {
+		is.Equal(importer.events[i].ID, archive[i].ID)
+		is.Equal(importer.events[i].Name, archive[i].Name)
+		is.Equal(importer.events[i].CreatedAt, archive[i].CreatedAt)
+		is.Equal(importer.events[i].Headers, archive[i].Headers)
+		is.Equal(importer.events[i].Data, archive[i].Data)
+	}
+}
+
+// failingArchiveMock is state archive, which fails after sending
+// its events.
+type failingArchiveMock []BridgeEvent
+
+func (a failingArchiveMock) Events(ctx context.Context, c chan<- BridgeEvent) error {
+	for _, evt := range a {
+		c <- evt
+	}
+	return errors.New("disk failure")
+}
+
+func TestHandlerExportFailure(t *testing.T) {
+	is := is.New(t)
+
+	export := HandlerExport(HandlerExportDependencies{
+		Logger:  testLogger(),
+		Archive: failingArchiveMock{{Name: BridgeMessageSent, ID: "1"}},
+	})
+
+	// Response is aborted, so client doesn't take truncated export
+	// for complete one.
+	defer func() {
+		is.Equal(recover(), http.ErrAbortHandler)
+	}()
+	export(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/admin/export", nil))
+	t.Fatal("export has not been aborted")
+}
+
+func TestHandlerImport(t *testing.T) {
+	type testArgs struct {
+		name     string
+		body     string
+		maxSize  int64
+		err      error
+		code     int
+		imported int
+	}
+
+	scenario := func(args testArgs) (string, func(*testing.T)) {
+		return args.name, func(t *testing.T) {
+			is := is.New(t)
+
+			maxSize := args.maxSize
+			if maxSize == 0 {
+				maxSize = 1 << 20
+			}
+
+			importer := &eventImporterMock{err: args.err}
+			h := HandlerImport(HandlerImportDependencies{
+				Logger:   testLogger(),
+				Importer: importer,
+				MaxSize:  maxSize,
+			})
+
+			r := httptest.NewRequest(http.MethodPost, "/admin/import", strings.NewReader(args.body))
+			w := httptest.NewRecorder()
+			h(w, r)
+
+			is.Equal(w.Code, args.code)
+			is.Equal(len(importer.events), args.imported)
+
+			// Sequence numbers of other run of event bridge are
+			// never imported.
+			for _, evt := range importer.events {
+				is.Equal(evt.Sequence, uint64(0))
+			}
+		}
+	}
+
+	t.Run(scenario(testArgs{
+		name:     "empty body",
+		code:     http.StatusOK,
+		imported: 0,
+	}))
+	t.Run(scenario(testArgs{
+		name:     "invalid json",
+		body:     "{\"type\":\"message-sent\",\"id\":\"1\"}\n{not json",
+		code:     http.StatusBadRequest,
+		imported: 0,
+	}))
+	t.Run(scenario(testArgs{
+		name:     "missing id",
+		body:     "{\"type\":\"message-sent\"}\n",
+		code:     http.StatusBadRequest,
+		imported: 0,
+	}))
+	t.Run(scenario(testArgs{
+		name: "storage failure",
+		body: "{\"type\":\"message-sent\",\"id\":\"1\"}\n",
+		err:  errors.New("disk full"),
+		code: http.StatusInternalServerError,
+	}))
+	t.Run(scenario(testArgs{
+		name:     "sequence numbers",
+		body:     "{\"type\":\"message-sent\",\"id\":\"1\",\"sequence\":42}\n",
+		code:     http.StatusOK,
+		imported: 1,
+	}))
+	t.Run(scenario(testArgs{
+		name:     "body too large",
+		body:     "{\"type\":\"message-sent\",\"id\":\"1\"}\n{\"type\":\"message-sent\",\"id\":\"2\"}\n",
+		maxSize:  40,
+		code:     http.StatusRequestEntityTooLarge,
+		imported: 0,
+	}))
+}
+
+func TestHandlerKick(t *testing.T) {
+	is := is.New(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	log := testLogger()
+
+	storage := newBridgeStorageMock()
+	bridge := NewBridge(ctx, BridgeBuilder{
+		Logger:  log,
+		Storage: storage,
+	})
+	messageHandler := NewBridgeMessageHandler(log)
+
+	stream := HandlerStream(HandlerStreamDependencies{
+		MessageNotifier: &EventAnnouncer{
+			MessageNotifier: messageHandler,
+			UserJoinProducer: &BridgeEventProducer[EventUserJoin]{
+				EventBridge: bridge,
+				Type:        BridgeUserJoin,
+				Log:         log,
+				Clock:       testClock(),
+			},
+			UserLeftProducer: &BridgeEventProducer[EventUserLeft]{
+				EventBridge: bridge,
+				Type:        BridgeUserLeft,
+				Log:         log,
+				Clock:       testClock(),
+			},
+			Clock:       testClock(),
+			IDGenerator: testIDGenerator(),
+		},
+	})
+
+	connect := func(id string) <-chan struct{} {
+		done := make(chan struct{})
+		r := requestWithSession(ctx, httptest.NewRequest(http.MethodGet, "/stream", nil), &SessionState{
+			ID:       id,
+			Nickname: id,
+		})
+		go func() {
+			defer close(done)
+			stream(newStreamRecorder(), r)
+		}()
+		return done
+	}
+
+	spammer := connect("spammer")
+	other := connect("other")
+	waitFor(t, time.Second, func() bool {
+		return len(messageHandler.ActiveSubscribers()) == 2
+	})
+
+	kick := HandlerKick(HandlerKickDependencies{
+		Logger:       log,
+		Disconnecter: messageHandler,
+	})
+	w := httptest.NewRecorder()
+	kick(w, httptest.NewRequest(http.MethodPost, "/admin/kick", strings.NewReader(`{"userID":"spammer"}`)))
+	is.Equal(w.Code, http.StatusOK)
+
+	select {
+	case <-spammer:
+	case <-time.After(time.Second):
+		t.Fatal("stream of kicked user is still running")
+	}
+
+	select {
+	case <-other:
+		t.Fatal("stream of other user has been closed")
+	default:
+	}
+	is.Equal(len(messageHandler.ActiveSubscribers()), 1)
+
+	// Other users are notified that kicked user has left.
+	waitFor(t, time.Second, func() bool {
+		for _, evt := range storage.Events() {
+			if evt.Name == BridgeUserLeft {
+				return true
+			}
+		}
+		return false
+	})
+
+	left := EventUserLeft{}
+	for _, evt := range storage.Events() {
+		if evt.Name == BridgeUserLeft {
+			is.NoErr(json.Unmarshal(evt.Data, &left))
+		}
+	}
+	is.Equal(left.User.ID, "spammer")
+
+	// User, who is not connected anymore, can't be kicked.
+	w = httptest.NewRecorder()
+	kick(w, httptest.NewRequest(http.MethodPost, "/admin/kick", strings.NewReader(`{"userID":"spammer"}`)))
+	is.Equal(w.Code, http.StatusNotFound)
+
+	cancel()
+	<-other
+}
+
+func TestHandlerHistoryClear(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+	log := testLogger()
+
+	history := NewLastMessagesBuffer(3, log)
+	data, err := json.Marshal(EventSentMessage{ID: "1"})
+	is.NoErr(err)
+	history.EventHook(ctx, BridgeEvent{ID: "1", Data: data})
+
+	h := HandlerHistoryClear(HandlerHistoryClearDependencies{
+		Logger:  log,
+		History: history,
+	})
+
+	r := requestWithSession(ctx, httptest.NewRequest(http.MethodPost, "/admin/history/clear", nil), &SessionState{
+		ID:       "admin",
+		Nickname: "admin",
+		Admin:    true,
+	})
+	w := httptest.NewRecorder()
+	h(w, r)
+
+	is.Equal(w.Code, http.StatusNoContent)
+	is.Equal(len(history.LastMessages(ctx, "", "")), 0)
+}
+
+func TestHandlerBridgeStats(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+
+	bridge := NewBridge(ctx, BridgeBuilder{
+		Logger:    testLogger(),
+		Storage:   newBridgeStorageMock(),
+		QueueSize: 8,
+	})
+	for _, id := range []string{"1", "2"} {
+		bridge.SendEvent(BridgeEvent{Name: BridgeMessageSent, ID: id})
+	}
+	bridge.Shutdown(ctx)
+
+	w := httptest.NewRecorder()
+	HandlerBridgeStats(bridge)(w, httptest.NewRequest(http.MethodGet, "/admin/bridge", nil))
+	is.Equal(w.Code, http.StatusOK)
+
+	res := struct {
+		Data BridgeStats `json:"data"`
+	}{}
+	is.NoErr(json.NewDecoder(w.Body).Decode(&res))
+	is.Equal(res.Data, BridgeStats{
+		QueueCapacity: 8,
+		Processed:     2,
+	})
+}
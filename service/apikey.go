@@ -0,0 +1,60 @@
+package service
+
+import (
+	"context"
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// apiKeyBotIDPrefix prefixes IDs of bot identities, so they never
+// collide with IDs of browser sessions.
+const apiKeyBotIDPrefix = "bot:"
+
+// APIKeyBotID returns user ID of bot with given nickname.
+func APIKeyBotID(nickname string) string {
+	return apiKeyBotIDPrefix + nickname
+}
+
+// APIKeyAuth authenticates bots with API key passed as bearer token in
+// Authorization header. Keys map to nicknames of bots. Session state of
+// matched bot is saved within request context, just like SessionRequired
+// does for browser sessions, so handlers don't tell them apart.
+func APIKeyAuth(keys map[string]string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || got == "" {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				writeError(w, r, http.StatusUnauthorized, ErrorReasonInvalidCredentials, "Resource requires API key.")
+				return
+			}
+
+			nickname, ok := apiKeyNickname(keys, got)
+			if !ok {
+				writeError(w, r, http.StatusForbidden, ErrorReasonInvalidCredentials, "Invalid API key.")
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), sessionStateKey, &SessionState{
+				ID:       APIKeyBotID(nickname),
+				Nickname: nickname,
+			})
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// apiKeyNickname returns nickname mapped to given key. Every key is
+// compared, so timing doesn't reveal which of them shares prefix with
+// given one.
+func apiKeyNickname(keys map[string]string, got string) (string, bool) {
+	nickname := ""
+	for key, nck := range keys {
+		if subtle.ConstantTimeCompare([]byte(got), []byte(key)) == 1 {
+			nickname = nck
+		}
+	}
+
+	return nickname, nickname != ""
+}
@@ -0,0 +1,161 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fenole/szmaterlok/service/sse"
+	"github.com/matryer/is"
+)
+
+func TestAPIKeyAuth(t *testing.T) {
+	type testArgs struct {
+		name          string
+		authorization string
+		code          int
+		nickname      string
+	}
+
+	keys := map[string]string{
+		"first-key":  "relay",
+		"second-key": "weather",
+	}
+
+	scenario := func(tt testArgs) (string, func(*testing.T)) {
+		return tt.name, func(t *testing.T) {
+			is := is.New(t)
+
+			var state *SessionState
+			h := APIKeyAuth(keys)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				state = SessionContextState(r.Context())
+				w.WriteHeader(http.StatusOK)
+			}))
+
+			r := httptest.NewRequest(http.MethodPost, "/api/message", nil)
+			if tt.authorization != "" {
+				r.Header.Set("Authorization", tt.authorization)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+
+			is.Equal(w.Code, tt.code)
+			if tt.code == http.StatusUnauthorized {
+				is.Equal(w.Header().Get("WWW-Authenticate"), "Bearer")
+			}
+			if tt.code != http.StatusOK {
+				is.Equal(state, nil) // handler shouldn't be called
+				return
+			}
+
+			is.Equal(state.Nickname, tt.nickname)
+			is.Equal(state.ID, APIKeyBotID(tt.nickname))
+		}
+	}
+
+	t.Run(scenario(testArgs{
+		name: "missing key",
+		code: http.StatusUnauthorized,
+	}))
+	t.Run(scenario(testArgs{
+		name:          "other scheme",
+		authorization: "Basic first-key",
+		code:          http.StatusUnauthorized,
+	}))
+	t.Run(scenario(testArgs{
+		name:          "invalid key",
+		authorization: "Bearer third-key",
+		code:          http.StatusForbidden,
+	}))
+	t.Run(scenario(testArgs{
+		name:          "valid key",
+		authorization: "Bearer first-key",
+		code:          http.StatusOK,
+		nickname:      "relay",
+	}))
+	t.Run(scenario(testArgs{
+		name:          "another valid key",
+		authorization: "Bearer second-key",
+		code:          http.StatusOK,
+		nickname:      "weather",
+	}))
+}
+
+func TestAPIKeyAuthStream(t *testing.T) {
+	is := is.New(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	log := testLogger()
+
+	messageHandler := NewBridgeMessageHandler(log)
+	router := NewBridgeEventRouter()
+	router.Hook(BridgeMessageSent, messageHandler)
+
+	bridge := NewBridge(ctx, BridgeBuilder{
+		Handler: router,
+		Logger:  log,
+		Storage: newBridgeStorageMock(),
+	})
+	defer bridge.Shutdown(context.Background())
+
+	// Browser user listens to the chat.
+	r := requestWithSession(ctx, httptest.NewRequest(http.MethodGet, "/stream", nil), &SessionState{
+		ID:       "id",
+		Nickname: "nickname",
+	})
+	w := newStreamRecorder()
+	stream := HandlerStream(HandlerStreamDependencies{
+		MessageNotifier:   messageHandler,
+		HeartbeatInterval: time.Millisecond * 5,
+	})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		stream(w, r)
+	}()
+
+	// Heartbeats are sent after subscription.
+	waitFor(t, time.Second, func() bool {
+		return strings.Contains(w.String(), heartbeatComment)
+	})
+
+	send := APIKeyAuth(map[string]string{"key": "relay"})(HandlerSendMessage(HandlerSendMessageDependencies{
+		MaxMessageSize: NewMessageSizeLimit(255),
+		Sender: &BridgeEventProducer[EventSentMessage]{
+			EventBridge: bridge,
+			Type:        BridgeMessageSent,
+			Log:         log,
+			Clock:       testClock(),
+		},
+		IDGenerator: testIDGenerator(),
+		Clock:       testClock(),
+	}))
+
+	req := httptest.NewRequest(http.MethodPost, "/api/message", strings.NewReader(`{"content":"beep"}`))
+	req.Header.Set("Authorization", "Bearer key")
+	res := httptest.NewRecorder()
+	send.ServeHTTP(res, req)
+	is.Equal(res.Code, http.StatusAccepted)
+
+	waitFor(t, time.Second, func() bool {
+		return strings.Contains(w.String(), "beep")
+	})
+
+	cancel()
+	<-done
+
+	s := sse.NewScanner(strings.NewReader(w.String()))
+	is.True(s.Scan()) // message should be on the stream
+	is.Equal(s.Event().Type, MessageSent)
+
+	msg := EventSentMessage{}
+	is.NoErr(json.Unmarshal(s.Event().Data, &msg))
+	is.Equal(msg.Content, "beep")
+	is.Equal(msg.From.Nickname, "relay")
+	is.Equal(msg.From.ID, APIKeyBotID("relay"))
+}
@@ -0,0 +1,149 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/sirupsen/logrus"
+)
+
+// ModerationAction is kind of action taken by moderator.
+type ModerationAction string
+
+// Moderation actions recorded in audit log.
+const (
+	ModerationKick         ModerationAction = "kick"
+	ModerationBan          ModerationAction = "ban"
+	ModerationUnban        ModerationAction = "unban"
+	ModerationMute         ModerationAction = "mute"
+	ModerationUnmute       ModerationAction = "unmute"
+	ModerationHistoryClear ModerationAction = "history-clear"
+)
+
+// EventModerationAction is model for event of moderator taking action
+// against user. Target is ID or nickname of the user, it's empty for
+// actions, which don't target single user.
+type EventModerationAction struct {
+	ID     string           `json:"id"`
+	Action ModerationAction `json:"action"`
+	Actor  ChatUser         `json:"actor"`
+	Target string           `json:"target,omitempty"`
+	Reason string           `json:"reason,omitempty"`
+	At     time.Time        `json:"at"`
+}
+
+// ModerationAudit records moderation actions in audit log by sending
+// them through event bridge, which stores them in event storage.
+type ModerationAudit struct {
+	Sender *BridgeEventProducer[EventModerationAction]
+
+	IDGenerator
+	Clock
+}
+
+// Record sends moderation action taken by moderator from given context.
+// Actions aren't recorded, when audit is nil.
+func (a *ModerationAudit) Record(ctx context.Context, action ModerationAction, target, reason string) {
+	if a == nil {
+		return
+	}
+
+	actor := ChatUser{}
+	if state := SessionContextState(ctx); state != nil {
+		actor = UserPresentation(state.ID, state.Nickname)
+	}
+
+	eventID := a.GenerateID()
+	a.Sender.SendEvent(ctx, eventID, EventModerationAction{
+		ID:     eventID,
+		Action: action,
+		Actor:  actor,
+		Target: target,
+		Reason: reason,
+		At:     a.Now(),
+	})
+}
+
+// ModerationLog stores archived moderation actions.
+type ModerationLog interface {
+	// ModerationActionsBefore returns at most limit of moderation-action
+	// events, which were stored before event with given ID, in reverse
+	// order of their sequence numbers. Empty before ID means that the
+	// newest actions are returned.
+	ModerationActionsBefore(ctx context.Context, before string, limit int) ([]BridgeEvent, error)
+}
+
+// Limits of single audit log page.
+const (
+	auditLimitDefault = 50
+	auditLimitMax     = 100
+)
+
+// HandlerAuditDependencies holds arguments for HandlerAudit.
+type HandlerAuditDependencies struct {
+	Logger *logrus.Logger
+	Log    ModerationLog
+}
+
+// HandlerAudit sends page of moderation actions in reverse chronological
+// order. Pages are selected with cursor, which is ID of the oldest action
+// from previous page.
+func HandlerAudit(deps HandlerAuditDependencies) http.HandlerFunc {
+	type response struct {
+		Actions    []EventModerationAction `json:"actions"`
+		NextCursor string                  `json:"nextCursor"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		log := deps.Logger.WithFields(logrus.Fields{
+			"reqID": middleware.GetReqID(ctx),
+		})
+
+		query := r.URL.Query()
+		limit := auditLimitDefault
+		if l := query.Get("limit"); l != "" {
+			parsed, err := strconv.Atoi(l)
+			if err != nil || parsed < 1 || parsed > auditLimitMax {
+				writeError(w, r, http.StatusBadRequest, ErrorReasonInvalidParam, fmt.Sprintf("Limit must be a number between 1 and %d.", auditLimitMax))
+				return
+			}
+			limit = parsed
+		}
+
+		evts, err := deps.Log.ModerationActionsBefore(ctx, query.Get("before"), limit)
+		if err != nil {
+			log.WithField("error", err.Error()).Error("Failed to retrieve audit log.")
+			writeError(w, r, http.StatusInternalServerError, ErrorReasonInternal, "Failed to retrieve audit log. Please try again later.")
+			return
+		}
+
+		res := response{
+			Actions: []EventModerationAction{},
+		}
+		if len(evts) == limit {
+			res.NextCursor = evts[len(evts)-1].ID
+		}
+
+		for _, evt := range evts {
+			action := EventModerationAction{}
+			if err := json.Unmarshal(evt.Data, &action); err != nil {
+				log.WithFields(logrus.Fields{
+					"eventID": evt.ID,
+					"error":   err.Error(),
+				}).Error("Failed to unmarshal EventModerationAction data.")
+				continue
+			}
+			res.Actions = append(res.Actions, action)
+		}
+
+		jsonResponse(w, http.StatusOK, responseWrapper{
+			Data: res,
+		})
+	}
+}
@@ -0,0 +1,148 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+)
+
+// moderationLogMock is ModerationLog reading moderation actions stored
+// in bridge storage mock.
+type moderationLogMock struct {
+	storage *bridgeStorageMock
+}
+
+func (l moderationLogMock) ModerationActionsBefore(ctx context.Context, before string, limit int) ([]BridgeEvent, error) {
+	evts := l.storage.Events()
+
+	res := []BridgeEvent{}
+	found := before == ""
+	for i := len(evts) - 1; i >= 0 && len(res) < limit; i-- {
+		if evts[i].Name != BridgeModerationAction {
+			continue
+		}
+		if found {
+			res = append(res, evts[i])
+		}
+		found = found || evts[i].ID == before
+	}
+
+	return res, nil
+}
+
+func TestModerationAudit(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+	log := testLogger()
+
+	storage := newBridgeStorageMock()
+	bridge := NewBridge(ctx, BridgeBuilder{
+		Logger:  log,
+		Storage: storage,
+	})
+	defer bridge.Shutdown(ctx)
+
+	audit := &ModerationAudit{
+		Sender: &BridgeEventProducer[EventModerationAction]{
+			EventBridge: bridge,
+			Type:        BridgeModerationAction,
+			Log:         log,
+			Clock:       testClock(),
+		},
+		IDGenerator: testIDGenerator(),
+		Clock:       testClock(),
+	}
+	admin := &SessionState{ID: "admin", Nickname: "admin", Admin: true}
+
+	ban := HandlerBan(HandlerBanDependencies{
+		Logger: log,
+		Bans:   NewBanStoreMemory(testClock()),
+		Audit:  audit,
+	})
+	r := requestWithSession(ctx, httptest.NewRequest(
+		http.MethodPost, "/admin/ban", strings.NewReader(`{"id": "spammer", "reason": " spam "}`),
+	), admin)
+	w := httptest.NewRecorder()
+	ban(w, r)
+	is.Equal(w.Code, http.StatusOK)
+
+	waitFor(t, time.Second, func() bool {
+		return len(storage.Events()) == 1
+	})
+	is.Equal(storage.Events()[0].Name, BridgeModerationAction)
+
+	auditLog := HandlerAudit(HandlerAuditDependencies{
+		Logger: log,
+		Log:    moderationLogMock{storage: storage},
+	})
+
+	// page returns page of audit log read with given query.
+	page := func(query string) (int, []EventModerationAction, string) {
+		r := requestWithSession(ctx, httptest.NewRequest(http.MethodGet, "/admin/audit"+query, nil), admin)
+		w := httptest.NewRecorder()
+		auditLog(w, r)
+
+		res := struct {
+			Data struct {
+				Actions    []EventModerationAction `json:"actions"`
+				NextCursor string                  `json:"nextCursor"`
+			} `json:"data"`
+		}{}
+		if w.Code == http.StatusOK {
+			is.NoErr(json.NewDecoder(w.Body).Decode(&res))
+		}
+		return w.Code, res.Data.Actions, res.Data.NextCursor
+	}
+
+	code, actions, next := page("")
+	is.Equal(code, http.StatusOK)
+	is.Equal(next, "")
+	is.Equal(actions, []EventModerationAction{{
+		ID:     "1",
+		Action: ModerationBan,
+		Actor:  UserPresentation("admin", "admin"),
+		Target: "spammer",
+		Reason: "spam",
+		At:     testClock().Now(),
+	}})
+
+	t.Run("pagination", func(t *testing.T) {
+		is := is.New(t)
+
+		for _, id := range []string{"2", "3"} {
+			is.NoErr(storage.StoreEvent(ctx, BridgeEvent{
+				Name: BridgeModerationAction,
+				ID:   id,
+				Data: []byte(`{"id": "` + id + `", "action": "kick"}`),
+			}))
+		}
+
+		code, actions, next := page("?limit=2")
+		is.Equal(code, http.StatusOK)
+		is.Equal(len(actions), 2)
+		is.Equal(actions[0].ID, "3")
+		is.Equal(actions[1].ID, "2")
+		is.Equal(next, "2")
+
+		code, actions, next = page("?limit=2&before=" + next)
+		is.Equal(code, http.StatusOK)
+		is.Equal(len(actions), 1)
+		is.Equal(actions[0].Action, ModerationBan)
+		is.Equal(next, "")
+
+		code, _, _ = page("?limit=0")
+		is.Equal(code, http.StatusBadRequest)
+	})
+
+	t.Run("nil audit", func(t *testing.T) {
+		// Actions aren't recorded without audit.
+		var none *ModerationAudit
+		none.Record(ctx, ModerationKick, "spammer", "")
+	})
+}
@@ -0,0 +1,322 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/sirupsen/logrus"
+)
+
+// ErrSessionBanned is returned for session states of banned users.
+var ErrSessionBanned = errors.New("session user has been banned")
+
+// BanStore holds banned user IDs and nicknames. Banned users can't
+// log in and their existing sessions are rejected.
+type BanStore interface {
+	// Ban bans given user ID or nickname until given expiration date.
+	// Zero expiration date means permanent ban.
+	Ban(ctx context.Context, id string, expireAt time.Time) error
+
+	// Unban lifts ban of given user ID or nickname.
+	Unban(ctx context.Context, id string) error
+
+	// Banned reports whether given user ID or nickname is banned.
+	Banned(ctx context.Context, id string) (bool, error)
+}
+
+// BanStoreMemory is in-memory BanStore. Expired bans are garbage
+// collected on every ban.
+type BanStoreMemory struct {
+	bans  map[string]time.Time
+	mtx   *sync.Mutex
+	clock Clock
+}
+
+// NewBanStoreMemory returns empty in-memory ban store.
+func NewBanStoreMemory(clock Clock) *BanStoreMemory {
+	return &BanStoreMemory{
+		bans:  make(map[string]time.Time),
+		mtx:   &sync.Mutex{},
+		clock: clock,
+	}
+}
+
+// Ban bans given user ID or nickname until given expiration date.
+// Zero expiration date means permanent ban.
+func (s *BanStoreMemory) Ban(ctx context.Context, id string, expireAt time.Time) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	now := s.clock.Now()
+	for bannedID, bannedExpireAt := range s.bans {
+		if !bannedExpireAt.IsZero() && bannedExpireAt.Before(now) {
+			delete(s.bans, bannedID)
+		}
+	}
+
+	s.bans[id] = expireAt
+	return nil
+}
+
+// Unban lifts ban of given user ID or nickname.
+func (s *BanStoreMemory) Unban(ctx context.Context, id string) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	delete(s.bans, id)
+	return nil
+}
+
+// Banned reports whether given user ID or nickname is banned.
+func (s *BanStoreMemory) Banned(ctx context.Context, id string) (bool, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	expireAt, ok := s.bans[id]
+	if !ok {
+		return false, nil
+	}
+
+	return expireAt.IsZero() || !expireAt.Before(s.clock.Now()), nil
+}
+
+// BanStoreCache is BanStore, which caches results of ban checks of
+// underlying store, so sessions aren't checked against storage on
+// every request. Bans and unbans made through the cache apply right
+// away, bans made by other instances sharing storage apply after TTL.
+type BanStoreCache struct {
+	store BanStore
+	ttl   time.Duration
+	clock Clock
+
+	mtx      *sync.Mutex
+	banned   map[string]bool
+	loadedAt time.Time
+}
+
+// NewBanStoreCache returns ban store, which caches ban checks of given
+// store for given TTL.
+func NewBanStoreCache(store BanStore, ttl time.Duration, clock Clock) *BanStoreCache {
+	return &BanStoreCache{
+		store:  store,
+		ttl:    ttl,
+		clock:  clock,
+		mtx:    &sync.Mutex{},
+		banned: make(map[string]bool),
+	}
+}
+
+// Ban bans given user ID or nickname until given expiration date.
+// Zero expiration date means permanent ban.
+func (c *BanStoreCache) Ban(ctx context.Context, id string, expireAt time.Time) error {
+	if err := c.store.Ban(ctx, id, expireAt); err != nil {
+		return err
+	}
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	// Ban, which has already expired, is looked up in store again.
+	delete(c.banned, id)
+	if expireAt.IsZero() || !expireAt.Before(c.clock.Now()) {
+		c.banned[id] = true
+	}
+	return nil
+}
+
+// Unban lifts ban of given user ID or nickname.
+func (c *BanStoreCache) Unban(ctx context.Context, id string) error {
+	if err := c.store.Unban(ctx, id); err != nil {
+		return err
+	}
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	c.banned[id] = false
+	return nil
+}
+
+// Banned reports whether given user ID or nickname is banned. Results
+// of the underlying store are cached. Whole cache is dropped after TTL,
+// so expired bans are lifted and it doesn't grow without bound.
+func (c *BanStoreCache) Banned(ctx context.Context, id string) (bool, error) {
+	c.mtx.Lock()
+	now := c.clock.Now()
+	if now.Sub(c.loadedAt) >= c.ttl {
+		c.banned = make(map[string]bool)
+		c.loadedAt = now
+	}
+	banned, ok := c.banned[id]
+	c.mtx.Unlock()
+
+	if ok {
+		return banned, nil
+	}
+
+	banned, err := c.store.Banned(ctx, id)
+	if err != nil {
+		return false, err
+	}
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if _, ok := c.banned[id]; !ok {
+		c.banned[id] = banned
+	}
+	return banned, nil
+}
+
+// banID returns normalized user ID or nickname, so bans can't be
+// bypassed by changing case of nickname.
+func banID(id string) string {
+	return strings.ToLower(strings.TrimSpace(id))
+}
+
+// sessionBanned reports whether user of given session state is banned
+// either by their ID or nickname.
+func sessionBanned(ctx context.Context, bans BanStore, state *SessionState) (bool, error) {
+	for _, id := range []string{state.ID, state.Nickname} {
+		banned, err := bans.Banned(ctx, banID(id))
+		if err != nil || banned {
+			return banned, err
+		}
+	}
+
+	return false, nil
+}
+
+// HandlerBanDependencies holds arguments for HandlerBan.
+type HandlerBanDependencies struct {
+	Logger *logrus.Logger
+	Bans   BanStore
+
+	// Disconnecter closes event streams of user banned by their ID or
+	// nickname. It can be nil.
+	Disconnecter UserDisconnecter
+
+	Audit *ModerationAudit
+}
+
+// HandlerBan bans user ID or nickname, optionally until given
+// expiration date. Nicknames are banned regardless of their case.
+// Event streams of banned user are disconnected.
+func HandlerBan(deps HandlerBanDependencies) http.HandlerFunc {
+	type request struct {
+		ID       string     `json:"id"`
+		ExpireAt *time.Time `json:"expireAt"`
+		Reason   string     `json:"reason"`
+	}
+	type response struct {
+		ID       string     `json:"id"`
+		ExpireAt *time.Time `json:"expireAt"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		log := deps.Logger.WithFields(logrus.Fields{
+			"reqID": middleware.GetReqID(ctx),
+		})
+
+		req := &request{}
+
+		defer r.Body.Close()
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			jsonResponse(w, http.StatusBadRequest, responseWrapper{
+				Error: errorResponse{
+					Code:    http.StatusBadRequest,
+					Reason:  ErrorReasonInvalidBody,
+					Message: "Failed to parse body.",
+				},
+			})
+			return
+		}
+
+		req.ID = banID(req.ID)
+		if req.ID == "" {
+			jsonResponse(w, http.StatusBadRequest, responseWrapper{
+				Error: errorResponse{
+					Code:    http.StatusBadRequest,
+					Reason:  ErrorReasonInvalidBody,
+					Message: "Ban requires user ID or nickname.",
+				},
+			})
+			return
+		}
+
+		expireAt := time.Time{}
+		if req.ExpireAt != nil {
+			expireAt = *req.ExpireAt
+		}
+
+		if err := deps.Bans.Ban(ctx, req.ID, expireAt); err != nil {
+			log.WithField("error", err.Error()).Error("Failed to ban user.")
+			jsonResponse(w, http.StatusInternalServerError, responseWrapper{
+				Error: errorResponse{
+					Code:    http.StatusInternalServerError,
+					Reason:  ErrorReasonInternal,
+					Message: "Failed to ban user. Please try again later.",
+				},
+			})
+			return
+		}
+
+		if deps.Disconnecter != nil {
+			deps.Disconnecter.Disconnect(req.ID)
+			deps.Disconnecter.DisconnectNickname(req.ID)
+		}
+
+		go deps.Audit.Record(ctx, ModerationBan, req.ID, strings.TrimSpace(req.Reason))
+
+		log.WithField("banID", req.ID).Info("User has been banned.")
+		jsonResponse(w, http.StatusOK, responseWrapper{
+			Data: response{
+				ID:       req.ID,
+				ExpireAt: req.ExpireAt,
+			},
+		})
+	}
+}
+
+// HandlerUnbanDependencies holds arguments for HandlerUnban.
+type HandlerUnbanDependencies struct {
+	Logger *logrus.Logger
+	Bans   BanStore
+	Audit  *ModerationAudit
+}
+
+// HandlerUnban lifts ban of user ID or nickname given in URL.
+func HandlerUnban(deps HandlerUnbanDependencies) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		log := deps.Logger.WithFields(logrus.Fields{
+			"reqID": middleware.GetReqID(ctx),
+		})
+
+		id := banID(chi.URLParam(r, "id"))
+		if err := deps.Bans.Unban(ctx, id); err != nil {
+			log.WithField("error", err.Error()).Error("Failed to unban user.")
+			jsonResponse(w, http.StatusInternalServerError, responseWrapper{
+				Error: errorResponse{
+					Code:    http.StatusInternalServerError,
+					Reason:  ErrorReasonInternal,
+					Message: "Failed to unban user. Please try again later.",
+				},
+			})
+			return
+		}
+
+		go deps.Audit.Record(ctx, ModerationUnban, id, "")
+
+		log.WithField("banID", id).Info("User has been unbanned.")
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
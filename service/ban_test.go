@@ -0,0 +1,219 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fenole/szmaterlok/service/sse"
+	"github.com/go-chi/chi/v5"
+	"github.com/matryer/is"
+)
+
+func TestHandlerLoginBanned(t *testing.T) {
+	type testArgs struct {
+		name     string
+		nickname string
+		expireIn time.Duration
+		code     int
+	}
+
+	scenario := func(tt testArgs) (string, func(*testing.T)) {
+		return tt.name, func(t *testing.T) {
+			is := is.New(t)
+
+			clock, move := testMovingClock()
+			bans := NewBanStoreMemory(clock)
+			is.NoErr(bans.Ban(context.Background(), "spammer", clock.Now().Add(tt.expireIn)))
+			move(time.Minute)
+
+			h := HandlerLogin(HandlerLoginDependencies{
+				StateFactory: DefaultSessionStateFactory(),
+				Logger:       testLogger(),
+				SessionStore: &SessionCookieStore{
+					ExpirationTime: time.Hour,
+					Tokenizer:      NewSessionSimpleTokenizer(),
+					Clock:          clock,
+				},
+				NicknamePolicy: NicknamePolicy{
+					MinLength: 3,
+					MaxLength: 8,
+				},
+				Bans: bans,
+			})
+
+			form := url.Values{"nickname": {tt.nickname}}
+			r := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(form.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			w := httptest.NewRecorder()
+
+			h(w, r)
+			is.Equal(w.Code, tt.code)
+		}
+	}
+
+	t.Run(scenario(testArgs{
+		name:     "banned nickname",
+		nickname: "spammer",
+		expireIn: time.Hour,
+		code:     http.StatusForbidden,
+	}))
+	t.Run(scenario(testArgs{
+		name:     "other nickname",
+		nickname: "karol",
+		expireIn: time.Hour,
+		code:     http.StatusSeeOther,
+	}))
+	t.Run(scenario(testArgs{
+		name:     "expired ban",
+		nickname: "spammer",
+		expireIn: time.Second,
+		code:     http.StatusSeeOther,
+	}))
+}
+
+func TestHandlerBan(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+
+	tokenizer, err := NewSessionAESTokenizer([]byte("veibiequohy2eshaerohHoghootae1ku"))
+	is.NoErr(err)
+
+	clock := testClock()
+	bans := NewBanStoreMemory(clock)
+	cs := &SessionCookieStore{
+		ExpirationTime: time.Hour,
+		Tokenizer:      tokenizer,
+		Bans:           bans,
+		Clock:          clock,
+	}
+
+	token, err := tokenizer.TokenEncode(SessionState{
+		ID:       "uniqueid",
+		Nickname: "karol",
+		ExpireAt: clock.Now().Add(time.Hour),
+	})
+	is.NoErr(err)
+
+	session := func() int {
+		h := SessionRequired(cs)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+		r := httptest.NewRequest(http.MethodGet, "/chat", nil)
+		r.AddCookie(&http.Cookie{Name: sessionCookieKey, Value: token})
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	streams := NewBridgeMessageHandler(testLogger())
+	subscribe := func(id, nickname string) chan sse.Event {
+		evts := make(chan sse.Event, 1)
+		streams.Subscribe(ctx, MessageSubscribeRequest{
+			ID:        id,
+			Nickname:  nickname,
+			RequestID: "req-" + id,
+			Channel:   evts,
+		})
+		return evts
+	}
+
+	router := chi.NewRouter()
+	router.Post("/admin/ban", HandlerBan(HandlerBanDependencies{
+		Logger:       testLogger(),
+		Bans:         bans,
+		Disconnecter: streams,
+	}))
+	router.Delete("/admin/ban/{id}", HandlerUnban(HandlerUnbanDependencies{
+		Logger: testLogger(),
+		Bans:   bans,
+	}))
+	request := func(method, target, body string) int {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(method, target, strings.NewReader(body)))
+		return w.Code
+	}
+
+	is.Equal(session(), http.StatusOK)
+
+	// Active session is rejected right after its user is banned.
+	is.Equal(request(http.MethodPost, "/admin/ban", `{"id":"uniqueid"}`), http.StatusOK)
+	is.Equal(session(), http.StatusUnauthorized)
+
+	is.Equal(request(http.MethodDelete, "/admin/ban/uniqueid", ""), http.StatusNoContent)
+	is.Equal(session(), http.StatusOK)
+
+	// Bans of nicknames apply to sessions as well, regardless of case
+	// of nickname, and their event streams are disconnected.
+	banned := subscribe("otherid", "Karol")
+	other := subscribe("thirdid", "janek")
+	is.Equal(request(http.MethodPost, "/admin/ban", `{"id":"KAROL","expireAt":"2023-01-01T00:00:00Z"}`), http.StatusOK)
+	is.Equal(session(), http.StatusUnauthorized)
+
+	for range banned {
+	}
+	is.Equal(len(streams.ActiveSubscribers()), 1)
+	is.Equal(len(other), 0)
+
+	isBanned, err := bans.Banned(ctx, "karol")
+	is.NoErr(err)
+	is.True(isBanned)
+
+	is.Equal(request(http.MethodDelete, "/admin/ban/Karol", ""), http.StatusNoContent)
+	is.Equal(session(), http.StatusOK)
+
+	is.Equal(request(http.MethodPost, "/admin/ban", `{"id":"  "}`), http.StatusBadRequest)
+}
+
+// banStoreCounter counts Banned calls of underlying ban store.
+type banStoreCounter struct {
+	BanStore
+	calls int
+}
+
+func (c *banStoreCounter) Banned(ctx context.Context, id string) (bool, error) {
+	c.calls++
+	return c.BanStore.Banned(ctx, id)
+}
+
+func TestBanStoreCache(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+
+	clock, move := testMovingClock()
+	store := &banStoreCounter{BanStore: NewBanStoreMemory(clock)}
+	cache := NewBanStoreCache(store, time.Minute, clock)
+
+	banned := func(id string) bool {
+		res, err := cache.Banned(ctx, id)
+		is.NoErr(err)
+		return res
+	}
+
+	// Results of store are cached.
+	is.True(!banned("karol"))
+	is.True(!banned("karol"))
+	is.Equal(store.calls, 1)
+
+	// Bans and unbans made through the cache apply right away.
+	is.NoErr(cache.Ban(ctx, "karol", clock.Now().Add(time.Hour)))
+	is.True(banned("karol"))
+	is.NoErr(cache.Unban(ctx, "karol"))
+	is.True(!banned("karol"))
+	is.Equal(store.calls, 1)
+
+	// Bans made directly in store apply after TTL.
+	is.True(!banned("janek"))
+	is.NoErr(store.Ban(ctx, "janek", clock.Now().Add(time.Hour*2)))
+	is.NoErr(cache.Ban(ctx, "spammer", clock.Now().Add(time.Second*90)))
+	is.True(!banned("janek"))
+	move(time.Minute)
+	is.True(banned("janek"))
+
+	// Expired ban is lifted once cache is dropped.
+	is.True(banned("spammer"))
+	move(time.Minute)
+	is.True(!banned("spammer"))
+}
@@ -3,7 +3,13 @@ package service
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/sirupsen/logrus"
@@ -42,6 +48,12 @@ type BridgeEvent struct {
 	// ID is unique event identifier.
 	ID string `json:"id"`
 
+	// Sequence is number assigned to event by event bridge. Sequence
+	// numbers increase monotonically in the order events are handled,
+	// unlike IDs, so they tell which events came after given one.
+	// Zero means that event hasn't been numbered.
+	Sequence uint64 `json:"sequence,omitempty"`
+
 	// CreatedAt is date of event creation expressed
 	// as unix epoch.
 	CreatedAt int64 `json:"createdAt"`
@@ -70,16 +82,33 @@ func (f BridgeEventHandlerFunc) EventHook(ctx context.Context, evt BridgeEvent)
 	f(ctx, evt)
 }
 
+// DeadLetterHandler receives events, which event hooks have failed
+// to handle.
+type DeadLetterHandler interface {
+	// DeadLetter is called with event, which caused failure, and
+	// the reason of failure.
+	DeadLetter(ctx context.Context, evt BridgeEvent, reason error)
+}
+
+// DeadLetterHandlerFunc is functional interface of DeadLetterHandler.
+type DeadLetterHandlerFunc func(context.Context, BridgeEvent, error)
+
+func (f DeadLetterHandlerFunc) DeadLetter(ctx context.Context, evt BridgeEvent, reason error) {
+	f(ctx, evt, reason)
+}
+
 type bridgeEventHandlerComposite []BridgeEventHandler
 
-func (ehc bridgeEventHandlerComposite) EventHook(ctx context.Context, evt BridgeEvent) {
+// eventHook runs all hooks concurrently with given router. Single
+// hook, which panics or times out, doesn't affect the other hooks.
+func (ehc bridgeEventHandlerComposite) eventHook(ctx context.Context, evt BridgeEvent, r *BridgeEventRouter) {
 	wg := sync.WaitGroup{}
 	wg.Add(len(ehc))
 	for _, h := range ehc {
 		h := h
 		go func() {
 			defer wg.Done()
-			h.EventHook(ctx, evt)
+			r.runHook(ctx, evt, h)
 		}()
 	}
 	wg.Wait()
@@ -91,6 +120,20 @@ type BridgeStorage interface {
 	StoreEvent(context.Context, BridgeEvent) error
 }
 
+// BridgeSequenceStore persists the highest sequence number reserved by
+// event bridge. Sequence numbers are assigned also to events, which
+// aren't stored, so the next run of event bridge has to continue after
+// the reserved one, not after the last stored event.
+type BridgeSequenceStore interface {
+	// ReserveSequence stores given sequence number as the highest
+	// one, which event bridge could have assigned.
+	ReserveSequence(ctx context.Context, sequence uint64) error
+}
+
+// bridgeSequenceBlock is number of sequence numbers reserved at once,
+// so event bridge doesn't hit sequence store with every event.
+const bridgeSequenceBlock = 1000
+
 // Bridge is asynchronous queue for events. It can process
 // events from different sources spread all across szmaterlok
 // application and handles them with event hooks represented
@@ -100,45 +143,267 @@ type BridgeStorage interface {
 type Bridge struct {
 	queue  chan BridgeEvent
 	closer chan struct{}
+	alive  *atomic.Bool
+
+	// stopped is closed when event loop stops reading the queue.
+	stopped chan struct{}
+
+	// senders counts senders, which are pushing events to the queue,
+	// so Shutdown closes the queue only after they're done. Events
+	// aren't accepted anymore after closing is set.
+	sendMtx *sync.Mutex
+	senders *sync.WaitGroup
+	closing bool
+
+	// dropped counts events rejected by TrySendEvent.
+	dropped *atomic.Uint64
+
+	// processed counts events, which have been stored and handled.
+	processed *atomic.Uint64
+
+	// inFlight counts events dispatched to handler, which haven't
+	// been handled yet.
+	inFlight *atomic.Int64
+
+	handler  BridgeEventHandler
+	log      *logrus.Logger
+	storage  BridgeStorage
+	persist  BridgePersistPredicate
+	metrics  *Metrics
+	onCancel BridgeCancelPolicy
+	ordered  bool
 
-	handler BridgeEventHandler
-	log     *logrus.Logger
-	storage BridgeStorage
+	// sequence is the last assigned sequence number. It's used only
+	// by event loop.
+	sequence uint64
+
+	// reserved is the highest sequence number reserved in sequences.
+	// It's used only by event loop.
+	reserved  uint64
+	sequences BridgeSequenceStore
 }
 
+// BridgeCancelPolicy decides what happens with events left in the
+// queue, when context of event bridge is cancelled.
+type BridgeCancelPolicy int
+
+const (
+	// BridgeCancelDrain processes events, which are already in the
+	// queue, before event loop exits.
+	BridgeCancelDrain BridgeCancelPolicy = iota
+
+	// BridgeCancelDiscard drops events left in the queue.
+	BridgeCancelDiscard
+)
+
 // BridgeBuilder holds arguments for building event bridge.
 type BridgeBuilder struct {
 	Handler BridgeEventHandler
 	Logger  *logrus.Logger
 	Storage BridgeStorage
+
+	// Metrics are optional collectors of event bridge
+	// statistics.
+	Metrics *Metrics
+
+	// QueueSize is number of events which can wait in the queue
+	// without blocking senders. Zero means unbuffered queue.
+	QueueSize int
+
+	// Persist selects events stored in Storage. BridgePersistDefault
+	// is used when it's nil.
+	Persist BridgePersistPredicate
+
+	// OnCancel is policy for events left in the queue after context
+	// cancellation. Events are drained by default.
+	OnCancel BridgeCancelPolicy
+
+	// Ordered makes event bridge dispatch events to Handler one by
+	// one, in the order they were sent. By default every event is
+	// handled in its own goroutine, so handlers can observe events
+	// out of order (for example two messages sent by the same user).
+	// Ordered dispatch trades throughput for correctness: single
+	// slow handler holds back all of the next events.
+	Ordered bool
+
+	// Sequence is the last sequence number assigned before, for
+	// example by previous run of event bridge. Events are numbered
+	// starting from the next one.
+	Sequence uint64
+
+	// Sequences is optional store of reserved sequence numbers. When
+	// it's set, sequence numbers are reserved in blocks before they're
+	// assigned, so they're never assigned again after restart.
+	Sequences BridgeSequenceStore
 }
 
 // NewBridge is constructor for event bridge. It returns
 // default instance of event bridge.
 func NewBridge(ctx context.Context, args BridgeBuilder) *Bridge {
-	evtChan := make(chan BridgeEvent)
 	res := &Bridge{
-		queue:   evtChan,
-		closer:  make(chan struct{}),
-		handler: args.Handler,
-		log:     args.Logger,
-		storage: args.Storage,
+		queue:     make(chan BridgeEvent, args.QueueSize),
+		closer:    make(chan struct{}),
+		alive:     &atomic.Bool{},
+		stopped:   make(chan struct{}),
+		sendMtx:   &sync.Mutex{},
+		senders:   &sync.WaitGroup{},
+		dropped:   &atomic.Uint64{},
+		processed: &atomic.Uint64{},
+		inFlight:  &atomic.Int64{},
+		handler:   args.Handler,
+		log:       args.Logger,
+		storage:   args.Storage,
+		persist:   args.Persist,
+		metrics:   args.Metrics,
+		onCancel:  args.OnCancel,
+		ordered:   args.Ordered,
+		sequence:  args.Sequence,
+		reserved:  args.Sequence,
+		sequences: args.Sequences,
+	}
+	if res.persist == nil {
+		res.persist = BridgePersistDefault
 	}
+	res.metrics.registerBridge(res)
 
+	res.alive.Store(true)
 	go res.run(ctx)
 	return res
 }
 
-// SendEvent sends event to event bridge. It blocks, so it's
-// a good idea to run it in a separate goroutine.
+// Alive reports whether event loop of event bridge is still running.
+func (b *Bridge) Alive() bool {
+	return b.alive.Load()
+}
+
+// SendEvent sends event to event bridge. It blocks when the queue
+// is full, so it's a good idea to run it in a separate goroutine.
+// Events sent after event loop has stopped or after shutdown are
+// dropped, so senders don't block forever on the queue nobody reads.
 func (b *Bridge) SendEvent(evt BridgeEvent) {
-	b.queue <- evt
+	if !b.enter() {
+		b.stoppedWarn(evt)
+		return
+	}
+	defer b.senders.Done()
+
+	select {
+	case b.queue <- evt:
+	case <-b.stopped:
+		b.stoppedWarn(evt)
+	}
+}
+
+// TrySendEvent sends event to event bridge without blocking. It
+// returns false and drops the event when the queue is full or event
+// bridge has been shut down.
+func (b *Bridge) TrySendEvent(evt BridgeEvent) bool {
+	if !b.enter() {
+		b.dropped.Add(1)
+		return false
+	}
+	defer b.senders.Done()
+
+	select {
+	case b.queue <- evt:
+		return true
+	default:
+		b.dropped.Add(1)
+		return false
+	}
+}
+
+// enter registers sender of event. It returns false, when event
+// bridge is shutting down and doesn't accept events anymore.
+// Registered sender must call senders.Done after sending.
+func (b *Bridge) enter() bool {
+	b.sendMtx.Lock()
+	defer b.sendMtx.Unlock()
+
+	if b.closing {
+		return false
+	}
+	b.senders.Add(1)
+	return true
+}
+
+// stoppedWarn logs event, which has been sent to event bridge, that
+// doesn't process events anymore.
+func (b *Bridge) stoppedWarn(evt BridgeEvent) {
+	b.log.WithFields(logrus.Fields{
+		"reqID": evt.Headers.Get(bridgeRequestIDHeaderVar),
+		"evtID": evt.ID,
+	}).Warn("Event has been sent to stopped event bridge.")
+}
+
+// Dropped returns number of events dropped by TrySendEvent.
+func (b *Bridge) Dropped() uint64 {
+	return b.dropped.Load()
+}
+
+// queueDepth returns number of events waiting in the queue.
+func (b *Bridge) queueDepth() int {
+	return len(b.queue)
+}
+
+// BridgeStats is snapshot of event bridge statistics.
+type BridgeStats struct {
+	// QueueLength is number of events waiting in the queue.
+	QueueLength int `json:"queueLength"`
+
+	// QueueCapacity is number of events, which can wait in the queue
+	// without blocking senders.
+	QueueCapacity int `json:"queueCapacity"`
+
+	// Processed is number of events, which have been stored and
+	// handled by event handler.
+	Processed uint64 `json:"processed"`
+
+	// InFlight is number of events, which are being handled by event
+	// handler right now.
+	InFlight int64 `json:"inFlight"`
+
+	// Dropped is number of events dropped by TrySendEvent.
+	Dropped uint64 `json:"dropped"`
+}
+
+// Stats returns current statistics of event bridge. Stalled event
+// handlers show up as growing queue and events stuck in flight.
+func (b *Bridge) Stats() BridgeStats {
+	return BridgeStats{
+		QueueLength:   b.queueDepth(),
+		QueueCapacity: cap(b.queue),
+		Processed:     b.processed.Load(),
+		InFlight:      b.inFlight.Load(),
+		Dropped:       b.Dropped(),
+	}
 }
 
 // Shutdown closes event bridge and waits for current
-// events being processed to finish.
+// events being processed to finish. Senders, which are already
+// pushing events to the queue, are waited for before the queue is
+// closed. Events sent after shutdown are dropped.
 func (b *Bridge) Shutdown(ctx context.Context) {
-	close(b.queue)
+	b.sendMtx.Lock()
+	closing := b.closing
+	b.closing = true
+	b.sendMtx.Unlock()
+
+	// Queue is closed only once, by the first call.
+	if !closing {
+		sent := make(chan struct{})
+		go func() {
+			b.senders.Wait()
+			close(b.queue)
+			close(sent)
+		}()
+
+		select {
+		case <-sent:
+		case <-ctx.Done():
+			return
+		}
+	}
 
 	select {
 	case <-b.closer:
@@ -158,48 +423,154 @@ func goWithWaitGroup(wg *sync.WaitGroup, f func()) {
 	}()
 }
 
-// run is main event loop of event bridge.
+// run is main event loop of event bridge. It exits when the queue
+// is closed by Shutdown or given context is cancelled.
 func (b *Bridge) run(ctx context.Context) {
 	wg := sync.WaitGroup{}
 
 	// Main processing loop.
-	for evt := range b.queue {
-		evt := evt
+loop:
+	for {
+		// Cancellation takes precedence over events waiting in the
+		// queue, because select picks ready cases at random.
+		if ctx.Err() != nil {
+			b.cancelled(&wg)
+			break
+		}
+
+		select {
+		case evt, ok := <-b.queue:
+			if !ok {
+				break loop
+			}
+			b.handle(ctx, &wg, evt)
+		case <-ctx.Done():
+			b.cancelled(&wg)
+			break loop
+		}
+	}
+	close(b.stopped)
+
+	// Wait for all jobs to finish.
+	wg.Wait()
+	b.alive.Store(false)
+
+	// Indicate event loop has finished.
+	close(b.closer)
+}
+
+// cancelled handles events left in the queue after cancellation
+// of event loop context, according to cancel policy.
+func (b *Bridge) cancelled(wg *sync.WaitGroup) {
+	// Context of event loop is already done, so remaining events
+	// are handled within the new one.
+	ctx := context.Background()
+
+	discarded := 0
+drain:
+	for {
+		select {
+		case evt, ok := <-b.queue:
+			if !ok {
+				break drain
+			}
+
+			if b.onCancel == BridgeCancelDiscard {
+				discarded++
+				continue
+			}
+			b.handle(ctx, wg, evt)
+		default:
+			break drain
+		}
+	}
 
+	if discarded > 0 {
+		b.log.WithFields(logrus.Fields{
+			"discarded": discarded,
+		}).Warn("Event bridge has been cancelled with events left in the queue.")
+	}
+}
+
+// handle stores given event and dispatches it to event handler.
+func (b *Bridge) handle(ctx context.Context, wg *sync.WaitGroup, evt BridgeEvent) {
+	// Events are numbered in the order they leave the queue, so
+	// sequence numbers are the same for storage and every handler.
+	b.sequence++
+	evt.Sequence = b.sequence
+	b.reserveSequence(ctx)
+
+	// Events are stored before they're dispatched to handlers, so
+	// archive always contains every event which handlers have seen.
+	// Storage failure doesn't stop the event from being handled.
+	if b.persist(evt.Name) {
 		if err := b.storage.StoreEvent(ctx, evt); err != nil {
 			b.log.WithFields(logrus.Fields{
 				"reqID": evt.Headers.Get(bridgeRequestIDHeaderVar),
 				"evtID": evt.ID,
+				"error": err.Error(),
 			}).Error("Failed to push event to event store.")
-			go func() {
-				b.log.WithFields(logrus.Fields{
-					"reqID": evt.Headers.Get(bridgeRequestIDHeaderVar),
-					"evtID": evt.ID,
-				}).Error("Retrying sending failing event to event bridge.")
-				b.queue <- evt
-			}()
-			continue
 		}
+	}
 
-		if b.handler == nil {
-			continue
-		}
+	if evt.Name == BridgeMessageSent {
+		b.metrics.messageSent()
+	}
 
-		goWithWaitGroup(&wg, func() {
-			b.handler.EventHook(ctx, evt)
-		})
+	if b.handler == nil {
+		b.processed.Add(1)
+		return
 	}
 
-	// Wait for all jobs to finish.
-	wg.Wait()
+	b.inFlight.Add(1)
+	dispatch := func() {
+		defer b.processed.Add(1)
+		defer b.inFlight.Add(-1)
+		defer b.metrics.observeHandler(evt.Name, time.Now())
+		b.handler.EventHook(ctx, evt)
+	}
+	if b.ordered {
+		dispatch()
+		return
+	}
+	goWithWaitGroup(wg, dispatch)
+}
+
+// reserveSequence reserves next block of sequence numbers, when the
+// last assigned one is beyond the reserved block. Failed reservation
+// is retried with the next event.
+func (b *Bridge) reserveSequence(ctx context.Context) {
+	if b.sequences == nil || b.sequence <= b.reserved {
+		return
+	}
 
-	// Send signal to closer and indicate event loop has finished.
-	b.closer <- struct{}{}
+	reserved := b.sequence + bridgeSequenceBlock - 1
+	if err := b.sequences.ReserveSequence(ctx, reserved); err != nil {
+		b.log.WithFields(logrus.Fields{
+			"sequence": reserved,
+			"error":    err.Error(),
+		}).Error("Failed to reserve event sequence numbers.")
+		return
+	}
+	b.reserved = reserved
 }
 
 // BridgeEventRouter delegates different event types into
 // their associated hook handlers.
+//
+// Panicking hook doesn't affect other hooks. Its panic is logged
+// with Logger and the event is passed to DeadLetter. Both of them
+// are optional.
+//
+// Every hook invocation is limited by Timeout. Hook running longer is
+// abandoned: its context is cancelled and router moves on without
+// waiting for it. Timeout is logged and reported to DeadLetter, just
+// like panic. Zero Timeout means that hooks are awaited indefinitely.
 type BridgeEventRouter struct {
+	Logger     *logrus.Logger
+	DeadLetter DeadLetterHandler
+	Timeout    time.Duration
+
 	hooks map[BridgeEventType]bridgeEventHandlerComposite
 }
 
@@ -229,20 +600,97 @@ func (r *BridgeEventRouter) EventHook(ctx context.Context, evt BridgeEvent) {
 	globHandler, ok := r.hooks[BridgeEventGlob]
 	if ok {
 		goWithWaitGroup(&wg, func() {
-			globHandler.EventHook(ctx, evt)
+			globHandler.eventHook(ctx, evt, r)
 		})
 	}
 
 	handler, ok := r.hooks[evt.Name]
 	if ok {
 		goWithWaitGroup(&wg, func() {
-			handler.EventHook(ctx, evt)
+			handler.eventHook(ctx, evt, r)
 		})
 	}
 
 	wg.Wait()
 }
 
+// runHook fires given hook with given event and recovers its panic.
+// When router has timeout, hook is abandoned after it.
+func (r *BridgeEventRouter) runHook(ctx context.Context, evt BridgeEvent, h BridgeEventHandler) {
+	run := func(ctx context.Context) {
+		defer func() {
+			if p := recover(); p != nil {
+				r.recovered(ctx, evt, p)
+			}
+		}()
+		h.EventHook(ctx, evt)
+	}
+
+	if r.Timeout <= 0 {
+		run(ctx)
+		return
+	}
+
+	hookCtx, cancel := context.WithTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		run(hookCtx)
+	}()
+
+	select {
+	case <-done:
+	case <-hookCtx.Done():
+		// Cancellation of event loop isn't a timeout, so hook is
+		// still awaited then.
+		if !errors.Is(hookCtx.Err(), context.DeadlineExceeded) {
+			<-done
+			return
+		}
+		r.timedOut(ctx, evt)
+	}
+}
+
+// timedOut handles event hook, which has been abandoned after it
+// hasn't handled given event within timeout.
+func (r *BridgeEventRouter) timedOut(ctx context.Context, evt BridgeEvent) {
+	reason := fmt.Errorf("event hook has timed out after %s", r.Timeout)
+
+	if r.Logger != nil {
+		r.Logger.WithFields(logrus.Fields{
+			"reqID":   evt.Headers.Get(bridgeRequestIDHeaderVar),
+			"evtID":   evt.ID,
+			"evtType": evt.Name,
+			"timeout": r.Timeout,
+		}).Error("Event hook has timed out.")
+	}
+
+	if r.DeadLetter != nil {
+		r.DeadLetter.DeadLetter(ctx, evt, reason)
+	}
+}
+
+// recovered handles panic of event hook, which has been fired
+// with given event.
+func (r *BridgeEventRouter) recovered(ctx context.Context, evt BridgeEvent, p interface{}) {
+	reason := fmt.Errorf("event hook has panicked: %v", p)
+
+	if r.Logger != nil {
+		r.Logger.WithFields(logrus.Fields{
+			"reqID":   evt.Headers.Get(bridgeRequestIDHeaderVar),
+			"evtID":   evt.ID,
+			"evtType": evt.Name,
+			"panic":   fmt.Sprint(p),
+		}).Error("Event hook has panicked.")
+	}
+
+	if r.DeadLetter != nil {
+		r.DeadLetter.DeadLetter(ctx, evt, reason)
+	}
+}
+
 // Types for bridge events.
 const (
 	// BridgeMessageSent is event type for message sent event.
@@ -253,20 +701,148 @@ const (
 
 	// BridgeUserJoin is event type fired when user's joining chat.
 	BridgeUserLeft = BridgeEventType("user-left")
+
+	// BridgeMessageEdited is event type fired when author edits message.
+	BridgeMessageEdited = BridgeEventType("message-edited")
+
+	// BridgeMessageDeleted is event type fired when author deletes message.
+	BridgeMessageDeleted = BridgeEventType("message-deleted")
+
+	// BridgeUserTyping is event type fired when user's typing message.
+	BridgeUserTyping = BridgeEventType("user-typing")
+
+	// BridgeUserPresence is event type fired when user's presence
+	// status changes.
+	BridgeUserPresence = BridgeEventType("user-presence")
+
+	// BridgeUserMuted is event type fired when moderator mutes or
+	// unmutes user.
+	BridgeUserMuted = BridgeEventType("user-muted")
+
+	// BridgeModerationAction is event type fired when moderator takes
+	// action against user. It's recorded in audit log.
+	BridgeModerationAction = BridgeEventType("moderation-action")
 )
 
+// BridgePersistPredicate reports whether events of given type should
+// be stored in event storage. Events which are not persisted are only
+// dispatched to handlers.
+type BridgePersistPredicate func(BridgeEventType) bool
+
+// BridgePersistDefault persists all events except ephemeral user
+// typing and presence notifications.
+func BridgePersistDefault(t BridgeEventType) bool {
+	return t != BridgeUserTyping && t != BridgeUserPresence
+}
+
+// PersistPolicy maps event types to whether their events should be
+// stored in event storage. Event types missing from the policy follow
+// BridgePersistDefault.
+type PersistPolicy map[BridgeEventType]bool
+
+// PersistPolicyDefault returns policy, which persists sent messages
+// and skips user join, left, typing and presence notifications.
+func PersistPolicyDefault() PersistPolicy {
+	return PersistPolicy{
+		BridgeMessageSent:  true,
+		BridgeUserJoin:     false,
+		BridgeUserLeft:     false,
+		BridgeUserTyping:   false,
+		BridgeUserPresence: false,
+	}
+}
+
+// Persist reports whether events of given type should be stored in
+// event storage. It's BridgePersistPredicate of the policy.
+func (p PersistPolicy) Persist(t BridgeEventType) bool {
+	if persist, ok := p[t]; ok {
+		return persist
+	}
+
+	return BridgePersistDefault(t)
+}
+
 type messageSubscriber struct {
 	id        string
+	nickname  string
+	channel   string
 	requestID string
 }
 
+// messageSubscription holds event channel of single subscriber. Events
+// are sent without holding lock of subscribers map, so subscription
+// guards its channel from being closed in the middle of sending.
+type messageSubscription struct {
+	events chan<- sse.Event
+	closed bool
+	mtx    *sync.Mutex
+}
+
+// send sends given event to subscription without blocking. It returns
+// false when the event doesn't fit into subscription channel. Events
+// sent to closed subscription are silently discarded.
+func (s *messageSubscription) send(evt sse.Event) bool {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if s.closed {
+		return true
+	}
+
+	select {
+	case s.events <- evt:
+		return true
+	default:
+		return false
+	}
+}
+
+// close closes subscription channel. Closing subscription again is
+// no-op.
+func (s *messageSubscription) close() {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if !s.closed {
+		s.closed = true
+		close(s.events)
+	}
+}
+
+// SlowClientPolicy decides what happens with subscribers, which don't
+// receive events fast enough.
+type SlowClientPolicy int
+
+const (
+	// SlowClientDrop drops events, which don't fit into channel of
+	// slow subscriber.
+	SlowClientDrop SlowClientPolicy = iota
+
+	// SlowClientDisconnect disconnects subscriber, whose channel is
+	// full.
+	SlowClientDisconnect
+)
+
 // BridgeMessageHandler handles sending, subscribing and
 // receiving of message-sent type events.
+//
+// Events are sent to subscribers without blocking, so subscriber
+// channels should be buffered. Events which don't fit into channel
+// are handled according to SlowClient policy. Subscriber channels
+// are closed by BridgeMessageHandler, when subscription ends.
 type BridgeMessageHandler struct {
+	// SlowClient is policy for subscribers with full channels. Events
+	// are dropped by default.
+	SlowClient SlowClientPolicy
+
+	// Metrics count events, which haven't been delivered to slow
+	// subscribers. It can be nil.
+	Metrics *Metrics
+
 	bridge *Bridge
 	log    *logrus.Logger
 
-	channels map[messageSubscriber]chan<- sse.Event
+	channels map[messageSubscriber]*messageSubscription
 	mtx      *sync.RWMutex
 }
 
@@ -275,18 +851,21 @@ type BridgeMessageHandler struct {
 func NewBridgeMessageHandler(log *logrus.Logger) *BridgeMessageHandler {
 	return &BridgeMessageHandler{
 		log:      log,
-		channels: make(map[messageSubscriber]chan<- sse.Event),
+		channels: make(map[messageSubscriber]*messageSubscription),
 		mtx:      &sync.RWMutex{},
 	}
 }
 
-// Subscribe given ID for SSE events. Returns unsubscribe func.
+// Subscribe given ID for SSE events. Returns unsubscribe func, which
+// closes channel of subscription.
 func (a *BridgeMessageHandler) Subscribe(ctx context.Context, req MessageSubscribeRequest) func() {
 	a.mtx.Lock()
 	defer a.mtx.Unlock()
 
 	key := messageSubscriber{
 		id:        req.ID,
+		nickname:  req.Nickname,
+		channel:   ChatChannelOrDefault(req.ChatChannel),
 		requestID: req.RequestID,
 	}
 
@@ -295,23 +874,94 @@ func (a *BridgeMessageHandler) Subscribe(ctx context.Context, req MessageSubscri
 		"subID": req.ID,
 	})
 
-	a.channels[key] = req.Channel
+	a.channels[key] = &messageSubscription{
+		events: req.Channel,
+		mtx:    &sync.Mutex{},
+	}
 	log.Info("Client has subscribed for bridge message handler.")
 
 	unsubscribe := func() {
 		a.mtx.Lock()
-		delete(a.channels, key)
+		a.remove(key)
 		a.mtx.Unlock()
 		log.Info("Client has unsubscribed from bridge message handler.")
 	}
 	return unsubscribe
 }
 
-// EventHook for SSE events sent to browsers.
-func (a *BridgeMessageHandler) EventHook(_ context.Context, evt BridgeEvent) {
+// remove deletes given subscription and closes its channel. It reports
+// whether subscription has been removed, so removing the same
+// subscription again is no-op. It has to be called with write lock
+// held.
+func (a *BridgeMessageHandler) remove(sub messageSubscriber) bool {
+	s, ok := a.channels[sub]
+	if !ok {
+		return false
+	}
+
+	delete(a.channels, sub)
+	s.close()
+	return true
+}
+
+// ActiveSubscribers returns all of currently subscribed clients. Single
+// user can be subscribed multiple times with different request IDs.
+func (a *BridgeMessageHandler) ActiveSubscribers() []messageSubscriber {
 	a.mtx.RLock()
 	defer a.mtx.RUnlock()
 
+	res := make([]messageSubscriber, 0, len(a.channels))
+	for sub := range a.channels {
+		res = append(res, sub)
+	}
+
+	return res
+}
+
+// Disconnect closes event channels of every subscription of user with
+// given ID, which makes their event streams return. It returns number
+// of closed subscriptions.
+func (a *BridgeMessageHandler) Disconnect(userID string) int {
+	return a.disconnect(func(sub messageSubscriber) bool {
+		return sub.id == userID
+	})
+}
+
+// DisconnectNickname closes event channels of every subscription of
+// users with given nickname, compared case-insensitively. It returns
+// number of closed subscriptions.
+func (a *BridgeMessageHandler) DisconnectNickname(nickname string) int {
+	return a.disconnect(func(sub messageSubscriber) bool {
+		return strings.EqualFold(sub.nickname, nickname)
+	})
+}
+
+// disconnect closes event channels of subscriptions matched by given
+// func and returns their number.
+func (a *BridgeMessageHandler) disconnect(match func(messageSubscriber) bool) int {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	n := 0
+	for sub := range a.channels {
+		if !match(sub) {
+			continue
+		}
+
+		a.remove(sub)
+		n++
+
+		a.log.WithFields(logrus.Fields{
+			"reqID": sub.requestID,
+			"subID": sub.id,
+		}).Info("Client has been disconnected from bridge message handler.")
+	}
+
+	return n
+}
+
+// EventHook for SSE events sent to browsers.
+func (a *BridgeMessageHandler) EventHook(_ context.Context, evt BridgeEvent) {
 	if evt.Headers.Get(bridgeContentTypeHeaderVar) != contentTypeApplicationJSON {
 		a.log.WithFields(logrus.Fields{
 			"eventType": string(evt.Name),
@@ -322,11 +972,97 @@ func (a *BridgeMessageHandler) EventHook(_ context.Context, evt BridgeEvent) {
 		return
 	}
 
-	for _, c := range a.channels {
-		c <- sse.Event{
-			ID:   evt.ID,
+	// Messages, their edits and deletions are delivered only to subscribers
+	// of their chat channel and direct messages only to their author and recipient.
+	// Users joining and leaving chat don't receive their own events.
+	// Other events are delivered to every subscriber.
+	channel := ""
+	recipients := map[string]bool{}
+	actor := ""
+	switch evt.Name {
+	case BridgeUserJoin, BridgeUserLeft:
+		actor = evt.Headers.Get(bridgeActorIDHeaderVar)
+	case BridgeMessageSent, BridgeMessageEdited, BridgeMessageDeleted:
+		msg := struct {
+			Channel string    `json:"channel"`
+			From    ChatUser  `json:"from"`
+			To      *ChatUser `json:"to"`
+		}{}
+		if err := json.Unmarshal(evt.Data, &msg); err != nil {
+			a.log.WithFields(logrus.Fields{
+				"eventType": string(evt.Name),
+				"eventID":   evt.ID,
+				"reqID":     evt.Headers.Get(bridgeRequestIDHeaderVar),
+				"scope":     "BridgeMessageHandler.EventHook",
+				"error":     err.Error(),
+			}).Error("Failed to unmarshal message channel.")
+			return
+		}
+		channel = ChatChannelOrDefault(msg.Channel)
+		if msg.To != nil {
+			recipients[msg.From.ID] = true
+			recipients[msg.To.ID] = true
+		}
+	}
+
+	// Subscriptions are copied under short lock, so sending events
+	// doesn't hold back subscribing and unsubscribing clients.
+	type target struct {
+		sub          messageSubscriber
+		subscription *messageSubscription
+	}
+	targets := []target{}
+
+	a.mtx.RLock()
+	for sub, s := range a.channels {
+		if actor != "" && sub.id == actor {
+			continue
+		}
+		if len(recipients) > 0 {
+			if !recipients[sub.id] {
+				continue
+			}
+		} else if channel != "" && sub.channel != channel {
+			continue
+		}
+
+		targets = append(targets, target{sub: sub, subscription: s})
+	}
+	a.mtx.RUnlock()
+
+	slow := []messageSubscriber{}
+	for _, t := range targets {
+		if !t.subscription.send(sse.Event{
+			ID:   streamEventID(evt.ID, evt.Sequence),
 			Type: string(evt.Name),
 			Data: evt.Data,
+		}) {
+			slow = append(slow, t.sub)
+		}
+	}
+
+	for _, sub := range slow {
+		a.Metrics.subscriberEventDropped()
+
+		log := a.log.WithFields(logrus.Fields{
+			"eventType": string(evt.Name),
+			"eventID":   evt.ID,
+			"reqID":     sub.requestID,
+			"subID":     sub.id,
+			"scope":     "BridgeMessageHandler.EventHook",
+		})
+		if a.SlowClient != SlowClientDisconnect {
+			log.Warn("Event has been dropped for slow client.")
+			continue
+		}
+
+		// Subscription could have ended after the read lock has
+		// been released.
+		a.mtx.Lock()
+		disconnected := a.remove(sub)
+		a.mtx.Unlock()
+		if disconnected {
+			log.Warn("Slow client has been disconnected from bridge message handler.")
 		}
 	}
 }
@@ -335,8 +1071,22 @@ const (
 	bridgeRequestIDHeaderVar   = "Request-ID"
 	bridgeContentTypeHeaderVar = "Content-Type"
 	contentTypeApplicationJSON = "application/json; charset=utf-8"
+
+	// bridgeActorIDHeaderVar holds ID of user, whose request has
+	// produced the event.
+	bridgeActorIDHeaderVar = "Actor-ID"
 )
 
+// streamEventID returns ID of SSE event for bridge event with given ID
+// and sequence number. Numbered events are identified by their sequence
+// numbers, so clients resume with Last-Event-ID in the right order.
+func streamEventID(id string, sequence uint64) string {
+	if sequence == 0 {
+		return id
+	}
+	return strconv.FormatUint(sequence, 10)
+}
+
 // BridgeEventProducer publishes events with given T type to event bridge.
 type BridgeEventProducer[T any] struct {
 	EventBridge *Bridge
@@ -357,14 +1107,19 @@ func (p *BridgeEventProducer[T]) SendEvent(ctx context.Context, id string, evt T
 		return
 	}
 
+	headers := BridgeHeaders{
+		bridgeContentTypeHeaderVar: "application/json; charset=utf-8",
+		bridgeRequestIDHeaderVar:   middleware.GetReqID(ctx),
+	}
+	if state := SessionContextState(ctx); state != nil {
+		headers[bridgeActorIDHeaderVar] = state.ID
+	}
+
 	p.EventBridge.SendEvent(BridgeEvent{
 		ID:        id,
 		Name:      p.Type,
 		CreatedAt: p.Now().Unix(),
-		Headers: BridgeHeaders{
-			bridgeContentTypeHeaderVar: "application/json; charset=utf-8",
-			bridgeRequestIDHeaderVar:   middleware.GetReqID(ctx),
-		},
-		Data: data,
+		Headers:   headers,
+		Data:      data,
 	})
 }
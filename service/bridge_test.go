@@ -0,0 +1,1182 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+	"github.com/sirupsen/logrus"
+
+	"github.com/fenole/szmaterlok/service/sse"
+)
+
+// testLogger returns logger which discards all of its output.
+func testLogger() *logrus.Logger {
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+	return log
+}
+
+// bridgeStorageMock is in-memory BridgeStorage which records
+// all of stored events.
+type bridgeStorageMock struct {
+	mtx    *sync.Mutex
+	events []BridgeEvent
+}
+
+func newBridgeStorageMock() *bridgeStorageMock {
+	return &bridgeStorageMock{
+		mtx: &sync.Mutex{},
+	}
+}
+
+func (s *bridgeStorageMock) StoreEvent(ctx context.Context, evt BridgeEvent) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.events = append(s.events, evt)
+	return nil
+}
+
+func (s *bridgeStorageMock) Events() []BridgeEvent {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	res := make([]BridgeEvent, len(s.events))
+	copy(res, s.events)
+	return res
+}
+
+// testIDGenerator returns IDGenerator which generates sequential IDs
+// starting from 1.
+func testIDGenerator() IDGenerator {
+	mtx := &sync.Mutex{}
+	next := 0
+	return IDGeneratorFunc(func() string {
+		mtx.Lock()
+		defer mtx.Unlock()
+		next++
+		return strconv.Itoa(next)
+	})
+}
+
+func testClock() Clock {
+	now, _ := time.Parse(time.ANSIC, "Thu Mar 17 21:23:59 2022")
+	return ClockFunc(func() time.Time {
+		return now
+	})
+}
+
+func TestBridgeMessageHandlerChannels(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+
+	h := NewBridgeMessageHandler(testLogger())
+
+	roomA := make(chan sse.Event, 1)
+	h.Subscribe(ctx, MessageSubscribeRequest{
+		ID:          "1",
+		ChatChannel: "a",
+		RequestID:   "req1",
+		Channel:     roomA,
+	})
+
+	roomB := make(chan sse.Event, 1)
+	h.Subscribe(ctx, MessageSubscribeRequest{
+		ID:          "2",
+		ChatChannel: "b",
+		RequestID:   "req2",
+		Channel:     roomB,
+	})
+
+	data, err := json.Marshal(EventSentMessage{
+		ID:      "msg",
+		Channel: "a",
+		Content: "hello",
+	})
+	is.NoErr(err)
+
+	h.EventHook(ctx, BridgeEvent{
+		Name: BridgeMessageSent,
+		ID:   "msg",
+		Headers: BridgeHeaders{
+			bridgeContentTypeHeaderVar: contentTypeApplicationJSON,
+		},
+		Data: data,
+	})
+
+	is.Equal(len(roomA), 1)
+	is.Equal((<-roomA).ID, "msg")
+	is.Equal(len(roomB), 0)
+}
+
+func TestBridgeMessageHandlerDirectMessage(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+
+	h := NewBridgeMessageHandler(testLogger())
+
+	subs := map[string]chan sse.Event{}
+	for _, id := range []string{"author", "recipient", "other"} {
+		c := make(chan sse.Event, 1)
+		subs[id] = c
+		h.Subscribe(ctx, MessageSubscribeRequest{
+			ID:        id,
+			RequestID: id,
+			Channel:   c,
+		})
+	}
+
+	data, err := json.Marshal(EventSentMessage{
+		ID:      "dm",
+		From:    ChatUser{ID: "author"},
+		To:      &ChatUser{ID: "recipient"},
+		Content: "psst",
+	})
+	is.NoErr(err)
+
+	h.EventHook(ctx, BridgeEvent{
+		Name: BridgeMessageSent,
+		ID:   "dm",
+		Headers: BridgeHeaders{
+			bridgeContentTypeHeaderVar: contentTypeApplicationJSON,
+		},
+		Data: data,
+	})
+
+	is.Equal(len(subs["author"]), 1)
+	is.Equal(len(subs["recipient"]), 1)
+	is.Equal(len(subs["other"]), 0)
+}
+
+func TestBridgeMessageHandlerActor(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+	log := testLogger()
+
+	messageHandler := NewBridgeMessageHandler(log)
+	router := NewBridgeEventRouter()
+	router.Hook(BridgeUserJoin, messageHandler)
+	router.Hook(BridgeUserLeft, messageHandler)
+	router.Hook(BridgeUserTyping, messageHandler)
+
+	storage := newBridgeStorageMock()
+	bridge := NewBridge(ctx, BridgeBuilder{
+		Handler: router,
+		Logger:  log,
+		Storage: storage,
+	})
+	defer bridge.Shutdown(ctx)
+
+	announcer := &EventAnnouncer{
+		MessageNotifier: messageHandler,
+		UserJoinProducer: &BridgeEventProducer[EventUserJoin]{
+			EventBridge: bridge,
+			Type:        BridgeUserJoin,
+			Log:         log,
+			Clock:       testClock(),
+		},
+		UserLeftProducer: &BridgeEventProducer[EventUserLeft]{
+			EventBridge: bridge,
+			Type:        BridgeUserLeft,
+			Log:         log,
+			Clock:       testClock(),
+		},
+		Clock:       testClock(),
+		IDGenerator: testIDGenerator(),
+	}
+
+	userCtx := func(id string) context.Context {
+		return context.WithValue(ctx, sessionStateKey, &SessionState{ID: id, Nickname: id})
+	}
+
+	// subscribe subscribes given user and waits until all of the events
+	// sent so far are stored, so they're handled in order.
+	events := 0
+	subscribe := func(id string) (chan sse.Event, func()) {
+		events++
+		n := events
+
+		c := make(chan sse.Event, 4)
+		unsubscribe := announcer.Subscribe(userCtx(id), MessageSubscribeRequest{
+			ID:        id,
+			Nickname:  id,
+			RequestID: strconv.Itoa(n),
+			Channel:   c,
+		})
+
+		waitFor(t, time.Second, func() bool {
+			return len(storage.Events()) == n
+		})
+		return c, unsubscribe
+	}
+	receive := func(c chan sse.Event) sse.Event {
+		t.Helper()
+		select {
+		case evt := <-c:
+			return evt
+		case <-time.After(time.Second):
+			t.Fatal("event has not been delivered")
+		}
+		return sse.Event{}
+	}
+
+	observer, _ := subscribe("observer")
+
+	// Actor has chat opened in two browser tabs.
+	actorFirst, unsubscribeFirst := subscribe("actor")
+	actorSecond, unsubscribeSecond := subscribe("actor")
+	subscribe("late")
+
+	// Others receive join events of the actor.
+	for _, id := range []string{"actor", "actor", "late"} {
+		evt := receive(observer)
+		is.Equal(evt.Type, string(BridgeUserJoin))
+
+		data := EventUserJoin{}
+		is.NoErr(json.Unmarshal(evt.Data, &data))
+		is.Equal(data.User.ID, id)
+	}
+
+	// Actor doesn't receive own join events, also from the other
+	// connection, but it receives join events of others.
+	for _, c := range []chan sse.Event{actorFirst, actorSecond} {
+		evt := receive(c)
+		data := EventUserJoin{}
+		is.NoErr(json.Unmarshal(evt.Data, &data))
+		is.Equal(data.User.ID, "late")
+		is.Equal(len(c), 0)
+	}
+
+	unsubscribeFirst()
+	unsubscribeSecond()
+	is.Equal(receive(observer).Type, string(BridgeUserLeft))
+	is.Equal(receive(observer).Type, string(BridgeUserLeft))
+
+	// Other events are still delivered to their actors.
+	(&BridgeEventProducer[EventUserTyping]{
+		EventBridge: bridge,
+		Type:        BridgeUserTyping,
+		Log:         log,
+		Clock:       testClock(),
+	}).SendEvent(userCtx("observer"), "typing", EventUserTyping{ID: "typing"})
+	is.Equal(receive(observer).Type, string(BridgeUserTyping))
+}
+
+// blockingStorageMock is BridgeStorage which blocks on every stored
+// event until it is released.
+type blockingStorageMock struct {
+	release chan struct{}
+}
+
+func (s *blockingStorageMock) StoreEvent(ctx context.Context, evt BridgeEvent) error {
+	<-s.release
+	return nil
+}
+
+func TestBridgeQueue(t *testing.T) {
+	const queueSize = 2
+
+	// newBlockedBridge returns bridge, which event loop is blocked on
+	// storing the first event, so the next events stay in the queue.
+	newBlockedBridge := func(t *testing.T) (*Bridge, *blockingStorageMock) {
+		t.Helper()
+
+		storage := &blockingStorageMock{
+			release: make(chan struct{}),
+		}
+		bridge := NewBridge(context.Background(), BridgeBuilder{
+			Logger:    testLogger(),
+			Storage:   storage,
+			QueueSize: queueSize,
+		})
+
+		bridge.SendEvent(BridgeEvent{ID: "first"})
+		waitFor(t, time.Second, func() bool {
+			return bridge.queueDepth() == 0
+		})
+
+		return bridge, storage
+	}
+
+	t.Run("drop", func(t *testing.T) {
+		is := is.New(t)
+		ctx := context.Background()
+		bridge, storage := newBlockedBridge(t)
+
+		for i := 0; i < queueSize; i++ {
+			is.True(bridge.TrySendEvent(BridgeEvent{ID: strconv.Itoa(i)}))
+		}
+		is.Equal(bridge.queueDepth(), queueSize)
+
+		is.True(!bridge.TrySendEvent(BridgeEvent{ID: "dropped"}))
+		is.True(!bridge.TrySendEvent(BridgeEvent{ID: "dropped"}))
+		is.Equal(bridge.Dropped(), uint64(2))
+
+		close(storage.release)
+		bridge.Shutdown(ctx)
+	})
+
+	t.Run("block", func(t *testing.T) {
+		is := is.New(t)
+		ctx := context.Background()
+		bridge, storage := newBlockedBridge(t)
+
+		for i := 0; i < queueSize; i++ {
+			bridge.SendEvent(BridgeEvent{ID: strconv.Itoa(i)})
+		}
+
+		sent := make(chan struct{})
+		go func() {
+			bridge.SendEvent(BridgeEvent{ID: "blocked"})
+			close(sent)
+		}()
+
+		select {
+		case <-sent:
+			t.Fatal("event has been sent to the full queue")
+		case <-time.After(time.Millisecond * 50):
+		}
+
+		close(storage.release)
+		select {
+		case <-sent:
+		case <-time.After(time.Second):
+			t.Fatal("event has not been sent after queue was released")
+		}
+		is.Equal(bridge.Dropped(), uint64(0))
+
+		bridge.Shutdown(ctx)
+	})
+
+	t.Run("shutdown", func(t *testing.T) {
+		is := is.New(t)
+		ctx := context.Background()
+		bridge, storage := newBlockedBridge(t)
+
+		for i := 0; i < queueSize; i++ {
+			bridge.SendEvent(BridgeEvent{ID: strconv.Itoa(i)})
+		}
+
+		// Sender blocked on the full queue is waited for, before the
+		// queue is closed.
+		sent := make(chan struct{})
+		go func() {
+			bridge.SendEvent(BridgeEvent{ID: "blocked"})
+			close(sent)
+		}()
+		time.Sleep(time.Millisecond * 50)
+
+		shutdown := make(chan struct{})
+		go func() {
+			bridge.Shutdown(ctx)
+			close(shutdown)
+		}()
+		close(storage.release)
+
+		select {
+		case <-shutdown:
+		case <-time.After(time.Second):
+			t.Fatal("event bridge has not been shut down")
+		}
+		<-sent
+		is.True(!bridge.Alive())
+
+		// Events sent after shutdown are dropped.
+		bridge.SendEvent(BridgeEvent{ID: "late"})
+		is.True(!bridge.TrySendEvent(BridgeEvent{ID: "late"}))
+		bridge.Shutdown(ctx)
+	})
+}
+
+// failingStorageMock is BridgeStorage which fails to store any event.
+type failingStorageMock struct{}
+
+func (failingStorageMock) StoreEvent(ctx context.Context, evt BridgeEvent) error {
+	return errors.New("storage is down")
+}
+
+func TestBridgePersistence(t *testing.T) {
+	t.Run("stored exactly once before dispatch", func(t *testing.T) {
+		is := is.New(t)
+		ctx := context.Background()
+		const messages = 50
+
+		storage := newBridgeStorageMock()
+
+		mtx := &sync.Mutex{}
+		handled := map[string]int{}
+		storedBeforeDispatch := true
+		handler := BridgeEventHandlerFunc(func(ctx context.Context, evt BridgeEvent) {
+			stored := false
+			for _, e := range storage.Events() {
+				stored = stored || e.ID == evt.ID
+			}
+
+			mtx.Lock()
+			defer mtx.Unlock()
+			handled[evt.ID]++
+			if evt.Name == BridgeMessageSent && !stored {
+				storedBeforeDispatch = false
+			}
+		})
+
+		bridge := NewBridge(ctx, BridgeBuilder{
+			Handler: handler,
+			Logger:  testLogger(),
+			Storage: storage,
+		})
+
+		wg := sync.WaitGroup{}
+		for i := 0; i < messages; i++ {
+			i := i
+			goWithWaitGroup(&wg, func() {
+				bridge.SendEvent(BridgeEvent{
+					Name: BridgeMessageSent,
+					ID:   "msg" + strconv.Itoa(i),
+				})
+			})
+			goWithWaitGroup(&wg, func() {
+				bridge.SendEvent(BridgeEvent{
+					Name: BridgeUserTyping,
+					ID:   "typing" + strconv.Itoa(i),
+				})
+			})
+		}
+		wg.Wait()
+		bridge.Shutdown(ctx)
+
+		stored := map[string]int{}
+		for _, evt := range storage.Events() {
+			is.Equal(evt.Name, BridgeMessageSent) // only messages are stored
+			stored[evt.ID]++
+		}
+		is.Equal(len(stored), messages)
+		for id, n := range stored {
+			is.Equal(n, 1)           // message is stored exactly once
+			is.Equal(handled[id], 1) // message is handled exactly once
+		}
+		is.Equal(len(handled), messages*2)
+		is.True(storedBeforeDispatch)
+	})
+
+	t.Run("storage failure", func(t *testing.T) {
+		is := is.New(t)
+		ctx := context.Background()
+
+		handled := make(chan BridgeEvent, 1)
+		bridge := NewBridge(ctx, BridgeBuilder{
+			Handler: BridgeEventHandlerFunc(func(ctx context.Context, evt BridgeEvent) {
+				handled <- evt
+			}),
+			Logger:  testLogger(),
+			Storage: failingStorageMock{},
+		})
+
+		bridge.SendEvent(BridgeEvent{Name: BridgeMessageSent, ID: "msg"})
+		bridge.Shutdown(ctx)
+
+		is.Equal((<-handled).ID, "msg")
+	})
+
+	t.Run("custom predicate", func(t *testing.T) {
+		is := is.New(t)
+		ctx := context.Background()
+
+		storage := newBridgeStorageMock()
+		bridge := NewBridge(ctx, BridgeBuilder{
+			Logger:  testLogger(),
+			Storage: storage,
+			Persist: func(t BridgeEventType) bool {
+				return t == BridgeUserTyping
+			},
+		})
+
+		bridge.SendEvent(BridgeEvent{Name: BridgeMessageSent, ID: "msg"})
+		bridge.SendEvent(BridgeEvent{Name: BridgeUserTyping, ID: "typing"})
+		bridge.Shutdown(ctx)
+
+		evts := storage.Events()
+		is.Equal(len(evts), 1)
+		is.Equal(evts[0].ID, "typing")
+	})
+}
+
+func TestPersistPolicy(t *testing.T) {
+	type testArgs struct {
+		name   string
+		policy PersistPolicy
+		stored []BridgeEventType
+	}
+
+	all := []BridgeEventType{
+		BridgeMessageSent,
+		BridgeMessageEdited,
+		BridgeMessageDeleted,
+		BridgeUserJoin,
+		BridgeUserLeft,
+		BridgeUserTyping,
+		BridgeUserPresence,
+		BridgeUserMuted,
+	}
+
+	scenario := func(tt testArgs) (string, func(*testing.T)) {
+		return tt.name, func(t *testing.T) {
+			is := is.New(t)
+			ctx := context.Background()
+
+			storage := newBridgeStorageMock()
+			bridge := NewBridge(ctx, BridgeBuilder{
+				Logger:  testLogger(),
+				Storage: storage,
+				Persist: tt.policy.Persist,
+				Ordered: true,
+			})
+
+			for _, name := range all {
+				bridge.SendEvent(BridgeEvent{Name: name, ID: string(name)})
+			}
+			bridge.Shutdown(ctx)
+
+			stored := []BridgeEventType{}
+			for _, evt := range storage.Events() {
+				stored = append(stored, evt.Name)
+			}
+			is.Equal(stored, tt.stored)
+		}
+	}
+
+	t.Run(scenario(testArgs{
+		name:   "default",
+		policy: PersistPolicyDefault(),
+		stored: []BridgeEventType{
+			BridgeMessageSent,
+			BridgeMessageEdited,
+			BridgeMessageDeleted,
+			BridgeUserMuted,
+		},
+	}))
+	t.Run(scenario(testArgs{
+		name: "presence audit",
+		policy: PersistPolicy{
+			BridgeUserJoin:     true,
+			BridgeUserLeft:     true,
+			BridgeUserPresence: true,
+		},
+		stored: []BridgeEventType{
+			BridgeMessageSent,
+			BridgeMessageEdited,
+			BridgeMessageDeleted,
+			BridgeUserJoin,
+			BridgeUserLeft,
+			BridgeUserPresence,
+			BridgeUserMuted,
+		},
+	}))
+	t.Run(scenario(testArgs{
+		name: "skip messages",
+		policy: PersistPolicy{
+			BridgeMessageSent:    false,
+			BridgeMessageEdited:  false,
+			BridgeMessageDeleted: false,
+		},
+		stored: []BridgeEventType{
+			BridgeUserJoin,
+			BridgeUserLeft,
+			BridgeUserMuted,
+		},
+	}))
+	t.Run(scenario(testArgs{
+		name:   "empty",
+		policy: PersistPolicy{},
+		stored: []BridgeEventType{
+			BridgeMessageSent,
+			BridgeMessageEdited,
+			BridgeMessageDeleted,
+			BridgeUserJoin,
+			BridgeUserLeft,
+			BridgeUserMuted,
+		},
+	}))
+}
+
+func TestBridgeStats(t *testing.T) {
+	t.Run("processed", func(t *testing.T) {
+		is := is.New(t)
+		ctx := context.Background()
+		const events = 20
+
+		metrics := NewMetrics()
+		bridge := NewBridge(ctx, BridgeBuilder{
+			Handler: BridgeEventHandlerFunc(func(ctx context.Context, evt BridgeEvent) {}),
+			Logger:  testLogger(),
+			Storage: newBridgeStorageMock(),
+			Metrics: metrics,
+		})
+
+		for i := 0; i < events; i++ {
+			bridge.SendEvent(BridgeEvent{Name: BridgeMessageSent, ID: strconv.Itoa(i)})
+		}
+		bridge.Shutdown(ctx)
+
+		is.Equal(bridge.Stats(), BridgeStats{Processed: events})
+		is.Equal(scrapeMetric(t, metrics, "szmaterlok_bridge_processed_events_total"), "20")
+		is.Equal(scrapeMetric(t, metrics, "szmaterlok_bridge_in_flight_events"), "0")
+	})
+
+	t.Run("without handler", func(t *testing.T) {
+		is := is.New(t)
+		ctx := context.Background()
+
+		bridge := NewBridge(ctx, BridgeBuilder{
+			Logger:  testLogger(),
+			Storage: newBridgeStorageMock(),
+		})
+		bridge.SendEvent(BridgeEvent{Name: BridgeMessageSent, ID: "msg"})
+		bridge.Shutdown(ctx)
+
+		is.Equal(bridge.Stats().Processed, uint64(1))
+	})
+
+	t.Run("in flight", func(t *testing.T) {
+		is := is.New(t)
+		ctx := context.Background()
+
+		release := make(chan struct{})
+		bridge := NewBridge(ctx, BridgeBuilder{
+			Handler: BridgeEventHandlerFunc(func(ctx context.Context, evt BridgeEvent) {
+				<-release
+			}),
+			Logger:  testLogger(),
+			Storage: newBridgeStorageMock(),
+		})
+
+		for i := 0; i < 3; i++ {
+			bridge.SendEvent(BridgeEvent{Name: BridgeUserTyping, ID: strconv.Itoa(i)})
+		}
+		waitFor(t, time.Second, func() bool {
+			return bridge.Stats().InFlight == 3
+		})
+		is.Equal(bridge.Stats().Processed, uint64(0))
+
+		close(release)
+		bridge.Shutdown(ctx)
+
+		stats := bridge.Stats()
+		is.Equal(stats.InFlight, int64(0))
+		is.Equal(stats.Processed, uint64(3))
+	})
+
+	t.Run("queue", func(t *testing.T) {
+		is := is.New(t)
+		ctx := context.Background()
+
+		// Ordered bridge holds back events in the queue, until slow
+		// handler finishes.
+		release := make(chan struct{})
+		bridge := NewBridge(ctx, BridgeBuilder{
+			Handler: BridgeEventHandlerFunc(func(ctx context.Context, evt BridgeEvent) {
+				<-release
+			}),
+			Logger:    testLogger(),
+			Storage:   newBridgeStorageMock(),
+			QueueSize: 4,
+			Ordered:   true,
+		})
+
+		bridge.SendEvent(BridgeEvent{Name: BridgeUserTyping, ID: "slow"})
+		waitFor(t, time.Second, func() bool {
+			return bridge.Stats().InFlight == 1
+		})
+		bridge.SendEvent(BridgeEvent{Name: BridgeUserTyping, ID: "1"})
+		bridge.SendEvent(BridgeEvent{Name: BridgeUserTyping, ID: "2"})
+
+		is.Equal(bridge.Stats(), BridgeStats{
+			QueueLength:   2,
+			QueueCapacity: 4,
+			InFlight:      1,
+		})
+
+		close(release)
+		bridge.Shutdown(ctx)
+
+		is.Equal(bridge.Stats(), BridgeStats{
+			QueueCapacity: 4,
+			Processed:     3,
+		})
+	})
+}
+
+func TestBridgeEventRouterPanic(t *testing.T) {
+	type testArgs struct {
+		name      string
+		panicking BridgeEventType
+	}
+
+	scenario := func(tt testArgs) (string, func(*testing.T)) {
+		return tt.name, func(t *testing.T) {
+			is := is.New(t)
+			ctx := context.Background()
+
+			mtx := &sync.Mutex{}
+			handled := 0
+			counter := BridgeEventHandlerFunc(func(ctx context.Context, evt BridgeEvent) {
+				mtx.Lock()
+				defer mtx.Unlock()
+				handled++
+			})
+
+			deadLetters := []BridgeEvent{}
+			router := NewBridgeEventRouter()
+			router.Logger = testLogger()
+			router.DeadLetter = DeadLetterHandlerFunc(func(ctx context.Context, evt BridgeEvent, reason error) {
+				mtx.Lock()
+				defer mtx.Unlock()
+				deadLetters = append(deadLetters, evt)
+			})
+
+			router.Hook(BridgeMessageSent, counter)
+			router.Hook(BridgeEventGlob, counter)
+			router.Hook(tt.panicking, BridgeEventHandlerFunc(func(ctx context.Context, evt BridgeEvent) {
+				panic("misbehaving hook")
+			}))
+			router.Hook(BridgeMessageSent, counter)
+
+			bridge := NewBridge(ctx, BridgeBuilder{
+				Handler: router,
+				Logger:  testLogger(),
+				Storage: newBridgeStorageMock(),
+			})
+			bridge.SendEvent(BridgeEvent{Name: BridgeMessageSent, ID: "first"})
+			bridge.SendEvent(BridgeEvent{Name: BridgeMessageSent, ID: "second"})
+			bridge.Shutdown(ctx)
+
+			// Every well-behaved hook has handled both events.
+			is.Equal(handled, 6)
+
+			is.Equal(len(deadLetters), 2)
+			ids := []string{deadLetters[0].ID, deadLetters[1].ID}
+			sort.Strings(ids)
+			is.Equal(ids, []string{"first", "second"})
+		}
+	}
+
+	t.Run(scenario(testArgs{
+		name:      "event type hook",
+		panicking: BridgeMessageSent,
+	}))
+	t.Run(scenario(testArgs{
+		name:      "glob hook",
+		panicking: BridgeEventGlob,
+	}))
+}
+
+func TestBridgeEventRouterTimeout(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+
+	mtx := &sync.Mutex{}
+	handled := []string{}
+	deadLetters := []error{}
+
+	router := NewBridgeEventRouter()
+	router.Logger = testLogger()
+	router.Timeout = time.Millisecond * 50
+	router.DeadLetter = DeadLetterHandlerFunc(func(ctx context.Context, evt BridgeEvent, reason error) {
+		mtx.Lock()
+		defer mtx.Unlock()
+		deadLetters = append(deadLetters, reason)
+	})
+
+	// Slow hook observes cancellation, but it doesn't return until
+	// it's released.
+	release := make(chan struct{})
+	cancelled := make(chan error, 2)
+	router.Hook(BridgeMessageSent, BridgeEventHandlerFunc(func(ctx context.Context, evt BridgeEvent) {
+		<-ctx.Done()
+		cancelled <- ctx.Err()
+		<-release
+	}))
+	router.Hook(BridgeMessageSent, BridgeEventHandlerFunc(func(ctx context.Context, evt BridgeEvent) {
+		mtx.Lock()
+		defer mtx.Unlock()
+		handled = append(handled, evt.ID)
+	}))
+
+	// Ordered bridge dispatches next event only after the previous
+	// one has been handled.
+	bridge := NewBridge(ctx, BridgeBuilder{
+		Handler: router,
+		Logger:  testLogger(),
+		Storage: newBridgeStorageMock(),
+		Ordered: true,
+	})
+	bridge.SendEvent(BridgeEvent{Name: BridgeMessageSent, ID: "first"})
+	bridge.SendEvent(BridgeEvent{Name: BridgeMessageSent, ID: "second"})
+
+	waitFor(t, time.Second, func() bool {
+		mtx.Lock()
+		defer mtx.Unlock()
+		return len(handled) == 2 && len(deadLetters) == 2
+	})
+	is.Equal(<-cancelled, context.DeadlineExceeded)
+	is.Equal(<-cancelled, context.DeadlineExceeded)
+
+	mtx.Lock()
+	is.Equal(handled, []string{"first", "second"})
+	is.True(strings.Contains(deadLetters[0].Error(), "timed out"))
+	mtx.Unlock()
+
+	close(release)
+	bridge.Shutdown(ctx)
+}
+
+func TestBridgeCancel(t *testing.T) {
+	type testArgs struct {
+		name     string
+		policy   BridgeCancelPolicy
+		expected []string
+	}
+
+	scenario := func(tt testArgs) (string, func(*testing.T)) {
+		return tt.name, func(t *testing.T) {
+			is := is.New(t)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			mtx := &sync.Mutex{}
+			handled := []string{}
+			storage := &blockingStorageMock{
+				release: make(chan struct{}),
+			}
+			bridge := NewBridge(ctx, BridgeBuilder{
+				Handler: BridgeEventHandlerFunc(func(ctx context.Context, evt BridgeEvent) {
+					mtx.Lock()
+					defer mtx.Unlock()
+					handled = append(handled, evt.ID)
+				}),
+				Logger:    testLogger(),
+				Storage:   storage,
+				QueueSize: 2,
+				OnCancel:  tt.policy,
+			})
+
+			// Event loop is blocked on storing the first event, so
+			// the next ones are left in the queue.
+			bridge.SendEvent(BridgeEvent{ID: "first"})
+			waitFor(t, time.Second, func() bool {
+				return bridge.queueDepth() == 0
+			})
+			bridge.SendEvent(BridgeEvent{ID: "second"})
+			bridge.SendEvent(BridgeEvent{ID: "third"})
+
+			cancel()
+			close(storage.release)
+
+			// Event loop stops without Shutdown being called.
+			waitFor(t, time.Second, func() bool {
+				return !bridge.Alive()
+			})
+
+			// Senders don't block, when nobody reads the queue.
+			sent := make(chan struct{})
+			go func() {
+				for i := 0; i < 3; i++ {
+					bridge.SendEvent(BridgeEvent{ID: "late"})
+				}
+				close(sent)
+			}()
+			select {
+			case <-sent:
+			case <-time.After(time.Second):
+				t.Fatal("event has been blocked on stopped event bridge")
+			}
+
+			mtx.Lock()
+			defer mtx.Unlock()
+			sort.Strings(handled)
+			is.Equal(handled, tt.expected)
+		}
+	}
+
+	t.Run(scenario(testArgs{
+		name:     "drain",
+		policy:   BridgeCancelDrain,
+		expected: []string{"first", "second", "third"},
+	}))
+	t.Run(scenario(testArgs{
+		name:     "discard",
+		policy:   BridgeCancelDiscard,
+		expected: []string{"first"},
+	}))
+}
+
+func TestBridgeOrdered(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+	const events = 500
+
+	// Handler accesses slice without lock on purpose: ordered
+	// dispatch never runs it concurrently.
+	handled := []string{}
+	bridge := NewBridge(ctx, BridgeBuilder{
+		Handler: BridgeEventHandlerFunc(func(ctx context.Context, evt BridgeEvent) {
+			handled = append(handled, evt.ID)
+		}),
+		Logger:    testLogger(),
+		Storage:   newBridgeStorageMock(),
+		QueueSize: 16,
+		Ordered:   true,
+	})
+
+	sent := make([]string, 0, events)
+	for i := 0; i < events; i++ {
+		id := strconv.Itoa(i)
+		sent = append(sent, id)
+		bridge.SendEvent(BridgeEvent{Name: BridgeMessageSent, ID: id})
+	}
+	bridge.Shutdown(ctx)
+
+	is.Equal(handled, sent)
+}
+
+func TestBridgeSequence(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+	const events = 100
+
+	storage := newBridgeStorageMock()
+	handled := make(chan BridgeEvent, events)
+	bridge := NewBridge(ctx, BridgeBuilder{
+		Handler: BridgeEventHandlerFunc(func(ctx context.Context, evt BridgeEvent) {
+			handled <- evt
+		}),
+		Logger:   testLogger(),
+		Storage:  storage,
+		Sequence: 41,
+	})
+
+	for i := 0; i < events; i++ {
+		bridge.SendEvent(BridgeEvent{Name: BridgeMessageSent, ID: strconv.Itoa(i)})
+	}
+	bridge.Shutdown(ctx)
+	close(handled)
+
+	// Events are numbered in the order they were sent, after the
+	// last sequence number given to the bridge.
+	for i, evt := range storage.Events() {
+		is.Equal(evt.ID, strconv.Itoa(i))
+		is.Equal(evt.Sequence, uint64(i+42))
+	}
+
+	// Handlers see the same numbers, even when they run concurrently.
+	count := 0
+	for evt := range handled {
+		id, err := strconv.Atoi(evt.ID)
+		is.NoErr(err)
+		is.Equal(evt.Sequence, uint64(id+42))
+		count++
+	}
+	is.Equal(count, events)
+}
+
+// bridgeSequenceStoreMock records reserved sequence numbers. It fails
+// first reservations, when fail is set.
+type bridgeSequenceStoreMock struct {
+	fail     int
+	reserved []uint64
+}
+
+func (m *bridgeSequenceStoreMock) ReserveSequence(ctx context.Context, sequence uint64) error {
+	if m.fail > 0 {
+		m.fail--
+		return errors.New("storage is down")
+	}
+
+	m.reserved = append(m.reserved, sequence)
+	return nil
+}
+
+func TestBridgeSequenceReserve(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+
+	sequences := &bridgeSequenceStoreMock{fail: 1}
+	bridge := NewBridge(ctx, BridgeBuilder{
+		Logger:    testLogger(),
+		Storage:   newBridgeStorageMock(),
+		Sequence:  41,
+		Sequences: sequences,
+	})
+
+	for i := 0; i < bridgeSequenceBlock+2; i++ {
+		bridge.SendEvent(BridgeEvent{Name: BridgeUserTyping, ID: strconv.Itoa(i)})
+	}
+	bridge.Shutdown(ctx)
+
+	// Failed reservation is retried with the next event, and the next
+	// block is reserved only after the reserved one is used up.
+	is.Equal(sequences.reserved, []uint64{
+		43 + bridgeSequenceBlock - 1,
+		43 + bridgeSequenceBlock*2 - 1,
+	})
+}
+
+func TestBridgeMessageHandlerSlowClient(t *testing.T) {
+	type testArgs struct {
+		name   string
+		policy SlowClientPolicy
+
+		// subscribers is expected number of subscribers after
+		// events have been sent.
+		subscribers int
+		dropped     string
+	}
+
+	scenario := func(tt testArgs) (string, func(*testing.T)) {
+		return tt.name, func(t *testing.T) {
+			is := is.New(t)
+			ctx := context.Background()
+			metrics := NewMetrics()
+
+			h := NewBridgeMessageHandler(testLogger())
+			h.SlowClient = tt.policy
+			h.Metrics = metrics
+
+			// Blocked client never reads its events.
+			blocked := make(chan sse.Event, 1)
+			h.Subscribe(ctx, MessageSubscribeRequest{
+				ID:        "blocked",
+				RequestID: "req1",
+				Channel:   blocked,
+			})
+
+			other := make(chan sse.Event, 3)
+			h.Subscribe(ctx, MessageSubscribeRequest{
+				ID:        "other",
+				RequestID: "req2",
+				Channel:   other,
+			})
+
+			for _, id := range []string{"1", "2", "3"} {
+				done := make(chan struct{})
+				go func() {
+					defer close(done)
+					h.EventHook(ctx, BridgeEvent{
+						Name: BridgeUserTyping,
+						ID:   id,
+						Headers: BridgeHeaders{
+							bridgeContentTypeHeaderVar: contentTypeApplicationJSON,
+						},
+						Data: []byte("{}"),
+					})
+				}()
+
+				select {
+				case <-done:
+				case <-time.After(time.Second):
+					t.Fatal("event hook has been blocked by slow client")
+				}
+			}
+
+			is.Equal(len(other), 3)
+			is.Equal(len(h.ActiveSubscribers()), tt.subscribers)
+			is.Equal(scrapeMetric(t, metrics, "szmaterlok_sse_dropped_events_total"), tt.dropped)
+
+			// The first event fits into the buffer of blocked client.
+			is.Equal((<-blocked).ID, "1")
+		}
+	}
+
+	t.Run(scenario(testArgs{
+		name:        "drop",
+		policy:      SlowClientDrop,
+		subscribers: 2,
+		dropped:     "2",
+	}))
+	t.Run(scenario(testArgs{
+		name:        "disconnect",
+		policy:      SlowClientDisconnect,
+		subscribers: 1,
+
+		// Disconnected client isn't counted for the next events.
+		dropped: "1",
+	}))
+}
+
+func TestBridgeMessageHandlerConcurrency(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+
+	h := NewBridgeMessageHandler(testLogger())
+	h.SlowClient = SlowClientDisconnect
+
+	const (
+		clients = 20
+		events  = 50
+	)
+
+	hook := func(id int) {
+		h.EventHook(ctx, BridgeEvent{
+			Name: BridgeUserTyping,
+			ID:   strconv.Itoa(id),
+			Headers: BridgeHeaders{
+				bridgeContentTypeHeaderVar: contentTypeApplicationJSON,
+			},
+			Data: []byte("{}"),
+		})
+	}
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < clients; i++ {
+		i := i
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			// Every client subscribes a few times and either
+			// unsubscribes or is disconnected by the server, while
+			// events are delivered.
+			for j := 0; j < 5; j++ {
+				id := strconv.Itoa(i)
+				evts := make(chan sse.Event, 1)
+				unsubscribe := h.Subscribe(ctx, MessageSubscribeRequest{
+					ID:        id,
+					RequestID: id + "-" + strconv.Itoa(j),
+					Channel:   evts,
+				})
+
+				hook(i*events + j)
+				if j%2 == 0 {
+					h.Disconnect(id)
+				}
+				unsubscribe()
+
+				// Channel is closed in both cases.
+				for range evts {
+				}
+			}
+		}()
+	}
+
+	for i := 0; i < events; i++ {
+		i := i
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			hook(i)
+		}()
+	}
+
+	wg.Wait()
+	is.Equal(len(h.ActiveSubscribers()), 0)
+}
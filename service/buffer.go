@@ -3,7 +3,10 @@ package service
 import (
 	"context"
 	"encoding/json"
+	"io"
 	"net/http"
+	"sort"
+	"strconv"
 	"sync"
 
 	"github.com/sirupsen/logrus"
@@ -52,13 +55,79 @@ func (mb *MessageCircularBuffer) PushEvent(ctx context.Context, evt EventSentMes
 	mb.head = mb.head.next
 }
 
-// BufferedEvents returns all of events stored in the buffer.
+// RemoveEvent removes event with given ID from the buffer. It reports
+// whether such event has been found.
+func (mb *MessageCircularBuffer) RemoveEvent(ctx context.Context, id string) bool {
+	mb.mtx.Lock()
+	defer mb.mtx.Unlock()
+
+	curr := mb.head
+	for {
+		if curr.value != nil && curr.value.ID == id {
+			curr.value = nil
+			return true
+		}
+
+		if curr.next == mb.head {
+			return false
+		}
+
+		curr = curr.next
+	}
+}
+
+// EditEvent overwrites content of event with given ID with content,
+// HTML and mentions of given edit. It reports whether such event has been found.
+func (mb *MessageCircularBuffer) EditEvent(ctx context.Context, edit EventMessageEdited) bool {
+	mb.mtx.Lock()
+	defer mb.mtx.Unlock()
+
+	curr := mb.head
+	for {
+		if curr.value != nil && curr.value.ID == edit.MessageID {
+			curr.value.Content = edit.Content
+			curr.value.HTML = edit.HTML
+			curr.value.Mentions = edit.Mentions
+			return true
+		}
+
+		if curr.next == mb.head {
+			return false
+		}
+
+		curr = curr.next
+	}
+}
+
+// Clear removes all of events from the buffer. Buffer keeps its size,
+// so it can be used again.
+func (mb *MessageCircularBuffer) Clear(ctx context.Context) {
+	mb.mtx.Lock()
+	defer mb.mtx.Unlock()
+
+	curr := mb.head
+	for {
+		curr.value = nil
+
+		if curr.next == mb.head {
+			return
+		}
+
+		curr = curr.next
+	}
+}
+
+// BufferedEvents returns all of events stored in the buffer in their
+// insertion order.
 func (mb *MessageCircularBuffer) BufferedEvents(ctx context.Context) []EventSentMessage {
 	mb.mtx.Lock()
 	defer mb.mtx.Unlock()
 
 	res := []EventSentMessage{}
 
+	// Head is the next node to be overwritten, so it is either the
+	// oldest populated node or it is followed by empty nodes and then
+	// by the oldest one.
 	curr := mb.head
 	for {
 		if curr.value != nil {
@@ -75,18 +144,67 @@ func (mb *MessageCircularBuffer) BufferedEvents(ctx context.Context) []EventSent
 	return res
 }
 
-// LastMessagesBuffer keeps fixed number of messages that can be
-// send to users to give them a little brief overview about current
-// discussion.
+// LastMessagesBuffer keeps fixed number of messages for every chat
+// channel that can be send to users to give them a little brief
+// overview about current discussion.
 type LastMessagesBuffer struct {
-	buffer *MessageCircularBuffer
-	log    *logrus.Logger
+	size    int
+	buffers map[string]*MessageCircularBuffer
+	mtx     *sync.Mutex
+	log     *logrus.Logger
 }
 
-// NewLastMessagesBuffer returns last message buffer of given size.
+// NewLastMessagesBuffer returns last message buffer, which keeps given
+// number of messages for every chat channel. Errors are logged with
+// given logger. They're discarded when it's nil.
 func NewLastMessagesBuffer(size int, log *logrus.Logger) *LastMessagesBuffer {
+	if log == nil {
+		log = logrus.New()
+		log.SetOutput(io.Discard)
+	}
+
 	return &LastMessagesBuffer{
-		buffer: NewMessageCircularBuffer(size),
+		size:    size,
+		buffers: make(map[string]*MessageCircularBuffer),
+		mtx:     &sync.Mutex{},
+		log:     log,
+	}
+}
+
+// lastMessagesChannelsMax is maximal number of chat channels, which
+// have their messages buffered. Messages sent to other channels aren't
+// buffered.
+const lastMessagesChannelsMax = 1024
+
+// channelBuffer returns circular buffer for given chat channel. Buffers
+// are created lazily, when create is true. It returns nil for unknown
+// chat channel, when create is false or there are too many buffered
+// chat channels.
+func (b *LastMessagesBuffer) channelBuffer(channel string, create bool) *MessageCircularBuffer {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	channel = ChatChannelOrDefault(channel)
+	buffer, ok := b.buffers[channel]
+	if ok {
+		return buffer
+	}
+	if !create || len(b.buffers) >= lastMessagesChannelsMax {
+		return nil
+	}
+
+	buffer = NewMessageCircularBuffer(b.size)
+	b.buffers[channel] = buffer
+	return buffer
+}
+
+// Clear removes messages of every chat channel from the buffer.
+func (b *LastMessagesBuffer) Clear(ctx context.Context) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	for _, buffer := range b.buffers {
+		buffer.Clear(ctx)
 	}
 }
 
@@ -100,43 +218,70 @@ func findEventByID(target string, items []EventSentMessage) (int, bool) {
 	return 0, false
 }
 
-// LastMessages returns all messages stored in LastMessagesBuffer that happened
-// after event with given last message ID.
-func (b *LastMessagesBuffer) LastMessages(ctx context.Context, lastMessageID string) []EventSentMessage {
-	items := b.buffer.BufferedEvents(ctx)
+// LastMessages returns messages from given chat channel stored in
+// LastMessagesBuffer, ordered by their sequence numbers. Messages without
+// sequence numbers come first in chronological order, and messages sent
+// at the same time are ordered by their IDs.
+//
+// Last event ID is either sequence number or ID of message. For sequence
+// number, only messages with greater sequence numbers are returned. For
+// message ID found in the buffer, only messages which come after it are
+// returned. No messages are returned for unknown chat channel.
+func (b *LastMessagesBuffer) LastMessages(ctx context.Context, channel, lastMessageID string) []EventSentMessage {
+	buffer := b.channelBuffer(channel, false)
+	if buffer == nil {
+		return []EventSentMessage{}
+	}
+
+	items := buffer.BufferedEvents(ctx)
+	sort.SliceStable(items, func(i, j int) bool {
+		if items[i].Sequence != items[j].Sequence {
+			return items[i].Sequence < items[j].Sequence
+		}
+		if items[i].SentAt.Equal(items[j].SentAt) {
+			return items[i].ID < items[j].ID
+		}
+		return items[i].SentAt.Before(items[j].SentAt)
+	})
 
 	if lastMessageID == "" {
 		return items
 	}
 
+	if sequence, err := strconv.ParseUint(lastMessageID, 10, 64); err == nil {
+		target := sort.Search(len(items), func(i int) bool {
+			return items[i].Sequence > sequence
+		})
+		return items[target:]
+	}
+
 	target, ok := findEventByID(lastMessageID, items)
 	if !ok {
 		return items
 	}
 
-	res := []EventSentMessage{}
-	for i, item := range items {
-		if i == target {
-			continue
-		}
-		if item.SentAt.After(items[i].SentAt) {
-			continue
-		}
-
-		res = append(res, item)
-	}
-
-	return res
+	return items[target+1:]
 }
 
 // EventHook listens for message-sent events and appends them to the
-// last messages circular buffer.
+// last messages circular buffer. It also listens for message-edited
+// and message-deleted events, so reconnecting clients receive current
+// content of messages and never receive deleted ones.
 func (b *LastMessagesBuffer) EventHook(ctx context.Context, evt BridgeEvent) {
+	switch evt.Name {
+	case BridgeMessageEdited:
+		b.editHook(ctx, evt)
+		return
+	case BridgeMessageDeleted:
+		b.deleteHook(ctx, evt)
+		return
+	}
+
 	evtData := EventSentMessage{}
 
 	if err := json.Unmarshal(evt.Data, &evtData); err != nil {
 		b.log.WithFields(logrus.Fields{
-			"scope":   "StateUserJoinHook",
+			"scope":   "LastMessagesBuffer.EventHook",
 			"reqID":   evt.Headers.Get(bridgeRequestIDHeaderVar),
 			"eventID": evt.ID,
 			"error":   err.Error(),
@@ -144,15 +289,115 @@ func (b *LastMessagesBuffer) EventHook(ctx context.Context, evt BridgeEvent) {
 		return
 	}
 
-	b.buffer.PushEvent(ctx, evtData)
+	// Direct messages are private, so they're never replayed.
+	if evtData.To != nil {
+		return
+	}
+	evtData.Sequence = evt.Sequence
+
+	buffer := b.channelBuffer(evtData.Channel, true)
+	if buffer == nil {
+		b.log.WithFields(logrus.Fields{
+			"scope":   "LastMessagesBuffer.EventHook",
+			"reqID":   evt.Headers.Get(bridgeRequestIDHeaderVar),
+			"eventID": evt.ID,
+			"channel": evtData.Channel,
+		}).Warnln("Too many chat channels, message won't be buffered.")
+		return
+	}
+
+	buffer.PushEvent(ctx, evtData)
+}
+
+func (b *LastMessagesBuffer) editHook(ctx context.Context, evt BridgeEvent) {
+	evtData := EventMessageEdited{}
+
+	if err := json.Unmarshal(evt.Data, &evtData); err != nil {
+		b.log.WithFields(logrus.Fields{
+			"scope":   "LastMessagesBuffer.EventHook",
+			"reqID":   evt.Headers.Get(bridgeRequestIDHeaderVar),
+			"eventID": evt.ID,
+			"error":   err.Error(),
+		}).Errorln("Failed to unmarshal EventMessageEdited data.")
+		return
+	}
+
+	if buffer := b.channelBuffer(evtData.Channel, false); buffer != nil {
+		buffer.EditEvent(ctx, evtData)
+	}
+}
+
+func (b *LastMessagesBuffer) deleteHook(ctx context.Context, evt BridgeEvent) {
+	evtData := EventMessageDeleted{}
+
+	if err := json.Unmarshal(evt.Data, &evtData); err != nil {
+		b.log.WithFields(logrus.Fields{
+			"scope":   "LastMessagesBuffer.EventHook",
+			"reqID":   evt.Headers.Get(bridgeRequestIDHeaderVar),
+			"eventID": evt.ID,
+			"error":   err.Error(),
+		}).Errorln("Failed to unmarshal EventMessageDeleted data.")
+		return
+	}
+
+	if buffer := b.channelBuffer(evtData.Channel, false); buffer != nil {
+		buffer.RemoveEvent(ctx, evtData.MessageID)
+	}
+}
+
+// StreamReady is SSE event type sent to the client right after
+// buffered messages, when its event stream is established.
+const StreamReady = "ready"
+
+// EventStreamReady is data of event sent to the client, when its event
+// stream is established.
+type EventStreamReady struct {
+	// User is chat user of the client.
+	User ChatUser `json:"user"`
+
+	// Online is number of online users including the client.
+	Online int `json:"online"`
+
+	// LastAcked is sequence number of the last message acknowledged
+	// by the client's session. It's omitted, when session hasn't
+	// acknowledged any message.
+	LastAcked uint64 `json:"lastAcked,omitempty"`
+}
+
+// UserList is SSE event type sent to the client with snapshot of
+// online users, when its event stream is established.
+const UserList = "user-list"
+
+// EventUserList is data of event with snapshot of online users.
+type EventUserList struct {
+	// Users are online users sorted by their nicknames. They include
+	// the client.
+	Users []OnlineChatUser `json:"users"`
 }
 
 // MessageNotifierWithBuffer is adapter for MessageNotifier which
-// sends messages from last messages buffer to subscribed clients.
+// sends messages from last messages buffer to subscribed clients,
+// followed by ready event and snapshot of online users.
 type MessageNotifierWithBuffer struct {
 	Notifier MessageNotifier
 	Buffer   *LastMessagesBuffer
 	Logger   *logrus.Logger
+
+	// Users are sent in user list event and counted in ready event.
+	// Both are skipped when it's nil.
+	Users AllChatUsersStore
+
+	// Cursors turn sequence numbers of events into signed resume
+	// cursors, which are sent as event IDs. Only valid cursors are
+	// accepted as last event ID then. Sequence numbers are sent as
+	// they are, when it's nil.
+	Cursors *ResumeCursorCodec
+
+	// Acks hold messages acknowledged by sessions. Resuming clients
+	// receive messages after the last acknowledged one, rather than
+	// after their last event ID. Acknowledgements are ignored, when
+	// it's nil.
+	Acks *MessageAcks
 }
 
 type contextLastEventIDKey int
@@ -174,10 +419,40 @@ func contextLastEventID(ctx context.Context) string {
 
 // Subscribe given ID for SSE events. Returns unsubscribe func.
 func (m *MessageNotifierWithBuffer) Subscribe(ctx context.Context, args MessageSubscribeRequest) func() {
-	lastEventID := contextLastEventID(ctx)
+	lastEventID := m.resumePoint(ctx, args)
+
+	// transientChan is bridge between channel created by client
+	// and channel created in this method. This way we can be sure
+	// that client will first receive buffered events and then
+	// the new ones. It's as big as client channel, so the client
+	// is given the same room for slow reads.
+	//
+	// Transient channel is owned by the underlying notifier, which
+	// closes it after it stops sending. Client channel is owned by
+	// the transient goroutine: it's the only one sending to it, so
+	// it closes the channel on its way out.
+	transientChan := make(chan sse.Event, cap(args.Channel))
+
+	// Client is subscribed before buffered messages and online users
+	// are read, so events fired in the meantime aren't lost. Events
+	// already reflected in them are skipped by dedupe.
+	unsubscribe := m.Notifier.Subscribe(ctx, MessageSubscribeRequest{
+		ID:          args.ID,
+		Nickname:    args.Nickname,
+		ChatChannel: args.ChatChannel,
+		RequestID:   args.RequestID,
+		Channel:     transientChan,
+	})
+	if unsubscribe == nil {
+		close(args.Channel)
+		return nil
+	}
 
-	buffered := m.Buffer.LastMessages(ctx, lastEventID)
-	tmpChan := make(chan sse.Event, len(buffered))
+	buffered := m.Buffer.LastMessages(ctx, args.ChatChannel, lastEventID)
+	tmpChan := make(chan sse.Event, len(buffered)+2)
+	dedupe := &streamDedupe{
+		messages: make(map[string]bool, len(buffered)),
+	}
 
 	for _, msg := range buffered {
 		b, err := json.Marshal(msg)
@@ -186,27 +461,69 @@ func (m *MessageNotifierWithBuffer) Subscribe(ctx context.Context, args MessageS
 			continue
 		}
 
+		id := streamEventID(msg.ID, msg.Sequence)
+		dedupe.messages[id] = true
 		tmpChan <- sse.Event{
 			Type: MessageSent,
 			Data: b,
-			ID:   msg.ID,
+			ID:   id,
+		}
+	}
+
+	// User list and ready events have no ID, so they don't change
+	// last event ID of the client.
+	users := m.onlineUsers(ctx, args)
+	ready := EventStreamReady{
+		User:   UserPresentation(args.ID, args.Nickname),
+		Online: len(users),
+	}
+	ready.LastAcked, _ = m.Acks.LastAcked(ctx, args.ID, args.ChatChannel)
+	if ready, err := json.Marshal(ready); err != nil {
+		m.Logger.WithField("subID", args.ID).Error("Failed to marshal ready event.")
+	} else {
+		tmpChan <- sse.Event{
+			Type: StreamReady,
+			Data: ready,
+		}
+	}
+
+	if users != nil {
+		if list, err := json.Marshal(EventUserList{Users: users}); err != nil {
+			m.Logger.WithField("subID", args.ID).Error("Failed to marshal user list event.")
+		} else {
+			dedupe.users = make(map[string]bool, len(users))
+			for _, u := range users {
+				dedupe.users[u.ID] = true
+			}
+			tmpChan <- sse.Event{
+				Type: UserList,
+				Data: list,
+			}
 		}
 	}
 	close(tmpChan)
 
-	// transientChan is bridge between channel created by client
-	// and channel created in this method. This way we can be sure
-	// that client will first receive buffered events and then
-	transientChan := make(chan sse.Event)
+	// done is closed by unsubscribe func, after underlying notifier
+	// stops sending, so the transient goroutine exits even if the
+	// notifier doesn't close transient channel.
+	done := make(chan struct{})
 
 	go func() {
+		defer close(args.Channel)
+
 		m.Logger.WithFields(logrus.Fields{
 			"reqID": args.RequestID,
 			"subID": args.ID,
 		}).Trace("Transient goroutine has started.")
 
 		for msg := range tmpChan {
-			args.Channel <- msg
+			select {
+			case args.Channel <- m.streamEvent(msg):
+			case <-done:
+				return
+			case <-ctx.Done():
+				return
+			}
 		}
 
 		m.Logger.WithFields(logrus.Fields{
@@ -214,8 +531,34 @@ func (m *MessageNotifierWithBuffer) Subscribe(ctx context.Context, args MessageS
 			"subID": args.ID,
 		}).Trace("Buffered messages have been sent.")
 
-		for msg := range transientChan {
-			args.Channel <- msg
+		for {
+			var msg sse.Event
+			var ok bool
+			select {
+			case msg, ok = <-transientChan:
+			case <-done:
+				return
+			case <-ctx.Done():
+				return
+			}
+
+			// Transient channel is closed when subscription ends,
+			// also when client is disconnected by the server.
+			if !ok {
+				break
+			}
+
+			if dedupe.duplicate(msg) {
+				continue
+			}
+
+			select {
+			case args.Channel <- m.streamEvent(msg):
+			case <-done:
+				return
+			case <-ctx.Done():
+				return
+			}
 		}
 
 		m.Logger.WithFields(logrus.Fields{
@@ -224,17 +567,161 @@ func (m *MessageNotifierWithBuffer) Subscribe(ctx context.Context, args MessageS
 		}).Trace("Transient goroutine has been terminated.")
 	}()
 
-	unsubscribe := m.Notifier.Subscribe(ctx, MessageSubscribeRequest{
-		ID:        args.ID,
-		RequestID: args.RequestID,
-		Channel:   transientChan,
-	})
+	once := &sync.Once{}
+	return func() {
+		once.Do(func() {
+			unsubscribe()
+			close(done)
+		})
+	}
+}
+
+// streamDedupe skips events delivered to subscribing client, which are
+// already reflected in buffered messages and snapshot of online users
+// sent before them.
+type streamDedupe struct {
+	// messages are event IDs of buffered messages.
+	messages map[string]bool
+
+	// users hold online state of users known to the client. Presence
+	// events aren't deduplicated, when it's nil.
+	users map[string]bool
+}
+
+// duplicate reports whether given event has already been reflected in
+// data sent to the client. Buffered messages are skipped, and so are
+// user-join events of online users and user-left events of offline
+// ones.
+func (d *streamDedupe) duplicate(evt sse.Event) bool {
+	switch evt.Type {
+	case MessageSent:
+		return d.messages[evt.ID]
+	case string(BridgeUserJoin), string(BridgeUserLeft):
+		if d.users == nil {
+			return false
+		}
+
+		presence := struct {
+			User ChatUser `json:"user"`
+		}{}
+		if err := json.Unmarshal(evt.Data, &presence); err != nil {
+			return false
+		}
+
+		online := evt.Type == string(BridgeUserJoin)
+		if d.users[presence.User.ID] == online {
+			return true
+		}
+		d.users[presence.User.ID] = online
+	}
+
+	return false
+}
+
+// resumePoint returns last event ID of subscribing client. With resume
+// cursors, it's sequence number from valid cursor. Invalid and expired
+// cursors are ignored, so the client receives all buffered messages.
+// Resuming clients, whose session has acknowledged messages in the same
+// chat channel, resume after the last acknowledged message, when it's
+// later than their last event.
+func (m *MessageNotifierWithBuffer) resumePoint(ctx context.Context, args MessageSubscribeRequest) string {
+	lastEventID := contextLastEventID(ctx)
+	if lastEventID == "" {
+		return ""
+	}
+
+	resume := m.lastEventSequence(lastEventID, args)
+	acked, ok := m.Acks.LastAcked(ctx, args.ID, args.ChatChannel)
+	if !ok {
+		return resume
+	}
+
+	// Message IDs can't be compared with sequence numbers, so
+	// acknowledgement is more precise resume point.
+	if sequence, err := strconv.ParseUint(resume, 10, 64); err == nil && sequence >= acked {
+		return resume
+	}
+
+	return strconv.FormatUint(acked, 10)
+}
+
+// lastEventSequence returns resume point from given last event ID. It's
+// either message ID or sequence number, or sequence number from resume
+// cursor, when resume cursors are used. It's empty for invalid cursors.
+func (m *MessageNotifierWithBuffer) lastEventSequence(lastEventID string, args MessageSubscribeRequest) string {
+	if m.Cursors == nil {
+		return lastEventID
+	}
+
+	sequence, err := m.Cursors.Decode(lastEventID)
+	if err != nil {
+		m.Logger.WithFields(logrus.Fields{
+			"reqID": args.RequestID,
+			"subID": args.ID,
+			"error": err.Error(),
+		}).Warn("Resume cursor has been rejected.")
+		return ""
+	}
+
+	return strconv.FormatUint(sequence, 10)
+}
+
+// streamEvent replaces sequence number of given event with resume
+// cursor, when resume cursors are used.
+func (m *MessageNotifierWithBuffer) streamEvent(evt sse.Event) sse.Event {
+	if m.Cursors == nil {
+		return evt
+	}
+
+	sequence, err := strconv.ParseUint(evt.ID, 10, 64)
+	if err != nil {
+		return evt
+	}
+
+	evt.ID = m.Cursors.Encode(sequence)
+	return evt
+}
+
+// onlineUsers returns snapshot of online users sorted by their
+// nicknames. User join event is processed asynchronously, so the
+// subscribing user is added to the snapshot, when it's not there yet.
+func (m *MessageNotifierWithBuffer) onlineUsers(ctx context.Context, args MessageSubscribeRequest) []OnlineChatUser {
+	if m.Users == nil {
+		return nil
+	}
+
+	users, err := m.Users.AllChatUsers(ctx)
+	if err != nil {
+		m.Logger.WithFields(logrus.Fields{
+			"subID": args.ID,
+			"error": err.Error(),
+		}).Error("Failed to read online users.")
+		return nil
+	}
 
-	wrappedUnsubscribe := func() {
-		unsubscribe()
-		close(transientChan)
+	joined := false
+	for _, u := range users {
+		if u.ID == args.ID {
+			joined = true
+			break
+		}
+	}
+	if !joined {
+		users = append(users, OnlineChatUser{
+			ID:       args.ID,
+			Nickname: args.Nickname,
+			Status:   PresenceOnline,
+		})
 	}
-	return wrappedUnsubscribe
+
+	sort.Slice(users, func(i, j int) bool {
+		if users[i].Nickname != users[j].Nickname {
+			return users[i].Nickname < users[j].Nickname
+		}
+		return users[i].ID < users[j].ID
+	})
+
+	return users
 }
 
 func requestsLastEventID(h http.Header) string {
@@ -1,12 +1,20 @@
 package service
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/matryer/is"
+	"github.com/sirupsen/logrus"
+
+	"github.com/fenole/szmaterlok/service/sse"
 )
 
 func TestMessageCircularBuffer(t *testing.T) {
@@ -95,9 +103,6 @@ func TestMessageCircularBuffer(t *testing.T) {
 					}
 
 					got := b.BufferedEvents(ctx)
-					sort.Slice(got, func(i, j int) bool {
-						return got[i].ID < got[j].ID
-					})
 
 					is.Equal(len(got), len(events))
 					is.Equal(got, events)
@@ -111,6 +116,21 @@ func TestMessageCircularBuffer(t *testing.T) {
 				return len(e) + 10
 			}))
 		})
+		t.Run("insertion order after overwrite", func(t *testing.T) {
+			ctx := context.TODO()
+			is := is.New(t)
+
+			b := NewMessageCircularBuffer(3)
+			for _, id := range []string{"1", "2", "3", "4", "5"} {
+				b.PushEvent(ctx, EventSentMessage{ID: id})
+			}
+
+			is.Equal(b.BufferedEvents(ctx), []EventSentMessage{
+				{ID: "3"},
+				{ID: "4"},
+				{ID: "5"},
+			})
+		})
 		t.Run("concurrent", func(t *testing.T) {
 			ctx := context.TODO()
 
@@ -168,4 +188,743 @@ func TestMessageCircularBuffer(t *testing.T) {
 
 		})
 	})
+	t.Run("Clear", func(t *testing.T) {
+		ctx := context.TODO()
+		is := is.New(t)
+
+		b := NewMessageCircularBuffer(3)
+		for _, id := range []string{"1", "2", "3", "4"} {
+			b.PushEvent(ctx, EventSentMessage{ID: id})
+		}
+
+		// Clearing races with pushes and reads.
+		wg := &sync.WaitGroup{}
+		wg.Add(3)
+		go func() {
+			defer wg.Done()
+			b.Clear(ctx)
+		}()
+		go func() {
+			defer wg.Done()
+			b.BufferedEvents(ctx)
+		}()
+		go func() {
+			defer wg.Done()
+			b.RemoveEvent(ctx, "4")
+		}()
+		wg.Wait()
+
+		b.Clear(ctx)
+		is.Equal(b.BufferedEvents(ctx), []EventSentMessage{})
+
+		// Buffer keeps its size after clearing.
+		for _, id := range []string{"5", "6", "7", "8"} {
+			b.PushEvent(ctx, EventSentMessage{ID: id})
+		}
+		is.Equal(b.BufferedEvents(ctx), []EventSentMessage{
+			{ID: "6"}, {ID: "7"}, {ID: "8"},
+		})
+	})
+}
+
+func TestLastMessagesBufferChannels(t *testing.T) {
+	is := is.New(t)
+	ctx := context.TODO()
+
+	b := NewLastMessagesBuffer(3, testLogger())
+	for _, msg := range []EventSentMessage{
+		{ID: "1", Channel: "a"},
+		{ID: "2", Channel: ""},
+		{ID: "3", Channel: ChatChannelDefault},
+	} {
+		data, err := json.Marshal(msg)
+		is.NoErr(err)
+		b.EventHook(ctx, BridgeEvent{ID: msg.ID, Data: data})
+	}
+
+	got := b.LastMessages(ctx, "a", "")
+	is.Equal(len(got), 1)
+	is.Equal(got[0].ID, "1")
+
+	// Messages without channel belong to the default one.
+	got = b.LastMessages(ctx, "", "")
+	is.Equal(len(got), 2)
+
+	// Reading unknown channel doesn't allocate its buffer.
+	is.Equal(len(b.LastMessages(ctx, "b", "")), 0)
+	is.Equal(len(b.buffers), 2)
+}
+
+func TestLastMessagesBufferChannelsMax(t *testing.T) {
+	is := is.New(t)
+	ctx := context.TODO()
+
+	b := NewLastMessagesBuffer(1, testLogger())
+	for i := 0; i <= lastMessagesChannelsMax; i++ {
+		msg := EventSentMessage{
+			ID:      strconv.Itoa(i),
+			Channel: "channel-" + strconv.Itoa(i),
+		}
+		data, err := json.Marshal(msg)
+		is.NoErr(err)
+		b.EventHook(ctx, BridgeEvent{ID: msg.ID, Data: data})
+	}
+
+	is.Equal(len(b.buffers), lastMessagesChannelsMax)
+	is.Equal(len(b.LastMessages(ctx, "channel-0", "")), 1)
+	is.Equal(len(b.LastMessages(ctx, "channel-"+strconv.Itoa(lastMessagesChannelsMax), "")), 0)
+}
+
+func TestLastMessagesBufferMalformedEvent(t *testing.T) {
+	type testArgs struct {
+		name string
+		evt  BridgeEventType
+	}
+
+	scenario := func(tt testArgs) (string, func(*testing.T)) {
+		return tt.name, func(t *testing.T) {
+			is := is.New(t)
+			ctx := context.TODO()
+
+			out := &bytes.Buffer{}
+			log := logrus.New()
+			log.SetOutput(out)
+
+			b := NewLastMessagesBuffer(3, log)
+			b.EventHook(ctx, BridgeEvent{
+				Name: tt.evt,
+				ID:   "malformed",
+				Data: []byte("{not json"),
+			})
+
+			is.True(strings.Contains(out.String(), "Failed to unmarshal"))
+			is.True(strings.Contains(out.String(), "malformed"))
+			is.Equal(len(b.LastMessages(ctx, "", "")), 0)
+		}
+	}
+
+	t.Run(scenario(testArgs{
+		name: "message sent",
+		evt:  BridgeMessageSent,
+	}))
+	t.Run(scenario(testArgs{
+		name: "message edited",
+		evt:  BridgeMessageEdited,
+	}))
+	t.Run(scenario(testArgs{
+		name: "message deleted",
+		evt:  BridgeMessageDeleted,
+	}))
+
+	t.Run("nil logger", func(t *testing.T) {
+		b := NewLastMessagesBuffer(3, nil)
+		b.EventHook(context.TODO(), BridgeEvent{Data: []byte("{not json")})
+	})
+}
+
+func TestLastMessagesBufferClear(t *testing.T) {
+	is := is.New(t)
+	ctx := context.TODO()
+
+	b := NewLastMessagesBuffer(3, testLogger())
+	push := func(msg EventSentMessage) {
+		data, err := json.Marshal(msg)
+		is.NoErr(err)
+		b.EventHook(ctx, BridgeEvent{ID: msg.ID, Data: data})
+	}
+
+	push(EventSentMessage{ID: "1", Channel: "a"})
+	push(EventSentMessage{ID: "2"})
+
+	b.Clear(ctx)
+	is.Equal(len(b.LastMessages(ctx, "a", "")), 0)
+	is.Equal(len(b.LastMessages(ctx, "", "")), 0)
+
+	push(EventSentMessage{ID: "3", Channel: "a"})
+	got := b.LastMessages(ctx, "a", "")
+	is.Equal(len(got), 1)
+	is.Equal(got[0].ID, "3")
+}
+
+func TestLastMessagesBufferDelete(t *testing.T) {
+	is := is.New(t)
+	ctx := context.TODO()
+
+	b := NewLastMessagesBuffer(3, testLogger())
+	for _, id := range []string{"1", "2", "3"} {
+		data, err := json.Marshal(EventSentMessage{ID: id})
+		is.NoErr(err)
+		b.EventHook(ctx, BridgeEvent{Name: BridgeMessageSent, ID: id, Data: data})
+	}
+
+	data, err := json.Marshal(EventMessageDeleted{ID: "del", MessageID: "2"})
+	is.NoErr(err)
+	b.EventHook(ctx, BridgeEvent{Name: BridgeMessageDeleted, ID: "del", Data: data})
+
+	got := b.LastMessages(ctx, "", "")
+	is.Equal(len(got), 2)
+	is.Equal(got[0].ID, "1")
+	is.Equal(got[1].ID, "3")
+}
+
+func TestLastMessagesBufferEdit(t *testing.T) {
+	is := is.New(t)
+	ctx := context.TODO()
+
+	b := NewLastMessagesBuffer(3, testLogger())
+	for _, id := range []string{"1", "2"} {
+		data, err := json.Marshal(EventSentMessage{ID: id, Channel: "a", Content: "typo"})
+		is.NoErr(err)
+		b.EventHook(ctx, BridgeEvent{Name: BridgeMessageSent, ID: id, Data: data})
+	}
+	before := b.LastMessages(ctx, "a", "")
+
+	data, err := json.Marshal(EventMessageEdited{
+		ID:        "edit",
+		MessageID: "2",
+		Channel:   "a",
+		Content:   "fixed",
+		HTML:      "<p>fixed</p>",
+	})
+	is.NoErr(err)
+	b.EventHook(ctx, BridgeEvent{Name: BridgeMessageEdited, ID: "edit", Data: data})
+
+	got := b.LastMessages(ctx, "a", "")
+	is.Equal(len(got), 2)
+	is.Equal(got[0].Content, "typo")
+	is.Equal(got[1].Content, "fixed")
+	is.Equal(got[1].HTML, "<p>fixed</p>")
+
+	// Messages returned before the edit are left intact.
+	is.Equal(before[1].Content, "typo")
+}
+
+func TestLastMessagesBufferLastMessages(t *testing.T) {
+	sentAt := time.Unix(1000, 0)
+	messages := []EventSentMessage{
+		{ID: "a", SentAt: sentAt},
+		{ID: "b", SentAt: sentAt.Add(time.Second)},
+		{ID: "d", SentAt: sentAt.Add(time.Second * 2)},
+		{ID: "c", SentAt: sentAt.Add(time.Second * 2)},
+		{ID: "e", SentAt: sentAt.Add(time.Second * 3)},
+	}
+
+	type testArgs struct {
+		name          string
+		lastMessageID string
+		want          []string
+	}
+
+	scenario := func(tt testArgs) (string, func(*testing.T)) {
+		return tt.name, func(t *testing.T) {
+			is := is.New(t)
+			ctx := context.TODO()
+
+			// Messages are pushed in reverse order, so buffer order
+			// differs from chronological one.
+			b := NewLastMessagesBuffer(len(messages), testLogger())
+			for i := len(messages) - 1; i >= 0; i-- {
+				data, err := json.Marshal(messages[i])
+				is.NoErr(err)
+				b.EventHook(ctx, BridgeEvent{Name: BridgeMessageSent, ID: messages[i].ID, Data: data})
+			}
+
+			got := []string{}
+			for _, msg := range b.LastMessages(ctx, "", tt.lastMessageID) {
+				got = append(got, msg.ID)
+			}
+			is.Equal(got, tt.want)
+		}
+	}
+
+	t.Run(scenario(testArgs{
+		name: "all messages",
+		want: []string{"a", "b", "c", "d", "e"},
+	}))
+	t.Run(scenario(testArgs{
+		name:          "after middle message",
+		lastMessageID: "b",
+		want:          []string{"c", "d", "e"},
+	}))
+	t.Run(scenario(testArgs{
+		name:          "equal timestamps tie-break by ID",
+		lastMessageID: "c",
+		want:          []string{"d", "e"},
+	}))
+	t.Run(scenario(testArgs{
+		name:          "after last message",
+		lastMessageID: "e",
+		want:          []string{},
+	}))
+	t.Run(scenario(testArgs{
+		name:          "unknown message",
+		lastMessageID: "unknown",
+		want:          []string{"a", "b", "c", "d", "e"},
+	}))
+}
+
+func TestLastMessagesBufferSequence(t *testing.T) {
+	// Sequence numbers don't follow send dates, for example when
+	// clock of the server has moved back.
+	sentAt := time.Unix(1000, 0)
+	messages := []EventSentMessage{
+		{ID: "a", SentAt: sentAt.Add(time.Second * 4)},
+		{ID: "b", SentAt: sentAt.Add(time.Second * 3)},
+		{ID: "c", SentAt: sentAt.Add(time.Second * 2)},
+		{ID: "d", SentAt: sentAt.Add(time.Second)},
+		{ID: "e", SentAt: sentAt},
+	}
+
+	type testArgs struct {
+		name        string
+		lastEventID string
+		want        []string
+	}
+
+	scenario := func(tt testArgs) (string, func(*testing.T)) {
+		return tt.name, func(t *testing.T) {
+			is := is.New(t)
+			ctx := context.TODO()
+
+			// Messages are pushed in reverse order, so buffer order
+			// differs from sequence one. Sequence numbers have gaps
+			// left by events of other types.
+			b := NewLastMessagesBuffer(len(messages), testLogger())
+			for i := len(messages) - 1; i >= 0; i-- {
+				data, err := json.Marshal(messages[i])
+				is.NoErr(err)
+				b.EventHook(ctx, BridgeEvent{
+					Name:     BridgeMessageSent,
+					ID:       messages[i].ID,
+					Sequence: uint64(i*2 + 10),
+					Data:     data,
+				})
+			}
+
+			got := []string{}
+			for _, msg := range b.LastMessages(ctx, "", tt.lastEventID) {
+				is.True(msg.Sequence != 0) // buffered messages carry sequence numbers
+				got = append(got, msg.ID)
+			}
+			is.Equal(got, tt.want)
+		}
+	}
+
+	t.Run(scenario(testArgs{
+		name: "all messages",
+		want: []string{"a", "b", "c", "d", "e"},
+	}))
+	t.Run(scenario(testArgs{
+		name:        "after message sequence",
+		lastEventID: "12",
+		want:        []string{"c", "d", "e"},
+	}))
+	t.Run(scenario(testArgs{
+		name:        "after other event sequence",
+		lastEventID: "15",
+		want:        []string{"d", "e"},
+	}))
+	t.Run(scenario(testArgs{
+		name:        "after last sequence",
+		lastEventID: "18",
+		want:        []string{},
+	}))
+	t.Run(scenario(testArgs{
+		name:        "before buffered sequences",
+		lastEventID: "3",
+		want:        []string{"a", "b", "c", "d", "e"},
+	}))
+	t.Run(scenario(testArgs{
+		name:        "after message ID",
+		lastEventID: "b",
+		want:        []string{"c", "d", "e"},
+	}))
+}
+
+func TestMessageNotifierWithBufferDisconnect(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+	log := testLogger()
+
+	h := NewBridgeMessageHandler(log)
+	n := &MessageNotifierWithBuffer{
+		Notifier: h,
+		Buffer:   NewLastMessagesBuffer(3, log),
+		Logger:   log,
+	}
+
+	evts := make(chan sse.Event, 1)
+	unsubscribe := n.Subscribe(ctx, MessageSubscribeRequest{
+		ID:        "1",
+		RequestID: "req",
+		Channel:   evts,
+	})
+
+	// Client channel is closed, when client is disconnected.
+	is.Equal(h.Disconnect("1"), 1)
+	select {
+	case evt := <-evts:
+		is.Equal(evt.Type, StreamReady)
+	case <-time.After(time.Second):
+		t.Fatal("ready event has not been delivered")
+	}
+	select {
+	case _, ok := <-evts:
+		is.True(!ok)
+	case <-time.After(time.Second):
+		t.Fatal("client channel has not been closed")
+	}
+
+	// Unsubscribing after disconnection is safe.
+	unsubscribe()
+}
+
+func TestMessageNotifierWithBufferUnsubscribe(t *testing.T) {
+	// drain reads given channel until it's closed.
+	drain := func(t *testing.T, evts <-chan sse.Event) {
+		t.Helper()
+
+		timeout := time.After(time.Second * 5)
+		for {
+			select {
+			case _, ok := <-evts:
+				if !ok {
+					return
+				}
+			case <-timeout:
+				t.Fatal("client channel has not been closed")
+			}
+		}
+	}
+
+	t.Run("stress", func(t *testing.T) {
+		ctx := context.Background()
+		log := testLogger()
+		const (
+			clients = 32
+			rounds  = 50
+		)
+
+		h := NewBridgeMessageHandler(log)
+		h.SlowClient = SlowClientDisconnect
+		n := &MessageNotifierWithBuffer{
+			Notifier: h,
+			Buffer:   NewLastMessagesBuffer(3, log),
+			Logger:   log,
+		}
+
+		// Events are sent and clients are disconnected by the server
+		// all the time, while clients subscribe and unsubscribe.
+		stop := make(chan struct{})
+		background := sync.WaitGroup{}
+		goWithWaitGroup(&background, func() {
+			for i := 0; ; i++ {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				h.EventHook(ctx, BridgeEvent{
+					Name: BridgeUserTyping,
+					ID:   "typing",
+					Headers: BridgeHeaders{
+						bridgeContentTypeHeaderVar: contentTypeApplicationJSON,
+					},
+					Data: []byte(`{}`),
+				})
+				if i%16 == 0 {
+					h.Disconnect("client-0")
+				}
+			}
+		})
+
+		clientsWg := sync.WaitGroup{}
+		for c := 0; c < clients; c++ {
+			// Clients share IDs, like users with many browser tabs.
+			id := "client-" + strconv.Itoa(c%8)
+
+			goWithWaitGroup(&clientsWg, func() {
+				for i := 0; i < rounds; i++ {
+					evts := make(chan sse.Event, 1)
+					unsubscribe := n.Subscribe(ctx, MessageSubscribeRequest{
+						ID:        id,
+						RequestID: id,
+						Channel:   evts,
+					})
+
+					// Some clients read a bit before leaving, others
+					// leave right away.
+					if i%2 == 0 {
+						select {
+						case <-evts:
+						case <-time.After(time.Millisecond):
+						}
+					}
+
+					unsubscribe()
+					drain(t, evts)
+				}
+			})
+		}
+		clientsWg.Wait()
+
+		close(stop)
+		background.Wait()
+	})
+
+	t.Run("notifier without closing", func(t *testing.T) {
+		is := is.New(t)
+		log := testLogger()
+
+		// Underlying notifier never closes its channel, so only
+		// unsubscribe can stop forwarding.
+		transient := make(chan chan<- sse.Event, 1)
+		n := &MessageNotifierWithBuffer{
+			Notifier: MessageNotifierFunc(func(ctx context.Context, args MessageSubscribeRequest) func() {
+				transient <- args.Channel
+				return func() {}
+			}),
+			Buffer: NewLastMessagesBuffer(3, log),
+			Logger: log,
+		}
+
+		evts := make(chan sse.Event, 1)
+		unsubscribe := n.Subscribe(context.Background(), MessageSubscribeRequest{
+			ID:        "1",
+			RequestID: "req",
+			Channel:   evts,
+		})
+
+		select {
+		case evt := <-evts:
+			is.Equal(evt.Type, StreamReady)
+		case <-time.After(time.Second):
+			t.Fatal("ready event has not been delivered")
+		}
+
+		// Events sent by notifier are forwarded until unsubscribe.
+		(<-transient) <- sse.Event{Type: "ping"}
+		select {
+		case evt := <-evts:
+			is.Equal(evt.Type, "ping")
+		case <-time.After(time.Second):
+			t.Fatal("event has not been forwarded")
+		}
+
+		unsubscribe()
+		drain(t, evts)
+
+		// Unsubscribing again is safe.
+		unsubscribe()
+	})
+}
+
+func TestMessageNotifierWithBufferReady(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+	log := testLogger()
+
+	buffer := NewLastMessagesBuffer(3, log)
+	for _, id := range []string{"a", "b"} {
+		data, err := json.Marshal(EventSentMessage{ID: id})
+		is.NoErr(err)
+		buffer.EventHook(ctx, BridgeEvent{Name: BridgeMessageSent, ID: id, Data: data})
+	}
+
+	users := NewStateOnlineUsers()
+	is.NoErr(users.PushChatUser(ctx, StateChatUser{ID: "other", Nickname: "other"}))
+
+	h := NewBridgeMessageHandler(log)
+	n := &MessageNotifierWithBuffer{
+		Notifier: h,
+		Buffer:   buffer,
+		Logger:   log,
+		Users:    users,
+	}
+
+	evts := make(chan sse.Event, 4)
+	unsubscribe := n.Subscribe(ctx, MessageSubscribeRequest{
+		ID:        "1",
+		Nickname:  "karol",
+		RequestID: "req",
+		Channel:   evts,
+	})
+	defer unsubscribe()
+
+	next := func() sse.Event {
+		select {
+		case evt := <-evts:
+			return evt
+		case <-time.After(time.Second):
+			t.Fatal("event has not been delivered")
+		}
+		return sse.Event{}
+	}
+
+	is.Equal(next().ID, "a")
+	is.Equal(next().ID, "b")
+
+	// First non-buffered event is ready event.
+	evt := next()
+	is.Equal(evt.Type, StreamReady)
+	is.Equal(evt.ID, "")
+
+	data := EventStreamReady{}
+	is.NoErr(json.Unmarshal(evt.Data, &data))
+	is.Equal(data.User, UserPresentation("1", "karol"))
+
+	// Client hasn't joined yet, but it's counted as online.
+	is.Equal(data.Online, 2)
+}
+
+func TestMessageNotifierWithBufferUserList(t *testing.T) {
+	type testArgs struct {
+		name string
+
+		// joined is set when user join event has been processed
+		// before subscription.
+		joined bool
+	}
+
+	scenario := func(tt testArgs) (string, func(*testing.T)) {
+		return tt.name, func(t *testing.T) {
+			is := is.New(t)
+			ctx := context.Background()
+			log := testLogger()
+
+			users := NewStateOnlineUsers()
+			is.NoErr(users.PushChatUser(ctx, StateChatUser{ID: "2", Nickname: "zenon"}))
+			is.NoErr(users.PushChatUser(ctx, StateChatUser{ID: "3", Nickname: "adam"}))
+			if tt.joined {
+				is.NoErr(users.PushChatUser(ctx, StateChatUser{ID: "1", Nickname: "karol"}))
+			}
+
+			n := &MessageNotifierWithBuffer{
+				Notifier: NewBridgeMessageHandler(log),
+				Buffer:   NewLastMessagesBuffer(3, log),
+				Logger:   log,
+				Users:    users,
+			}
+
+			evts := make(chan sse.Event, 2)
+			unsubscribe := n.Subscribe(ctx, MessageSubscribeRequest{
+				ID:        "1",
+				Nickname:  "karol",
+				RequestID: "req",
+				Channel:   evts,
+			})
+			defer unsubscribe()
+
+			var evt sse.Event
+			for evt.Type != UserList {
+				select {
+				case evt = <-evts:
+				case <-time.After(time.Second):
+					t.Fatal("user list event has not been delivered")
+				}
+			}
+			is.Equal(evt.ID, "")
+
+			data := EventUserList{}
+			is.NoErr(json.Unmarshal(evt.Data, &data))
+			is.Equal(data.Users, []OnlineChatUser{
+				{ID: "3", Nickname: "adam", Status: PresenceOnline},
+				{ID: "1", Nickname: "karol", Status: PresenceOnline},
+				{ID: "2", Nickname: "zenon", Status: PresenceOnline},
+			})
+		}
+	}
+
+	t.Run(scenario(testArgs{
+		name:   "user join event not processed yet",
+		joined: false,
+	}))
+	t.Run(scenario(testArgs{
+		name:   "user already joined",
+		joined: true,
+	}))
+}
+
+func TestMessageNotifierWithBufferSubscribeRace(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+	log := testLogger()
+
+	buffer := NewLastMessagesBuffer(3, log)
+	users := NewStateOnlineUsers()
+
+	push := func(id string) {
+		data, err := json.Marshal(EventSentMessage{ID: id})
+		is.NoErr(err)
+		buffer.EventHook(ctx, BridgeEvent{Name: BridgeMessageSent, ID: id, Data: data})
+	}
+	push("a")
+
+	presence := func(name BridgeEventType, id string) sse.Event {
+		data, err := json.Marshal(EventUserJoin{ID: string(name) + id, User: ChatUser{ID: id}})
+		is.NoErr(err)
+		return sse.Event{Type: string(name), Data: data}
+	}
+
+	// Events are fired right after client subscribes, before buffered
+	// messages and online users are read. They're delivered live and
+	// reflected in buffer and user list at the same time.
+	n := &MessageNotifierWithBuffer{
+		Notifier: MessageNotifierFunc(func(ctx context.Context, args MessageSubscribeRequest) func() {
+			push("b")
+			is.NoErr(users.PushChatUser(ctx, StateChatUser{ID: "2", Nickname: "zenon"}))
+
+			args.Channel <- sse.Event{Type: MessageSent, ID: "b"}
+			args.Channel <- presence(BridgeUserJoin, "2")
+			args.Channel <- presence(BridgeUserLeft, "3")
+			args.Channel <- sse.Event{Type: MessageSent, ID: "c"}
+			args.Channel <- presence(BridgeUserLeft, "2")
+			return func() {}
+		}),
+		Buffer: buffer,
+		Logger: log,
+		Users:  users,
+	}
+
+	evts := make(chan sse.Event, 8)
+	unsubscribe := n.Subscribe(ctx, MessageSubscribeRequest{
+		ID:        "1",
+		Nickname:  "karol",
+		RequestID: "req",
+		Channel:   evts,
+	})
+	defer unsubscribe()
+
+	next := func() sse.Event {
+		select {
+		case evt := <-evts:
+			return evt
+		case <-time.After(time.Second):
+			t.Fatal("event has not been delivered")
+		}
+		return sse.Event{}
+	}
+
+	is.Equal(next().ID, "a")
+	is.Equal(next().ID, "b")
+	is.Equal(next().Type, StreamReady)
+
+	list := EventUserList{}
+	evt := next()
+	is.Equal(evt.Type, UserList)
+	is.NoErr(json.Unmarshal(evt.Data, &list))
+	is.Equal(len(list.Users), 2)
+
+	// Events already reflected in buffered messages and user list
+	// aren't delivered again, but the new ones are.
+	is.Equal(next().ID, "c")
+	evt = next()
+	is.Equal(evt.Type, string(BridgeUserLeft))
+	is.Equal(evt.Data, presence(BridgeUserLeft, "2").Data)
+
+	select {
+	case evt := <-evts:
+		t.Fatalf("unexpected event: %s", evt.Type)
+	case <-time.After(time.Millisecond * 50):
+	}
 }
@@ -0,0 +1,71 @@
+package service
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5/middleware"
+
+	"github.com/fenole/szmaterlok/service/sse"
+)
+
+// compressibleContentTypes are content types of responses, which
+// are compressed by CompressMiddleware. Event stream is absent on
+// purpose: compressed SSE frames would be buffered by encoder.
+var compressibleContentTypes = []string{
+	"text/html",
+	"text/css",
+	"text/plain",
+	"text/javascript",
+	"application/javascript",
+	"application/json",
+	"image/svg+xml",
+}
+
+// CompressMiddleware compresses responses of compressible content
+// types with gzip or deflate, depending on Accept-Encoding header.
+// Requests accepting event stream bypass compression entirely, so
+// stream handler works with unwrapped response writer and can flush
+// events. Other handlers can still reset connection deadlines with
+// http.ResponseController, because compressed response writer
+// unwraps to the original one.
+func CompressMiddleware(level int) func(http.Handler) http.Handler {
+	compress := middleware.Compress(level, compressibleContentTypes...)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if strings.Contains(r.Header.Get("Accept"), sse.ContentTypeEventStream) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			compress(http.HandlerFunc(func(cw http.ResponseWriter, r *http.Request) {
+				next.ServeHTTP(&compressResponseWriter{
+					ResponseWriter: cw,
+					original:       w,
+				}, r)
+			})).ServeHTTP(w, r)
+		})
+	}
+}
+
+// compressResponseWriter is response writer of compression middleware,
+// which unwraps to the original response writer. Writes and flushes go
+// through compression, but deadlines are set on the original writer.
+type compressResponseWriter struct {
+	http.ResponseWriter
+	original http.ResponseWriter
+}
+
+// Flush sends compressed data buffered so far to the client.
+func (w *compressResponseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap returns the original response writer. It's used by
+// http.ResponseController.
+func (w *compressResponseWriter) Unwrap() http.ResponseWriter {
+	return w.original
+}
@@ -0,0 +1,167 @@
+package service
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+
+	"github.com/fenole/szmaterlok/service/sse"
+)
+
+func TestCompressMiddleware(t *testing.T) {
+	t.Run("users", func(t *testing.T) {
+		is := is.New(t)
+		ctx := context.Background()
+
+		store := NewStateOnlineUsers()
+		is.NoErr(store.PushChatUser(ctx, StateChatUser{ID: "id", Nickname: "karol"}))
+
+		h := CompressMiddleware(5)(HandlerOnlineUsers(testLogger(), store))
+
+		r := requestWithSession(ctx, httptest.NewRequest(http.MethodGet, "/users", nil), &SessionState{
+			ID: "id",
+		})
+		r.Header.Set("Accept-Encoding", "gzip")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+
+		is.Equal(w.Code, http.StatusOK)
+		is.Equal(w.Header().Get("Content-Encoding"), "gzip")
+
+		gz, err := gzip.NewReader(w.Body)
+		is.NoErr(err)
+		body, err := io.ReadAll(gz)
+		is.NoErr(err)
+
+		var got struct {
+			Data struct {
+				Users []OnlineChatUser `json:"users"`
+			} `json:"data"`
+		}
+		is.NoErr(json.Unmarshal(body, &got))
+		is.Equal(len(got.Data.Users), 1)
+		is.Equal(got.Data.Users[0].Nickname, "karol")
+	})
+
+	type testArgs struct {
+		name   string
+		accept string
+	}
+
+	scenario := func(tt testArgs) (string, func(*testing.T)) {
+		return tt.name, func(t *testing.T) {
+			is := is.New(t)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			h := CompressMiddleware(5)(sse.Headers(HandlerStream(HandlerStreamDependencies{
+				HeartbeatInterval: time.Millisecond * 5,
+				MessageNotifier: MessageNotifierFunc(func(context.Context, MessageSubscribeRequest) func() {
+					return func() {}
+				}),
+			})))
+
+			r := requestWithSession(ctx, httptest.NewRequest(http.MethodGet, "/stream", nil), &SessionState{
+				ID:       "id",
+				Nickname: "nickname",
+			})
+			r.Header.Set("Accept-Encoding", "gzip, deflate")
+			if tt.accept != "" {
+				r.Header.Set("Accept", tt.accept)
+			}
+			w := newStreamRecorder()
+
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				h.ServeHTTP(w, r)
+			}()
+
+			// Heartbeats are readable before stream is closed, so they
+			// haven't been buffered by any encoder.
+			waitFor(t, time.Second, func() bool {
+				return strings.Contains(w.String(), heartbeatComment)
+			})
+
+			cancel()
+			<-done
+
+			is.Equal(w.Header().Get("Content-Encoding"), "")
+			is.True(strings.HasPrefix(w.String(), ": "+heartbeatComment))
+		}
+	}
+
+	t.Run(scenario(testArgs{
+		name:   "event source stream",
+		accept: sse.ContentTypeEventStream,
+	}))
+	t.Run(scenario(testArgs{
+		name: "stream without accept header",
+	}))
+}
+
+// slowArchiveMock is state archive, which sends its events with
+// given delay between them.
+type slowArchiveMock struct {
+	events []BridgeEvent
+	delay  time.Duration
+}
+
+func (a slowArchiveMock) Events(ctx context.Context, c chan<- BridgeEvent) error {
+	for _, evt := range a.events {
+		select {
+		case <-time.After(a.delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		c <- evt
+	}
+	return nil
+}
+
+func TestCompressMiddlewareWriteDeadline(t *testing.T) {
+	is := is.New(t)
+
+	archive := slowArchiveMock{delay: time.Millisecond * 50}
+	for i := 0; i < 6; i++ {
+		archive.events = append(archive.events, BridgeEvent{
+			Name: BridgeMessageSent,
+			ID:   strconv.Itoa(i),
+		})
+	}
+
+	srv := httptest.NewUnstartedServer(NewRouter(RouterDependencies{
+		Logger:     testLogger(),
+		AdminToken: "secret",
+		Archive:    archive,
+	}))
+	srv.Config.WriteTimeout = time.Millisecond * 100
+	srv.Start()
+	defer srv.Close()
+
+	// Export takes longer than write timeout, so it's complete only
+	// when export handler resets write deadline behind compression.
+	r, err := http.NewRequest(http.MethodGet, srv.URL+"/admin/export", nil)
+	is.NoErr(err)
+	r.Header.Set("Authorization", "Bearer secret")
+	r.Header.Set("Accept-Encoding", "gzip")
+
+	res, err := srv.Client().Do(r)
+	is.NoErr(err)
+	defer res.Body.Close()
+	is.Equal(res.StatusCode, http.StatusOK)
+
+	body, err := io.ReadAll(res.Body)
+	is.NoErr(err)
+	is.Equal(strings.Count(string(body), "\n"), len(archive.events))
+}
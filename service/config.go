@@ -2,12 +2,26 @@ package service
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"log"
+	"net"
+	"net/http"
+	"net/url"
 	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
+	"time"
 
 	env "github.com/joho/godotenv"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+	"gopkg.in/yaml.v2"
 )
 
 // Pathts of configuration files.
@@ -32,21 +46,279 @@ const (
 	// ConfigAddressVarName is env variable for listening address.
 	ConfigAddressVarName = "S8K_ADDR"
 
+	// ConfigFileVarName is env variable for path of optional YAML
+	// or JSON config file.
+	ConfigFileVarName = "S8K_CONFIG"
+
 	// ConfigSessionSecretVarName is env variable for secret session password.
 	ConfigSessionSecretVarName = "S8K_SESSION_SECRET"
 
+	// ConfigSessionSecretOldVarName is env variable for comma-separated
+	// list of previous session secrets. Tokens encoded with them are
+	// still accepted, which allows rotation of session secret.
+	ConfigSessionSecretOldVarName = "S8K_SESSION_SECRET_OLD"
+
 	// ConfigTokenizerVarName is env variable for tokenizer type used by szmaterlok.
 	ConfigTokenizerVarName = "S8K_TOKENIZER"
 
 	// ConfigDatabasePathVarName is env variable for database connection string
-	// (filepath to sqlite file).
+	// (filepath to sqlite file, :memory: or postgres:// URL).
 	ConfigDatabasePathVarName = "S8K_DB"
 
 	// ConfigLastMessagesBufferSizeVarName is env variable for size of last messages buffer.
 	ConfigLastMessagesBufferSizeVarName = "S8K_LAST_MSG_BUFFER_SIZE"
 
+	// ConfigMessagesStateSizeVarName is env variable for number of recent
+	// messages kept in memory, which can be edited, deleted and acknowledged.
+	ConfigMessagesStateSizeVarName = "S8K_MSG_STATE_SIZE"
+
 	// ConfigMaxMessageSizeVarName is env variable for maximum message size.
 	ConfigMaxMessageSizeVarName = "S8K_MAX_MSG_SIZE"
+
+	// ConfigReadTimeoutVarName is env variable for maximum duration
+	// of reading entire http request.
+	ConfigReadTimeoutVarName = "S8K_READ_TIMEOUT"
+
+	// ConfigReadHeaderTimeoutVarName is env variable for maximum
+	// duration of reading http request headers.
+	ConfigReadHeaderTimeoutVarName = "S8K_READ_HEADER_TIMEOUT"
+
+	// ConfigWriteTimeoutVarName is env variable for maximum duration
+	// before timing out writes of http response.
+	ConfigWriteTimeoutVarName = "S8K_WRITE_TIMEOUT"
+
+	// ConfigIdleTimeoutVarName is env variable for maximum amount of
+	// time to wait for the next request when keep-alives are enabled.
+	ConfigIdleTimeoutVarName = "S8K_IDLE_TIMEOUT"
+
+	// ConfigSSEHeartbeatIntervalVarName is env variable for interval of
+	// keep-alive comments sent to idle event stream clients.
+	ConfigSSEHeartbeatIntervalVarName = "S8K_SSE_HEARTBEAT_INTERVAL"
+
+	// ConfigSSEReconnectTimeVarName is env variable for reconnection
+	// time hint sent to event stream clients.
+	ConfigSSEReconnectTimeVarName = "S8K_SSE_RECONNECT_TIME"
+
+	// ConfigNicknameMinLengthVarName is env variable for minimal
+	// nickname length.
+	ConfigNicknameMinLengthVarName = "S8K_NICK_MIN_LEN"
+
+	// ConfigNicknameMaxLengthVarName is env variable for maximal
+	// nickname length.
+	ConfigNicknameMaxLengthVarName = "S8K_NICK_MAX_LEN"
+
+	// ConfigMetricsEnabledVarName is env variable for enabling
+	// prometheus metrics endpoint.
+	ConfigMetricsEnabledVarName = "S8K_METRICS_ENABLED"
+
+	// ConfigBridgeQueueSizeVarName is env variable for size of
+	// event bridge queue.
+	ConfigBridgeQueueSizeVarName = "S8K_BRIDGE_QUEUE_SIZE"
+
+	// ConfigPersistPolicyVarName is env variable for comma-separated
+	// list of event types with their persistence, in type:persist or
+	// type:skip format.
+	ConfigPersistPolicyVarName = "S8K_PERSIST_POLICY"
+
+	// ConfigHookTimeoutVarName is env variable for timeout of single
+	// event hook invocation.
+	ConfigHookTimeoutVarName = "S8K_HOOK_TIMEOUT"
+
+	// ConfigResumeCursorTTLVarName is env variable for time to live of
+	// resume cursors sent as event stream IDs.
+	ConfigResumeCursorTTLVarName = "S8K_RESUME_CURSOR_TTL"
+
+	// ConfigSessionSlidingVarName is env variable for enabling
+	// sliding sessions.
+	ConfigSessionSlidingVarName = "S8K_SESSION_SLIDING"
+
+	// ConfigSessionSlidingWindowVarName is env variable for period
+	// before session expiration in which session is refreshed.
+	ConfigSessionSlidingWindowVarName = "S8K_SESSION_SLIDING_WINDOW"
+
+	// ConfigCookieSecureVarName is env variable for Secure attribute
+	// of session cookie.
+	ConfigCookieSecureVarName = "S8K_COOKIE_SECURE"
+
+	// ConfigCookieSameSiteVarName is env variable for SameSite attribute
+	// of session cookie. Valid values are: lax, strict and none.
+	ConfigCookieSameSiteVarName = "S8K_COOKIE_SAMESITE"
+
+	// ConfigSessionRevocationVarName is env variable for enabling
+	// server-side session revocation list.
+	ConfigSessionRevocationVarName = "S8K_SESSION_REVOCATION"
+
+	// ConfigMessageRateVarName is env variable for number of messages
+	// per second, which single session can send.
+	ConfigMessageRateVarName = "S8K_MSG_RATE"
+
+	// ConfigMessageBurstVarName is env variable for number of messages,
+	// which single session can send at once.
+	ConfigMessageBurstVarName = "S8K_MSG_BURST"
+
+	// ConfigFloodViolationsVarName is env variable for number of
+	// message rate limit violations within flood window, after which
+	// session is muted. Zero disables muting.
+	ConfigFloodViolationsVarName = "S8K_FLOOD_VIOLATIONS"
+
+	// ConfigFloodWindowVarName is env variable for period, in which
+	// message rate limit violations are counted.
+	ConfigFloodWindowVarName = "S8K_FLOOD_WINDOW"
+
+	// ConfigFloodMuteVarName is env variable for duration of mute of
+	// flooding session.
+	ConfigFloodMuteVarName = "S8K_FLOOD_MUTE"
+
+	// ConfigSlowModeVarName is env variable for comma-separated list of
+	// chat channels in slow mode, in channel:interval format.
+	ConfigSlowModeVarName = "S8K_SLOW_MODE"
+
+	// ConfigCORSOriginsVarName is env variable for comma-separated list
+	// of origins, which are allowed to make cross-origin requests.
+	ConfigCORSOriginsVarName = "S8K_CORS_ORIGINS"
+
+	// ConfigLogFormatVarName is env variable for format of logs.
+	// Valid values are: text and json.
+	ConfigLogFormatVarName = "S8K_LOG_FORMAT"
+
+	// ConfigLogLevelVarName is env variable for minimal level of
+	// logged entries, for example: debug, info, warn or error.
+	ConfigLogLevelVarName = "S8K_LOG_LEVEL"
+
+	// ConfigRetentionVarName is env variable for period, after which
+	// events are deleted from event storage.
+	ConfigRetentionVarName = "S8K_RETENTION"
+
+	// ConfigEphemeralRetentionVarName is env variable for period, after
+	// which ephemeral events (user presence and typing) are deleted
+	// from event storage.
+	ConfigEphemeralRetentionVarName = "S8K_RETENTION_EPHEMERAL"
+
+	// ConfigAdminTokenVarName is env variable for bearer token, which
+	// grants access to admin resources. Admin resources are disabled
+	// when it's not set.
+	ConfigAdminTokenVarName = "S8K_ADMIN_TOKEN"
+
+	// ConfigAdminsVarName is env variable for comma-separated list
+	// of nicknames, which can be granted admin privileges at login
+	// with admin token. Any nickname can when it's not set.
+	ConfigAdminsVarName = "S8K_ADMINS"
+
+	// ConfigWordFilterFileVarName is env variable for path to file with
+	// words, which are replaced with asterisks in sent messages.
+	// Messages aren't filtered when it's not set.
+	ConfigWordFilterFileVarName = "S8K_WORDFILTER_FILE"
+
+	// ConfigMarkdownVarName is env variable for enabling rendering
+	// of message content from markdown to HTML.
+	ConfigMarkdownVarName = "S8K_MARKDOWN"
+
+	// ConfigAwayTimeoutVarName is env variable for period of user
+	// inactivity, after which user is marked as away.
+	ConfigAwayTimeoutVarName = "S8K_AWAY_TIMEOUT"
+
+	// ConfigPresenceTTLVarName is env variable for period without
+	// event stream heartbeat, after which user is removed from chat.
+	ConfigPresenceTTLVarName = "S8K_PRESENCE_TTL"
+
+	// ConfigSlowClientVarName is env variable for policy of handling
+	// slow event stream clients: drop or disconnect.
+	ConfigSlowClientVarName = "S8K_SLOW_CLIENT"
+
+	// ConfigSSEBufferSizeVarName is env variable for number of events
+	// buffered for single event stream client.
+	ConfigSSEBufferSizeVarName = "S8K_SSE_BUFFER_SIZE"
+
+	// ConfigMaxConnsVarName is env variable for maximal number of
+	// concurrently open event streams.
+	ConfigMaxConnsVarName = "S8K_MAX_CONNS"
+
+	// ConfigMaxConnsPerUserVarName is env variable for maximal number
+	// of concurrently open event streams of single user.
+	ConfigMaxConnsPerUserVarName = "S8K_MAX_CONNS_PER_USER"
+
+	// ConfigMaxAcksVarName is env variable for maximal number of
+	// message acknowledgements of sessions in chat channels held in
+	// memory.
+	ConfigMaxAcksVarName = "S8K_MAX_ACKS"
+
+	// ConfigTokenizerCacheSizeVarName is env variable for maximal number
+	// of session tokens held in tokenizer cache.
+	ConfigTokenizerCacheSizeVarName = "S8K_TOKENIZER_CACHE_SIZE"
+
+	// ConfigTLSCertVarName is env variable for path to TLS certificate
+	// file. Server listens on HTTPS when it's set along with key.
+	ConfigTLSCertVarName = "S8K_TLS_CERT"
+
+	// ConfigTLSKeyVarName is env variable for path to TLS private key
+	// file matching certificate.
+	ConfigTLSKeyVarName = "S8K_TLS_KEY"
+
+	// ConfigTLSAutocertDomainsVarName is env variable for comma-separated
+	// list of domains, for which TLS certificates are obtained
+	// automatically from Let's Encrypt.
+	ConfigTLSAutocertDomainsVarName = "S8K_TLS_AUTOCERT_DOMAINS"
+
+	// ConfigTLSAutocertCacheVarName is env variable for directory,
+	// where automatically obtained certificates are cached.
+	ConfigTLSAutocertCacheVarName = "S8K_TLS_AUTOCERT_CACHE"
+
+	// ConfigHTTP2VarName is env variable for enabling HTTP/2 over
+	// cleartext connections (h2c), so event streams can be multiplexed
+	// without TLS.
+	ConfigHTTP2VarName = "S8K_HTTP2"
+
+	// ConfigWebhookURLsVarName is env variable for comma-separated list
+	// of URLs, to which chat events are posted. Webhooks are disabled
+	// when it's not set.
+	ConfigWebhookURLsVarName = "S8K_WEBHOOK_URLS"
+
+	// ConfigWebhookEventsVarName is env variable for comma-separated
+	// list of event types posted to webhooks.
+	ConfigWebhookEventsVarName = "S8K_WEBHOOK_EVENTS"
+
+	// ConfigWebhookSecretVarName is env variable for secret, which
+	// signs webhook payloads. Payloads aren't signed when it's not set.
+	ConfigWebhookSecretVarName = "S8K_WEBHOOK_SECRET"
+
+	// ConfigWebhookTimeoutVarName is env variable for timeout of single
+	// webhook delivery attempt.
+	ConfigWebhookTimeoutVarName = "S8K_WEBHOOK_TIMEOUT"
+
+	// ConfigWebhookRetriesVarName is env variable for number of retries
+	// of failed webhook delivery.
+	ConfigWebhookRetriesVarName = "S8K_WEBHOOK_RETRIES"
+
+	// ConfigAPIKeysVarName is env variable for comma-separated list of
+	// API keys of bots, in key:nickname format. Bot API is disabled
+	// when it's empty.
+	ConfigAPIKeysVarName = "S8K_API_KEYS"
+
+	// ConfigIncomingWebhooksVarName is env variable for comma-separated
+	// list of keys of Slack and Discord compatible incoming webhooks,
+	// in key:nickname format. Incoming webhooks are disabled when it's
+	// empty.
+	ConfigIncomingWebhooksVarName = "S8K_INCOMING_WEBHOOKS"
+
+	// ConfigUploadDirVarName is env variable for directory, in which
+	// uploaded files are stored. Uploads are disabled when it's empty.
+	ConfigUploadDirVarName = "S8K_UPLOAD_DIR"
+
+	// ConfigUploadMaxSizeVarName is env variable for maximal size of
+	// uploaded file in bytes.
+	ConfigUploadMaxSizeVarName = "S8K_UPLOAD_MAX_SIZE"
+
+	// ConfigImportMaxSizeVarName is env variable for maximal size of
+	// body of archive import request in bytes.
+	ConfigImportMaxSizeVarName = "S8K_IMPORT_MAX_SIZE"
+
+	// ConfigUploadContentTypesVarName is env variable for
+	// comma-separated list of allowed media types of uploaded files.
+	ConfigUploadContentTypesVarName = "S8K_UPLOAD_CONTENT_TYPES"
+
+	// ConfigDebugVarName is env variable for enabling debug mode, which
+	// allows insecure settings meant for local development.
+	ConfigDebugVarName = "S8K_DEBUG"
 )
 
 // Default values for configuration variables.
@@ -70,6 +342,9 @@ const (
 	// ConfigTokenizerAES is name for AES tokenizer backend type.
 	ConfigTokenizerAES = "aes"
 
+	// ConfigTokenizerJWT is name for JWT tokenizer backend type.
+	ConfigTokenizerJWT = "jwt"
+
 	// ConfigTokenizerDefaultVal is default value for tokenizer type.
 	ConfigTokenizerDefaultVal = ConfigTokenizerSimple
 
@@ -81,9 +356,195 @@ const (
 	// last message buffer size.
 	ConfigLastMessagesBufferSizeDefaultVal = 10
 
+	// ConfigMessagesStateSizeDefaultVal is default number of recent
+	// messages kept in memory.
+	ConfigMessagesStateSizeDefaultVal = 10000
+
 	// ConfigMaxMessageSizeDefaultVal is default value for maximum
 	// message size (in bytes).
 	ConfigMaxMessageSizeDefaultVal = 255
+
+	// ConfigReadTimeoutDefaultVal is default value for http server
+	// read timeout.
+	ConfigReadTimeoutDefaultVal = time.Second * 15
+
+	// ConfigReadHeaderTimeoutDefaultVal is default value for http
+	// server read header timeout.
+	ConfigReadHeaderTimeoutDefaultVal = time.Second * 5
+
+	// ConfigWriteTimeoutDefaultVal is default value for http server
+	// write timeout.
+	ConfigWriteTimeoutDefaultVal = time.Second * 15
+
+	// ConfigIdleTimeoutDefaultVal is default value for http server
+	// idle timeout.
+	ConfigIdleTimeoutDefaultVal = time.Second * 60
+
+	// ConfigSSEHeartbeatIntervalDefaultVal is default interval of
+	// event stream heartbeats.
+	ConfigSSEHeartbeatIntervalDefaultVal = time.Second * 15
+
+	// ConfigSSEReconnectTimeDefaultVal is default reconnection time hint.
+	// Zero value means that browsers will use their own default.
+	ConfigSSEReconnectTimeDefaultVal = time.Duration(0)
+
+	// ConfigNicknameMinLengthDefaultVal is default value for minimal
+	// nickname length (in runes).
+	ConfigNicknameMinLengthDefaultVal = 1
+
+	// ConfigNicknameMaxLengthDefaultVal is default value for maximal
+	// nickname length (in runes).
+	ConfigNicknameMaxLengthDefaultVal = 32
+
+	// ConfigMetricsEnabledDefaultVal is default value for enabling
+	// metrics endpoint.
+	ConfigMetricsEnabledDefaultVal = false
+
+	// ConfigMarkdownDefaultVal is default value for enabling markdown
+	// rendering.
+	ConfigMarkdownDefaultVal = false
+
+	// ConfigBridgeQueueSizeDefaultVal is default size of event bridge
+	// queue. Zero means that senders wait for the bridge.
+	ConfigBridgeQueueSizeDefaultVal = 0
+
+	// ConfigHookTimeoutDefaultVal is default timeout of single event
+	// hook invocation. It's long enough for webhook deliveries with
+	// default retries.
+	ConfigHookTimeoutDefaultVal = time.Minute
+
+	// ConfigResumeCursorTTLDefaultVal is default time to live of resume
+	// cursors. Clients reconnecting later receive all buffered messages.
+	ConfigResumeCursorTTLDefaultVal = time.Hour
+
+	// ConfigSessionSlidingDefaultVal is default value for enabling
+	// sliding sessions.
+	ConfigSessionSlidingDefaultVal = false
+
+	// ConfigSessionSlidingWindowDefaultVal is default sliding window
+	// of sessions.
+	ConfigSessionSlidingWindowDefaultVal = time.Hour * 24
+
+	// ConfigCookieSecureDefaultVal is default value for Secure attribute
+	// of session cookie.
+	ConfigCookieSecureDefaultVal = false
+
+	// ConfigCookieSameSiteDefaultVal is default value for SameSite
+	// attribute of session cookie.
+	ConfigCookieSameSiteDefaultVal = http.SameSiteLaxMode
+
+	// ConfigSessionRevocationDefaultVal is default value for enabling
+	// session revocation list.
+	ConfigSessionRevocationDefaultVal = false
+
+	// ConfigMessageRateDefaultVal is default rate of sent messages. Zero
+	// disables rate limiting.
+	ConfigMessageRateDefaultVal = 1.0
+
+	// ConfigMessageBurstDefaultVal is default burst of sent messages.
+	ConfigMessageBurstDefaultVal = 5
+
+	// ConfigFloodViolationsDefaultVal is default number of message rate
+	// limit violations, after which session is muted.
+	ConfigFloodViolationsDefaultVal = 10
+
+	// ConfigFloodWindowDefaultVal is default period, in which message
+	// rate limit violations are counted.
+	ConfigFloodWindowDefaultVal = time.Minute
+
+	// ConfigFloodMuteDefaultVal is default duration of mute of flooding
+	// session.
+	ConfigFloodMuteDefaultVal = time.Minute * 5
+
+	// ConfigLogFormatText is name for human readable log format.
+	ConfigLogFormatText = "text"
+
+	// ConfigLogFormatJSON is name for JSON log format.
+	ConfigLogFormatJSON = "json"
+
+	// ConfigLogFormatDefaultVal is default value for log format.
+	ConfigLogFormatDefaultVal = ConfigLogFormatText
+
+	// ConfigLogLevelDefaultVal is default minimal level of logged
+	// entries.
+	ConfigLogLevelDefaultVal = logrus.InfoLevel
+
+	// ConfigRetentionDefaultVal is default retention of events. Zero
+	// means that events are kept forever.
+	ConfigRetentionDefaultVal = time.Duration(0)
+
+	// ConfigEphemeralRetentionDefaultVal is default retention of
+	// ephemeral events. Zero means that they're kept as long as
+	// the other events.
+	ConfigEphemeralRetentionDefaultVal = time.Duration(0)
+
+	// ConfigAwayTimeoutDefaultVal is default period of inactivity
+	// after which user is marked as away. Zero means that users
+	// are never marked as away automatically.
+	ConfigAwayTimeoutDefaultVal = time.Minute * 5
+
+	// ConfigPresenceTTLDefaultVal is default period without event
+	// stream heartbeat, after which user is removed from chat.
+	ConfigPresenceTTLDefaultVal = time.Minute * 3
+
+	// ConfigSlowClientDefaultVal is default policy of handling slow
+	// event stream clients.
+	ConfigSlowClientDefaultVal = SlowClientDrop
+
+	// ConfigSSEBufferSizeDefaultVal is default number of events
+	// buffered for single event stream client.
+	ConfigSSEBufferSizeDefaultVal = 64
+
+	// ConfigMaxConnsDefaultVal is default maximal number of concurrently
+	// open event streams. Zero means no limit.
+	ConfigMaxConnsDefaultVal = 0
+
+	// ConfigMaxConnsPerUserDefaultVal is default maximal number of
+	// concurrently open event streams of single user. Zero means no limit.
+	ConfigMaxConnsPerUserDefaultVal = 0
+
+	// ConfigMaxAcksDefaultVal is default maximal number of message
+	// acknowledgements of sessions in chat channels held in memory.
+	// Zero disables message acknowledgements.
+	ConfigMaxAcksDefaultVal = 10000
+
+	// ConfigTokenizerCacheSizeDefaultVal is default maximal number of
+	// session tokens held in tokenizer cache. Zero means unbounded cache.
+	ConfigTokenizerCacheSizeDefaultVal = 0
+
+	// ConfigTLSAutocertCacheDefaultVal is default directory for
+	// automatically obtained certificates.
+	ConfigTLSAutocertCacheDefaultVal = "autocert"
+
+	// ConfigHTTP2DefaultVal is default value for enabling h2c.
+	ConfigHTTP2DefaultVal = false
+
+	// ConfigWebhookEventsDefaultVal is default comma-separated list of
+	// event types posted to webhooks.
+	ConfigWebhookEventsDefaultVal = "message-sent,message-edited,message-deleted,user-join,user-left"
+
+	// ConfigWebhookTimeoutDefaultVal is default timeout of single webhook
+	// delivery attempt.
+	ConfigWebhookTimeoutDefaultVal = time.Second * 5
+
+	// ConfigWebhookRetriesDefaultVal is default number of retries of
+	// failed webhook delivery.
+	ConfigWebhookRetriesDefaultVal = 3
+
+	// ConfigUploadMaxSizeDefaultVal is default maximal size of uploaded
+	// file in bytes.
+	ConfigUploadMaxSizeDefaultVal = 10 << 20
+
+	// ConfigImportMaxSizeDefaultVal is default maximal size of body
+	// of archive import request in bytes.
+	ConfigImportMaxSizeDefaultVal = 1 << 30
+
+	// ConfigUploadContentTypesDefaultVal is default comma-separated
+	// list of allowed media types of uploaded files.
+	ConfigUploadContentTypesDefaultVal = "image/png,image/jpeg,image/gif,image/webp"
+
+	// ConfigDebugDefaultVal is default value for enabling debug mode.
+	ConfigDebugDefaultVal = false
 )
 
 // ConfigVariables represents state read from environmental
@@ -91,6 +552,8 @@ const (
 type ConfigVariables struct {
 	// Address is combination of IP addres and port
 	// which is used for listening to TCP/IP connections.
+	// Address with empty host, for example ":8080", listens
+	// on both IPv4 and IPv6 interfaces.
 	Address string
 
 	// Tokenizer is name of tokenizer type backend that should be
@@ -101,6 +564,10 @@ type ConfigVariables struct {
 	// and decrypt session state data if tokenizer age was chose.
 	SessionSecret string
 
+	// SessionSecretOld are previous session secrets, which are used
+	// only for decoding of session tokens.
+	SessionSecretOld []string
+
 	// Database holds connection string for szmaterlok event storage.
 	Database string
 
@@ -108,12 +575,245 @@ type ConfigVariables struct {
 	// messages buffer that is sent to the users, when they're joining chat.
 	LastMessagesBufferSize int
 
+	// MessagesStateSize is number of recent messages kept in memory.
+	// Older messages can't be edited, deleted or acknowledged anymore.
+	MessagesStateSize int
+
 	// MaximumMessageSize is maximal number of runes for single message.
 	MaximumMessageSize int
+
+	// ReadTimeout is maximum duration for reading the entire
+	// http request, including the body.
+	ReadTimeout time.Duration
+
+	// ReadHeaderTimeout is the amount of time allowed to read
+	// http request headers.
+	ReadHeaderTimeout time.Duration
+
+	// WriteTimeout is the maximum duration before timing out
+	// writes of the http response. Event stream handler is
+	// exempted from this timeout.
+	WriteTimeout time.Duration
+
+	// IdleTimeout is the maximum amount of time to wait for the
+	// next request when keep-alives are enabled.
+	IdleTimeout time.Duration
+
+	// SSEHeartbeatInterval is period of inactivity after which event
+	// stream sends keep-alive comment to the client. Zero disables
+	// heartbeats.
+	SSEHeartbeatInterval time.Duration
+
+	// SSEReconnectTime is time which browsers wait before reconnecting
+	// to the event stream after losing connection.
+	SSEReconnectTime time.Duration
+
+	// NicknameMinLength is minimal number of runes for user nickname.
+	NicknameMinLength int
+
+	// NicknameMaxLength is maximal number of runes for user nickname.
+	NicknameMaxLength int
+
+	// MetricsEnabled turns on /metrics endpoint with prometheus metrics.
+	MetricsEnabled bool
+
+	// BridgeQueueSize is number of events which can wait for event
+	// bridge without blocking their senders.
+	BridgeQueueSize int
+
+	// PersistPolicy selects types of events stored in event storage.
+	// Types set in environment override PersistPolicyDefault.
+	PersistPolicy PersistPolicy
+
+	// HookTimeout is timeout of single event hook invocation. Hooks
+	// running longer are abandoned. Zero disables timeout. It has to
+	// cover all of webhook delivery attempts, which are cancelled
+	// together with the hook.
+	HookTimeout time.Duration
+
+	// ResumeCursorTTL is time to live of resume cursors sent as event
+	// stream IDs. Zero means that cursors never expire.
+	ResumeCursorTTL time.Duration
+
+	// SessionSliding enables re-issuing of sessions, which are about
+	// to expire.
+	SessionSliding bool
+
+	// SessionSlidingWindow is period before session expiration in
+	// which session is re-issued, when sliding sessions are enabled.
+	SessionSlidingWindow time.Duration
+
+	// CookieSecure restricts session cookie to HTTPS connections.
+	CookieSecure bool
+
+	// CookieSameSite is SameSite attribute of session cookie.
+	CookieSameSite http.SameSite
+
+	// SessionRevocation enables server-side revocation list of
+	// sessions, which is checked on every authenticated request.
+	SessionRevocation bool
+
+	// MessageRate is number of messages per second, which single
+	// session can send. Zero disables rate limiting.
+	MessageRate float64
+
+	// MessageBurst is number of messages, which single session can
+	// send at once before it is rate limited.
+	MessageBurst int
+
+	// FloodViolations is number of message rate limit violations within
+	// FloodWindow, after which session is muted for FloodMute. Zero
+	// disables muting.
+	FloodViolations int
+	FloodWindow     time.Duration
+	FloodMute       time.Duration
+
+	// SlowMode maps chat channels to minimal intervals between
+	// messages sent to them by single user.
+	SlowMode map[string]time.Duration
+
+	// CORSOrigins is list of origins allowed to make cross-origin
+	// requests. CORS is disabled when it's empty.
+	CORSOrigins []string
+
+	// LogFormat is format of logs: text or json.
+	LogFormat string
+
+	// LogLevel is minimal level of logged entries.
+	LogLevel logrus.Level
+
+	// Retention is period after which events are deleted. Zero
+	// disables pruning.
+	Retention time.Duration
+
+	// EphemeralRetention is period after which ephemeral events are
+	// deleted. It is usually shorter than Retention.
+	EphemeralRetention time.Duration
+
+	// AdminToken grants access to admin resources. They're disabled
+	// when it's empty.
+	AdminToken string
+
+	// Admins restricts admin privileges granted at login with admin
+	// token to listed nicknames.
+	Admins []string
+
+	// WordFilterFile is path to file with forbidden words. Messages
+	// aren't filtered when it's empty.
+	WordFilterFile string
+
+	// Markdown turns on rendering of message content from markdown
+	// to sanitized HTML.
+	Markdown bool
+
+	// AwayTimeout is period of inactivity after which user is marked
+	// as away. Zero disables automatic away status.
+	AwayTimeout time.Duration
+
+	// PresenceTTL is period without event stream heartbeat, after which
+	// user is removed from chat. Zero disables removal of such users.
+	PresenceTTL time.Duration
+
+	// SlowClient is policy of handling event stream clients, which
+	// can't keep up with events.
+	SlowClient SlowClientPolicy
+
+	// SSEBufferSize is number of events buffered for single event
+	// stream client.
+	SSEBufferSize int
+
+	// MaxConns is maximal number of concurrently open event streams.
+	// Zero means no limit.
+	MaxConns int
+
+	// MaxConnsPerUser is maximal number of concurrently open event
+	// streams of single user. Zero means no limit.
+	MaxConnsPerUser int
+
+	// MaxAcks is maximal number of message acknowledgements of
+	// sessions in chat channels held in memory. Zero disables message
+	// acknowledgements.
+	MaxAcks int
+
+	// TokenizerCacheSize is maximal number of session tokens held in
+	// tokenizer cache. Zero means unbounded cache.
+	TokenizerCacheSize int
+
+	// TLSCert and TLSKey are paths to TLS certificate and its private
+	// key. Server listens on plain HTTP when they're empty.
+	TLSCert string
+	TLSKey  string
+
+	// TLSAutocertDomains are domains, for which TLS certificates are
+	// obtained automatically. Autocert is disabled when it's empty.
+	TLSAutocertDomains []string
+
+	// TLSAutocertCache is directory, where automatically obtained
+	// certificates are cached.
+	TLSAutocertCache string
+
+	// HTTP2 turns on HTTP/2 over cleartext connections (h2c).
+	HTTP2 bool
+
+	// WebhookURLs are endpoints, to which chat events are posted.
+	// Webhooks are disabled when it's empty.
+	WebhookURLs []string
+
+	// WebhookEvents are types of events posted to webhooks.
+	WebhookEvents []string
+
+	// WebhookSecret signs webhook payloads with HMAC.
+	WebhookSecret string
+
+	// WebhookTimeout is timeout of single webhook delivery attempt.
+	WebhookTimeout time.Duration
+
+	// WebhookRetries is number of retries of failed webhook delivery.
+	WebhookRetries int
+
+	// APIKeys map API keys to nicknames of bots, which can send
+	// messages without session.
+	APIKeys map[string]string
+
+	// IncomingWebhooks map keys of incoming webhooks to nicknames of
+	// bots posting with them.
+	IncomingWebhooks map[string]string
+
+	// UploadDir is directory, in which uploaded files are stored.
+	// Uploads are disabled when it's empty.
+	UploadDir string
+
+	// UploadMaxSize is maximal size of uploaded file in bytes.
+	UploadMaxSize int64
+
+	// UploadContentTypes are allowed media types of uploaded files.
+	UploadContentTypes []string
+
+	// ImportMaxSize is maximal size of body of archive import
+	// request in bytes.
+	ImportMaxSize int64
+
+	// Debug mode allows insecure settings, for example default
+	// session secret.
+	Debug bool
 }
 
+// configEnvironNames holds names of env variables, which have been set
+// before ConfigLoad loaded config files. It's nil until ConfigLoad is
+// called.
+var configEnvironNames map[string]struct{}
+
 // ConfigLoad loads all the config files with environmental variables.
+// Variables which are already set aren't overwritten, so env variables
+// take precedence over system config file, which takes precedence over
+// local config file.
 func ConfigLoad(ctx context.Context) error {
+	configEnvironNames = make(map[string]struct{})
+	for _, kv := range os.Environ() {
+		name, _, _ := strings.Cut(kv, "=")
+		configEnvironNames[name] = struct{}{}
+	}
+
 	if err := env.Load(ConfigSystemFile); err != nil {
 		log.Printf("config: failed to open system config file: %s", err)
 	}
@@ -125,6 +825,51 @@ func ConfigLoad(ctx context.Context) error {
 	return nil
 }
 
+// ConfigReloadFiles reads config files again and returns getenv func,
+// which can be passed to ConfigReadEnv. Variables are resolved with the
+// same precedence as with ConfigLoad, but process environment is left
+// intact.
+func ConfigReloadFiles(ctx context.Context) (func(string) string, error) {
+	lookup := os.LookupEnv
+	if configEnvironNames != nil {
+		lookup = func(name string) (string, bool) {
+			if _, ok := configEnvironNames[name]; !ok {
+				return "", false
+			}
+			return os.LookupEnv(name)
+		}
+	}
+
+	return configReloadEnv(lookup, ConfigSystemFile, ConfigLocalFile), nil
+}
+
+// configReloadEnv returns getenv func, which looks up variables with
+// given lookup func first and then in given config files, in their
+// order. Files which can't be read are skipped.
+func configReloadEnv(lookup func(string) (string, bool), files ...string) func(string) string {
+	vars := make([]map[string]string, 0, len(files))
+	for _, path := range files {
+		fileVars, err := env.Read(path)
+		if err != nil {
+			log.Printf("config: failed to read config file: %s", err)
+			continue
+		}
+		vars = append(vars, fileVars)
+	}
+
+	return func(name string) string {
+		if val, ok := lookup(name); ok {
+			return val
+		}
+		for _, fileVars := range vars {
+			if val, ok := fileVars[name]; ok {
+				return val
+			}
+		}
+		return ""
+	}
+}
+
 // ConfigDefault returns default configuration for szmaterlok.
 func ConfigDefault() ConfigVariables {
 	return ConfigVariables{
@@ -133,30 +878,275 @@ func ConfigDefault() ConfigVariables {
 		Tokenizer:              ConfigTokenizerDefaultVal,
 		Database:               ConfigDatabasePathDefaultVal,
 		LastMessagesBufferSize: ConfigLastMessagesBufferSizeDefaultVal,
+		MessagesStateSize:      ConfigMessagesStateSizeDefaultVal,
 		MaximumMessageSize:     ConfigMaxMessageSizeDefaultVal,
+		ReadTimeout:            ConfigReadTimeoutDefaultVal,
+		ReadHeaderTimeout:      ConfigReadHeaderTimeoutDefaultVal,
+		WriteTimeout:           ConfigWriteTimeoutDefaultVal,
+		IdleTimeout:            ConfigIdleTimeoutDefaultVal,
+		SSEHeartbeatInterval:   ConfigSSEHeartbeatIntervalDefaultVal,
+		SSEReconnectTime:       ConfigSSEReconnectTimeDefaultVal,
+		NicknameMinLength:      ConfigNicknameMinLengthDefaultVal,
+		NicknameMaxLength:      ConfigNicknameMaxLengthDefaultVal,
+		MetricsEnabled:         ConfigMetricsEnabledDefaultVal,
+		Markdown:               ConfigMarkdownDefaultVal,
+		BridgeQueueSize:        ConfigBridgeQueueSizeDefaultVal,
+		PersistPolicy:          PersistPolicyDefault(),
+		HookTimeout:            ConfigHookTimeoutDefaultVal,
+		ResumeCursorTTL:        ConfigResumeCursorTTLDefaultVal,
+		SessionSliding:         ConfigSessionSlidingDefaultVal,
+		SessionSlidingWindow:   ConfigSessionSlidingWindowDefaultVal,
+		CookieSecure:           ConfigCookieSecureDefaultVal,
+		CookieSameSite:         ConfigCookieSameSiteDefaultVal,
+		SessionRevocation:      ConfigSessionRevocationDefaultVal,
+		MessageRate:            ConfigMessageRateDefaultVal,
+		MessageBurst:           ConfigMessageBurstDefaultVal,
+		FloodViolations:        ConfigFloodViolationsDefaultVal,
+		FloodWindow:            ConfigFloodWindowDefaultVal,
+		FloodMute:              ConfigFloodMuteDefaultVal,
+		LogFormat:              ConfigLogFormatDefaultVal,
+		LogLevel:               ConfigLogLevelDefaultVal,
+		Retention:              ConfigRetentionDefaultVal,
+		EphemeralRetention:     ConfigEphemeralRetentionDefaultVal,
+		AwayTimeout:            ConfigAwayTimeoutDefaultVal,
+		PresenceTTL:            ConfigPresenceTTLDefaultVal,
+		SlowClient:             ConfigSlowClientDefaultVal,
+		SSEBufferSize:          ConfigSSEBufferSizeDefaultVal,
+		MaxConns:               ConfigMaxConnsDefaultVal,
+		MaxConnsPerUser:        ConfigMaxConnsPerUserDefaultVal,
+		MaxAcks:                ConfigMaxAcksDefaultVal,
+		TokenizerCacheSize:     ConfigTokenizerCacheSizeDefaultVal,
+		TLSAutocertCache:       ConfigTLSAutocertCacheDefaultVal,
+		HTTP2:                  ConfigHTTP2DefaultVal,
+		WebhookEvents:          configParseList(ConfigWebhookEventsDefaultVal),
+		WebhookTimeout:         ConfigWebhookTimeoutDefaultVal,
+		WebhookRetries:         ConfigWebhookRetriesDefaultVal,
+		UploadMaxSize:          ConfigUploadMaxSizeDefaultVal,
+		UploadContentTypes:     configParseList(ConfigUploadContentTypesDefaultVal),
+		ImportMaxSize:          ConfigImportMaxSizeDefaultVal,
+		Debug:                  ConfigDebugDefaultVal,
 	}
 }
 
 // ConfigRead overwrites fields of given config variables with
 // their environmental correspondent values (when they're set).
+// When S8K_CONFIG points to config file, it's read first, so
+// environmental variables take precedence over the file.
 func ConfigRead(c *ConfigVariables) error {
-	if addr := os.Getenv(ConfigAddressVarName); addr != "" {
-		c.Address = addr
+	return ConfigReadEnv(c, os.Getenv)
+}
+
+// ConfigReadEnv is ConfigRead, which reads environmental variables
+// with given getenv func.
+func ConfigReadEnv(c *ConfigVariables, getenv func(string) string) error {
+	if path := getenv(ConfigFileVarName); path != "" {
+		if err := ConfigReadFile(path, c); err != nil {
+			return err
+		}
+	}
+
+	return configRead(c, getenv)
+}
+
+// ConfigFlags holds values of command line flags. Flags take
+// precedence over env variables and config file.
+type ConfigFlags struct {
+	// Version is set, when build information should be printed.
+	Version bool
+
+	// vals maps names of env variables to values of flags, which
+	// have been set explicitly.
+	vals map[string]string
+}
+
+// configFlags lists command line flags along with env variables
+// they override.
+var configFlags = []struct {
+	name    string
+	varName string
+	usage   string
+}{
+	{name: "addr", varName: ConfigAddressVarName, usage: "listening address"},
+	{name: "db", varName: ConfigDatabasePathVarName, usage: "database connection string"},
+	{name: "tokenizer", varName: ConfigTokenizerVarName, usage: "session tokenizer: simple, age, aes or jwt"},
+	{name: "secret", varName: ConfigSessionSecretVarName, usage: "session secret"},
+	{name: "log-level", varName: ConfigLogLevelVarName, usage: "log level"},
+}
+
+// ConfigParseFlags parses given command line arguments without program
+// name. Usage and parsing errors are written to given output.
+func ConfigParseFlags(args []string, output io.Writer) (*ConfigFlags, error) {
+	fs := flag.NewFlagSet("szmaterlok", flag.ContinueOnError)
+	fs.SetOutput(output)
+
+	res := &ConfigFlags{
+		vals: map[string]string{},
+	}
+	fs.BoolVar(&res.Version, "version", false, "print build information and exit")
+
+	vals := map[string]*string{}
+	for _, f := range configFlags {
+		vals[f.name] = fs.String(f.name, "", fmt.Sprintf("%s (overrides %s)", f.usage, f.varName))
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected arguments: %s", strings.Join(fs.Args(), " "))
 	}
 
-	if secret := os.Getenv(ConfigSessionSecretVarName); secret != "" {
+	// Only flags set explicitly override config.
+	fs.Visit(func(f *flag.Flag) {
+		for _, cf := range configFlags {
+			if cf.name == f.Name {
+				res.vals[cf.varName] = *vals[f.Name]
+			}
+		}
+	})
+
+	return res, nil
+}
+
+// Apply overwrites fields of given config variables with values of
+// flags, which have been set.
+func (f *ConfigFlags) Apply(c *ConfigVariables) error {
+	return configRead(c, func(name string) string {
+		return f.vals[name]
+	})
+}
+
+// ConfigReadFile overwrites fields of given config variables with
+// values from YAML (.yaml, .yml) or JSON (.json) file with given path.
+// Keys of the file are names of env variables, case-insensitive and
+// with optional S8K_ prefix, for example "S8K_ADDR" or "addr". Lists
+// can be given either as arrays or comma-separated strings.
+func ConfigReadFile(path string, c *ConfigVariables) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	raw := map[string]interface{}{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(b, &raw)
+	case ".json":
+		err = json.Unmarshal(b, &raw)
+	default:
+		return fmt.Errorf("unsupported config file format: %s", path)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+
+	vals := map[string]string{}
+	for key, val := range raw {
+		name := strings.ToUpper(key)
+		if !strings.HasPrefix(name, "S8K_") {
+			name = "S8K_" + name
+		}
+
+		v, err := configFileValue(val)
+		if err != nil {
+			return fmt.Errorf("invalid value of %s key in config file: %w", key, err)
+		}
+		vals[name] = v
+	}
+
+	// Every key read by configRead is removed, so the ones left are
+	// unknown.
+	err = configRead(c, func(name string) string {
+		v := vals[name]
+		delete(vals, name)
+		return v
+	})
+	if err != nil {
+		return fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+
+	if len(vals) > 0 {
+		unknown := maps.Keys(vals)
+		slices.Sort(unknown)
+		return fmt.Errorf("unknown keys in config file %s: %s", path, strings.Join(unknown, ", "))
+	}
+
+	return nil
+}
+
+// configFileValue converts value decoded from config file into its
+// env variable form.
+func configFileValue(val interface{}) (string, error) {
+	switch v := val.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case int:
+		return strconv.Itoa(v), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case uint64:
+		return strconv.FormatUint(v, 10), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case []interface{}:
+		res := []string{}
+		for _, item := range v {
+			s, err := configFileValue(item)
+			if err != nil {
+				return "", err
+			}
+			res = append(res, s)
+		}
+		return strings.Join(res, ","), nil
+	default:
+		return "", fmt.Errorf("unsupported type %T", val)
+	}
+}
+
+// configRead overwrites fields of given config variables with values
+// returned by getenv for their env variable names (when they're set).
+func configRead(c *ConfigVariables, getenv func(string) string) error {
+	if addr := getenv(ConfigAddressVarName); addr != "" {
+		addrParsed, err := configParseAddress(addr)
+		if err != nil {
+			return err
+		}
+		c.Address = addrParsed
+	}
+
+	if secret := getenv(ConfigSessionSecretVarName); secret != "" {
 		c.SessionSecret = secret
 	}
 
-	if tokenizer := os.Getenv(ConfigTokenizerVarName); tokenizer != "" {
+	if old := getenv(ConfigSessionSecretOldVarName); old != "" {
+		c.SessionSecretOld = configParseList(old)
+	}
+
+	if tokenizer := getenv(ConfigTokenizerVarName); tokenizer != "" {
 		c.Tokenizer = tokenizer
 	}
 
-	if db := os.Getenv(ConfigDatabasePathVarName); db != "" {
+	if at := getenv(ConfigAdminTokenVarName); at != "" {
+		c.AdminToken = at
+	}
+
+	if admins := getenv(ConfigAdminsVarName); admins != "" {
+		c.Admins = configParseList(admins)
+	}
+
+	if wf := getenv(ConfigWordFilterFileVarName); wf != "" {
+		c.WordFilterFile = wf
+	}
+
+	if db := getenv(ConfigDatabasePathVarName); db != "" {
 		c.Database = db
 	}
 
-	if lmbs := os.Getenv(ConfigLastMessagesBufferSizeVarName); lmbs != "" {
+	if lmbs := getenv(ConfigLastMessagesBufferSizeVarName); lmbs != "" {
 		lmbsParsed, err := strconv.Atoi(lmbs)
 		if err != nil {
 			return fmt.Errorf("failed to parse last message buffer size config value: %w", err)
@@ -164,7 +1154,15 @@ func ConfigRead(c *ConfigVariables) error {
 		c.LastMessagesBufferSize = lmbsParsed
 	}
 
-	if mms := os.Getenv(ConfigMaxMessageSizeVarName); mms != "" {
+	if mss := getenv(ConfigMessagesStateSizeVarName); mss != "" {
+		mssParsed, err := strconv.Atoi(mss)
+		if err != nil {
+			return fmt.Errorf("failed to parse messages state size config value: %w", err)
+		}
+		c.MessagesStateSize = mssParsed
+	}
+
+	if mms := getenv(ConfigMaxMessageSizeVarName); mms != "" {
 		mmsParsed, err := strconv.Atoi(mms)
 		if err != nil {
 			return fmt.Errorf("failed to parse maximal message size: %w", err)
@@ -172,5 +1170,603 @@ func ConfigRead(c *ConfigVariables) error {
 		c.MaximumMessageSize = mmsParsed
 	}
 
+	if nmin := getenv(ConfigNicknameMinLengthVarName); nmin != "" {
+		nminParsed, err := strconv.Atoi(nmin)
+		if err != nil {
+			return fmt.Errorf("failed to parse minimal nickname length: %w", err)
+		}
+		c.NicknameMinLength = nminParsed
+	}
+
+	if nmax := getenv(ConfigNicknameMaxLengthVarName); nmax != "" {
+		nmaxParsed, err := strconv.Atoi(nmax)
+		if err != nil {
+			return fmt.Errorf("failed to parse maximal nickname length: %w", err)
+		}
+		c.NicknameMaxLength = nmaxParsed
+	}
+
+	if me := getenv(ConfigMetricsEnabledVarName); me != "" {
+		meParsed, err := strconv.ParseBool(me)
+		if err != nil {
+			return fmt.Errorf("failed to parse metrics enabled flag: %w", err)
+		}
+		c.MetricsEnabled = meParsed
+	}
+
+	if md := getenv(ConfigMarkdownVarName); md != "" {
+		mdParsed, err := strconv.ParseBool(md)
+		if err != nil {
+			return fmt.Errorf("failed to parse markdown flag: %w", err)
+		}
+		c.Markdown = mdParsed
+	}
+
+	if dbg := getenv(ConfigDebugVarName); dbg != "" {
+		dbgParsed, err := strconv.ParseBool(dbg)
+		if err != nil {
+			return fmt.Errorf("failed to parse debug flag: %w", err)
+		}
+		c.Debug = dbgParsed
+	}
+
+	if ss := getenv(ConfigSessionSlidingVarName); ss != "" {
+		ssParsed, err := strconv.ParseBool(ss)
+		if err != nil {
+			return fmt.Errorf("failed to parse session sliding flag: %w", err)
+		}
+		c.SessionSliding = ssParsed
+	}
+
+	if cs := getenv(ConfigCookieSecureVarName); cs != "" {
+		csParsed, err := strconv.ParseBool(cs)
+		if err != nil {
+			return fmt.Errorf("failed to parse cookie secure flag: %w", err)
+		}
+		c.CookieSecure = csParsed
+	}
+
+	if mr := getenv(ConfigMessageRateVarName); mr != "" {
+		mrParsed, err := strconv.ParseFloat(mr, 64)
+		if err != nil {
+			return fmt.Errorf("failed to parse message rate: %w", err)
+		}
+		if mrParsed < 0 {
+			return fmt.Errorf("message rate cannot be negative: %s", mr)
+		}
+		c.MessageRate = mrParsed
+	}
+
+	if mb := getenv(ConfigMessageBurstVarName); mb != "" {
+		mbParsed, err := strconv.Atoi(mb)
+		if err != nil {
+			return fmt.Errorf("failed to parse message burst: %w", err)
+		}
+		if mbParsed < 1 {
+			return fmt.Errorf("message burst must be positive: %s", mb)
+		}
+		c.MessageBurst = mbParsed
+	}
+
+	if fv := getenv(ConfigFloodViolationsVarName); fv != "" {
+		fvParsed, err := strconv.Atoi(fv)
+		if err != nil {
+			return fmt.Errorf("failed to parse flood violations: %w", err)
+		}
+		if fvParsed < 0 {
+			return fmt.Errorf("flood violations cannot be negative: %s", fv)
+		}
+		c.FloodViolations = fvParsed
+	}
+
+	if sm := getenv(ConfigSlowModeVarName); sm != "" {
+		slowMode, err := configParseSlowMode(sm)
+		if err != nil {
+			return err
+		}
+		c.SlowMode = slowMode
+	}
+
+	if sr := getenv(ConfigSessionRevocationVarName); sr != "" {
+		srParsed, err := strconv.ParseBool(sr)
+		if err != nil {
+			return fmt.Errorf("failed to parse session revocation flag: %w", err)
+		}
+		c.SessionRevocation = srParsed
+	}
+
+	if css := getenv(ConfigCookieSameSiteVarName); css != "" {
+		cssParsed, err := configParseSameSite(css)
+		if err != nil {
+			return err
+		}
+		c.CookieSameSite = cssParsed
+	}
+
+	if co := getenv(ConfigCORSOriginsVarName); co != "" {
+		c.CORSOrigins = configParseList(co)
+	}
+
+	if lf := getenv(ConfigLogFormatVarName); lf != "" {
+		switch lf {
+		case ConfigLogFormatText, ConfigLogFormatJSON:
+			c.LogFormat = lf
+		default:
+			return fmt.Errorf("invalid log format: %s", lf)
+		}
+	}
+
+	if ll := getenv(ConfigLogLevelVarName); ll != "" {
+		llParsed, err := logrus.ParseLevel(ll)
+		if err != nil {
+			return fmt.Errorf("failed to parse log level: %w", err)
+		}
+		c.LogLevel = llParsed
+	}
+
+	if bqs := getenv(ConfigBridgeQueueSizeVarName); bqs != "" {
+		bqsParsed, err := strconv.Atoi(bqs)
+		if err != nil {
+			return fmt.Errorf("failed to parse bridge queue size: %w", err)
+		}
+		if bqsParsed < 0 {
+			return fmt.Errorf("bridge queue size cannot be negative: %d", bqsParsed)
+		}
+		c.BridgeQueueSize = bqsParsed
+	}
+
+	if pp := getenv(ConfigPersistPolicyVarName); pp != "" {
+		policy, err := configParsePersistPolicy(pp)
+		if err != nil {
+			return err
+		}
+		for t, persist := range policy {
+			c.PersistPolicy[t] = persist
+		}
+	}
+
+	if sc := getenv(ConfigSlowClientVarName); sc != "" {
+		scParsed, err := configParseSlowClient(sc)
+		if err != nil {
+			return err
+		}
+		c.SlowClient = scParsed
+	}
+
+	if sbs := getenv(ConfigSSEBufferSizeVarName); sbs != "" {
+		sbsParsed, err := strconv.Atoi(sbs)
+		if err != nil {
+			return fmt.Errorf("failed to parse event stream buffer size: %w", err)
+		}
+		if sbsParsed < 0 {
+			return fmt.Errorf("event stream buffer size cannot be negative: %d", sbsParsed)
+		}
+		c.SSEBufferSize = sbsParsed
+	}
+
+	if mc := getenv(ConfigMaxConnsVarName); mc != "" {
+		mcParsed, err := strconv.Atoi(mc)
+		if err != nil {
+			return fmt.Errorf("failed to parse maximal number of connections: %w", err)
+		}
+		if mcParsed < 0 {
+			return fmt.Errorf("maximal number of connections cannot be negative: %d", mcParsed)
+		}
+		c.MaxConns = mcParsed
+	}
+
+	if mcpu := getenv(ConfigMaxConnsPerUserVarName); mcpu != "" {
+		mcpuParsed, err := strconv.Atoi(mcpu)
+		if err != nil {
+			return fmt.Errorf("failed to parse maximal number of connections per user: %w", err)
+		}
+		if mcpuParsed < 0 {
+			return fmt.Errorf("maximal number of connections per user cannot be negative: %d", mcpuParsed)
+		}
+		c.MaxConnsPerUser = mcpuParsed
+	}
+
+	if ma := getenv(ConfigMaxAcksVarName); ma != "" {
+		maParsed, err := strconv.Atoi(ma)
+		if err != nil {
+			return fmt.Errorf("failed to parse maximal number of acknowledging sessions: %w", err)
+		}
+		if maParsed < 0 {
+			return fmt.Errorf("maximal number of acknowledging sessions cannot be negative: %d", maParsed)
+		}
+		c.MaxAcks = maParsed
+	}
+
+	if tcs := getenv(ConfigTokenizerCacheSizeVarName); tcs != "" {
+		tcsParsed, err := strconv.Atoi(tcs)
+		if err != nil {
+			return fmt.Errorf("failed to parse tokenizer cache size: %w", err)
+		}
+		if tcsParsed < 0 {
+			return fmt.Errorf("tokenizer cache size cannot be negative: %d", tcsParsed)
+		}
+		c.TokenizerCacheSize = tcsParsed
+	}
+
+	if cert := getenv(ConfigTLSCertVarName); cert != "" {
+		c.TLSCert = cert
+	}
+
+	if key := getenv(ConfigTLSKeyVarName); key != "" {
+		c.TLSKey = key
+	}
+
+	if ad := getenv(ConfigTLSAutocertDomainsVarName); ad != "" {
+		c.TLSAutocertDomains = configParseList(ad)
+	}
+
+	if ac := getenv(ConfigTLSAutocertCacheVarName); ac != "" {
+		c.TLSAutocertCache = ac
+	}
+
+	if h2 := getenv(ConfigHTTP2VarName); h2 != "" {
+		h2Parsed, err := strconv.ParseBool(h2)
+		if err != nil {
+			return fmt.Errorf("failed to parse http2 flag: %w", err)
+		}
+		c.HTTP2 = h2Parsed
+	}
+
+	if wu := getenv(ConfigWebhookURLsVarName); wu != "" {
+		urls := configParseList(wu)
+		for _, u := range urls {
+			parsed, err := url.Parse(u)
+			if err != nil {
+				return fmt.Errorf("failed to parse webhook url: %w", err)
+			}
+			if (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+				return fmt.Errorf("invalid webhook url: %s", u)
+			}
+		}
+		c.WebhookURLs = urls
+	}
+
+	if we := getenv(ConfigWebhookEventsVarName); we != "" {
+		c.WebhookEvents = configParseList(we)
+	}
+
+	if ws := getenv(ConfigWebhookSecretVarName); ws != "" {
+		c.WebhookSecret = ws
+	}
+
+	if wr := getenv(ConfigWebhookRetriesVarName); wr != "" {
+		wrParsed, err := strconv.Atoi(wr)
+		if err != nil {
+			return fmt.Errorf("failed to parse webhook retries: %w", err)
+		}
+		if wrParsed < 0 {
+			return fmt.Errorf("webhook retries cannot be negative: %d", wrParsed)
+		}
+		c.WebhookRetries = wrParsed
+	}
+
+	if ak := getenv(ConfigAPIKeysVarName); ak != "" {
+		keys, err := configParseKeys("api key", ak)
+		if err != nil {
+			return err
+		}
+		c.APIKeys = keys
+	}
+
+	if iw := getenv(ConfigIncomingWebhooksVarName); iw != "" {
+		keys, err := configParseKeys("incoming webhook key", iw)
+		if err != nil {
+			return err
+		}
+		c.IncomingWebhooks = keys
+	}
+
+	if ud := getenv(ConfigUploadDirVarName); ud != "" {
+		c.UploadDir = ud
+	}
+
+	if ums := getenv(ConfigUploadMaxSizeVarName); ums != "" {
+		umsParsed, err := strconv.ParseInt(ums, 10, 64)
+		if err != nil {
+			return fmt.Errorf("failed to parse maximal upload size: %w", err)
+		}
+		c.UploadMaxSize = umsParsed
+	}
+
+	if uct := getenv(ConfigUploadContentTypesVarName); uct != "" {
+		c.UploadContentTypes = configParseList(uct)
+	}
+
+	if ims := getenv(ConfigImportMaxSizeVarName); ims != "" {
+		imsParsed, err := strconv.ParseInt(ims, 10, 64)
+		if err != nil {
+			return fmt.Errorf("failed to parse maximal import size: %w", err)
+		}
+		c.ImportMaxSize = imsParsed
+	}
+
+	durations := []struct {
+		name string
+		dst  *time.Duration
+	}{
+		{name: ConfigReadTimeoutVarName, dst: &c.ReadTimeout},
+		{name: ConfigReadHeaderTimeoutVarName, dst: &c.ReadHeaderTimeout},
+		{name: ConfigWriteTimeoutVarName, dst: &c.WriteTimeout},
+		{name: ConfigIdleTimeoutVarName, dst: &c.IdleTimeout},
+		{name: ConfigSSEHeartbeatIntervalVarName, dst: &c.SSEHeartbeatInterval},
+		{name: ConfigSSEReconnectTimeVarName, dst: &c.SSEReconnectTime},
+		{name: ConfigSessionSlidingWindowVarName, dst: &c.SessionSlidingWindow},
+		{name: ConfigRetentionVarName, dst: &c.Retention},
+		{name: ConfigEphemeralRetentionVarName, dst: &c.EphemeralRetention},
+		{name: ConfigAwayTimeoutVarName, dst: &c.AwayTimeout},
+		{name: ConfigPresenceTTLVarName, dst: &c.PresenceTTL},
+		{name: ConfigWebhookTimeoutVarName, dst: &c.WebhookTimeout},
+		{name: ConfigHookTimeoutVarName, dst: &c.HookTimeout},
+		{name: ConfigResumeCursorTTLVarName, dst: &c.ResumeCursorTTL},
+		{name: ConfigFloodWindowVarName, dst: &c.FloodWindow},
+		{name: ConfigFloodMuteVarName, dst: &c.FloodMute},
+	}
+	for _, d := range durations {
+		if err := configReadDuration(getenv, d.name, d.dst); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
+
+// configParseSameSite parses SameSite cookie attribute from its
+// case-insensitive name.
+func configParseSameSite(val string) (http.SameSite, error) {
+	switch strings.ToLower(val) {
+	case "lax":
+		return http.SameSiteLaxMode, nil
+	case "strict":
+		return http.SameSiteStrictMode, nil
+	case "none":
+		return http.SameSiteNoneMode, nil
+	default:
+		return 0, fmt.Errorf("invalid cookie SameSite attribute: %s", val)
+	}
+}
+
+// ConfigValidate checks whether given config variables are within their
+// valid ranges. It returns every found problem combined into single error.
+func ConfigValidate(c ConfigVariables) error {
+	errs := []error{}
+
+	if c.LastMessagesBufferSize < 1 {
+		errs = append(errs, fmt.Errorf(
+			"%s must be positive: %d", ConfigLastMessagesBufferSizeVarName, c.LastMessagesBufferSize,
+		))
+	}
+
+	if c.MessagesStateSize < 1 {
+		errs = append(errs, fmt.Errorf(
+			"%s must be positive: %d", ConfigMessagesStateSizeVarName, c.MessagesStateSize,
+		))
+	}
+
+	if c.MaximumMessageSize < 1 {
+		errs = append(errs, fmt.Errorf(
+			"%s must be positive: %d", ConfigMaxMessageSizeVarName, c.MaximumMessageSize,
+		))
+	}
+
+	if c.FloodViolations > 0 && (c.FloodWindow <= 0 || c.FloodMute <= 0) {
+		errs = append(errs, fmt.Errorf(
+			"%s and %s must be positive, when %s is set", ConfigFloodWindowVarName, ConfigFloodMuteVarName, ConfigFloodViolationsVarName,
+		))
+	}
+
+	if c.UploadMaxSize < 1 {
+		errs = append(errs, fmt.Errorf(
+			"%s must be positive: %d", ConfigUploadMaxSizeVarName, c.UploadMaxSize,
+		))
+	}
+
+	if c.ImportMaxSize < 1 {
+		errs = append(errs, fmt.Errorf(
+			"%s must be positive: %d", ConfigImportMaxSizeVarName, c.ImportMaxSize,
+		))
+	}
+
+	switch c.Tokenizer {
+	case ConfigTokenizerSimple, ConfigTokenizerAge, ConfigTokenizerAES, ConfigTokenizerJWT:
+	default:
+		errs = append(errs, fmt.Errorf(
+			"%s has unknown tokenizer name: %s", ConfigTokenizerVarName, c.Tokenizer,
+		))
+	}
+
+	if c.NicknameMinLength < 1 {
+		errs = append(errs, fmt.Errorf(
+			"%s must be positive: %d", ConfigNicknameMinLengthVarName, c.NicknameMinLength,
+		))
+	}
+
+	if c.NicknameMaxLength < c.NicknameMinLength {
+		errs = append(errs, fmt.Errorf(
+			"%s cannot be less than %s: %d < %d",
+			ConfigNicknameMaxLengthVarName, ConfigNicknameMinLengthVarName,
+			c.NicknameMaxLength, c.NicknameMinLength,
+		))
+	}
+
+	if (c.TLSCert == "") != (c.TLSKey == "") {
+		errs = append(errs, fmt.Errorf(
+			"%s and %s must be set together", ConfigTLSCertVarName, ConfigTLSKeyVarName,
+		))
+	}
+
+	if c.TLSCert != "" && len(c.TLSAutocertDomains) > 0 {
+		errs = append(errs, fmt.Errorf(
+			"%s cannot be used along with %s", ConfigTLSAutocertDomainsVarName, ConfigTLSCertVarName,
+		))
+	}
+
+	return errors.Join(errs...)
+}
+
+// configParseAddress validates listening address in host:port form.
+// Port alone, with or without leading colon, is accepted as well.
+// Address with empty host listens on all IPv4 and IPv6 interfaces.
+func configParseAddress(val string) (string, error) {
+	if !strings.Contains(val, ":") {
+		val = ":" + val
+	}
+
+	host, port, err := net.SplitHostPort(val)
+	if err != nil {
+		return "", fmt.Errorf("invalid listening address %q: %w", val, err)
+	}
+
+	portParsed, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return "", fmt.Errorf("invalid port of listening address %q", val)
+	}
+
+	if host != "" && net.ParseIP(host) == nil && !configValidHostname(host) {
+		return "", fmt.Errorf("invalid host of listening address %q", val)
+	}
+
+	return net.JoinHostPort(host, strconv.FormatUint(portParsed, 10)), nil
+}
+
+// configValidHostname reports whether given host consists only of
+// characters allowed in hostnames.
+func configValidHostname(host string) bool {
+	for _, r := range host {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		case r == '.' || r == '-':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// configParseSlowClient parses policy of handling slow event stream
+// clients from its case-insensitive name.
+func configParseSlowClient(val string) (SlowClientPolicy, error) {
+	switch strings.ToLower(val) {
+	case "drop":
+		return SlowClientDrop, nil
+	case "disconnect":
+		return SlowClientDisconnect, nil
+	default:
+		return 0, fmt.Errorf("invalid slow client policy: %s", val)
+	}
+}
+
+// configParseList parses comma-separated list of values. Empty
+// entries are skipped.
+func configParseList(val string) []string {
+	res := []string{}
+	for _, v := range strings.Split(val, ",") {
+		v = strings.TrimSpace(v)
+		if v != "" {
+			res = append(res, v)
+		}
+	}
+	return res
+}
+
+// configReadDuration parses duration from variable with given name
+// returned by getenv and saves it to given dst. It leaves dst untouched
+// when variable is not set.
+func configReadDuration(getenv func(string) string, name string, dst *time.Duration) error {
+	val := getenv(name)
+	if val == "" {
+		return nil
+	}
+
+	d, err := time.ParseDuration(val)
+	if err != nil {
+		return fmt.Errorf("failed to parse %s duration: %w", name, err)
+	}
+	if d < 0 {
+		return fmt.Errorf("%s duration cannot be negative: %s", name, val)
+	}
+
+	*dst = d
+	return nil
+}
+
+// configParseKeys parses comma-separated list of key:nickname pairs.
+// Given name describes keys in errors.
+func configParseKeys(name, val string) (map[string]string, error) {
+	keys := map[string]string{}
+	for _, pair := range configParseList(val) {
+		key, nickname, ok := strings.Cut(pair, ":")
+		key, nickname = strings.TrimSpace(key), strings.TrimSpace(nickname)
+		if !ok || key == "" || nickname == "" {
+			return nil, fmt.Errorf("invalid %s, expected key:nickname pair", name)
+		}
+		if _, ok := keys[key]; ok {
+			return nil, fmt.Errorf("duplicated %s of bot: %s", name, nickname)
+		}
+		keys[key] = nickname
+	}
+
+	return keys, nil
+}
+
+// configParsePersistPolicy parses comma-separated list of
+// type:persist and type:skip pairs into persistence policy.
+func configParsePersistPolicy(val string) (PersistPolicy, error) {
+	policy := PersistPolicy{}
+	for _, pair := range configParseList(val) {
+		name, persist, ok := strings.Cut(pair, ":")
+		name, persist = strings.TrimSpace(name), strings.TrimSpace(persist)
+		if !ok || name == "" || persist == "" {
+			return nil, fmt.Errorf("invalid persist policy, expected type:persist or type:skip pair")
+		}
+
+		t := BridgeEventType(name)
+		if _, ok := policy[t]; ok {
+			return nil, fmt.Errorf("duplicated persist policy of event type: %s", name)
+		}
+
+		switch strings.ToLower(persist) {
+		case "persist":
+			policy[t] = true
+		case "skip":
+			policy[t] = false
+		default:
+			return nil, fmt.Errorf("invalid persist policy of %s, expected persist or skip: %s", name, persist)
+		}
+	}
+
+	return policy, nil
+}
+
+// configParseSlowMode parses comma-separated list of channel:interval
+// pairs into map of slow mode intervals.
+func configParseSlowMode(val string) (map[string]time.Duration, error) {
+	intervals := map[string]time.Duration{}
+	for _, pair := range configParseList(val) {
+		channel, interval, ok := strings.Cut(pair, ":")
+		channel, interval = strings.TrimSpace(channel), strings.TrimSpace(interval)
+		if !ok || channel == "" || interval == "" {
+			return nil, fmt.Errorf("invalid slow mode, expected channel:interval pair")
+		}
+		if !validChatChannel(channel) {
+			return nil, fmt.Errorf("invalid slow mode channel name: %s", channel)
+		}
+		d, err := time.ParseDuration(interval)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse slow mode interval of %s: %w", channel, err)
+		}
+		if d <= 0 {
+			return nil, fmt.Errorf("slow mode interval of %s must be positive: %s", channel, interval)
+		}
+		if _, ok := intervals[channel]; ok {
+			return nil, fmt.Errorf("duplicated slow mode of channel: %s", channel)
+		}
+		intervals[channel] = d
+	}
+
+	return intervals, nil
+}
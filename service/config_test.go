@@ -0,0 +1,905 @@
+package service
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+	"github.com/sirupsen/logrus"
+)
+
+func TestConfigRead(t *testing.T) {
+	t.Run("timeouts", func(t *testing.T) {
+		is := is.New(t)
+
+		t.Setenv(ConfigReadTimeoutVarName, "10s")
+		t.Setenv(ConfigReadHeaderTimeoutVarName, "2s")
+		t.Setenv(ConfigWriteTimeoutVarName, "1m")
+		t.Setenv(ConfigIdleTimeoutVarName, "1m30s")
+		t.Setenv(ConfigHookTimeoutVarName, "30s")
+		t.Setenv(ConfigResumeCursorTTLVarName, "10m")
+		t.Setenv(ConfigPresenceTTLVarName, "90s")
+
+		c := ConfigDefault()
+		is.NoErr(ConfigRead(&c))
+
+		is.Equal(c.ReadTimeout, time.Second*10)
+		is.Equal(c.ReadHeaderTimeout, time.Second*2)
+		is.Equal(c.WriteTimeout, time.Minute)
+		is.Equal(c.IdleTimeout, time.Second*90)
+		is.Equal(c.HookTimeout, time.Second*30)
+		is.Equal(c.ResumeCursorTTL, time.Minute*10)
+		is.Equal(c.PresenceTTL, time.Second*90)
+	})
+
+	t.Run("default timeouts", func(t *testing.T) {
+		is := is.New(t)
+
+		c := ConfigDefault()
+		is.NoErr(ConfigRead(&c))
+
+		is.Equal(c.ReadTimeout, ConfigReadTimeoutDefaultVal)
+		is.Equal(c.ReadHeaderTimeout, ConfigReadHeaderTimeoutDefaultVal)
+		is.Equal(c.WriteTimeout, ConfigWriteTimeoutDefaultVal)
+		is.Equal(c.IdleTimeout, ConfigIdleTimeoutDefaultVal)
+		is.Equal(c.HookTimeout, ConfigHookTimeoutDefaultVal)
+		is.Equal(c.ResumeCursorTTL, ConfigResumeCursorTTLDefaultVal)
+		is.Equal(c.PresenceTTL, ConfigPresenceTTLDefaultVal)
+	})
+
+	t.Run("invalid timeouts", func(t *testing.T) {
+		scenario := func(name, val string) (string, func(*testing.T)) {
+			return name + "=" + val, func(t *testing.T) {
+				is := is.New(t)
+
+				t.Setenv(name, val)
+
+				c := ConfigDefault()
+				is.True(ConfigRead(&c) != nil)
+			}
+		}
+
+		t.Run(scenario(ConfigReadTimeoutVarName, "ten seconds"))
+		t.Run(scenario(ConfigReadHeaderTimeoutVarName, "5"))
+		t.Run(scenario(ConfigWriteTimeoutVarName, "-1s"))
+		t.Run(scenario(ConfigIdleTimeoutVarName, "1x"))
+		t.Run(scenario(ConfigHookTimeoutVarName, "-1m"))
+		t.Run(scenario(ConfigResumeCursorTTLVarName, "-1h"))
+		t.Run(scenario(ConfigPresenceTTLVarName, "3"))
+	})
+}
+
+func TestConfigReadWebhooks(t *testing.T) {
+	t.Run("valid", func(t *testing.T) {
+		is := is.New(t)
+
+		t.Setenv(ConfigWebhookURLsVarName, "https://example.com/hook, http://localhost:9000/events")
+		t.Setenv(ConfigWebhookEventsVarName, "message-sent")
+		t.Setenv(ConfigWebhookSecretVarName, "secret")
+		t.Setenv(ConfigWebhookTimeoutVarName, "2s")
+		t.Setenv(ConfigWebhookRetriesVarName, "0")
+
+		c := ConfigDefault()
+		is.NoErr(ConfigRead(&c))
+
+		is.Equal(c.WebhookURLs, []string{"https://example.com/hook", "http://localhost:9000/events"})
+		is.Equal(c.WebhookEvents, []string{"message-sent"})
+		is.Equal(c.WebhookSecret, "secret")
+		is.Equal(c.WebhookTimeout, time.Second*2)
+		is.Equal(c.WebhookRetries, 0)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		scenario := func(name, val string) (string, func(*testing.T)) {
+			return name + "=" + val, func(t *testing.T) {
+				is := is.New(t)
+
+				t.Setenv(name, val)
+
+				c := ConfigDefault()
+				is.True(ConfigRead(&c) != nil)
+			}
+		}
+
+		t.Run(scenario(ConfigWebhookURLsVarName, "example.com/hook"))
+		t.Run(scenario(ConfigWebhookURLsVarName, "ftp://example.com/hook"))
+		t.Run(scenario(ConfigWebhookRetriesVarName, "-1"))
+		t.Run(scenario(ConfigWebhookTimeoutVarName, "soon"))
+	})
+}
+
+func TestConfigReadAPIKeys(t *testing.T) {
+	t.Run("valid", func(t *testing.T) {
+		is := is.New(t)
+
+		t.Setenv(ConfigAPIKeysVarName, "first-key:relay, second-key:weather")
+		t.Setenv(ConfigIncomingWebhooksVarName, "ci-key:ci")
+
+		c := ConfigDefault()
+		is.NoErr(ConfigRead(&c))
+		is.Equal(c.APIKeys, map[string]string{
+			"first-key":  "relay",
+			"second-key": "weather",
+		})
+		is.Equal(c.IncomingWebhooks, map[string]string{"ci-key": "ci"})
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		scenario := func(val string) (string, func(*testing.T)) {
+			return val, func(t *testing.T) {
+				is := is.New(t)
+
+				t.Setenv(ConfigAPIKeysVarName, val)
+
+				c := ConfigDefault()
+				is.True(ConfigRead(&c) != nil)
+			}
+		}
+
+		t.Run(scenario("first-key"))
+		t.Run(scenario(":relay"))
+		t.Run(scenario("first-key:"))
+		t.Run(scenario("first-key:relay,first-key:weather"))
+
+		t.Run("incoming webhooks", func(t *testing.T) {
+			is := is.New(t)
+
+			t.Setenv(ConfigIncomingWebhooksVarName, "ci-key")
+
+			c := ConfigDefault()
+			is.True(ConfigRead(&c) != nil)
+		})
+	})
+}
+
+func TestConfigReadSlowMode(t *testing.T) {
+	t.Run("valid", func(t *testing.T) {
+		is := is.New(t)
+
+		t.Setenv(ConfigSlowModeVarName, "general:10s, random:1m")
+
+		c := ConfigDefault()
+		is.NoErr(ConfigRead(&c))
+		is.Equal(c.SlowMode, map[string]time.Duration{
+			"general": time.Second * 10,
+			"random":  time.Minute,
+		})
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		scenario := func(val string) (string, func(*testing.T)) {
+			return val, func(t *testing.T) {
+				is := is.New(t)
+
+				t.Setenv(ConfigSlowModeVarName, val)
+
+				c := ConfigDefault()
+				is.True(ConfigRead(&c) != nil)
+			}
+		}
+
+		t.Run(scenario("general"))
+		t.Run(scenario(":10s"))
+		t.Run(scenario("general:10"))
+		t.Run(scenario("general:-10s"))
+		t.Run(scenario("general:10s,general:1m"))
+		t.Run(scenario("gen eral:10s"))
+	})
+}
+
+func TestConfigReadCookie(t *testing.T) {
+	t.Run("defaults", func(t *testing.T) {
+		is := is.New(t)
+
+		c := ConfigDefault()
+		is.NoErr(ConfigRead(&c))
+
+		is.Equal(c.CookieSecure, false)
+		is.Equal(c.CookieSameSite, http.SameSiteLaxMode)
+	})
+
+	t.Run("attributes", func(t *testing.T) {
+		is := is.New(t)
+
+		t.Setenv(ConfigCookieSecureVarName, "true")
+		t.Setenv(ConfigCookieSameSiteVarName, "Strict")
+
+		c := ConfigDefault()
+		is.NoErr(ConfigRead(&c))
+
+		is.Equal(c.CookieSecure, true)
+		is.Equal(c.CookieSameSite, http.SameSiteStrictMode)
+	})
+
+	t.Run("invalid SameSite", func(t *testing.T) {
+		is := is.New(t)
+
+		t.Setenv(ConfigCookieSameSiteVarName, "sometimes")
+
+		c := ConfigDefault()
+		is.True(ConfigRead(&c) != nil)
+	})
+}
+
+func TestConfigReadCORSOrigins(t *testing.T) {
+	is := is.New(t)
+
+	t.Setenv(ConfigCORSOriginsVarName, " https://example.com, ,https://chat.example.com ")
+
+	c := ConfigDefault()
+	is.NoErr(ConfigRead(&c))
+
+	is.Equal(c.CORSOrigins, []string{"https://example.com", "https://chat.example.com"})
+}
+
+func TestConfigReadLogFormat(t *testing.T) {
+	t.Run("json", func(t *testing.T) {
+		is := is.New(t)
+
+		t.Setenv(ConfigLogFormatVarName, ConfigLogFormatJSON)
+
+		c := ConfigDefault()
+		is.NoErr(ConfigRead(&c))
+		is.Equal(c.LogFormat, ConfigLogFormatJSON)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		is := is.New(t)
+
+		t.Setenv(ConfigLogFormatVarName, "xml")
+
+		c := ConfigDefault()
+		is.True(ConfigRead(&c) != nil)
+	})
+}
+
+func TestConfigReadLogLevel(t *testing.T) {
+	type testArgs struct {
+		name    string
+		val     string
+		want    logrus.Level
+		wantErr bool
+	}
+
+	scenario := func(tt testArgs) (string, func(*testing.T)) {
+		return tt.name, func(t *testing.T) {
+			is := is.New(t)
+
+			t.Setenv(ConfigLogLevelVarName, tt.val)
+
+			c := ConfigDefault()
+			err := ConfigRead(&c)
+			if tt.wantErr {
+				is.True(err != nil)
+				return
+			}
+
+			is.NoErr(err)
+			is.Equal(c.LogLevel, tt.want)
+		}
+	}
+
+	t.Run(scenario(testArgs{
+		name: "debug",
+		val:  "debug",
+		want: logrus.DebugLevel,
+	}))
+	t.Run(scenario(testArgs{
+		name: "info",
+		val:  "info",
+		want: logrus.InfoLevel,
+	}))
+	t.Run(scenario(testArgs{
+		name: "warn",
+		val:  "warn",
+		want: logrus.WarnLevel,
+	}))
+	t.Run(scenario(testArgs{
+		name:    "invalid",
+		val:     "loud",
+		wantErr: true,
+	}))
+}
+
+func TestConfigReadMaxConns(t *testing.T) {
+	t.Run("valid", func(t *testing.T) {
+		is := is.New(t)
+
+		t.Setenv(ConfigMaxConnsVarName, "1000")
+		t.Setenv(ConfigMaxConnsPerUserVarName, "5")
+
+		c := ConfigDefault()
+		is.NoErr(ConfigRead(&c))
+		is.Equal(c.MaxConns, 1000)
+		is.Equal(c.MaxConnsPerUser, 5)
+	})
+
+	t.Run("default", func(t *testing.T) {
+		is := is.New(t)
+
+		c := ConfigDefault()
+		is.NoErr(ConfigRead(&c))
+		is.Equal(c.MaxConns, 0)
+		is.Equal(c.MaxConnsPerUser, 0)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		scenario := func(name, val string) (string, func(*testing.T)) {
+			return name + "=" + val, func(t *testing.T) {
+				is := is.New(t)
+
+				t.Setenv(name, val)
+
+				c := ConfigDefault()
+				is.True(ConfigRead(&c) != nil)
+			}
+		}
+
+		t.Run(scenario(ConfigMaxConnsVarName, "many"))
+		t.Run(scenario(ConfigMaxConnsVarName, "-1"))
+		t.Run(scenario(ConfigMaxConnsPerUserVarName, "1.5"))
+		t.Run(scenario(ConfigMaxConnsPerUserVarName, "-2"))
+	})
+}
+
+func TestConfigReadPersistPolicy(t *testing.T) {
+	t.Run("valid", func(t *testing.T) {
+		is := is.New(t)
+
+		t.Setenv(ConfigPersistPolicyVarName, "user-join:persist, user-left:PERSIST,message-edited:skip")
+
+		c := ConfigDefault()
+		is.NoErr(ConfigRead(&c))
+		is.True(c.PersistPolicy.Persist(BridgeMessageSent))
+		is.True(c.PersistPolicy.Persist(BridgeUserJoin))
+		is.True(c.PersistPolicy.Persist(BridgeUserLeft))
+		is.True(!c.PersistPolicy.Persist(BridgeMessageEdited))
+		is.True(!c.PersistPolicy.Persist(BridgeUserTyping))
+	})
+
+	t.Run("default", func(t *testing.T) {
+		is := is.New(t)
+
+		c := ConfigDefault()
+		is.NoErr(ConfigRead(&c))
+		is.Equal(c.PersistPolicy, PersistPolicyDefault())
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		scenario := func(val string) (string, func(*testing.T)) {
+			return val, func(t *testing.T) {
+				is := is.New(t)
+
+				t.Setenv(ConfigPersistPolicyVarName, val)
+
+				c := ConfigDefault()
+				is.True(ConfigRead(&c) != nil)
+			}
+		}
+
+		t.Run(scenario("user-join"))
+		t.Run(scenario("user-join:"))
+		t.Run(scenario(":skip"))
+		t.Run(scenario("user-join:always"))
+		t.Run(scenario("user-join:persist,user-join:skip"))
+	})
+}
+
+func TestConfigReadMaxAcks(t *testing.T) {
+	t.Run("valid", func(t *testing.T) {
+		is := is.New(t)
+
+		t.Setenv(ConfigMaxAcksVarName, "500")
+
+		c := ConfigDefault()
+		is.NoErr(ConfigRead(&c))
+		is.Equal(c.MaxAcks, 500)
+	})
+
+	t.Run("default", func(t *testing.T) {
+		is := is.New(t)
+
+		c := ConfigDefault()
+		is.NoErr(ConfigRead(&c))
+		is.Equal(c.MaxAcks, ConfigMaxAcksDefaultVal)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		scenario := func(val string) (string, func(*testing.T)) {
+			return val, func(t *testing.T) {
+				is := is.New(t)
+
+				t.Setenv(ConfigMaxAcksVarName, val)
+
+				c := ConfigDefault()
+				is.True(ConfigRead(&c) != nil)
+			}
+		}
+
+		t.Run(scenario("many"))
+		t.Run(scenario("-1"))
+	})
+}
+
+func TestConfigReadSlowClient(t *testing.T) {
+	t.Run("disconnect", func(t *testing.T) {
+		is := is.New(t)
+
+		t.Setenv(ConfigSlowClientVarName, "Disconnect")
+
+		c := ConfigDefault()
+		is.NoErr(ConfigRead(&c))
+		is.Equal(c.SlowClient, SlowClientDisconnect)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		is := is.New(t)
+
+		t.Setenv(ConfigSlowClientVarName, "block")
+
+		c := ConfigDefault()
+		is.True(ConfigRead(&c) != nil)
+	})
+}
+
+func TestConfigReadAddress(t *testing.T) {
+	type testArgs struct {
+		name    string
+		val     string
+		want    string
+		wantErr bool
+	}
+
+	scenario := func(tt testArgs) (string, func(*testing.T)) {
+		return tt.name, func(t *testing.T) {
+			is := is.New(t)
+
+			t.Setenv(ConfigAddressVarName, tt.val)
+
+			c := ConfigDefault()
+			err := ConfigRead(&c)
+			if tt.wantErr {
+				is.True(err != nil)
+				return
+			}
+
+			is.NoErr(err)
+			is.Equal(c.Address, tt.want)
+		}
+	}
+
+	t.Run(scenario(testArgs{
+		name: "ipv4",
+		val:  "127.0.0.1:8080",
+		want: "127.0.0.1:8080",
+	}))
+	t.Run(scenario(testArgs{
+		name: "ipv6",
+		val:  "[::1]:8080",
+		want: "[::1]:8080",
+	}))
+	t.Run(scenario(testArgs{
+		name: "hostname",
+		val:  "localhost:8080",
+		want: "localhost:8080",
+	}))
+	t.Run(scenario(testArgs{
+		name: "port only",
+		val:  ":8080",
+		want: ":8080",
+	}))
+	t.Run(scenario(testArgs{
+		name: "port without colon",
+		val:  "8080",
+		want: ":8080",
+	}))
+	t.Run(scenario(testArgs{
+		name:    "missing port",
+		val:     "127.0.0.1",
+		wantErr: true,
+	}))
+	t.Run(scenario(testArgs{
+		name:    "port out of range",
+		val:     ":80800",
+		wantErr: true,
+	}))
+	t.Run(scenario(testArgs{
+		name:    "named port",
+		val:     ":http",
+		wantErr: true,
+	}))
+	t.Run(scenario(testArgs{
+		name:    "unbracketed ipv6",
+		val:     "::1:8080",
+		wantErr: true,
+	}))
+	t.Run(scenario(testArgs{
+		name:    "invalid host",
+		val:     "local host:8080",
+		wantErr: true,
+	}))
+}
+
+func TestConfigValidate(t *testing.T) {
+	type testArgs struct {
+		name   string
+		modify func(c *ConfigVariables)
+
+		// invalid lists env variables of invalid fields.
+		invalid []string
+	}
+
+	scenario := func(tt testArgs) (string, func(*testing.T)) {
+		return tt.name, func(t *testing.T) {
+			is := is.New(t)
+
+			c := ConfigDefault()
+			tt.modify(&c)
+
+			err := ConfigValidate(c)
+			if len(tt.invalid) == 0 {
+				is.NoErr(err)
+				return
+			}
+
+			is.True(err != nil)
+			for _, name := range tt.invalid {
+				is.True(strings.Contains(err.Error(), name))
+			}
+			is.Equal(len(err.(interface{ Unwrap() []error }).Unwrap()), len(tt.invalid))
+		}
+	}
+
+	t.Run(scenario(testArgs{
+		name:   "default",
+		modify: func(c *ConfigVariables) {},
+	}))
+	t.Run(scenario(testArgs{
+		name: "empty last messages buffer",
+		modify: func(c *ConfigVariables) {
+			c.LastMessagesBufferSize = 0
+		},
+		invalid: []string{ConfigLastMessagesBufferSizeVarName},
+	}))
+	t.Run(scenario(testArgs{
+		name: "empty messages state",
+		modify: func(c *ConfigVariables) {
+			c.MessagesStateSize = 0
+		},
+		invalid: []string{ConfigMessagesStateSizeVarName},
+	}))
+	t.Run(scenario(testArgs{
+		name: "zero message size",
+		modify: func(c *ConfigVariables) {
+			c.MaximumMessageSize = 0
+		},
+		invalid: []string{ConfigMaxMessageSizeVarName},
+	}))
+	t.Run(scenario(testArgs{
+		name: "unknown tokenizer",
+		modify: func(c *ConfigVariables) {
+			c.Tokenizer = "rot13"
+		},
+		invalid: []string{ConfigTokenizerVarName},
+	}))
+	t.Run(scenario(testArgs{
+		name: "zero nickname min length",
+		modify: func(c *ConfigVariables) {
+			c.NicknameMinLength = 0
+		},
+		invalid: []string{ConfigNicknameMinLengthVarName},
+	}))
+	t.Run(scenario(testArgs{
+		name: "nickname max length less than min length",
+		modify: func(c *ConfigVariables) {
+			c.NicknameMinLength = 5
+			c.NicknameMaxLength = 4
+		},
+		invalid: []string{ConfigNicknameMaxLengthVarName},
+	}))
+	t.Run(scenario(testArgs{
+		name: "tls cert without key",
+		modify: func(c *ConfigVariables) {
+			c.TLSCert = "cert.pem"
+		},
+		invalid: []string{ConfigTLSCertVarName},
+	}))
+	t.Run(scenario(testArgs{
+		name: "tls cert with autocert",
+		modify: func(c *ConfigVariables) {
+			c.TLSCert = "cert.pem"
+			c.TLSKey = "key.pem"
+			c.TLSAutocertDomains = []string{"chat.example.com"}
+		},
+		invalid: []string{ConfigTLSAutocertDomainsVarName},
+	}))
+	t.Run(scenario(testArgs{
+		name: "many invalid fields",
+		modify: func(c *ConfigVariables) {
+			c.LastMessagesBufferSize = -1
+			c.MaximumMessageSize = -1
+			c.Tokenizer = ""
+		},
+		invalid: []string{
+			ConfigLastMessagesBufferSizeVarName,
+			ConfigMaxMessageSizeVarName,
+			ConfigTokenizerVarName,
+		},
+	}))
+}
+
+func TestConfigReadFile(t *testing.T) {
+	type testArgs struct {
+		name     string
+		filename string
+		content  string
+
+		// env holds env variables set along with config file.
+		env map[string]string
+
+		want    func(c *ConfigVariables)
+		wantErr bool
+	}
+
+	scenario := func(tt testArgs) (string, func(*testing.T)) {
+		return tt.name, func(t *testing.T) {
+			is := is.New(t)
+
+			path := filepath.Join(t.TempDir(), tt.filename)
+			is.NoErr(os.WriteFile(path, []byte(tt.content), 0o600))
+
+			t.Setenv(ConfigFileVarName, path)
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+
+			c := ConfigDefault()
+			err := ConfigRead(&c)
+			if tt.wantErr {
+				is.True(err != nil)
+				return
+			}
+			is.NoErr(err)
+
+			want := ConfigDefault()
+			tt.want(&want)
+			is.Equal(c, want)
+		}
+	}
+
+	t.Run(scenario(testArgs{
+		name:     "yaml",
+		filename: "config.yaml",
+		content: `S8K_ADDR: ":9090"
+max_msg_size: 256
+markdown: true
+cors_origins:
+  - https://example.com
+  - https://chat.example.com
+write_timeout: 30s
+`,
+		want: func(c *ConfigVariables) {
+			c.Address = ":9090"
+			c.MaximumMessageSize = 256
+			c.Markdown = true
+			c.CORSOrigins = []string{"https://example.com", "https://chat.example.com"}
+			c.WriteTimeout = time.Second * 30
+		},
+	}))
+	t.Run(scenario(testArgs{
+		name:     "json",
+		filename: "config.json",
+		content: `{
+  "S8K_ADDR": ":9090",
+  "S8K_MAX_MSG_SIZE": 256,
+  "S8K_MARKDOWN": true,
+  "S8K_CORS_ORIGINS": "https://example.com, https://chat.example.com"
+}`,
+		want: func(c *ConfigVariables) {
+			c.Address = ":9090"
+			c.MaximumMessageSize = 256
+			c.Markdown = true
+			c.CORSOrigins = []string{"https://example.com", "https://chat.example.com"}
+		},
+	}))
+	t.Run(scenario(testArgs{
+		name:     "env overrides file",
+		filename: "config.yml",
+		content: `addr: ":9090"
+max_msg_size: 256
+`,
+		env: map[string]string{
+			ConfigMaxMessageSizeVarName: "512",
+		},
+		want: func(c *ConfigVariables) {
+			c.Address = ":9090"
+			c.MaximumMessageSize = 512
+		},
+	}))
+	t.Run(scenario(testArgs{
+		name:     "unknown key",
+		filename: "config.yaml",
+		content:  "adress: \":9090\"\n",
+		wantErr:  true,
+	}))
+	t.Run(scenario(testArgs{
+		name:     "invalid value",
+		filename: "config.yaml",
+		content:  "max_msg_size: big\n",
+		wantErr:  true,
+	}))
+	t.Run(scenario(testArgs{
+		name:     "malformed file",
+		filename: "config.json",
+		content:  "{\"addr\": ",
+		wantErr:  true,
+	}))
+	t.Run(scenario(testArgs{
+		name:     "unsupported format",
+		filename: "config.toml",
+		content:  "addr = \":9090\"\n",
+		wantErr:  true,
+	}))
+}
+
+func TestConfigParseFlags(t *testing.T) {
+	type testArgs struct {
+		name string
+		args []string
+
+		// env holds env variables set along with flags.
+		env map[string]string
+
+		want        func(c *ConfigVariables)
+		wantVersion bool
+		wantErr     bool
+		wantErrRead bool
+	}
+
+	scenario := func(tt testArgs) (string, func(*testing.T)) {
+		return tt.name, func(t *testing.T) {
+			is := is.New(t)
+
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+
+			flags, err := ConfigParseFlags(tt.args, io.Discard)
+			if tt.wantErr {
+				is.True(err != nil)
+				return
+			}
+			is.NoErr(err)
+			is.Equal(flags.Version, tt.wantVersion)
+
+			c := ConfigDefault()
+			is.NoErr(ConfigRead(&c))
+			err = flags.Apply(&c)
+			if tt.wantErrRead {
+				is.True(err != nil)
+				return
+			}
+			is.NoErr(err)
+
+			want := ConfigDefault()
+			if tt.want != nil {
+				tt.want(&want)
+			}
+			is.Equal(c, want)
+		}
+	}
+
+	t.Run(scenario(testArgs{
+		name: "no flags",
+	}))
+	t.Run(scenario(testArgs{
+		name: "all flags",
+		args: []string{
+			"-addr", "127.0.0.1:9090",
+			"-db", "chat.db",
+			"-tokenizer", "aes",
+			"-secret", "veibiequohy2eshaerohHoghootae1ku",
+			"-log-level", "debug",
+		},
+		want: func(c *ConfigVariables) {
+			c.Address = "127.0.0.1:9090"
+			c.Database = "chat.db"
+			c.Tokenizer = "aes"
+			c.SessionSecret = "veibiequohy2eshaerohHoghootae1ku"
+			c.LogLevel = logrus.DebugLevel
+		},
+	}))
+	t.Run(scenario(testArgs{
+		name: "flags override env",
+		args: []string{"-addr", ":9090"},
+		env: map[string]string{
+			ConfigAddressVarName:   ":8081",
+			ConfigTokenizerVarName: "aes",
+		},
+		want: func(c *ConfigVariables) {
+			c.Address = ":9090"
+			c.Tokenizer = "aes"
+		},
+	}))
+	t.Run(scenario(testArgs{
+		name:        "version",
+		args:        []string{"-version"},
+		wantVersion: true,
+	}))
+	t.Run(scenario(testArgs{
+		name:    "unknown flag",
+		args:    []string{"-unknown"},
+		wantErr: true,
+	}))
+	t.Run(scenario(testArgs{
+		name:    "unexpected arguments",
+		args:    []string{"-addr", ":9090", "serve"},
+		wantErr: true,
+	}))
+	t.Run(scenario(testArgs{
+		name:        "invalid address",
+		args:        []string{"-addr", "bad addr"},
+		wantErrRead: true,
+	}))
+}
+
+func TestConfigPrecedence(t *testing.T) {
+	is := is.New(t)
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	is.NoErr(os.WriteFile(path, []byte(`addr: ":7070"
+db: "file.db"
+max_msg_size: 256
+`), 0o600))
+
+	t.Setenv(ConfigFileVarName, path)
+	t.Setenv(ConfigAddressVarName, ":8081")
+	t.Setenv(ConfigDatabasePathVarName, "env.db")
+
+	flags, err := ConfigParseFlags([]string{"-addr", ":9090"}, io.Discard)
+	is.NoErr(err)
+
+	c := ConfigDefault()
+	is.NoErr(ConfigRead(&c))
+	is.NoErr(flags.Apply(&c))
+
+	is.Equal(c.Address, ":9090")        // flag wins over env and file
+	is.Equal(c.Database, "env.db")      // env wins over file
+	is.Equal(c.MaximumMessageSize, 256) // file wins over default
+}
+
+func TestConfigReloadEnv(t *testing.T) {
+	is := is.New(t)
+
+	dir := t.TempDir()
+	system := filepath.Join(dir, "system.env")
+	local := filepath.Join(dir, "local.env")
+	is.NoErr(os.WriteFile(system, []byte("S8K_ADDR=:7070\nS8K_DB=system.db\n"), 0o600))
+	is.NoErr(os.WriteFile(local, []byte("S8K_ADDR=:6060\nS8K_DB=local.db\nS8K_MAX_MSG_SIZE=256\n"), 0o600))
+
+	environ := map[string]string{
+		ConfigAddressVarName: ":8081",
+	}
+	lookup := func(name string) (string, bool) {
+		val, ok := environ[name]
+		return val, ok
+	}
+
+	getenv := configReloadEnv(lookup, system, local, filepath.Join(dir, "missing.env"))
+
+	c := ConfigDefault()
+	is.NoErr(ConfigReadEnv(&c, getenv))
+
+	is.Equal(c.Address, ":8081")        // env wins over files
+	is.Equal(c.Database, "system.db")   // system file wins over local file
+	is.Equal(c.MaximumMessageSize, 256) // local file wins over default
+
+	// Process environment is left intact.
+	_, ok := os.LookupEnv(ConfigDatabasePathVarName)
+	is.True(!ok)
+}
@@ -0,0 +1,84 @@
+package service
+
+import (
+	"errors"
+	"sync"
+)
+
+var (
+	// ErrTooManyConnections is returned when server holds maximal
+	// number of open event streams.
+	ErrTooManyConnections = errors.New("too many open event streams")
+
+	// ErrTooManyUserConnections is returned when user holds maximal
+	// number of open event streams.
+	ErrTooManyUserConnections = errors.New("too many open event streams of user")
+)
+
+// ConnectionLimiter limits number of concurrently open event streams,
+// both globally and per user, so they can't exhaust server resources.
+// Zero limit means no limit. Nil ConnectionLimiter doesn't limit
+// anything.
+type ConnectionLimiter struct {
+	max        int
+	maxPerUser int
+
+	mtx   *sync.Mutex
+	total int
+	users map[string]int
+}
+
+// NewConnectionLimiter returns connection limiter with given global
+// and per user limits of open event streams.
+func NewConnectionLimiter(max, maxPerUser int) *ConnectionLimiter {
+	return &ConnectionLimiter{
+		max:        max,
+		maxPerUser: maxPerUser,
+		mtx:        &sync.Mutex{},
+		users:      make(map[string]int),
+	}
+}
+
+// Acquire takes slot for event stream of user with given ID. Slot has
+// to be given back with Release, once stream is closed. It returns
+// ErrTooManyConnections or ErrTooManyUserConnections, when there is no
+// free slot.
+func (l *ConnectionLimiter) Acquire(userID string) error {
+	if l == nil {
+		return nil
+	}
+
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	if l.maxPerUser > 0 && l.users[userID] >= l.maxPerUser {
+		return ErrTooManyUserConnections
+	}
+	if l.max > 0 && l.total >= l.max {
+		return ErrTooManyConnections
+	}
+
+	l.total++
+	l.users[userID]++
+	return nil
+}
+
+// Release gives back slot of event stream of user with given ID.
+func (l *ConnectionLimiter) Release(userID string) {
+	if l == nil {
+		return
+	}
+
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	if l.users[userID] == 0 {
+		return
+	}
+
+	l.total--
+	l.users[userID]--
+	if l.users[userID] == 0 {
+		delete(l.users, userID)
+	}
+}
@@ -0,0 +1,134 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+)
+
+func TestConnectionLimiter(t *testing.T) {
+	is := is.New(t)
+
+	limiter := NewConnectionLimiter(3, 2)
+
+	is.NoErr(limiter.Acquire("user"))
+	is.NoErr(limiter.Acquire("user"))
+	is.Equal(limiter.Acquire("user"), ErrTooManyUserConnections)
+
+	is.NoErr(limiter.Acquire("other"))
+	is.Equal(limiter.Acquire("third"), ErrTooManyConnections)
+
+	// Released slot can be taken again.
+	limiter.Release("user")
+	is.NoErr(limiter.Acquire("third"))
+	is.Equal(limiter.Acquire("user"), ErrTooManyConnections)
+
+	// Slots of users, who don't hold any, aren't released.
+	limiter.Release("unknown")
+	is.Equal(limiter.Acquire("user"), ErrTooManyConnections)
+
+	// Nil limiter doesn't limit anything.
+	var none *ConnectionLimiter
+	is.NoErr(none.Acquire("user"))
+	none.Release("user")
+}
+
+func TestHandlerStreamConnectionLimit(t *testing.T) {
+	type testArgs struct {
+		name       string
+		max        int
+		maxPerUser int
+		code       int
+	}
+
+	scenario := func(tt testArgs) (string, func(*testing.T)) {
+		return tt.name, func(t *testing.T) {
+			is := is.New(t)
+
+			subscribed := make(chan struct{}, tt.max+tt.maxPerUser)
+			h := HandlerStream(HandlerStreamDependencies{
+				Connections: NewConnectionLimiter(tt.max, tt.maxPerUser),
+				MessageNotifier: MessageNotifierFunc(func(context.Context, MessageSubscribeRequest) func() {
+					subscribed <- struct{}{}
+					return func() {}
+				}),
+			})
+
+			// connect opens event stream, which lasts until returned
+			// function is called.
+			connect := func() (*streamRecorder, func()) {
+				ctx, cancel := context.WithCancel(context.Background())
+				r := requestWithSession(ctx, httptest.NewRequest(http.MethodGet, "/stream", nil), &SessionState{
+					ID:       "user",
+					Nickname: "user",
+				})
+				w := newStreamRecorder()
+
+				done := make(chan struct{})
+				go func() {
+					defer close(done)
+					h(w, r)
+				}()
+
+				select {
+				case <-subscribed:
+				case <-done:
+				case <-time.After(time.Second):
+					t.Fatal("stream has not been opened")
+				}
+
+				return w, func() {
+					cancel()
+					<-done
+				}
+			}
+
+			limit := tt.maxPerUser
+			if limit == 0 || (tt.max > 0 && tt.max < limit) {
+				limit = tt.max
+			}
+
+			closers := []func(){}
+			for i := 0; i < limit; i++ {
+				w, disconnect := connect()
+				is.Equal(w.code, http.StatusOK)
+				closers = append(closers, disconnect)
+			}
+
+			w, disconnect := connect()
+			disconnect()
+			is.Equal(w.code, tt.code)
+
+			// Disconnecting frees a slot.
+			closers[0]()
+			w, disconnect = connect()
+			is.Equal(w.code, http.StatusOK)
+			disconnect()
+
+			for _, disconnect := range closers[1:] {
+				disconnect()
+			}
+		}
+	}
+
+	t.Run(scenario(testArgs{
+		name:       "per user",
+		maxPerUser: 2,
+		code:       http.StatusTooManyRequests,
+	}))
+	t.Run(scenario(testArgs{
+		name: "global",
+		max:  2,
+		code: http.StatusServiceUnavailable,
+	}))
+	t.Run(scenario(testArgs{
+		name:       "global below per user",
+		max:        1,
+		maxPerUser: 3,
+		code:       http.StatusServiceUnavailable,
+	}))
+}
@@ -0,0 +1,69 @@
+package service
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+const (
+	corsAllowedMethods = "GET, POST, PUT, DELETE"
+	corsAllowedHeaders = "Content-Type, Last-Event-ID"
+	corsMaxAge         = "600"
+)
+
+// CORSMiddleware allows cross-origin requests from given list of
+// origins. Allowed origin is always echoed back instead of wildcard,
+// so browsers can send session cookie along with request (which is
+// required by event stream). Preflight requests are answered by
+// middleware itself.
+func CORSMiddleware(origins []string) func(http.Handler) http.Handler {
+	allowed := make(map[string]struct{}, len(origins))
+	for _, origin := range origins {
+		allowed[origin] = struct{}{}
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			if origin == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			w.Header().Add("Vary", "Origin")
+			preflight := r.Method == http.MethodOptions &&
+				r.Header.Get("Access-Control-Request-Method") != ""
+
+			if _, ok := allowed[origin]; !ok {
+				if preflight {
+					jsonResponse(w, http.StatusForbidden, responseWrapper{
+						Error: errorResponse{
+							Code:    http.StatusForbidden,
+							Reason:  ErrorReasonOriginNotAllowed,
+							Message: "Origin is not allowed.",
+						},
+					})
+					return
+				}
+
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Set("Access-Control-Allow-Credentials", "true")
+			w.Header().Set("Access-Control-Expose-Headers", middleware.RequestIDHeader)
+
+			if preflight {
+				w.Header().Set("Access-Control-Allow-Methods", corsAllowedMethods)
+				w.Header().Set("Access-Control-Allow-Headers", corsAllowedHeaders)
+				w.Header().Set("Access-Control-Max-Age", corsMaxAge)
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,96 @@
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+func TestCORSMiddleware(t *testing.T) {
+	type testArgs struct {
+		name         string
+		method       string
+		origin       string
+		preflight    bool
+		wantCode     int
+		wantOrigin   string
+		wantNextCall bool
+	}
+
+	scenario := func(tt testArgs) (string, func(*testing.T)) {
+		return tt.name, func(t *testing.T) {
+			is := is.New(t)
+
+			called := false
+			h := CORSMiddleware([]string{"https://example.com", "https://chat.example.com"})(
+				http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					called = true
+					w.WriteHeader(http.StatusOK)
+				}),
+			)
+
+			r := httptest.NewRequest(tt.method, "/stream", nil)
+			if tt.origin != "" {
+				r.Header.Set("Origin", tt.origin)
+			}
+			if tt.preflight {
+				r.Header.Set("Access-Control-Request-Method", http.MethodPost)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+
+			is.Equal(w.Code, tt.wantCode)
+			is.Equal(called, tt.wantNextCall)
+			is.Equal(w.Header().Get("Access-Control-Allow-Origin"), tt.wantOrigin)
+
+			if tt.wantOrigin != "" {
+				is.Equal(w.Header().Get("Access-Control-Allow-Credentials"), "true")
+			} else {
+				is.Equal(w.Header().Get("Access-Control-Allow-Credentials"), "")
+			}
+			if tt.preflight && tt.wantOrigin != "" {
+				is.Equal(w.Header().Get("Access-Control-Allow-Methods"), corsAllowedMethods)
+				is.Equal(w.Header().Get("Access-Control-Allow-Headers"), corsAllowedHeaders)
+			}
+		}
+	}
+
+	t.Run(scenario(testArgs{
+		name:         "allowed origin",
+		method:       http.MethodGet,
+		origin:       "https://chat.example.com",
+		wantCode:     http.StatusOK,
+		wantOrigin:   "https://chat.example.com",
+		wantNextCall: true,
+	}))
+	t.Run(scenario(testArgs{
+		name:         "disallowed origin",
+		method:       http.MethodGet,
+		origin:       "https://evil.example.org",
+		wantCode:     http.StatusOK,
+		wantNextCall: true,
+	}))
+	t.Run(scenario(testArgs{
+		name:         "same origin",
+		method:       http.MethodGet,
+		wantCode:     http.StatusOK,
+		wantNextCall: true,
+	}))
+	t.Run(scenario(testArgs{
+		name:       "preflight",
+		method:     http.MethodOptions,
+		origin:     "https://example.com",
+		preflight:  true,
+		wantCode:   http.StatusNoContent,
+		wantOrigin: "https://example.com",
+	}))
+	t.Run(scenario(testArgs{
+		name:      "disallowed preflight",
+		method:    http.MethodOptions,
+		origin:    "https://evil.example.org",
+		preflight: true,
+		wantCode:  http.StatusForbidden,
+	}))
+}
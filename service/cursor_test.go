@@ -0,0 +1,177 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+
+	"github.com/fenole/szmaterlok/service/sse"
+)
+
+func TestResumeCursorCodec(t *testing.T) {
+	is := is.New(t)
+
+	clock, move := testMovingClock()
+	codec := NewResumeCursorCodec([]byte("secret"), time.Hour, clock)
+
+	cursor := codec.Encode(42)
+	got, err := codec.Decode(cursor)
+	is.NoErr(err)
+	is.Equal(got, uint64(42))
+
+	t.Run("tampered", func(t *testing.T) {
+		is := is.New(t)
+
+		// Every byte of cursor is covered by signature.
+		b, err := codec.base64.DecodeString(cursor)
+		is.NoErr(err)
+		for i := range b {
+			tampered := append([]byte{}, b...)
+			tampered[i] ^= 1
+
+			_, err := codec.Decode(codec.base64.EncodeToString(tampered))
+			is.Equal(err, ErrResumeCursorInvalid)
+		}
+
+		// Cursors signed with other secret are rejected as well.
+		other := NewResumeCursorCodec([]byte("other"), time.Hour, clock)
+		_, err = other.Decode(cursor)
+		is.Equal(err, ErrResumeCursorInvalid)
+	})
+
+	t.Run("malformed", func(t *testing.T) {
+		is := is.New(t)
+
+		for _, raw := range []string{"", "42", "not base64!", cursor[:len(cursor)-2]} {
+			_, err := codec.Decode(raw)
+			is.Equal(err, ErrResumeCursorInvalid)
+		}
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		is := is.New(t)
+
+		move(time.Minute * 59)
+		_, err := codec.Decode(cursor)
+		is.NoErr(err)
+
+		move(time.Minute)
+		_, err = codec.Decode(cursor)
+		is.Equal(err, ErrResumeCursorExpired)
+
+		// Cursors without time to live never expire.
+		eternal := NewResumeCursorCodec([]byte("secret"), 0, clock)
+		_, err = eternal.Decode(cursor)
+		is.NoErr(err)
+	})
+}
+
+func TestMessageNotifierWithBufferCursors(t *testing.T) {
+	type testArgs struct {
+		name string
+
+		// lastEventID returns Last-Event-ID header sent by client.
+		lastEventID func(codec *ResumeCursorCodec) string
+
+		// elapsed is time between issuing cursor and reconnecting.
+		elapsed time.Duration
+		want    []uint64
+	}
+
+	scenario := func(tt testArgs) (string, func(*testing.T)) {
+		return tt.name, func(t *testing.T) {
+			is := is.New(t)
+			log := testLogger()
+
+			clock, move := testMovingClock()
+			codec := NewResumeCursorCodec([]byte("secret"), time.Hour, clock)
+
+			buffer := NewLastMessagesBuffer(5, log)
+			for seq := uint64(1); seq <= 5; seq++ {
+				id := strconv.FormatUint(seq, 10)
+				data, err := json.Marshal(EventSentMessage{ID: "msg-" + id})
+				is.NoErr(err)
+				buffer.EventHook(context.Background(), BridgeEvent{
+					Name:     BridgeMessageSent,
+					ID:       "msg-" + id,
+					Sequence: seq,
+					Data:     data,
+				})
+			}
+
+			lastEventID := tt.lastEventID(codec)
+			move(tt.elapsed)
+
+			n := &MessageNotifierWithBuffer{
+				Notifier: NewBridgeMessageHandler(log),
+				Buffer:   buffer,
+				Logger:   log,
+				Cursors:  codec,
+			}
+
+			evts := make(chan sse.Event, 8)
+			unsubscribe := n.Subscribe(ContextWithLastEventID(context.Background(), lastEventID), MessageSubscribeRequest{
+				ID:        "1",
+				RequestID: "req",
+				Channel:   evts,
+			})
+			defer unsubscribe()
+
+			got := []uint64{}
+			for {
+				var evt sse.Event
+				select {
+				case evt = <-evts:
+				case <-time.After(time.Second):
+					t.Fatal("event has not been delivered")
+				}
+				if evt.Type == StreamReady {
+					break
+				}
+
+				// Buffered messages are identified by cursors.
+				seq, err := codec.Decode(evt.ID)
+				is.NoErr(err)
+				got = append(got, seq)
+			}
+			is.Equal(got, tt.want)
+		}
+	}
+
+	t.Run(scenario(testArgs{
+		name: "valid cursor",
+		lastEventID: func(codec *ResumeCursorCodec) string {
+			return codec.Encode(3)
+		},
+		elapsed: time.Minute,
+		want:    []uint64{4, 5},
+	}))
+	t.Run(scenario(testArgs{
+		name: "tampered cursor",
+		lastEventID: func(codec *ResumeCursorCodec) string {
+			cursor := []byte(codec.Encode(3))
+			cursor[0] ^= 1
+			return string(cursor)
+		},
+		want: []uint64{1, 2, 3, 4, 5},
+	}))
+	t.Run(scenario(testArgs{
+		name: "expired cursor",
+		lastEventID: func(codec *ResumeCursorCodec) string {
+			return codec.Encode(3)
+		},
+		elapsed: time.Hour,
+		want:    []uint64{1, 2, 3, 4, 5},
+	}))
+	t.Run(scenario(testArgs{
+		name: "raw sequence number",
+		lastEventID: func(codec *ResumeCursorCodec) string {
+			return "3"
+		},
+		want: []uint64{1, 2, 3, 4, 5},
+	}))
+}
@@ -0,0 +1,110 @@
+package service
+
+// ErrorReason is machine-readable reason of error response. Unlike
+// messages, reasons are stable, so clients can tell errors apart.
+type ErrorReason string
+
+const (
+	// ErrorReasonInternal is reason of unexpected server errors.
+	ErrorReasonInternal ErrorReason = "internal_error"
+
+	// ErrorReasonUnavailable is reason of requests made, when service
+	// or its feature isn't available.
+	ErrorReasonUnavailable ErrorReason = "unavailable"
+
+	// ErrorReasonUnauthenticated is reason of requests without
+	// session, which is required.
+	ErrorReasonUnauthenticated ErrorReason = "unauthenticated"
+
+	// ErrorReasonInvalidCredentials is reason of requests with missing
+	// or invalid API key or admin token.
+	ErrorReasonInvalidCredentials ErrorReason = "invalid_credentials"
+
+	// ErrorReasonAdminRequired is reason of requests to admin resources
+	// made by other users.
+	ErrorReasonAdminRequired ErrorReason = "admin_required"
+
+	// ErrorReasonOriginNotAllowed is reason of rejected CORS preflight
+	// requests.
+	ErrorReasonOriginNotAllowed ErrorReason = "origin_not_allowed"
+
+	// ErrorReasonInvalidBody is reason of requests with malformed or
+	// invalid body.
+	ErrorReasonInvalidBody ErrorReason = "invalid_body"
+
+	// ErrorReasonInvalidParam is reason of requests with invalid query
+	// params.
+	ErrorReasonInvalidParam ErrorReason = "invalid_param"
+
+	// ErrorReasonNotFound is reason of requests for resources, which
+	// don't exist.
+	ErrorReasonNotFound ErrorReason = "not_found"
+
+	// ErrorReasonNicknameEmpty is reason of login without nickname.
+	ErrorReasonNicknameEmpty ErrorReason = "nickname_empty"
+
+	// ErrorReasonNicknameInvalid is reason of login with nickname, which
+	// is too short, too long or contains control characters.
+	ErrorReasonNicknameInvalid ErrorReason = "nickname_invalid"
+
+	// ErrorReasonNicknameBanned is reason of login with banned nickname.
+	ErrorReasonNicknameBanned ErrorReason = "nickname_banned"
+
+	// ErrorReasonMessageEmpty is reason of messages without content.
+	ErrorReasonMessageEmpty ErrorReason = "message_empty"
+
+	// ErrorReasonMessageTooLong is reason of messages exceeding maximal
+	// message length.
+	ErrorReasonMessageTooLong ErrorReason = "message_too_long"
+
+	// ErrorReasonMessageRejected is reason of messages rejected by
+	// message filters.
+	ErrorReasonMessageRejected ErrorReason = "message_rejected"
+
+	// ErrorReasonMessageNotFound is reason of requests for messages,
+	// which don't exist.
+	ErrorReasonMessageNotFound ErrorReason = "message_not_found"
+
+	// ErrorReasonNotAuthor is reason of requests modifying messages of
+	// other users.
+	ErrorReasonNotAuthor ErrorReason = "not_author"
+
+	// ErrorReasonAttachmentNotFound is reason of messages with
+	// attachments, which don't exist.
+	ErrorReasonAttachmentNotFound ErrorReason = "attachment_not_found"
+
+	// ErrorReasonUserOffline is reason of requests concerning users,
+	// who aren't online.
+	ErrorReasonUserOffline ErrorReason = "user_offline"
+
+	// ErrorReasonInvalidStatus is reason of requests setting unknown
+	// presence status.
+	ErrorReasonInvalidStatus ErrorReason = "invalid_status"
+
+	// ErrorReasonRateLimited is reason of requests rejected by rate
+	// limiter.
+	ErrorReasonRateLimited ErrorReason = "rate_limited"
+
+	// ErrorReasonSlowMode is reason of messages sent too soon to
+	// channel in slow mode.
+	ErrorReasonSlowMode ErrorReason = "slow_mode"
+
+	// ErrorReasonTooManyConnections is reason of event streams
+	// rejected by connection limits.
+	ErrorReasonTooManyConnections ErrorReason = "too_many_connections"
+
+	// ErrorReasonMuted is reason of messages sent by muted users.
+	ErrorReasonMuted ErrorReason = "muted"
+
+	// ErrorReasonFileTooLarge is reason of uploads exceeding maximal
+	// file size.
+	ErrorReasonFileTooLarge ErrorReason = "file_too_large"
+
+	// ErrorReasonUnsupportedMediaType is reason of uploads of files,
+	// which type isn't allowed.
+	ErrorReasonUnsupportedMediaType ErrorReason = "unsupported_media_type"
+
+	// ErrorReasonBodyTooLarge is reason of requests, which body
+	// exceeds maximal size.
+	ErrorReasonBodyTooLarge ErrorReason = "body_too_large"
+)
@@ -0,0 +1,165 @@
+package service
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// MessageFilter transforms messages before they're sent. Filters can
+// modify any field of the message or reject it by returning an error.
+type MessageFilter interface {
+	// Transform modifies given message in place. Returned error
+	// rejects the message.
+	Transform(ctx context.Context, msg *EventSentMessage) error
+}
+
+// MessageFilterFunc is functional interface of MessageFilter.
+type MessageFilterFunc func(ctx context.Context, msg *EventSentMessage) error
+
+// Transform modifies given message in place. Returned error
+// rejects the message.
+func (f MessageFilterFunc) Transform(ctx context.Context, msg *EventSentMessage) error {
+	return f(ctx, msg)
+}
+
+// MessageFilterChain applies all of its filters in order. It stops at
+// the first filter, which rejects the message.
+type MessageFilterChain []MessageFilter
+
+// Transform modifies given message with every filter of chain.
+func (c MessageFilterChain) Transform(ctx context.Context, msg *EventSentMessage) error {
+	for _, f := range c {
+		if err := f.Transform(ctx, msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ErrFilterFailed marks errors of message filters, which failed to
+// process message, for example because their storage is unavailable.
+// Such errors fail the request instead of rejecting the message.
+var ErrFilterFailed = errors.New("filter: failed to process message")
+
+// messageRejected responds to the request with error returned by
+// message filter. Message is rejected, unless filter failed with
+// ErrFilterFailed, which is reported as internal error.
+func messageRejected(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, ErrFilterFailed) {
+		writeError(w, r, http.StatusInternalServerError, ErrorReasonInternal, "Failed to process message. Please try again later.")
+		return
+	}
+
+	jsonResponse(w, http.StatusBadRequest, responseWrapper{
+		Error: errorResponse{
+			Code:    http.StatusBadRequest,
+			Reason:  ErrorReasonMessageRejected,
+			Message: fmt.Sprintf("Message has been rejected: %s.", err),
+		},
+	})
+}
+
+// WordFilter replaces forbidden words with asterisks. Words are
+// matched case-insensitively and only as whole words, so forbidden
+// words inside other words are left untouched.
+type WordFilter struct {
+	words map[string]struct{}
+}
+
+// NewWordFilter returns word filter for given list of forbidden words.
+func NewWordFilter(words []string) *WordFilter {
+	f := &WordFilter{
+		words: make(map[string]struct{}, len(words)),
+	}
+	for _, word := range words {
+		word = strings.TrimSpace(word)
+		if word != "" {
+			f.words[strings.ToLower(word)] = struct{}{}
+		}
+	}
+
+	return f
+}
+
+// LoadWordFilter reads forbidden words from file at given path. File
+// contains single word per line. Empty lines and lines starting with
+// # are skipped.
+func LoadWordFilter(path string) (*WordFilter, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open word filter file: %w", err)
+	}
+	defer file.Close()
+
+	words := []string{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		words = append(words, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read word filter file: %w", err)
+	}
+
+	return NewWordFilter(words), nil
+}
+
+// Transform replaces forbidden words in message content with
+// asterisks. It never rejects the message.
+func (f *WordFilter) Transform(ctx context.Context, msg *EventSentMessage) error {
+	msg.Content = f.FilterContent(msg.Content)
+	return nil
+}
+
+// FilterContent returns given content with forbidden words replaced
+// by asterisks.
+func (f *WordFilter) FilterContent(content string) string {
+	if len(f.words) == 0 {
+		return content
+	}
+
+	var b strings.Builder
+	b.Grow(len(content))
+
+	// Word is maximal sequence of letters and digits, so boundaries
+	// work for any script, not just ASCII.
+	start := -1
+	flush := func(end int) {
+		word := content[start:end]
+		if _, ok := f.words[strings.ToLower(word)]; ok {
+			b.WriteString(strings.Repeat("*", utf8.RuneCountInString(word)))
+		} else {
+			b.WriteString(word)
+		}
+		start = -1
+	}
+
+	for i, r := range content {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if start < 0 {
+				start = i
+			}
+			continue
+		}
+
+		if start >= 0 {
+			flush(i)
+		}
+		b.WriteRune(r)
+	}
+	if start >= 0 {
+		flush(len(content))
+	}
+
+	return b.String()
+}
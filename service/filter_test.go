@@ -0,0 +1,140 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+func TestWordFilter(t *testing.T) {
+	type testArgs struct {
+		name    string
+		words   []string
+		content string
+		want    string
+	}
+
+	scenario := func(tt testArgs) (string, func(*testing.T)) {
+		return tt.name, func(t *testing.T) {
+			is := is.New(t)
+			is.Equal(NewWordFilter(tt.words).FilterContent(tt.content), tt.want)
+		}
+	}
+
+	t.Run(scenario(testArgs{
+		name:    "empty list",
+		content: "heck yeah",
+		want:    "heck yeah",
+	}))
+	t.Run(scenario(testArgs{
+		name:    "whole word",
+		words:   []string{"heck"},
+		content: "heck yeah",
+		want:    "**** yeah",
+	}))
+	t.Run(scenario(testArgs{
+		name:    "case insensitive",
+		words:   []string{"HeCk"},
+		content: "HECK, heck and Heck",
+		want:    "****, **** and ****",
+	}))
+	t.Run(scenario(testArgs{
+		name:    "inside other word",
+		words:   []string{"ass"},
+		content: "assassin passes class",
+		want:    "assassin passes class",
+	}))
+	t.Run(scenario(testArgs{
+		name:    "surrounded by punctuation",
+		words:   []string{"ass"},
+		content: "(ass)! ass.ass",
+		want:    "(***)! ***.***",
+	}))
+	t.Run(scenario(testArgs{
+		name:    "joined with digits",
+		words:   []string{"ass"},
+		content: "ass1 1ass ass",
+		want:    "ass1 1ass ***",
+	}))
+	t.Run(scenario(testArgs{
+		name:    "non-ascii letters",
+		words:   []string{"żółw"},
+		content: "Żółw, żółwie i żółw.",
+		want:    "****, żółwie i ****.",
+	}))
+}
+
+func TestMessageFilterChain(t *testing.T) {
+	errLink := errors.New("links are not allowed")
+
+	type testArgs struct {
+		name    string
+		content string
+		want    string
+		err     error
+		calls   int
+	}
+
+	scenario := func(tt testArgs) (string, func(*testing.T)) {
+		return tt.name, func(t *testing.T) {
+			is := is.New(t)
+
+			calls := 0
+			chain := MessageFilterChain{
+				NewWordFilter([]string{"heck"}),
+				MessageFilterFunc(func(ctx context.Context, msg *EventSentMessage) error {
+					if strings.Contains(msg.Content, "://") {
+						return errLink
+					}
+					return nil
+				}),
+				MessageFilterFunc(func(ctx context.Context, msg *EventSentMessage) error {
+					calls++
+					msg.Content = strings.ToUpper(msg.Content)
+					return nil
+				}),
+			}
+
+			msg := &EventSentMessage{Content: tt.content}
+			err := chain.Transform(context.Background(), msg)
+			is.True(errors.Is(err, tt.err))
+			is.Equal(msg.Content, tt.want)
+
+			// Filters after rejecting one don't run.
+			is.Equal(calls, tt.calls)
+		}
+	}
+
+	t.Run(scenario(testArgs{
+		name:    "mutated",
+		content: "oh heck",
+		want:    "OH ****",
+		calls:   1,
+	}))
+	t.Run(scenario(testArgs{
+		name:    "rejected",
+		content: "heck https://example.com",
+		want:    "**** https://example.com",
+		err:     errLink,
+		calls:   0,
+	}))
+}
+
+func TestLoadWordFilter(t *testing.T) {
+	is := is.New(t)
+
+	path := filepath.Join(t.TempDir(), "words.txt")
+	is.NoErr(os.WriteFile(path, []byte("# forbidden words\nheck\n\n  darn  \n"), 0o600))
+
+	f, err := LoadWordFilter(path)
+	is.NoErr(err)
+	is.Equal(f.FilterContent("heck darn forbidden"), "**** **** forbidden")
+
+	_, err = LoadWordFilter(filepath.Join(t.TempDir(), "missing.txt"))
+	is.True(err != nil)
+}
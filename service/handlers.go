@@ -2,16 +2,25 @@ package service
 
 import (
 	"context"
+	"crypto/subtle"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"hash/fnv"
 	"html/template"
 	"io/fs"
 	"net/http"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
+	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/sirupsen/logrus"
+	"golang.org/x/exp/slices"
 
 	"github.com/fenole/szmaterlok/service/sse"
 )
@@ -28,7 +37,7 @@ func HandlerIndex(f fs.FS) http.HandlerFunc {
 
 		w.WriteHeader(http.StatusOK)
 		if err := tmpl.ExecuteTemplate(w, "layout", nil); err != nil {
-			http.Error(w, "failed to parse delivered html template", http.StatusInternalServerError)
+			writeError(w, r, http.StatusInternalServerError, ErrorReasonInternal, "failed to parse delivered html template")
 			return
 		}
 	}
@@ -46,31 +55,119 @@ func HandlerChat(f fs.FS) http.HandlerFunc {
 
 		w.WriteHeader(http.StatusOK)
 		if err := tmpl.ExecuteTemplate(w, "layout", nil); err != nil {
-			http.Error(w, "failed to parse delivered html template", http.StatusInternalServerError)
+			writeError(w, r, http.StatusInternalServerError, ErrorReasonInternal, "failed to parse delivered html template")
 			return
 		}
 	}
 }
 
+// Nickname validation errors.
+var (
+	ErrNicknameTooShort    = errors.New("nickname is too short")
+	ErrNicknameTooLong     = errors.New("nickname is too long")
+	ErrNicknameControlChar = errors.New("nickname cannot contain control characters")
+)
+
+// NicknamePolicy describes constraints for nicknames chosen by users.
+type NicknamePolicy struct {
+	// MinLength is minimal number of runes in nickname.
+	MinLength int
+
+	// MaxLength is maximal number of runes in nickname.
+	MaxLength int
+}
+
+// ValidateNickname returns error if given nickname doesn't follow nickname
+// policy. Nickname should be trimmed from surrounding whitespace before
+// validation.
+func (p NicknamePolicy) ValidateNickname(nickname string) error {
+	length := utf8.RuneCountInString(nickname)
+	if length < p.MinLength {
+		return fmt.Errorf("%w (minimum is %d)", ErrNicknameTooShort, p.MinLength)
+	}
+	if length > p.MaxLength {
+		return fmt.Errorf("%w (maximum is %d)", ErrNicknameTooLong, p.MaxLength)
+	}
+
+	for _, r := range nickname {
+		if unicode.IsControl(r) {
+			return ErrNicknameControlChar
+		}
+	}
+
+	return nil
+}
+
+// AdminPolicy decides which users are granted admin privileges
+// at login.
+type AdminPolicy struct {
+	// Nicknames restricts admin privileges to users with listed
+	// nicknames. Any nickname is allowed when it's empty. Nicknames
+	// are chosen at login, so they never grant privileges alone.
+	Nicknames []string
+
+	// Token grants admin privileges to user, who passes it with
+	// login form. Nobody is admin when it's empty.
+	Token string
+}
+
+// IsAdmin reports whether user with given nickname, who logs in with
+// given token, should be admin.
+func (p AdminPolicy) IsAdmin(nickname, token string) bool {
+	if p.Token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(p.Token)) != 1 {
+		return false
+	}
+
+	return len(p.Nicknames) == 0 || slices.Contains(p.Nicknames, nickname)
+}
+
 // HandlerLoginDependencies holds behavioral dependencies for
 // login http handler.
 type HandlerLoginDependencies struct {
-	StateFactory *SessionStateFactory
-	Logger       *logrus.Logger
-	SessionStore *SessionCookieStore
+	StateFactory   *SessionStateFactory
+	Logger         *logrus.Logger
+	SessionStore   *SessionCookieStore
+	NicknamePolicy NicknamePolicy
+	AdminPolicy    AdminPolicy
+
+	// Bans holds banned nicknames. Bans are not checked when
+	// it's nil.
+	Bans BanStore
 }
 
 func HandlerLogin(deps HandlerLoginDependencies) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		nickname := r.FormValue("nickname")
+		nickname := strings.TrimSpace(r.FormValue("nickname"))
 		if nickname == "" {
-			http.Error(w, "Nickname cannot be empty.", http.StatusBadRequest)
+			writeError(w, r, http.StatusBadRequest, ErrorReasonNicknameEmpty, "Nickname cannot be empty.")
+			return
+		}
+
+		if err := deps.NicknamePolicy.ValidateNickname(nickname); err != nil {
+			writeError(w, r, http.StatusBadRequest, ErrorReasonNicknameInvalid, fmt.Sprintf("Invalid nickname: %s.", err))
 			return
 		}
 
+		if deps.Bans != nil {
+			banned, err := deps.Bans.Banned(r.Context(), banID(nickname))
+			if err != nil {
+				deps.Logger.WithFields(logrus.Fields{
+					"reqID": middleware.GetReqID(r.Context()),
+					"error": err.Error(),
+				}).Error("Failed to check nickname ban.")
+				writeError(w, r, http.StatusInternalServerError, ErrorReasonInternal, "Failed to check nickname ban.")
+				return
+			}
+			if banned {
+				writeError(w, r, http.StatusForbidden, ErrorReasonNicknameBanned, "Nickname has been banned.")
+				return
+			}
+		}
+
 		state := deps.StateFactory.MakeState(nickname)
+		state.Admin = deps.AdminPolicy.IsAdmin(nickname, r.FormValue("adminToken"))
 		if err := deps.SessionStore.SaveSessionState(w, state); err != nil {
-			http.Error(w, "Failed to save session state.", http.StatusInternalServerError)
+			writeError(w, r, http.StatusInternalServerError, ErrorReasonInternal, "Failed to save session state.")
 			return
 		}
 
@@ -86,6 +183,58 @@ func HandlerLogout(cs *SessionCookieStore) http.HandlerFunc {
 	}
 }
 
+// HandlerLogoutAll revokes all sessions of current user, so every
+// token of the user becomes invalid, and deletes session cookie. Session
+// ID is added to revocation list and tokens kept on the server side are
+// removed. It requires authentication.
+func HandlerLogoutAll(cs *SessionCookieStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		state := SessionContextState(ctx)
+		if state == nil {
+			writeError(w, r, http.StatusForbidden, ErrorReasonUnauthenticated, "Logout requires authentication.")
+			return
+		}
+
+		revoked := false
+		if cs.Revoker != nil {
+			// Other copies of the session could have been refreshed
+			// with later expiration date than the current one, so
+			// session is revoked until any of them can expire.
+			expireAt := state.ExpireAt
+			if refreshed := cs.Now().Add(cs.ExpirationTime); refreshed.After(expireAt) {
+				expireAt = refreshed
+			}
+
+			if err := cs.Revoker.RevokeSession(ctx, state.ID, expireAt); err != nil {
+				writeError(w, r, http.StatusInternalServerError, ErrorReasonInternal, "Failed to revoke session. Please try again later.")
+				return
+			}
+			revoked = true
+		}
+
+		if tokens, ok := cs.Tokenizer.(SessionTokenRevoker); ok {
+			err := tokens.RevokeUserTokens(ctx, state.ID)
+			switch {
+			case errors.Is(err, ErrTokenRevocationUnsupported):
+			case err != nil:
+				writeError(w, r, http.StatusInternalServerError, ErrorReasonInternal, "Failed to revoke session. Please try again later.")
+				return
+			default:
+				revoked = true
+			}
+		}
+
+		if !revoked {
+			writeError(w, r, http.StatusNotImplemented, ErrorReasonUnavailable, "Session revocation is disabled.")
+			return
+		}
+
+		cs.ClearState(w)
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+	}
+}
+
 // MessageSent is SSE event type for message sent event.
 const MessageSent = "message-sent"
 
@@ -93,15 +242,143 @@ const MessageSent = "message-sent"
 type ChatUser struct {
 	ID       string `json:"id"`
 	Nickname string `json:"nickname"`
+
+	// Color is CSS color of user in chat. It's derived from user ID
+	// by UserPresentation.
+	Color string `json:"color,omitempty"`
+}
+
+// UserPresentation returns chat user with given ID and nickname
+// together with fields, which describe how user is presented in chat.
+// Presentation is derived from user ID, so it's the same in every
+// event of the user.
+func UserPresentation(id, nickname string) ChatUser {
+	h := fnv.New32a()
+	h.Write([]byte(id))
+
+	return ChatUser{
+		ID:       id,
+		Nickname: nickname,
+		Color:    fmt.Sprintf("hsl(%d, 65%%, 45%%)", h.Sum32()%360),
+	}
+}
+
+// ChatChannelDefault is name of chat channel used when client
+// doesn't choose any.
+const ChatChannelDefault = "general"
+
+// ChatChannelOrDefault returns given chat channel name or the default
+// one, if given name is empty.
+func ChatChannelOrDefault(channel string) string {
+	if channel == "" {
+		return ChatChannelDefault
+	}
+	return channel
+}
+
+// ChatChannelMaxLength is maximal length of chat channel name.
+const ChatChannelMaxLength = 32
+
+// validChatChannel reports whether given chat channel name is non-empty
+// and consists of at most ChatChannelMaxLength ASCII letters, digits,
+// dashes and underscores.
+func validChatChannel(channel string) bool {
+	if channel == "" || len(channel) > ChatChannelMaxLength {
+		return false
+	}
+	for _, c := range channel {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-', c == '_':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// requestChatChannel returns chat channel name from query params of
+// given request. It returns false, if the name is invalid.
+func requestChatChannel(r *http.Request) (string, bool) {
+	channel := ChatChannelOrDefault(r.URL.Query().Get("channel"))
+	return channel, validChatChannel(channel)
+}
+
+// invalidChatChannel responds to the request with invalid chat channel
+// name in its query params.
+func invalidChatChannel(w http.ResponseWriter, r *http.Request) {
+	writeError(w, r, http.StatusBadRequest, ErrorReasonInvalidParam, fmt.Sprintf("Channel must consist of 1 to %d letters, digits, dashes or underscores.", ChatChannelMaxLength))
 }
 
 // EventSentMessage is model for event of single sent message
-// by client to all listeners.
+// by client to all listeners of its chat channel.
 type EventSentMessage struct {
-	ID      string    `json:"id"`
+	ID string `json:"id"`
+
+	// Sequence is sequence number of message-sent event. It's set for
+	// buffered and archived messages only.
+	Sequence uint64 `json:"sequence,omitempty"`
+
 	From    ChatUser  `json:"from"`
+	Channel string    `json:"channel"`
 	Content string    `json:"content"`
 	SentAt  time.Time `json:"sentAt"`
+
+	// To is recipient of direct message. Direct messages are
+	// delivered only to their author and recipient.
+	To *ChatUser `json:"to,omitempty"`
+
+	// Mentions are online users mentioned in content with @nickname.
+	Mentions []ChatUser `json:"mentions,omitempty"`
+
+	// HTML is sanitized content rendered from markdown. It's empty
+	// when markdown rendering is disabled.
+	HTML string `json:"html,omitempty"`
+
+	// ClientMsgID is optional ID assigned by sending client, so it can
+	// reconcile optimistically rendered message with its event. ID
+	// field remains canonical message ID.
+	ClientMsgID string `json:"clientMsgId,omitempty"`
+
+	// Attachments are files uploaded with /upload and sent along with
+	// the message.
+	Attachments []Attachment `json:"attachments,omitempty"`
+}
+
+// clientMsgIDMaxLength is maximal length of client message ID.
+const clientMsgIDMaxLength = 64
+
+// messageAttachmentsMax is maximal number of attachments of single
+// message.
+const messageAttachmentsMax = 10
+
+// EventMessageEdited is model for event of single message being edited
+// by its author. Channel and recipient are copied from the edited message,
+// so the edit reaches the same listeners as the original message.
+type EventMessageEdited struct {
+	ID        string    `json:"id"`
+	MessageID string    `json:"messageId"`
+	Content   string    `json:"content"`
+	EditedAt  time.Time `json:"editedAt"`
+	From      ChatUser  `json:"from"`
+	Channel   string    `json:"channel"`
+	To        *ChatUser `json:"to,omitempty"`
+	HTML      string    `json:"html,omitempty"`
+
+	// Mentions are online users mentioned in edited content.
+	Mentions []ChatUser `json:"mentions,omitempty"`
+}
+
+// EventMessageDeleted is model for event of single message being deleted.
+// Author, channel and recipient are copied from the deleted message, so
+// the deletion reaches the same listeners as the original message.
+type EventMessageDeleted struct {
+	ID        string    `json:"id"`
+	MessageID string    `json:"messageId"`
+	DeletedAt time.Time `json:"deletedAt"`
+	By        ChatUser  `json:"by"`
+	From      ChatUser  `json:"from"`
+	Channel   string    `json:"channel"`
+	To        *ChatUser `json:"to,omitempty"`
 }
 
 // EventUserJoin is model for event of single user joining chat.
@@ -118,17 +395,41 @@ type EventUserLeft struct {
 	LeftAt time.Time `json:"leftAt"`
 }
 
+// EventUserTyping is model for event of single user typing message.
+type EventUserTyping struct {
+	ID   string    `json:"id"`
+	User ChatUser  `json:"user"`
+	At   time.Time `json:"at"`
+}
+
+// EventUserPresence is model for event of user changing their presence
+// status.
+type EventUserPresence struct {
+	ID     string    `json:"id"`
+	User   ChatUser  `json:"user"`
+	Status string    `json:"status"`
+	At     time.Time `json:"at"`
+}
+
 // MessageSubscribeRequest holds arguments for subscribe
 // method of MessageNotifier.
 type MessageSubscribeRequest struct {
 	// ID is chat (channel, user or any other chat entity) ID.
 	ID string
 
+	// Nickname is display name of subscribing chat entity.
+	Nickname string
+
+	// ChatChannel is name of chat channel, which messages
+	// will be sent to the subscriber.
+	ChatChannel string
+
 	// RequestID is unique request ID. One client, with the same ID,
 	// can have multiple request IDs.
 	RequestID string
 
-	// Channel for sending SSE events.
+	// Channel for sending SSE events. It is closed when subscriber
+	// is disconnected by the server.
 	Channel chan<- sse.Event
 }
 
@@ -138,6 +439,13 @@ type MessageNotifier interface {
 	Subscribe(ctx context.Context, args MessageSubscribeRequest) func()
 }
 
+// MessageNotifierFunc is functional interface of MessageNotifier.
+type MessageNotifierFunc func(ctx context.Context, args MessageSubscribeRequest) func()
+
+func (f MessageNotifierFunc) Subscribe(ctx context.Context, args MessageSubscribeRequest) func() {
+	return f(ctx, args)
+}
+
 // EventAnnouncer wraps MessageNotifier and user activities producers
 // and announces user presence to every event listener during single
 // subscribe and unsubscribe action.
@@ -147,39 +455,46 @@ type EventAnnouncer struct {
 	UserJoinProducer *BridgeEventProducer[EventUserJoin]
 	UserLeftProducer *BridgeEventProducer[EventUserLeft]
 
+	// Shutdown announces users leaving, when server is shutting down.
+	// Users of streams closed after the announcement aren't announced
+	// again. It can be nil.
+	Shutdown *ShutdownAnnouncer
+
 	Clock
 	IDGenerator
 }
 
 // Subscribe given ID for SSE events. Returns unsubscribe func.
+// Requests without session state aren't subscribed and nothing is
+// announced, so their unsubscribe func does nothing.
 func (ea *EventAnnouncer) Subscribe(ctx context.Context, args MessageSubscribeRequest) func() {
 	state := SessionContextState(ctx)
 	if state == nil {
-		return nil
+		return func() {}
 	}
 
 	joinID := ea.GenerateID()
 	go ea.UserJoinProducer.SendEvent(ctx, joinID, EventUserJoin{
-		ID: joinID,
-		User: ChatUser{
-			ID:       state.ID,
-			Nickname: state.Nickname,
-		},
+		ID:       joinID,
+		User:     UserPresentation(state.ID, state.Nickname),
 		JoinedAt: ea.Now(),
 	})
 
 	unsubscribe := ea.MessageNotifier.Subscribe(ctx, args)
 	wrappedUnsubscribe := func() {
+		if !ea.Shutdown.leave(state.ID, unsubscribe) {
+			return
+		}
+
+		// User-left event is queued before unsubscribe returns, so
+		// it isn't lost when server shuts down event bridge right
+		// after event streams are closed.
 		id := ea.GenerateID()
-		go ea.UserLeftProducer.SendEvent(ctx, id, EventUserLeft{
-			ID: id,
-			User: ChatUser{
-				ID:       state.ID,
-				Nickname: state.Nickname,
-			},
+		ea.UserLeftProducer.SendEvent(ctx, id, EventUserLeft{
+			ID:     id,
+			User:   UserPresentation(state.ID, state.Nickname),
 			LeftAt: ea.Now(),
 		})
-		unsubscribe()
 	}
 
 	return wrappedUnsubscribe
@@ -187,11 +502,45 @@ func (ea *EventAnnouncer) Subscribe(ctx context.Context, args MessageSubscribeRe
 
 // HandlerStreamDependencies holds arguments for HandlerStream http handler.
 type HandlerStreamDependencies struct {
+	// HeartbeatInterval is the period of inactivity after which
+	// keep-alive comment is sent to the client. Zero value disables
+	// heartbeats.
+	HeartbeatInterval time.Duration
+
+	// ReconnectTime is sent to the client with the first event of
+	// every connection. Browser waits given time before reconnecting
+	// to the event stream. Zero value leaves browser default.
+	ReconnectTime time.Duration
+
+	// Metrics count open event streams. It can be nil.
+	Metrics *Metrics
+
+	// Shutdown closes event stream with server shutdown event, when
+	// server is shutting down. It can be nil.
+	Shutdown *StreamShutdown
+
+	// Connections limit number of open event streams. There is no
+	// limit when it's nil.
+	Connections *ConnectionLimiter
+
+	// Presence records heartbeats of open event stream every
+	// PresenceInterval, so user isn't expired while it's connected.
+	// Heartbeats aren't recorded when it's nil or interval is zero.
+	Presence         PresenceHeartbeater
+	PresenceInterval time.Duration
+
+	// BufferSize is number of events which can wait for the client,
+	// before it's treated as slow client.
+	BufferSize int
+
 	MessageNotifier
 	IDGenerator
 	Clock
 }
 
+// heartbeatComment is text of SSE comment sent to idle clients.
+const heartbeatComment = "keep-alive"
+
 // HandlerStream is SSE event stream handler, which sends event notifications
 // to clients. It requires authentication.
 //
@@ -201,45 +550,139 @@ func HandlerStream(deps HandlerStreamDependencies) http.HandlerFunc {
 		ctx := r.Context()
 		state := SessionContextState(ctx)
 		if state == nil {
-			jsonResponse(w, http.StatusForbidden, responseWrapper{
-				Error: errorResponse{
-					Code:    http.StatusForbidden,
-					Message: "Event stream requires authentication.",
-				},
-			})
+			writeError(w, r, http.StatusForbidden, ErrorReasonUnauthenticated, "Event stream requires authentication.")
 			return
 		}
 
 		// Make sure that the writer supports flushing.
 		flusher, ok := w.(http.Flusher)
 		if !ok {
-			http.Error(w, "Streaming unsupported!", http.StatusInternalServerError)
+			writeError(w, r, http.StatusInternalServerError, ErrorReasonInternal, "Streaming unsupported!")
+			return
+		}
+
+		channel, ok := requestChatChannel(r)
+		if !ok {
+			invalidChatChannel(w, r)
+			return
+		}
+
+		if err := deps.Connections.Acquire(state.ID); err != nil {
+			if errors.Is(err, ErrTooManyUserConnections) {
+				writeError(w, r, http.StatusTooManyRequests, ErrorReasonTooManyConnections, "You have too many open event streams.")
+				return
+			}
+			writeError(w, r, http.StatusServiceUnavailable, ErrorReasonTooManyConnections, "Server has too many open event streams. Please try again later.")
 			return
 		}
+		defer deps.Connections.Release(state.ID)
 
-		evts := make(chan sse.Event)
+		// Event stream is long-lived connection, so it has to be exempted
+		// from server read and write timeouts. Errors are ignored on
+		// purpose: writers without deadlines support have nothing to reset.
+		rc := http.NewResponseController(w)
+		_ = rc.SetReadDeadline(time.Time{})
+		_ = rc.SetWriteDeadline(time.Time{})
+
+		evts := make(chan sse.Event, deps.BufferSize)
 		unsubscribe := deps.Subscribe(ctx, MessageSubscribeRequest{
-			ID:        state.ID,
-			RequestID: middleware.GetReqID(ctx),
-			Channel:   evts,
+			ID:          state.ID,
+			Nickname:    state.Nickname,
+			ChatChannel: channel,
+			RequestID:   middleware.GetReqID(ctx),
+			Channel:     evts,
 		})
+		if unsubscribe == nil {
+			// Notifier has failed to subscribe the client, so no
+			// event would ever be sent to the stream.
+			writeError(w, r, http.StatusInternalServerError, ErrorReasonInternal, "Failed to subscribe to event stream. Please try again later.")
+			return
+		}
 		defer unsubscribe()
 
+		deps.Metrics.connectionOpened()
+		defer deps.Metrics.connectionClosed()
+
+		deps.Shutdown.streamOpened()
+		defer deps.Shutdown.streamClosed()
+
+		// Heartbeat channel stays nil when heartbeats are disabled,
+		// so it never fires in the select below.
+		var heartbeat <-chan time.Time
+		var ticker *time.Ticker
+		if deps.HeartbeatInterval > 0 {
+			ticker = time.NewTicker(deps.HeartbeatInterval)
+			defer ticker.Stop()
+			heartbeat = ticker.C
+		}
+
+		// Presence channel stays nil when presence heartbeats are
+		// disabled, just like heartbeat channel.
+		var presence <-chan time.Time
+		if deps.Presence != nil && deps.PresenceInterval > 0 {
+			presenceTicker := time.NewTicker(deps.PresenceInterval)
+			defer presenceTicker.Stop()
+			presence = presenceTicker.C
+		}
+
+		// Reconnection time is sent only once per connection.
+		retry := deps.ReconnectTime.Milliseconds()
+
 		for {
 			select {
-			case evt := <-evts:
+			case evt, ok := <-evts:
+				// Closed channel means that client has been
+				// disconnected by the server.
+				if !ok {
+					return
+				}
+
+				if retry != 0 {
+					evt.Retry = retry
+					retry = 0
+				}
+
 				if err := sse.Encode(w, evt); err != nil {
-					jsonResponse(w, http.StatusInternalServerError, responseWrapper{
-						Error: errorResponse{
-							Code:    http.StatusInternalServerError,
-							Message: "Failed to encode event stream message.",
-						},
-					})
+					writeError(w, r, http.StatusInternalServerError, ErrorReasonInternal, "Failed to encode event stream message.")
 					return
 				}
 
 				// Flush the data immediatly instead of buffering it for later.
 				flusher.Flush()
+
+				// Real event has been sent, so there is no need for
+				// heartbeat until next period of inactivity.
+				if ticker != nil {
+					ticker.Reset(deps.HeartbeatInterval)
+				}
+			case <-heartbeat:
+				if err := sse.EncodeComment(w, heartbeatComment); err != nil {
+					return
+				}
+				flusher.Flush()
+			case <-presence:
+				// User join event is processed asynchronously, so
+				// user can be missing from state for a while.
+				_ = deps.Presence.Heartbeat(ctx, state.ID, deps.Now())
+			case <-deps.Shutdown.Done():
+				// Client is told to reconnect, so it doesn't treat
+				// closed connection as failure.
+				data, err := json.Marshal(EventServerShutdown{
+					Retry: deps.ReconnectTime.Milliseconds(),
+				})
+				if err != nil {
+					return
+				}
+
+				if err := sse.Encode(w, sse.Event{
+					Type:  StreamShutdownEvent,
+					Data:  data,
+					Retry: deps.ReconnectTime.Milliseconds(),
+				}); err != nil {
+					return
+				}
+				flusher.Flush()
+				return
 			case <-r.Context().Done():
 				return
 			}
@@ -250,8 +693,26 @@ func HandlerStream(deps HandlerStreamDependencies) http.HandlerFunc {
 // HandlerLoginDependencies holds behavioral dependencies for
 // http handler for sending messages.
 type HandlerSendMessageDependencies struct {
-	MaxMessageSize int
+	MaxMessageSize *MessageSizeLimit
 	Sender         *BridgeEventProducer[EventSentMessage]
+
+	// Uploads resolve attachments of messages. Messages can't have
+	// attachments when it's nil.
+	Uploads UploadStore
+
+	// Mutes hold users, who can't send messages. Nobody is muted
+	// when it's nil.
+	Mutes  MuteStore
+	Logger *logrus.Logger
+
+	// SlowMode holds slow mode intervals of chat channels. There is
+	// no slow mode when it's nil.
+	SlowMode SlowModeStore
+
+	// Filters transform messages before they're sent. They run in
+	// order and any of them can reject the message.
+	Filters []MessageFilter
+
 	IDGenerator
 	Clock
 }
@@ -259,15 +720,24 @@ type HandlerSendMessageDependencies struct {
 // HandlerSendMessage handles sending message to all current listeners.
 func HandlerSendMessage(deps HandlerSendMessageDependencies) http.HandlerFunc {
 	type request struct {
-		Content string `json:"content"`
+		Content     string   `json:"content"`
+		ClientMsgID string   `json:"clientMsgId"`
+		Attachments []string `json:"attachments"`
 	}
 	type response struct {
-		ID string `json:"id"`
+		ID          string `json:"id"`
+		ClientMsgID string `json:"clientMsgId,omitempty"`
 	}
 
 	verify := func(r *request) error {
-		if len([]rune(r.Content)) > deps.MaxMessageSize {
-			return fmt.Errorf("maximum message length has been exceeded")
+		if len(r.ClientMsgID) > clientMsgIDMaxLength {
+			return fmt.Errorf("client message ID cannot be longer than %d bytes", clientMsgIDMaxLength)
+		}
+		if len(r.Attachments) > 0 && deps.Uploads == nil {
+			return fmt.Errorf("attachments are disabled")
+		}
+		if len(r.Attachments) > messageAttachmentsMax {
+			return fmt.Errorf("message cannot have more than %d attachments", messageAttachmentsMax)
 		}
 		return nil
 	}
@@ -276,12 +746,15 @@ func HandlerSendMessage(deps HandlerSendMessageDependencies) http.HandlerFunc {
 		ctx := r.Context()
 		state := SessionContextState(ctx)
 		if state == nil {
-			jsonResponse(w, http.StatusForbidden, responseWrapper{
-				Error: errorResponse{
-					Code:    http.StatusForbidden,
-					Message: "Sending messages requires authentication.",
-				},
-			})
+			writeError(w, r, http.StatusForbidden, ErrorReasonUnauthenticated, "Sending messages requires authentication.")
+			return
+		}
+		if muteRejected(w, r, deps.Logger, deps.Mutes, deps, state.ID) {
+			return
+		}
+		channel, ok := requestChatChannel(r)
+		if !ok {
+			invalidChatChannel(w, r)
 			return
 		}
 
@@ -289,86 +762,829 @@ func HandlerSendMessage(deps HandlerSendMessageDependencies) http.HandlerFunc {
 
 		defer r.Body.Close()
 		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
-			jsonResponse(w, http.StatusBadRequest, responseWrapper{
-				Error: errorResponse{
-					Code:    http.StatusBadRequest,
-					Message: "Failed to parse body.",
-				},
-			})
+			writeError(w, r, http.StatusBadRequest, ErrorReasonInvalidBody, "Failed to parse body.")
+			return
+		}
+
+		// Surrounding whitespace is meaningless for chat messages, but
+		// whitespace inside message is preserved.
+		req.Content = strings.TrimSpace(req.Content)
+		if req.Content == "" && len(req.Attachments) == 0 {
+			writeError(w, r, http.StatusBadRequest, ErrorReasonMessageEmpty, "Message cannot be empty.")
+			return
+		}
+
+		if len([]rune(req.Content)) > deps.MaxMessageSize.Size() {
+			writeError(w, r, http.StatusBadRequest, ErrorReasonMessageTooLong, "Invalid request body: maximum message length has been exceeded")
 			return
 		}
 
 		if err := verify(req); err != nil {
-			jsonResponse(w, http.StatusBadRequest, responseWrapper{
-				Error: errorResponse{
-					Code:    http.StatusBadRequest,
-					Message: fmt.Sprintf("Invalid request body: %s", err.Error()),
-				},
-			})
+			writeError(w, r, http.StatusBadRequest, ErrorReasonInvalidBody, fmt.Sprintf("Invalid request body: %s", err.Error()))
 			return
 		}
 
+		attachments := []Attachment{}
+		for _, id := range req.Attachments {
+			a, err := deps.Uploads.Upload(ctx, id)
+			if errors.Is(err, ErrNoSuchUpload) {
+				writeError(w, r, http.StatusBadRequest, ErrorReasonAttachmentNotFound, fmt.Sprintf("Attachment %s doesn't exist.", id))
+				return
+			}
+			if err != nil {
+				deps.Logger.WithFields(logrus.Fields{
+					"reqID": middleware.GetReqID(ctx),
+					"error": err.Error(),
+				}).Error("Failed to read attachment.")
+				writeError(w, r, http.StatusInternalServerError, ErrorReasonInternal, "Failed to read attachment.")
+				return
+			}
+			attachments = append(attachments, a)
+		}
+
 		messageID := deps.GenerateID()
-		go deps.Sender.SendEvent(ctx, messageID, EventSentMessage{
-			ID: messageID,
-			From: ChatUser{
-				ID:       state.ID,
-				Nickname: state.Nickname,
+		msg := EventSentMessage{
+			ID:          messageID,
+			From:        UserPresentation(state.ID, state.Nickname),
+			Channel:     channel,
+			Content:     req.Content,
+			SentAt:      deps.Now(),
+			ClientMsgID: req.ClientMsgID,
+		}
+		if len(attachments) > 0 {
+			msg.Attachments = attachments
+		}
+		if err := MessageFilterChain(deps.Filters).Transform(ctx, &msg); err != nil {
+			messageRejected(w, r, err)
+			return
+		}
+
+		if deps.SlowMode != nil {
+			retryAfter, err := deps.SlowMode.AllowMessage(ctx, msg.Channel, state.ID)
+			if err != nil {
+				deps.Logger.WithFields(logrus.Fields{
+					"reqID":   middleware.GetReqID(ctx),
+					"channel": msg.Channel,
+					"error":   err.Error(),
+				}).Error("Failed to check slow mode.")
+				writeError(w, r, http.StatusInternalServerError, ErrorReasonInternal, "Failed to check slow mode.")
+				return
+			}
+			if retryAfter > 0 {
+				slowModeLimited(w, r, retryAfter)
+				return
+			}
+		}
+
+		go deps.Sender.SendEvent(ctx, messageID, msg)
+
+		jsonResponse(w, http.StatusAccepted, responseWrapper{
+			Data: response{
+				ID:          messageID,
+				ClientMsgID: req.ClientMsgID,
 			},
-			Content: req.Content,
-			SentAt:  deps.Now(),
+		})
+	}
+}
+
+// HandlerTypingDependencies holds behavioral dependencies for
+// http handler for typing notifications.
+type HandlerTypingDependencies struct {
+	Sender *BridgeEventProducer[EventUserTyping]
+	IDGenerator
+	Clock
+}
+
+// HandlerTyping notifies all current listeners that user is typing
+// message. Typing events are ephemeral, so they're not stored.
+func HandlerTyping(deps HandlerTypingDependencies) http.HandlerFunc {
+	type response struct {
+		ID string `json:"id"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		state := SessionContextState(ctx)
+		if state == nil {
+			writeError(w, r, http.StatusForbidden, ErrorReasonUnauthenticated, "Sending typing notifications requires authentication.")
+			return
+		}
+
+		eventID := deps.GenerateID()
+		go deps.Sender.SendEvent(ctx, eventID, EventUserTyping{
+			ID:   eventID,
+			User: UserPresentation(state.ID, state.Nickname),
+			At:   deps.Now(),
 		})
 
 		jsonResponse(w, http.StatusAccepted, responseWrapper{
 			Data: response{
-				ID: messageID,
+				ID: eventID,
 			},
 		})
 	}
 }
 
-// OnlineChatUser holds information about single
-// user, which is currently using chat
-type OnlineChatUser struct {
-	ID       string `json:"id"`
-	Nickname string `json:"nickname"`
+// MessageStore finds messages sent to the chat.
+type MessageStore interface {
+	// Message returns message with given ID. It returns
+	// ErrNoSuchMessage if there is no such message.
+	Message(ctx context.Context, id string) (StateMessage, error)
 }
 
-// AllChatUsersStore stores information about current online users.
-type AllChatUsersStore interface {
+// HandlerEditMessageDependencies holds behavioral dependencies for
+// http handler for editing messages.
+type HandlerEditMessageDependencies struct {
+	MaxMessageSize *MessageSizeLimit
+	Sender         *BridgeEventProducer[EventMessageEdited]
+	Messages       MessageStore
 
-	// AllChatUsers returns all online users which are currently using chat.
-	AllChatUsers(ctx context.Context) ([]OnlineChatUser, error)
+	// Mutes hold users, who can't edit messages. Nobody is muted
+	// when it's nil.
+	Mutes  MuteStore
+	Logger *logrus.Logger
+
+	// Filters transform messages before they're sent. They run in
+	// order and any of them can reject the message.
+	Filters []MessageFilter
+
+	IDGenerator
+	Clock
 }
 
-// HandlerOnlineUsers sends list of online users, which are using chat.
-func HandlerOnlineUsers(log *logrus.Logger, store AllChatUsersStore) http.HandlerFunc {
+// HandlerEditMessage handles editing content of message with ID from
+// URL. Only author of message can edit it.
+func HandlerEditMessage(deps HandlerEditMessageDependencies) http.HandlerFunc {
+	type request struct {
+		Content string `json:"content"`
+	}
 	type response struct {
-		Users []OnlineChatUser `json:"users"`
+		ID string `json:"id"`
 	}
+
 	return func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
-		log := log.WithFields(logrus.Fields{
-			"reqID": middleware.GetReqID(ctx),
-		})
-
-		users, err := store.AllChatUsers(ctx)
-		if err != nil {
-			log.WithFields(logrus.Fields{
-				"error": err.Error(),
-			}).Error("Failed to retrieve online users.")
-			jsonResponse(w, http.StatusInternalServerError, responseWrapper{
-				Error: errorResponse{
-					Code:    http.StatusInternalServerError,
-					Message: "Failed to retrieve users list. Please try again later.",
-				},
-			})
+		state := SessionContextState(ctx)
+		if state == nil {
+			writeError(w, r, http.StatusForbidden, ErrorReasonUnauthenticated, "Editing messages requires authentication.")
+			return
+		}
+		if muteRejected(w, r, deps.Logger, deps.Mutes, deps, state.ID) {
 			return
 		}
 
-		jsonResponse(w, http.StatusOK, responseWrapper{
-			Data: response{
-				Users: users,
+		req := &request{}
+
+		defer r.Body.Close()
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			writeError(w, r, http.StatusBadRequest, ErrorReasonInvalidBody, "Failed to parse body.")
+			return
+		}
+
+		req.Content = strings.TrimSpace(req.Content)
+		if req.Content == "" {
+			writeError(w, r, http.StatusBadRequest, ErrorReasonMessageEmpty, "Message cannot be empty.")
+			return
+		}
+
+		if len([]rune(req.Content)) > deps.MaxMessageSize.Size() {
+			writeError(w, r, http.StatusBadRequest, ErrorReasonMessageTooLong, "Invalid request body: maximum message length has been exceeded")
+			return
+		}
+
+		msg, err := deps.Messages.Message(ctx, chi.URLParam(r, "id"))
+		if errors.Is(err, ErrNoSuchMessage) {
+			writeError(w, r, http.StatusNotFound, ErrorReasonMessageNotFound, "There is no such message.")
+			return
+		}
+		if err != nil {
+			writeError(w, r, http.StatusInternalServerError, ErrorReasonInternal, "Failed to find message. Please try again later.")
+			return
+		}
+
+		if msg.From.ID != state.ID {
+			writeError(w, r, http.StatusForbidden, ErrorReasonNotAuthor, "Only author can edit message.")
+			return
+		}
+
+		// Edited content goes through the same filters as content
+		// of sent messages.
+		edited := EventSentMessage{
+			ID:      msg.ID,
+			From:    msg.From,
+			Channel: msg.Channel,
+			To:      msg.To,
+			Content: req.Content,
+			SentAt:  msg.SentAt,
+		}
+		if err := MessageFilterChain(deps.Filters).Transform(ctx, &edited); err != nil {
+			messageRejected(w, r, err)
+			return
+		}
+
+		eventID := deps.GenerateID()
+		go deps.Sender.SendEvent(ctx, eventID, EventMessageEdited{
+			ID:        eventID,
+			MessageID: msg.ID,
+			Content:   edited.Content,
+			EditedAt:  deps.Now(),
+			From:      UserPresentation(state.ID, state.Nickname),
+			Channel:   msg.Channel,
+			To:        msg.To,
+			HTML:      edited.HTML,
+			Mentions:  edited.Mentions,
+		})
+
+		jsonResponse(w, http.StatusAccepted, responseWrapper{
+			Data: response{
+				ID: eventID,
+			},
+		})
+	}
+}
+
+// HandlerDeleteMessageDependencies holds behavioral dependencies for
+// http handler for deleting messages.
+type HandlerDeleteMessageDependencies struct {
+	Sender   *BridgeEventProducer[EventMessageDeleted]
+	Messages MessageStore
+	IDGenerator
+	Clock
+}
+
+// HandlerDeleteMessage handles deleting message with ID from URL. Only
+// author of message can delete it.
+func HandlerDeleteMessage(deps HandlerDeleteMessageDependencies) http.HandlerFunc {
+	type response struct {
+		ID string `json:"id"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		state := SessionContextState(ctx)
+		if state == nil {
+			writeError(w, r, http.StatusForbidden, ErrorReasonUnauthenticated, "Deleting messages requires authentication.")
+			return
+		}
+
+		msg, err := deps.Messages.Message(ctx, chi.URLParam(r, "id"))
+		if errors.Is(err, ErrNoSuchMessage) {
+			writeError(w, r, http.StatusNotFound, ErrorReasonMessageNotFound, "There is no such message.")
+			return
+		}
+		if err != nil {
+			writeError(w, r, http.StatusInternalServerError, ErrorReasonInternal, "Failed to find message. Please try again later.")
+			return
+		}
+
+		if msg.From.ID != state.ID {
+			writeError(w, r, http.StatusForbidden, ErrorReasonNotAuthor, "Only author can delete message.")
+			return
+		}
+
+		eventID := deps.GenerateID()
+		go deps.Sender.SendEvent(ctx, eventID, EventMessageDeleted{
+			ID:        eventID,
+			MessageID: msg.ID,
+			DeletedAt: deps.Now(),
+			By:        UserPresentation(state.ID, state.Nickname),
+			From:      msg.From,
+			Channel:   msg.Channel,
+			To:        msg.To,
+		})
+
+		jsonResponse(w, http.StatusAccepted, responseWrapper{
+			Data: response{
+				ID: eventID,
+			},
+		})
+	}
+}
+
+// MessageHistory stores archived messages.
+type MessageHistory interface {
+	// MessagesBefore returns at most limit of message-sent events from
+	// given chat channel and direct messages, which user with given ID
+	// sent or received, which were sent before event with given ID, in
+	// reverse order of their sequence numbers. Empty before ID means
+	// that the newest messages are returned. Deleted messages are
+	// skipped and edited ones have their current content.
+	MessagesBefore(ctx context.Context, userID, channel, before string, limit int) ([]BridgeEvent, error)
+}
+
+// Limits of single message history page.
+const (
+	historyLimitDefault = 50
+	historyLimitMax     = 100
+)
+
+// HandlerHistoryDependencies holds behavioral dependencies for
+// http handler for message history.
+type HandlerHistoryDependencies struct {
+	Logger  *logrus.Logger
+	History MessageHistory
+}
+
+// HandlerHistory sends page of archived messages from chat channel,
+// together with direct messages of current user, in reverse
+// chronological order. Pages are selected with cursor, which is ID of
+// the oldest message from previous page.
+func HandlerHistory(deps HandlerHistoryDependencies) http.HandlerFunc {
+	type response struct {
+		Messages   []EventSentMessage `json:"messages"`
+		NextCursor string             `json:"nextCursor"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		state := SessionContextState(ctx)
+		if state == nil {
+			writeError(w, r, http.StatusForbidden, ErrorReasonUnauthenticated, "Message history requires authentication.")
+			return
+		}
+
+		log := deps.Logger.WithFields(logrus.Fields{
+			"reqID": middleware.GetReqID(ctx),
+		})
+
+		channel, ok := requestChatChannel(r)
+		if !ok {
+			invalidChatChannel(w, r)
+			return
+		}
+
+		query := r.URL.Query()
+		limit := historyLimitDefault
+		if l := query.Get("limit"); l != "" {
+			parsed, err := strconv.Atoi(l)
+			if err != nil || parsed < 1 || parsed > historyLimitMax {
+				writeError(w, r, http.StatusBadRequest, ErrorReasonInvalidParam, fmt.Sprintf("Limit must be a number between 1 and %d.", historyLimitMax))
+				return
+			}
+			limit = parsed
+		}
+
+		evts, err := deps.History.MessagesBefore(ctx, state.ID, channel, query.Get("before"), limit)
+		if err != nil {
+			log.WithFields(logrus.Fields{
+				"error": err.Error(),
+			}).Error("Failed to retrieve message history.")
+			writeError(w, r, http.StatusInternalServerError, ErrorReasonInternal, "Failed to retrieve message history. Please try again later.")
+			return
+		}
+
+		res := response{}
+		if len(evts) == limit {
+			res.NextCursor = evts[len(evts)-1].ID
+		}
+
+		res.Messages = archivedMessages(log, evts, func(msg EventSentMessage) bool {
+			if msg.To != nil {
+				return msg.From.ID == state.ID || msg.To.ID == state.ID
+			}
+			return ChatChannelOrDefault(msg.Channel) == channel
+		})
+
+		jsonResponse(w, http.StatusOK, responseWrapper{
+			Data: res,
+		})
+	}
+}
+
+// archivedMessages decodes archived message-sent events and returns
+// messages accepted by visible predicate.
+func archivedMessages(
+	log logrus.FieldLogger,
+	evts []BridgeEvent,
+	visible func(EventSentMessage) bool,
+) []EventSentMessage {
+	res := []EventSentMessage{}
+	for _, evt := range evts {
+		msg := EventSentMessage{}
+		if err := json.Unmarshal(evt.Data, &msg); err != nil {
+			log.WithFields(logrus.Fields{
+				"eventID": evt.ID,
+				"error":   err.Error(),
+			}).Error("Failed to unmarshal EventSentMessage data.")
+			continue
+		}
+
+		if !visible(msg) {
+			continue
+		}
+		msg.Sequence = evt.Sequence
+
+		res = append(res, msg)
+	}
+
+	return res
+}
+
+// MessageSearch finds archived messages by their content.
+type MessageSearch interface {
+	// SearchMessages returns at most limit of message-sent events, which
+	// current content matches given query, newest first. Deleted
+	// messages and direct messages, which user with given ID neither
+	// sent nor received, are skipped before the limit is applied.
+	// Edited messages have their current content.
+	SearchMessages(ctx context.Context, userID, query string, limit int) ([]BridgeEvent, error)
+}
+
+// HandlerSearchDependencies holds behavioral dependencies for
+// http handler for message search.
+type HandlerSearchDependencies struct {
+	Logger *logrus.Logger
+	Search MessageSearch
+}
+
+// HandlerSearch sends archived messages matching query from q param,
+// newest first. Messages from all chat channels are searched, but
+// direct messages are returned only to their author and recipient.
+func HandlerSearch(deps HandlerSearchDependencies) http.HandlerFunc {
+	type response struct {
+		Messages []EventSentMessage `json:"messages"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		state := SessionContextState(ctx)
+		if state == nil {
+			writeError(w, r, http.StatusForbidden, ErrorReasonUnauthenticated, "Message search requires authentication.")
+			return
+		}
+
+		log := deps.Logger.WithFields(logrus.Fields{
+			"reqID": middleware.GetReqID(ctx),
+		})
+
+		query := r.URL.Query()
+		q := strings.TrimSpace(query.Get("q"))
+		if q == "" {
+			writeError(w, r, http.StatusBadRequest, ErrorReasonInvalidParam, "Search query cannot be empty.")
+			return
+		}
+
+		limit := historyLimitDefault
+		if l := query.Get("limit"); l != "" {
+			parsed, err := strconv.Atoi(l)
+			if err != nil || parsed < 1 || parsed > historyLimitMax {
+				writeError(w, r, http.StatusBadRequest, ErrorReasonInvalidParam, fmt.Sprintf("Limit must be a number between 1 and %d.", historyLimitMax))
+				return
+			}
+			limit = parsed
+		}
+
+		evts, err := deps.Search.SearchMessages(ctx, state.ID, q, limit)
+		if err != nil {
+			log.WithFields(logrus.Fields{
+				"error": err.Error(),
+			}).Error("Failed to search messages.")
+			writeError(w, r, http.StatusInternalServerError, ErrorReasonInternal, "Failed to search messages. Please try again later.")
+			return
+		}
+
+		messages := archivedMessages(log, evts, func(msg EventSentMessage) bool {
+			return msg.To == nil || msg.From.ID == state.ID || msg.To.ID == state.ID
+		})
+
+		jsonResponse(w, http.StatusOK, responseWrapper{
+			Data: response{
+				Messages: messages,
+			},
+		})
+	}
+}
+
+// ChatUserStore finds users which are currently using chat.
+type ChatUserStore interface {
+	// ChatUser returns online user with given ID. It returns
+	// ErrNoSuchUser if there is no such user.
+	ChatUser(ctx context.Context, id string) (OnlineChatUser, error)
+}
+
+// HandlerDirectMessageDependencies holds behavioral dependencies for
+// http handler for sending direct messages.
+type HandlerDirectMessageDependencies struct {
+	MaxMessageSize *MessageSizeLimit
+	Sender         *BridgeEventProducer[EventSentMessage]
+	Users          ChatUserStore
+
+	// Mutes hold users, who can't send messages. Nobody is muted
+	// when it's nil.
+	Mutes  MuteStore
+	Logger *logrus.Logger
+
+	// Filters transform messages before they're sent. They run in
+	// order and any of them can reject the message.
+	Filters []MessageFilter
+
+	IDGenerator
+	Clock
+}
+
+// HandlerDirectMessage handles sending message to single online user.
+func HandlerDirectMessage(deps HandlerDirectMessageDependencies) http.HandlerFunc {
+	type request struct {
+		To          string `json:"to"`
+		Content     string `json:"content"`
+		ClientMsgID string `json:"clientMsgId"`
+	}
+	type response struct {
+		ID          string `json:"id"`
+		ClientMsgID string `json:"clientMsgId,omitempty"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		state := SessionContextState(ctx)
+		if state == nil {
+			writeError(w, r, http.StatusForbidden, ErrorReasonUnauthenticated, "Sending messages requires authentication.")
+			return
+		}
+		if muteRejected(w, r, deps.Logger, deps.Mutes, deps, state.ID) {
+			return
+		}
+
+		req := &request{}
+
+		defer r.Body.Close()
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			writeError(w, r, http.StatusBadRequest, ErrorReasonInvalidBody, "Failed to parse body.")
+			return
+		}
+
+		req.Content = strings.TrimSpace(req.Content)
+		if req.Content == "" {
+			writeError(w, r, http.StatusBadRequest, ErrorReasonMessageEmpty, "Message cannot be empty.")
+			return
+		}
+
+		if len([]rune(req.Content)) > deps.MaxMessageSize.Size() {
+			writeError(w, r, http.StatusBadRequest, ErrorReasonMessageTooLong, "Invalid request body: maximum message length has been exceeded")
+			return
+		}
+
+		if len(req.ClientMsgID) > clientMsgIDMaxLength {
+			writeError(w, r, http.StatusBadRequest, ErrorReasonInvalidBody, fmt.Sprintf(
+				"Invalid request body: client message ID cannot be longer than %d bytes", clientMsgIDMaxLength,
+			))
+			return
+		}
+
+		recipient, err := deps.Users.ChatUser(ctx, req.To)
+		if errors.Is(err, ErrNoSuchUser) {
+			writeError(w, r, http.StatusNotFound, ErrorReasonUserOffline, "Recipient is not online.")
+			return
+		}
+		if err != nil {
+			writeError(w, r, http.StatusInternalServerError, ErrorReasonInternal, "Failed to find recipient. Please try again later.")
+			return
+		}
+
+		to := UserPresentation(recipient.ID, recipient.Nickname)
+		messageID := deps.GenerateID()
+		msg := EventSentMessage{
+			ID:          messageID,
+			From:        UserPresentation(state.ID, state.Nickname),
+			To:          &to,
+			Content:     req.Content,
+			SentAt:      deps.Now(),
+			ClientMsgID: req.ClientMsgID,
+		}
+		if err := MessageFilterChain(deps.Filters).Transform(ctx, &msg); err != nil {
+			messageRejected(w, r, err)
+			return
+		}
+
+		go deps.Sender.SendEvent(ctx, messageID, msg)
+
+		jsonResponse(w, http.StatusAccepted, responseWrapper{
+			Data: response{
+				ID:          messageID,
+				ClientMsgID: req.ClientMsgID,
+			},
+		})
+	}
+}
+
+// OnlineChatUser holds information about single
+// user, which is currently using chat
+type OnlineChatUser struct {
+	ID       string `json:"id"`
+	Nickname string `json:"nickname"`
+	Status   string `json:"status,omitempty"`
+}
+
+// AllChatUsersStore stores information about current online users.
+type AllChatUsersStore interface {
+
+	// AllChatUsers returns all online users which are currently using chat.
+	AllChatUsers(ctx context.Context) ([]OnlineChatUser, error)
+
+	// ChatUsers returns online users matching given options, sorted
+	// by their nicknames.
+	ChatUsers(ctx context.Context, opts ChatUsersOptions) ([]OnlineChatUser, error)
+}
+
+// HandlerOnlineUsers sends list of online users, which are using chat.
+// List can be filtered by nickname prefix and paged with limit and
+// offset query params.
+func HandlerOnlineUsers(log *logrus.Logger, store AllChatUsersStore) http.HandlerFunc {
+	type response struct {
+		Users []OnlineChatUser `json:"users"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		log := log.WithFields(logrus.Fields{
+			"reqID": middleware.GetReqID(ctx),
+		})
+
+		query := r.URL.Query()
+		opts := ChatUsersOptions{
+			Prefix: query.Get("prefix"),
+		}
+		for _, param := range []struct {
+			name string
+			dst  *int
+		}{
+			{name: "limit", dst: &opts.Limit},
+			{name: "offset", dst: &opts.Offset},
+		} {
+			val := query.Get(param.name)
+			if val == "" {
+				continue
+			}
+
+			parsed, err := strconv.Atoi(val)
+			if err != nil || parsed < 0 {
+				writeError(w, r, http.StatusBadRequest, ErrorReasonInvalidParam, fmt.Sprintf("Param %s must be non-negative number.", param.name))
+				return
+			}
+			*param.dst = parsed
+		}
+
+		users, err := store.ChatUsers(ctx, opts)
+		if err != nil {
+			log.WithFields(logrus.Fields{
+				"error": err.Error(),
+			}).Error("Failed to retrieve online users.")
+			writeError(w, r, http.StatusInternalServerError, ErrorReasonInternal, "Failed to retrieve users list. Please try again later.")
+			return
+		}
+
+		jsonResponse(w, http.StatusOK, responseWrapper{
+			Data: response{
+				Users: users,
+			},
+		})
+	}
+}
+
+// ChatUsersCounter counts users which are currently using chat.
+type ChatUsersCounter interface {
+	// Count returns number of online users.
+	Count(ctx context.Context) int
+}
+
+// HandlerOnlineUsersCount sends number of online users.
+func HandlerOnlineUsersCount(counter ChatUsersCounter) http.HandlerFunc {
+	type response struct {
+		Count int `json:"count"`
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		jsonResponse(w, http.StatusOK, responseWrapper{
+			Data: response{
+				Count: counter.Count(r.Context()),
+			},
+		})
+	}
+}
+
+// HandlerHealth is liveness probe handler. It responds with 200
+// status code as long as the process is able to serve requests.
+func HandlerHealth() http.HandlerFunc {
+	type response struct {
+		Status string `json:"status"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		jsonResponse(w, http.StatusOK, responseWrapper{
+			Data: response{
+				Status: "ok",
+			},
+		})
+	}
+}
+
+// BuildInfoDefaultVal is used for fields of build information, which
+// haven't been injected at build time.
+const BuildInfoDefaultVal = "dev"
+
+// BuildInfo describes running build of szmaterlok. Its values are
+// usually injected with -ldflags -X at build time.
+type BuildInfo struct {
+	Version string `json:"version"`
+	Commit  string `json:"commit"`
+	Date    string `json:"date"`
+}
+
+// WithDefaults returns copy of build info with empty fields set to
+// BuildInfoDefaultVal.
+func (b BuildInfo) WithDefaults() BuildInfo {
+	for _, f := range []*string{&b.Version, &b.Commit, &b.Date} {
+		if *f == "" {
+			*f = BuildInfoDefaultVal
+		}
+	}
+	return b
+}
+
+// String returns build info in human readable form.
+func (b BuildInfo) String() string {
+	b = b.WithDefaults()
+	return fmt.Sprintf("szmaterlok %s (commit %s, built %s)", b.Version, b.Commit, b.Date)
+}
+
+// HandlerVersion responds with build information of running
+// szmaterlok instance.
+func HandlerVersion(info BuildInfo) http.HandlerFunc {
+	info = info.WithDefaults()
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		jsonResponse(w, http.StatusOK, responseWrapper{
+			Data: info,
+		})
+	}
+}
+
+// Pinger checks connection with external dependency.
+type Pinger interface {
+	// Ping returns error when dependency cannot be reached.
+	Ping(ctx context.Context) error
+}
+
+// PingerFunc is functional interface of Pinger.
+type PingerFunc func(ctx context.Context) error
+
+func (f PingerFunc) Ping(ctx context.Context) error {
+	return f(ctx)
+}
+
+// HandlerReadyDependencies holds behavioral dependencies for
+// readiness probe handler.
+type HandlerReadyDependencies struct {
+	Logger  *logrus.Logger
+	Storage Pinger
+	Bridge  *Bridge
+}
+
+// HandlerReady is readiness probe handler. It checks connection with
+// event storage and event bridge loop. When any of them fails, it
+// responds with 503 status code and list of failed dependencies.
+func HandlerReady(deps HandlerReadyDependencies) http.HandlerFunc {
+	type response struct {
+		Status string `json:"status"`
+	}
+	type readyErrorResponse struct {
+		errorResponse
+		Failed []string `json:"failed"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		log := deps.Logger.WithFields(logrus.Fields{
+			"reqID": middleware.GetReqID(ctx),
+		})
+
+		failed := []string{}
+		if err := deps.Storage.Ping(ctx); err != nil {
+			log.WithFields(logrus.Fields{
+				"error": err.Error(),
+			}).Error("Storage is not ready.")
+			failed = append(failed, "storage")
+		}
+		if !deps.Bridge.Alive() {
+			log.Error("Event bridge is not running.")
+			failed = append(failed, "bridge")
+		}
+
+		if len(failed) > 0 {
+			jsonResponse(w, http.StatusServiceUnavailable, responseWrapper{
+				Error: readyErrorResponse{
+					errorResponse: errorResponse{
+						Code:    http.StatusServiceUnavailable,
+						Reason:  ErrorReasonUnavailable,
+						Message: "Service is not ready.",
+					},
+					Failed: failed,
+				},
+			})
+			return
+		}
+
+		jsonResponse(w, http.StatusOK, responseWrapper{
+			Data: response{
+				Status: "ok",
 			},
 		})
 	}
@@ -0,0 +1,1753 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/matryer/is"
+
+	"github.com/fenole/szmaterlok/service/sse"
+)
+
+// streamRecorder is concurrent-safe http.ResponseWriter, which
+// can be observed while event stream handler is still running.
+type streamRecorder struct {
+	mtx    *sync.Mutex
+	header http.Header
+	code   int
+	buff   *bytes.Buffer
+}
+
+func newStreamRecorder() *streamRecorder {
+	return &streamRecorder{
+		mtx:    &sync.Mutex{},
+		header: http.Header{},
+		code:   http.StatusOK,
+		buff:   &bytes.Buffer{},
+	}
+}
+
+func (sr *streamRecorder) Header() http.Header {
+	return sr.header
+}
+
+func (sr *streamRecorder) Write(b []byte) (int, error) {
+	sr.mtx.Lock()
+	defer sr.mtx.Unlock()
+	return sr.buff.Write(b)
+}
+
+func (sr *streamRecorder) WriteHeader(code int) {
+	sr.mtx.Lock()
+	defer sr.mtx.Unlock()
+	sr.code = code
+}
+
+func (sr *streamRecorder) Flush() {}
+
+func (sr *streamRecorder) String() string {
+	sr.mtx.Lock()
+	defer sr.mtx.Unlock()
+	return sr.buff.String()
+}
+
+// waitFor polls given condition until it's true or timeout passes.
+func waitFor(t *testing.T, timeout time.Duration, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition has not been met before timeout")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// requestWithSession returns http request with given session state
+// saved within its context.
+func requestWithSession(ctx context.Context, r *http.Request, s *SessionState) *http.Request {
+	return r.WithContext(context.WithValue(ctx, sessionStateKey, s))
+}
+
+func TestHandlerStream(t *testing.T) {
+	t.Run("heartbeat", func(t *testing.T) {
+		is := is.New(t)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		r := requestWithSession(ctx, httptest.NewRequest(http.MethodGet, "/stream", nil), &SessionState{
+			ID:       "id",
+			Nickname: "nickname",
+		})
+		w := newStreamRecorder()
+
+		h := HandlerStream(HandlerStreamDependencies{
+			HeartbeatInterval: time.Millisecond * 5,
+			MessageNotifier: MessageNotifierFunc(func(context.Context, MessageSubscribeRequest) func() {
+				return func() {}
+			}),
+		})
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			h(w, r)
+		}()
+
+		waitFor(t, time.Second, func() bool {
+			return strings.Count(w.String(), heartbeatComment) >= 2
+		})
+
+		cancel()
+		<-done
+
+		got := w.String()
+		heartbeats := strings.Count(got, heartbeatComment)
+		is.Equal(got, strings.Repeat(": keep-alive\n\n", heartbeats))
+
+		// Heartbeats should stop after client disconnection.
+		time.Sleep(time.Millisecond * 20)
+		is.Equal(w.String(), got)
+	})
+
+	t.Run("without session", func(t *testing.T) {
+		is := is.New(t)
+		ctx := context.Background()
+		log := testLogger()
+
+		storage := newBridgeStorageMock()
+		bridge := NewBridge(ctx, BridgeBuilder{
+			Logger:  log,
+			Storage: storage,
+			Persist: func(BridgeEventType) bool { return true },
+		})
+
+		subscribed := false
+		announcer := &EventAnnouncer{
+			MessageNotifier: MessageNotifierFunc(func(context.Context, MessageSubscribeRequest) func() {
+				subscribed = true
+				return func() {}
+			}),
+			UserJoinProducer: &BridgeEventProducer[EventUserJoin]{
+				EventBridge: bridge,
+				Type:        BridgeUserJoin,
+				Log:         log,
+				Clock:       testClock(),
+			},
+			UserLeftProducer: &BridgeEventProducer[EventUserLeft]{
+				EventBridge: bridge,
+				Type:        BridgeUserLeft,
+				Log:         log,
+				Clock:       testClock(),
+			},
+			Clock:       testClock(),
+			IDGenerator: testIDGenerator(),
+		}
+
+		// Announcer never returns nil unsubscribe func.
+		unsubscribe := announcer.Subscribe(ctx, MessageSubscribeRequest{
+			ID:      "id",
+			Channel: make(chan sse.Event),
+		})
+		is.True(unsubscribe != nil)
+		unsubscribe()
+
+		w := newStreamRecorder()
+		HandlerStream(HandlerStreamDependencies{
+			MessageNotifier: announcer,
+		})(w, httptest.NewRequest(http.MethodGet, "/stream", nil))
+		is.Equal(w.code, http.StatusForbidden)
+
+		bridge.Shutdown(ctx)
+		is.True(!subscribed)
+		is.Equal(len(storage.Events()), 0) // nothing is announced
+	})
+
+	t.Run("nil unsubscribe", func(t *testing.T) {
+		is := is.New(t)
+
+		r := requestWithSession(context.Background(), httptest.NewRequest(http.MethodGet, "/stream", nil), &SessionState{
+			ID:       "id",
+			Nickname: "nickname",
+		})
+		w := newStreamRecorder()
+
+		HandlerStream(HandlerStreamDependencies{
+			MessageNotifier: MessageNotifierFunc(func(context.Context, MessageSubscribeRequest) func() {
+				return nil
+			}),
+		})(w, r)
+		is.Equal(w.code, http.StatusInternalServerError)
+	})
+}
+
+func TestHandlerStreamRetry(t *testing.T) {
+	is := is.New(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	r := requestWithSession(ctx, httptest.NewRequest(http.MethodGet, "/stream", nil), &SessionState{
+		ID:       "id",
+		Nickname: "nickname",
+	})
+	w := newStreamRecorder()
+
+	h := HandlerStream(HandlerStreamDependencies{
+		ReconnectTime: time.Second * 3,
+		MessageNotifier: MessageNotifierFunc(func(ctx context.Context, args MessageSubscribeRequest) func() {
+			go func() {
+				args.Channel <- sse.Event{Type: MessageSent, ID: "1", Data: []byte("first")}
+				args.Channel <- sse.Event{Type: MessageSent, ID: "2", Data: []byte("second")}
+			}()
+			return func() {}
+		}),
+	})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		h(w, r)
+	}()
+
+	waitFor(t, time.Second, func() bool {
+		return strings.Contains(w.String(), "second")
+	})
+
+	cancel()
+	<-done
+
+	want := "event: message-sent\nid: 1\nretry: 3000\ndata: first\n\n" +
+		"event: message-sent\nid: 2\ndata: second\n\n"
+	is.Equal(w.String(), want)
+}
+
+func TestHandlerSendMessage(t *testing.T) {
+	type testArgs struct {
+		name    string
+		content string
+		filters []MessageFilter
+		code    int
+		want    string
+	}
+
+	scenario := func(tt testArgs) (string, func(*testing.T)) {
+		return tt.name, func(t *testing.T) {
+			is := is.New(t)
+			ctx := context.Background()
+			log := testLogger()
+
+			storage := newBridgeStorageMock()
+			bridge := NewBridge(ctx, BridgeBuilder{
+				Logger:  log,
+				Storage: storage,
+			})
+
+			h := HandlerSendMessage(HandlerSendMessageDependencies{
+				MaxMessageSize: NewMessageSizeLimit(255),
+				Filters:        tt.filters,
+				Sender: &BridgeEventProducer[EventSentMessage]{
+					EventBridge: bridge,
+					Type:        BridgeMessageSent,
+					Log:         log,
+					Clock:       testClock(),
+				},
+				IDGenerator: testIDGenerator(),
+				Clock:       testClock(),
+			})
+
+			body, err := json.Marshal(map[string]string{"content": tt.content})
+			is.NoErr(err)
+
+			r := requestWithSession(ctx, httptest.NewRequest(
+				http.MethodPost, "/message", bytes.NewReader(body),
+			), &SessionState{
+				ID:       "id",
+				Nickname: "nickname",
+			})
+			w := httptest.NewRecorder()
+
+			h(w, r)
+			is.Equal(w.Code, tt.code)
+
+			if tt.code != http.StatusAccepted {
+				res := struct {
+					Error errorResponse `json:"error"`
+				}{}
+				is.NoErr(json.NewDecoder(w.Body).Decode(&res))
+				is.Equal(res.Error.Message, tt.want)
+
+				bridge.Shutdown(ctx)
+				is.Equal(len(storage.Events()), 0)
+				return
+			}
+
+			waitFor(t, time.Second, func() bool {
+				return len(storage.Events()) == 1
+			})
+			bridge.Shutdown(ctx)
+
+			msg := EventSentMessage{}
+			is.NoErr(json.Unmarshal(storage.Events()[0].Data, &msg))
+			is.Equal(msg.Content, tt.want)
+		}
+	}
+
+	t.Run(scenario(testArgs{
+		name:    "empty",
+		content: "",
+		code:    http.StatusBadRequest,
+		want:    "Message cannot be empty.",
+	}))
+	t.Run(scenario(testArgs{
+		name:    "spaces",
+		content: "   ",
+		code:    http.StatusBadRequest,
+		want:    "Message cannot be empty.",
+	}))
+	t.Run(scenario(testArgs{
+		name:    "tabs and newlines",
+		content: "\t\n\r\n\t",
+		code:    http.StatusBadRequest,
+		want:    "Message cannot be empty.",
+	}))
+	t.Run(scenario(testArgs{
+		name:    "non-breaking space",
+		content: "\u00a0 \u00a0",
+		code:    http.StatusBadRequest,
+		want:    "Message cannot be empty.",
+	}))
+	t.Run(scenario(testArgs{
+		name:    "internal whitespace preserved",
+		content: "\t hello \n  world ",
+		code:    http.StatusAccepted,
+		want:    "hello \n  world",
+	}))
+	t.Run(scenario(testArgs{
+		name:    "unfiltered by default",
+		content: "darn it",
+		code:    http.StatusAccepted,
+		want:    "darn it",
+	}))
+	t.Run(scenario(testArgs{
+		name:    "filtered",
+		content: "Darn it",
+		filters: []MessageFilter{NewWordFilter([]string{"darn"})},
+		code:    http.StatusAccepted,
+		want:    "**** it",
+	}))
+	t.Run(scenario(testArgs{
+		name:    "rejected by filter",
+		content: "Darn it",
+		filters: []MessageFilter{
+			NewWordFilter([]string{"darn"}),
+			MessageFilterFunc(func(ctx context.Context, msg *EventSentMessage) error {
+				if strings.Contains(msg.Content, "*") {
+					return errors.New("message is too rude")
+				}
+				return nil
+			}),
+		},
+		code: http.StatusBadRequest,
+		want: "Message has been rejected: message is too rude.",
+	}))
+	t.Run(scenario(testArgs{
+		name:    "failed filter",
+		content: "hey @karol",
+		filters: []MessageFilter{
+			MentionFilter{Users: allChatUsersFailingMock{}},
+		},
+		code: http.StatusInternalServerError,
+		want: "Failed to process message. Please try again later.",
+	}))
+}
+
+func TestHandlerSendMessageClientID(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+	log := testLogger()
+
+	messageHandler := NewBridgeMessageHandler(log)
+	router := NewBridgeEventRouter()
+	router.Hook(BridgeMessageSent, messageHandler)
+
+	bridge := NewBridge(ctx, BridgeBuilder{
+		Handler: router,
+		Logger:  log,
+		Storage: newBridgeStorageMock(),
+	})
+	defer bridge.Shutdown(ctx)
+
+	evts := make(chan sse.Event, 1)
+	unsubscribe := messageHandler.Subscribe(ctx, MessageSubscribeRequest{
+		ID:        "id",
+		RequestID: "req",
+		Channel:   evts,
+	})
+	defer unsubscribe()
+
+	h := HandlerSendMessage(HandlerSendMessageDependencies{
+		MaxMessageSize: NewMessageSizeLimit(255),
+		Sender: &BridgeEventProducer[EventSentMessage]{
+			EventBridge: bridge,
+			Type:        BridgeMessageSent,
+			Log:         log,
+			Clock:       testClock(),
+		},
+		IDGenerator: testIDGenerator(),
+		Clock:       testClock(),
+	})
+
+	send := func(body map[string]string) *httptest.ResponseRecorder {
+		b, err := json.Marshal(body)
+		is.NoErr(err)
+
+		r := requestWithSession(ctx, httptest.NewRequest(
+			http.MethodPost, "/message", bytes.NewReader(b),
+		), &SessionState{
+			ID:       "id",
+			Nickname: "nickname",
+		})
+		w := httptest.NewRecorder()
+		h(w, r)
+		return w
+	}
+
+	w := send(map[string]string{"content": "hello", "clientMsgId": "optimistic-1"})
+	is.Equal(w.Code, http.StatusAccepted)
+
+	res := struct {
+		Data struct {
+			ID          string `json:"id"`
+			ClientMsgID string `json:"clientMsgId"`
+		} `json:"data"`
+	}{}
+	is.NoErr(json.NewDecoder(w.Body).Decode(&res))
+	is.Equal(res.Data.ClientMsgID, "optimistic-1")
+
+	select {
+	case evt := <-evts:
+		is.Equal(evt.Type, string(BridgeMessageSent))
+
+		msg := EventSentMessage{}
+		is.NoErr(json.Unmarshal(evt.Data, &msg))
+		is.Equal(msg.ID, res.Data.ID) // server assigns canonical ID
+		is.Equal(msg.ClientMsgID, "optimistic-1")
+	case <-time.After(time.Second):
+		t.Fatal("message event has not been delivered")
+	}
+
+	// Client message IDs are bounded.
+	w = send(map[string]string{"content": "hello", "clientMsgId": strings.Repeat("a", clientMsgIDMaxLength+1)})
+	is.Equal(w.Code, http.StatusBadRequest)
+}
+
+func TestNicknamePolicy(t *testing.T) {
+	type testArgs struct {
+		name     string
+		nickname string
+		want     error
+	}
+
+	policy := NicknamePolicy{
+		MinLength: 3,
+		MaxLength: 8,
+	}
+
+	scenario := func(tt testArgs) (string, func(*testing.T)) {
+		return tt.name, func(t *testing.T) {
+			is := is.New(t)
+
+			err := policy.ValidateNickname(tt.nickname)
+			is.True(errors.Is(err, tt.want))
+		}
+	}
+
+	t.Run(scenario(testArgs{
+		name:     "too short",
+		nickname: "ab",
+		want:     ErrNicknameTooShort,
+	}))
+	t.Run(scenario(testArgs{
+		name:     "too long",
+		nickname: "abcdefghi",
+		want:     ErrNicknameTooLong,
+	}))
+	t.Run(scenario(testArgs{
+		name:     "newline",
+		nickname: "ab\ncd",
+		want:     ErrNicknameControlChar,
+	}))
+	t.Run(scenario(testArgs{
+		name:     "null byte",
+		nickname: "ab\x00cd",
+		want:     ErrNicknameControlChar,
+	}))
+	t.Run(scenario(testArgs{
+		name:     "valid",
+		nickname: "karol",
+		want:     nil,
+	}))
+	t.Run(scenario(testArgs{
+		name:     "valid multibyte",
+		nickname: "żółćżółć",
+		want:     nil,
+	}))
+}
+
+func TestHandlerLogin(t *testing.T) {
+	type testArgs struct {
+		name     string
+		nickname string
+		code     int
+	}
+
+	scenario := func(tt testArgs) (string, func(*testing.T)) {
+		return tt.name, func(t *testing.T) {
+			is := is.New(t)
+
+			h := HandlerLogin(HandlerLoginDependencies{
+				StateFactory: DefaultSessionStateFactory(),
+				Logger:       testLogger(),
+				SessionStore: &SessionCookieStore{
+					ExpirationTime: time.Hour,
+					Tokenizer:      NewSessionSimpleTokenizer(),
+					Clock:          testClock(),
+				},
+				NicknamePolicy: NicknamePolicy{
+					MinLength: 3,
+					MaxLength: 8,
+				},
+			})
+
+			form := url.Values{"nickname": {tt.nickname}}
+			r := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(form.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			w := httptest.NewRecorder()
+
+			h(w, r)
+			is.Equal(w.Code, tt.code)
+		}
+	}
+
+	t.Run(scenario(testArgs{
+		name:     "too long",
+		nickname: strings.Repeat("a", 10000),
+		code:     http.StatusBadRequest,
+	}))
+	t.Run(scenario(testArgs{
+		name:     "control characters",
+		nickname: "ka\r\nrol",
+		code:     http.StatusBadRequest,
+	}))
+	t.Run(scenario(testArgs{
+		name:     "whitespace only",
+		nickname: "   ",
+		code:     http.StatusBadRequest,
+	}))
+	t.Run(scenario(testArgs{
+		name:     "surrounding whitespace",
+		nickname: "  karol  ",
+		code:     http.StatusSeeOther,
+	}))
+}
+
+func TestHandlerLoginNegotiatedError(t *testing.T) {
+	type testArgs struct {
+		name        string
+		accept      string
+		contentType string
+	}
+
+	scenario := func(tt testArgs) (string, func(*testing.T)) {
+		return tt.name, func(t *testing.T) {
+			is := is.New(t)
+
+			h := HandlerLogin(HandlerLoginDependencies{
+				StateFactory: DefaultSessionStateFactory(),
+				Logger:       testLogger(),
+				SessionStore: &SessionCookieStore{
+					ExpirationTime: time.Hour,
+					Tokenizer:      NewSessionSimpleTokenizer(),
+					Clock:          testClock(),
+				},
+				NicknamePolicy: NicknamePolicy{
+					MinLength: 3,
+					MaxLength: 8,
+				},
+			})
+
+			r := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader("nickname="))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			r.Header.Set("Accept", tt.accept)
+			w := httptest.NewRecorder()
+
+			h(w, r)
+			is.Equal(w.Code, http.StatusBadRequest)
+			is.True(strings.HasPrefix(w.Header().Get("Content-Type"), tt.contentType))
+		}
+	}
+
+	t.Run(scenario(testArgs{
+		name:        "form submitted by browser",
+		accept:      "text/html,application/xhtml+xml,*/*;q=0.8",
+		contentType: "text/plain",
+	}))
+	t.Run(scenario(testArgs{
+		name:        "api client",
+		accept:      "application/json",
+		contentType: "application/json",
+	}))
+}
+
+func TestAdminPolicy(t *testing.T) {
+	type testArgs struct {
+		name     string
+		policy   AdminPolicy
+		nickname string
+		token    string
+		want     bool
+	}
+
+	scenario := func(tt testArgs) (string, func(*testing.T)) {
+		return tt.name, func(t *testing.T) {
+			is := is.New(t)
+			is.Equal(tt.policy.IsAdmin(tt.nickname, tt.token), tt.want)
+		}
+	}
+
+	t.Run(scenario(testArgs{
+		name:     "listed nickname with token",
+		policy:   AdminPolicy{Nicknames: []string{"karol", "zenek"}, Token: "secret"},
+		nickname: "zenek",
+		token:    "secret",
+		want:     true,
+	}))
+	t.Run(scenario(testArgs{
+		name:     "listed nickname without token",
+		policy:   AdminPolicy{Nicknames: []string{"karol", "zenek"}, Token: "secret"},
+		nickname: "zenek",
+		want:     false,
+	}))
+	t.Run(scenario(testArgs{
+		name:     "listed nickname with token disabled",
+		policy:   AdminPolicy{Nicknames: []string{"karol"}},
+		nickname: "karol",
+		want:     false,
+	}))
+	t.Run(scenario(testArgs{
+		name:     "other nickname with token",
+		policy:   AdminPolicy{Nicknames: []string{"karol"}, Token: "secret"},
+		nickname: "Karol",
+		token:    "secret",
+		want:     false,
+	}))
+	t.Run(scenario(testArgs{
+		name:     "valid token",
+		policy:   AdminPolicy{Token: "secret"},
+		nickname: "karol",
+		token:    "secret",
+		want:     true,
+	}))
+	t.Run(scenario(testArgs{
+		name:     "invalid token",
+		policy:   AdminPolicy{Token: "secret"},
+		nickname: "karol",
+		token:    "public",
+		want:     false,
+	}))
+	t.Run(scenario(testArgs{
+		name:     "token disabled",
+		policy:   AdminPolicy{},
+		nickname: "karol",
+		token:    "",
+		want:     false,
+	}))
+}
+
+func TestHandlerTyping(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+	log := testLogger()
+
+	messageHandler := NewBridgeMessageHandler(log)
+	router := NewBridgeEventRouter()
+	router.Hook(BridgeUserTyping, messageHandler)
+
+	storage := newBridgeStorageMock()
+	bridge := NewBridge(ctx, BridgeBuilder{
+		Handler: router,
+		Logger:  log,
+		Storage: storage,
+	})
+
+	evts := make(chan sse.Event, 1)
+	unsubscribe := messageHandler.Subscribe(ctx, MessageSubscribeRequest{
+		ID:        "other",
+		RequestID: "req",
+		Channel:   evts,
+	})
+	defer unsubscribe()
+
+	h := HandlerTyping(HandlerTypingDependencies{
+		Sender: &BridgeEventProducer[EventUserTyping]{
+			EventBridge: bridge,
+			Type:        BridgeUserTyping,
+			Log:         log,
+			Clock:       testClock(),
+		},
+		IDGenerator: testIDGenerator(),
+		Clock:       testClock(),
+	})
+
+	r := requestWithSession(ctx, httptest.NewRequest(http.MethodPost, "/typing", nil), &SessionState{
+		ID:       "id",
+		Nickname: "nickname",
+	})
+	w := httptest.NewRecorder()
+
+	h(w, r)
+	is.Equal(w.Code, http.StatusAccepted)
+
+	select {
+	case evt := <-evts:
+		is.Equal(evt.Type, string(BridgeUserTyping))
+
+		data := EventUserTyping{}
+		is.NoErr(json.Unmarshal(evt.Data, &data))
+		is.Equal(data.User, UserPresentation("id", "nickname"))
+	case <-time.After(time.Second):
+		t.Fatal("typing event has not been delivered")
+	}
+
+	bridge.Shutdown(ctx)
+
+	// Typing events are ephemeral.
+	is.Equal(len(storage.Events()), 0)
+}
+
+func TestHandlerDirectMessage(t *testing.T) {
+	type testArgs struct {
+		name string
+		to   string
+		code int
+	}
+
+	scenario := func(tt testArgs) (string, func(*testing.T)) {
+		return tt.name, func(t *testing.T) {
+			is := is.New(t)
+			ctx := context.Background()
+			log := testLogger()
+
+			users := NewStateOnlineUsers()
+			is.NoErr(users.PushChatUser(ctx, StateChatUser{
+				ID:       "recipient",
+				Nickname: "recipient",
+			}))
+
+			storage := newBridgeStorageMock()
+			bridge := NewBridge(ctx, BridgeBuilder{
+				Logger:  log,
+				Storage: storage,
+			})
+
+			h := HandlerDirectMessage(HandlerDirectMessageDependencies{
+				MaxMessageSize: NewMessageSizeLimit(255),
+				Sender: &BridgeEventProducer[EventSentMessage]{
+					EventBridge: bridge,
+					Type:        BridgeMessageSent,
+					Log:         log,
+					Clock:       testClock(),
+				},
+				Users:       users,
+				IDGenerator: testIDGenerator(),
+				Clock:       testClock(),
+			})
+
+			body, err := json.Marshal(map[string]string{
+				"to":      tt.to,
+				"content": "psst",
+			})
+			is.NoErr(err)
+
+			r := requestWithSession(ctx, httptest.NewRequest(
+				http.MethodPost, "/dm", bytes.NewReader(body),
+			), &SessionState{
+				ID:       "author",
+				Nickname: "author",
+			})
+			w := httptest.NewRecorder()
+
+			h(w, r)
+			is.Equal(w.Code, tt.code)
+
+			if tt.code != http.StatusAccepted {
+				bridge.Shutdown(ctx)
+				is.Equal(len(storage.Events()), 0)
+				return
+			}
+
+			waitFor(t, time.Second, func() bool {
+				return len(storage.Events()) == 1
+			})
+			bridge.Shutdown(ctx)
+
+			msg := EventSentMessage{}
+			is.NoErr(json.Unmarshal(storage.Events()[0].Data, &msg))
+			to := UserPresentation("recipient", "recipient")
+			is.Equal(msg.To, &to)
+		}
+	}
+
+	t.Run(scenario(testArgs{
+		name: "online recipient",
+		to:   "recipient",
+		code: http.StatusAccepted,
+	}))
+	t.Run(scenario(testArgs{
+		name: "unknown recipient",
+		to:   "ghost",
+		code: http.StatusNotFound,
+	}))
+}
+
+func TestHandlerEditMessage(t *testing.T) {
+	type testArgs struct {
+		name   string
+		editor string
+		id     string
+		muted  bool
+		code   int
+	}
+
+	scenario := func(tt testArgs) (string, func(*testing.T)) {
+		return tt.name, func(t *testing.T) {
+			is := is.New(t)
+			ctx := context.Background()
+			log := testLogger()
+
+			messages := NewStateMessages(10)
+			is.NoErr(messages.PushMessage(ctx, StateMessage{
+				ID:      "msg",
+				From:    ChatUser{ID: "author", Nickname: "author"},
+				Channel: ChatChannelDefault,
+				Content: "helo",
+			}))
+
+			storage := newBridgeStorageMock()
+			bridge := NewBridge(ctx, BridgeBuilder{
+				Logger:  log,
+				Storage: storage,
+			})
+
+			users := NewStateOnlineUsers()
+			is.NoErr(users.PushChatUser(ctx, StateChatUser{ID: "karol", Nickname: "karol"}))
+
+			mutes := NewMuteStoreMemory(testClock())
+			if tt.muted {
+				is.NoErr(mutes.Mute(ctx, tt.editor, testClock().Now().Add(time.Minute)))
+			}
+
+			router := chi.NewRouter()
+			router.Put("/message/{id}", HandlerEditMessage(HandlerEditMessageDependencies{
+				MaxMessageSize: NewMessageSizeLimit(255),
+				Filters:        []MessageFilter{MentionFilter{Users: users}},
+				Sender: &BridgeEventProducer[EventMessageEdited]{
+					EventBridge: bridge,
+					Type:        BridgeMessageEdited,
+					Log:         log,
+					Clock:       testClock(),
+				},
+				Messages:    messages,
+				Mutes:       mutes,
+				Logger:      log,
+				IDGenerator: testIDGenerator(),
+				Clock:       testClock(),
+			}))
+
+			body, err := json.Marshal(map[string]string{"content": "hello @karol"})
+			is.NoErr(err)
+
+			r := requestWithSession(ctx, httptest.NewRequest(
+				http.MethodPut, "/message/"+tt.id, bytes.NewReader(body),
+			), &SessionState{
+				ID:       tt.editor,
+				Nickname: tt.editor,
+			})
+			w := httptest.NewRecorder()
+
+			router.ServeHTTP(w, r)
+			is.Equal(w.Code, tt.code)
+
+			if tt.code != http.StatusAccepted {
+				bridge.Shutdown(ctx)
+				is.Equal(len(storage.Events()), 0)
+				return
+			}
+
+			waitFor(t, time.Second, func() bool {
+				return len(storage.Events()) == 1
+			})
+			bridge.Shutdown(ctx)
+
+			evt := EventMessageEdited{}
+			is.NoErr(json.Unmarshal(storage.Events()[0].Data, &evt))
+			is.Equal(evt.MessageID, "msg")
+			is.Equal(evt.Content, "hello @karol")
+			is.Equal(evt.Channel, ChatChannelDefault)
+
+			// Mentions of edited content are kept.
+			is.Equal(evt.Mentions, []ChatUser{UserPresentation("karol", "karol")})
+		}
+	}
+
+	t.Run(scenario(testArgs{
+		name:   "author",
+		editor: "author",
+		id:     "msg",
+		code:   http.StatusAccepted,
+	}))
+	t.Run(scenario(testArgs{
+		name:   "someone else",
+		editor: "other",
+		id:     "msg",
+		code:   http.StatusForbidden,
+	}))
+	t.Run(scenario(testArgs{
+		name:   "missing message",
+		editor: "author",
+		id:     "ghost",
+		code:   http.StatusNotFound,
+	}))
+	t.Run(scenario(testArgs{
+		name:   "muted author",
+		editor: "author",
+		id:     "msg",
+		muted:  true,
+		code:   http.StatusForbidden,
+	}))
+}
+
+func TestHandlerDeleteMessage(t *testing.T) {
+	type testArgs struct {
+		name    string
+		deleter string
+		code    int
+	}
+
+	scenario := func(tt testArgs) (string, func(*testing.T)) {
+		return tt.name, func(t *testing.T) {
+			is := is.New(t)
+			ctx := context.Background()
+			log := testLogger()
+
+			messages := NewStateMessages(10)
+			is.NoErr(messages.PushMessage(ctx, StateMessage{
+				ID:      "msg",
+				From:    ChatUser{ID: "author", Nickname: "author"},
+				Channel: ChatChannelDefault,
+				Content: "oops",
+			}))
+
+			storage := newBridgeStorageMock()
+			bridge := NewBridge(ctx, BridgeBuilder{
+				Logger:  log,
+				Storage: storage,
+			})
+
+			router := chi.NewRouter()
+			router.Delete("/message/{id}", HandlerDeleteMessage(HandlerDeleteMessageDependencies{
+				Sender: &BridgeEventProducer[EventMessageDeleted]{
+					EventBridge: bridge,
+					Type:        BridgeMessageDeleted,
+					Log:         log,
+					Clock:       testClock(),
+				},
+				Messages:    messages,
+				IDGenerator: testIDGenerator(),
+				Clock:       testClock(),
+			}))
+
+			r := requestWithSession(ctx, httptest.NewRequest(
+				http.MethodDelete, "/message/msg", nil,
+			), &SessionState{
+				ID:       tt.deleter,
+				Nickname: tt.deleter,
+			})
+			w := httptest.NewRecorder()
+
+			router.ServeHTTP(w, r)
+			is.Equal(w.Code, tt.code)
+
+			if tt.code != http.StatusAccepted {
+				bridge.Shutdown(ctx)
+				is.Equal(len(storage.Events()), 0)
+				return
+			}
+
+			waitFor(t, time.Second, func() bool {
+				return len(storage.Events()) == 1
+			})
+			bridge.Shutdown(ctx)
+
+			evt := EventMessageDeleted{}
+			is.NoErr(json.Unmarshal(storage.Events()[0].Data, &evt))
+			is.Equal(evt.MessageID, "msg")
+			is.Equal(evt.By.ID, "author")
+		}
+	}
+
+	t.Run(scenario(testArgs{
+		name:    "author",
+		deleter: "author",
+		code:    http.StatusAccepted,
+	}))
+	t.Run(scenario(testArgs{
+		name:    "someone else",
+		deleter: "other",
+		code:    http.StatusForbidden,
+	}))
+}
+
+func TestHandlerErrorReasons(t *testing.T) {
+	ctx := context.Background()
+	log := testLogger()
+	clock := testClock()
+
+	uploads, err := NewUploadDiskStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bans := NewBanStoreMemory(clock)
+	if err := bans.Ban(ctx, "troll", time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	mutes := NewMuteStoreMemory(clock)
+	if err := mutes.Mute(ctx, "muted", clock.Now().Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	slowMode := NewSlowModeMemory(clock, map[string]time.Duration{"slow": time.Minute})
+	if _, err := slowMode.AllowMessage(ctx, "slow", "author"); err != nil {
+		t.Fatal(err)
+	}
+	messages := NewStateMessages(10)
+	if err := messages.PushMessage(ctx, StateMessage{
+		ID:      "msg",
+		From:    ChatUser{ID: "author", Nickname: "author"},
+		Channel: ChatChannelDefault,
+		Content: "helo",
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Every request is rejected, so no event reaches the bridge.
+	bridge := NewBridge(ctx, BridgeBuilder{
+		Logger:  log,
+		Storage: newBridgeStorageMock(),
+	})
+	defer bridge.Shutdown(ctx)
+
+	router := chi.NewRouter()
+	router.Post("/login", HandlerLogin(HandlerLoginDependencies{
+		StateFactory: DefaultSessionStateFactory(),
+		Logger:       log,
+		SessionStore: &SessionCookieStore{
+			ExpirationTime: time.Hour,
+			Tokenizer:      NewSessionSimpleTokenizer(),
+			Clock:          clock,
+		},
+		NicknamePolicy: NicknamePolicy{
+			MinLength: 3,
+			MaxLength: 8,
+		},
+		Bans: bans,
+	}))
+	router.Post("/logout/all", HandlerLogoutAll(&SessionCookieStore{}))
+	router.Get("/stream", HandlerStream(HandlerStreamDependencies{}))
+	router.Post("/message", HandlerSendMessage(HandlerSendMessageDependencies{
+		MaxMessageSize: NewMessageSizeLimit(8),
+		Sender: &BridgeEventProducer[EventSentMessage]{
+			EventBridge: bridge,
+			Type:        BridgeMessageSent,
+			Log:         log,
+			Clock:       clock,
+		},
+		Uploads:  uploads,
+		Mutes:    mutes,
+		SlowMode: slowMode,
+		Logger:   log,
+		Filters: []MessageFilter{MessageFilterFunc(func(ctx context.Context, msg *EventSentMessage) error {
+			if msg.Content == "spam" {
+				return errors.New("message is spam")
+			}
+			return nil
+		})},
+		IDGenerator: testIDGenerator(),
+		Clock:       clock,
+	}))
+	router.Post("/typing", HandlerTyping(HandlerTypingDependencies{}))
+	router.Put("/message/{id}", HandlerEditMessage(HandlerEditMessageDependencies{
+		MaxMessageSize: NewMessageSizeLimit(8),
+		Messages:       messages,
+		Mutes:          mutes,
+		Logger:         log,
+		IDGenerator:    testIDGenerator(),
+		Clock:          clock,
+	}))
+	router.Delete("/message/{id}", HandlerDeleteMessage(HandlerDeleteMessageDependencies{
+		Messages:    messages,
+		IDGenerator: testIDGenerator(),
+		Clock:       clock,
+	}))
+	router.Get("/history", HandlerHistory(HandlerHistoryDependencies{Logger: log}))
+	router.Get("/search", HandlerSearch(HandlerSearchDependencies{Logger: log}))
+	router.Post("/dm", HandlerDirectMessage(HandlerDirectMessageDependencies{
+		MaxMessageSize: NewMessageSizeLimit(8),
+		Users:          NewStateOnlineUsers(),
+		Mutes:          mutes,
+		Logger:         log,
+		IDGenerator:    testIDGenerator(),
+		Clock:          clock,
+	}))
+	router.Get("/users", HandlerOnlineUsers(log, NewStateOnlineUsers()))
+	router.Get("/readyz", HandlerReady(HandlerReadyDependencies{
+		Logger: log,
+		Storage: PingerFunc(func(ctx context.Context) error {
+			return errors.New("sql: database is closed")
+		}),
+		Bridge: bridge,
+	}))
+
+	type testArgs struct {
+		name   string
+		method string
+		target string
+		body   string
+		form   url.Values
+		userID string
+		code   int
+		reason ErrorReason
+	}
+
+	scenario := func(tt testArgs) (string, func(*testing.T)) {
+		return tt.name, func(t *testing.T) {
+			is := is.New(t)
+
+			body := strings.NewReader(tt.body)
+			if tt.form != nil {
+				body = strings.NewReader(tt.form.Encode())
+			}
+			r := httptest.NewRequest(tt.method, tt.target, body)
+			if tt.form != nil {
+				r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			}
+			if tt.userID != "" {
+				r = requestWithSession(ctx, r, &SessionState{
+					ID:       tt.userID,
+					Nickname: tt.userID,
+				})
+			}
+			w := httptest.NewRecorder()
+
+			router.ServeHTTP(w, r)
+			is.Equal(w.Code, tt.code)
+
+			res := struct {
+				Error errorResponse `json:"error"`
+			}{}
+			is.NoErr(json.NewDecoder(w.Body).Decode(&res))
+			is.Equal(res.Error.Code, tt.code)
+			is.Equal(res.Error.Reason, tt.reason)
+		}
+	}
+
+	t.Run(scenario(testArgs{
+		name:   "login without nickname",
+		method: http.MethodPost,
+		target: "/login",
+		form:   url.Values{"nickname": {" "}},
+		code:   http.StatusBadRequest,
+		reason: ErrorReasonNicknameEmpty,
+	}))
+	t.Run(scenario(testArgs{
+		name:   "login with invalid nickname",
+		method: http.MethodPost,
+		target: "/login",
+		form:   url.Values{"nickname": {"ab"}},
+		code:   http.StatusBadRequest,
+		reason: ErrorReasonNicknameInvalid,
+	}))
+	t.Run(scenario(testArgs{
+		name:   "login with banned nickname",
+		method: http.MethodPost,
+		target: "/login",
+		form:   url.Values{"nickname": {"troll"}},
+		code:   http.StatusForbidden,
+		reason: ErrorReasonNicknameBanned,
+	}))
+	t.Run(scenario(testArgs{
+		name:   "logout all without session",
+		method: http.MethodPost,
+		target: "/logout/all",
+		code:   http.StatusForbidden,
+		reason: ErrorReasonUnauthenticated,
+	}))
+	t.Run(scenario(testArgs{
+		name:   "logout all without revocation",
+		method: http.MethodPost,
+		target: "/logout/all",
+		userID: "author",
+		code:   http.StatusNotImplemented,
+		reason: ErrorReasonUnavailable,
+	}))
+	t.Run(scenario(testArgs{
+		name:   "stream without session",
+		method: http.MethodGet,
+		target: "/stream",
+		code:   http.StatusForbidden,
+		reason: ErrorReasonUnauthenticated,
+	}))
+	t.Run(scenario(testArgs{
+		name:   "stream with invalid channel",
+		method: http.MethodGet,
+		target: "/stream?channel=" + url.QueryEscape("<script>"),
+		userID: "author",
+		code:   http.StatusBadRequest,
+		reason: ErrorReasonInvalidParam,
+	}))
+	t.Run(scenario(testArgs{
+		name:   "stream with too long channel",
+		method: http.MethodGet,
+		target: "/stream?channel=" + strings.Repeat("a", ChatChannelMaxLength+1),
+		userID: "author",
+		code:   http.StatusBadRequest,
+		reason: ErrorReasonInvalidParam,
+	}))
+	t.Run(scenario(testArgs{
+		name:   "message with invalid channel",
+		method: http.MethodPost,
+		target: "/message?channel=" + url.QueryEscape("a b"),
+		body:   `{"content":"hello"}`,
+		userID: "author",
+		code:   http.StatusBadRequest,
+		reason: ErrorReasonInvalidParam,
+	}))
+	t.Run(scenario(testArgs{
+		name:   "message without session",
+		method: http.MethodPost,
+		target: "/message",
+		body:   `{"content":"hello"}`,
+		code:   http.StatusForbidden,
+		reason: ErrorReasonUnauthenticated,
+	}))
+	t.Run(scenario(testArgs{
+		name:   "message of muted user",
+		method: http.MethodPost,
+		target: "/message",
+		body:   `{"content":"hello"}`,
+		userID: "muted",
+		code:   http.StatusForbidden,
+		reason: ErrorReasonMuted,
+	}))
+	t.Run(scenario(testArgs{
+		name:   "malformed message",
+		method: http.MethodPost,
+		target: "/message",
+		body:   `{`,
+		userID: "author",
+		code:   http.StatusBadRequest,
+		reason: ErrorReasonInvalidBody,
+	}))
+	t.Run(scenario(testArgs{
+		name:   "empty message",
+		method: http.MethodPost,
+		target: "/message",
+		body:   `{"content":" "}`,
+		userID: "author",
+		code:   http.StatusBadRequest,
+		reason: ErrorReasonMessageEmpty,
+	}))
+	t.Run(scenario(testArgs{
+		name:   "too long message",
+		method: http.MethodPost,
+		target: "/message",
+		body:   `{"content":"hello world"}`,
+		userID: "author",
+		code:   http.StatusBadRequest,
+		reason: ErrorReasonMessageTooLong,
+	}))
+	t.Run(scenario(testArgs{
+		name:   "too long client message id",
+		method: http.MethodPost,
+		target: "/message",
+		body:   `{"content":"hello","clientMsgId":"` + strings.Repeat("a", clientMsgIDMaxLength+1) + `"}`,
+		userID: "author",
+		code:   http.StatusBadRequest,
+		reason: ErrorReasonInvalidBody,
+	}))
+	t.Run(scenario(testArgs{
+		name:   "unknown attachment",
+		method: http.MethodPost,
+		target: "/message",
+		body:   `{"attachments":["` + strings.Repeat("0", 32) + `"]}`,
+		userID: "author",
+		code:   http.StatusBadRequest,
+		reason: ErrorReasonAttachmentNotFound,
+	}))
+	t.Run(scenario(testArgs{
+		name:   "message rejected by filter",
+		method: http.MethodPost,
+		target: "/message",
+		body:   `{"content":"spam"}`,
+		userID: "author",
+		code:   http.StatusBadRequest,
+		reason: ErrorReasonMessageRejected,
+	}))
+	t.Run(scenario(testArgs{
+		name:   "message in slow mode",
+		method: http.MethodPost,
+		target: "/message?channel=slow",
+		body:   `{"content":"hello"}`,
+		userID: "author",
+		code:   http.StatusTooManyRequests,
+		reason: ErrorReasonSlowMode,
+	}))
+	t.Run(scenario(testArgs{
+		name:   "typing without session",
+		method: http.MethodPost,
+		target: "/typing",
+		code:   http.StatusForbidden,
+		reason: ErrorReasonUnauthenticated,
+	}))
+	t.Run(scenario(testArgs{
+		name:   "edit without session",
+		method: http.MethodPut,
+		target: "/message/msg",
+		body:   `{"content":"hello"}`,
+		code:   http.StatusForbidden,
+		reason: ErrorReasonUnauthenticated,
+	}))
+	t.Run(scenario(testArgs{
+		name:   "edit of muted user",
+		method: http.MethodPut,
+		target: "/message/msg",
+		body:   `{"content":"hello"}`,
+		userID: "muted",
+		code:   http.StatusForbidden,
+		reason: ErrorReasonMuted,
+	}))
+	t.Run(scenario(testArgs{
+		name:   "malformed edit",
+		method: http.MethodPut,
+		target: "/message/msg",
+		body:   `{`,
+		userID: "author",
+		code:   http.StatusBadRequest,
+		reason: ErrorReasonInvalidBody,
+	}))
+	t.Run(scenario(testArgs{
+		name:   "empty edit",
+		method: http.MethodPut,
+		target: "/message/msg",
+		body:   `{"content":""}`,
+		userID: "author",
+		code:   http.StatusBadRequest,
+		reason: ErrorReasonMessageEmpty,
+	}))
+	t.Run(scenario(testArgs{
+		name:   "too long edit",
+		method: http.MethodPut,
+		target: "/message/msg",
+		body:   `{"content":"hello world"}`,
+		userID: "author",
+		code:   http.StatusBadRequest,
+		reason: ErrorReasonMessageTooLong,
+	}))
+	t.Run(scenario(testArgs{
+		name:   "edit of missing message",
+		method: http.MethodPut,
+		target: "/message/ghost",
+		body:   `{"content":"hello"}`,
+		userID: "author",
+		code:   http.StatusNotFound,
+		reason: ErrorReasonMessageNotFound,
+	}))
+	t.Run(scenario(testArgs{
+		name:   "edit of message of someone else",
+		method: http.MethodPut,
+		target: "/message/msg",
+		body:   `{"content":"hello"}`,
+		userID: "other",
+		code:   http.StatusForbidden,
+		reason: ErrorReasonNotAuthor,
+	}))
+	t.Run(scenario(testArgs{
+		name:   "delete without session",
+		method: http.MethodDelete,
+		target: "/message/msg",
+		code:   http.StatusForbidden,
+		reason: ErrorReasonUnauthenticated,
+	}))
+	t.Run(scenario(testArgs{
+		name:   "delete of missing message",
+		method: http.MethodDelete,
+		target: "/message/ghost",
+		userID: "author",
+		code:   http.StatusNotFound,
+		reason: ErrorReasonMessageNotFound,
+	}))
+	t.Run(scenario(testArgs{
+		name:   "delete of message of someone else",
+		method: http.MethodDelete,
+		target: "/message/msg",
+		userID: "other",
+		code:   http.StatusForbidden,
+		reason: ErrorReasonNotAuthor,
+	}))
+	t.Run(scenario(testArgs{
+		name:   "history without session",
+		method: http.MethodGet,
+		target: "/history",
+		code:   http.StatusForbidden,
+		reason: ErrorReasonUnauthenticated,
+	}))
+	t.Run(scenario(testArgs{
+		name:   "history with invalid limit",
+		method: http.MethodGet,
+		target: "/history?limit=0",
+		userID: "author",
+		code:   http.StatusBadRequest,
+		reason: ErrorReasonInvalidParam,
+	}))
+	t.Run(scenario(testArgs{
+		name:   "history with invalid channel",
+		method: http.MethodGet,
+		target: "/history?channel=" + url.QueryEscape("zażółć"),
+		userID: "author",
+		code:   http.StatusBadRequest,
+		reason: ErrorReasonInvalidParam,
+	}))
+	t.Run(scenario(testArgs{
+		name:   "search without session",
+		method: http.MethodGet,
+		target: "/search?q=hello",
+		code:   http.StatusForbidden,
+		reason: ErrorReasonUnauthenticated,
+	}))
+	t.Run(scenario(testArgs{
+		name:   "search without query",
+		method: http.MethodGet,
+		target: "/search",
+		userID: "author",
+		code:   http.StatusBadRequest,
+		reason: ErrorReasonInvalidParam,
+	}))
+	t.Run(scenario(testArgs{
+		name:   "search with invalid limit",
+		method: http.MethodGet,
+		target: "/search?q=hello&limit=x",
+		userID: "author",
+		code:   http.StatusBadRequest,
+		reason: ErrorReasonInvalidParam,
+	}))
+	t.Run(scenario(testArgs{
+		name:   "direct message without session",
+		method: http.MethodPost,
+		target: "/dm",
+		body:   `{"to":"recipient","content":"psst"}`,
+		code:   http.StatusForbidden,
+		reason: ErrorReasonUnauthenticated,
+	}))
+	t.Run(scenario(testArgs{
+		name:   "direct message of muted user",
+		method: http.MethodPost,
+		target: "/dm",
+		body:   `{"to":"recipient","content":"psst"}`,
+		userID: "muted",
+		code:   http.StatusForbidden,
+		reason: ErrorReasonMuted,
+	}))
+	t.Run(scenario(testArgs{
+		name:   "malformed direct message",
+		method: http.MethodPost,
+		target: "/dm",
+		body:   `{`,
+		userID: "author",
+		code:   http.StatusBadRequest,
+		reason: ErrorReasonInvalidBody,
+	}))
+	t.Run(scenario(testArgs{
+		name:   "empty direct message",
+		method: http.MethodPost,
+		target: "/dm",
+		body:   `{"to":"recipient","content":""}`,
+		userID: "author",
+		code:   http.StatusBadRequest,
+		reason: ErrorReasonMessageEmpty,
+	}))
+	t.Run(scenario(testArgs{
+		name:   "too long direct message",
+		method: http.MethodPost,
+		target: "/dm",
+		body:   `{"to":"recipient","content":"hello world"}`,
+		userID: "author",
+		code:   http.StatusBadRequest,
+		reason: ErrorReasonMessageTooLong,
+	}))
+	t.Run(scenario(testArgs{
+		name:   "direct message to offline user",
+		method: http.MethodPost,
+		target: "/dm",
+		body:   `{"to":"recipient","content":"psst"}`,
+		userID: "author",
+		code:   http.StatusNotFound,
+		reason: ErrorReasonUserOffline,
+	}))
+	t.Run(scenario(testArgs{
+		name:   "users with invalid limit",
+		method: http.MethodGet,
+		target: "/users?limit=-1",
+		userID: "author",
+		code:   http.StatusBadRequest,
+		reason: ErrorReasonInvalidParam,
+	}))
+	t.Run(scenario(testArgs{
+		name:   "not ready",
+		method: http.MethodGet,
+		target: "/readyz",
+		code:   http.StatusServiceUnavailable,
+		reason: ErrorReasonUnavailable,
+	}))
+}
+
+func TestHandlerHealth(t *testing.T) {
+	is := is.New(t)
+
+	w := httptest.NewRecorder()
+	HandlerHealth()(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	is.Equal(w.Code, http.StatusOK)
+}
+
+func TestHandlerVersion(t *testing.T) {
+	type testArgs struct {
+		name string
+		info BuildInfo
+		want BuildInfo
+	}
+
+	scenario := func(tt testArgs) (string, func(*testing.T)) {
+		return tt.name, func(t *testing.T) {
+			is := is.New(t)
+
+			w := httptest.NewRecorder()
+			HandlerVersion(tt.info)(w, httptest.NewRequest(http.MethodGet, "/version", nil))
+			is.Equal(w.Code, http.StatusOK)
+
+			res := struct {
+				Data BuildInfo `json:"data"`
+			}{}
+			is.NoErr(json.NewDecoder(w.Body).Decode(&res))
+			is.Equal(res.Data, tt.want)
+		}
+	}
+
+	t.Run(scenario(testArgs{
+		name: "injected",
+		info: BuildInfo{
+			Version: "v1.2.3",
+			Commit:  "3f2c1a9",
+			Date:    "2023-05-01T12:00:00Z",
+		},
+		want: BuildInfo{
+			Version: "v1.2.3",
+			Commit:  "3f2c1a9",
+			Date:    "2023-05-01T12:00:00Z",
+		},
+	}))
+	t.Run(scenario(testArgs{
+		name: "unset",
+		want: BuildInfo{
+			Version: "dev",
+			Commit:  "dev",
+			Date:    "dev",
+		},
+	}))
+	t.Run(scenario(testArgs{
+		name: "partially set",
+		info: BuildInfo{
+			Version: "v1.2.3",
+		},
+		want: BuildInfo{
+			Version: "v1.2.3",
+			Commit:  "dev",
+			Date:    "dev",
+		},
+	}))
+}
+
+func TestHandlerReady(t *testing.T) {
+	type testArgs struct {
+		name          string
+		storageErr    error
+		bridgeStopped bool
+		code          int
+		failed        []string
+	}
+
+	scenario := func(tt testArgs) (string, func(*testing.T)) {
+		return tt.name, func(t *testing.T) {
+			is := is.New(t)
+			ctx := context.Background()
+
+			bridge := NewBridge(ctx, BridgeBuilder{
+				Logger:  testLogger(),
+				Storage: newBridgeStorageMock(),
+			})
+			if tt.bridgeStopped {
+				bridge.Shutdown(ctx)
+			} else {
+				defer bridge.Shutdown(ctx)
+			}
+
+			h := HandlerReady(HandlerReadyDependencies{
+				Logger: testLogger(),
+				Storage: PingerFunc(func(ctx context.Context) error {
+					return tt.storageErr
+				}),
+				Bridge: bridge,
+			})
+
+			w := httptest.NewRecorder()
+			h(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+			is.Equal(w.Code, tt.code)
+
+			if tt.code == http.StatusOK {
+				return
+			}
+
+			res := struct {
+				Error struct {
+					Code   int      `json:"code"`
+					Failed []string `json:"failed"`
+				} `json:"error"`
+			}{}
+			is.NoErr(json.NewDecoder(w.Body).Decode(&res))
+			is.Equal(res.Error.Code, tt.code)
+			is.Equal(res.Error.Failed, tt.failed)
+		}
+	}
+
+	t.Run(scenario(testArgs{
+		name: "ready",
+		code: http.StatusOK,
+	}))
+	t.Run(scenario(testArgs{
+		name:       "storage failure",
+		storageErr: errors.New("sql: database is closed"),
+		code:       http.StatusServiceUnavailable,
+		failed:     []string{"storage"},
+	}))
+	t.Run(scenario(testArgs{
+		name:          "bridge stopped",
+		bridgeStopped: true,
+		code:          http.StatusServiceUnavailable,
+		failed:        []string{"bridge"},
+	}))
+	t.Run(scenario(testArgs{
+		name:          "everything failed",
+		storageErr:    errors.New("sql: database is closed"),
+		bridgeStopped: true,
+		code:          http.StatusServiceUnavailable,
+		failed:        []string{"storage", "bridge"},
+	}))
+}
+
+func TestUserPresentation(t *testing.T) {
+	is := is.New(t)
+
+	user := UserPresentation("a7f4c2", "karol")
+	is.Equal(user.ID, "a7f4c2")
+	is.Equal(user.Nickname, "karol")
+	is.True(strings.HasPrefix(user.Color, "hsl("))
+
+	// Color depends only on user ID.
+	is.Equal(UserPresentation("a7f4c2", "zenek").Color, user.Color)
+
+	colors := map[string]bool{}
+	for _, id := range []string{"1", "2", "3", "4", "5"} {
+		colors[UserPresentation(id, "karol").Color] = true
+	}
+	is.Equal(len(colors), 5)
+}
+
+// messageHistoryMock returns given events and records arguments of the
+// last query.
+type messageHistoryMock struct {
+	evts []BridgeEvent
+
+	userID, channel, before string
+	limit                   int
+}
+
+func (m *messageHistoryMock) MessagesBefore(ctx context.Context, userID, channel, before string, limit int) ([]BridgeEvent, error) {
+	m.userID, m.channel, m.before, m.limit = userID, channel, before, limit
+	if len(m.evts) > limit {
+		return m.evts[:limit], nil
+	}
+	return m.evts, nil
+}
+
+func TestHandlerHistory(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+
+	history := &messageHistoryMock{}
+	for _, id := range []string{"3", "2", "1"} {
+		data, err := json.Marshal(EventSentMessage{ID: id, Channel: "random"})
+		is.NoErr(err)
+		history.evts = append(history.evts, BridgeEvent{ID: id, Name: BridgeMessageSent, Data: data})
+	}
+
+	h := HandlerHistory(HandlerHistoryDependencies{
+		Logger:  testLogger(),
+		History: history,
+	})
+
+	r := requestWithSession(ctx, httptest.NewRequest(http.MethodGet, "/history?channel=random&before=4&limit=2", nil), &SessionState{
+		ID:       "id",
+		Nickname: "karol",
+	})
+	w := httptest.NewRecorder()
+	h(w, r)
+	is.Equal(w.Code, http.StatusOK)
+
+	// Channel and direct messages are filtered by storage, so full page
+	// means that there can be more messages.
+	is.Equal(history.userID, "id")
+	is.Equal(history.channel, "random")
+	is.Equal(history.before, "4")
+	is.Equal(history.limit, 2)
+
+	res := struct {
+		Data struct {
+			Messages   []EventSentMessage `json:"messages"`
+			NextCursor string             `json:"nextCursor"`
+		} `json:"data"`
+	}{}
+	is.NoErr(json.NewDecoder(w.Body).Decode(&res))
+	is.Equal(len(res.Data.Messages), 2)
+	is.Equal(res.Data.NextCursor, "2")
+}
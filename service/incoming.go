@@ -0,0 +1,98 @@
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// HandlerIncomingWebhookDependencies holds behavioral dependencies for
+// http handler for incoming webhooks.
+type HandlerIncomingWebhookDependencies struct {
+	// Hooks map webhook keys to nicknames of posting bots.
+	Hooks map[string]string
+
+	MaxMessageSize *MessageSizeLimit
+	Sender         *BridgeEventProducer[EventSentMessage]
+	Filters        []MessageFilter
+
+	IDGenerator
+	Clock
+}
+
+// HandlerIncomingWebhook turns payloads of Slack and Discord compatible
+// incoming webhooks into chat messages, so existing integrations can
+// post to the chat. Webhook is identified by key in URL, which maps
+// to nickname of the bot sending messages.
+func HandlerIncomingWebhook(deps HandlerIncomingWebhookDependencies) http.HandlerFunc {
+	type request struct {
+		// Text is message content sent by Slack clients.
+		Text string `json:"text"`
+
+		// Content is message content sent by Discord clients.
+		Content string `json:"content"`
+	}
+	type response struct {
+		ID string `json:"id"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		nickname, ok := apiKeyNickname(deps.Hooks, chi.URLParam(r, "key"))
+		if !ok {
+			writeError(w, r, http.StatusNotFound, ErrorReasonNotFound, "Webhook doesn't exist.")
+			return
+		}
+		channel, ok := requestChatChannel(r)
+		if !ok {
+			invalidChatChannel(w, r)
+			return
+		}
+
+		req := &request{}
+
+		defer r.Body.Close()
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			writeError(w, r, http.StatusBadRequest, ErrorReasonInvalidBody, "Failed to parse body.")
+			return
+		}
+
+		content := req.Text
+		if strings.TrimSpace(content) == "" {
+			content = req.Content
+		}
+		content = strings.TrimSpace(content)
+		if content == "" {
+			writeError(w, r, http.StatusBadRequest, ErrorReasonMessageEmpty, "Message cannot be empty.")
+			return
+		}
+		if len([]rune(content)) > deps.MaxMessageSize.Size() {
+			writeError(w, r, http.StatusBadRequest, ErrorReasonMessageTooLong, "Invalid request body: maximum message length has been exceeded.")
+			return
+		}
+
+		messageID := deps.GenerateID()
+		msg := EventSentMessage{
+			ID:      messageID,
+			From:    UserPresentation(APIKeyBotID(nickname), nickname),
+			Channel: channel,
+			Content: content,
+			SentAt:  deps.Now(),
+		}
+		if err := MessageFilterChain(deps.Filters).Transform(ctx, &msg); err != nil {
+			messageRejected(w, r, err)
+			return
+		}
+
+		go deps.Sender.SendEvent(ctx, messageID, msg)
+
+		jsonResponse(w, http.StatusAccepted, responseWrapper{
+			Data: response{
+				ID: messageID,
+			},
+		})
+	}
+}
@@ -0,0 +1,120 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fenole/szmaterlok/service/sse"
+	"github.com/go-chi/chi/v5"
+	"github.com/matryer/is"
+)
+
+func TestHandlerIncomingWebhook(t *testing.T) {
+	type testArgs struct {
+		name    string
+		path    string
+		payload string
+		code    int
+		want    string
+	}
+
+	scenario := func(tt testArgs) (string, func(*testing.T)) {
+		return tt.name, func(t *testing.T) {
+			is := is.New(t)
+			ctx := context.Background()
+			log := testLogger()
+
+			messageHandler := NewBridgeMessageHandler(log)
+			router := NewBridgeEventRouter()
+			router.Hook(BridgeMessageSent, messageHandler)
+
+			bridge := NewBridge(ctx, BridgeBuilder{
+				Handler: router,
+				Logger:  log,
+				Storage: newBridgeStorageMock(),
+			})
+			defer bridge.Shutdown(ctx)
+
+			evts := make(chan sse.Event, 1)
+			unsubscribe := messageHandler.Subscribe(ctx, MessageSubscribeRequest{
+				ID:        "id",
+				RequestID: "req",
+				Channel:   evts,
+			})
+			defer unsubscribe()
+
+			mux := chi.NewRouter()
+			mux.Post("/hooks/incoming/{key}", HandlerIncomingWebhook(HandlerIncomingWebhookDependencies{
+				Hooks:          map[string]string{"ci-key": "ci"},
+				MaxMessageSize: NewMessageSizeLimit(255),
+				Sender: &BridgeEventProducer[EventSentMessage]{
+					EventBridge: bridge,
+					Type:        BridgeMessageSent,
+					Log:         log,
+					Clock:       testClock(),
+				},
+				IDGenerator: testIDGenerator(),
+				Clock:       testClock(),
+			}))
+
+			r := httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(tt.payload))
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, r)
+
+			is.Equal(w.Code, tt.code)
+			if tt.code != http.StatusAccepted {
+				return
+			}
+
+			select {
+			case evt := <-evts:
+				msg := EventSentMessage{}
+				is.NoErr(json.Unmarshal(evt.Data, &msg))
+				is.Equal(msg.Content, tt.want)
+				is.Equal(msg.From.Nickname, "ci")
+				is.Equal(msg.From.ID, APIKeyBotID("ci"))
+				is.Equal(msg.Channel, ChatChannelDefault)
+			case <-time.After(time.Second):
+				t.Fatal("message has not been broadcast")
+			}
+		}
+	}
+
+	t.Run(scenario(testArgs{
+		name:    "slack payload",
+		path:    "/hooks/incoming/ci-key",
+		payload: `{"text":"Build #42 passed"}`,
+		code:    http.StatusAccepted,
+		want:    "Build #42 passed",
+	}))
+	t.Run(scenario(testArgs{
+		name:    "discord payload",
+		path:    "/hooks/incoming/ci-key",
+		payload: `{"content":"Build #43 failed","username":"ignored"}`,
+		code:    http.StatusAccepted,
+		want:    "Build #43 failed",
+	}))
+	t.Run(scenario(testArgs{
+		name:    "unknown key",
+		path:    "/hooks/incoming/other-key",
+		payload: `{"text":"Build #42 passed"}`,
+		code:    http.StatusNotFound,
+	}))
+	t.Run(scenario(testArgs{
+		name:    "empty payload",
+		path:    "/hooks/incoming/ci-key",
+		payload: `{"text":" ","content":""}`,
+		code:    http.StatusBadRequest,
+	}))
+	t.Run(scenario(testArgs{
+		name:    "invalid payload",
+		path:    "/hooks/incoming/ci-key",
+		payload: `text=hello`,
+		code:    http.StatusBadRequest,
+	}))
+}
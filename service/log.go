@@ -1,7 +1,10 @@
 package service
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
+	"regexp"
 	"time"
 
 	"github.com/go-chi/chi/v5/middleware"
@@ -10,11 +13,85 @@ import (
 
 // LoggerDefault return default general purpose
 // logger that can be used everywhere across project.
+// Logs are written in given format (see ConfigLogFormatVarName),
+// unknown formats fall back to human readable text.
 //
 // It should be initialized once and then reused or modified
 // in different areas.
-func LoggerDefault() *logrus.Logger {
-	return logrus.New()
+func LoggerDefault(format string) *logrus.Logger {
+	log := logrus.New()
+	log.AddHook(ScrubbingHook{})
+	if format == ConfigLogFormatJSON {
+		log.SetFormatter(&logrus.JSONFormatter{})
+	}
+	return log
+}
+
+// scrubbedValue replaces sensitive values in logs.
+const scrubbedValue = "***"
+
+var (
+	// scrubCookiePattern matches session cookie with its value, for
+	// example within Cookie header.
+	scrubCookiePattern = regexp.MustCompile(sessionCookieKey + `=[^;\s]*`)
+
+	// scrubTokenPattern matches long runs of URL safe base64, which
+	// are produced by session tokenizers. Runs can be joined with dots
+	// (JWT) or colons (AES nonce). Minimal length of run is long
+	// enough to leave UUIDs untouched.
+	scrubTokenPattern = regexp.MustCompile(`(?:[A-Za-z0-9_-]+={0,2}[.:])*[A-Za-z0-9_-]{40,}={0,2}(?:[.:][A-Za-z0-9_-]+={0,2})*`)
+)
+
+// ScrubbingHook is logrus hook, which removes session cookies and
+// tokens from log entries before they're written. Values of fields
+// named after session cookie are replaced entirely.
+type ScrubbingHook struct{}
+
+// Levels returns all logging levels, so every entry is scrubbed.
+func (ScrubbingHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+// Fire scrubs message and field values of given entry.
+func (ScrubbingHook) Fire(entry *logrus.Entry) error {
+	entry.Message = scrubString(entry.Message)
+
+	for key, val := range entry.Data {
+		if key == sessionCookieKey {
+			entry.Data[key] = scrubbedValue
+			continue
+		}
+
+		switch v := val.(type) {
+		case string:
+			entry.Data[key] = scrubString(v)
+		case error:
+			if msg := v.Error(); scrubString(msg) != msg {
+				entry.Data[key] = errors.New(scrubString(msg))
+			}
+		case fmt.Stringer:
+			if str := v.String(); scrubString(str) != str {
+				entry.Data[key] = scrubString(str)
+			}
+		}
+	}
+
+	return nil
+}
+
+// scrubString replaces session cookies and tokens found in given
+// string.
+func scrubString(s string) string {
+	s = scrubCookiePattern.ReplaceAllString(s, sessionCookieKey+"="+scrubbedValue)
+	return scrubTokenPattern.ReplaceAllString(s, scrubbedValue)
+}
+
+// LoggerFromConfig returns default logger with format and level
+// set according to given configuration.
+func LoggerFromConfig(c ConfigVariables) *logrus.Logger {
+	log := LoggerDefault(c.LogFormat)
+	log.SetLevel(c.LogLevel)
+	return log
 }
 
 // LoggerLogFormatter is adapter which implements chi LogFormatter
@@ -58,7 +135,7 @@ func (la loggerLogEntry) Write(
 		"status":  status,
 		"bytes":   bytes,
 		"elapsed": elapsed.String(),
-	}).Infof("%s %s://%s%s %s\" ", la.req.Method, scheme, la.req.Host, la.req.RequestURI, la.req.Proto)
+	}).Infof("%s %s://%s%s %s", la.req.Method, scheme, la.req.Host, la.req.RequestURI, la.req.Proto)
 }
 
 func (log loggerLogEntry) Panic(v interface{}, stack []byte) {
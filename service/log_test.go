@@ -0,0 +1,132 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/matryer/is"
+	"github.com/sirupsen/logrus"
+)
+
+func TestLoggerLogFormatterJSON(t *testing.T) {
+	is := is.New(t)
+
+	buff := &bytes.Buffer{}
+	log := LoggerDefault(ConfigLogFormatJSON)
+	log.SetOutput(buff)
+
+	var entry middleware.LogEntry
+	h := middleware.RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		entry = (&LoggerLogFormatter{Logger: log}).NewLogEntry(r)
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/users", nil))
+	entry.Write(http.StatusOK, 42, http.Header{}, time.Millisecond*3, nil)
+
+	var got struct {
+		Level   string `json:"level"`
+		Msg     string `json:"msg"`
+		ReqID   string `json:"reqID"`
+		Method  string `json:"method"`
+		Status  int    `json:"status"`
+		Bytes   int    `json:"bytes"`
+		Elapsed string `json:"elapsed"`
+	}
+	is.NoErr(json.Unmarshal(buff.Bytes(), &got))
+
+	is.Equal(got.Level, "info")
+	is.Equal(got.Msg, "GET http://example.com/users HTTP/1.1")
+	is.True(got.ReqID != "")
+	is.Equal(got.Method, http.MethodGet)
+	is.Equal(got.Status, http.StatusOK)
+	is.Equal(got.Bytes, 42)
+	is.Equal(got.Elapsed, "3ms")
+}
+
+func TestScrubbingHook(t *testing.T) {
+	tokenizer, err := NewSessionAESTokenizer([]byte("veibiequohy2eshaerohHoghootae1ku"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := tokenizer.TokenEncode(SessionState{ID: "uniqueid", Nickname: "karol"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type testArgs struct {
+		name  string
+		entry func(log *logrus.Logger)
+		field string
+		want  string
+	}
+
+	scenario := func(tt testArgs) (string, func(*testing.T)) {
+		return tt.name, func(t *testing.T) {
+			is := is.New(t)
+
+			buff := &bytes.Buffer{}
+			log := LoggerDefault(ConfigLogFormatJSON)
+			log.SetOutput(buff)
+
+			tt.entry(log)
+
+			got := map[string]interface{}{}
+			is.NoErr(json.Unmarshal(buff.Bytes(), &got))
+			is.Equal(got[tt.field], tt.want)
+		}
+	}
+
+	t.Run(scenario(testArgs{
+		name: "token field",
+		entry: func(log *logrus.Logger) {
+			log.WithField("token", token).Info("decoding token")
+		},
+		field: "token",
+		want:  "***",
+	}))
+	t.Run(scenario(testArgs{
+		name: "wrapped token in error",
+		entry: func(log *logrus.Logger) {
+			log.WithError(fmt.Errorf("failed to decode token %s: invalid", token)).Error("decoding token")
+		},
+		field: "error",
+		want:  "failed to decode token ***: invalid",
+	}))
+	t.Run(scenario(testArgs{
+		name: "cookie field",
+		entry: func(log *logrus.Logger) {
+			log.WithField(sessionCookieKey, "short").Info("cookie")
+		},
+		field: sessionCookieKey,
+		want:  "***",
+	}))
+	t.Run(scenario(testArgs{
+		name: "cookie header",
+		entry: func(log *logrus.Logger) {
+			log.WithField("cookie", "theme=dark; "+sessionCookieKey+"=abc").Info("cookie")
+		},
+		field: "cookie",
+		want:  "theme=dark; " + sessionCookieKey + "=***",
+	}))
+	t.Run(scenario(testArgs{
+		name: "message",
+		entry: func(log *logrus.Logger) {
+			log.Infof("received %s", token)
+		},
+		field: "msg",
+		want:  "received ***",
+	}))
+	t.Run(scenario(testArgs{
+		name: "uuid",
+		entry: func(log *logrus.Logger) {
+			log.WithField("eventID", "1b4e28ba-2fa1-11d2-883f-0cc47a4c3f2e").Info("event")
+		},
+		field: "eventID",
+		want:  "1b4e28ba-2fa1-11d2-883f-0cc47a4c3f2e",
+	}))
+}
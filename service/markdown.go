@@ -0,0 +1,49 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/yuin/goldmark"
+)
+
+// MarkdownFilter renders message content written in Markdown into HTML,
+// which is safe to embed in chat. Raw content of message is kept
+// untouched. Only basic formatting survives sanitization: paragraphs,
+// bold, italic, inline code, code blocks and links. Other elements
+// are reduced to their text.
+type MarkdownFilter struct {
+	markdown goldmark.Markdown
+	policy   *bluemonday.Policy
+}
+
+// NewMarkdownFilter returns markdown filter with default allowlist
+// of HTML elements.
+func NewMarkdownFilter() *MarkdownFilter {
+	policy := bluemonday.NewPolicy()
+	policy.AllowElements("p", "br", "strong", "em", "code", "pre")
+	policy.AllowAttrs("href").OnElements("a")
+	policy.AllowStandardURLs()
+	policy.RequireNoFollowOnLinks(true)
+
+	return &MarkdownFilter{
+		// Raw HTML is omitted by default renderer, but sanitizer
+		// stays the only line of defense.
+		markdown: goldmark.New(),
+		policy:   policy,
+	}
+}
+
+// Transform sets HTML of given message rendered from its content.
+func (f *MarkdownFilter) Transform(ctx context.Context, msg *EventSentMessage) error {
+	var buf bytes.Buffer
+	if err := f.markdown.Convert([]byte(msg.Content), &buf); err != nil {
+		return fmt.Errorf("failed to render markdown: %w", err)
+	}
+
+	msg.HTML = strings.TrimSpace(f.policy.Sanitize(buf.String()))
+	return nil
+}
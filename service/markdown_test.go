@@ -0,0 +1,69 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+func TestMarkdownFilter(t *testing.T) {
+	type testArgs struct {
+		name    string
+		content string
+		want    string
+	}
+
+	filter := NewMarkdownFilter()
+
+	scenario := func(tt testArgs) (string, func(*testing.T)) {
+		return tt.name, func(t *testing.T) {
+			is := is.New(t)
+
+			msg := &EventSentMessage{Content: tt.content}
+			is.NoErr(filter.Transform(context.Background(), msg))
+			is.Equal(msg.HTML, tt.want)
+
+			// Raw content is kept untouched.
+			is.Equal(msg.Content, tt.content)
+		}
+	}
+
+	t.Run(scenario(testArgs{
+		name:    "formatting",
+		content: "**bold**, *italic* and `code`",
+		want:    "<p><strong>bold</strong>, <em>italic</em> and <code>code</code></p>",
+	}))
+	t.Run(scenario(testArgs{
+		name:    "link",
+		content: "[docs](https://example.com/docs)",
+		want:    `<p><a href="https://example.com/docs" rel="nofollow">docs</a></p>`,
+	}))
+	t.Run(scenario(testArgs{
+		// Inline tags are removed one by one, so text between
+		// them is left as plain text.
+		name:    "inline script",
+		content: "hello <script>alert(1)</script>",
+		want:    "<p>hello alert(1)</p>",
+	}))
+	t.Run(scenario(testArgs{
+		name:    "script block",
+		content: "<script>alert(1)</script>",
+		want:    "",
+	}))
+	t.Run(scenario(testArgs{
+		name:    "javascript link",
+		content: "[click](javascript:alert(1))",
+		want:    "<p>click</p>",
+	}))
+	t.Run(scenario(testArgs{
+		name:    "iframe",
+		content: `<iframe src="https://example.com"></iframe>`,
+		want:    "",
+	}))
+	t.Run(scenario(testArgs{
+		name:    "heading reduced to text",
+		content: "# title",
+		want:    "title",
+	}))
+}
@@ -0,0 +1,87 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// isMentionRune reports whether given rune can be part of mentioned
+// nickname.
+func isMentionRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || r == '-' || r == '.'
+}
+
+// ParseMentions returns nicknames mentioned with @nickname in given
+// content. Mention has to start at the beginning of content or after
+// character, which can't be part of nickname, so e-mail addresses are
+// ignored. Returned nicknames are unique and ordered by their first
+// mention.
+func ParseMentions(content string) []string {
+	res := []string{}
+	seen := map[string]bool{}
+
+	prev := ' '
+	for i, r := range content {
+		if r != '@' || isMentionRune(prev) {
+			prev = r
+			continue
+		}
+		prev = r
+
+		rest := content[i+utf8.RuneLen(r):]
+		end := strings.IndexFunc(rest, func(r rune) bool {
+			return !isMentionRune(r)
+		})
+		if end < 0 {
+			end = len(rest)
+		}
+
+		// Punctuation at the end of sentence is not part of nickname.
+		nickname := strings.TrimRight(rest[:end], ".-")
+		if nickname == "" || seen[strings.ToLower(nickname)] {
+			continue
+		}
+
+		seen[strings.ToLower(nickname)] = true
+		res = append(res, nickname)
+	}
+
+	return res
+}
+
+// MentionFilter attaches online users mentioned in message content
+// to the message. Nicknames are matched case-insensitively. Mentions
+// of users, who are not online, are ignored.
+type MentionFilter struct {
+	Users AllChatUsersStore
+}
+
+// Transform sets mentions of given message. It never rejects the
+// message, but it fails with ErrFilterFailed, when online users can't
+// be found.
+func (f MentionFilter) Transform(ctx context.Context, msg *EventSentMessage) error {
+	nicknames := ParseMentions(msg.Content)
+	if len(nicknames) == 0 {
+		return nil
+	}
+
+	users, err := f.Users.AllChatUsers(ctx)
+	if err != nil {
+		return fmt.Errorf("%w: failed to find mentioned users: %w", ErrFilterFailed, err)
+	}
+
+	for _, nickname := range nicknames {
+		// Nicknames aren't unique, so every online user with
+		// mentioned nickname is mentioned.
+		for _, user := range users {
+			if strings.EqualFold(user.Nickname, nickname) {
+				msg.Mentions = append(msg.Mentions, UserPresentation(user.ID, user.Nickname))
+			}
+		}
+	}
+
+	return nil
+}
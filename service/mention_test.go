@@ -0,0 +1,127 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/matryer/is"
+)
+
+func TestParseMentions(t *testing.T) {
+	type testArgs struct {
+		name    string
+		content string
+		want    []string
+	}
+
+	scenario := func(tt testArgs) (string, func(*testing.T)) {
+		return tt.name, func(t *testing.T) {
+			is := is.New(t)
+			is.Equal(ParseMentions(tt.content), tt.want)
+		}
+	}
+
+	t.Run(scenario(testArgs{
+		name:    "no mentions",
+		content: "hello world",
+		want:    []string{},
+	}))
+	t.Run(scenario(testArgs{
+		name:    "single",
+		content: "@karol hello",
+		want:    []string{"karol"},
+	}))
+	t.Run(scenario(testArgs{
+		name:    "multiple",
+		content: "hi @karol, @zenek and @Karol.",
+		want:    []string{"karol", "zenek"},
+	}))
+	t.Run(scenario(testArgs{
+		name:    "email",
+		content: "write to karol@example.com",
+		want:    []string{},
+	}))
+	t.Run(scenario(testArgs{
+		name:    "lone at sign",
+		content: "meet @ 5pm @",
+		want:    []string{},
+	}))
+	t.Run(scenario(testArgs{
+		name:    "non-ascii",
+		content: "(@żaneta_1)",
+		want:    []string{"żaneta_1"},
+	}))
+}
+
+func TestMentionFilter(t *testing.T) {
+	type testArgs struct {
+		name    string
+		content string
+		want    []ChatUser
+	}
+
+	ctx := context.Background()
+	users := NewStateOnlineUsers()
+	for _, u := range []StateChatUser{
+		{ID: "1", Nickname: "karol"},
+		{ID: "2", Nickname: "Zenek"},
+	} {
+		if err := users.PushChatUser(ctx, u); err != nil {
+			t.Fatal(err)
+		}
+	}
+	filter := MentionFilter{Users: users}
+
+	scenario := func(tt testArgs) (string, func(*testing.T)) {
+		return tt.name, func(t *testing.T) {
+			is := is.New(t)
+
+			msg := &EventSentMessage{Content: tt.content}
+			is.NoErr(filter.Transform(ctx, msg))
+			is.Equal(msg.Mentions, tt.want)
+		}
+	}
+
+	t.Run(scenario(testArgs{
+		name:    "single",
+		content: "hey @Karol!",
+		want:    []ChatUser{UserPresentation("1", "karol")},
+	}))
+	t.Run(scenario(testArgs{
+		name:    "multiple",
+		content: "@zenek @karol",
+		want: []ChatUser{
+			UserPresentation("2", "Zenek"),
+			UserPresentation("1", "karol"),
+		},
+	}))
+	t.Run(scenario(testArgs{
+		name:    "offline user",
+		content: "@mietek are you there?",
+		want:    nil,
+	}))
+}
+
+// allChatUsersFailingMock is store of chat users, which always fails.
+type allChatUsersFailingMock struct {
+	AllChatUsersStore
+}
+
+func (allChatUsersFailingMock) AllChatUsers(ctx context.Context) ([]OnlineChatUser, error) {
+	return nil, errors.New("storage is unavailable")
+}
+
+func TestMentionFilterFailed(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+
+	filter := MentionFilter{Users: allChatUsersFailingMock{}}
+
+	// Failure of store is reported as failed filter, not rejection.
+	err := filter.Transform(ctx, &EventSentMessage{Content: "hey @karol"})
+	is.True(errors.Is(err, ErrFilterFailed))
+
+	// Store isn't used, when nobody is mentioned.
+	is.NoErr(filter.Transform(ctx, &EventSentMessage{Content: "hey"}))
+}
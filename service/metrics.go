@@ -0,0 +1,178 @@
+package service
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// metricsNamespace prefixes names of all szmaterlok metrics.
+const metricsNamespace = "szmaterlok"
+
+// Metrics holds prometheus collectors describing szmaterlok runtime.
+// All of its methods are safe to call on nil Metrics, which makes
+// instrumentation optional for its users.
+type Metrics struct {
+	registry *prometheus.Registry
+
+	messagesSent      prometheus.Counter
+	activeConnections prometheus.Gauge
+	droppedEvents     prometheus.Counter
+	handlerDuration   *prometheus.HistogramVec
+}
+
+// NewMetrics returns metrics registered in their own registry
+// alongside with go runtime and process collectors.
+func NewMetrics() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		messagesSent: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "messages_sent_total",
+			Help:      "Number of chat messages processed by event bridge.",
+		}),
+		activeConnections: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "sse_active_connections",
+			Help:      "Number of currently open event streams.",
+		}),
+		droppedEvents: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "sse_dropped_events_total",
+			Help:      "Number of events not delivered to slow event stream clients.",
+		}),
+		handlerDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "bridge_event_handler_duration_seconds",
+			Help:      "Time spent by event bridge handlers on single event.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"type"}),
+	}
+
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.messagesSent,
+		m.activeConnections,
+		m.droppedEvents,
+		m.handlerDuration,
+	)
+
+	return m
+}
+
+// Handler returns http handler exposing metrics in prometheus
+// text format.
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// registerBridge registers gauges reporting number of events waiting
+// in event bridge queue and events being handled, and counters of
+// events processed and dropped by the bridge.
+func (m *Metrics) registerBridge(b *Bridge) {
+	if m == nil {
+		return
+	}
+
+	m.registry.MustRegister(
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "bridge_queue_depth",
+			Help:      "Number of events waiting in event bridge queue.",
+		}, func() float64 {
+			return float64(b.queueDepth())
+		}),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "bridge_dropped_events_total",
+			Help:      "Number of events dropped, because event bridge queue was full.",
+		}, func() float64 {
+			return float64(b.Dropped())
+		}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "bridge_in_flight_events",
+			Help:      "Number of events being handled by event bridge handlers.",
+		}, func() float64 {
+			return float64(b.inFlight.Load())
+		}),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "bridge_processed_events_total",
+			Help:      "Number of events stored and handled by event bridge.",
+		}, func() float64 {
+			return float64(b.processed.Load())
+		}),
+	)
+}
+
+// registerTokenizerCache registers counters of session tokenizer
+// cache hits and misses.
+func (m *Metrics) registerTokenizerCache(c *SessionTokenizerCache) {
+	if m == nil {
+		return
+	}
+
+	m.registry.MustRegister(
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "tokenizer_cache_hits_total",
+			Help:      "Number of session tokens decoded from tokenizer cache.",
+		}, func() float64 {
+			return float64(c.Hits())
+		}),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "tokenizer_cache_misses_total",
+			Help:      "Number of session tokens missing in tokenizer cache.",
+		}, func() float64 {
+			return float64(c.Misses())
+		}),
+	)
+}
+
+// messageSent increments sent messages counter.
+func (m *Metrics) messageSent() {
+	if m == nil {
+		return
+	}
+	m.messagesSent.Inc()
+}
+
+// connectionOpened increments active event streams gauge.
+func (m *Metrics) connectionOpened() {
+	if m == nil {
+		return
+	}
+	m.activeConnections.Inc()
+}
+
+// connectionClosed decrements active event streams gauge.
+func (m *Metrics) connectionClosed() {
+	if m == nil {
+		return
+	}
+	m.activeConnections.Dec()
+}
+
+// subscriberEventDropped increments counter of events not delivered
+// to slow subscribers.
+func (m *Metrics) subscriberEventDropped() {
+	if m == nil {
+		return
+	}
+	m.droppedEvents.Inc()
+}
+
+// observeHandler records time which event handler spent on
+// event with given type since start.
+func (m *Metrics) observeHandler(t BridgeEventType, start time.Time) {
+	if m == nil {
+		return
+	}
+	m.handlerDuration.WithLabelValues(string(t)).Observe(time.Since(start).Seconds())
+}
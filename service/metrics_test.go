@@ -0,0 +1,91 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+)
+
+// scrapeMetric returns value of metric with given name, as it is
+// exposed by metrics http handler.
+func scrapeMetric(t *testing.T, m *Metrics, name string) string {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	m.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected metrics status code: %d", w.Code)
+	}
+
+	for _, line := range strings.Split(w.Body.String(), "\n") {
+		if val, ok := strings.CutPrefix(line, name+" "); ok {
+			return val
+		}
+	}
+
+	return ""
+}
+
+func TestMetrics(t *testing.T) {
+	t.Run("messages sent", func(t *testing.T) {
+		is := is.New(t)
+		ctx := context.Background()
+		log := testLogger()
+		metrics := NewMetrics()
+
+		bridge := NewBridge(ctx, BridgeBuilder{
+			Logger:  log,
+			Storage: newBridgeStorageMock(),
+			Metrics: metrics,
+		})
+		defer bridge.Shutdown(ctx)
+
+		h := HandlerSendMessage(HandlerSendMessageDependencies{
+			MaxMessageSize: NewMessageSizeLimit(255),
+			Sender: &BridgeEventProducer[EventSentMessage]{
+				EventBridge: bridge,
+				Type:        BridgeMessageSent,
+				Log:         log,
+				Clock:       testClock(),
+			},
+			IDGenerator: testIDGenerator(),
+			Clock:       testClock(),
+		})
+
+		is.Equal(scrapeMetric(t, metrics, "szmaterlok_messages_sent_total"), "0")
+
+		body, err := json.Marshal(map[string]string{"content": "hello"})
+		is.NoErr(err)
+
+		r := requestWithSession(ctx, httptest.NewRequest(
+			http.MethodPost, "/message", bytes.NewReader(body),
+		), &SessionState{
+			ID:       "id",
+			Nickname: "nickname",
+		})
+		w := httptest.NewRecorder()
+		h(w, r)
+		is.Equal(w.Code, http.StatusAccepted)
+
+		waitFor(t, time.Second, func() bool {
+			return scrapeMetric(t, metrics, "szmaterlok_messages_sent_total") == "1"
+		})
+	})
+
+	t.Run("nil metrics", func(t *testing.T) {
+		var m *Metrics
+		m.messageSent()
+		m.connectionOpened()
+		m.connectionClosed()
+		m.subscriberEventDropped()
+		m.observeHandler(BridgeMessageSent, time.Now())
+		m.registerBridge(nil)
+	})
+}
@@ -0,0 +1,368 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/sirupsen/logrus"
+)
+
+// MuteStore holds muted user IDs. Muted users can't send messages
+// until their mute expires.
+type MuteStore interface {
+	// Mute mutes given user ID until given expiration date.
+	Mute(ctx context.Context, id string, expireAt time.Time) error
+
+	// Unmute lifts mute of given user ID.
+	Unmute(ctx context.Context, id string) error
+
+	// Muted returns expiration date of mute of given user ID. It
+	// returns false when user isn't muted or their mute has expired.
+	Muted(ctx context.Context, id string) (time.Time, bool, error)
+}
+
+// MuteStoreMemory is in-memory MuteStore. Expired mutes are garbage
+// collected on every mute.
+type MuteStoreMemory struct {
+	mutes map[string]time.Time
+	mtx   *sync.Mutex
+	clock Clock
+}
+
+// NewMuteStoreMemory returns empty in-memory mute store.
+func NewMuteStoreMemory(clock Clock) *MuteStoreMemory {
+	return &MuteStoreMemory{
+		mutes: make(map[string]time.Time),
+		mtx:   &sync.Mutex{},
+		clock: clock,
+	}
+}
+
+// Mute mutes given user ID until given expiration date.
+func (s *MuteStoreMemory) Mute(ctx context.Context, id string, expireAt time.Time) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	now := s.clock.Now()
+	for mutedID, mutedExpireAt := range s.mutes {
+		if !mutedExpireAt.After(now) {
+			delete(s.mutes, mutedID)
+		}
+	}
+
+	s.mutes[id] = expireAt
+	return nil
+}
+
+// Unmute lifts mute of given user ID.
+func (s *MuteStoreMemory) Unmute(ctx context.Context, id string) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	delete(s.mutes, id)
+	return nil
+}
+
+// Muted returns expiration date of mute of given user ID.
+func (s *MuteStoreMemory) Muted(ctx context.Context, id string) (time.Time, bool, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	expireAt, ok := s.mutes[id]
+	if !ok || !expireAt.After(s.clock.Now()) {
+		return time.Time{}, false, nil
+	}
+
+	return expireAt, true, nil
+}
+
+// FloodGuard escalates repeated rate limit violations. Users exceeding
+// rate limit given number of times within window are muted for
+// cooldown period.
+type FloodGuard struct {
+	mutes      MuteStore
+	violations int
+	window     time.Duration
+	cooldown   time.Duration
+	strikes    map[string][]time.Time
+	mtx        *sync.Mutex
+	clock      Clock
+}
+
+// FloodGuardBuilder holds arguments for building flood guard.
+type FloodGuardBuilder struct {
+	Mutes MuteStore
+
+	// Violations is number of rate limit violations within window,
+	// after which user is muted.
+	Violations int
+
+	// Window is period, in which violations are counted.
+	Window time.Duration
+
+	// Cooldown is duration of mute.
+	Cooldown time.Duration
+
+	Clock
+}
+
+// NewFloodGuard returns flood guard configured with given arguments.
+func NewFloodGuard(b FloodGuardBuilder) *FloodGuard {
+	return &FloodGuard{
+		mutes:      b.Mutes,
+		violations: b.Violations,
+		window:     b.Window,
+		cooldown:   b.Cooldown,
+		strikes:    make(map[string][]time.Time),
+		mtx:        &sync.Mutex{},
+		clock:      b.Clock,
+	}
+}
+
+// Violation records rate limit violation of given user ID. User is
+// muted, when it's one violation too many. Violation returns
+// expiration date of the mute then.
+func (g *FloodGuard) Violation(ctx context.Context, id string) (time.Time, bool, error) {
+	g.mtx.Lock()
+	defer g.mtx.Unlock()
+
+	now := g.clock.Now()
+
+	// Violations outside of window are forgotten, including
+	// violations of other users, so map doesn't grow indefinitely.
+	for strikedID, strikes := range g.strikes {
+		recent := strikes[:0]
+		for _, at := range strikes {
+			if now.Sub(at) < g.window {
+				recent = append(recent, at)
+			}
+		}
+		if len(recent) == 0 {
+			delete(g.strikes, strikedID)
+			continue
+		}
+		g.strikes[strikedID] = recent
+	}
+
+	strikes := append(g.strikes[id], now)
+	if len(strikes) < g.violations {
+		g.strikes[id] = strikes
+		return time.Time{}, false, nil
+	}
+
+	// Counting starts from scratch after mute.
+	delete(g.strikes, id)
+
+	expireAt := now.Add(g.cooldown)
+	if err := g.mutes.Mute(ctx, id, expireAt); err != nil {
+		return time.Time{}, false, fmt.Errorf("g.mutes.Mute: %w", err)
+	}
+	return expireAt, true, nil
+}
+
+// FloodGuardMiddleware limits requests of every session with given
+// rate limiter, just like RateLimitMiddleware, and reports violations
+// to given flood guard. Requests of muted users are rejected with 403
+// status code. It has to be used after SessionRequired middleware.
+func FloodGuardMiddleware(log *logrus.Logger, l *RateLimiter, g *FloodGuard) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+			state := SessionContextState(ctx)
+			if state == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if muteRejected(w, r, log, g.mutes, g.clock, state.ID) {
+				return
+			}
+
+			ok, retryAfter := l.Allow(state.ID)
+			if ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			log := log.WithFields(logrus.Fields{
+				"reqID":  middleware.GetReqID(ctx),
+				"userID": state.ID,
+			})
+
+			expireAt, muted, err := g.Violation(ctx, state.ID)
+			if err != nil {
+				log.WithField("error", err.Error()).Error("Failed to mute user.")
+			}
+			if muted {
+				log.WithField("expireAt", expireAt).Info("User has been muted for flooding.")
+				userMuted(w, r, expireAt.Sub(g.clock.Now()))
+				return
+			}
+
+			rateLimited(w, retryAfter)
+		})
+	}
+}
+
+// userMuted responds to request of user, who is muted for given
+// duration.
+func userMuted(w http.ResponseWriter, r *http.Request, d time.Duration) {
+	seconds := int(math.Ceil(d.Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	writeError(w, r, http.StatusForbidden, ErrorReasonMuted, fmt.Sprintf(
+		"You have been muted and you can't send messages. Try again in %d seconds.", seconds,
+	))
+}
+
+// muteRejected responds to request of muted user. It reports whether
+// request has been rejected. Nobody is muted when given mute store
+// is nil.
+func muteRejected(w http.ResponseWriter, r *http.Request, log *logrus.Logger, mutes MuteStore, clock Clock, id string) bool {
+	if mutes == nil {
+		return false
+	}
+
+	expireAt, muted, err := mutes.Muted(r.Context(), id)
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"reqID":  middleware.GetReqID(r.Context()),
+			"userID": id,
+			"error":  err.Error(),
+		}).Error("Failed to check mute.")
+		writeError(w, r, http.StatusInternalServerError, ErrorReasonInternal, "Failed to check mute.")
+		return true
+	}
+	if muted {
+		userMuted(w, r, expireAt.Sub(clock.Now()))
+		return true
+	}
+
+	return false
+}
+
+// EventUserMuted is model for event of moderator muting or unmuting
+// user. ExpireAt is nil when user is unmuted.
+type EventUserMuted struct {
+	ID       string     `json:"id"`
+	UserID   string     `json:"userID"`
+	Muted    bool       `json:"muted"`
+	ExpireAt *time.Time `json:"expireAt,omitempty"`
+	At       time.Time  `json:"at"`
+}
+
+// HandlerMuteDependencies holds arguments for HandlerMute and
+// HandlerUnmute.
+type HandlerMuteDependencies struct {
+	Logger *logrus.Logger
+	Mutes  MuteStore
+	Sender *BridgeEventProducer[EventUserMuted]
+	Audit  *ModerationAudit
+
+	IDGenerator
+	Clock
+}
+
+// HandlerMute mutes user ID for given number of seconds. Muted users
+// can read the chat, but they can't send messages.
+func HandlerMute(deps HandlerMuteDependencies) http.HandlerFunc {
+	type request struct {
+		UserID  string `json:"userID"`
+		Seconds int64  `json:"seconds"`
+		Reason  string `json:"reason"`
+	}
+	type response struct {
+		UserID   string    `json:"userID"`
+		ExpireAt time.Time `json:"expireAt"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		log := deps.Logger.WithFields(logrus.Fields{
+			"reqID": middleware.GetReqID(ctx),
+		})
+
+		req := &request{}
+
+		defer r.Body.Close()
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			writeError(w, r, http.StatusBadRequest, ErrorReasonInvalidBody, "Failed to parse body.")
+			return
+		}
+
+		req.UserID = strings.TrimSpace(req.UserID)
+		if req.UserID == "" {
+			writeError(w, r, http.StatusBadRequest, ErrorReasonInvalidBody, "Mute requires user ID.")
+			return
+		}
+		if req.Seconds < 1 {
+			writeError(w, r, http.StatusBadRequest, ErrorReasonInvalidBody, "Mute duration has to be positive number of seconds.")
+			return
+		}
+
+		now := deps.Now()
+		expireAt := now.Add(time.Duration(req.Seconds) * time.Second)
+		if err := deps.Mutes.Mute(ctx, req.UserID, expireAt); err != nil {
+			log.WithField("error", err.Error()).Error("Failed to mute user.")
+			writeError(w, r, http.StatusInternalServerError, ErrorReasonInternal, "Failed to mute user. Please try again later.")
+			return
+		}
+
+		eventID := deps.GenerateID()
+		go deps.Sender.SendEvent(ctx, eventID, EventUserMuted{
+			ID:       eventID,
+			UserID:   req.UserID,
+			Muted:    true,
+			ExpireAt: &expireAt,
+			At:       now,
+		})
+
+		go deps.Audit.Record(ctx, ModerationMute, req.UserID, strings.TrimSpace(req.Reason))
+
+		log.WithField("muteID", req.UserID).Info("User has been muted.")
+		jsonResponse(w, http.StatusOK, responseWrapper{
+			Data: response{
+				UserID:   req.UserID,
+				ExpireAt: expireAt,
+			},
+		})
+	}
+}
+
+// HandlerUnmute lifts mute of user ID given in URL.
+func HandlerUnmute(deps HandlerMuteDependencies) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		log := deps.Logger.WithFields(logrus.Fields{
+			"reqID": middleware.GetReqID(ctx),
+		})
+
+		id := chi.URLParam(r, "userID")
+		if err := deps.Mutes.Unmute(ctx, id); err != nil {
+			log.WithField("error", err.Error()).Error("Failed to unmute user.")
+			writeError(w, r, http.StatusInternalServerError, ErrorReasonInternal, "Failed to unmute user. Please try again later.")
+			return
+		}
+
+		eventID := deps.GenerateID()
+		go deps.Sender.SendEvent(ctx, eventID, EventUserMuted{
+			ID:     eventID,
+			UserID: id,
+			Muted:  false,
+			At:     deps.Now(),
+		})
+
+		go deps.Audit.Record(ctx, ModerationUnmute, id, "")
+
+		log.WithField("muteID", id).Info("User has been unmuted.")
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,273 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fenole/szmaterlok/service/sse"
+	"github.com/go-chi/chi/v5"
+	"github.com/matryer/is"
+)
+
+func TestMuteStoreMemory(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+
+	clock, move := testMovingClock()
+	store := NewMuteStoreMemory(clock)
+
+	_, muted, err := store.Muted(ctx, "spammer")
+	is.NoErr(err)
+	is.True(!muted)
+
+	expireAt := clock.Now().Add(time.Minute)
+	is.NoErr(store.Mute(ctx, "spammer", expireAt))
+
+	got, muted, err := store.Muted(ctx, "spammer")
+	is.NoErr(err)
+	is.True(muted)
+	is.Equal(got, expireAt)
+
+	// Mute expires automatically.
+	move(time.Minute)
+	_, muted, err = store.Muted(ctx, "spammer")
+	is.NoErr(err)
+	is.True(!muted)
+
+	// Expired mutes are collected.
+	is.NoErr(store.Mute(ctx, "other", clock.Now().Add(time.Minute)))
+	is.Equal(len(store.mutes), 1)
+}
+
+func TestFloodGuardMiddleware(t *testing.T) {
+	const violations = 3
+
+	is := is.New(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	clock, move := testMovingClock()
+	limiter := NewRateLimiter(ctx, RateLimiterBuilder{
+		Rate:        1,
+		Burst:       1,
+		IdleTimeout: time.Minute,
+		Clock:       clock,
+	})
+	guard := NewFloodGuard(FloodGuardBuilder{
+		Mutes:      NewMuteStoreMemory(clock),
+		Violations: violations,
+		Window:     time.Minute,
+		Cooldown:   time.Minute * 5,
+		Clock:      clock,
+	})
+
+	h := FloodGuardMiddleware(testLogger(), limiter, guard)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	}))
+
+	send := func(id string) *httptest.ResponseRecorder {
+		r := requestWithSession(ctx, httptest.NewRequest(http.MethodPost, "/message", nil), &SessionState{
+			ID: id,
+		})
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	t.Run("escalation", func(t *testing.T) {
+		is := is.New(t)
+
+		is.Equal(send("spammer").Code, http.StatusAccepted)
+
+		// User is throttled first.
+		for i := 0; i < violations-1; i++ {
+			is.Equal(send("spammer").Code, http.StatusTooManyRequests)
+		}
+
+		// Then muted, even when rate limit would let them through.
+		w := send("spammer")
+		is.Equal(w.Code, http.StatusForbidden)
+		is.Equal(w.Header().Get("Retry-After"), "300")
+
+		res := responseWrapper{Error: &errorResponse{}}
+		is.NoErr(json.NewDecoder(w.Body).Decode(&res))
+		is.True(strings.Contains(res.Error.(*errorResponse).Message, "muted")) // reason should be given
+
+		move(time.Minute)
+		w = send("spammer")
+		is.Equal(w.Code, http.StatusForbidden)
+		is.Equal(w.Header().Get("Retry-After"), "240")
+
+		// Other users aren't affected.
+		is.Equal(send("other").Code, http.StatusAccepted)
+	})
+
+	t.Run("expiry", func(t *testing.T) {
+		is := is.New(t)
+
+		move(time.Minute * 4)
+		is.Equal(send("spammer").Code, http.StatusAccepted)
+
+		// Violations before mute aren't counted again.
+		is.Equal(send("spammer").Code, http.StatusTooManyRequests)
+	})
+
+	t.Run("violations outside window", func(t *testing.T) {
+		is := is.New(t)
+
+		for i := 0; i < violations*2; i++ {
+			move(time.Second * 30)
+			is.Equal(send("slow").Code, http.StatusAccepted)
+			is.Equal(send("slow").Code, http.StatusTooManyRequests)
+		}
+	})
+}
+
+func TestHandlerMute(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+	log := testLogger()
+
+	clock, move := testMovingClock()
+	mutes := NewMuteStoreMemory(clock)
+
+	messageHandler := NewBridgeMessageHandler(log)
+	bridgeRouter := NewBridgeEventRouter()
+	bridgeRouter.Hook(BridgeMessageSent, messageHandler)
+	bridgeRouter.Hook(BridgeUserMuted, messageHandler)
+
+	bridge := NewBridge(ctx, BridgeBuilder{
+		Handler: bridgeRouter,
+		Logger:  log,
+		Storage: newBridgeStorageMock(),
+	})
+	defer bridge.Shutdown(ctx)
+
+	evts := make(chan sse.Event, 4)
+	unsubscribe := messageHandler.Subscribe(ctx, MessageSubscribeRequest{
+		ID:        "spammer",
+		RequestID: "req",
+		Channel:   evts,
+	})
+	defer unsubscribe()
+
+	receive := func() sse.Event {
+		t.Helper()
+		select {
+		case evt := <-evts:
+			return evt
+		case <-time.After(time.Second):
+			t.Fatal("event has not been delivered")
+		}
+		return sse.Event{}
+	}
+
+	muteDeps := HandlerMuteDependencies{
+		Logger: log,
+		Mutes:  mutes,
+		Sender: &BridgeEventProducer[EventUserMuted]{
+			EventBridge: bridge,
+			Type:        BridgeUserMuted,
+			Log:         log,
+			Clock:       clock,
+		},
+		IDGenerator: testIDGenerator(),
+		Clock:       clock,
+	}
+	router := chi.NewRouter()
+	router.Post("/admin/mute", HandlerMute(muteDeps))
+	router.Delete("/admin/mute/{userID}", HandlerUnmute(muteDeps))
+	router.Post("/message", HandlerSendMessage(HandlerSendMessageDependencies{
+		MaxMessageSize: NewMessageSizeLimit(255),
+		Sender: &BridgeEventProducer[EventSentMessage]{
+			EventBridge: bridge,
+			Type:        BridgeMessageSent,
+			Log:         log,
+			Clock:       clock,
+		},
+		Mutes:       mutes,
+		Logger:      log,
+		IDGenerator: testIDGenerator(),
+		Clock:       clock,
+	}))
+
+	request := func(method, target, body string) int {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(method, target, strings.NewReader(body)))
+		return w.Code
+	}
+	send := func(id string) int {
+		r := requestWithSession(ctx, httptest.NewRequest(http.MethodPost, "/message", strings.NewReader(`{"content":"hello"}`)), &SessionState{
+			ID:       id,
+			Nickname: id,
+		})
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		return w.Code
+	}
+	mutedEvent := func() EventUserMuted {
+		t.Helper()
+		evt := receive()
+		is.Equal(evt.Type, string(BridgeUserMuted))
+
+		data := EventUserMuted{}
+		is.NoErr(json.Unmarshal(evt.Data, &data))
+		return data
+	}
+
+	t.Run("enforcement", func(t *testing.T) {
+		is := is.New(t)
+
+		is.Equal(request(http.MethodPost, "/admin/mute", `{"userID":"spammer","seconds":60}`), http.StatusOK)
+
+		evt := mutedEvent()
+		is.Equal(evt.UserID, "spammer")
+		is.True(evt.Muted)
+		is.Equal(*evt.ExpireAt, clock.Now().Add(time.Minute))
+
+		is.Equal(send("spammer"), http.StatusForbidden)
+		is.Equal(send("other"), http.StatusAccepted)
+
+		// Muted user still receives messages of others.
+		is.Equal(receive().Type, MessageSent)
+	})
+
+	t.Run("expiry", func(t *testing.T) {
+		is := is.New(t)
+
+		move(time.Minute)
+		is.Equal(send("spammer"), http.StatusAccepted)
+		receive()
+	})
+
+	t.Run("unmute", func(t *testing.T) {
+		is := is.New(t)
+
+		is.Equal(request(http.MethodPost, "/admin/mute", `{"userID":"spammer","seconds":3600}`), http.StatusOK)
+		mutedEvent()
+		is.Equal(send("spammer"), http.StatusForbidden)
+
+		is.Equal(request(http.MethodDelete, "/admin/mute/spammer", ""), http.StatusNoContent)
+
+		evt := mutedEvent()
+		is.Equal(evt.UserID, "spammer")
+		is.True(!evt.Muted)
+		is.Equal(evt.ExpireAt, nil)
+
+		is.Equal(send("spammer"), http.StatusAccepted)
+		is.Equal(receive().Type, MessageSent)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		is := is.New(t)
+
+		is.Equal(request(http.MethodPost, "/admin/mute", `{"userID":" ","seconds":60}`), http.StatusBadRequest)
+		is.Equal(request(http.MethodPost, "/admin/mute", `{"userID":"spammer","seconds":0}`), http.StatusBadRequest)
+		is.Equal(request(http.MethodPost, "/admin/mute", `{`), http.StatusBadRequest)
+	})
+}
@@ -0,0 +1,204 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/sirupsen/logrus"
+)
+
+// PresenceStore stores presence status of online users.
+type PresenceStore interface {
+	// SetStatus sets presence status of user with given ID at given
+	// time and reports whether it has changed. It returns ErrNoSuchUser
+	// if user is not online and ErrInvalidPresenceStatus for unknown
+	// statuses.
+	SetStatus(ctx context.Context, id, status string, at time.Time) (bool, error)
+}
+
+// PresenceHeartbeater records heartbeats of event streams of online
+// users.
+type PresenceHeartbeater interface {
+	// Heartbeat records that event stream of user with given ID is
+	// still open at given time. It returns ErrNoSuchUser if user is
+	// not online.
+	Heartbeat(ctx context.Context, id string, at time.Time) error
+}
+
+// PresenceSweeper marks idle users as away and announces every change
+// of presence status to chat clients. It also removes users, whose
+// event streams have stopped sending heartbeats, and announces that
+// they have left the chat.
+type PresenceSweeper struct {
+	Users    *StateOnlineUsers
+	Producer *BridgeEventProducer[EventUserPresence]
+
+	// UserLeftProducer sends user-left events of expired users.
+	UserLeftProducer *BridgeEventProducer[EventUserLeft]
+
+	// IdleTimeout is period of inactivity after which user is marked
+	// as away. Zero disables away status.
+	IdleTimeout time.Duration
+
+	// TTL is period without heartbeat after which user is removed.
+	// Zero disables expiration of users.
+	TTL time.Duration
+
+	Clock
+	IDGenerator
+}
+
+// Sweep updates presence status of online users and sends presence
+// event for every user whose status has changed. Then it removes
+// expired users and sends user-left event for every one of them.
+func (p *PresenceSweeper) Sweep(ctx context.Context) {
+	now := p.Now()
+
+	if p.IdleTimeout > 0 {
+		for _, u := range p.Users.Sweep(ctx, now, p.IdleTimeout) {
+			id := p.GenerateID()
+			p.Producer.SendEvent(ctx, id, EventUserPresence{
+				ID:     id,
+				User:   UserPresentation(u.ID, u.Nickname),
+				Status: u.Status,
+				At:     now,
+			})
+		}
+	}
+
+	if p.TTL > 0 {
+		for _, u := range p.Users.Expire(ctx, now, p.TTL) {
+			id := p.GenerateID()
+			p.UserLeftProducer.SendEvent(ctx, id, EventUserLeft{
+				ID:     id,
+				User:   UserPresentation(u.ID, u.Nickname),
+				LeftAt: now,
+			})
+		}
+	}
+}
+
+// Run sweeps presence status of online users with given interval. It
+// runs until given context is done, so the context should be cancelled
+// before shutting down event bridge.
+func (p *PresenceSweeper) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			p.Sweep(ctx)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// HandlerPresenceDependencies holds behavioral dependencies for
+// http handler for setting presence status.
+type HandlerPresenceDependencies struct {
+	Logger *logrus.Logger
+	Users  PresenceStore
+	Sender *BridgeEventProducer[EventUserPresence]
+
+	IDGenerator
+	Clock
+}
+
+// HandlerPresence sets presence status of current user. Change of
+// status is announced to all chat clients.
+func HandlerPresence(deps HandlerPresenceDependencies) http.HandlerFunc {
+	type request struct {
+		Status string `json:"status"`
+	}
+	type response struct {
+		Status string `json:"status"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		log := deps.Logger.WithFields(logrus.Fields{
+			"reqID": middleware.GetReqID(ctx),
+		})
+
+		state := SessionContextState(ctx)
+		if state == nil {
+			jsonResponse(w, http.StatusForbidden, responseWrapper{
+				Error: errorResponse{
+					Code:    http.StatusForbidden,
+					Reason:  ErrorReasonUnauthenticated,
+					Message: "Setting presence status requires authentication.",
+				},
+			})
+			return
+		}
+
+		req := &request{}
+
+		defer r.Body.Close()
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			jsonResponse(w, http.StatusBadRequest, responseWrapper{
+				Error: errorResponse{
+					Code:    http.StatusBadRequest,
+					Reason:  ErrorReasonInvalidBody,
+					Message: "Failed to parse body.",
+				},
+			})
+			return
+		}
+
+		now := deps.Now()
+		changed, err := deps.Users.SetStatus(ctx, state.ID, req.Status, now)
+		switch {
+		case errors.Is(err, ErrInvalidPresenceStatus):
+			jsonResponse(w, http.StatusBadRequest, responseWrapper{
+				Error: errorResponse{
+					Code:    http.StatusBadRequest,
+					Reason:  ErrorReasonInvalidStatus,
+					Message: "Presence status has to be either online or away.",
+				},
+			})
+			return
+		case errors.Is(err, ErrNoSuchUser):
+			jsonResponse(w, http.StatusNotFound, responseWrapper{
+				Error: errorResponse{
+					Code:    http.StatusNotFound,
+					Reason:  ErrorReasonUserOffline,
+					Message: "User is not online.",
+				},
+			})
+			return
+		case err != nil:
+			log.WithField("error", err.Error()).Error("Failed to set presence status.")
+			jsonResponse(w, http.StatusInternalServerError, responseWrapper{
+				Error: errorResponse{
+					Code:    http.StatusInternalServerError,
+					Reason:  ErrorReasonInternal,
+					Message: "Failed to set presence status. Please try again later.",
+				},
+			})
+			return
+		}
+
+		if changed {
+			eventID := deps.GenerateID()
+			go deps.Sender.SendEvent(ctx, eventID, EventUserPresence{
+				ID:     eventID,
+				User:   UserPresentation(state.ID, state.Nickname),
+				Status: req.Status,
+				At:     now,
+			})
+		}
+
+		jsonResponse(w, http.StatusOK, responseWrapper{
+			Data: response{
+				Status: req.Status,
+			},
+		})
+	}
+}
@@ -0,0 +1,342 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+
+	"github.com/fenole/szmaterlok/service/sse"
+)
+
+func TestStateOnlineUsersPresence(t *testing.T) {
+	type testArgs struct {
+		name string
+
+		// setStatus is status set explicitly right after joining.
+		setStatus string
+
+		// activeAfter is time after joining, when user sends
+		// a message. Zero means that user is not active.
+		activeAfter time.Duration
+
+		sweepAfter time.Duration
+		status     string
+		changed    bool
+	}
+
+	idle := time.Minute * 5
+
+	scenario := func(tt testArgs) (string, func(*testing.T)) {
+		return tt.name, func(t *testing.T) {
+			is := is.New(t)
+			ctx := context.Background()
+			joinedAt := testClock().Now()
+
+			state := NewStateOnlineUsers()
+			is.NoErr(state.PushChatUser(ctx, StateChatUser{
+				ID:              "1",
+				Nickname:        "karol",
+				LastSeen:        joinedAt,
+				StatusChangedAt: joinedAt,
+			}))
+
+			if tt.setStatus != "" {
+				_, err := state.SetStatus(ctx, "1", tt.setStatus, joinedAt)
+				is.NoErr(err)
+			}
+			if tt.activeAfter > 0 {
+				is.NoErr(state.Touch(ctx, "1", joinedAt.Add(tt.activeAfter)))
+			}
+
+			changed := state.Sweep(ctx, joinedAt.Add(tt.sweepAfter), idle)
+			is.Equal(len(changed) == 1, tt.changed)
+
+			u, err := state.ChatUser(ctx, "1")
+			is.NoErr(err)
+			is.Equal(u.Status, tt.status)
+		}
+	}
+
+	t.Run(scenario(testArgs{
+		name:       "active user",
+		sweepAfter: time.Minute,
+		status:     PresenceOnline,
+	}))
+	t.Run(scenario(testArgs{
+		name:       "idle user",
+		sweepAfter: idle,
+		status:     PresenceAway,
+		changed:    true,
+	}))
+	t.Run(scenario(testArgs{
+		name:        "user active before idle period",
+		activeAfter: time.Minute * 4,
+		sweepAfter:  time.Minute * 6,
+		status:      PresenceOnline,
+	}))
+	t.Run(scenario(testArgs{
+		name:       "away user",
+		setStatus:  PresenceAway,
+		sweepAfter: time.Minute,
+		status:     PresenceAway,
+	}))
+	t.Run(scenario(testArgs{
+		name:        "away user sending message",
+		setStatus:   PresenceAway,
+		activeAfter: time.Minute,
+		sweepAfter:  time.Minute * 2,
+		status:      PresenceOnline,
+		changed:     true,
+	}))
+
+	t.Run("SetStatus", func(t *testing.T) {
+		is := is.New(t)
+		ctx := context.Background()
+		now := testClock().Now()
+
+		state := NewStateOnlineUsers()
+		is.NoErr(state.PushChatUser(ctx, StateChatUser{ID: "1", Nickname: "karol"}))
+
+		changed, err := state.SetStatus(ctx, "1", PresenceAway, now)
+		is.NoErr(err)
+		is.True(changed)
+
+		changed, err = state.SetStatus(ctx, "1", PresenceAway, now)
+		is.NoErr(err)
+		is.True(!changed)
+
+		_, err = state.SetStatus(ctx, "1", "busy", now)
+		is.Equal(err, ErrInvalidPresenceStatus)
+
+		_, err = state.SetStatus(ctx, "2", PresenceOnline, now)
+		is.Equal(err, ErrNoSuchUser)
+	})
+}
+
+func TestHandlerPresence(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+	log := testLogger()
+
+	messageHandler := NewBridgeMessageHandler(log)
+	router := NewBridgeEventRouter()
+	router.Hook(BridgeUserPresence, messageHandler)
+
+	storage := newBridgeStorageMock()
+	bridge := NewBridge(ctx, BridgeBuilder{
+		Handler: router,
+		Logger:  log,
+		Storage: storage,
+	})
+
+	evts := make(chan sse.Event, 1)
+	unsubscribe := messageHandler.Subscribe(ctx, MessageSubscribeRequest{
+		ID:        "other",
+		RequestID: "req",
+		Channel:   evts,
+	})
+	defer unsubscribe()
+
+	state := NewStateOnlineUsers()
+	is.NoErr(state.PushChatUser(ctx, StateChatUser{ID: "id", Nickname: "nickname"}))
+
+	producer := &BridgeEventProducer[EventUserPresence]{
+		EventBridge: bridge,
+		Type:        BridgeUserPresence,
+		Log:         log,
+		Clock:       testClock(),
+	}
+	h := HandlerPresence(HandlerPresenceDependencies{
+		Logger:      log,
+		Users:       state,
+		Sender:      producer,
+		IDGenerator: testIDGenerator(),
+		Clock:       testClock(),
+	})
+
+	request := func(id, body string) int {
+		r := requestWithSession(ctx, httptest.NewRequest(http.MethodPost, "/presence", strings.NewReader(body)), &SessionState{
+			ID:       id,
+			Nickname: "nickname",
+		})
+		w := httptest.NewRecorder()
+		h(w, r)
+		return w.Code
+	}
+	presence := func() EventUserPresence {
+		select {
+		case evt := <-evts:
+			is.Equal(evt.Type, string(BridgeUserPresence))
+
+			data := EventUserPresence{}
+			is.NoErr(json.Unmarshal(evt.Data, &data))
+			return data
+		case <-time.After(time.Second):
+			t.Fatal("presence event has not been delivered")
+		}
+		return EventUserPresence{}
+	}
+
+	is.Equal(request("id", `{"status":"away"}`), http.StatusOK)
+	data := presence()
+	is.Equal(data.User, UserPresentation("id", "nickname"))
+	is.Equal(data.Status, PresenceAway)
+
+	u, err := state.ChatUser(ctx, "id")
+	is.NoErr(err)
+	is.Equal(u.Status, PresenceAway)
+
+	is.Equal(request("id", `{"status":"busy"}`), http.StatusBadRequest)
+	is.Equal(request("offline", `{"status":"away"}`), http.StatusNotFound)
+
+	// Idle users are marked as away by sweeper.
+	is.NoErr(state.PushChatUser(ctx, StateChatUser{
+		ID:       "idle",
+		Nickname: "idle",
+		LastSeen: testClock().Now().Add(-time.Hour),
+	}))
+	sweeper := &PresenceSweeper{
+		Users:       state,
+		Producer:    producer,
+		IdleTimeout: time.Minute,
+		Clock:       testClock(),
+		IDGenerator: testIDGenerator(),
+	}
+	sweeper.Sweep(ctx)
+	data = presence()
+	is.Equal(data.User, UserPresentation("idle", "idle"))
+	is.Equal(data.Status, PresenceAway)
+
+	bridge.Shutdown(ctx)
+
+	// Presence events are ephemeral.
+	is.Equal(len(storage.Events()), 0)
+}
+
+func TestPresenceSweeperExpire(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+	log := testLogger()
+
+	clock, move := testMovingClock()
+	state := NewStateOnlineUsers()
+
+	router := NewBridgeEventRouter()
+	router.Hook(BridgeUserLeft, StateUserLeftHook(log, state))
+
+	left := make(chan EventUserLeft, 2)
+	router.Hook(BridgeUserLeft, BridgeEventHandlerFunc(func(ctx context.Context, evt BridgeEvent) {
+		data := EventUserLeft{}
+		is.NoErr(json.Unmarshal(evt.Data, &data))
+		left <- data
+	}))
+
+	bridge := NewBridge(ctx, BridgeBuilder{
+		Handler: router,
+		Logger:  log,
+		Storage: newBridgeStorageMock(),
+	})
+	defer bridge.Shutdown(ctx)
+
+	// Ghost has two connections, which have been dropped without
+	// closing their event streams.
+	for _, u := range []StateChatUser{
+		{ID: "ghost", Nickname: "ghost", LastSeen: clock.Now()},
+		{ID: "ghost", Nickname: "ghost", LastSeen: clock.Now()},
+		{ID: "alive", Nickname: "alive", LastSeen: clock.Now()},
+	} {
+		is.NoErr(state.PushChatUser(ctx, u))
+	}
+
+	sweeper := &PresenceSweeper{
+		Users: state,
+		UserLeftProducer: &BridgeEventProducer[EventUserLeft]{
+			EventBridge: bridge,
+			Type:        BridgeUserLeft,
+			Log:         log,
+			Clock:       clock,
+		},
+		TTL:         time.Minute,
+		Clock:       clock,
+		IDGenerator: testIDGenerator(),
+	}
+
+	move(time.Second * 40)
+	is.NoErr(state.Heartbeat(ctx, "alive", clock.Now()))
+	sweeper.Sweep(ctx)
+	is.Equal(state.Count(ctx), 2) // nobody should be expired before ttl
+
+	move(time.Second * 20)
+	sweeper.Sweep(ctx)
+
+	select {
+	case data := <-left:
+		is.Equal(data.User, UserPresentation("ghost", "ghost"))
+		is.Equal(data.LeftAt, clock.Now())
+	case <-time.After(time.Second):
+		t.Fatal("user-left event has not been sent")
+	}
+
+	_, err := state.ChatUser(ctx, "ghost")
+	is.Equal(err, ErrNoSuchUser)
+	_, err = state.ChatUser(ctx, "alive")
+	is.NoErr(err)
+
+	// Ghost connection closed after expiration doesn't affect state.
+	is.Equal(state.RemoveChatUser(ctx, "ghost"), ErrNoSuchUser)
+	is.Equal(state.Heartbeat(ctx, "ghost", clock.Now()), ErrNoSuchUser)
+
+	// Sweeper without idle timeout doesn't mark anyone as away.
+	u, err := state.ChatUser(ctx, "alive")
+	is.NoErr(err)
+	is.Equal(u.Status, PresenceOnline)
+}
+
+func TestHandlerStreamPresence(t *testing.T) {
+	is := is.New(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	clock, move := testMovingClock()
+	state := NewStateOnlineUsers()
+	is.NoErr(state.PushChatUser(ctx, StateChatUser{ID: "id", Nickname: "nickname", LastSeen: clock.Now()}))
+
+	r := requestWithSession(ctx, httptest.NewRequest(http.MethodGet, "/stream", nil), &SessionState{
+		ID:       "id",
+		Nickname: "nickname",
+	})
+
+	h := HandlerStream(HandlerStreamDependencies{
+		Presence:         state,
+		PresenceInterval: time.Millisecond,
+		MessageNotifier: MessageNotifierFunc(func(context.Context, MessageSubscribeRequest) func() {
+			return func() {}
+		}),
+		Clock: clock,
+	})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		h(newStreamRecorder(), r)
+	}()
+
+	// Open stream keeps user from being expired.
+	move(time.Hour)
+	waitFor(t, time.Second, func() bool {
+		state.mtx.Lock()
+		defer state.mtx.Unlock()
+		return state.state["id"].HeartbeatAt.Equal(clock.Now())
+	})
+	is.Equal(len(state.Expire(ctx, clock.Now(), time.Minute)), 0)
+
+	cancel()
+	<-done
+}
@@ -0,0 +1,174 @@
+package service
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type rateLimiterBucket struct {
+	tokens   float64
+	updated  time.Time
+	lastSeen time.Time
+}
+
+// RateLimiter is token bucket rate limiter with separate bucket for
+// every key. Buckets which haven't been used for a while are dropped
+// by background sweeper.
+type RateLimiter struct {
+	rate    float64
+	burst   float64
+	idle    time.Duration
+	buckets map[string]*rateLimiterBucket
+	mtx     *sync.Mutex
+	clock   Clock
+}
+
+// RateLimiterBuilder holds arguments for building rate limiter.
+type RateLimiterBuilder struct {
+	// Rate is number of tokens added to every bucket per second.
+	Rate float64
+
+	// Burst is capacity of single bucket.
+	Burst int
+
+	// IdleTimeout is period after which unused bucket is dropped.
+	IdleTimeout time.Duration
+
+	// SweepInterval is period of sweeper runs.
+	SweepInterval time.Duration
+
+	Clock
+}
+
+// NewRateLimiter returns rate limiter and starts its sweeper. Sweeper
+// runs until given context is done.
+func NewRateLimiter(ctx context.Context, b RateLimiterBuilder) *RateLimiter {
+	l := &RateLimiter{
+		rate:    b.Rate,
+		burst:   float64(b.Burst),
+		idle:    b.IdleTimeout,
+		buckets: make(map[string]*rateLimiterBucket),
+		mtx:     &sync.Mutex{},
+		clock:   b.Clock,
+	}
+
+	if b.SweepInterval > 0 {
+		go l.sweeper(ctx, b.SweepInterval)
+	}
+
+	return l
+}
+
+// Allow takes single token from bucket of given key. When bucket is
+// empty, Allow returns false and time after which next token will
+// be available.
+func (l *RateLimiter) Allow(key string) (bool, time.Duration) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	now := l.clock.Now()
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &rateLimiterBucket{
+			tokens:  l.burst,
+			updated: now,
+		}
+		l.buckets[key] = b
+	}
+	b.lastSeen = now
+
+	elapsed := now.Sub(b.updated).Seconds()
+	b.tokens = math.Min(l.burst, b.tokens+elapsed*l.rate)
+	b.updated = now
+
+	if b.tokens < 1 {
+		missing := (1 - b.tokens) / l.rate
+		return false, time.Duration(missing * float64(time.Second))
+	}
+
+	b.tokens--
+	return true, 0
+}
+
+// SetLimits changes rate and burst of every bucket. Tokens collected
+// by buckets above new burst are dropped.
+func (l *RateLimiter) SetLimits(rate float64, burst int) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	l.rate = rate
+	l.burst = float64(burst)
+	for _, b := range l.buckets {
+		b.tokens = math.Min(l.burst, b.tokens)
+	}
+}
+
+// Sweep drops buckets which haven't been used for longer than
+// idle timeout.
+func (l *RateLimiter) Sweep() {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	now := l.clock.Now()
+	for key, b := range l.buckets {
+		if now.Sub(b.lastSeen) > l.idle {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+func (l *RateLimiter) sweeper(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			l.Sweep()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// RateLimitMiddleware limits requests of every session with given
+// rate limiter. It has to be used after SessionRequired middleware.
+// Limited requests are rejected with 429 status code and Retry-After
+// header.
+func RateLimitMiddleware(l *RateLimiter) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			state := SessionContextState(r.Context())
+			if state == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			ok, retryAfter := l.Allow(state.ID)
+			if !ok {
+				rateLimited(w, retryAfter)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// rateLimited responds to request rejected by rate limiter. Client
+// can retry after given duration.
+func rateLimited(w http.ResponseWriter, retryAfter time.Duration) {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	jsonResponse(w, http.StatusTooManyRequests, responseWrapper{
+		Error: errorResponse{
+			Code:    http.StatusTooManyRequests,
+			Reason:  ErrorReasonRateLimited,
+			Message: "Too many requests. Please slow down.",
+		},
+	})
+}
@@ -0,0 +1,107 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+)
+
+// testMovingClock returns clock, which time can be moved forward
+// with returned function.
+func testMovingClock() (Clock, func(time.Duration)) {
+	mtx := &sync.Mutex{}
+	now := testClock().Now()
+
+	clock := ClockFunc(func() time.Time {
+		mtx.Lock()
+		defer mtx.Unlock()
+		return now
+	})
+	move := func(d time.Duration) {
+		mtx.Lock()
+		defer mtx.Unlock()
+		now = now.Add(d)
+	}
+
+	return clock, move
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	const burst = 3
+
+	is := is.New(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	clock, move := testMovingClock()
+	limiter := NewRateLimiter(ctx, RateLimiterBuilder{
+		Rate:        0.5,
+		Burst:       burst,
+		IdleTimeout: time.Minute,
+		Clock:       clock,
+	})
+
+	h := RateLimitMiddleware(limiter)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	}))
+
+	send := func(id string) *httptest.ResponseRecorder {
+		r := requestWithSession(ctx, httptest.NewRequest(http.MethodPost, "/message", nil), &SessionState{
+			ID: id,
+		})
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	for i := 0; i < burst; i++ {
+		is.Equal(send("spammer").Code, http.StatusAccepted)
+	}
+
+	w := send("spammer")
+	is.Equal(w.Code, http.StatusTooManyRequests)
+	is.Equal(w.Header().Get("Retry-After"), "2")
+
+	res := struct {
+		Error errorResponse `json:"error"`
+	}{}
+	is.NoErr(json.NewDecoder(w.Body).Decode(&res))
+	is.Equal(res.Error.Reason, ErrorReasonRateLimited)
+
+	// Other sessions have their own buckets.
+	is.Equal(send("other").Code, http.StatusAccepted)
+
+	// Single token is added after two seconds.
+	move(time.Second * 2)
+	is.Equal(send("spammer").Code, http.StatusAccepted)
+	is.Equal(send("spammer").Code, http.StatusTooManyRequests)
+}
+
+func TestRateLimiterSweep(t *testing.T) {
+	is := is.New(t)
+
+	clock, move := testMovingClock()
+	limiter := NewRateLimiter(context.Background(), RateLimiterBuilder{
+		Rate:        1,
+		Burst:       1,
+		IdleTimeout: time.Minute,
+		Clock:       clock,
+	})
+
+	limiter.Allow("idle")
+	move(time.Second * 30)
+	limiter.Allow("active")
+
+	move(time.Second * 31)
+	limiter.Sweep()
+
+	is.Equal(len(limiter.buckets), 1)
+	_, ok := limiter.buckets["active"]
+	is.True(ok)
+}
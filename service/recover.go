@@ -0,0 +1,52 @@
+package service
+
+import (
+	"fmt"
+	"net/http"
+	"runtime/debug"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/sirupsen/logrus"
+)
+
+// RecovererMiddleware recovers from panics of next handlers. Panic
+// is logged along with its stack trace and client receives JSON
+// response with 500 status code and request ID, which can be used
+// to find corresponding log entry.
+func RecovererMiddleware(log *logrus.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			defer func() {
+				rvr := recover()
+				if rvr == nil {
+					return
+				}
+				// Aborted handlers are handled by http server.
+				if rvr == http.ErrAbortHandler {
+					panic(rvr)
+				}
+
+				reqID := middleware.GetReqID(r.Context())
+				log.WithFields(logrus.Fields{
+					"reqID": reqID,
+					"panic": fmt.Sprint(rvr),
+					"stack": string(debug.Stack()),
+				}).Error("Recovered from panic.")
+
+				if reqID != "" {
+					w.Header().Set(middleware.RequestIDHeader, reqID)
+				}
+				jsonResponse(w, http.StatusInternalServerError, responseWrapper{
+					Error: errorResponse{
+						Code:      http.StatusInternalServerError,
+						Reason:    ErrorReasonInternal,
+						Message:   "Internal server error.",
+						RequestID: reqID,
+					},
+				})
+			}()
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,65 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/matryer/is"
+	"github.com/sirupsen/logrus"
+)
+
+func TestRecovererMiddleware(t *testing.T) {
+	is := is.New(t)
+
+	logs := &bytes.Buffer{}
+	log := logrus.New()
+	log.SetOutput(logs)
+	log.SetFormatter(&logrus.JSONFormatter{})
+
+	h := middleware.RequestID(RecovererMiddleware(log)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	})))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/message", nil))
+	is.Equal(w.Code, http.StatusInternalServerError)
+	is.True(strings.HasPrefix(w.Header().Get("Content-Type"), "application/json"))
+
+	res := struct {
+		Error struct {
+			Code      int    `json:"code"`
+			Message   string `json:"message"`
+			RequestID string `json:"requestID"`
+		} `json:"error"`
+	}{}
+	is.NoErr(json.NewDecoder(w.Body).Decode(&res))
+	is.Equal(res.Error.Code, http.StatusInternalServerError)
+	is.True(res.Error.Message != "")
+	is.True(res.Error.RequestID != "")                                        // request ID is missing in body
+	is.Equal(w.Header().Get(middleware.RequestIDHeader), res.Error.RequestID) // request ID is missing in header
+
+	entry := map[string]interface{}{}
+	is.NoErr(json.Unmarshal(logs.Bytes(), &entry))
+	is.Equal(entry["level"], "error")
+	is.Equal(entry["reqID"], res.Error.RequestID)
+	is.Equal(entry["panic"], "boom")
+	is.True(strings.Contains(entry["stack"].(string), "TestRecovererMiddleware")) // stack trace is missing
+}
+
+func TestRecovererMiddlewareAbort(t *testing.T) {
+	is := is.New(t)
+
+	h := RecovererMiddleware(testLogger())(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic(http.ErrAbortHandler)
+	}))
+
+	defer func() {
+		is.Equal(recover(), http.ErrAbortHandler) // abort panic should be passed to server
+	}()
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+}
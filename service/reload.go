@@ -0,0 +1,137 @@
+package service
+
+import (
+	"reflect"
+	"sync"
+	"sync/atomic"
+
+	"github.com/sirupsen/logrus"
+)
+
+// MessageSizeLimit is maximal number of characters of single message.
+// It can be changed while szmaterlok is running.
+type MessageSizeLimit struct {
+	size *atomic.Int64
+}
+
+// NewMessageSizeLimit returns message size limit of given size.
+func NewMessageSizeLimit(size int) *MessageSizeLimit {
+	l := &MessageSizeLimit{
+		size: &atomic.Int64{},
+	}
+	l.size.Store(int64(size))
+	return l
+}
+
+// Size returns maximal number of characters of single message.
+func (l *MessageSizeLimit) Size() int {
+	return int(l.size.Load())
+}
+
+// SetSize changes maximal number of characters of single message.
+func (l *MessageSizeLimit) SetSize(size int) {
+	l.size.Store(int64(size))
+}
+
+// ConfigReloader applies config values, which can safely change while
+// szmaterlok is running: log level, message rate limits and maximal
+// message size. Changes of other values are logged with a warning,
+// because they require restart.
+type ConfigReloader struct {
+	log         *logrus.Logger
+	messageSize *MessageSizeLimit
+	rateLimiter *RateLimiter
+
+	// current is config currently used by szmaterlok.
+	current ConfigVariables
+	mtx     *sync.Mutex
+}
+
+// ConfigReloaderBuilder holds build arguments for ConfigReloader.
+type ConfigReloaderBuilder struct {
+	// Config is config used at startup.
+	Config ConfigVariables
+
+	Logger      *logrus.Logger
+	MessageSize *MessageSizeLimit
+
+	// MessageRateLimiter is nil, when messages aren't rate limited.
+	MessageRateLimiter *RateLimiter
+}
+
+// NewConfigReloader is default and safe constructor for ConfigReloader.
+func NewConfigReloader(b ConfigReloaderBuilder) *ConfigReloader {
+	return &ConfigReloader{
+		log:         b.Logger,
+		messageSize: b.MessageSize,
+		rateLimiter: b.MessageRateLimiter,
+		current:     b.Config,
+		mtx:         &sync.Mutex{},
+	}
+}
+
+// configReloadable lists fields of ConfigVariables, which are applied
+// by ConfigReloader.
+var configReloadable = map[string]bool{
+	"LogLevel":           true,
+	"MaximumMessageSize": true,
+	"MessageRate":        true,
+	"MessageBurst":       true,
+}
+
+// Reload validates and applies given config. Invalid config is
+// rejected as a whole.
+func (r *ConfigReloader) Reload(next ConfigVariables) error {
+	if err := ConfigValidate(next); err != nil {
+		return err
+	}
+
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	if next.LogLevel != r.current.LogLevel {
+		r.log.SetLevel(next.LogLevel)
+		r.log.WithField("level", next.LogLevel.String()).Info("Log level has been reloaded.")
+	}
+
+	if next.MaximumMessageSize != r.current.MaximumMessageSize {
+		r.messageSize.SetSize(next.MaximumMessageSize)
+		r.log.WithField("size", next.MaximumMessageSize).Info("Maximal message size has been reloaded.")
+	}
+
+	if next.MessageRate != r.current.MessageRate || next.MessageBurst != r.current.MessageBurst {
+		// Rate limiter is created only at startup, so rate limiting
+		// can't be turned on or off without restart.
+		if r.rateLimiter == nil || next.MessageRate <= 0 {
+			r.log.Warn("Turning message rate limiting on or off requires restart.")
+			next.MessageRate = r.current.MessageRate
+			next.MessageBurst = r.current.MessageBurst
+		} else {
+			r.rateLimiter.SetLimits(next.MessageRate, next.MessageBurst)
+			r.log.WithFields(logrus.Fields{
+				"rate":  next.MessageRate,
+				"burst": next.MessageBurst,
+			}).Info("Message rate limits have been reloaded.")
+		}
+	}
+
+	current := reflect.ValueOf(r.current)
+	changed := reflect.ValueOf(next)
+	for i := 0; i < current.NumField(); i++ {
+		name := current.Type().Field(i).Name
+		if configReloadable[name] {
+			continue
+		}
+
+		if !reflect.DeepEqual(current.Field(i).Interface(), changed.Field(i).Interface()) {
+			r.log.WithField("field", name).Warn("Config change requires restart.")
+
+			// Value is still in use, so it's reported again with
+			// next reload.
+			reflect.ValueOf(&next).Elem().Field(i).Set(current.Field(i))
+		}
+	}
+
+	r.current = next
+	return nil
+}
@@ -0,0 +1,115 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/matryer/is"
+	"github.com/sirupsen/logrus"
+)
+
+func TestConfigReloader(t *testing.T) {
+	is := is.New(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	config := ConfigDefault()
+	config.MessageRate = 1
+	config.MessageBurst = 1
+
+	log := testLogger()
+	log.SetLevel(config.LogLevel)
+
+	messageSize := NewMessageSizeLimit(config.MaximumMessageSize)
+	clock, _ := testMovingClock()
+	limiter := NewRateLimiter(ctx, RateLimiterBuilder{
+		Rate:  config.MessageRate,
+		Burst: config.MessageBurst,
+		Clock: clock,
+	})
+
+	reloader := NewConfigReloader(ConfigReloaderBuilder{
+		Config:             config,
+		Logger:             log,
+		MessageSize:        messageSize,
+		MessageRateLimiter: limiter,
+	})
+
+	// allowed returns number of allowed requests out of 5 sent at
+	// once by user with given ID.
+	allowed := func(id string) int {
+		res := 0
+		for i := 0; i < 5; i++ {
+			if ok, _ := limiter.Allow(id); ok {
+				res++
+			}
+		}
+		return res
+	}
+	is.Equal(allowed("1"), 1)
+
+	next := config
+	next.LogLevel = logrus.DebugLevel
+	next.MaximumMessageSize = 16
+	next.MessageBurst = 3
+	next.Address = ":9090"
+	is.NoErr(reloader.Reload(next))
+
+	// Live logger uses reloaded log level.
+	is.Equal(log.GetLevel(), logrus.DebugLevel)
+	is.Equal(messageSize.Size(), 16)
+	is.Equal(allowed("2"), 3)
+
+	// Invalid config is rejected as a whole.
+	invalid := next
+	invalid.LogLevel = logrus.ErrorLevel
+	invalid.MaximumMessageSize = 0
+	is.True(reloader.Reload(invalid) != nil)
+	is.Equal(log.GetLevel(), logrus.DebugLevel)
+	is.Equal(messageSize.Size(), 16)
+
+	// Rate limiting can't be turned off without restart.
+	disabled := next
+	disabled.MessageRate = 0
+	is.NoErr(reloader.Reload(disabled))
+	is.Equal(allowed("3"), 3)
+}
+
+func TestHandlerSendMessageReloadedSize(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+	log := testLogger()
+
+	messageSize := NewMessageSizeLimit(8)
+	h := HandlerSendMessage(HandlerSendMessageDependencies{
+		MaxMessageSize: messageSize,
+		Sender: &BridgeEventProducer[EventSentMessage]{
+			EventBridge: NewBridge(ctx, BridgeBuilder{
+				Logger:  log,
+				Storage: newBridgeStorageMock(),
+			}),
+			Type:  BridgeMessageSent,
+			Log:   log,
+			Clock: testClock(),
+		},
+		IDGenerator: testIDGenerator(),
+		Clock:       testClock(),
+	})
+
+	send := func() int {
+		r := requestWithSession(ctx, httptest.NewRequest(
+			http.MethodPost, "/message", strings.NewReader(`{"content":"longer message"}`),
+		), &SessionState{ID: "id", Nickname: "nickname"})
+		w := httptest.NewRecorder()
+		h(w, r)
+		return w.Code
+	}
+
+	is.Equal(send(), http.StatusBadRequest)
+
+	messageSize.SetSize(16)
+	is.Equal(send(), http.StatusAccepted)
+}
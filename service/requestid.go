@@ -0,0 +1,58 @@
+package service
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// RequestIDHeaderMiddleware echoes ID of request in response header,
+// so clients can refer to it when reporting problems. It has to be
+// mounted after chi RequestID middleware.
+func RequestIDHeaderMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if reqID := middleware.GetReqID(r.Context()); reqID != "" {
+			w.Header().Set(middleware.RequestIDHeader, reqID)
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// debugResponse is debug information attached to error responses
+// in debug mode.
+type debugResponse struct {
+	RequestID string `json:"requestID"`
+}
+
+// debugResponseWriter marks responses, which are written in debug
+// mode. It is recognized by jsonResponse.
+type debugResponseWriter struct {
+	http.ResponseWriter
+	reqID string
+}
+
+// Flush sends buffered data to the client, when underlying writer
+// supports flushing.
+func (w *debugResponseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap returns underlying writer for http.ResponseController.
+func (w *debugResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// DebugMiddleware attaches request ID to the Debug field of JSON
+// error responses. It has to be mounted right before handlers, as
+// jsonResponse recognizes only writer passed by this middleware.
+func DebugMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(&debugResponseWriter{
+			ResponseWriter: w,
+			reqID:          middleware.GetReqID(r.Context()),
+		}, r)
+	})
+}
@@ -0,0 +1,114 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/matryer/is"
+	"github.com/sirupsen/logrus"
+)
+
+func TestRequestIDHeaderMiddleware(t *testing.T) {
+	is := is.New(t)
+
+	logs := &bytes.Buffer{}
+	log := logrus.New()
+	log.SetOutput(logs)
+	log.SetFormatter(&logrus.JSONFormatter{})
+
+	h := middleware.RequestID(RequestIDHeaderMiddleware(middleware.RequestLogger(&LoggerLogFormatter{
+		Logger: log,
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/users", nil))
+	is.Equal(w.Code, http.StatusNoContent)
+
+	reqID := w.Header().Get("X-Request-ID")
+	is.True(reqID != "") // request ID header is missing
+
+	entry := map[string]interface{}{}
+	is.NoErr(json.Unmarshal(logs.Bytes(), &entry))
+	is.Equal(entry["reqID"], reqID) // header doesn't match logged ID
+}
+
+func TestDebugMiddleware(t *testing.T) {
+	type testArgs struct {
+		name  string
+		debug bool
+		res   responseWrapper
+
+		wantDebug bool
+	}
+
+	scenario := func(tt testArgs) (string, func(*testing.T)) {
+		return tt.name, func(t *testing.T) {
+			is := is.New(t)
+
+			var h http.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				jsonResponse(w, http.StatusOK, tt.res)
+			})
+			if tt.debug {
+				h = DebugMiddleware(h)
+			}
+			h = middleware.RequestID(RequestIDHeaderMiddleware(h))
+
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/users", nil))
+
+			res := struct {
+				Debug *debugResponse `json:"debug"`
+			}{}
+			is.NoErr(json.NewDecoder(w.Body).Decode(&res))
+			if !tt.wantDebug {
+				is.Equal(res.Debug, nil)
+				return
+			}
+
+			is.True(res.Debug != nil) // debug field is missing
+			is.Equal(res.Debug.RequestID, w.Header().Get("X-Request-ID"))
+		}
+	}
+
+	t.Run(scenario(testArgs{
+		name:  "error in debug mode",
+		debug: true,
+		res: responseWrapper{
+			Error: errorResponse{Code: http.StatusBadRequest, Message: "Invalid request."},
+		},
+		wantDebug: true,
+	}))
+	t.Run(scenario(testArgs{
+		name:  "data in debug mode",
+		debug: true,
+		res: responseWrapper{
+			Data: "ok",
+		},
+	}))
+	t.Run(scenario(testArgs{
+		name: "error without debug mode",
+		res: responseWrapper{
+			Error: errorResponse{Code: http.StatusBadRequest, Message: "Invalid request."},
+		},
+	}))
+}
+
+func TestDebugMiddlewareFlush(t *testing.T) {
+	is := is.New(t)
+
+	w := newStreamRecorder()
+	DebugMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, ok := w.(http.Flusher)
+		is.True(ok) // debug writer has to support event streams
+		w.Write([]byte("data"))
+		w.(http.Flusher).Flush()
+	})).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/stream", nil))
+
+	is.Equal(w.String(), "data")
+}
@@ -2,6 +2,7 @@ package service
 
 import (
 	"net/http"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
@@ -17,10 +18,107 @@ type RouterDependencies struct {
 	Logger       *logrus.Logger
 	SessionStore *SessionCookieStore
 	Bridge       *Bridge
+	Storage      Pinger
 
-	MaximumMessageSize int
+	// Metrics are exposed at /metrics when set.
+	Metrics *Metrics
+
+	// StreamShutdown closes open event streams with server shutdown
+	// event, when set.
+	StreamShutdown *StreamShutdown
+
+	// ShutdownAnnouncer announces users of open event streams leaving,
+	// when server is shutting down. It can be nil.
+	ShutdownAnnouncer *ShutdownAnnouncer
+
+	// StreamConnections limit number of open event streams, when set.
+	StreamConnections *ConnectionLimiter
+
+	// MessageAcks hold messages acknowledged at /ack. It isn't
+	// mounted when it's nil.
+	MessageAcks *MessageAcks
+
+	// BuildInfo is exposed at /version.
+	BuildInfo BuildInfo
+
+	// Debug attaches request ID to error responses.
+	Debug bool
+
+	// MessageRateLimiter limits sending of messages by single session.
+	// Messages aren't limited when it's nil.
+	MessageRateLimiter *RateLimiter
+
+	// MessageFloodGuard mutes sessions repeatedly exceeding message
+	// rate limit. It's used only along with MessageRateLimiter.
+	MessageFloodGuard *FloodGuard
+
+	// CORSOrigins are allowed to make cross-origin requests. CORS
+	// headers aren't set when it's empty.
+	CORSOrigins []string
+
+	// AdminToken grants access to /admin resources. They aren't
+	// mounted when it's empty.
+	AdminToken    string
+	Archive       StateArchive
+	Importer      EventImporter
+	ImportMaxSize int64
+
+	// APIKeys map API keys to nicknames of bots, which send messages
+	// to /api/message. It isn't mounted when it's empty.
+	APIKeys map[string]string
+
+	// IncomingWebhooks map keys of incoming webhooks to nicknames of
+	// bots. /hooks/incoming isn't mounted when it's empty.
+	IncomingWebhooks map[string]string
+
+	// Uploads store files uploaded to /upload. Uploads and message
+	// attachments are disabled when it's nil.
+	Uploads            UploadStore
+	UploadMaxSize      int64
+	UploadContentTypes []string
+
+	UserDisconnecter UserDisconnecter
+	Bans             BanStore
+
+	// Mutes hold users, who can't send messages. Admins can mute
+	// users, when it's set.
+	Mutes MuteStore
+
+	// SlowMode holds slow mode intervals of chat channels. Admins
+	// can set slow mode, when it's set.
+	SlowMode SlowModeStore
+
+	// History of recent messages can be cleared by admins, when
+	// it's set.
+	History HistoryClearer
+
+	// ModerationLog holds moderation actions, which are recorded and
+	// exposed at /admin/audit, when it's set.
+	ModerationLog ModerationLog
+
+	// MessageFilters transform sent and edited messages in order.
+	MessageFilters []MessageFilter
+
+	MaximumMessageSize *MessageSizeLimit
+	NicknamePolicy     NicknamePolicy
+	AdminPolicy        AdminPolicy
+	HeartbeatInterval  time.Duration
+	ReconnectTime      time.Duration
+	StreamBufferSize   int
+
+	// PresenceTTL is period without heartbeat after which users are
+	// expired. Event streams record heartbeats three times per period.
+	// Zero disables heartbeats.
+	PresenceTTL time.Duration
 
 	AllChatUsersStore
+	ChatUsersCounter
+	ChatUserStore
+	PresenceStore
+	PresenceHeartbeater
+	MessageStore
+	MessageHistory
+	MessageSearch
 	MessageNotifier
 	IDGenerator
 	Clock
@@ -32,19 +130,51 @@ func NewRouter(deps RouterDependencies) *chi.Mux {
 
 	sessionRequired := SessionRequired(deps.SessionStore)
 
+	var messageRateLimit func(http.Handler) http.Handler
+	switch {
+	case deps.MessageRateLimiter != nil && deps.MessageFloodGuard != nil:
+		messageRateLimit = FloodGuardMiddleware(deps.Logger, deps.MessageRateLimiter, deps.MessageFloodGuard)
+	case deps.MessageRateLimiter != nil:
+		messageRateLimit = RateLimitMiddleware(deps.MessageRateLimiter)
+	}
+
+	sendMessageMiddlewares := []func(http.Handler) http.Handler{sessionRequired}
+	if messageRateLimit != nil {
+		sendMessageMiddlewares = append(sendMessageMiddlewares, messageRateLimit)
+	}
+
 	r.Use(middleware.RequestID)
+	r.Use(RequestIDHeaderMiddleware)
 	r.Use(middleware.RequestLogger(&LoggerLogFormatter{
 		Logger: deps.Logger,
 	}))
-	r.Use(middleware.Recoverer)
+	r.Use(RecovererMiddleware(deps.Logger))
+	r.Use(CompressMiddleware(5))
+	if len(deps.CORSOrigins) > 0 {
+		r.Use(CORSMiddleware(deps.CORSOrigins))
+	}
+	if deps.Debug {
+		r.Use(DebugMiddleware)
+	}
 
+	r.Get("/healthz", HandlerHealth())
+	r.Get("/version", HandlerVersion(deps.BuildInfo))
+	r.Get("/readyz", HandlerReady(HandlerReadyDependencies{
+		Logger:  deps.Logger,
+		Storage: deps.Storage,
+		Bridge:  deps.Bridge,
+	}))
 	r.With(SessionLoginGuard(deps.SessionStore, "/chat")).Get("/", HandlerIndex(web.UI))
 	r.Post("/login", HandlerLogin(HandlerLoginDependencies{
-		StateFactory: DefaultSessionStateFactory(),
-		Logger:       deps.Logger,
-		SessionStore: deps.SessionStore,
+		StateFactory:   DefaultSessionStateFactory(),
+		Logger:         deps.Logger,
+		SessionStore:   deps.SessionStore,
+		NicknamePolicy: deps.NicknamePolicy,
+		AdminPolicy:    deps.AdminPolicy,
+		Bans:           deps.Bans,
 	}))
 	r.Post("/logout", HandlerLogout(deps.SessionStore))
+	r.With(sessionRequired).Post("/logout/all", HandlerLogoutAll(deps.SessionStore))
 	r.With(sessionRequired).Get("/chat", HandlerChat(web.UI))
 	r.With(LastEventIDMiddleware, sessionRequired, sse.Headers).Get("/stream", HandlerStream(HandlerStreamDependencies{
 		MessageNotifier: &EventAnnouncer{
@@ -61,24 +191,250 @@ func NewRouter(deps RouterDependencies) *chi.Mux {
 				Log:         deps.Logger,
 				Clock:       deps,
 			},
+			Shutdown:    deps.ShutdownAnnouncer,
 			Clock:       deps,
 			IDGenerator: deps,
 		},
+		HeartbeatInterval: deps.HeartbeatInterval,
+		ReconnectTime:     deps.ReconnectTime,
+		Metrics:           deps.Metrics,
+		Shutdown:          deps.StreamShutdown,
+		Connections:       deps.StreamConnections,
+		Presence:          deps.PresenceHeartbeater,
+		PresenceInterval:  deps.PresenceTTL / 3,
+		BufferSize:        deps.StreamBufferSize,
+		IDGenerator:       deps,
+		Clock:             deps,
+	}))
+	r.With(sendMessageMiddlewares...).Post("/message", HandlerSendMessage(HandlerSendMessageDependencies{
+		Sender: &BridgeEventProducer[EventSentMessage]{
+			EventBridge: deps.Bridge,
+			Type:        BridgeMessageSent,
+			Log:         deps.Logger,
+			Clock:       deps,
+		},
+		Uploads:        deps.Uploads,
+		Mutes:          deps.Mutes,
+		SlowMode:       deps.SlowMode,
+		Logger:         deps.Logger,
+		Filters:        deps.MessageFilters,
+		IDGenerator:    deps,
+		Clock:          deps,
+		MaxMessageSize: deps.MaximumMessageSize,
+	}))
+	if len(deps.APIKeys) > 0 {
+		apiMessageMiddlewares := []func(http.Handler) http.Handler{APIKeyAuth(deps.APIKeys)}
+		if messageRateLimit != nil {
+			apiMessageMiddlewares = append(apiMessageMiddlewares, messageRateLimit)
+		}
+		r.With(apiMessageMiddlewares...).Post("/api/message", HandlerSendMessage(HandlerSendMessageDependencies{
+			Sender: &BridgeEventProducer[EventSentMessage]{
+				EventBridge: deps.Bridge,
+				Type:        BridgeMessageSent,
+				Log:         deps.Logger,
+				Clock:       deps,
+			},
+			Mutes:          deps.Mutes,
+			SlowMode:       deps.SlowMode,
+			Logger:         deps.Logger,
+			Filters:        deps.MessageFilters,
+			IDGenerator:    deps,
+			Clock:          deps,
+			MaxMessageSize: deps.MaximumMessageSize,
+		}))
+	}
+	if len(deps.IncomingWebhooks) > 0 {
+		r.Post("/hooks/incoming/{key}", HandlerIncomingWebhook(HandlerIncomingWebhookDependencies{
+			Hooks: deps.IncomingWebhooks,
+			Sender: &BridgeEventProducer[EventSentMessage]{
+				EventBridge: deps.Bridge,
+				Type:        BridgeMessageSent,
+				Log:         deps.Logger,
+				Clock:       deps,
+			},
+			Filters:        deps.MessageFilters,
+			IDGenerator:    deps,
+			Clock:          deps,
+			MaxMessageSize: deps.MaximumMessageSize,
+		}))
+	}
+	if deps.Uploads != nil {
+		r.With(sessionRequired).Post("/upload", HandlerUpload(HandlerUploadDependencies{
+			Logger:       deps.Logger,
+			Uploads:      deps.Uploads,
+			MaxSize:      deps.UploadMaxSize,
+			ContentTypes: deps.UploadContentTypes,
+		}))
+		r.With(sessionRequired).Get("/files/{id}", HandlerUploadFile(deps.Logger, deps.Uploads))
+	}
+	r.With(sessionRequired).Put("/message/{id}", HandlerEditMessage(HandlerEditMessageDependencies{
+		Sender: &BridgeEventProducer[EventMessageEdited]{
+			EventBridge: deps.Bridge,
+			Type:        BridgeMessageEdited,
+			Log:         deps.Logger,
+			Clock:       deps,
+		},
+		Messages:       deps.MessageStore,
+		Mutes:          deps.Mutes,
+		Logger:         deps.Logger,
+		Filters:        deps.MessageFilters,
+		IDGenerator:    deps,
+		Clock:          deps,
+		MaxMessageSize: deps.MaximumMessageSize,
+	}))
+	r.With(sessionRequired).Delete("/message/{id}", HandlerDeleteMessage(HandlerDeleteMessageDependencies{
+		Sender: &BridgeEventProducer[EventMessageDeleted]{
+			EventBridge: deps.Bridge,
+			Type:        BridgeMessageDeleted,
+			Log:         deps.Logger,
+			Clock:       deps,
+		},
+		Messages:    deps.MessageStore,
 		IDGenerator: deps,
 		Clock:       deps,
 	}))
-	r.With(sessionRequired).Post("/message", HandlerSendMessage(HandlerSendMessageDependencies{
+	r.With(sendMessageMiddlewares...).Post("/dm", HandlerDirectMessage(HandlerDirectMessageDependencies{
 		Sender: &BridgeEventProducer[EventSentMessage]{
 			EventBridge: deps.Bridge,
 			Type:        BridgeMessageSent,
 			Log:         deps.Logger,
 			Clock:       deps,
 		},
+		Mutes:          deps.Mutes,
+		Logger:         deps.Logger,
+		Users:          deps.ChatUserStore,
+		Filters:        deps.MessageFilters,
 		IDGenerator:    deps,
 		Clock:          deps,
 		MaxMessageSize: deps.MaximumMessageSize,
 	}))
+	r.With(sessionRequired).Get("/history", HandlerHistory(HandlerHistoryDependencies{
+		Logger:  deps.Logger,
+		History: deps.MessageHistory,
+	}))
+	r.With(sessionRequired).Get("/search", HandlerSearch(HandlerSearchDependencies{
+		Logger: deps.Logger,
+		Search: deps.MessageSearch,
+	}))
+	r.With(sessionRequired).Post("/typing", HandlerTyping(HandlerTypingDependencies{
+		Sender: &BridgeEventProducer[EventUserTyping]{
+			EventBridge: deps.Bridge,
+			Type:        BridgeUserTyping,
+			Log:         deps.Logger,
+			Clock:       deps,
+		},
+		IDGenerator: deps,
+		Clock:       deps,
+	}))
+	r.With(sessionRequired).Post("/presence", HandlerPresence(HandlerPresenceDependencies{
+		Logger: deps.Logger,
+		Users:  deps,
+		Sender: &BridgeEventProducer[EventUserPresence]{
+			EventBridge: deps.Bridge,
+			Type:        BridgeUserPresence,
+			Log:         deps.Logger,
+			Clock:       deps,
+		},
+		IDGenerator: deps,
+		Clock:       deps,
+	}))
+	if deps.MessageAcks != nil {
+		r.With(sessionRequired).Post("/ack", HandlerAck(HandlerAckDependencies{
+			Logger:   deps.Logger,
+			Acks:     deps.MessageAcks,
+			Messages: deps.MessageStore,
+		}))
+	}
 	r.With(sessionRequired).Get("/users", HandlerOnlineUsers(deps.Logger, deps))
+	r.With(sessionRequired).Get("/users/count", HandlerOnlineUsersCount(deps))
+	r.Route("/admin", func(r chi.Router) {
+		if deps.AdminToken != "" {
+			adminTokenRequired := AdminRequired(deps.AdminToken)
+			r.With(adminTokenRequired).Get("/export", HandlerExport(HandlerExportDependencies{
+				Logger:  deps.Logger,
+				Archive: deps.Archive,
+			}))
+			r.With(adminTokenRequired).Post("/import", HandlerImport(HandlerImportDependencies{
+				Logger:   deps.Logger,
+				Importer: deps.Importer,
+				MaxSize:  deps.ImportMaxSize,
+			}))
+		}
+		adminRequired := SessionAdminRequired(deps.SessionStore)
+
+		var audit *ModerationAudit
+		if deps.ModerationLog != nil {
+			audit = &ModerationAudit{
+				Sender: &BridgeEventProducer[EventModerationAction]{
+					EventBridge: deps.Bridge,
+					Type:        BridgeModerationAction,
+					Log:         deps.Logger,
+					Clock:       deps,
+				},
+				IDGenerator: deps,
+				Clock:       deps,
+			}
+			r.With(adminRequired).Get("/audit", HandlerAudit(HandlerAuditDependencies{
+				Logger: deps.Logger,
+				Log:    deps.ModerationLog,
+			}))
+		}
+
+		if deps.Bridge != nil {
+			r.With(adminRequired).Get("/bridge", HandlerBridgeStats(deps.Bridge))
+		}
+		r.With(adminRequired).Post("/kick", HandlerKick(HandlerKickDependencies{
+			Logger:       deps.Logger,
+			Disconnecter: deps.UserDisconnecter,
+			Audit:        audit,
+		}))
+		if deps.History != nil {
+			r.With(adminRequired).Post("/history/clear", HandlerHistoryClear(HandlerHistoryClearDependencies{
+				Logger:  deps.Logger,
+				History: deps.History,
+				Audit:   audit,
+			}))
+		}
+		if deps.Bans != nil {
+			r.With(adminRequired).Post("/ban", HandlerBan(HandlerBanDependencies{
+				Logger:       deps.Logger,
+				Bans:         deps.Bans,
+				Disconnecter: deps.UserDisconnecter,
+				Audit:        audit,
+			}))
+			r.With(adminRequired).Delete("/ban/{id}", HandlerUnban(HandlerUnbanDependencies{
+				Logger: deps.Logger,
+				Bans:   deps.Bans,
+				Audit:  audit,
+			}))
+		}
+		if deps.Mutes != nil {
+			muteDeps := HandlerMuteDependencies{
+				Logger: deps.Logger,
+				Mutes:  deps.Mutes,
+				Sender: &BridgeEventProducer[EventUserMuted]{
+					EventBridge: deps.Bridge,
+					Type:        BridgeUserMuted,
+					Log:         deps.Logger,
+					Clock:       deps,
+				},
+				Audit:       audit,
+				IDGenerator: deps,
+				Clock:       deps,
+			}
+			r.With(adminRequired).Post("/mute", HandlerMute(muteDeps))
+			r.With(adminRequired).Delete("/mute/{userID}", HandlerUnmute(muteDeps))
+		}
+		if deps.SlowMode != nil {
+			r.With(adminRequired).Post("/slowmode", HandlerSlowMode(HandlerSlowModeDependencies{
+				Logger:   deps.Logger,
+				SlowMode: deps.SlowMode,
+			}))
+		}
+	})
+	if deps.Metrics != nil {
+		r.Handle("/metrics", deps.Metrics.Handler())
+	}
 	r.Handle("/*", http.FileServer(http.FS(web.Assets)))
 
 	return r
@@ -0,0 +1,87 @@
+package service
+
+import (
+	"net/http"
+
+	"golang.org/x/crypto/acme/autocert"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// HTTPServer serves HTTP requests either over plain HTTP or over TLS.
+// It is implemented by *http.Server.
+type HTTPServer interface {
+	ListenAndServe() error
+	ListenAndServeTLS(certFile, keyFile string) error
+}
+
+// ListenMode describes how szmaterlok server listens for requests.
+type ListenMode string
+
+const (
+	// ListenHTTP serves plain HTTP requests.
+	ListenHTTP ListenMode = "http"
+
+	// ListenTLS serves HTTPS requests with certificate and key loaded
+	// from files.
+	ListenTLS ListenMode = "tls"
+
+	// ListenAutocert serves HTTPS requests with certificates obtained
+	// automatically with ACME protocol.
+	ListenAutocert ListenMode = "autocert"
+)
+
+// ListenModeFromConfig chooses listen mode based on TLS configuration.
+func ListenModeFromConfig(c ConfigVariables) ListenMode {
+	switch {
+	case c.TLSCert != "" && c.TLSKey != "":
+		return ListenTLS
+	case len(c.TLSAutocertDomains) > 0:
+		return ListenAutocert
+	default:
+		return ListenHTTP
+	}
+}
+
+// ServerListen starts serving requests with given server using listen
+// mode chosen from given config. It blocks until server is closed.
+// In autocert mode, TLS config of server has to be set up beforehand
+// with ServerAutocert.
+func ServerListen(srv HTTPServer, c ConfigVariables) error {
+	switch ListenModeFromConfig(c) {
+	case ListenTLS:
+		return srv.ListenAndServeTLS(c.TLSCert, c.TLSKey)
+	case ListenAutocert:
+		// Certificates are provided by TLS config of server.
+		return srv.ListenAndServeTLS("", "")
+	default:
+		return srv.ListenAndServe()
+	}
+}
+
+// ServerAutocert sets up TLS config of given server, so it obtains
+// certificates for configured domains automatically from Let's Encrypt.
+// Certificates are verified with TLS-ALPN-01 challenge, which requires
+// server to listen on port 443.
+func ServerAutocert(srv *http.Server, c ConfigVariables) *autocert.Manager {
+	m := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(c.TLSAutocertDomains...),
+		Cache:      autocert.DirCache(c.TLSAutocertCache),
+	}
+	srv.TLSConfig = m.TLSConfig()
+
+	return m
+}
+
+// ServerHandler wraps given handler according to given config. With
+// HTTP2 enabled, it serves HTTP/2 requests over cleartext connections
+// (h2c), so many event streams share single connection. HTTP/1.1
+// requests are served as usual.
+func ServerHandler(h http.Handler, c ConfigVariables) http.Handler {
+	if !c.HTTP2 {
+		return h
+	}
+
+	return h2c.NewHandler(h, &http2.Server{})
+}
@@ -0,0 +1,189 @@
+package service
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/fenole/szmaterlok/service/sse"
+	"github.com/matryer/is"
+	"golang.org/x/net/http2"
+)
+
+// httpServerMock records which listen method has been called.
+type httpServerMock struct {
+	method   string
+	certFile string
+	keyFile  string
+}
+
+func (s *httpServerMock) ListenAndServe() error {
+	s.method = "ListenAndServe"
+	return http.ErrServerClosed
+}
+
+func (s *httpServerMock) ListenAndServeTLS(certFile, keyFile string) error {
+	s.method = "ListenAndServeTLS"
+	s.certFile = certFile
+	s.keyFile = keyFile
+	return http.ErrServerClosed
+}
+
+func TestServerListen(t *testing.T) {
+	type testArgs struct {
+		name   string
+		config func(c *ConfigVariables)
+
+		mode     ListenMode
+		method   string
+		certFile string
+		keyFile  string
+	}
+
+	scenario := func(tt testArgs) (string, func(*testing.T)) {
+		return tt.name, func(t *testing.T) {
+			is := is.New(t)
+
+			c := ConfigDefault()
+			if tt.config != nil {
+				tt.config(&c)
+			}
+			is.Equal(ListenModeFromConfig(c), tt.mode)
+
+			srv := &httpServerMock{}
+			is.Equal(ServerListen(srv, c), http.ErrServerClosed)
+			is.Equal(srv.method, tt.method)
+			is.Equal(srv.certFile, tt.certFile)
+			is.Equal(srv.keyFile, tt.keyFile)
+		}
+	}
+
+	t.Run(scenario(testArgs{
+		name:   "plain http",
+		mode:   ListenHTTP,
+		method: "ListenAndServe",
+	}))
+	t.Run(scenario(testArgs{
+		name: "tls",
+		config: func(c *ConfigVariables) {
+			c.TLSCert = "cert.pem"
+			c.TLSKey = "key.pem"
+		},
+		mode:     ListenTLS,
+		method:   "ListenAndServeTLS",
+		certFile: "cert.pem",
+		keyFile:  "key.pem",
+	}))
+	t.Run(scenario(testArgs{
+		name: "autocert",
+		config: func(c *ConfigVariables) {
+			c.TLSAutocertDomains = []string{"chat.example.com"}
+		},
+		mode:   ListenAutocert,
+		method: "ListenAndServeTLS",
+	}))
+	t.Run(scenario(testArgs{
+		name: "cert without key",
+		config: func(c *ConfigVariables) {
+			c.TLSCert = "cert.pem"
+		},
+		mode:   ListenHTTP,
+		method: "ListenAndServe",
+	}))
+}
+
+func TestServerAutocert(t *testing.T) {
+	is := is.New(t)
+
+	c := ConfigDefault()
+	c.TLSAutocertDomains = []string{"chat.example.com"}
+	c.TLSAutocertCache = t.TempDir()
+
+	srv := &http.Server{}
+	m := ServerAutocert(srv, c)
+	is.True(srv.TLSConfig != nil)
+	is.True(srv.TLSConfig.GetCertificate != nil)
+
+	// Domains out of the list are rejected by host policy.
+	is.NoErr(m.HostPolicy(context.Background(), "chat.example.com"))
+	is.True(m.HostPolicy(context.Background(), "example.org") != nil)
+}
+
+func TestServerHandlerHTTP2(t *testing.T) {
+	is := is.New(t)
+
+	// Every subscriber receives single message right away.
+	stream := HandlerStream(HandlerStreamDependencies{
+		MessageNotifier: MessageNotifierFunc(func(ctx context.Context, args MessageSubscribeRequest) func() {
+			args.Channel <- sse.Event{
+				ID:   args.RequestID,
+				Type: MessageSent,
+				Data: []byte(args.Nickname),
+			}
+			return func() {}
+		}),
+		BufferSize: 1,
+	})
+	h := sse.Headers(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		stream(w, requestWithSession(r.Context(), r, &SessionState{
+			ID:       "id",
+			Nickname: r.URL.Query().Get("nickname"),
+		}))
+	}))
+
+	c := ConfigDefault()
+	c.HTTP2 = true
+
+	srv := httptest.NewUnstartedServer(ServerHandler(h, c))
+	var conns int32
+	srv.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(&conns, 1)
+		}
+	}
+	srv.Start()
+	defer srv.Close()
+
+	// Client speaks HTTP/2 over cleartext connection.
+	client := &http.Client{
+		Transport: &http2.Transport{
+			AllowHTTP: true,
+			DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, network, addr)
+			},
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Both streams stay open at the same time.
+	streams := []*sse.Scanner{}
+	for _, nickname := range []string{"alice", "bob"} {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/stream?nickname="+nickname, nil)
+		is.NoErr(err)
+
+		res, err := client.Do(req)
+		is.NoErr(err)
+		defer res.Body.Close()
+
+		is.Equal(res.ProtoMajor, 2)
+		is.Equal(res.Header.Get("Content-Type"), sse.ContentTypeEventStream)
+		is.Equal(res.Header.Get("Connection"), "")
+		streams = append(streams, sse.NewScanner(res.Body))
+	}
+
+	for i, nickname := range []string{"alice", "bob"} {
+		is.True(streams[i].Scan()) // event hasn't been received
+		evt := streams[i].Event()
+		is.Equal(evt.Type, MessageSent)
+		is.Equal(string(evt.Data), nickname)
+	}
+
+	// Streams are multiplexed over single connection.
+	is.Equal(atomic.LoadInt32(&conns), int32(1))
+}
@@ -5,7 +5,12 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"math"
+	"mime"
 	"net/http"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
@@ -18,6 +23,9 @@ type SessionState struct {
 	ID        string    `json:"id"`
 	CreatedAt time.Time `json:"cat"`
 	ExpireAt  time.Time `json:"eat"`
+
+	// Admin is set for users with administrative privileges.
+	Admin bool `json:"adm,omitempty"`
 }
 
 // SessionStateFactory creates new unique session states.
@@ -60,6 +68,14 @@ type SessionTokenizer interface {
 
 // jsonResponse sends a JSON response with given status code.
 func jsonResponse(w http.ResponseWriter, code int, i interface{}) error {
+	// Error responses written in debug mode carry request ID.
+	if dw, ok := w.(*debugResponseWriter); ok {
+		if res, ok := i.(responseWrapper); ok && res.Error != nil && res.Debug == nil {
+			res.Debug = debugResponse{RequestID: dw.reqID}
+			i = res
+		}
+	}
+
 	b, err := json.Marshal(i)
 	if err != nil {
 		return err
@@ -71,6 +87,57 @@ func jsonResponse(w http.ResponseWriter, code int, i interface{}) error {
 	return nil
 }
 
+// writeError responds with error of given status code, reason and
+// message. Error is encoded as JSON errorResponse, unless client
+// prefers plain text according to its Accept header (for example
+// browser submitting a form).
+func writeError(w http.ResponseWriter, r *http.Request, code int, reason ErrorReason, msg string) {
+	if errorPlainText(r) {
+		http.Error(w, msg, code)
+		return
+	}
+
+	jsonResponse(w, code, responseWrapper{
+		Error: errorResponse{
+			Code:    code,
+			Reason:  reason,
+			Message: msg,
+		},
+	})
+}
+
+// errorPlainText reports whether given request prefers plain text
+// over JSON. HTML is served as plain text, as there are no HTML error
+// pages. JSON is preferred when Accept header is missing or both are
+// equally acceptable.
+func errorPlainText(r *http.Request) bool {
+	jsonQ, textQ := 0.0, 0.0
+	for _, header := range r.Header.Values("Accept") {
+		for _, mediaRange := range strings.Split(header, ",") {
+			mediaType, params, err := mime.ParseMediaType(mediaRange)
+			if err != nil {
+				continue
+			}
+
+			q := 1.0
+			if qv, ok := params["q"]; ok {
+				if parsed, err := strconv.ParseFloat(qv, 64); err == nil {
+					q = parsed
+				}
+			}
+
+			switch mediaType {
+			case "application/json", "application/*", "*/*":
+				jsonQ = math.Max(jsonQ, q)
+			case "text/plain", "text/html", "text/*":
+				textQ = math.Max(textQ, q)
+			}
+		}
+	}
+
+	return textQ > jsonQ
+}
+
 type responseWrapper struct {
 	Data  interface{} `json:"data,omitempty"`
 	Error interface{} `json:"error,omitempty"`
@@ -78,8 +145,13 @@ type responseWrapper struct {
 }
 
 type errorResponse struct {
-	Code    int    `json:"code"`
-	Message string `json:"message"`
+	Code    int         `json:"code"`
+	Reason  ErrorReason `json:"reason"`
+	Message string      `json:"message"`
+
+	// RequestID is set for unexpected errors, so they can be found
+	// in logs.
+	RequestID string `json:"requestID,omitempty"`
 }
 
 // SessionRequired is http middleware which checks for presence of session
@@ -96,18 +168,51 @@ func SessionRequired(cs *SessionCookieStore) func(http.Handler) http.Handler {
 				jsonResponse(w, http.StatusUnauthorized, responseWrapper{
 					Error: errorResponse{
 						Code:    http.StatusUnauthorized,
+						Reason:  ErrorReasonUnauthenticated,
 						Message: "You are not authorized to access these resources.",
 					},
 				})
 				return
 			}
 
+			// Failed refresh leaves the current session untouched, as
+			// it is still valid.
+			if refreshed, err := cs.RefreshState(w, *state); err == nil {
+				state = &refreshed
+			}
+
 			ctx := context.WithValue(r.Context(), sessionStateKey, state)
 			next.ServeHTTP(w, r.WithContext(ctx))
 		})
 	}
 }
 
+// SessionAdminRequired guards given handler from being accessed without
+// valid session state of admin user. Requests without session are
+// rejected just like with SessionRequired, requests of other users
+// are forbidden.
+func SessionAdminRequired(cs *SessionCookieStore) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		admin := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			state := SessionContextState(r.Context())
+			if state == nil || !state.Admin {
+				jsonResponse(w, http.StatusForbidden, responseWrapper{
+					Error: errorResponse{
+						Code:    http.StatusForbidden,
+						Reason:  ErrorReasonAdminRequired,
+						Message: "Resource requires admin privileges.",
+					},
+				})
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+
+		return SessionRequired(cs)(admin)
+	}
+}
+
 // SessionLoginGuard guards given handler from being accessed with request
 // which contains valid session. If any valid session exists, client is
 // being redirect to given rediretUri.
@@ -156,7 +261,10 @@ type SessionCookieSetRequest struct {
 	Clock
 }
 
-var ErrSessionStateExpire = errors.New("session state expired")
+var (
+	ErrSessionStateExpire = errors.New("session state expired")
+	ErrSessionRevoked     = errors.New("session has been revoked")
+)
 
 // SessionCookieStore handles save and read operation of session
 // state token within http cookies.
@@ -170,6 +278,30 @@ type SessionCookieStore struct {
 	// Tokenizer handles encoding and decoding of session state.
 	Tokenizer SessionTokenizer
 
+	// Sliding enables sliding sessions. Sessions which are about to
+	// expire within SlidingWindow are re-issued with fresh expiration
+	// date.
+	Sliding bool
+
+	// SlidingWindow is period before session expiration date in which
+	// session is refreshed.
+	SlidingWindow time.Duration
+
+	// Secure restricts session cookie to HTTPS connections.
+	Secure bool
+
+	// SameSite controls whether session cookie is sent with cross-site
+	// requests. Zero value means http.SameSiteLaxMode.
+	SameSite http.SameSite
+
+	// Revoker holds revoked sessions. Revocation list is not checked
+	// when it's nil.
+	Revoker SessionRevoker
+
+	// Bans holds banned users. Sessions of banned users are rejected.
+	// Bans are not checked when it's nil.
+	Bans BanStore
+
 	// Clock returns current time.
 	Clock
 }
@@ -193,6 +325,26 @@ func (cs *SessionCookieStore) SessionState(r *http.Request) (*SessionState, erro
 		return nil, ErrSessionStateExpire
 	}
 
+	if cs.Revoker != nil {
+		revoked, err := cs.Revoker.SessionRevoked(r.Context(), state.ID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to check session revocation: %w", err)
+		}
+		if revoked {
+			return nil, ErrSessionRevoked
+		}
+	}
+
+	if cs.Bans != nil {
+		banned, err := sessionBanned(r.Context(), cs.Bans, state)
+		if err != nil {
+			return nil, fmt.Errorf("failed to check session ban: %w", err)
+		}
+		if banned {
+			return nil, ErrSessionBanned
+		}
+	}
+
 	return state, nil
 }
 
@@ -206,23 +358,126 @@ func (cs *SessionCookieStore) SaveSessionState(
 		return fmt.Errorf("failed to tokenize state: %w", err)
 	}
 
-	http.SetCookie(w, &http.Cookie{
-		Name:     sessionCookieKey,
-		Value:    token,
-		Path:     "/",
-		Expires:  cs.Now().Add(cs.ExpirationTime),
-		HttpOnly: true,
-	})
+	http.SetCookie(w, cs.cookie(token, cs.Now().Add(cs.ExpirationTime)))
 	return nil
 }
 
+// RefreshState re-issues session cookie with expiration date moved
+// by ExpirationTime, when sliding sessions are enabled and given state
+// expires within sliding window. It returns the current session state.
+func (cs *SessionCookieStore) RefreshState(
+	w http.ResponseWriter, s SessionState,
+) (SessionState, error) {
+	now := cs.Now()
+	if !cs.Sliding || s.ExpireAt.Sub(now) > cs.SlidingWindow {
+		return s, nil
+	}
+
+	s.ExpireAt = now.Add(cs.ExpirationTime)
+	if err := cs.SaveSessionState(w, s); err != nil {
+		return s, fmt.Errorf("failed to refresh session state: %w", err)
+	}
+
+	return s, nil
+}
+
 // ClearState deletes current session state stored in http cookies.
 func (cs *SessionCookieStore) ClearState(w http.ResponseWriter) {
-	http.SetCookie(w, &http.Cookie{
+	http.SetCookie(w, cs.cookie("", cs.Now().Add(-1*time.Second)))
+}
+
+// cookie returns session cookie with given value and expiration date.
+// Saved and cleared cookies have to share their attributes, otherwise
+// browsers won't overwrite them.
+func (cs *SessionCookieStore) cookie(value string, expires time.Time) *http.Cookie {
+	sameSite := cs.SameSite
+	if sameSite == 0 {
+		sameSite = http.SameSiteLaxMode
+	}
+
+	return &http.Cookie{
 		Name:     sessionCookieKey,
-		Value:    "",
+		Value:    value,
 		Path:     "/",
-		Expires:  cs.Now().Add(-1 * time.Second),
+		Expires:  expires,
 		HttpOnly: true,
-	})
+		Secure:   cs.Secure,
+		SameSite: sameSite,
+	}
+}
+
+// SessionRevoker stores IDs of revoked sessions. Revoked sessions are
+// rejected even if their tokens are still valid. Entries are kept only
+// until revoked session expires. Revoking session again never moves
+// its expiration date back.
+type SessionRevoker interface {
+	// RevokeSession adds session with given ID to the revocation list
+	// until given expiration date of the session. Later expiration date
+	// of already revoked session is kept.
+	RevokeSession(ctx context.Context, id string, expireAt time.Time) error
+
+	// SessionRevoked reports whether session with given ID is revoked.
+	SessionRevoked(ctx context.Context, id string) (bool, error)
+}
+
+var ErrTokenRevocationUnsupported = errors.New("session: tokenizer can't revoke tokens")
+
+// SessionTokenRevoker is implemented by tokenizers, which keep session
+// tokens on the server side, so they can invalidate them before they
+// expire.
+type SessionTokenRevoker interface {
+	// RevokeUserTokens invalidates all session tokens of given user.
+	RevokeUserTokens(ctx context.Context, userID string) error
+}
+
+// SessionRevokerMemory is in-memory SessionRevoker. Expired entries
+// are garbage collected on every revocation.
+type SessionRevokerMemory struct {
+	revoked map[string]time.Time
+	mtx     *sync.Mutex
+	clock   Clock
+}
+
+// NewSessionRevokerMemory returns empty in-memory session revoker.
+func NewSessionRevokerMemory(clock Clock) *SessionRevokerMemory {
+	return &SessionRevokerMemory{
+		revoked: make(map[string]time.Time),
+		mtx:     &sync.Mutex{},
+		clock:   clock,
+	}
+}
+
+// RevokeSession adds session with given ID to the revocation list
+// until given expiration date of the session. Later expiration date
+// of already revoked session is kept.
+func (r *SessionRevokerMemory) RevokeSession(ctx context.Context, id string, expireAt time.Time) error {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	now := r.clock.Now()
+	for revokedID, revokedExpireAt := range r.revoked {
+		if revokedExpireAt.Before(now) {
+			delete(r.revoked, revokedID)
+		}
+	}
+
+	if revokedExpireAt, ok := r.revoked[id]; ok && revokedExpireAt.After(expireAt) {
+		return nil
+	}
+
+	r.revoked[id] = expireAt
+	return nil
+}
+
+// SessionRevoked reports whether session with given ID is revoked.
+func (r *SessionRevokerMemory) SessionRevoked(ctx context.Context, id string) (bool, error) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	expireAt, ok := r.revoked[id]
+	if !ok {
+		return false, nil
+	}
+
+	return !expireAt.Before(r.clock.Now()), nil
 }
@@ -1,12 +1,67 @@
 package service
 
 import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/matryer/is"
 )
 
+func TestSessionSimpleTokenizer(t *testing.T) {
+	is := is.New(t)
+
+	clock, move := testMovingClock()
+	store := NewSessionTokenStoreMemory(clock)
+	tokenizer := NewSessionSimpleTokenizerWithStore(store)
+	tokenizer.clock = clock
+
+	now := clock.Now()
+	state := SessionState{
+		Nickname:  "karol",
+		ID:        "uniqueid",
+		CreatedAt: now,
+		ExpireAt:  now.Add(time.Hour),
+	}
+
+	token, err := tokenizer.TokenEncode(state)
+	is.NoErr(err)
+
+	got, err := tokenizer.TokenDecode(token)
+	is.NoErr(err)
+	is.Equal(*got, state)
+
+	// Expired token is rejected and removed from storage.
+	move(time.Hour * 2)
+	_, err = tokenizer.TokenDecode(token)
+	is.True(errors.Is(err, ErrSessionStateExpire))
+	is.Equal(len(store.tokens), 0)
+
+	_, err = tokenizer.TokenDecode(token)
+	is.True(errors.Is(err, ErrMissingSessionToken))
+
+	// Expired tokens, which are never decoded, are removed with
+	// next encoded token.
+	_, err = tokenizer.TokenEncode(SessionState{
+		ID:       "expired",
+		ExpireAt: clock.Now().Add(time.Minute),
+	})
+	is.NoErr(err)
+	move(time.Hour)
+
+	_, err = tokenizer.TokenEncode(SessionState{
+		ID:       "fresh",
+		ExpireAt: clock.Now().Add(time.Hour),
+	})
+	is.NoErr(err)
+	is.Equal(len(store.tokens), 1)
+}
+
 func TestSessionAgeTokenizer(t *testing.T) {
 	is := is.New(t)
 
@@ -66,3 +121,931 @@ func TestAESTokenizer(t *testing.T) {
 
 	is.Equal(*gotState, wantState)
 }
+
+func TestAESTokenizerTampered(t *testing.T) {
+	is := is.New(t)
+
+	tokenizer, err := NewSessionAESTokenizer([]byte("veibiequohy2eshaerohHoghootae1ku"))
+	is.NoErr(err)
+
+	token, err := tokenizer.TokenEncode(SessionState{
+		Nickname: "karol",
+		ID:       "uniqueid",
+	})
+	is.NoErr(err)
+
+	// Plaintext starts with known prefix, so flipping bits of the first
+	// block would turn it into admin claim without authentication.
+	parts := strings.Split(token, ":")
+	b, err := base64.URLEncoding.DecodeString(parts[1])
+	is.NoErr(err)
+	known, forged := []byte(`{"nck":"`), []byte(`{"adm":1`)
+	for i := range known {
+		b[i] ^= known[i] ^ forged[i]
+	}
+	parts[1] = base64.URLEncoding.EncodeToString(b)
+
+	_, err = tokenizer.TokenDecode(strings.Join(parts, ":"))
+	is.True(errors.Is(err, ErrAESInvalidToken))
+
+	_, err = tokenizer.TokenDecode("malformed")
+	is.True(errors.Is(err, ErrAESInvalidToken))
+}
+
+func TestSessionTokenizerAdmin(t *testing.T) {
+	type testArgs struct {
+		name      string
+		tokenizer func() (SessionTokenizer, error)
+	}
+
+	scenario := func(tt testArgs) (string, func(*testing.T)) {
+		return tt.name, func(t *testing.T) {
+			is := is.New(t)
+
+			tokenizer, err := tt.tokenizer()
+			is.NoErr(err)
+
+			now := testClock().Now()
+			for _, admin := range []bool{true, false} {
+				want := SessionState{
+					Nickname:  "karol",
+					ID:        "uniqueid",
+					CreatedAt: now,
+					ExpireAt:  now.Add(time.Hour),
+					Admin:     admin,
+				}
+
+				token, err := tokenizer.TokenEncode(want)
+				is.NoErr(err)
+
+				got, err := tokenizer.TokenDecode(token)
+				is.NoErr(err)
+				is.Equal(got.Admin, admin)
+			}
+		}
+	}
+
+	t.Run(scenario(testArgs{
+		name: "simple",
+		tokenizer: func() (SessionTokenizer, error) {
+			tokenizer := NewSessionSimpleTokenizerWithStore(NewSessionTokenStoreMemory(testClock()))
+			tokenizer.clock = testClock()
+			return tokenizer, nil
+		},
+	}))
+	t.Run(scenario(testArgs{
+		name: "age",
+		tokenizer: func() (SessionTokenizer, error) {
+			return NewSessionAgeTokenizer("secret_password")
+		},
+	}))
+	t.Run(scenario(testArgs{
+		name: "aes",
+		tokenizer: func() (SessionTokenizer, error) {
+			return NewSessionAESTokenizer([]byte("veibiequohy2eshaerohHoghootae1ku"))
+		},
+	}))
+	t.Run(scenario(testArgs{
+		name: "jwt",
+		tokenizer: func() (SessionTokenizer, error) {
+			return NewSessionJWTTokenizer([]byte("veibiequohy2eshaerohHoghootae1ku"), testClock())
+		},
+	}))
+}
+
+func TestAESTokenizerFromPassphrase(t *testing.T) {
+	is := is.New(t)
+
+	pass := "correct horse battery staple"
+	tokenizerA, err := NewSessionAESTokenizerFromPassphrase(pass)
+	is.NoErr(err)
+
+	now := testClock().Now()
+	wantState := SessionState{
+		Nickname:  "karol",
+		ID:        "uniqueid",
+		CreatedAt: now,
+		ExpireAt:  now.Add(time.Hour),
+	}
+
+	token, err := tokenizerA.TokenEncode(wantState)
+	is.NoErr(err)
+
+	gotState, err := tokenizerA.TokenDecode(token)
+	is.NoErr(err)
+	is.Equal(*gotState, wantState)
+
+	// Instances with the same passphrase interoperate.
+	tokenizerB, err := NewSessionAESTokenizerFromPassphrase(pass)
+	is.NoErr(err)
+	gotState, err = tokenizerB.TokenDecode(token)
+	is.NoErr(err)
+	is.Equal(*gotState, wantState)
+
+	tokenizerC, err := NewSessionAESTokenizerFromPassphrase("incorrect horse battery staple")
+	is.NoErr(err)
+	_, err = tokenizerC.TokenDecode(token)
+	is.True(err != nil)
+
+	_, err = NewSessionAESTokenizerFromPassphrase("")
+	is.True(err != nil)
+}
+
+func TestSessionJWTTokenizer(t *testing.T) {
+	pass := []byte("veibiequohy2eshaerohHoghootae1ku")
+	expirationTime := time.Hour * 24 * 7
+
+	now, err := time.Parse(time.ANSIC, "Thu Mar 17 21:23:59 2022")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantState := SessionState{
+		Nickname:  "karol",
+		ID:        "uniqueid",
+		CreatedAt: now,
+		ExpireAt:  now.Add(expirationTime),
+	}
+
+	t.Run("round trip", func(t *testing.T) {
+		is := is.New(t)
+
+		tokenizer, err := NewSessionJWTTokenizer(pass, testClock())
+		is.NoErr(err)
+
+		token, err := tokenizer.TokenEncode(wantState)
+		is.NoErr(err)
+		is.Equal(len(strings.Split(token, ".")), 3)
+
+		gotState, err := tokenizer.TokenDecode(token)
+		is.NoErr(err)
+		is.True(gotState != nil)
+
+		is.Equal(*gotState, wantState)
+	})
+
+	t.Run("tampered token", func(t *testing.T) {
+		is := is.New(t)
+
+		tokenizer, err := NewSessionJWTTokenizer(pass, testClock())
+		is.NoErr(err)
+
+		token, err := tokenizer.TokenEncode(wantState)
+		is.NoErr(err)
+
+		// Replace claims with the ones of another user.
+		forged := wantState
+		forged.ID = "anotherid"
+		forgedToken, err := tokenizer.TokenEncode(forged)
+		is.NoErr(err)
+
+		parts := strings.Split(token, ".")
+		parts[1] = strings.Split(forgedToken, ".")[1]
+
+		_, err = tokenizer.TokenDecode(strings.Join(parts, "."))
+		is.True(errors.Is(err, ErrJWTInvalidSignature))
+	})
+
+	t.Run("different secret", func(t *testing.T) {
+		is := is.New(t)
+
+		tokenizerA, err := NewSessionJWTTokenizer(pass, testClock())
+		is.NoErr(err)
+		tokenizerB, err := NewSessionJWTTokenizer([]byte("another secret"), testClock())
+		is.NoErr(err)
+
+		token, err := tokenizerA.TokenEncode(wantState)
+		is.NoErr(err)
+
+		_, err = tokenizerB.TokenDecode(token)
+		is.True(errors.Is(err, ErrJWTInvalidSignature))
+	})
+
+	t.Run("expired token", func(t *testing.T) {
+		is := is.New(t)
+
+		tokenizer, err := NewSessionJWTTokenizer(pass, ClockFunc(func() time.Time {
+			return wantState.ExpireAt.Add(time.Second)
+		}))
+		is.NoErr(err)
+
+		token, err := tokenizer.TokenEncode(wantState)
+		is.NoErr(err)
+
+		_, err = tokenizer.TokenDecode(token)
+		is.True(errors.Is(err, ErrJWTExpired))
+	})
+}
+
+func TestSessionRotatingTokenizer(t *testing.T) {
+	is := is.New(t)
+
+	oldTokenizer, err := NewSessionAESTokenizer([]byte("veibiequohy2eshaerohHoghootae1ku"))
+	is.NoErr(err)
+	newTokenizer, err := NewSessionAESTokenizer([]byte("oogh0aiv9Iephahchoh6ju4Ohf2Aekoo"))
+	is.NoErr(err)
+
+	tokenizer := NewSessionRotatingTokenizer(newTokenizer, oldTokenizer)
+
+	now := testClock().Now()
+	state := SessionState{
+		Nickname:  "karol",
+		ID:        "uniqueid",
+		CreatedAt: now,
+		ExpireAt:  now.Add(time.Hour),
+	}
+
+	// Token encoded with old key is still valid.
+	oldToken, err := oldTokenizer.TokenEncode(state)
+	is.NoErr(err)
+	got, err := tokenizer.TokenDecode(oldToken)
+	is.NoErr(err)
+	is.Equal(*got, state)
+
+	// New tokens are encoded with the new key.
+	newToken, err := tokenizer.TokenEncode(state)
+	is.NoErr(err)
+	got, err = newTokenizer.TokenDecode(newToken)
+	is.NoErr(err)
+	is.Equal(*got, state)
+
+	_, err = oldTokenizer.TokenDecode(newToken)
+	is.True(err != nil)
+
+	// Tokens of unknown keys are rejected.
+	otherTokenizer, err := NewSessionAESTokenizer([]byte("Aeng4ahzeeRah8ohrohP2eizaiY5gi4e"))
+	is.NoErr(err)
+	otherToken, err := otherTokenizer.TokenEncode(state)
+	is.NoErr(err)
+	_, err = tokenizer.TokenDecode(otherToken)
+	is.True(err != nil)
+}
+
+func TestSessionTokenizerCache(t *testing.T) {
+	is := is.New(t)
+
+	aes, err := NewSessionAESTokenizer([]byte("veibiequohy2eshaerohHoghootae1ku"))
+	is.NoErr(err)
+
+	metrics := NewMetrics()
+	cache := NewSessionTokenizerCache(SessionTokenizerCacheBuilder{
+		Wrapped:    aes,
+		Timeout:    time.Hour,
+		Logger:     testLogger(),
+		MaxEntries: 2,
+		Metrics:    metrics,
+	})
+
+	now := testClock().Now()
+	token := func(id string) string {
+		res, err := cache.TokenEncode(SessionState{
+			Nickname:  "karol",
+			ID:        id,
+			CreatedAt: now,
+			ExpireAt:  now.Add(time.Hour),
+		})
+		is.NoErr(err)
+		return res
+	}
+	decode := func(token string) {
+		got, err := cache.TokenDecode(token)
+		is.NoErr(err)
+		is.True(got != nil)
+	}
+	tokenA, tokenB, tokenC := token("a"), token("b"), token("c")
+
+	decode(tokenA)
+	decode(tokenA)
+	decode(tokenB)
+	is.Equal(cache.Hits(), uint64(1))
+	is.Equal(cache.Misses(), uint64(2))
+	is.Equal(cache.Len(), 2)
+
+	// Token A is used more recently than B, so B is evicted.
+	decode(tokenA)
+	decode(tokenC)
+	is.Equal(cache.Len(), 2)
+	is.Equal(cache.Hits(), uint64(2))
+	is.Equal(cache.Misses(), uint64(3))
+
+	decode(tokenA)
+	decode(tokenC)
+	is.Equal(cache.Hits(), uint64(4))
+
+	decode(tokenB)
+	is.Equal(cache.Misses(), uint64(4))
+	is.Equal(cache.Len(), 2)
+
+	// Invalid tokens are not cached.
+	_, err = cache.TokenDecode("invalid")
+	is.True(err != nil)
+	is.Equal(cache.Misses(), uint64(5))
+	is.Equal(cache.Len(), 2)
+
+	is.Equal(scrapeMetric(t, metrics, "szmaterlok_tokenizer_cache_hits_total"), "4")
+	is.Equal(scrapeMetric(t, metrics, "szmaterlok_tokenizer_cache_misses_total"), "5")
+
+	t.Run("expiration", func(t *testing.T) {
+		is := is.New(t)
+
+		cache := NewSessionTokenizerCache(SessionTokenizerCacheBuilder{
+			Wrapped: aes,
+			Timeout: time.Millisecond,
+			Logger:  testLogger(),
+		})
+		_, err := cache.TokenDecode(tokenA)
+		is.NoErr(err)
+
+		waitFor(t, time.Second, func() bool {
+			return cache.Len() == 0
+		})
+	})
+}
+
+func TestSessionTokenizerFactory(t *testing.T) {
+	type testArgs struct {
+		name      string
+		tokenizer string
+		secret    string
+		old       []string
+		debug     bool
+		err       error
+	}
+
+	scenario := func(tt testArgs) (string, func(*testing.T)) {
+		return tt.name, func(t *testing.T) {
+			is := is.New(t)
+
+			config := ConfigDefault()
+			config.Tokenizer = tt.tokenizer
+			config.Debug = tt.debug
+			config.SessionSecretOld = tt.old
+			if tt.secret != "" {
+				config.SessionSecret = tt.secret
+			}
+
+			f := &SessionTokenizerFactory{
+				Timeout: time.Minute,
+				Logger:  testLogger(),
+			}
+			tokenizer, err := f.Tokenizer(&config)
+			is.True(errors.Is(err, tt.err))
+			is.Equal(tokenizer == nil, tt.err != nil)
+		}
+	}
+
+	t.Run(scenario(testArgs{
+		name:      "simple tokenizer with default secret",
+		tokenizer: ConfigTokenizerSimple,
+	}))
+	t.Run(scenario(testArgs{
+		name:      "age tokenizer with default secret",
+		tokenizer: ConfigTokenizerAge,
+		err:       ErrDefaultSessionSecret,
+	}))
+	t.Run(scenario(testArgs{
+		name:      "jwt tokenizer with default secret",
+		tokenizer: ConfigTokenizerJWT,
+		err:       ErrDefaultSessionSecret,
+	}))
+	t.Run(scenario(testArgs{
+		name:      "default secret in debug mode",
+		tokenizer: ConfigTokenizerAge,
+		debug:     true,
+	}))
+	t.Run(scenario(testArgs{
+		name:      "aes key",
+		tokenizer: ConfigTokenizerAES,
+		secret:    "veibiequohy2eshaerohHoghootae1ku",
+	}))
+	t.Run(scenario(testArgs{
+		name:      "too short aes key",
+		tokenizer: ConfigTokenizerAES,
+		secret:    "veibiequohy2",
+		err:       ErrWeakSessionSecret,
+	}))
+	t.Run(scenario(testArgs{
+		name:      "short aes passphrase in debug mode",
+		tokenizer: ConfigTokenizerAES,
+		secret:    "veibiequohy2",
+		debug:     true,
+	}))
+	t.Run(scenario(testArgs{
+		name:      "aes passphrase",
+		tokenizer: ConfigTokenizerAES,
+		secret:    "correct horse battery staple",
+	}))
+	t.Run(scenario(testArgs{
+		name:      "aes key with low entropy",
+		tokenizer: ConfigTokenizerAES,
+		secret:    "abababababababab",
+		err:       ErrWeakSessionSecret,
+	}))
+	t.Run(scenario(testArgs{
+		name:      "old aes passphrase",
+		tokenizer: ConfigTokenizerAES,
+		secret:    "veibiequohy2eshaerohHoghootae1ku",
+		old:       []string{"correct horse battery staple"},
+	}))
+	t.Run(scenario(testArgs{
+		name:      "empty old jwt secret",
+		tokenizer: ConfigTokenizerJWT,
+		secret:    "veibiequohy2eshaerohHoghootae1ku",
+		old:       []string{""},
+		err:       ErrEmptyJWTSecret,
+	}))
+	t.Run(scenario(testArgs{
+		name:      "invalid tokenizer",
+		tokenizer: "rot13",
+		err:       ErrInvalidTokenizerType,
+	}))
+}
+
+func TestSessionRequiredSliding(t *testing.T) {
+	type testArgs struct {
+		name      string
+		sliding   bool
+		expiresIn time.Duration
+		reissued  bool
+	}
+
+	scenario := func(tt testArgs) (string, func(*testing.T)) {
+		return tt.name, func(t *testing.T) {
+			is := is.New(t)
+
+			tokenizer, err := NewSessionAESTokenizer([]byte("veibiequohy2eshaerohHoghootae1ku"))
+			is.NoErr(err)
+
+			clock := testClock()
+			cs := &SessionCookieStore{
+				ExpirationTime: time.Hour * 24 * 7,
+				Tokenizer:      tokenizer,
+				Sliding:        tt.sliding,
+				SlidingWindow:  time.Hour * 24,
+				Clock:          clock,
+			}
+
+			state := SessionState{
+				Nickname:  "karol",
+				ID:        "uniqueid",
+				CreatedAt: clock.Now().Add(-time.Hour),
+				ExpireAt:  clock.Now().Add(tt.expiresIn),
+			}
+			token, err := tokenizer.TokenEncode(state)
+			is.NoErr(err)
+
+			var got *SessionState
+			h := SessionRequired(cs)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = SessionContextState(r.Context())
+			}))
+
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.AddCookie(&http.Cookie{Name: sessionCookieKey, Value: token})
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+
+			is.Equal(w.Code, http.StatusOK)
+			is.True(got != nil)
+
+			cookies := w.Result().Cookies()
+			if !tt.reissued {
+				is.Equal(len(cookies), 0)
+				is.Equal(got.ExpireAt, state.ExpireAt)
+				return
+			}
+
+			is.Equal(len(cookies), 1)
+			is.Equal(cookies[0].Name, sessionCookieKey)
+
+			refreshed, err := tokenizer.TokenDecode(cookies[0].Value)
+			is.NoErr(err)
+			is.Equal(refreshed.ID, state.ID)
+			is.Equal(refreshed.ExpireAt, clock.Now().Add(cs.ExpirationTime))
+			is.Equal(got.ExpireAt, refreshed.ExpireAt)
+		}
+	}
+
+	t.Run(scenario(testArgs{
+		name:      "inside window",
+		sliding:   true,
+		expiresIn: time.Hour,
+		reissued:  true,
+	}))
+	t.Run(scenario(testArgs{
+		name:      "outside window",
+		sliding:   true,
+		expiresIn: time.Hour * 48,
+		reissued:  false,
+	}))
+	t.Run(scenario(testArgs{
+		name:      "sliding disabled",
+		sliding:   false,
+		expiresIn: time.Hour,
+		reissued:  false,
+	}))
+}
+
+func TestSessionAdminRequired(t *testing.T) {
+	type testArgs struct {
+		name    string
+		session bool
+		admin   bool
+		code    int
+	}
+
+	scenario := func(tt testArgs) (string, func(*testing.T)) {
+		return tt.name, func(t *testing.T) {
+			is := is.New(t)
+
+			tokenizer, err := NewSessionAESTokenizer([]byte("veibiequohy2eshaerohHoghootae1ku"))
+			is.NoErr(err)
+
+			clock := testClock()
+			cs := &SessionCookieStore{
+				ExpirationTime: time.Hour,
+				Tokenizer:      tokenizer,
+				Clock:          clock,
+			}
+
+			h := SessionAdminRequired(cs)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusNoContent)
+			}))
+
+			r := httptest.NewRequest(http.MethodPost, "/admin/kick", nil)
+			if tt.session {
+				// Admin flag has to survive token round trip.
+				token, err := tokenizer.TokenEncode(SessionState{
+					ID:       "uniqueid",
+					Nickname: "karol",
+					ExpireAt: clock.Now().Add(time.Hour),
+					Admin:    tt.admin,
+				})
+				is.NoErr(err)
+				r.AddCookie(&http.Cookie{Name: sessionCookieKey, Value: token})
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+
+			is.Equal(w.Code, tt.code)
+		}
+	}
+
+	t.Run(scenario(testArgs{
+		name:    "admin",
+		session: true,
+		admin:   true,
+		code:    http.StatusNoContent,
+	}))
+	t.Run(scenario(testArgs{
+		name:    "non-admin",
+		session: true,
+		admin:   false,
+		code:    http.StatusForbidden,
+	}))
+	t.Run(scenario(testArgs{
+		name:    "no session",
+		session: false,
+		code:    http.StatusUnauthorized,
+	}))
+}
+
+func TestSessionCookieStoreAttributes(t *testing.T) {
+	type testArgs struct {
+		name     string
+		secure   bool
+		sameSite http.SameSite
+		want     []string
+		notWant  []string
+	}
+
+	scenario := func(tt testArgs) (string, func(*testing.T)) {
+		return tt.name, func(t *testing.T) {
+			tokenizer, err := NewSessionAESTokenizer([]byte("veibiequohy2eshaerohHoghootae1ku"))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			cs := &SessionCookieStore{
+				ExpirationTime: time.Hour,
+				Tokenizer:      tokenizer,
+				Secure:         tt.secure,
+				SameSite:       tt.sameSite,
+				Clock:          testClock(),
+			}
+
+			check := func(t *testing.T, header string) {
+				is := is.New(t)
+				for _, attr := range tt.want {
+					is.True(strings.Contains(header, attr)) // missing attribute
+				}
+				for _, attr := range tt.notWant {
+					is.True(!strings.Contains(header, attr)) // unexpected attribute
+				}
+			}
+
+			t.Run("save", func(t *testing.T) {
+				is := is.New(t)
+				w := httptest.NewRecorder()
+				is.NoErr(cs.SaveSessionState(w, SessionState{ID: "id"}))
+				check(t, w.Header().Get("Set-Cookie"))
+			})
+			t.Run("clear", func(t *testing.T) {
+				w := httptest.NewRecorder()
+				cs.ClearState(w)
+				check(t, w.Header().Get("Set-Cookie"))
+			})
+		}
+	}
+
+	t.Run(scenario(testArgs{
+		name:    "defaults",
+		want:    []string{"HttpOnly", "SameSite=Lax"},
+		notWant: []string{"Secure"},
+	}))
+	t.Run(scenario(testArgs{
+		name:     "secure strict",
+		secure:   true,
+		sameSite: http.SameSiteStrictMode,
+		want:     []string{"HttpOnly", "Secure", "SameSite=Strict"},
+	}))
+	t.Run(scenario(testArgs{
+		name:     "none",
+		secure:   true,
+		sameSite: http.SameSiteNoneMode,
+		want:     []string{"HttpOnly", "Secure", "SameSite=None"},
+	}))
+}
+
+func TestSessionRevocation(t *testing.T) {
+	t.Run("revoke then reject", func(t *testing.T) {
+		is := is.New(t)
+
+		tokenizer, err := NewSessionAESTokenizer([]byte("veibiequohy2eshaerohHoghootae1ku"))
+		is.NoErr(err)
+
+		clock := testClock()
+		cs := &SessionCookieStore{
+			ExpirationTime: time.Hour,
+			Tokenizer:      tokenizer,
+			Revoker:        NewSessionRevokerMemory(clock),
+			Clock:          clock,
+		}
+
+		token, err := tokenizer.TokenEncode(SessionState{
+			ID:       "uniqueid",
+			Nickname: "karol",
+			ExpireAt: clock.Now().Add(time.Hour),
+		})
+		is.NoErr(err)
+
+		request := func(h http.Handler) *httptest.ResponseRecorder {
+			r := httptest.NewRequest(http.MethodPost, "/", nil)
+			r.AddCookie(&http.Cookie{Name: sessionCookieKey, Value: token})
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			return w
+		}
+
+		ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+		is.Equal(request(SessionRequired(cs)(ok)).Code, http.StatusOK)
+
+		w := request(SessionRequired(cs)(HandlerLogoutAll(cs)))
+		is.Equal(w.Code, http.StatusSeeOther)
+
+		// The same token is rejected after revocation.
+		is.Equal(request(SessionRequired(cs)(ok)).Code, http.StatusUnauthorized)
+	})
+
+	t.Run("sliding refresh", func(t *testing.T) {
+		is := is.New(t)
+
+		tokenizer, err := NewSessionAESTokenizer([]byte("veibiequohy2eshaerohHoghootae1ku"))
+		is.NoErr(err)
+
+		clock, move := testMovingClock()
+		cs := &SessionCookieStore{
+			ExpirationTime: time.Hour,
+			Tokenizer:      tokenizer,
+			Sliding:        true,
+			SlidingWindow:  time.Minute * 10,
+			Revoker:        NewSessionRevokerMemory(clock),
+			Clock:          clock,
+		}
+
+		token, err := tokenizer.TokenEncode(SessionState{
+			ID:       "uniqueid",
+			Nickname: "karol",
+			ExpireAt: clock.Now().Add(time.Hour),
+		})
+		is.NoErr(err)
+
+		request := func(token string, h http.Handler) *httptest.ResponseRecorder {
+			r := httptest.NewRequest(http.MethodPost, "/", nil)
+			r.AddCookie(&http.Cookie{Name: sessionCookieKey, Value: token})
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			return w
+		}
+		ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+		// Stolen copy of the session is refreshed with later
+		// expiration date.
+		move(time.Minute * 55)
+		w := request(token, SessionRequired(cs)(ok))
+		is.Equal(w.Code, http.StatusOK)
+		cookies := w.Result().Cookies()
+		is.Equal(len(cookies), 1)
+		stolen := cookies[0].Value
+
+		w = request(token, SessionRequired(cs)(HandlerLogoutAll(cs)))
+		is.Equal(w.Code, http.StatusSeeOther)
+
+		// Revocation outlives every copy of the session, also after
+		// revocation with older copy of it.
+		ctx := context.Background()
+		is.NoErr(cs.Revoker.RevokeSession(ctx, "uniqueid", clock.Now().Add(time.Minute)))
+		move(time.Minute * 30)
+		is.Equal(request(stolen, SessionRequired(cs)(ok)).Code, http.StatusUnauthorized)
+		move(time.Minute * 29)
+		is.Equal(request(stolen, SessionRequired(cs)(ok)).Code, http.StatusUnauthorized)
+	})
+
+	t.Run("revoke tokens of user", func(t *testing.T) {
+		is := is.New(t)
+
+		// Sessions of simple tokenizer are revoked by removing their
+		// tokens, even without revocation list.
+		clock := testClock()
+		store := NewSessionTokenStoreMemory(clock)
+		simple := NewSessionSimpleTokenizerWithStore(store)
+		simple.clock = clock
+		tokenizer := NewSessionTokenizerCache(SessionTokenizerCacheBuilder{
+			Wrapped: simple,
+			Timeout: time.Hour,
+			Logger:  testLogger(),
+		})
+		cs := &SessionCookieStore{
+			ExpirationTime: time.Hour,
+			Tokenizer:      tokenizer,
+			Clock:          clock,
+		}
+
+		encode := func(id string) string {
+			token, err := tokenizer.TokenEncode(SessionState{
+				ID:       id,
+				Nickname: "karol",
+				ExpireAt: clock.Now().Add(time.Hour),
+			})
+			is.NoErr(err)
+			return token
+		}
+		token, refreshed, other := encode("uniqueid"), encode("uniqueid"), encode("other")
+
+		request := func(token string, h http.Handler) *httptest.ResponseRecorder {
+			r := httptest.NewRequest(http.MethodPost, "/", nil)
+			r.AddCookie(&http.Cookie{Name: sessionCookieKey, Value: token})
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			return w
+		}
+
+		ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+		is.Equal(request(refreshed, SessionRequired(cs)(ok)).Code, http.StatusOK)
+
+		w := request(token, SessionRequired(cs)(HandlerLogoutAll(cs)))
+		is.Equal(w.Code, http.StatusSeeOther)
+
+		// Every token of the user is rejected, also the cached one,
+		// but sessions of other users are left intact.
+		is.Equal(request(token, SessionRequired(cs)(ok)).Code, http.StatusUnauthorized)
+		is.Equal(request(refreshed, SessionRequired(cs)(ok)).Code, http.StatusUnauthorized)
+		is.Equal(request(other, SessionRequired(cs)(ok)).Code, http.StatusOK)
+		is.Equal(len(store.tokens), 1)
+	})
+
+	t.Run("stateless tokens without revocation list", func(t *testing.T) {
+		is := is.New(t)
+
+		aes, err := NewSessionAESTokenizer([]byte("veibiequohy2eshaerohHoghootae1ku"))
+		is.NoErr(err)
+		tokenizer := NewSessionTokenizerCache(SessionTokenizerCacheBuilder{
+			Wrapped: aes,
+			Timeout: time.Hour,
+			Logger:  testLogger(),
+		})
+
+		clock := testClock()
+		cs := &SessionCookieStore{
+			ExpirationTime: time.Hour,
+			Tokenizer:      tokenizer,
+			Clock:          clock,
+		}
+
+		token, err := tokenizer.TokenEncode(SessionState{
+			ID:       "uniqueid",
+			Nickname: "karol",
+			ExpireAt: clock.Now().Add(time.Hour),
+		})
+		is.NoErr(err)
+
+		r := httptest.NewRequest(http.MethodPost, "/", nil)
+		r.AddCookie(&http.Cookie{Name: sessionCookieKey, Value: token})
+		w := httptest.NewRecorder()
+		SessionRequired(cs)(HandlerLogoutAll(cs)).ServeHTTP(w, r)
+		is.Equal(w.Code, http.StatusNotImplemented)
+	})
+
+	t.Run("garbage collection", func(t *testing.T) {
+		is := is.New(t)
+		ctx := context.Background()
+
+		now := time.Unix(1000, 0)
+		r := NewSessionRevokerMemory(ClockFunc(func() time.Time {
+			return now
+		}))
+
+		is.NoErr(r.RevokeSession(ctx, "old", now.Add(time.Minute)))
+		revoked, err := r.SessionRevoked(ctx, "old")
+		is.NoErr(err)
+		is.True(revoked)
+
+		// Session has expired, so it doesn't have to be revoked anymore.
+		now = now.Add(time.Hour)
+		revoked, err = r.SessionRevoked(ctx, "old")
+		is.NoErr(err)
+		is.True(!revoked)
+
+		is.NoErr(r.RevokeSession(ctx, "new", now.Add(time.Minute)))
+		is.Equal(len(r.revoked), 1)
+		_, ok := r.revoked["new"]
+		is.True(ok)
+	})
+}
+
+func TestWriteError(t *testing.T) {
+	type testArgs struct {
+		name   string
+		accept []string
+
+		wantPlain bool
+	}
+
+	scenario := func(tt testArgs) (string, func(*testing.T)) {
+		return tt.name, func(t *testing.T) {
+			is := is.New(t)
+
+			r := httptest.NewRequest(http.MethodPost, "/message", nil)
+			for _, accept := range tt.accept {
+				r.Header.Add("Accept", accept)
+			}
+			w := httptest.NewRecorder()
+
+			writeError(w, r, http.StatusBadRequest, ErrorReasonMessageEmpty, "Message cannot be empty.")
+			is.Equal(w.Code, http.StatusBadRequest)
+
+			if tt.wantPlain {
+				is.True(strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain"))
+				is.Equal(w.Body.String(), "Message cannot be empty.\n")
+				return
+			}
+
+			is.True(strings.HasPrefix(w.Header().Get("Content-Type"), "application/json"))
+			is.Equal(w.Body.String(), `{"error":{"code":400,"reason":"message_empty","message":"Message cannot be empty."}}`)
+		}
+	}
+
+	t.Run(scenario(testArgs{
+		name: "no accept header",
+	}))
+	t.Run(scenario(testArgs{
+		name:   "json",
+		accept: []string{"application/json"},
+	}))
+	t.Run(scenario(testArgs{
+		name:   "any",
+		accept: []string{"*/*"},
+	}))
+	t.Run(scenario(testArgs{
+		name:      "plain text",
+		accept:    []string{"text/plain"},
+		wantPlain: true,
+	}))
+	t.Run(scenario(testArgs{
+		name:      "browser",
+		accept:    []string{"text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8"},
+		wantPlain: true,
+	}))
+	t.Run(scenario(testArgs{
+		name:   "json preferred by quality",
+		accept: []string{"text/plain;q=0.5, application/json"},
+	}))
+	t.Run(scenario(testArgs{
+		name:      "many headers",
+		accept:    []string{"application/json;q=0.1", "text/plain"},
+		wantPlain: true,
+	}))
+	t.Run(scenario(testArgs{
+		name:   "malformed header",
+		accept: []string{"text/plain;;;=, application/json"},
+	}))
+}
@@ -0,0 +1,192 @@
+package service
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// StreamShutdownEvent is SSE event type sent to every open event
+// stream, right before it's closed because server is shutting down.
+const StreamShutdownEvent = "server-shutdown"
+
+// EventServerShutdown is data of server shutdown event. Retry is
+// number of milliseconds, after which client should reconnect.
+type EventServerShutdown struct {
+	Retry int64 `json:"retry"`
+}
+
+// StreamShutdown broadcasts shutdown of server to open event streams.
+// Every stream sends final server shutdown event and closes, so
+// clients can reconnect once server is back, instead of failing with
+// broken connection. Nil StreamShutdown never broadcasts shutdown.
+type StreamShutdown struct {
+	mtx      *sync.Mutex
+	done     chan struct{}
+	idle     chan struct{}
+	open     int
+	shutdown bool
+
+	// idleClosed tells whether idle has been closed, because streams
+	// opened after shutdown make open drop to zero again.
+	idleClosed bool
+}
+
+// NewStreamShutdown returns stream shutdown broadcaster.
+func NewStreamShutdown() *StreamShutdown {
+	return &StreamShutdown{
+		mtx:  &sync.Mutex{},
+		done: make(chan struct{}),
+		idle: make(chan struct{}),
+	}
+}
+
+// Done returns channel, which is closed when shutdown is broadcast.
+func (s *StreamShutdown) Done() <-chan struct{} {
+	if s == nil {
+		return nil
+	}
+	return s.done
+}
+
+// streamOpened registers open event stream.
+func (s *StreamShutdown) streamOpened() {
+	if s == nil {
+		return
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.open++
+}
+
+// streamClosed unregisters closed event stream.
+func (s *StreamShutdown) streamClosed() {
+	if s == nil {
+		return
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.open--
+	if s.open == 0 && s.shutdown {
+		s.closeIdle()
+	}
+}
+
+// closeIdle reports that there are no open streams left after
+// shutdown. It must be called with mutex locked.
+func (s *StreamShutdown) closeIdle() {
+	if s.idleClosed {
+		return
+	}
+	s.idleClosed = true
+	close(s.idle)
+}
+
+// Shutdown broadcasts shutdown to open event streams and waits until
+// all of them are closed, given grace period passes or given context
+// is done. It reports whether all streams have been closed. Streams
+// opened after shutdown are closed right away.
+func (s *StreamShutdown) Shutdown(ctx context.Context, grace time.Duration) bool {
+	s.mtx.Lock()
+	if !s.shutdown {
+		s.shutdown = true
+		close(s.done)
+		if s.open == 0 {
+			s.closeIdle()
+		}
+	}
+	s.mtx.Unlock()
+
+	timer := time.NewTimer(grace)
+	defer timer.Stop()
+
+	select {
+	case <-s.idle:
+		return true
+	case <-timer.C:
+		return false
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// ShutdownAnnouncer announces to every event listener that all of
+// currently subscribed users are leaving the chat, because server is
+// going down. Nil ShutdownAnnouncer never announces shutdown.
+type ShutdownAnnouncer struct {
+	Subscribers      *BridgeMessageHandler
+	UserLeftProducer *BridgeEventProducer[EventUserLeft]
+
+	Clock
+	IDGenerator
+
+	// announced holds IDs of users, who have been announced as
+	// leaving. It's nil until shutdown is announced.
+	mtx       *sync.Mutex
+	announced map[string]struct{}
+}
+
+// NewShutdownAnnouncer returns shutdown announcer of users subscribed
+// to given message handler, which sends user-left events with given
+// producer.
+func NewShutdownAnnouncer(subscribers *BridgeMessageHandler, producer *BridgeEventProducer[EventUserLeft], clock Clock, ids IDGenerator) *ShutdownAnnouncer {
+	return &ShutdownAnnouncer{
+		Subscribers:      subscribers,
+		UserLeftProducer: producer,
+		Clock:            clock,
+		IDGenerator:      ids,
+		mtx:              &sync.Mutex{},
+	}
+}
+
+// Announce sends user-left event for every user with active
+// subscription. User subscribed multiple times is announced once. It
+// blocks until all events are queued, so it should be called before
+// shutting down event bridge. Shutdown is announced only once.
+func (sa *ShutdownAnnouncer) Announce(ctx context.Context) {
+	sa.mtx.Lock()
+	if sa.announced != nil {
+		sa.mtx.Unlock()
+		return
+	}
+	sa.announced = map[string]struct{}{}
+	users := []ChatUser{}
+	for _, sub := range sa.Subscribers.ActiveSubscribers() {
+		if _, ok := sa.announced[sub.id]; ok {
+			continue
+		}
+		sa.announced[sub.id] = struct{}{}
+		users = append(users, UserPresentation(sub.id, sub.nickname))
+	}
+	sa.mtx.Unlock()
+
+	for _, user := range users {
+		id := sa.GenerateID()
+		sa.UserLeftProducer.SendEvent(ctx, id, EventUserLeft{
+			ID:     id,
+			User:   user,
+			LeftAt: sa.Now(),
+		})
+	}
+}
+
+// leave calls given unsubscribe func of event stream of user with given
+// ID, which is closing. It reports whether the user still has to be
+// announced as leaving, because shutdown announcement hasn't covered
+// them. Unsubscribing and announcement are mutually exclusive, so
+// users aren't announced twice.
+func (sa *ShutdownAnnouncer) leave(userID string, unsubscribe func()) bool {
+	if sa == nil {
+		unsubscribe()
+		return true
+	}
+
+	sa.mtx.Lock()
+	defer sa.mtx.Unlock()
+
+	unsubscribe()
+	_, ok := sa.announced[userID]
+	return !ok
+}
@@ -0,0 +1,216 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+
+	"github.com/fenole/szmaterlok/service/sse"
+)
+
+func TestStreamShutdown(t *testing.T) {
+	is := is.New(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	r := requestWithSession(ctx, httptest.NewRequest(http.MethodGet, "/stream", nil), &SessionState{
+		ID:       "id",
+		Nickname: "nickname",
+	})
+	w := newStreamRecorder()
+
+	shutdown := NewStreamShutdown()
+	subscribed := make(chan struct{}, 1)
+	h := HandlerStream(HandlerStreamDependencies{
+		ReconnectTime: time.Second * 3,
+		Shutdown:      shutdown,
+		MessageNotifier: MessageNotifierFunc(func(context.Context, MessageSubscribeRequest) func() {
+			select {
+			case subscribed <- struct{}{}:
+			default:
+			}
+			return func() {}
+		}),
+	})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		h(w, r)
+	}()
+	<-subscribed
+
+	// In-flight stream is closed with shutdown event within grace period.
+	is.True(shutdown.Shutdown(ctx, time.Second))
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("stream has not been closed")
+	}
+
+	want := "event: server-shutdown\nretry: 3000\ndata: {\"retry\":3000}\n\n"
+	is.Equal(w.String(), want)
+
+	// Shutdown is broadcast only once.
+	is.True(shutdown.Shutdown(ctx, time.Second))
+
+	// Streams opened after shutdown are closed right away.
+	for i := 0; i < 2; i++ {
+		w := newStreamRecorder()
+		h(w, r.Clone(r.Context()))
+		is.Equal(w.String(), want)
+	}
+
+	// Nil shutdown never closes streams.
+	var none *StreamShutdown
+	is.Equal(none.Done(), nil)
+}
+
+// userLeftEvents returns users of user-left events stored in given
+// storage, ordered by their IDs.
+func userLeftEvents(t *testing.T, storage *bridgeStorageMock) []ChatUser {
+	t.Helper()
+	is := is.New(t)
+
+	users := []ChatUser{}
+	for _, evt := range storage.Events() {
+		if evt.Name != BridgeUserLeft {
+			continue
+		}
+
+		data := EventUserLeft{}
+		is.NoErr(json.Unmarshal(evt.Data, &data))
+		users = append(users, data.User)
+	}
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].ID < users[j].ID
+	})
+
+	return users
+}
+
+func TestShutdownAnnouncer(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+	log := testLogger()
+
+	messageHandler := NewBridgeMessageHandler(log)
+	for _, sub := range []MessageSubscribeRequest{
+		{ID: "1", Nickname: "one", RequestID: "req1"},
+		{ID: "2", Nickname: "two", RequestID: "req2"},
+		{ID: "2", Nickname: "two", RequestID: "req3"},
+	} {
+		sub.Channel = make(chan sse.Event)
+		messageHandler.Subscribe(ctx, sub)
+	}
+
+	storage := newBridgeStorageMock()
+	bridge := NewBridge(ctx, BridgeBuilder{
+		Logger:  log,
+		Storage: storage,
+	})
+
+	announcer := NewShutdownAnnouncer(messageHandler, &BridgeEventProducer[EventUserLeft]{
+		EventBridge: bridge,
+		Type:        BridgeUserLeft,
+		Log:         log,
+		Clock:       testClock(),
+	}, testClock(), testIDGenerator())
+
+	// Users subscribed multiple times and repeated announcements don't
+	// produce duplicated events.
+	announcer.Announce(ctx)
+	announcer.Announce(ctx)
+	bridge.Shutdown(ctx)
+
+	is.Equal(userLeftEvents(t, storage), []ChatUser{
+		UserPresentation("1", "one"),
+		UserPresentation("2", "two"),
+	})
+}
+
+func TestShutdownAnnouncerStreams(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+	log := testLogger()
+
+	messageHandler := NewBridgeMessageHandler(log)
+	storage := newBridgeStorageMock()
+	bridge := NewBridge(ctx, BridgeBuilder{
+		Logger:  log,
+		Storage: storage,
+	})
+
+	leftProducer := &BridgeEventProducer[EventUserLeft]{
+		EventBridge: bridge,
+		Type:        BridgeUserLeft,
+		Log:         log,
+		Clock:       testClock(),
+	}
+	shutdown := NewShutdownAnnouncer(messageHandler, leftProducer, testClock(), testIDGenerator())
+	announcer := &EventAnnouncer{
+		MessageNotifier: messageHandler,
+		UserJoinProducer: &BridgeEventProducer[EventUserJoin]{
+			EventBridge: bridge,
+			Type:        BridgeUserJoin,
+			Log:         log,
+			Clock:       testClock(),
+		},
+		UserLeftProducer: leftProducer,
+		Shutdown:         shutdown,
+		Clock:            testClock(),
+		IDGenerator:      testIDGenerator(),
+	}
+
+	subscribe := func(id, requestID string) func() {
+		return announcer.Subscribe(context.WithValue(ctx, sessionStateKey, &SessionState{
+			ID:       id,
+			Nickname: id,
+		}), MessageSubscribeRequest{
+			ID:        id,
+			Nickname:  id,
+			RequestID: requestID,
+			Channel:   make(chan sse.Event, 4),
+		})
+	}
+
+	unsubscribeFirst := subscribe("first", "req1")
+	unsubscribeSecond := subscribe("second", "req2")
+	unsubscribeLeaving := subscribe("leaving", "req3")
+
+	// Stream closed before shutdown announces its user itself.
+	unsubscribeLeaving()
+
+	shutdown.Announce(ctx)
+
+	// Stream opened after shutdown announcement isn't covered by it.
+	unsubscribeLate := subscribe("late", "req4")
+
+	// Streams closed after announcement don't announce their users
+	// again.
+	unsubscribeFirst()
+	unsubscribeSecond()
+	unsubscribeLate()
+	bridge.Shutdown(ctx)
+
+	is.Equal(userLeftEvents(t, storage), []ChatUser{
+		UserPresentation("first", "first"),
+		UserPresentation("late", "late"),
+		UserPresentation("leaving", "leaving"),
+		UserPresentation("second", "second"),
+	})
+
+	// Nil shutdown announcer doesn't suppress user-left events.
+	var none *ShutdownAnnouncer
+	unsubscribed := false
+	is.True(none.leave("first", func() { unsubscribed = true }))
+	is.True(unsubscribed)
+}
@@ -0,0 +1,182 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/sirupsen/logrus"
+)
+
+// SlowModeStore holds slow mode intervals of chat channels. In slow
+// mode, every user has to wait for the interval between their messages
+// sent to the channel. Unlike rate limiting, slow mode applies to single
+// channel only.
+type SlowModeStore interface {
+	// SetSlowMode sets slow mode interval of given channel. Zero
+	// interval disables slow mode.
+	SetSlowMode(ctx context.Context, channel string, interval time.Duration) error
+
+	// AllowMessage records message of given user sent to given channel.
+	// When user has sent previous message too soon, message isn't
+	// recorded and AllowMessage returns time after which user can
+	// send the next one.
+	AllowMessage(ctx context.Context, channel, userID string) (time.Duration, error)
+}
+
+// slowModeKey identifies user posting in chat channel.
+type slowModeKey struct {
+	channel string
+	userID  string
+}
+
+// SlowModeMemory is in-memory SlowModeStore. Times of messages, which
+// no longer hold back their authors, are garbage collected on every
+// recorded message.
+type SlowModeMemory struct {
+	intervals map[string]time.Duration
+	last      map[slowModeKey]time.Time
+	mtx       *sync.Mutex
+	clock     Clock
+}
+
+// NewSlowModeMemory returns in-memory slow mode store with given
+// initial intervals of chat channels.
+func NewSlowModeMemory(clock Clock, intervals map[string]time.Duration) *SlowModeMemory {
+	s := &SlowModeMemory{
+		intervals: make(map[string]time.Duration),
+		last:      make(map[slowModeKey]time.Time),
+		mtx:       &sync.Mutex{},
+		clock:     clock,
+	}
+	for channel, interval := range intervals {
+		if interval > 0 {
+			s.intervals[ChatChannelOrDefault(channel)] = interval
+		}
+	}
+
+	return s
+}
+
+// SetSlowMode sets slow mode interval of given channel.
+func (s *SlowModeMemory) SetSlowMode(ctx context.Context, channel string, interval time.Duration) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	channel = ChatChannelOrDefault(channel)
+	if interval <= 0 {
+		delete(s.intervals, channel)
+		return nil
+	}
+
+	s.intervals[channel] = interval
+	return nil
+}
+
+// AllowMessage records message of given user sent to given channel.
+func (s *SlowModeMemory) AllowMessage(ctx context.Context, channel, userID string) (time.Duration, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	channel = ChatChannelOrDefault(channel)
+	interval, ok := s.intervals[channel]
+	if !ok {
+		return 0, nil
+	}
+
+	now := s.clock.Now()
+	key := slowModeKey{channel: channel, userID: userID}
+	if last, ok := s.last[key]; ok {
+		if wait := last.Add(interval).Sub(now); wait > 0 {
+			return wait, nil
+		}
+	}
+
+	for k, last := range s.last {
+		if !last.Add(s.intervals[k.channel]).After(now) {
+			delete(s.last, k)
+		}
+	}
+
+	s.last[key] = now
+	return 0, nil
+}
+
+// slowModeLimited responds to message sent to channel in slow mode
+// too soon. Client can retry after given duration.
+func slowModeLimited(w http.ResponseWriter, r *http.Request, retryAfter time.Duration) {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	writeError(w, r, http.StatusTooManyRequests, ErrorReasonSlowMode, fmt.Sprintf(
+		"Channel is in slow mode. You can send next message in %d seconds.", seconds,
+	))
+}
+
+// HandlerSlowModeDependencies holds arguments for HandlerSlowMode.
+type HandlerSlowModeDependencies struct {
+	Logger   *logrus.Logger
+	SlowMode SlowModeStore
+}
+
+// HandlerSlowMode sets slow mode interval of chat channel. Zero
+// seconds disable slow mode.
+func HandlerSlowMode(deps HandlerSlowModeDependencies) http.HandlerFunc {
+	type request struct {
+		Channel string `json:"channel"`
+		Seconds int64  `json:"seconds"`
+	}
+	type response struct {
+		Channel string `json:"channel"`
+		Seconds int64  `json:"seconds"`
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		log := deps.Logger.WithFields(logrus.Fields{
+			"reqID": middleware.GetReqID(ctx),
+		})
+
+		req := &request{}
+
+		defer r.Body.Close()
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			writeError(w, r, http.StatusBadRequest, ErrorReasonInvalidBody, "Failed to parse body.")
+			return
+		}
+
+		req.Channel = ChatChannelOrDefault(strings.TrimSpace(req.Channel))
+		if !validChatChannel(req.Channel) {
+			writeError(w, r, http.StatusBadRequest, ErrorReasonInvalidBody, fmt.Sprintf("Channel must consist of 1 to %d letters, digits, dashes or underscores.", ChatChannelMaxLength))
+			return
+		}
+		if req.Seconds < 0 {
+			writeError(w, r, http.StatusBadRequest, ErrorReasonInvalidBody, "Slow mode interval cannot be negative.")
+			return
+		}
+
+		interval := time.Duration(req.Seconds) * time.Second
+		if err := deps.SlowMode.SetSlowMode(ctx, req.Channel, interval); err != nil {
+			log.WithField("error", err.Error()).Error("Failed to set slow mode.")
+			writeError(w, r, http.StatusInternalServerError, ErrorReasonInternal, "Failed to set slow mode. Please try again later.")
+			return
+		}
+
+		log.WithFields(logrus.Fields{
+			"channel":  req.Channel,
+			"interval": interval,
+		}).Info("Slow mode has been set.")
+		jsonResponse(w, http.StatusOK, responseWrapper{
+			Data: response{
+				Channel: req.Channel,
+				Seconds: req.Seconds,
+			},
+		})
+	}
+}
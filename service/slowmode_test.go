@@ -0,0 +1,169 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/matryer/is"
+)
+
+func TestSlowModeMemory(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+
+	clock, move := testMovingClock()
+	store := NewSlowModeMemory(clock, map[string]time.Duration{
+		"general": time.Second * 10,
+	})
+
+	allow := func(channel, userID string) time.Duration {
+		t.Helper()
+		retryAfter, err := store.AllowMessage(ctx, channel, userID)
+		is.NoErr(err)
+		return retryAfter
+	}
+
+	t.Run("interval", func(t *testing.T) {
+		is := is.New(t)
+
+		is.Equal(allow("general", "user"), time.Duration(0))
+		is.Equal(allow("general", "user"), time.Second*10)
+
+		move(time.Second * 4)
+		is.Equal(allow("general", "user"), time.Second*6)
+
+		// Rejected messages don't extend the interval.
+		move(time.Second * 6)
+		is.Equal(allow("general", "user"), time.Duration(0))
+	})
+
+	t.Run("independence", func(t *testing.T) {
+		is := is.New(t)
+
+		is.Equal(allow("general", "other"), time.Duration(0))
+		is.True(allow("general", "other") > 0)
+
+		// Channels without slow mode aren't limited.
+		is.Equal(allow("random", "other"), time.Duration(0))
+		is.Equal(allow("random", "other"), time.Duration(0))
+
+		is.NoErr(store.SetSlowMode(ctx, "random", time.Minute))
+		is.Equal(allow("random", "other"), time.Duration(0))
+		is.Equal(allow("random", "other"), time.Minute)
+		is.True(allow("general", "other") > 0)
+
+		is.NoErr(store.SetSlowMode(ctx, "random", 0))
+		is.Equal(allow("random", "other"), time.Duration(0))
+	})
+
+	t.Run("collection", func(t *testing.T) {
+		is := is.New(t)
+
+		move(time.Minute)
+		is.Equal(allow("general", "last"), time.Duration(0))
+		is.Equal(len(store.last), 1)
+	})
+}
+
+func TestHandlerSlowMode(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+	log := testLogger()
+
+	clock, move := testMovingClock()
+	slowMode := NewSlowModeMemory(clock, nil)
+
+	sent := make(chan BridgeEvent, 1)
+	bridge := NewBridge(ctx, BridgeBuilder{
+		Handler: BridgeEventHandlerFunc(func(ctx context.Context, evt BridgeEvent) {
+			sent <- evt
+		}),
+		Logger:  log,
+		Storage: newBridgeStorageMock(),
+	})
+	defer bridge.Shutdown(ctx)
+
+	router := chi.NewRouter()
+	router.Post("/admin/slowmode", HandlerSlowMode(HandlerSlowModeDependencies{
+		Logger:   log,
+		SlowMode: slowMode,
+	}))
+	router.Post("/message", HandlerSendMessage(HandlerSendMessageDependencies{
+		MaxMessageSize: NewMessageSizeLimit(255),
+		Sender: &BridgeEventProducer[EventSentMessage]{
+			EventBridge: bridge,
+			Type:        BridgeMessageSent,
+			Log:         log,
+			Clock:       clock,
+		},
+		SlowMode:    slowMode,
+		Logger:      log,
+		IDGenerator: testIDGenerator(),
+		Clock:       clock,
+	}))
+
+	request := func(body string) int {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/admin/slowmode", strings.NewReader(body)))
+		return w.Code
+	}
+	send := func(id, channel string) *httptest.ResponseRecorder {
+		r := requestWithSession(ctx, httptest.NewRequest(http.MethodPost, "/message?channel="+channel, strings.NewReader(`{"content":"hello"}`)), &SessionState{
+			ID:       id,
+			Nickname: id,
+		})
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+
+		// Accepted messages have to be delivered before bridge shutdown.
+		if w.Code == http.StatusAccepted {
+			select {
+			case <-sent:
+			case <-time.After(time.Second):
+				t.Fatal("message has not been sent")
+			}
+		}
+		return w
+	}
+
+	t.Run("enforcement", func(t *testing.T) {
+		is := is.New(t)
+
+		is.Equal(request(`{"channel":"busy","seconds":30}`), http.StatusOK)
+
+		is.Equal(send("user", "busy").Code, http.StatusAccepted)
+
+		move(time.Second * 10)
+		w := send("user", "busy")
+		is.Equal(w.Code, http.StatusTooManyRequests)
+		is.Equal(w.Header().Get("Retry-After"), "20")
+
+		// Other users and channels aren't affected.
+		is.Equal(send("other", "busy").Code, http.StatusAccepted)
+		is.Equal(send("user", "quiet").Code, http.StatusAccepted)
+		is.Equal(send("user", "quiet").Code, http.StatusAccepted)
+
+		move(time.Second * 20)
+		is.Equal(send("user", "busy").Code, http.StatusAccepted)
+	})
+
+	t.Run("disable", func(t *testing.T) {
+		is := is.New(t)
+
+		is.Equal(request(`{"channel":"busy","seconds":0}`), http.StatusOK)
+		is.Equal(send("user", "busy").Code, http.StatusAccepted)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		is := is.New(t)
+
+		is.Equal(request(`{"channel":"busy","seconds":-1}`), http.StatusBadRequest)
+		is.Equal(request(`{`), http.StatusBadRequest)
+		is.Equal(request(`{"channel":"bu sy","seconds":30}`), http.StatusBadRequest)
+	})
+}
@@ -0,0 +1,137 @@
+package sse
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ClientRetryDefault is default reconnection time of client, used
+// until server sends its own hint.
+const ClientRetryDefault = time.Second * 3
+
+// ErrContentType is returned by client, when server responds with
+// other content than event stream. Client doesn't reconnect then.
+var ErrContentType = errors.New("sse: unexpected content type")
+
+// StatusError is returned by client, when server responds with status
+// code other than 200. Client doesn't reconnect after such response.
+type StatusError struct {
+	Code int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("sse: unexpected status code: %d", e.Code)
+}
+
+// Client consumes event stream. Like browsers' EventSource, it
+// reconnects after connection is lost, waiting for reconnection time
+// sent by server and resuming stream with Last-Event-ID header.
+type Client struct {
+	// HTTPClient sends requests. http.DefaultClient is used when
+	// it's nil.
+	HTTPClient *http.Client
+
+	// URL of event stream.
+	URL string
+
+	// Header is sent with every request. It can be used to
+	// authenticate, for example with session cookie.
+	Header http.Header
+
+	// Retry is reconnection time used until server sends its hint.
+	// ClientRetryDefault is used when it's zero.
+	Retry time.Duration
+}
+
+// Stream delivers events from event stream to given channel. It
+// blocks until given context is done or server responds with error,
+// which is returned. Lost connections are restored.
+func (c *Client) Stream(ctx context.Context, events chan<- Event) error {
+	retry := c.Retry
+	if retry <= 0 {
+		retry = ClientRetryDefault
+	}
+	lastEventID := ""
+
+	for {
+		err := c.connect(ctx, lastEventID, func(evt Event) error {
+			if evt.ID != "" {
+				lastEventID = evt.ID
+			}
+			if evt.Retry > 0 {
+				retry = time.Duration(evt.Retry) * time.Millisecond
+			}
+
+			select {
+			case events <- evt:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		})
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		statusErr := &StatusError{}
+		if errors.As(err, &statusErr) || errors.Is(err, ErrContentType) {
+			return err
+		}
+
+		timer := time.NewTimer(retry)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+}
+
+// connect opens single connection with event stream and passes
+// received events to given function until connection is closed.
+func (c *Client) connect(ctx context.Context, lastEventID string, deliver func(Event) error) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.URL, nil)
+	if err != nil {
+		return fmt.Errorf("http.NewRequestWithContext: %w", err)
+	}
+	for key, vals := range c.Header {
+		req.Header[key] = vals
+	}
+	req.Header.Set("Accept", ContentTypeEventStream)
+	req.Header.Set("Cache-Control", "no-cache")
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+
+	client := c.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("client.Do: %w", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return &StatusError{Code: res.StatusCode}
+	}
+	if ct := res.Header.Get("Content-Type"); !strings.HasPrefix(ct, ContentTypeEventStream) {
+		return fmt.Errorf("%w: %s", ErrContentType, ct)
+	}
+
+	s := NewScanner(res.Body)
+	for s.Scan() {
+		if err := deliver(s.Event()); err != nil {
+			return err
+		}
+	}
+
+	return s.Err()
+}
@@ -0,0 +1,167 @@
+package sse_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fenole/szmaterlok/service"
+	"github.com/fenole/szmaterlok/service/sse"
+	"github.com/matryer/is"
+)
+
+// testStreamServer runs HandlerStream behind session middleware.
+// Every connection receives given number of events, numbered across
+// connections, and then it's closed by the server. Last-Event-ID
+// headers of consecutive connections are recorded.
+type testStreamServer struct {
+	*httptest.Server
+
+	store   *service.SessionCookieStore
+	mtx     sync.Mutex
+	lastIDs []string
+	sent    int
+}
+
+func newTestStreamServer(t *testing.T, eventsPerConn int, reconnect time.Duration) *testStreamServer {
+	srv := &testStreamServer{
+		store: &service.SessionCookieStore{
+			ExpirationTime: time.Hour,
+			Tokenizer:      service.NewSessionSimpleTokenizer(),
+			Clock:          service.ClockFunc(time.Now),
+		},
+	}
+
+	stream := service.HandlerStream(service.HandlerStreamDependencies{
+		ReconnectTime: reconnect,
+		BufferSize:    eventsPerConn,
+		MessageNotifier: service.MessageNotifierFunc(func(ctx context.Context, args service.MessageSubscribeRequest) func() {
+			srv.mtx.Lock()
+			defer srv.mtx.Unlock()
+
+			for i := 0; i < eventsPerConn; i++ {
+				srv.sent++
+				args.Channel <- sse.Event{
+					Type: service.MessageSent,
+					ID:   strconv.Itoa(srv.sent),
+					Data: []byte(args.Nickname),
+				}
+			}
+			// Closed channel disconnects client.
+			close(args.Channel)
+
+			return func() {}
+		}),
+	})
+
+	h := service.SessionRequired(srv.store)(sse.Headers(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		srv.mtx.Lock()
+		srv.lastIDs = append(srv.lastIDs, r.Header.Get("Last-Event-ID"))
+		srv.mtx.Unlock()
+
+		stream(w, r)
+	})))
+
+	srv.Server = httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
+// session returns header with session cookie of user with given
+// nickname.
+func (s *testStreamServer) session(t *testing.T, nickname string) http.Header {
+	w := httptest.NewRecorder()
+	if err := s.store.SaveSessionState(w, service.SessionState{
+		ID:        "id",
+		Nickname:  nickname,
+		CreatedAt: time.Now(),
+		ExpireAt:  time.Now().Add(time.Hour),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	return http.Header{"Cookie": w.Header().Values("Set-Cookie")}
+}
+
+func (s *testStreamServer) LastEventIDs() []string {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return append([]string{}, s.lastIDs...)
+}
+
+func TestClient(t *testing.T) {
+	is := is.New(t)
+
+	srv := newTestStreamServer(t, 2, time.Millisecond*10)
+	c := &sse.Client{
+		URL:    srv.URL,
+		Header: srv.session(t, "bot"),
+
+		// Client would wait for too long without server's hint.
+		Retry: time.Hour,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	events := make(chan sse.Event)
+	errc := make(chan error, 1)
+	go func() {
+		errc <- c.Stream(ctx, events)
+	}()
+
+	for i := 1; i <= 6; i++ {
+		select {
+		case evt := <-events:
+			is.Equal(evt.ID, strconv.Itoa(i))
+			is.Equal(evt.Type, service.MessageSent)
+			is.Equal(string(evt.Data), "bot")
+		case <-time.After(time.Second * 5):
+			t.Fatalf("event %d has not been delivered", i)
+		}
+	}
+
+	cancel()
+	is.True(errors.Is(<-errc, context.Canceled))
+
+	// Client resumes stream after last received event.
+	is.Equal(srv.LastEventIDs()[:3], []string{"", "2", "4"})
+}
+
+func TestClientUnauthorized(t *testing.T) {
+	is := is.New(t)
+
+	srv := newTestStreamServer(t, 1, 0)
+	c := &sse.Client{
+		URL:   srv.URL,
+		Retry: time.Millisecond,
+	}
+
+	err := c.Stream(context.Background(), make(chan sse.Event))
+	statusErr := &sse.StatusError{}
+	is.True(errors.As(err, &statusErr)) // client should give up
+	is.Equal(statusErr.Code, http.StatusUnauthorized)
+}
+
+func TestClientContextCancel(t *testing.T) {
+	is := is.New(t)
+
+	srv := newTestStreamServer(t, 1, 0)
+	c := &sse.Client{
+		URL:    srv.URL,
+		Header: srv.session(t, "bot"),
+	}
+
+	// Nobody receives events, so client waits until it's cancelled.
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+
+	err := c.Stream(ctx, make(chan sse.Event))
+	is.True(errors.Is(err, context.DeadlineExceeded))
+}
@@ -0,0 +1,146 @@
+package sse
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strconv"
+)
+
+// scannerMaxLineSize is maximal length of single line of event stream.
+const scannerMaxLineSize = 1 << 20
+
+// Scanner reads consecutive events from event stream. Comments are
+// skipped, as well as blocks without data lines, for example
+// heartbeats. Incomplete event at the end of stream is discarded.
+//
+// Fields are parsed according to event stream interpretation of HTML
+// specification, but state isn't kept between events: every event
+// has only ID and retry value sent along with it.
+type Scanner struct {
+	lines *bufio.Scanner
+	evt   Event
+	err   error
+}
+
+// NewScanner returns scanner reading events from given stream.
+func NewScanner(r io.Reader) *Scanner {
+	lines := bufio.NewScanner(r)
+	lines.Buffer(make([]byte, 0, 4096), scannerMaxLineSize)
+	lines.Split(scanLines)
+
+	return &Scanner{
+		lines: lines,
+	}
+}
+
+// Scan advances scanner to the next event, which will then be
+// available through Event method. It returns false when stream
+// ends or an error occurs.
+func (s *Scanner) Scan() bool {
+	evt := Event{}
+	data := []byte{}
+	hasData := false
+
+	for s.lines.Scan() {
+		line := s.lines.Bytes()
+
+		// Blank line dispatches the event.
+		if len(line) == 0 {
+			if !hasData {
+				evt = Event{}
+				continue
+			}
+
+			// Last newline of data buffer is removed.
+			evt.Data = data[:len(data)-1]
+			s.evt = evt
+			return true
+		}
+
+		// Line starting with colon is a comment.
+		if line[0] == ':' {
+			continue
+		}
+
+		field, value := line, []byte{}
+		if i := bytes.IndexByte(line, ':'); i >= 0 {
+			field, value = line[:i], line[i+1:]
+			value = bytes.TrimPrefix(value, []byte(" "))
+		}
+
+		switch string(field) {
+		case "event":
+			evt.Type = string(value)
+		case "data":
+			data = append(data, value...)
+			data = append(data, '\n')
+			hasData = true
+		case "id":
+			if bytes.IndexByte(value, 0) < 0 {
+				evt.ID = string(value)
+			}
+		case "retry":
+			if retry, err := strconv.ParseInt(string(value), 10, 64); err == nil && retry >= 0 {
+				evt.Retry = retry
+			}
+		}
+	}
+
+	s.err = s.lines.Err()
+	return false
+}
+
+// Event returns the most recent event read by Scan.
+func (s *Scanner) Event() Event {
+	return s.evt
+}
+
+// Err returns first non-EOF error encountered by scanner.
+func (s *Scanner) Err() error {
+	return s.err
+}
+
+// Decode reads first event from given stream. It returns io.EOF when
+// stream ends before any complete event. Decode may read past the
+// end of the event, so consecutive events should be read with Scanner.
+func Decode(r io.Reader) (Event, error) {
+	s := NewScanner(r)
+	if s.Scan() {
+		return s.Event(), nil
+	}
+	if err := s.Err(); err != nil {
+		return Event{}, err
+	}
+
+	return Event{}, io.EOF
+}
+
+// scanLines is split function for bufio.Scanner, which splits stream
+// into lines terminated with CRLF, LF or CR. Terminators are removed.
+func scanLines(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if atEOF && len(data) == 0 {
+		return 0, nil, nil
+	}
+
+	if i := bytes.IndexAny(data, "\r\n"); i >= 0 {
+		if data[i] == '\n' {
+			return i + 1, data[:i], nil
+		}
+
+		// CR may be followed by LF, which has not been read yet.
+		if i+1 == len(data) && !atEOF {
+			return 0, nil, nil
+		}
+		if i+1 < len(data) && data[i+1] == '\n' {
+			return i + 2, data[:i], nil
+		}
+		return i + 1, data[:i], nil
+	}
+
+	if atEOF {
+		return len(data), data, nil
+	}
+
+	return 0, nil, nil
+}
@@ -71,7 +71,7 @@ func Encode(stream io.Writer, v Event) error {
 		}
 	}
 
-	for _, l := range bytes.Split(v.Data, []byte("\n")) {
+	for _, l := range splitLines(v.Data) {
 		if _, err := fmt.Fprintf(stream, "data: %s\n", l); err != nil {
 			return fmt.Errorf("fmt.Fprintf: %w", err)
 		}
@@ -83,6 +83,32 @@ func Encode(stream io.Writer, v Event) error {
 	return nil
 }
 
+// splitLines splits given data into lines terminated with CRLF, LF or
+// CR, as all of them end line of event stream. Carriage returns would
+// otherwise break framing of encoded event.
+func splitLines(data []byte) [][]byte {
+	data = bytes.ReplaceAll(data, []byte("\r\n"), []byte("\n"))
+	data = bytes.ReplaceAll(data, []byte("\r"), []byte("\n"))
+	return bytes.Split(data, []byte("\n"))
+}
+
+// EncodeComment writes given text as event stream comment to the stream,
+// followed by a newline character. Comments are ignored by clients, but
+// they can be used to keep idle connections alive. Multiline text is
+// written as multiple comment lines.
+func EncodeComment(stream io.Writer, text string) error {
+	for _, l := range splitLines([]byte(text)) {
+		if _, err := fmt.Fprintf(stream, ": %s\n", l); err != nil {
+			return fmt.Errorf("fmt.Fprintf: %w", err)
+		}
+	}
+	if _, err := fmt.Fprint(stream, "\n"); err != nil {
+		return fmt.Errorf("fmt.Fprintf: %w", err)
+	}
+
+	return nil
+}
+
 // ContentTypeEventStream is content type for event stream filetype.
 const ContentTypeEventStream string = "text/event-stream"
 
@@ -91,7 +117,10 @@ func Headers(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", ContentTypeEventStream)
 		w.Header().Set("Cache-Control", "no-cache")
-		w.Header().Set("Connection", "keep-alive")
+		// Connection-specific headers aren't allowed in HTTP/2.
+		if r.ProtoMajor < 2 {
+			w.Header().Set("Connection", "keep-alive")
+		}
 
 		next.ServeHTTP(w, r)
 	})
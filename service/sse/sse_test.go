@@ -1,31 +1,21 @@
 package sse
 
 import (
+	"bytes"
+	"io"
+	"strings"
 	"testing"
 
 	"github.com/matryer/is"
 )
 
-func TestEventStream(t *testing.T) {
-	type testArgs struct {
-		name  string
-		event Event
-		want  string
-	}
-
-	scenario := func(tt testArgs) (string, func(*testing.T)) {
-		return tt.name, func(t *testing.T) {
-			is := is.New(t)
-
-			stream, err := tt.event.Stream()
-			is.NoErr(err)
-			is.True(stream != nil)
-
-			is.Equal(string(stream), tt.want)
-		}
-	}
-
-	t.Run(scenario(testArgs{
+// eventFixtures are events along with their event stream encoding.
+var eventFixtures = []struct {
+	name  string
+	event Event
+	want  string
+}{
+	{
 		name: "minimal event",
 		event: Event{
 			Type: "usermessage",
@@ -35,9 +25,8 @@ func TestEventStream(t *testing.T) {
 data: {"username": "bobby", "time": "02:34:11", "text": "Hi everyone."}
 
 `,
-	}))
-
-	t.Run(scenario(testArgs{
+	},
+	{
 		name: "event with id",
 		event: Event{
 			Type: "notifyusers",
@@ -49,9 +38,8 @@ id: someid
 data: {"which": "all", "time": "2:34:11", "text": "This is notification."}
 
 `,
-	}))
-
-	t.Run(scenario(testArgs{
+	},
+	{
 		name: "multiline data with id and retry value",
 		event: Event{
 			Type:  "hugevent",
@@ -67,5 +55,183 @@ data: two
 data: three
 
 `,
+	},
+}
+
+func TestEventStream(t *testing.T) {
+	for _, tt := range eventFixtures {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			is := is.New(t)
+
+			stream, err := tt.event.Stream()
+			is.NoErr(err)
+			is.True(stream != nil)
+
+			is.Equal(string(stream), tt.want)
+		})
+	}
+}
+
+func TestEncodeLineEndings(t *testing.T) {
+	type testArgs struct {
+		name string
+		data string
+		want string
+	}
+
+	scenario := func(tt testArgs) (string, func(*testing.T)) {
+		return tt.name, func(t *testing.T) {
+			is := is.New(t)
+
+			buff := &bytes.Buffer{}
+			is.NoErr(Encode(buff, Event{Type: "usermessage", Data: []byte(tt.data)}))
+			is.Equal(buff.String(), tt.want)
+		}
+	}
+
+	t.Run(scenario(testArgs{
+		name: "crlf",
+		data: "one\r\ntwo",
+		want: "event: usermessage\ndata: one\ndata: two\n\n",
+	}))
+	t.Run(scenario(testArgs{
+		name: "lone cr",
+		data: "one\rtwo",
+		want: "event: usermessage\ndata: one\ndata: two\n\n",
+	}))
+	t.Run(scenario(testArgs{
+		name: "mixed",
+		data: "one\r\r\ntwo\nthree\r",
+		want: "event: usermessage\ndata: one\ndata: \ndata: two\ndata: three\ndata: \n\n",
+	}))
+}
+
+func TestDecode(t *testing.T) {
+	for _, tt := range eventFixtures {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			is := is.New(t)
+
+			buff := &bytes.Buffer{}
+			is.NoErr(Encode(buff, tt.event))
+
+			evt, err := Decode(buff)
+			is.NoErr(err)
+			is.Equal(evt, tt.event)
+		})
+	}
+
+	t.Run("empty stream", func(t *testing.T) {
+		is := is.New(t)
+
+		_, err := Decode(strings.NewReader(""))
+		is.Equal(err, io.EOF)
+	})
+
+	t.Run("incomplete event", func(t *testing.T) {
+		is := is.New(t)
+
+		_, err := Decode(strings.NewReader("event: usermessage\ndata: hi\n"))
+		is.Equal(err, io.EOF)
+	})
+}
+
+func TestScanner(t *testing.T) {
+	type testArgs struct {
+		name   string
+		stream string
+		want   []Event
+	}
+
+	scenario := func(tt testArgs) (string, func(*testing.T)) {
+		return tt.name, func(t *testing.T) {
+			is := is.New(t)
+
+			s := NewScanner(strings.NewReader(tt.stream))
+			got := []Event{}
+			for s.Scan() {
+				got = append(got, s.Event())
+			}
+			is.NoErr(s.Err())
+			is.Equal(got, tt.want)
+		}
+	}
+
+	t.Run(scenario(testArgs{
+		name: "comments and heartbeats are skipped",
+		stream: ": keep-alive\n\n" +
+			"event: one\nid: 1\n: comment inside event\ndata: first\n\n" +
+			": keep-alive\n\n" +
+			"event: two\ndata: second\n\n",
+		want: []Event{
+			{Type: "one", ID: "1", Data: []byte("first")},
+			{Type: "two", Data: []byte("second")},
+		},
+	}))
+	t.Run(scenario(testArgs{
+		name:   "crlf and cr line endings",
+		stream: "event: one\r\ndata: a\r\ndata: b\r\n\r\nevent: two\rdata: c\r\r",
+		want: []Event{
+			{Type: "one", Data: []byte("a\nb")},
+			{Type: "two", Data: []byte("c")},
+		},
+	}))
+	t.Run(scenario(testArgs{
+		name:   "values without space and fields without value",
+		stream: "event:one\ndata\ndata:x\nretry: soon\nunknown: field\n\n",
+		want: []Event{
+			{Type: "one", Data: []byte("\nx")},
+		},
+	}))
+	t.Run(scenario(testArgs{
+		name:   "empty data",
+		stream: "event: one\ndata: \n\n",
+		want: []Event{
+			{Type: "one", Data: []byte{}},
+		},
+	}))
+	t.Run(scenario(testArgs{
+		name:   "incomplete last event",
+		stream: "event: one\ndata: a\n\nevent: two\ndata: b\n",
+		want: []Event{
+			{Type: "one", Data: []byte("a")},
+		},
+	}))
+}
+
+func TestEncodeComment(t *testing.T) {
+	type testArgs struct {
+		name string
+		text string
+		want string
+	}
+
+	scenario := func(tt testArgs) (string, func(*testing.T)) {
+		return tt.name, func(t *testing.T) {
+			is := is.New(t)
+
+			buff := &bytes.Buffer{}
+			is.NoErr(EncodeComment(buff, tt.text))
+			is.Equal(buff.String(), tt.want)
+		}
+	}
+
+	t.Run(scenario(testArgs{
+		name: "keep-alive",
+		text: "keep-alive",
+		want: ": keep-alive\n\n",
+	}))
+
+	t.Run(scenario(testArgs{
+		name: "multiline",
+		text: "one\ntwo",
+		want: ": one\n: two\n\n",
+	}))
+
+	t.Run(scenario(testArgs{
+		name: "carriage returns",
+		text: "one\r\ntwo\rthree",
+		want: ": one\n: two\n: three\n\n",
 	}))
 }
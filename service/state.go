@@ -5,9 +5,13 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"sort"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/sirupsen/logrus"
+	"golang.org/x/exp/slices"
 )
 
 // StateChatUser contains data of single user who is
@@ -15,21 +19,56 @@ import (
 type StateChatUser struct {
 	ID       string
 	Nickname string
+
+	// Status is presence status of user: PresenceOnline or
+	// PresenceAway.
+	Status string
+
+	// LastSeen is time of the last activity of user.
+	LastSeen time.Time
+
+	// StatusChangedAt is time of the last change of status.
+	StatusChangedAt time.Time
+
+	// HeartbeatAt is time of the last heartbeat of user event streams.
+	// Unlike LastSeen, it tells whether user is still connected, not
+	// whether user is active.
+	HeartbeatAt time.Time
 }
 
+// Presence statuses of online users.
+const (
+	// PresenceOnline is status of active user.
+	PresenceOnline = "online"
+
+	// PresenceAway is status of user who has been inactive for a while
+	// or has marked themselves as away.
+	PresenceAway = "away"
+)
+
+// ErrInvalidPresenceStatus is returned for unknown presence statuses.
+var ErrInvalidPresenceStatus = errors.New("state: invalid presence status")
+
 // StateOnlineUsers contains data for users, which
 // are currently using chat.
+//
+// Single user can be connected to the chat multiple times (for
+// example from many browser tabs), so StateOnlineUsers counts
+// connections of every user. User is removed from the state when the
+// last of user connections is closed.
 type StateOnlineUsers struct {
-	mtx   *sync.Mutex
-	state map[string]StateChatUser
+	mtx         *sync.Mutex
+	state       map[string]StateChatUser
+	connections map[string]int
 }
 
 // NewStateOnlineUsers is constructor for StateOnlineUsers. Using
 // NewStateOnlineUsers is the only safe way to construct StateOnlineUsers.
 func NewStateOnlineUsers() *StateOnlineUsers {
 	return &StateOnlineUsers{
-		mtx:   &sync.Mutex{},
-		state: map[string]StateChatUser{},
+		mtx:         &sync.Mutex{},
+		state:       map[string]StateChatUser{},
+		connections: map[string]int{},
 	}
 }
 
@@ -44,25 +83,237 @@ func (s *StateOnlineUsers) AllChatUsers(ctx context.Context) ([]OnlineChatUser,
 		res = append(res, OnlineChatUser{
 			ID:       u.ID,
 			Nickname: u.Nickname,
+			Status:   u.Status,
 		})
 	}
 
 	return res, nil
 }
 
-// PushChatUser saves data of user which is logging in.
+// ChatUsersOptions filters and pages list of online users.
+type ChatUsersOptions struct {
+	// Prefix of nickname. Nicknames are matched case-insensitively.
+	Prefix string
+
+	// Offset is number of users skipped from the start of the list.
+	Offset int
+
+	// Limit is maximal number of returned users. Zero means no limit.
+	Limit int
+}
+
+// ChatUsers returns online users matching given options, sorted by
+// their nicknames case-insensitively. Users with equal nicknames are
+// sorted by ID.
+func (s *StateOnlineUsers) ChatUsers(ctx context.Context, opts ChatUsersOptions) ([]OnlineChatUser, error) {
+	prefix := strings.ToLower(opts.Prefix)
+	res := []OnlineChatUser{}
+
+	s.mtx.Lock()
+	for _, u := range s.state {
+		if !strings.HasPrefix(strings.ToLower(u.Nickname), prefix) {
+			continue
+		}
+
+		res = append(res, OnlineChatUser{
+			ID:       u.ID,
+			Nickname: u.Nickname,
+			Status:   u.Status,
+		})
+	}
+	s.mtx.Unlock()
+
+	sort.Slice(res, func(i, j int) bool {
+		a, b := strings.ToLower(res[i].Nickname), strings.ToLower(res[j].Nickname)
+		if a == b {
+			return res[i].ID < res[j].ID
+		}
+		return a < b
+	})
+
+	if opts.Offset >= len(res) {
+		return []OnlineChatUser{}, nil
+	}
+	res = res[opts.Offset:]
+
+	if opts.Limit > 0 && opts.Limit < len(res) {
+		res = res[:opts.Limit]
+	}
+
+	return res, nil
+}
+
+// Count returns number of users which are currently using chat.
+func (s *StateOnlineUsers) Count(ctx context.Context) int {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	return len(s.state)
+}
+
+// ChatUser returns online user with given ID. It returns ErrNoSuchUser
+// if there is no such user.
+func (s *StateOnlineUsers) ChatUser(ctx context.Context, id string) (OnlineChatUser, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	u, ok := s.state[id]
+	if !ok {
+		return OnlineChatUser{}, ErrNoSuchUser
+	}
+
+	return OnlineChatUser{
+		ID:       u.ID,
+		Nickname: u.Nickname,
+		Status:   u.Status,
+	}, nil
+}
+
+// PushChatUser saves data of user which is logging in. Every push
+// counts as separate connection of the user. Users without status
+// are online.
 func (s *StateOnlineUsers) PushChatUser(ctx context.Context, u StateChatUser) error {
 	s.mtx.Lock()
 	defer s.mtx.Unlock()
 
+	if u.Status == "" {
+		u.Status = PresenceOnline
+	}
+	if u.HeartbeatAt.IsZero() {
+		u.HeartbeatAt = u.LastSeen
+	}
 	s.state[u.ID] = u
+	s.connections[u.ID]++
 
 	return nil
 }
 
 var ErrNoSuchUser = errors.New("state: there is no such user")
 
-// RemoveChatUser removes user with given id from state storage.
+// SetStatus sets presence status of user with given ID at given time.
+// It reports whether status of user has changed. Setting online status
+// counts as activity of user.
+func (s *StateOnlineUsers) SetStatus(ctx context.Context, id, status string, at time.Time) (bool, error) {
+	if status != PresenceOnline && status != PresenceAway {
+		return false, ErrInvalidPresenceStatus
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	u, ok := s.state[id]
+	if !ok {
+		return false, ErrNoSuchUser
+	}
+
+	changed := u.Status != status
+	u.Status = status
+	u.StatusChangedAt = at
+	if status == PresenceOnline && at.After(u.LastSeen) {
+		u.LastSeen = at
+	}
+	s.state[id] = u
+
+	return changed, nil
+}
+
+// Touch records activity of user with given ID at given time. Status of
+// user isn't changed until the next Sweep.
+func (s *StateOnlineUsers) Touch(ctx context.Context, id string, at time.Time) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	u, ok := s.state[id]
+	if !ok {
+		return ErrNoSuchUser
+	}
+
+	if at.After(u.LastSeen) {
+		u.LastSeen = at
+		s.state[id] = u
+	}
+
+	return nil
+}
+
+// Heartbeat records that event stream of user with given ID is still
+// open at given time.
+func (s *StateOnlineUsers) Heartbeat(ctx context.Context, id string, at time.Time) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	u, ok := s.state[id]
+	if !ok {
+		return ErrNoSuchUser
+	}
+
+	if at.After(u.HeartbeatAt) {
+		u.HeartbeatAt = at
+		s.state[id] = u
+	}
+
+	return nil
+}
+
+// Expire removes users, whose event streams haven't sent heartbeat for
+// given time to live, along with all of their connections. Such users
+// have been disconnected without closing their streams, for example by
+// network failure. It returns removed users sorted by their IDs.
+func (s *StateOnlineUsers) Expire(ctx context.Context, now time.Time, ttl time.Duration) []StateChatUser {
+	res := []StateChatUser{}
+
+	s.mtx.Lock()
+	for id, u := range s.state {
+		if now.Sub(u.HeartbeatAt) < ttl {
+			continue
+		}
+
+		delete(s.state, id)
+		delete(s.connections, id)
+		res = append(res, u)
+	}
+	s.mtx.Unlock()
+
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].ID < res[j].ID
+	})
+
+	return res
+}
+
+// Sweep marks online users, who haven't been active for given idle
+// period, as away. Away users, who have been active since their
+// status has changed, are marked as online again. It returns users
+// with changed status sorted by their IDs.
+func (s *StateOnlineUsers) Sweep(ctx context.Context, now time.Time, idle time.Duration) []StateChatUser {
+	res := []StateChatUser{}
+
+	s.mtx.Lock()
+	for id, u := range s.state {
+		switch {
+		case u.Status == PresenceOnline && now.Sub(u.LastSeen) >= idle:
+			u.Status = PresenceAway
+		case u.Status == PresenceAway && u.LastSeen.After(u.StatusChangedAt):
+			u.Status = PresenceOnline
+		default:
+			continue
+		}
+
+		u.StatusChangedAt = now
+		s.state[id] = u
+		res = append(res, u)
+	}
+	s.mtx.Unlock()
+
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].ID < res[j].ID
+	})
+
+	return res
+}
+
+// RemoveChatUser closes single connection of user with given id. User
+// is removed from state storage, when all of user connections are closed.
 func (s *StateOnlineUsers) RemoveChatUser(ctx context.Context, id string) error {
 	s.mtx.Lock()
 	defer s.mtx.Unlock()
@@ -72,7 +323,13 @@ func (s *StateOnlineUsers) RemoveChatUser(ctx context.Context, id string) error
 		return ErrNoSuchUser
 	}
 
+	s.connections[id]--
+	if s.connections[id] > 0 {
+		return nil
+	}
+
 	delete(s.state, id)
+	delete(s.connections, id)
 
 	return nil
 }
@@ -94,8 +351,11 @@ func StateUserJoinHook(log *logrus.Logger, s *StateOnlineUsers) BridgeEventHandl
 		}
 
 		if err := s.PushChatUser(ctx, StateChatUser{
-			ID:       evtData.User.ID,
-			Nickname: evtData.User.Nickname,
+			ID:              evtData.User.ID,
+			Nickname:        evtData.User.Nickname,
+			Status:          PresenceOnline,
+			LastSeen:        evtData.JoinedAt,
+			StatusChangedAt: evtData.JoinedAt,
 		}); err != nil {
 			log.WithFields(logrus.Fields{
 				"scope":   "StateUserJoinHook",
@@ -123,7 +383,8 @@ func StateUserLeftHook(log *logrus.Logger, s *StateOnlineUsers) BridgeEventHandl
 			return
 		}
 
-		if err := s.RemoveChatUser(ctx, evtData.User.ID); err != nil {
+		// User could have already been expired by presence sweeper.
+		if err := s.RemoveChatUser(ctx, evtData.User.ID); err != nil && !errors.Is(err, ErrNoSuchUser) {
 			log.WithFields(logrus.Fields{
 				"scope":   "StateUserLeftHook",
 				"reqID":   evt.Headers.Get(bridgeRequestIDHeaderVar),
@@ -135,6 +396,234 @@ func StateUserLeftHook(log *logrus.Logger, s *StateOnlineUsers) BridgeEventHandl
 	}
 }
 
+// StateUserActivityHook records sending of message as activity of its
+// author, so away users become online again.
+func StateUserActivityHook(log *logrus.Logger, s *StateOnlineUsers) BridgeEventHandlerFunc {
+	return func(ctx context.Context, evt BridgeEvent) {
+		evtData := &EventSentMessage{}
+
+		if err := json.Unmarshal(evt.Data, evtData); err != nil {
+			log.WithFields(logrus.Fields{
+				"scope":   "StateUserActivityHook",
+				"reqID":   evt.Headers.Get(bridgeRequestIDHeaderVar),
+				"eventID": evt.ID,
+				"error":   err.Error(),
+			}).Errorln("Failed to unmarshal EventSentMessage data.")
+			return
+		}
+
+		// Author could have already left the chat.
+		if err := s.Touch(ctx, evtData.From.ID, evtData.SentAt); err != nil && !errors.Is(err, ErrNoSuchUser) {
+			log.WithFields(logrus.Fields{
+				"scope":   "StateUserActivityHook",
+				"reqID":   evt.Headers.Get(bridgeRequestIDHeaderVar),
+				"eventID": evt.ID,
+				"error":   err.Error(),
+			}).Errorln("Failed to record user activity.")
+		}
+	}
+}
+
+// StateMessage contains data of single message sent to the chat.
+type StateMessage struct {
+	ID       string
+	From     ChatUser
+	Channel  string
+	To       *ChatUser
+	Content  string
+	SentAt   time.Time
+	EditedAt time.Time
+
+	// Sequence is sequence number of event, which sent the message.
+	Sequence uint64
+}
+
+// StateMessages contains data of recent messages sent to the chat.
+// Only given number of the most recently sent messages is kept, older
+// ones are evicted in order they were sent.
+type StateMessages struct {
+	mtx   *sync.Mutex
+	size  int
+	state map[string]StateMessage
+
+	// order holds IDs of kept messages in order they were sent. IDs
+	// of deleted messages stay in it until they're evicted.
+	order []string
+}
+
+// NewStateMessages is constructor for StateMessages, which keeps given
+// number of recent messages. Using NewStateMessages is the only safe way
+// to construct StateMessages.
+func NewStateMessages(size int) *StateMessages {
+	return &StateMessages{
+		mtx:   &sync.Mutex{},
+		size:  size,
+		state: map[string]StateMessage{},
+	}
+}
+
+var ErrNoSuchMessage = errors.New("state: there is no such message")
+
+// Message returns message with given ID. It returns ErrNoSuchMessage
+// if there is no such message.
+func (s *StateMessages) Message(ctx context.Context, id string) (StateMessage, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	m, ok := s.state[id]
+	if !ok {
+		return StateMessage{}, ErrNoSuchMessage
+	}
+
+	return m, nil
+}
+
+// PushMessage saves data of sent message. The oldest message is evicted
+// when state is full.
+func (s *StateMessages) PushMessage(ctx context.Context, m StateMessage) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if _, ok := s.state[m.ID]; ok {
+		s.state[m.ID] = m
+		return nil
+	}
+
+	s.state[m.ID] = m
+	s.order = append(s.order, m.ID)
+	if len(s.order) > s.size {
+		delete(s.state, s.order[0])
+		s.order = s.order[1:]
+	}
+
+	return nil
+}
+
+// EditMessage overwrites content of message with given ID. Edits are
+// applied only if they're not older than the last applied edit, so
+// the latest edit always wins.
+func (s *StateMessages) EditMessage(ctx context.Context, id, content string, editedAt time.Time) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	m, ok := s.state[id]
+	if !ok {
+		return ErrNoSuchMessage
+	}
+
+	if editedAt.Before(m.EditedAt) {
+		return nil
+	}
+
+	m.Content = content
+	m.EditedAt = editedAt
+	s.state[id] = m
+
+	return nil
+}
+
+// RemoveMessage removes message with given ID from state storage.
+func (s *StateMessages) RemoveMessage(ctx context.Context, id string) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if _, ok := s.state[id]; !ok {
+		return ErrNoSuchMessage
+	}
+
+	delete(s.state, id)
+
+	return nil
+}
+
+// StateMessageSentHook saves every sent message in state messages storage.
+func StateMessageSentHook(log *logrus.Logger, s *StateMessages) BridgeEventHandlerFunc {
+	return func(ctx context.Context, evt BridgeEvent) {
+		evtData := &EventSentMessage{}
+
+		if err := json.Unmarshal(evt.Data, evtData); err != nil {
+			log.WithFields(logrus.Fields{
+				"scope":   "StateMessageSentHook",
+				"reqID":   evt.Headers.Get(bridgeRequestIDHeaderVar),
+				"eventID": evt.ID,
+				"error":   err.Error(),
+			}).Errorln("Failed to unmarshal EventSentMessage data.")
+			return
+		}
+
+		if err := s.PushMessage(ctx, StateMessage{
+			ID:       evtData.ID,
+			From:     evtData.From,
+			Channel:  evtData.Channel,
+			To:       evtData.To,
+			Content:  evtData.Content,
+			SentAt:   evtData.SentAt,
+			Sequence: evt.Sequence,
+		}); err != nil {
+			log.WithFields(logrus.Fields{
+				"scope":   "StateMessageSentHook",
+				"reqID":   evt.Headers.Get(bridgeRequestIDHeaderVar),
+				"eventID": evt.ID,
+				"error":   err.Error(),
+			}).Errorln("Failed to push message.")
+		}
+	}
+}
+
+// StateMessageEditedHook applies message edits to state messages storage.
+func StateMessageEditedHook(log *logrus.Logger, s *StateMessages) BridgeEventHandlerFunc {
+	return func(ctx context.Context, evt BridgeEvent) {
+		evtData := &EventMessageEdited{}
+
+		if err := json.Unmarshal(evt.Data, evtData); err != nil {
+			log.WithFields(logrus.Fields{
+				"scope":   "StateMessageEditedHook",
+				"reqID":   evt.Headers.Get(bridgeRequestIDHeaderVar),
+				"eventID": evt.ID,
+				"error":   err.Error(),
+			}).Errorln("Failed to unmarshal EventMessageEdited data.")
+			return
+		}
+
+		if err := s.EditMessage(
+			ctx, evtData.MessageID, evtData.Content, evtData.EditedAt,
+		); err != nil {
+			log.WithFields(logrus.Fields{
+				"scope":   "StateMessageEditedHook",
+				"reqID":   evt.Headers.Get(bridgeRequestIDHeaderVar),
+				"eventID": evt.ID,
+				"error":   err.Error(),
+			}).Errorln("Failed to edit message.")
+		}
+	}
+}
+
+// StateMessageDeletedHook removes deleted messages from state messages storage.
+func StateMessageDeletedHook(log *logrus.Logger, s *StateMessages) BridgeEventHandlerFunc {
+	return func(ctx context.Context, evt BridgeEvent) {
+		evtData := &EventMessageDeleted{}
+
+		if err := json.Unmarshal(evt.Data, evtData); err != nil {
+			log.WithFields(logrus.Fields{
+				"scope":   "StateMessageDeletedHook",
+				"reqID":   evt.Headers.Get(bridgeRequestIDHeaderVar),
+				"eventID": evt.ID,
+				"error":   err.Error(),
+			}).Errorln("Failed to unmarshal EventMessageDeleted data.")
+			return
+		}
+
+		if err := s.RemoveMessage(ctx, evtData.MessageID); err != nil {
+			log.WithFields(logrus.Fields{
+				"scope":   "StateMessageDeletedHook",
+				"reqID":   evt.Headers.Get(bridgeRequestIDHeaderVar),
+				"eventID": evt.ID,
+				"error":   err.Error(),
+			}).Errorln("Failed to remove message.")
+		}
+	}
+}
+
 // StateArchive stores events from past. With state archive application
 // is able to rebuild its state.
 type StateArchive interface {
@@ -143,6 +632,45 @@ type StateArchive interface {
 	Events(context.Context, chan<- BridgeEvent) error
 }
 
+// EventFilter selects events read from state archive. Zero values
+// of its fields don't filter events.
+type EventFilter struct {
+	// Types of selected events. Events of all types are selected
+	// when it's empty.
+	Types []BridgeEventType
+
+	// Since selects events created at or after given time.
+	Since time.Time
+
+	// Until selects events created before given time.
+	Until time.Time
+}
+
+// Match reports whether given event is selected by filter.
+func (f EventFilter) Match(evt BridgeEvent) bool {
+	if len(f.Types) > 0 && !slices.Contains(f.Types, evt.Name) {
+		return false
+	}
+	if !f.Since.IsZero() && evt.CreatedAt < f.Since.Unix() {
+		return false
+	}
+	if !f.Until.IsZero() && evt.CreatedAt >= f.Until.Unix() {
+		return false
+	}
+
+	return true
+}
+
+// FilteredStateArchive is state archive, which is able to select
+// events with filter on its own.
+type FilteredStateArchive interface {
+	StateArchive
+
+	// EventsFiltered sends events selected by given filter through
+	// given channel grouped by their creation date.
+	EventsFiltered(context.Context, EventFilter, chan<- BridgeEvent) error
+}
+
 // StateBuilder rebuilds state of application with events from
 // state archive.
 type StateBuilder struct {
@@ -152,6 +680,11 @@ type StateBuilder struct {
 	// Handler rebuilds state by applying hook to events
 	// from archive.
 	Handler BridgeEventHandler
+
+	// Filter optionally selects events, which are relevant for
+	// rebuilt state. Events are filtered by archive when it
+	// implements FilteredStateArchive.
+	Filter *EventFilter
 }
 
 // Rebuild whole state of application.
@@ -159,12 +692,20 @@ func (sb *StateBuilder) Rebuild(ctx context.Context) error {
 	errc := make(chan error, 1)
 	evtc := make(chan BridgeEvent)
 
+	filtered, canFilter := sb.Archive.(FilteredStateArchive)
 	go func() {
 		defer close(evtc)
+		if sb.Filter != nil && canFilter {
+			errc <- filtered.EventsFiltered(ctx, *sb.Filter, evtc)
+			return
+		}
 		errc <- sb.Archive.Events(ctx, evtc)
 	}()
 
 	for evt := range evtc {
+		if sb.Filter != nil && !canFilter && !sb.Filter.Match(evt) {
+			continue
+		}
 		sb.Handler.EventHook(ctx, evt)
 	}
 
@@ -2,8 +2,12 @@ package service
 
 import (
 	"context"
+	"encoding/json"
 	"sort"
+	"strconv"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/matryer/is"
 )
@@ -86,3 +90,218 @@ func TestStateOnlineUsers(t *testing.T) {
 		is.True(!ok)
 	})
 }
+
+func TestStateOnlineUsersChatUsers(t *testing.T) {
+	state := NewStateOnlineUsers()
+	for _, u := range []StateChatUser{
+		{ID: "1", Nickname: "bob"},
+		{ID: "2", Nickname: "Alice"},
+		{ID: "3", Nickname: "alfred"},
+		{ID: "4", Nickname: "Bobby"},
+		{ID: "5", Nickname: "carol"},
+		{ID: "6", Nickname: "bob"},
+	} {
+		state.state[u.ID] = u
+	}
+
+	type testArgs struct {
+		name string
+		opts ChatUsersOptions
+		want []string
+	}
+
+	scenario := func(tt testArgs) (string, func(*testing.T)) {
+		return tt.name, func(t *testing.T) {
+			is := is.New(t)
+
+			users, err := state.ChatUsers(context.TODO(), tt.opts)
+			is.NoErr(err)
+
+			got := []string{}
+			for _, u := range users {
+				got = append(got, u.ID)
+			}
+			is.Equal(got, tt.want)
+		}
+	}
+
+	t.Run(scenario(testArgs{
+		name: "all users sorted by nickname",
+		want: []string{"3", "2", "1", "6", "4", "5"},
+	}))
+	t.Run(scenario(testArgs{
+		name: "case-insensitive prefix",
+		opts: ChatUsersOptions{Prefix: "BO"},
+		want: []string{"1", "6", "4"},
+	}))
+	t.Run(scenario(testArgs{
+		name: "no matches",
+		opts: ChatUsersOptions{Prefix: "dave"},
+		want: []string{},
+	}))
+	t.Run(scenario(testArgs{
+		name: "first page",
+		opts: ChatUsersOptions{Limit: 2},
+		want: []string{"3", "2"},
+	}))
+	t.Run(scenario(testArgs{
+		name: "last partial page",
+		opts: ChatUsersOptions{Offset: 4, Limit: 4},
+		want: []string{"4", "5"},
+	}))
+	t.Run(scenario(testArgs{
+		name: "offset at the end",
+		opts: ChatUsersOptions{Offset: 6, Limit: 2},
+		want: []string{},
+	}))
+	t.Run(scenario(testArgs{
+		name: "offset past the end",
+		opts: ChatUsersOptions{Offset: 10},
+		want: []string{},
+	}))
+	t.Run(scenario(testArgs{
+		name: "prefix with paging",
+		opts: ChatUsersOptions{Prefix: "b", Offset: 1, Limit: 1},
+		want: []string{"6"},
+	}))
+}
+
+func TestStateOnlineUsersCount(t *testing.T) {
+	is := is.New(t)
+	ctx := context.TODO()
+
+	const (
+		workers = 10
+		users   = 100
+		removed = 40
+	)
+
+	state := NewStateOnlineUsers()
+	is.Equal(state.Count(ctx), 0)
+
+	wg := &sync.WaitGroup{}
+	for w := 0; w < workers; w++ {
+		w := w
+		goWithWaitGroup(wg, func() {
+			for i := 0; i < users; i++ {
+				id := strconv.Itoa(w) + "/" + strconv.Itoa(i)
+				is.NoErr(state.PushChatUser(ctx, StateChatUser{ID: id, Nickname: id}))
+				_ = state.Count(ctx)
+			}
+			for i := 0; i < removed; i++ {
+				id := strconv.Itoa(w) + "/" + strconv.Itoa(i)
+				is.NoErr(state.RemoveChatUser(ctx, id))
+				_ = state.Count(ctx)
+			}
+		})
+	}
+	wg.Wait()
+
+	is.Equal(state.Count(ctx), workers*(users-removed))
+}
+
+func TestStateOnlineUsersConnections(t *testing.T) {
+	is := is.New(t)
+	ctx := context.TODO()
+	log := testLogger()
+
+	state := NewStateOnlineUsers()
+	join := StateUserJoinHook(log, state)
+	left := StateUserLeftHook(log, state)
+
+	user := ChatUser{ID: "1", Nickname: "nickname"}
+	event := func(name BridgeEventType, data interface{}) BridgeEvent {
+		b, err := json.Marshal(data)
+		is.NoErr(err)
+		return BridgeEvent{Name: name, Data: b}
+	}
+
+	// User opens two tabs.
+	join(ctx, event(BridgeUserJoin, EventUserJoin{ID: "join1", User: user}))
+	join(ctx, event(BridgeUserJoin, EventUserJoin{ID: "join2", User: user}))
+	is.Equal(state.Count(ctx), 1)
+
+	// One of them is closed, but user is still online.
+	left(ctx, event(BridgeUserLeft, EventUserLeft{ID: "left1", User: user}))
+	u, err := state.ChatUser(ctx, user.ID)
+	is.NoErr(err)
+	is.Equal(u.Nickname, user.Nickname)
+
+	// The last one is closed.
+	left(ctx, event(BridgeUserLeft, EventUserLeft{ID: "left2", User: user}))
+	_, err = state.ChatUser(ctx, user.ID)
+	is.Equal(err, ErrNoSuchUser)
+	is.Equal(state.Count(ctx), 0)
+
+	// Leaving again doesn't resurrect any connection counter.
+	is.Equal(state.RemoveChatUser(ctx, user.ID), ErrNoSuchUser)
+	join(ctx, event(BridgeUserJoin, EventUserJoin{ID: "join3", User: user}))
+	left(ctx, event(BridgeUserLeft, EventUserLeft{ID: "left3", User: user}))
+	is.Equal(state.Count(ctx), 0)
+}
+
+// stateArchiveMock is state archive, which can't filter events.
+type stateArchiveMock []BridgeEvent
+
+func (a stateArchiveMock) Events(ctx context.Context, c chan<- BridgeEvent) error {
+	for _, evt := range a {
+		c <- evt
+	}
+	return nil
+}
+
+func TestStateMessages(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+
+	state := NewStateMessages(2)
+	for _, id := range []string{"1", "2", "3"} {
+		is.NoErr(state.PushMessage(ctx, StateMessage{ID: id, Content: "content " + id}))
+	}
+
+	// The oldest message is evicted, when state is full.
+	_, err := state.Message(ctx, "1")
+	is.Equal(err, ErrNoSuchMessage)
+	m, err := state.Message(ctx, "3")
+	is.NoErr(err)
+	is.Equal(m.Content, "content 3")
+
+	// Deleted message takes place in state, until it's evicted.
+	is.NoErr(state.RemoveMessage(ctx, "2"))
+	is.NoErr(state.PushMessage(ctx, StateMessage{ID: "4"}))
+	is.Equal(len(state.state), 2)
+	is.Equal(state.order, []string{"3", "4"})
+
+	// Pushing message again doesn't evict other messages.
+	is.NoErr(state.PushMessage(ctx, StateMessage{ID: "4", Content: "again"}))
+	_, err = state.Message(ctx, "3")
+	is.NoErr(err)
+}
+
+func TestStateBuilderFilter(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+
+	archive := stateArchiveMock{
+		{Name: BridgeUserJoin, ID: "join", CreatedAt: 100},
+		{Name: BridgeMessageSent, ID: "old", CreatedAt: 100},
+		{Name: BridgeMessageSent, ID: "new", CreatedAt: 200},
+	}
+
+	got := []string{}
+	builder := StateBuilder{
+		Archive: archive,
+		Handler: BridgeEventHandlerFunc(func(ctx context.Context, evt BridgeEvent) {
+			got = append(got, evt.ID)
+		}),
+		Filter: &EventFilter{
+			Types: []BridgeEventType{BridgeMessageSent},
+			Since: time.Unix(150, 0),
+		},
+	}
+	is.NoErr(builder.Rebuild(ctx))
+
+	// Archive doesn't implement filtering, so events are filtered
+	// by state builder.
+	is.Equal(got, []string{"new"})
+}
@@ -2,49 +2,150 @@ package service
 
 import (
 	"bytes"
+	"container/list"
+	"context"
 	"crypto/aes"
 	"crypto/cipher"
+	"crypto/hmac"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"math"
 	"os"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"filippo.io/age"
 	"github.com/google/uuid"
 	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/scrypt"
 )
 
+// SessionTokenStore stores session states of users under their
+// string tokens. Stores can drop entries of expired session states.
+type SessionTokenStore interface {
+	// StoreSessionToken stores session state under given token.
+	StoreSessionToken(ctx context.Context, token string, state SessionState) error
+
+	// SessionToken returns session state stored under given token. It
+	// returns ErrMissingSessionToken if there is no such token.
+	SessionToken(ctx context.Context, token string) (*SessionState, error)
+
+	// DeleteSessionToken removes given token from store.
+	DeleteSessionToken(ctx context.Context, token string) error
+
+	// DeleteUserSessionTokens removes all tokens of session states
+	// with given user ID from store.
+	DeleteUserSessionTokens(ctx context.Context, userID string) error
+}
+
+// SessionTokenStoreMemory is in-memory SessionTokenStore. Expired
+// entries are garbage collected on every stored token.
+type SessionTokenStoreMemory struct {
+	tokens map[string]SessionState
+	mtx    *sync.Mutex
+	clock  Clock
+}
+
+// NewSessionTokenStoreMemory returns empty in-memory session token store.
+func NewSessionTokenStoreMemory(clock Clock) *SessionTokenStoreMemory {
+	return &SessionTokenStoreMemory{
+		tokens: make(map[string]SessionState),
+		mtx:    &sync.Mutex{},
+		clock:  clock,
+	}
+}
+
+// StoreSessionToken stores session state under given token.
+func (s *SessionTokenStoreMemory) StoreSessionToken(ctx context.Context, token string, state SessionState) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	// Tokens, which are never decoded again, would stay in store
+	// forever. Collect them with every new session.
+	now := s.clock.Now()
+	for storedToken, storedState := range s.tokens {
+		if storedState.ExpireAt.Before(now) {
+			delete(s.tokens, storedToken)
+		}
+	}
+
+	s.tokens[token] = state
+	return nil
+}
+
+// SessionToken returns session state stored under given token.
+func (s *SessionTokenStoreMemory) SessionToken(ctx context.Context, token string) (*SessionState, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	state, ok := s.tokens[token]
+	if !ok {
+		return nil, ErrMissingSessionToken
+	}
+
+	return &state, nil
+}
+
+// DeleteSessionToken removes given token from store.
+func (s *SessionTokenStoreMemory) DeleteSessionToken(ctx context.Context, token string) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	delete(s.tokens, token)
+	return nil
+}
+
+// DeleteUserSessionTokens removes all tokens of session states with
+// given user ID from store.
+func (s *SessionTokenStoreMemory) DeleteUserSessionTokens(ctx context.Context, userID string) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	for token, state := range s.tokens {
+		if state.ID == userID {
+			delete(s.tokens, token)
+		}
+	}
+	return nil
+}
+
 // SessionSimpleTokenizer is a simple key/value storage for
-// string tokens and session state of users.
+// string tokens and session state of users. Expired session states
+// are removed from storage.
 type SessionSimpleTokenizer struct {
-	gen     IDGenerator
-	storage map[string]SessionState
-	mtx     *sync.RWMutex
-	base64  *base64.Encoding
+	gen    IDGenerator
+	clock  Clock
+	store  SessionTokenStore
+	base64 *base64.Encoding
 }
 
 // NewSessionSimpleTokenizer is default and safe constructor for SessionSimpleTokenizer.
+// Session states are kept in memory, so tokens don't survive restarts.
 func NewSessionSimpleTokenizer() *SessionSimpleTokenizer {
+	return NewSessionSimpleTokenizerWithStore(NewSessionTokenStoreMemory(ClockFunc(time.Now)))
+}
+
+// NewSessionSimpleTokenizerWithStore returns SessionSimpleTokenizer,
+// which keeps session states in given store.
+func NewSessionSimpleTokenizerWithStore(store SessionTokenStore) *SessionSimpleTokenizer {
 	return &SessionSimpleTokenizer{
-		gen:     IDGeneratorFunc(uuid.NewString),
-		storage: make(map[string]SessionState),
-		mtx:     &sync.RWMutex{},
-		base64:  base64.URLEncoding,
+		gen:    IDGeneratorFunc(uuid.NewString),
+		clock:  ClockFunc(time.Now),
+		store:  store,
+		base64: base64.URLEncoding,
 	}
 }
 
 // TokenEncode returns tokenized string which represents session state and
 // can be decoded with the same interface implementation.
 func (t *SessionSimpleTokenizer) TokenEncode(state SessionState) (string, error) {
-	t.mtx.Lock()
-	defer t.mtx.Unlock()
-
 	token := t.gen.GenerateID()
 
 	hostname, err := os.Hostname()
@@ -52,7 +153,9 @@ func (t *SessionSimpleTokenizer) TokenEncode(state SessionState) (string, error)
 		token = hostname + "/" + token
 	}
 
-	t.storage[token] = state
+	if err := t.store.StoreSessionToken(context.Background(), token, state); err != nil {
+		return "", fmt.Errorf("failed to store session token: %w", err)
+	}
 
 	return t.base64.EncodeToString([]byte(token)), nil
 }
@@ -66,16 +169,31 @@ func (t *SessionSimpleTokenizer) TokenDecode(token string) (*SessionState, error
 		return nil, fmt.Errorf("Failed to decode token: %w", err)
 	}
 
-	t.mtx.RLock()
-	defer t.mtx.RUnlock()
-
+	ctx := context.Background()
 	token = string(b)
-	s, ok := t.storage[token]
-	if !ok {
-		return nil, ErrMissingSessionToken
+	s, err := t.store.SessionToken(ctx, token)
+	if err != nil {
+		return nil, err
 	}
 
-	return &s, nil
+	if s.ExpireAt.Before(t.clock.Now()) {
+		if err := t.store.DeleteSessionToken(ctx, token); err != nil {
+			return nil, fmt.Errorf("failed to delete expired session token: %w", err)
+		}
+		return nil, ErrSessionStateExpire
+	}
+
+	return s, nil
+}
+
+// RevokeUserTokens removes all tokens of given user from store, so
+// they can't be decoded anymore.
+func (t *SessionSimpleTokenizer) RevokeUserTokens(ctx context.Context, userID string) error {
+	if err := t.store.DeleteUserSessionTokens(ctx, userID); err != nil {
+		return fmt.Errorf("failed to delete user session tokens: %w", err)
+	}
+
+	return nil
 }
 
 // SessionAgeTokenizer encodes and decodes session state token.
@@ -158,9 +276,10 @@ func (st *SessionAgeTokenizer) TokenDecode(token string) (*SessionState, error)
 }
 
 // SessionAESTokenizer implements stateless SessionTokenizer interface
-// with AES/CFB encryption.
+// with AES/GCM authenticated encryption. Tokens, which have been
+// tampered with, are rejected.
 type SessionAESTokenizer struct {
-	block  cipher.Block
+	aead   cipher.AEAD
 	base64 *base64.Encoding
 }
 
@@ -175,19 +294,67 @@ func NewSessionAESTokenizer(secret []byte) (*SessionAESTokenizer, error) {
 		return nil, fmt.Errorf("Failed to crete new AES cipher block: %w", err)
 	}
 
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create new AES/GCM cipher: %w", err)
+	}
+
 	return &SessionAESTokenizer{
-		block:  block,
+		aead:   aead,
 		base64: base64.URLEncoding,
 	}, nil
 }
 
-func (st *SessionAESTokenizer) newIV() ([]byte, error) {
-	iv := make([]byte, st.block.BlockSize())
-	if _, err := io.ReadFull(rand.Reader, iv); err != nil {
-		return nil, fmt.Errorf("failed to fill iv: %w", err)
+// sessionAESPassphraseSalt is salt of AES keys derived from
+// passphrases. It's fixed, so every instance of szmaterlok derives
+// the same key from the same passphrase.
+const sessionAESPassphraseSalt = "szmaterlok/session-aes-key"
+
+// sessionAESKeyFromPassphrase derives 32 bytes long AES key from given
+// passphrase with scrypt.
+func sessionAESKeyFromPassphrase(pass string) ([]byte, error) {
+	key, err := scrypt.Key([]byte(pass), []byte(sessionAESPassphraseSalt), 1<<15, 8, 1, 32)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive AES key from passphrase: %w", err)
+	}
+
+	return key, nil
+}
+
+// NewSessionAESTokenizerFromPassphrase returns AES-256 session tokenizer
+// with key derived from given passphrase. Tokenizers created with the
+// same passphrase can decode each other tokens.
+func NewSessionAESTokenizerFromPassphrase(pass string) (*SessionAESTokenizer, error) {
+	if pass == "" {
+		return nil, errors.New("AES passphrase cannot be empty")
+	}
+
+	key, err := sessionAESKeyFromPassphrase(pass)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewSessionAESTokenizer(key)
+}
+
+// sessionAESRawKey reports whether given secret can be used as AES key
+// as it is.
+func sessionAESRawKey(secret string) bool {
+	switch len(secret) {
+	case 16, 24, 32:
+		return true
+	default:
+		return false
+	}
+}
+
+func (st *SessionAESTokenizer) newNonce() ([]byte, error) {
+	nonce := make([]byte, st.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, fmt.Errorf("failed to fill nonce: %w", err)
 	}
 
-	return iv, nil
+	return nonce, nil
 }
 
 // TokenEncode returns tokenized string which represents session state and
@@ -198,24 +365,30 @@ func (st *SessionAESTokenizer) TokenEncode(state SessionState) (string, error) {
 		return "", fmt.Errorf("failed to encode state into json: %w", err)
 	}
 
-	iv, err := st.newIV()
+	nonce, err := st.newNonce()
 	if err != nil {
-		return "", fmt.Errorf("failed to encode state with IV: %w", err)
+		return "", fmt.Errorf("failed to encode state with nonce: %w", err)
 	}
 
-	cfb := cipher.NewCFBEncrypter(st.block, iv)
-	res := make([]byte, len(b))
-	cfb.XORKeyStream(res, b)
-	return st.base64.EncodeToString(iv) + ":" + st.base64.EncodeToString(res), nil
+	res := st.aead.Seal(nil, nonce, b, nil)
+	return st.base64.EncodeToString(nonce) + ":" + st.base64.EncodeToString(res), nil
 }
 
+var ErrAESInvalidToken = errors.New("session: invalid aes token")
+
 // TokenDecode decodes given string token into valid session state.
 func (st *SessionAESTokenizer) TokenDecode(token string) (*SessionState, error) {
 	splitted := strings.Split(token, ":")
+	if len(splitted) != 2 {
+		return nil, fmt.Errorf("%w: malformed token", ErrAESInvalidToken)
+	}
 
-	iv, err := st.base64.DecodeString(splitted[0])
+	nonce, err := st.base64.DecodeString(splitted[0])
 	if err != nil {
-		return nil, fmt.Errorf("failed to decode iv from base64: %w", err)
+		return nil, fmt.Errorf("failed to decode nonce from base64: %w", err)
+	}
+	if len(nonce) != st.aead.NonceSize() {
+		return nil, fmt.Errorf("%w: invalid nonce size", ErrAESInvalidToken)
 	}
 
 	b, err := st.base64.DecodeString(splitted[1])
@@ -223,11 +396,14 @@ func (st *SessionAESTokenizer) TokenDecode(token string) (*SessionState, error)
 		return nil, fmt.Errorf("failed to decode token from base64: %w", err)
 	}
 
-	res := &SessionState{}
-	cfb := cipher.NewCFBDecrypter(st.block, iv)
-	decrypted := make([]byte, len(b))
-	cfb.XORKeyStream(decrypted, b)
+	// Authentication tag is verified before anything is decoded, so
+	// modified tokens can't forge session state.
+	decrypted, err := st.aead.Open(nil, nonce, b, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrAESInvalidToken, err)
+	}
 
+	res := &SessionState{}
 	if err := json.Unmarshal(decrypted, res); err != nil {
 		return nil, fmt.Errorf("failed to decode json state: %w", err)
 	}
@@ -235,19 +411,196 @@ func (st *SessionAESTokenizer) TokenDecode(token string) (*SessionState, error)
 	return res, nil
 }
 
+// SessionJWTTokenizer implements stateless SessionTokenizer interface
+// with compact JSON Web Tokens signed with HS256 algorithm.
+type SessionJWTTokenizer struct {
+	secret []byte
+	base64 *base64.Encoding
+	Clock
+}
+
+// NewSessionJWTTokenizer returns JWT session tokenizer, which signs
+// tokens with given secret. Clock is used for validation of token
+// expiration date.
+func NewSessionJWTTokenizer(secret []byte, clock Clock) (*SessionJWTTokenizer, error) {
+	if len(secret) == 0 {
+		return nil, ErrEmptyJWTSecret
+	}
+
+	return &SessionJWTTokenizer{
+		secret: secret,
+		base64: base64.RawURLEncoding,
+		Clock:  clock,
+	}, nil
+}
+
+type jwtHeader struct {
+	Algorithm string `json:"alg"`
+	Type      string `json:"typ"`
+}
+
+// jwtClaims maps SessionState fields to JWT claims.
+type jwtClaims struct {
+	Subject   string `json:"sub"`
+	Nickname  string `json:"nck"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+	Admin     bool   `json:"adm,omitempty"`
+}
+
+const jwtAlgorithmHS256 = "HS256"
+
+var (
+	ErrEmptyJWTSecret      = errors.New("session: jwt secret cannot be empty")
+	ErrJWTInvalidSignature = errors.New("session: invalid jwt signature")
+	ErrJWTExpired          = errors.New("session: jwt has expired")
+)
+
+func (st *SessionJWTTokenizer) sign(payload string) string {
+	mac := hmac.New(sha256.New, st.secret)
+	mac.Write([]byte(payload))
+	return st.base64.EncodeToString(mac.Sum(nil))
+}
+
+// TokenEncode returns signed JWT with claims of given session state.
+func (st *SessionJWTTokenizer) TokenEncode(state SessionState) (string, error) {
+	header, err := json.Marshal(jwtHeader{
+		Algorithm: jwtAlgorithmHS256,
+		Type:      "JWT",
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to encode jwt header: %w", err)
+	}
+
+	claims, err := json.Marshal(jwtClaims{
+		Subject:   state.ID,
+		Nickname:  state.Nickname,
+		IssuedAt:  state.CreatedAt.Unix(),
+		ExpiresAt: state.ExpireAt.Unix(),
+		Admin:     state.Admin,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to encode jwt claims: %w", err)
+	}
+
+	payload := st.base64.EncodeToString(header) + "." + st.base64.EncodeToString(claims)
+	return payload + "." + st.sign(payload), nil
+}
+
+// TokenDecode verifies signature and expiration date of given JWT
+// and decodes it into session state.
+func (st *SessionJWTTokenizer) TokenDecode(token string) (*SessionState, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed jwt")
+	}
+
+	payload := parts[0] + "." + parts[1]
+	if !hmac.Equal([]byte(st.sign(payload)), []byte(parts[2])) {
+		return nil, ErrJWTInvalidSignature
+	}
+
+	b, err := st.base64.DecodeString(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode jwt header from base64: %w", err)
+	}
+
+	header := jwtHeader{}
+	if err := json.Unmarshal(b, &header); err != nil {
+		return nil, fmt.Errorf("failed to decode jwt header: %w", err)
+	}
+	if header.Algorithm != jwtAlgorithmHS256 {
+		return nil, fmt.Errorf("unsupported jwt algorithm: %s", header.Algorithm)
+	}
+
+	b, err = st.base64.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode jwt claims from base64: %w", err)
+	}
+
+	claims := jwtClaims{}
+	if err := json.Unmarshal(b, &claims); err != nil {
+		return nil, fmt.Errorf("failed to decode jwt claims: %w", err)
+	}
+
+	expireAt := time.Unix(claims.ExpiresAt, 0).UTC()
+	if !st.Now().Before(expireAt) {
+		return nil, ErrJWTExpired
+	}
+
+	return &SessionState{
+		Nickname:  claims.Nickname,
+		ID:        claims.Subject,
+		CreatedAt: time.Unix(claims.IssuedAt, 0).UTC(),
+		ExpireAt:  expireAt,
+		Admin:     claims.Admin,
+	}, nil
+}
+
+// SessionRotatingTokenizer supports rotation of session secrets. It
+// encodes tokens with tokenizer of the current secret and decodes them
+// with tokenizers of the current and old secrets, so sessions created
+// before rotation are still valid.
+type SessionRotatingTokenizer struct {
+	current SessionTokenizer
+	old     []SessionTokenizer
+}
+
+// NewSessionRotatingTokenizer returns tokenizer, which encodes tokens
+// with current tokenizer. Old tokenizers are used for decoding tokens,
+// which can't be decoded with current one, in given order.
+func NewSessionRotatingTokenizer(current SessionTokenizer, old ...SessionTokenizer) *SessionRotatingTokenizer {
+	return &SessionRotatingTokenizer{
+		current: current,
+		old:     old,
+	}
+}
+
+// TokenEncode encodes given session state with current tokenizer.
+func (t *SessionRotatingTokenizer) TokenEncode(state SessionState) (string, error) {
+	return t.current.TokenEncode(state)
+}
+
+// TokenDecode decodes given token with current tokenizer and falls back
+// to old ones. Error of current tokenizer is returned when none of them
+// can decode the token.
+func (t *SessionRotatingTokenizer) TokenDecode(token string) (*SessionState, error) {
+	res, err := t.current.TokenDecode(token)
+	if err == nil {
+		return res, nil
+	}
+
+	for _, old := range t.old {
+		if res, oldErr := old.TokenDecode(token); oldErr == nil {
+			return res, nil
+		}
+	}
+
+	return nil, err
+}
+
 type sessionTokenizerCacheEntry struct {
+	token string
 	value SessionState
 	timer *time.Timer
 }
 
 // SessionTokenizerCache wraps SessionTokenizer interface and extends it
-// with concurrent-safe in-memory cache storage.
+// with concurrent-safe in-memory cache storage. When size of cache is
+// bounded, the least recently used tokens are evicted first.
 type SessionTokenizerCache struct {
-	wrapped SessionTokenizer
-	timeout time.Duration
-	log     *logrus.Logger
-	mtx     *sync.RWMutex
-	cache   map[string]sessionTokenizerCacheEntry
+	wrapped    SessionTokenizer
+	timeout    time.Duration
+	maxEntries int
+	log        *logrus.Logger
+	mtx        *sync.Mutex
+	cache      map[string]*list.Element
+
+	// lru holds cache entries ordered from the most recently used.
+	lru *list.List
+
+	hits   *atomic.Uint64
+	misses *atomic.Uint64
 }
 
 // SessionTokenizerCacheBuilder holds build arguments for SessionTokenizerCache.
@@ -255,17 +608,31 @@ type SessionTokenizerCacheBuilder struct {
 	Wrapped SessionTokenizer
 	Timeout time.Duration
 	Logger  *logrus.Logger
+
+	// MaxEntries is maximal number of cached tokens. Zero means that
+	// cache is unbounded.
+	MaxEntries int
+
+	// Metrics are optional collectors of cache hits and misses.
+	Metrics *Metrics
 }
 
 // NewSessionTokenizerCache is default and safe constructor for SessionTokenizerCache.
 func NewSessionTokenizerCache(b SessionTokenizerCacheBuilder) *SessionTokenizerCache {
-	return &SessionTokenizerCache{
-		wrapped: b.Wrapped,
-		timeout: b.Timeout,
-		log:     b.Logger,
-		mtx:     &sync.RWMutex{},
-		cache:   make(map[string]sessionTokenizerCacheEntry),
+	c := &SessionTokenizerCache{
+		wrapped:    b.Wrapped,
+		timeout:    b.Timeout,
+		maxEntries: b.MaxEntries,
+		log:        b.Logger,
+		mtx:        &sync.Mutex{},
+		cache:      make(map[string]*list.Element),
+		lru:        list.New(),
+		hits:       &atomic.Uint64{},
+		misses:     &atomic.Uint64{},
 	}
+	b.Metrics.registerTokenizerCache(c)
+
+	return c
 }
 
 // TokenEncode returns tokenized string which represents session state and
@@ -276,45 +643,113 @@ func (c *SessionTokenizerCache) TokenEncode(state SessionState) (string, error)
 
 // TokenDecode decodes given string token into valid session state.
 func (c *SessionTokenizerCache) TokenDecode(token string) (*SessionState, error) {
-	// Read cache entry from map. Wrap it with read lock.
-	c.mtx.RLock()
-	entry, ok := c.cache[token]
-	c.mtx.RUnlock()
-	if !ok {
-		// There is no entry in cache. Decode token manually.
-		res, err := c.wrapped.TokenDecode(token)
-		if err != nil {
-			return nil, err
-		}
+	c.mtx.Lock()
+	elem, ok := c.cache[token]
+	if ok {
+		entry := elem.Value.(*sessionTokenizerCacheEntry)
+		entry.timer.Reset(c.timeout)
+		c.lru.MoveToFront(elem)
+		res := entry.value
+		c.mtx.Unlock()
+
+		c.hits.Add(1)
+		return &res, nil
+	}
+	c.mtx.Unlock()
+
+	// There is no entry in cache. Decode token manually.
+	c.misses.Add(1)
+	res, err := c.wrapped.TokenDecode(token)
+	if err != nil {
+		return nil, err
+	}
 
-		// Begin write transaction.
+	// Begin write transaction.
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	// Token could have been cached concurrently.
+	if elem, ok := c.cache[token]; ok {
+		elem.Value.(*sessionTokenizerCacheEntry).timer.Reset(c.timeout)
+		c.lru.MoveToFront(elem)
+		return res, nil
+	}
+
+	// Add new cache entry for given token.
+	entry := &sessionTokenizerCacheEntry{
+		token: token,
+		value: *res,
+	}
+	elem = c.lru.PushFront(entry)
+	c.cache[token] = elem
+
+	// Fire garbage collection for given token after cache timeout.
+	entry.timer = time.AfterFunc(c.timeout, func() {
 		c.mtx.Lock()
+		defer c.mtx.Unlock()
 
-		// Add new cache entry for given token.
-		c.cache[token] = sessionTokenizerCacheEntry{
-			value: *res,
-
-			// Fire garbage collection for given token after cache timeout.
-			timer: time.AfterFunc(c.timeout, func() {
-				s := *res
-				c.mtx.Lock()
-				defer c.mtx.Unlock()
-				delete(c.cache, token)
-				c.log.WithFields(logrus.Fields{
-					"userID":   s.ID,
-					"nickname": s.Nickname,
-				}).Debug("Garbage collection of tokenizer cache.")
-			}),
+		// Entry could have been already evicted.
+		if c.cache[token] != elem {
+			return
 		}
+		c.remove(elem)
+		c.log.WithFields(logrus.Fields{
+			"userID":   entry.value.ID,
+			"nickname": entry.value.Nickname,
+		}).Debug("Garbage collection of tokenizer cache.")
+	})
+
+	if c.maxEntries > 0 && c.lru.Len() > c.maxEntries {
+		c.remove(c.lru.Back())
+	}
 
-		// End write transaction.
-		c.mtx.Unlock()
+	return res, nil
+}
 
-		return res, nil
+// RevokeUserTokens evicts cached tokens of given user and revokes
+// them in wrapped tokenizer. It returns ErrTokenRevocationUnsupported
+// if wrapped tokenizer can't revoke tokens.
+func (c *SessionTokenizerCache) RevokeUserTokens(ctx context.Context, userID string) error {
+	c.mtx.Lock()
+	for _, elem := range c.cache {
+		if elem.Value.(*sessionTokenizerCacheEntry).value.ID == userID {
+			c.remove(elem)
+		}
 	}
+	c.mtx.Unlock()
 
-	entry.timer.Reset(c.timeout)
-	return &entry.value, nil
+	revoker, ok := c.wrapped.(SessionTokenRevoker)
+	if !ok {
+		return ErrTokenRevocationUnsupported
+	}
+
+	return revoker.RevokeUserTokens(ctx, userID)
+}
+
+// remove deletes given cache entry. It has to be called with lock held.
+func (c *SessionTokenizerCache) remove(elem *list.Element) {
+	entry := elem.Value.(*sessionTokenizerCacheEntry)
+	entry.timer.Stop()
+	c.lru.Remove(elem)
+	delete(c.cache, entry.token)
+}
+
+// Len returns number of cached tokens.
+func (c *SessionTokenizerCache) Len() int {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	return c.lru.Len()
+}
+
+// Hits returns number of tokens decoded from cache.
+func (c *SessionTokenizerCache) Hits() uint64 {
+	return c.hits.Load()
+}
+
+// Misses returns number of tokens, which haven't been found in cache.
+func (c *SessionTokenizerCache) Misses() uint64 {
+	return c.misses.Load()
 }
 
 // SessionTokenizerFactory initiates tokenizer for szmaterlok based
@@ -322,46 +757,171 @@ func (c *SessionTokenizerCache) TokenDecode(token string) (*SessionState, error)
 type SessionTokenizerFactory struct {
 	Timeout time.Duration
 	Logger  *logrus.Logger
+
+	// CacheSize is maximal number of cached tokens. Zero means that
+	// cache is unbounded.
+	CacheSize int
+
+	// Metrics are optional collectors of cache statistics.
+	Metrics *Metrics
+
+	// TokenStore is optional store of session states for simple
+	// tokenizer. Session states are kept in memory if it is nil.
+	TokenStore SessionTokenStore
 }
 
 var ErrInvalidTokenizerType = errors.New("session: invalid tokenizer type name")
 
+var (
+	ErrDefaultSessionSecret = errors.New("session: default session secret can be used only in debug mode")
+	ErrWeakSessionSecret    = errors.New("session: session secret is too weak")
+)
+
+// sessionSecretMinEntropy is minimal estimated entropy of AES key
+// or passphrase in bits.
+const sessionSecretMinEntropy = 48
+
+// sessionSecretEntropy estimates entropy of given secret in bits from
+// frequency of its bytes. Estimation is rough, but it's good enough to
+// catch keys made of few repeated characters.
+func sessionSecretEntropy(secret []byte) float64 {
+	counts := map[byte]int{}
+	for _, b := range secret {
+		counts[b]++
+	}
+
+	perByte := 0.0
+	for _, n := range counts {
+		p := float64(n) / float64(len(secret))
+		perByte -= p * math.Log2(p)
+	}
+
+	return perByte * float64(len(secret))
+}
+
+// validateSessionSecret checks whether session secret from given
+// configuration is safe to use with given tokenizer backend. Debug
+// mode skips all of the checks.
+func validateSessionSecret(config *ConfigVariables) error {
+	switch config.Tokenizer {
+	case ConfigTokenizerAge, ConfigTokenizerAES, ConfigTokenizerJWT:
+	default:
+		// Other backends don't use session secret.
+		return nil
+	}
+
+	if !config.Debug && config.SessionSecret == ConfigSessionSecretDefaultVal {
+		return fmt.Errorf("%w: set %s", ErrDefaultSessionSecret, ConfigSessionSecretVarName)
+	}
+
+	if config.Tokenizer != ConfigTokenizerAES {
+		return nil
+	}
+
+	// Secrets of other lengths than AES key sizes are passphrases,
+	// which AES key is derived from.
+	if entropy := sessionSecretEntropy([]byte(config.SessionSecret)); !config.Debug && entropy < sessionSecretMinEntropy {
+		return fmt.Errorf(
+			"%w: estimated entropy of AES key or passphrase is %.0f bits, at least %d bits are required",
+			ErrWeakSessionSecret, entropy, sessionSecretMinEntropy,
+		)
+	}
+
+	return nil
+}
+
 // Tokenizer builds session tokenizer wrapped with cache based on
 // the environmental variable from configuration.
 func (f *SessionTokenizerFactory) Tokenizer(config *ConfigVariables) (SessionTokenizer, error) {
+	if err := validateSessionSecret(config); err != nil {
+		return nil, err
+	}
+
 	cacheBuilder := SessionTokenizerCacheBuilder{
-		Wrapped: nil,
-		Timeout: f.Timeout,
-		Logger:  f.Logger,
+		Wrapped:    nil,
+		Timeout:    f.Timeout,
+		Logger:     f.Logger,
+		MaxEntries: f.CacheSize,
+		Metrics:    f.Metrics,
 	}
 
+	// backend builds tokenizer for single session secret.
+	var backend func(secret string) (SessionTokenizer, error)
+
 	switch config.Tokenizer {
 
 	case ConfigTokenizerSimple:
 		f.Logger.Info("Chose simple tokenizer backend.")
 		t := NewSessionSimpleTokenizer()
+		if f.TokenStore != nil {
+			f.Logger.Info("Session tokens of simple tokenizer are persisted in storage.")
+			t = NewSessionSimpleTokenizerWithStore(f.TokenStore)
+		}
 		cacheBuilder.Wrapped = t
 		return NewSessionTokenizerCache(cacheBuilder), nil
 
 	case ConfigTokenizerAge:
 		f.Logger.Info("Chose age tokenizer backend.")
-		t, err := NewSessionAgeTokenizer(config.SessionSecret)
-		if err != nil {
-			return nil, err
+		backend = func(secret string) (SessionTokenizer, error) {
+			t, err := NewSessionAgeTokenizer(secret)
+			if err != nil {
+				return nil, err
+			}
+			return t, nil
 		}
-		cacheBuilder.Wrapped = t
-		return NewSessionTokenizerCache(cacheBuilder), nil
 
 	case ConfigTokenizerAES:
 		f.Logger.Info("Chose AES tokenizer backend.")
-		t, err := NewSessionAESTokenizer([]byte(config.SessionSecret))
-		if err != nil {
-			return nil, err
+		backend = func(secret string) (SessionTokenizer, error) {
+			if sessionAESRawKey(secret) {
+				t, err := NewSessionAESTokenizer([]byte(secret))
+				if err != nil {
+					return nil, err
+				}
+				return t, nil
+			}
+
+			f.Logger.Info("Deriving AES key from session secret passphrase.")
+			t, err := NewSessionAESTokenizerFromPassphrase(secret)
+			if err != nil {
+				return nil, err
+			}
+			return t, nil
+		}
+
+	case ConfigTokenizerJWT:
+		f.Logger.Info("Chose JWT tokenizer backend.")
+		backend = func(secret string) (SessionTokenizer, error) {
+			t, err := NewSessionJWTTokenizer([]byte(secret), ClockFunc(time.Now))
+			if err != nil {
+				return nil, err
+			}
+			return t, nil
 		}
-		cacheBuilder.Wrapped = t
-		return NewSessionTokenizerCache(cacheBuilder), nil
 
 	default:
 		return nil, ErrInvalidTokenizerType
 	}
+
+	t, err := backend(config.SessionSecret)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(config.SessionSecretOld) > 0 {
+		old := make([]SessionTokenizer, 0, len(config.SessionSecretOld))
+		for i, secret := range config.SessionSecretOld {
+			ot, err := backend(secret)
+			if err != nil {
+				return nil, fmt.Errorf("failed to create tokenizer for old session secret #%d: %w", i+1, err)
+			}
+			old = append(old, ot)
+		}
+
+		f.Logger.WithField("oldSecrets", len(old)).Info("Accepting tokens of old session secrets.")
+		t = NewSessionRotatingTokenizer(t, old...)
+	}
+
+	cacheBuilder.Wrapped = t
+	return NewSessionTokenizerCache(cacheBuilder), nil
 }
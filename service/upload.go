@@ -0,0 +1,345 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/exp/slices"
+)
+
+// uploadFormField is name of multipart form field holding uploaded file.
+const uploadFormField = "file"
+
+// uploadFormOverhead is number of bytes allowed in upload request
+// besides uploaded file, for multipart boundaries and headers.
+const uploadFormOverhead = 1 << 10
+
+// uploadIDPattern matches IDs returned by uploadID.
+var uploadIDPattern = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+// ErrNoSuchUpload is returned when there is no uploaded file with
+// given ID.
+var ErrNoSuchUpload = errors.New("no such upload")
+
+// Attachment references file uploaded by user.
+type Attachment struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	ContentType string `json:"contentType"`
+	Size        int64  `json:"size"`
+	URL         string `json:"url"`
+}
+
+// uploadID returns new unguessable ID of uploaded file.
+func uploadID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// uploadURL returns URL of uploaded file with given ID.
+func uploadURL(id string) string {
+	return "/files/" + id
+}
+
+// UploadStore stores files uploaded by users. Implementations can keep
+// them on local disk or in object storage.
+type UploadStore interface {
+	// SaveUpload stores content of file described by given attachment.
+	SaveUpload(ctx context.Context, a Attachment, content io.Reader) error
+
+	// Upload returns attachment with given ID. It returns ErrNoSuchUpload
+	// if there is no such file.
+	Upload(ctx context.Context, id string) (Attachment, error)
+
+	// OpenUpload returns content of file with given ID. It returns
+	// ErrNoSuchUpload if there is no such file.
+	OpenUpload(ctx context.Context, id string) (io.ReadSeekCloser, error)
+}
+
+// UploadDiskStore keeps uploaded files in local directory. Metadata of
+// every file is stored next to it in JSON file.
+type UploadDiskStore struct {
+	dir string
+}
+
+// NewUploadDiskStore returns upload store keeping files in given
+// directory, which is created if it doesn't exist.
+func NewUploadDiskStore(dir string) (*UploadDiskStore, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, fmt.Errorf("os.MkdirAll: %w", err)
+	}
+
+	return &UploadDiskStore{dir: dir}, nil
+}
+
+// path returns path of file with given ID. It returns ErrNoSuchUpload
+// for malformed IDs, so they can't point outside of upload directory.
+func (s *UploadDiskStore) path(id string) (string, error) {
+	if !uploadIDPattern.MatchString(id) {
+		return "", ErrNoSuchUpload
+	}
+	return filepath.Join(s.dir, id), nil
+}
+
+// SaveUpload writes content and metadata of given file to disk. Files
+// are written under temporary names first, so partially written files
+// are never served.
+func (s *UploadDiskStore) SaveUpload(ctx context.Context, a Attachment, content io.Reader) error {
+	path, err := s.path(a.ID)
+	if err != nil {
+		return fmt.Errorf("invalid upload id: %s", a.ID)
+	}
+
+	meta, err := json.Marshal(a)
+	if err != nil {
+		return fmt.Errorf("json.Marshal: %w", err)
+	}
+
+	if err := uploadWriteFile(path, content); err != nil {
+		return err
+	}
+	if err := uploadWriteFile(path+".json", bytes.NewReader(meta)); err != nil {
+		os.Remove(path)
+		return err
+	}
+
+	return nil
+}
+
+// uploadWriteFile writes given content to file at given path
+// atomically.
+func uploadWriteFile(path string, content io.Reader) error {
+	f, err := os.CreateTemp(filepath.Dir(path), ".upload-*")
+	if err != nil {
+		return fmt.Errorf("os.CreateTemp: %w", err)
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := io.Copy(f, content); err != nil {
+		f.Close()
+		return fmt.Errorf("io.Copy: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("f.Close: %w", err)
+	}
+
+	if err := os.Rename(f.Name(), path); err != nil {
+		return fmt.Errorf("os.Rename: %w", err)
+	}
+	return nil
+}
+
+// Upload reads metadata of file with given ID.
+func (s *UploadDiskStore) Upload(ctx context.Context, id string) (Attachment, error) {
+	path, err := s.path(id)
+	if err != nil {
+		return Attachment{}, err
+	}
+
+	meta, err := os.ReadFile(path + ".json")
+	if errors.Is(err, os.ErrNotExist) {
+		return Attachment{}, ErrNoSuchUpload
+	}
+	if err != nil {
+		return Attachment{}, fmt.Errorf("os.ReadFile: %w", err)
+	}
+
+	a := Attachment{}
+	if err := json.Unmarshal(meta, &a); err != nil {
+		return Attachment{}, fmt.Errorf("json.Unmarshal: %w", err)
+	}
+	return a, nil
+}
+
+// OpenUpload opens file with given ID.
+func (s *UploadDiskStore) OpenUpload(ctx context.Context, id string) (io.ReadSeekCloser, error) {
+	path, err := s.path(id)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNoSuchUpload
+	}
+	if err != nil {
+		return nil, fmt.Errorf("os.Open: %w", err)
+	}
+	return f, nil
+}
+
+// HandlerUploadDependencies holds behavioral dependencies for
+// http handler for uploading files.
+type HandlerUploadDependencies struct {
+	Logger  *logrus.Logger
+	Uploads UploadStore
+
+	// MaxSize is maximal size of uploaded file in bytes.
+	MaxSize int64
+
+	// ContentTypes are allowed media types of uploaded files. Content
+	// type is detected from file content, declared one is ignored.
+	ContentTypes []string
+}
+
+// HandlerUpload stores file sent as multipart form and responds with
+// attachment referencing it, which can be sent with messages.
+func HandlerUpload(deps HandlerUploadDependencies) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		log := deps.Logger.WithField("reqID", middleware.GetReqID(ctx))
+
+		state := SessionContextState(ctx)
+		if state == nil {
+			writeError(w, r, http.StatusForbidden, ErrorReasonUnauthenticated, "Uploading files requires authentication.")
+			return
+		}
+
+		// Whole request can't be much larger than the file itself.
+		r.Body = http.MaxBytesReader(w, r.Body, deps.MaxSize+uploadFormOverhead)
+		defer r.Body.Close()
+
+		mr, err := r.MultipartReader()
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, ErrorReasonInvalidBody, "Request must be multipart form.")
+			return
+		}
+
+		for {
+			part, err := mr.NextPart()
+			if errors.Is(err, io.EOF) {
+				writeError(w, r, http.StatusBadRequest, ErrorReasonInvalidBody, fmt.Sprintf("Form field %q is missing.", uploadFormField))
+				return
+			}
+			if err != nil {
+				uploadReadError(w, r, err)
+				return
+			}
+			if part.FormName() != uploadFormField {
+				continue
+			}
+
+			content, err := io.ReadAll(io.LimitReader(part, deps.MaxSize+1))
+			if err != nil {
+				uploadReadError(w, r, err)
+				return
+			}
+			if int64(len(content)) > deps.MaxSize {
+				writeError(w, r, http.StatusRequestEntityTooLarge, ErrorReasonFileTooLarge, fmt.Sprintf("File cannot be larger than %d bytes.", deps.MaxSize))
+				return
+			}
+
+			mediaType, _, _ := mime.ParseMediaType(http.DetectContentType(content))
+			if !slices.Contains(deps.ContentTypes, mediaType) {
+				writeError(w, r, http.StatusUnsupportedMediaType, ErrorReasonUnsupportedMediaType, fmt.Sprintf("Files of type %s are not allowed.", mediaType))
+				return
+			}
+
+			id, err := uploadID()
+			if err != nil {
+				log.WithField("error", err.Error()).Error("Failed to generate upload id.")
+				writeError(w, r, http.StatusInternalServerError, ErrorReasonInternal, "Failed to store file.")
+				return
+			}
+			a := Attachment{
+				ID:          id,
+				Name:        filepath.Base(part.FileName()),
+				ContentType: mediaType,
+				Size:        int64(len(content)),
+				URL:         uploadURL(id),
+			}
+
+			if err := deps.Uploads.SaveUpload(ctx, a, bytes.NewReader(content)); err != nil {
+				log.WithField("error", err.Error()).Error("Failed to store uploaded file.")
+				writeError(w, r, http.StatusInternalServerError, ErrorReasonInternal, "Failed to store file.")
+				return
+			}
+
+			jsonResponse(w, http.StatusCreated, responseWrapper{
+				Data: a,
+			})
+			return
+		}
+	}
+}
+
+// uploadReadError responds to request, which body couldn't be read.
+func uploadReadError(w http.ResponseWriter, r *http.Request, err error) {
+	maxBytesErr := &http.MaxBytesError{}
+	if errors.As(err, &maxBytesErr) {
+		writeError(w, r, http.StatusRequestEntityTooLarge, ErrorReasonFileTooLarge, "Request body is too large.")
+		return
+	}
+	writeError(w, r, http.StatusBadRequest, ErrorReasonInvalidBody, "Failed to read multipart form.")
+}
+
+// HandlerUploadFile serves uploaded file with given ID. File is
+// streamed from upload store and it's offered for download with its
+// original name. Malformed IDs are rejected by upload stores, so they
+// can't be used for path traversal.
+func HandlerUploadFile(log *logrus.Logger, uploads UploadStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		id := chi.URLParam(r, "id")
+
+		a, err := uploads.Upload(ctx, id)
+		if errors.Is(err, ErrNoSuchUpload) {
+			writeError(w, r, http.StatusNotFound, ErrorReasonNotFound, "File doesn't exist.")
+			return
+		}
+		if err != nil {
+			log.WithFields(logrus.Fields{
+				"reqID": middleware.GetReqID(ctx),
+				"error": err.Error(),
+			}).Error("Failed to read uploaded file metadata.")
+			writeError(w, r, http.StatusInternalServerError, ErrorReasonInternal, "Failed to read file.")
+			return
+		}
+
+		f, err := uploads.OpenUpload(ctx, id)
+		if errors.Is(err, ErrNoSuchUpload) {
+			writeError(w, r, http.StatusNotFound, ErrorReasonNotFound, "File doesn't exist.")
+			return
+		}
+		if err != nil {
+			log.WithFields(logrus.Fields{
+				"reqID": middleware.GetReqID(ctx),
+				"error": err.Error(),
+			}).Error("Failed to open uploaded file.")
+			writeError(w, r, http.StatusInternalServerError, ErrorReasonInternal, "Failed to read file.")
+			return
+		}
+		defer f.Close()
+
+		// Uploaded files never change, as every upload gets new ID.
+		w.Header().Set("Content-Type", a.ContentType)
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{
+			"filename": a.Name,
+		}))
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.Header().Set("Cache-Control", "private, max-age=31536000, immutable")
+		w.Header().Set("ETag", `"`+a.ID+`"`)
+
+		// ServeContent copies file in chunks, handling range and
+		// conditional requests.
+		http.ServeContent(w, r, "", time.Time{}, f)
+	}
+}
@@ -0,0 +1,288 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fenole/szmaterlok/service/sse"
+	"github.com/go-chi/chi/v5"
+	"github.com/matryer/is"
+)
+
+// testPNG is content detected as PNG image.
+var testPNG = append([]byte("\x89PNG\r\n\x1a\n"), bytes.Repeat([]byte{0}, 64)...)
+
+// uploadRequest returns multipart request uploading given file.
+func uploadRequest(t *testing.T, field, name string, content []byte) *http.Request {
+	body := &bytes.Buffer{}
+	mw := multipart.NewWriter(body)
+	fw, err := mw.CreateFormFile(field, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fw.Write(content); err != nil {
+		t.Fatal(err)
+	}
+	if err := mw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r := httptest.NewRequest(http.MethodPost, "/upload", body)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	return requestWithSession(context.Background(), r, &SessionState{
+		ID:       "id",
+		Nickname: "nickname",
+	})
+}
+
+func TestHandlerUpload(t *testing.T) {
+	type testArgs struct {
+		name    string
+		field   string
+		file    string
+		content []byte
+		code    int
+	}
+
+	scenario := func(tt testArgs) (string, func(*testing.T)) {
+		return tt.name, func(t *testing.T) {
+			is := is.New(t)
+
+			store, err := NewUploadDiskStore(t.TempDir())
+			is.NoErr(err)
+
+			h := HandlerUpload(HandlerUploadDependencies{
+				Logger:       testLogger(),
+				Uploads:      store,
+				MaxSize:      128,
+				ContentTypes: []string{"image/png", "image/jpeg"},
+			})
+
+			w := httptest.NewRecorder()
+			h(w, uploadRequest(t, tt.field, tt.file, tt.content))
+			is.Equal(w.Code, tt.code)
+			if tt.code != http.StatusCreated {
+				return
+			}
+
+			res := struct {
+				Data Attachment `json:"data"`
+			}{}
+			is.NoErr(json.NewDecoder(w.Body).Decode(&res))
+			is.True(uploadIDPattern.MatchString(res.Data.ID)) // id should be random hex
+			is.Equal(res.Data.Name, "cat.png")
+			is.Equal(res.Data.ContentType, "image/png")
+			is.Equal(res.Data.Size, int64(len(tt.content)))
+			is.Equal(res.Data.URL, "/files/"+res.Data.ID)
+
+			stored, err := store.Upload(context.Background(), res.Data.ID)
+			is.NoErr(err)
+			is.Equal(stored, res.Data)
+
+			f, err := store.OpenUpload(context.Background(), res.Data.ID)
+			is.NoErr(err)
+			defer f.Close()
+			content, err := io.ReadAll(f)
+			is.NoErr(err)
+			is.Equal(content, tt.content)
+		}
+	}
+
+	t.Run(scenario(testArgs{
+		name:    "upload",
+		field:   uploadFormField,
+		file:    "cat.png",
+		content: testPNG,
+		code:    http.StatusCreated,
+	}))
+	t.Run(scenario(testArgs{
+		name:    "oversized file",
+		field:   uploadFormField,
+		file:    "cat.png",
+		content: append(bytes.Clone(testPNG), bytes.Repeat([]byte{0}, 128)...),
+		code:    http.StatusRequestEntityTooLarge,
+	}))
+	t.Run(scenario(testArgs{
+		name:    "disallowed content type",
+		field:   uploadFormField,
+		file:    "cat.png", // declared name doesn't matter
+		content: []byte("<html><script>alert(1)</script></html>"),
+		code:    http.StatusUnsupportedMediaType,
+	}))
+	t.Run(scenario(testArgs{
+		name:    "missing file",
+		field:   "image",
+		file:    "cat.png",
+		content: testPNG,
+		code:    http.StatusBadRequest,
+	}))
+}
+
+func TestHandlerUploadFile(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+
+	store, err := NewUploadDiskStore(t.TempDir())
+	is.NoErr(err)
+
+	id, err := uploadID()
+	is.NoErr(err)
+	is.NoErr(store.SaveUpload(ctx, Attachment{
+		ID:          id,
+		Name:        "cat.png",
+		ContentType: "image/png",
+		Size:        int64(len(testPNG)),
+		URL:         uploadURL(id),
+	}, bytes.NewReader(testPNG)))
+
+	router := chi.NewRouter()
+	router.Get("/files/{id}", HandlerUploadFile(testLogger(), store))
+
+	get := func(path string, header http.Header) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		for key, vals := range header {
+			r.Header[key] = vals
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		return w
+	}
+
+	t.Run("valid", func(t *testing.T) {
+		is := is.New(t)
+
+		w := get(uploadURL(id), nil)
+		is.Equal(w.Code, http.StatusOK)
+		is.Equal(w.Header().Get("Content-Type"), "image/png")
+		is.Equal(w.Header().Get("Content-Disposition"), `attachment; filename=cat.png`)
+		is.Equal(w.Header().Get("X-Content-Type-Options"), "nosniff")
+		is.True(strings.Contains(w.Header().Get("Cache-Control"), "immutable"))
+		is.Equal(w.Body.Bytes(), testPNG)
+
+		// File is cached by client.
+		w = get(uploadURL(id), http.Header{"If-None-Match": {w.Header().Get("ETag")}})
+		is.Equal(w.Code, http.StatusNotModified)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		scenario := func(name, path string) (string, func(*testing.T)) {
+			return name, func(t *testing.T) {
+				is := is.New(t)
+
+				w := get(path, nil)
+				is.Equal(w.Code, http.StatusNotFound)
+				is.True(!bytes.Contains(w.Body.Bytes(), testPNG))
+			}
+		}
+
+		t.Run(scenario("missing file", "/files/"+strings.Repeat("0", 32)))
+		t.Run(scenario("traversal", "/files/../"+id))
+		t.Run(scenario("escaped traversal", "/files/..%2F"+id))
+		t.Run(scenario("metadata", "/files/"+id+".json"))
+	})
+}
+
+func TestUploadDiskStoreTraversal(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+
+	store, err := NewUploadDiskStore(t.TempDir())
+	is.NoErr(err)
+
+	for _, id := range []string{"../secret", "..", "/etc/passwd", ""} {
+		_, err := store.Upload(ctx, id)
+		is.True(errors.Is(err, ErrNoSuchUpload))
+
+		_, err = store.OpenUpload(ctx, id)
+		is.True(errors.Is(err, ErrNoSuchUpload))
+
+		is.True(store.SaveUpload(ctx, Attachment{ID: id}, bytes.NewReader(testPNG)) != nil)
+	}
+}
+
+func TestHandlerSendMessageAttachments(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+	log := testLogger()
+
+	store, err := NewUploadDiskStore(t.TempDir())
+	is.NoErr(err)
+
+	id, err := uploadID()
+	is.NoErr(err)
+	attachment := Attachment{
+		ID:          id,
+		Name:        "cat.png",
+		ContentType: "image/png",
+		Size:        int64(len(testPNG)),
+		URL:         uploadURL(id),
+	}
+	is.NoErr(store.SaveUpload(ctx, attachment, bytes.NewReader(testPNG)))
+
+	messageHandler := NewBridgeMessageHandler(log)
+	router := NewBridgeEventRouter()
+	router.Hook(BridgeMessageSent, messageHandler)
+
+	bridge := NewBridge(ctx, BridgeBuilder{
+		Handler: router,
+		Logger:  log,
+		Storage: newBridgeStorageMock(),
+	})
+	defer bridge.Shutdown(ctx)
+
+	evts := make(chan sse.Event, 1)
+	unsubscribe := messageHandler.Subscribe(ctx, MessageSubscribeRequest{
+		ID:        "id",
+		RequestID: "req",
+		Channel:   evts,
+	})
+	defer unsubscribe()
+
+	h := HandlerSendMessage(HandlerSendMessageDependencies{
+		MaxMessageSize: NewMessageSizeLimit(255),
+		Sender: &BridgeEventProducer[EventSentMessage]{
+			EventBridge: bridge,
+			Type:        BridgeMessageSent,
+			Log:         log,
+			Clock:       testClock(),
+		},
+		Uploads:     store,
+		Logger:      log,
+		IDGenerator: testIDGenerator(),
+		Clock:       testClock(),
+	})
+
+	send := func(body string) *httptest.ResponseRecorder {
+		r := requestWithSession(ctx, httptest.NewRequest(http.MethodPost, "/message", strings.NewReader(body)), &SessionState{
+			ID:       "id",
+			Nickname: "nickname",
+		})
+		w := httptest.NewRecorder()
+		h(w, r)
+		return w
+	}
+
+	is.Equal(send(`{"attachments":["`+strings.Repeat("0", 32)+`"]}`).Code, http.StatusBadRequest) // unknown attachment
+
+	// Message can consist only of attachments.
+	is.Equal(send(`{"attachments":["`+id+`"]}`).Code, http.StatusAccepted)
+
+	select {
+	case evt := <-evts:
+		msg := EventSentMessage{}
+		is.NoErr(json.Unmarshal(evt.Data, &msg))
+		is.Equal(msg.Content, "")
+		is.Equal(msg.Attachments, []Attachment{attachment})
+	case <-time.After(time.Second):
+		t.Fatal("message has not been sent")
+	}
+}
@@ -0,0 +1,232 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// WebhookSignatureHeader holds HMAC-SHA256 signature of webhook
+	// payload, prefixed with "sha256=".
+	WebhookSignatureHeader = "X-S8K-Signature"
+
+	// WebhookEventHeader holds type of delivered event.
+	WebhookEventHeader = "X-S8K-Event"
+
+	// WebhookDeliveryHeader holds ID of delivered event. It's the same
+	// for every retry, so receivers can ignore duplicates.
+	WebhookDeliveryHeader = "X-S8K-Delivery"
+)
+
+// webhookPayload is JSON body of webhook request. Event data is
+// embedded as JSON, bridge headers are internal and they aren't sent.
+type webhookPayload struct {
+	Name      BridgeEventType `json:"type"`
+	ID        string          `json:"id"`
+	CreatedAt int64           `json:"createdAt"`
+	Data      json.RawMessage `json:"data"`
+}
+
+// WebhookSignature returns signature of given webhook payload, which
+// is sent in WebhookSignatureHeader.
+func WebhookSignature(secret, payload []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// WebhookHandler is bridge event handler, which posts events to
+// external endpoints. Every endpoint is notified concurrently. Failed
+// deliveries are retried with exponential backoff.
+type WebhookHandler struct {
+	client  *http.Client
+	urls    []string
+	secret  []byte
+	timeout time.Duration
+	retries int
+	backoff time.Duration
+	log     *logrus.Logger
+}
+
+// WebhookHandlerBuilder holds arguments for building webhook handler.
+type WebhookHandlerBuilder struct {
+	// URLs of endpoints receiving events.
+	URLs []string
+
+	// Secret signs payloads with HMAC. Payloads aren't signed when
+	// it's empty.
+	Secret string
+
+	// Timeout of single delivery attempt.
+	Timeout time.Duration
+
+	// Retries is number of delivery attempts after the first one
+	// has failed.
+	Retries int
+
+	// Backoff is delay before the first retry. It's doubled with
+	// every next retry.
+	Backoff time.Duration
+
+	// Client sends requests. http.DefaultClient is used when it's nil.
+	Client *http.Client
+
+	Logger *logrus.Logger
+}
+
+// NewWebhookHandler returns webhook handler configured with given
+// arguments.
+func NewWebhookHandler(args WebhookHandlerBuilder) *WebhookHandler {
+	client := args.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	return &WebhookHandler{
+		client:  client,
+		urls:    args.URLs,
+		secret:  []byte(args.Secret),
+		timeout: args.Timeout,
+		retries: args.Retries,
+		backoff: args.Backoff,
+		log:     args.Logger,
+	}
+}
+
+// EventHook delivers given event to every endpoint. It returns after
+// all of the deliveries have succeeded or failed. Deliveries stop, when
+// given context is cancelled, so hook timeout of event router has to
+// cover all of the attempts, see MaxDuration.
+func (h *WebhookHandler) EventHook(ctx context.Context, evt BridgeEvent) {
+	log := h.log.WithFields(logrus.Fields{
+		"reqID":   evt.Headers.Get(bridgeRequestIDHeaderVar),
+		"evtID":   evt.ID,
+		"evtType": evt.Name,
+	})
+
+	body, err := json.Marshal(webhookPayload{
+		Name:      evt.Name,
+		ID:        evt.ID,
+		CreatedAt: evt.CreatedAt,
+		Data:      json.RawMessage(evt.Data),
+	})
+	if err != nil {
+		log.WithField("error", err.Error()).Error("Failed to encode webhook payload.")
+		return
+	}
+
+	wg := sync.WaitGroup{}
+	for _, url := range h.urls {
+		url := url
+		goWithWaitGroup(&wg, func() {
+			if err := h.deliver(ctx, url, evt, body); err != nil {
+				log.WithFields(logrus.Fields{
+					"url":   url,
+					"error": err.Error(),
+				}).Error("Failed to deliver webhook.")
+			}
+		})
+	}
+	wg.Wait()
+}
+
+// MaxDuration returns the longest time, which delivery of single event
+// with all of its retries can take. It's zero, when delivery attempts
+// have no timeout, so deliveries aren't bounded.
+func (h *WebhookHandler) MaxDuration() time.Duration {
+	if h.timeout <= 0 {
+		return 0
+	}
+
+	res := h.timeout
+	backoff := h.backoff
+	for i := 0; i < h.retries; i++ {
+		res += backoff + h.timeout
+		backoff *= 2
+	}
+
+	return res
+}
+
+// errWebhookPermanent marks delivery failures, which aren't retried.
+var errWebhookPermanent = errors.New("permanent failure")
+
+// deliver posts given payload to given endpoint, retrying failed
+// attempts.
+func (h *WebhookHandler) deliver(ctx context.Context, url string, evt BridgeEvent, body []byte) error {
+	backoff := h.backoff
+
+	var err error
+	for attempt := 0; attempt <= h.retries; attempt++ {
+		if attempt > 0 {
+			timer := time.NewTimer(backoff)
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				return fmt.Errorf("delivery cancelled after %d attempts: %w", attempt, err)
+			}
+			backoff *= 2
+		}
+
+		// Hook abandoned by event router has its context cancelled,
+		// so it doesn't make another attempt in the background.
+		if ctx.Err() != nil {
+			return fmt.Errorf("delivery cancelled after %d attempts: %w", attempt, ctx.Err())
+		}
+
+		err = h.post(ctx, url, evt, body)
+		if err == nil || errors.Is(err, errWebhookPermanent) {
+			return err
+		}
+	}
+
+	return fmt.Errorf("delivery failed after %d attempts: %w", h.retries+1, err)
+}
+
+// post makes single delivery attempt.
+func (h *WebhookHandler) post(ctx context.Context, url string, evt BridgeEvent, body []byte) error {
+	if h.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, h.timeout)
+		defer cancel()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("%w: %s", errWebhookPermanent, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookEventHeader, string(evt.Name))
+	req.Header.Set(WebhookDeliveryHeader, evt.ID)
+	if len(h.secret) > 0 {
+		req.Header.Set(WebhookSignatureHeader, WebhookSignature(h.secret, body))
+	}
+
+	res, err := h.client.Do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+
+	switch {
+	case res.StatusCode >= 200 && res.StatusCode < 300:
+		return nil
+	case res.StatusCode == http.StatusTooManyRequests || res.StatusCode >= 500:
+		return fmt.Errorf("unexpected status code: %d", res.StatusCode)
+	default:
+		// Other client errors won't go away with retries.
+		return fmt.Errorf("%w: unexpected status code: %d", errWebhookPermanent, res.StatusCode)
+	}
+}
@@ -0,0 +1,251 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+)
+
+// webhookReceiver is local webhook endpoint, which records delivered
+// requests and responds with consecutive status codes.
+type webhookReceiver struct {
+	*httptest.Server
+
+	mtx      sync.Mutex
+	requests []*http.Request
+	bodies   [][]byte
+	codes    []int
+	delay    time.Duration
+}
+
+func newWebhookReceiver(t *testing.T, delay time.Duration, codes ...int) *webhookReceiver {
+	rcv := &webhookReceiver{
+		codes: codes,
+		delay: delay,
+	}
+	rcv.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+
+		rcv.mtx.Lock()
+		rcv.requests = append(rcv.requests, r)
+		rcv.bodies = append(rcv.bodies, body)
+		code := http.StatusNoContent
+		if len(rcv.codes) > 0 {
+			code, rcv.codes = rcv.codes[0], rcv.codes[1:]
+		}
+		rcv.mtx.Unlock()
+
+		select {
+		case <-time.After(rcv.delay):
+		case <-r.Context().Done():
+		}
+		w.WriteHeader(code)
+	}))
+	t.Cleanup(rcv.Close)
+
+	return rcv
+}
+
+func (rcv *webhookReceiver) Attempts() int {
+	rcv.mtx.Lock()
+	defer rcv.mtx.Unlock()
+	return len(rcv.requests)
+}
+
+func TestWebhookHandler(t *testing.T) {
+	type testArgs struct {
+		name    string
+		codes   []int
+		delay   time.Duration
+		retries int
+
+		attempts int
+	}
+
+	scenario := func(tt testArgs) (string, func(*testing.T)) {
+		return tt.name, func(t *testing.T) {
+			is := is.New(t)
+
+			rcv := newWebhookReceiver(t, tt.delay, tt.codes...)
+			h := NewWebhookHandler(WebhookHandlerBuilder{
+				URLs:    []string{rcv.URL},
+				Timeout: time.Millisecond * 50,
+				Retries: tt.retries,
+				Backoff: time.Millisecond,
+				Logger:  testLogger(),
+			})
+
+			h.EventHook(context.Background(), BridgeEvent{
+				Name: BridgeMessageSent,
+				ID:   "evt",
+				Data: []byte(`{"content":"hello"}`),
+			})
+			is.Equal(rcv.Attempts(), tt.attempts)
+
+			// Every retry is the same delivery.
+			for _, r := range rcv.requests {
+				is.Equal(r.Header.Get(WebhookDeliveryHeader), "evt")
+			}
+		}
+	}
+
+	t.Run(scenario(testArgs{
+		name:     "delivered",
+		retries:  3,
+		attempts: 1,
+	}))
+	t.Run(scenario(testArgs{
+		name:     "retried server errors",
+		codes:    []int{http.StatusInternalServerError, http.StatusTooManyRequests},
+		retries:  3,
+		attempts: 3,
+	}))
+	t.Run(scenario(testArgs{
+		name:     "retries exhausted",
+		codes:    []int{http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway},
+		retries:  2,
+		attempts: 3,
+	}))
+	t.Run(scenario(testArgs{
+		name:     "client error is not retried",
+		codes:    []int{http.StatusBadRequest},
+		retries:  3,
+		attempts: 1,
+	}))
+	t.Run(scenario(testArgs{
+		name:     "timeout",
+		delay:    time.Second,
+		retries:  1,
+		attempts: 2,
+	}))
+}
+
+// roundTripperFunc is functional interface of http.RoundTripper.
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+func TestWebhookHandlerCancel(t *testing.T) {
+	is := is.New(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Hook is abandoned right after the first attempt.
+	attempts := 0
+	h := NewWebhookHandler(WebhookHandlerBuilder{
+		URLs:    []string{"http://webhook.invalid"},
+		Retries: 10,
+		Client: &http.Client{
+			Transport: roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+				attempts++
+				cancel()
+				return &http.Response{
+					StatusCode: http.StatusInternalServerError,
+					Body:       http.NoBody,
+				}, nil
+			}),
+		},
+		Logger: testLogger(),
+	})
+
+	h.EventHook(ctx, BridgeEvent{
+		Name: BridgeMessageSent,
+		ID:   "evt",
+		Data: []byte(`{"content":"hello"}`),
+	})
+	is.Equal(attempts, 1)
+}
+
+func TestWebhookHandlerMaxDuration(t *testing.T) {
+	is := is.New(t)
+
+	h := NewWebhookHandler(WebhookHandlerBuilder{
+		Timeout: time.Second * 5,
+		Retries: 3,
+		Backoff: time.Second,
+	})
+	is.Equal(h.MaxDuration(), time.Second*27)
+
+	// Attempts without timeout aren't bounded.
+	h = NewWebhookHandler(WebhookHandlerBuilder{Retries: 3})
+	is.Equal(h.MaxDuration(), time.Duration(0))
+}
+
+func TestWebhookHandlerSignature(t *testing.T) {
+	is := is.New(t)
+
+	receivers := []*webhookReceiver{
+		newWebhookReceiver(t, 0),
+		newWebhookReceiver(t, 0),
+	}
+	h := NewWebhookHandler(WebhookHandlerBuilder{
+		URLs:    []string{receivers[0].URL, receivers[1].URL},
+		Secret:  "webhook-secret",
+		Timeout: time.Second,
+		Logger:  testLogger(),
+	})
+
+	h.EventHook(context.Background(), BridgeEvent{
+		Name:      BridgeMessageSent,
+		ID:        "evt",
+		CreatedAt: 1683000000,
+		Headers: BridgeHeaders{
+			bridgeRequestIDHeaderVar: "req",
+		},
+		Data: []byte(`{"content":"hello"}`),
+	})
+
+	for _, rcv := range receivers {
+		is.Equal(rcv.Attempts(), 1) // every endpoint receives event
+		r, body := rcv.requests[0], rcv.bodies[0]
+
+		is.Equal(r.Method, http.MethodPost)
+		is.Equal(r.Header.Get("Content-Type"), "application/json")
+		is.Equal(r.Header.Get(WebhookEventHeader), string(BridgeMessageSent))
+		is.Equal(r.Header.Get(WebhookSignatureHeader), WebhookSignature([]byte("webhook-secret"), body))
+
+		payload := struct {
+			Type      string            `json:"type"`
+			ID        string            `json:"id"`
+			CreatedAt int64             `json:"createdAt"`
+			Data      map[string]string `json:"data"`
+			Headers   map[string]string `json:"headers"`
+		}{}
+		is.NoErr(json.Unmarshal(body, &payload))
+		is.Equal(payload.Type, string(BridgeMessageSent))
+		is.Equal(payload.ID, "evt")
+		is.Equal(payload.CreatedAt, int64(1683000000))
+		is.Equal(payload.Data["content"], "hello")
+		is.Equal(payload.Headers, nil) // internal headers are not sent
+	}
+
+	// Tampered payload doesn't match signature.
+	is.True(WebhookSignature([]byte("webhook-secret"), []byte(`{}`)) != receivers[0].requests[0].Header.Get(WebhookSignatureHeader))
+}
+
+func TestWebhookHandlerUnsigned(t *testing.T) {
+	is := is.New(t)
+
+	rcv := newWebhookReceiver(t, 0)
+	h := NewWebhookHandler(WebhookHandlerBuilder{
+		URLs:   []string{rcv.URL},
+		Logger: testLogger(),
+	})
+
+	h.EventHook(context.Background(), BridgeEvent{
+		Name: BridgeUserJoin,
+		ID:   "evt",
+		Data: []byte(`{}`),
+	})
+	is.Equal(rcv.Attempts(), 1)
+	is.Equal(rcv.requests[0].Header.Get(WebhookSignatureHeader), "")
+}
@@ -6,13 +6,18 @@ import (
 	"fmt"
 
 	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/database/postgres"
 	"github.com/golang-migrate/migrate/v4/database/sqlite"
 	"github.com/golang-migrate/migrate/v4/source/iofs"
 
 	_ "modernc.org/sqlite"
 )
 
-const currentVersion = 1
+const currentVersion = 11
+
+// postgresCurrentVersion is version of postgres migrations. They are
+// numbered independently from sqlite ones.
+const postgresCurrentVersion = 6
 
 //go:embed sqlite_migrations
 var sqliteMigrations embed.FS
@@ -41,3 +46,31 @@ func migrateSQLite(db *sql.DB) error {
 
 	return sourceInstance.Close()
 }
+
+//go:embed postgres_migrations
+var postgresMigrations embed.FS
+
+func migratePostgres(db *sql.DB) error {
+	sourceInstance, err := iofs.New(postgresMigrations, "postgres_migrations")
+	if err != nil {
+		return fmt.Errorf("invalid source instance, %w", err)
+	}
+
+	targetInstance, err := postgres.WithInstance(db, new(postgres.Config))
+	if err != nil {
+		return fmt.Errorf("invalid target postgres instance, %w", err)
+	}
+
+	m, err := migrate.NewWithInstance(
+		"iofs", sourceInstance, "postgres", targetInstance)
+	if err != nil {
+		return fmt.Errorf("failed to initialize migrate instance, %w", err)
+	}
+
+	err = m.Migrate(postgresCurrentVersion)
+	if err != nil && err != migrate.ErrNoChange {
+		return err
+	}
+
+	return sourceInstance.Close()
+}
@@ -0,0 +1,455 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/fenole/szmaterlok/service"
+
+	_ "embed"
+)
+
+// PostgresStorage is event storage backed by postgres database. Unlike
+// sqlite storage, it can be shared by multiple szmaterlok instances.
+type PostgresStorage struct {
+	db *sql.DB
+
+	// now returns current time. It is used for expiration of
+	// revoked sessions.
+	now func() time.Time
+}
+
+// NewPostgresStorage opens and migrates postgres storage with given
+// connection string.
+func NewPostgresStorage(ctx context.Context, dsn string) (*PostgresStorage, error) {
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open postgres db: %w", err)
+	}
+
+	if err := db.PingContext(ctx); err != nil {
+		return nil, fmt.Errorf("failed to connect to postgres db: %w", err)
+	}
+
+	if err := migratePostgres(db); err != nil {
+		return nil, fmt.Errorf("migration failed: %w", err)
+	}
+
+	return &PostgresStorage{
+		db:  db,
+		now: time.Now,
+	}, nil
+}
+
+//go:embed postgres_store_event.sql
+var postgresStoreEventQuery string
+
+// StoreEvent stores given bridge event in postgres event storage.
+func (s *PostgresStorage) StoreEvent(ctx context.Context, evt service.BridgeEvent) error {
+	headers, err := json.Marshal(evt.Headers)
+	if err != nil {
+		return fmt.Errorf("failed to encode headers as json: %w", err)
+	}
+
+	// Headers are passed as string, because byte slices are sent
+	// to postgres as bytea.
+	_, err = s.db.ExecContext(
+		ctx,
+		postgresStoreEventQuery,
+		evt.ID,
+		string(evt.Name),
+		evt.CreatedAt,
+		string(headers),
+		evt.Data,
+		int64(evt.Sequence),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to store event: %w", err)
+	}
+
+	return nil
+}
+
+// StoreEvents stores given bridge events in postgres event storage
+// within single transaction. Either all of the events are stored or none.
+func (s *PostgresStorage) StoreEvents(ctx context.Context, evts []service.BridgeEvent) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, postgresStoreEventQuery)
+	if err != nil {
+		return fmt.Errorf("failed to prepare statement: %w", err)
+	}
+	defer stmt.Close()
+
+	for _, evt := range evts {
+		headers, err := json.Marshal(evt.Headers)
+		if err != nil {
+			return fmt.Errorf("failed to encode headers as json: %w", err)
+		}
+
+		if _, err := stmt.ExecContext(
+			ctx,
+			evt.ID,
+			string(evt.Name),
+			evt.CreatedAt,
+			string(headers),
+			evt.Data,
+			int64(evt.Sequence),
+		); err != nil {
+			return fmt.Errorf("failed to store event %s: %w", evt.ID, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+//go:embed postgres_events.sql
+var postgresEventsQuery string
+
+// Events sends all events from state archive through given channels
+// grouped by their creation date.
+func (s *PostgresStorage) Events(ctx context.Context, c chan<- service.BridgeEvent) error {
+	rows, err := s.db.QueryContext(ctx, postgresEventsQuery)
+	if err != nil {
+		return fmt.Errorf("failed to create query: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		evt, err := scanEvent(rows)
+		if err != nil {
+			return err
+		}
+
+		c <- evt
+	}
+
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("rows iteration failure: %w", err)
+	}
+
+	return nil
+}
+
+//go:embed postgres_events_filtered.sql
+var postgresEventsFilteredQuery string
+
+// EventsFiltered sends events selected by given filter through given
+// channel grouped by their creation date.
+func (s *PostgresStorage) EventsFiltered(ctx context.Context, filter service.EventFilter, c chan<- service.BridgeEvent) error {
+	since, until := filterRange(filter)
+
+	rows, err := s.db.QueryContext(
+		ctx,
+		postgresEventsFilteredQuery,
+		since,
+		until,
+		pq.Array(filterTypes(filter)),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create query: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		evt, err := scanEvent(rows)
+		if err != nil {
+			return err
+		}
+
+		c <- evt
+	}
+
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("rows iteration failure: %w", err)
+	}
+
+	return nil
+}
+
+//go:embed postgres_events_before.sql
+var postgresEventsBeforeQuery string
+
+//go:embed postgres_messages_before.sql
+var postgresMessagesBeforeQuery string
+
+// MessagesBefore returns at most limit of message-sent events from given
+// chat channel and direct messages of user with given ID, which were
+// stored before event with given ID, in reverse order of their sequence
+// numbers. Empty before ID means that the newest messages are returned.
+// Deleted messages are skipped and edited ones have their current content.
+func (s *PostgresStorage) MessagesBefore(ctx context.Context, userID, channel, before string, limit int) ([]service.BridgeEvent, error) {
+	rows, err := s.db.QueryContext(
+		ctx,
+		postgresMessagesBeforeQuery,
+		string(service.BridgeMessageSent),
+		before,
+		limit,
+		string(service.BridgeMessageEdited),
+		string(service.BridgeMessageDeleted),
+		userID,
+		channel,
+		service.ChatChannelDefault,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create query: %w", err)
+	}
+
+	return scanEvents(rows)
+}
+
+// ModerationActionsBefore returns at most limit of moderation-action
+// events, which were stored before event with given ID, in reverse order
+// of their sequence numbers. Empty before ID means that the newest
+// actions are returned.
+func (s *PostgresStorage) ModerationActionsBefore(ctx context.Context, before string, limit int) ([]service.BridgeEvent, error) {
+	return s.eventsBefore(ctx, service.BridgeModerationAction, before, limit)
+}
+
+// eventsBefore returns at most limit of events of given type, which were
+// stored before event with given ID, in reverse order of their sequence
+// numbers.
+func (s *PostgresStorage) eventsBefore(ctx context.Context, t service.BridgeEventType, before string, limit int) ([]service.BridgeEvent, error) {
+	rows, err := s.db.QueryContext(
+		ctx,
+		postgresEventsBeforeQuery,
+		string(t),
+		before,
+		limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create query: %w", err)
+	}
+
+	return scanEvents(rows)
+}
+
+//go:embed postgres_last_sequence.sql
+var postgresLastSequenceQuery string
+
+// LastSequence returns the greatest sequence number of stored events
+// or reserved by event bridge. It's zero when there are neither.
+func (s *PostgresStorage) LastSequence(ctx context.Context) (uint64, error) {
+	var res int64
+	if err := s.db.QueryRowContext(ctx, postgresLastSequenceQuery).Scan(&res); err != nil {
+		return 0, fmt.Errorf("failed to read last sequence number: %w", err)
+	}
+
+	return uint64(res), nil
+}
+
+//go:embed postgres_reserve_sequence.sql
+var postgresReserveSequenceQuery string
+
+// ReserveSequence stores given sequence number as the highest one,
+// which event bridge could have assigned. Lower numbers than already
+// reserved one are ignored.
+func (s *PostgresStorage) ReserveSequence(ctx context.Context, sequence uint64) error {
+	if _, err := s.db.ExecContext(
+		ctx,
+		postgresReserveSequenceQuery,
+		int64(sequence),
+	); err != nil {
+		return fmt.Errorf("failed to reserve sequence number: %w", err)
+	}
+
+	return nil
+}
+
+//go:embed postgres_search_messages.sql
+var postgresSearchMessagesQuery string
+
+// SearchMessages returns at most limit of message-sent events, which
+// current content matches all words from given query, in reverse
+// chronological order. Deleted messages and direct messages, which
+// user with given ID neither sent nor received, are skipped. Edited
+// messages have their current content.
+func (s *PostgresStorage) SearchMessages(ctx context.Context, userID, query string, limit int) ([]service.BridgeEvent, error) {
+	if strings.TrimSpace(query) == "" {
+		return []service.BridgeEvent{}, nil
+	}
+
+	rows, err := s.db.QueryContext(
+		ctx,
+		postgresSearchMessagesQuery,
+		string(service.BridgeMessageSent),
+		query,
+		userID,
+		string(service.BridgeMessageEdited),
+		string(service.BridgeMessageDeleted),
+		limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create query: %w", err)
+	}
+
+	return scanEvents(rows)
+}
+
+// Ping verifies that connection to the database is still alive.
+func (s *PostgresStorage) Ping(ctx context.Context) error {
+	if err := s.db.PingContext(ctx); err != nil {
+		return fmt.Errorf("failed to ping postgres db: %w", err)
+	}
+
+	return nil
+}
+
+//go:embed postgres_revoke_session.sql
+var postgresRevokeSessionQuery string
+
+//go:embed postgres_collect_revoked_sessions.sql
+var postgresCollectRevokedSessionsQuery string
+
+// RevokeSession adds session with given ID to the revocation list
+// until given expiration date of the session. Later expiration date
+// of already revoked session is kept. Entries of already expired
+// sessions are garbage collected.
+func (s *PostgresStorage) RevokeSession(ctx context.Context, id string, expireAt time.Time) error {
+	if _, err := s.db.ExecContext(
+		ctx,
+		postgresCollectRevokedSessionsQuery,
+		s.now().Unix(),
+	); err != nil {
+		return fmt.Errorf("failed to collect revoked sessions: %w", err)
+	}
+
+	if _, err := s.db.ExecContext(
+		ctx,
+		postgresRevokeSessionQuery,
+		id,
+		expireAt.Unix(),
+	); err != nil {
+		return fmt.Errorf("failed to revoke session: %w", err)
+	}
+
+	return nil
+}
+
+//go:embed postgres_session_revoked.sql
+var postgresSessionRevokedQuery string
+
+// SessionRevoked reports whether session with given ID is revoked.
+func (s *PostgresStorage) SessionRevoked(ctx context.Context, id string) (bool, error) {
+	var count int
+	if err := s.db.QueryRowContext(
+		ctx,
+		postgresSessionRevokedQuery,
+		id,
+		s.now().Unix(),
+	).Scan(&count); err != nil {
+		return false, fmt.Errorf("failed to check session revocation: %w", err)
+	}
+
+	return count > 0, nil
+}
+
+//go:embed postgres_ban.sql
+var postgresBanQuery string
+
+//go:embed postgres_collect_bans.sql
+var postgresCollectBansQuery string
+
+// Ban bans given user ID or nickname until given expiration date.
+// Zero expiration date means permanent ban. Expired bans are garbage
+// collected.
+func (s *PostgresStorage) Ban(ctx context.Context, id string, expireAt time.Time) error {
+	if _, err := s.db.ExecContext(
+		ctx,
+		postgresCollectBansQuery,
+		s.now().Unix(),
+	); err != nil {
+		return fmt.Errorf("failed to collect bans: %w", err)
+	}
+
+	if _, err := s.db.ExecContext(
+		ctx,
+		postgresBanQuery,
+		id,
+		banExpireAt(expireAt),
+	); err != nil {
+		return fmt.Errorf("failed to ban: %w", err)
+	}
+
+	return nil
+}
+
+//go:embed postgres_unban.sql
+var postgresUnbanQuery string
+
+// Unban lifts ban of given user ID or nickname.
+func (s *PostgresStorage) Unban(ctx context.Context, id string) error {
+	if _, err := s.db.ExecContext(ctx, postgresUnbanQuery, id); err != nil {
+		return fmt.Errorf("failed to unban: %w", err)
+	}
+
+	return nil
+}
+
+//go:embed postgres_banned.sql
+var postgresBannedQuery string
+
+// Banned reports whether given user ID or nickname is banned.
+func (s *PostgresStorage) Banned(ctx context.Context, id string) (bool, error) {
+	var count int
+	if err := s.db.QueryRowContext(
+		ctx,
+		postgresBannedQuery,
+		id,
+		s.now().Unix(),
+	).Scan(&count); err != nil {
+		return false, fmt.Errorf("failed to check ban: %w", err)
+	}
+
+	return count > 0, nil
+}
+
+//go:embed postgres_prune_events.sql
+var postgresPruneEventsQuery string
+
+// PruneBefore deletes all events created before given time. It returns
+// number of deleted events.
+func (s *PostgresStorage) PruneBefore(ctx context.Context, t time.Time) (int64, error) {
+	res, err := s.db.ExecContext(ctx, postgresPruneEventsQuery, t.Unix())
+	if err != nil {
+		return 0, fmt.Errorf("failed to prune events: %w", err)
+	}
+
+	return res.RowsAffected()
+}
+
+//go:embed postgres_prune_ephemeral_events.sql
+var postgresPruneEphemeralEventsQuery string
+
+// PruneEphemeralBefore deletes ephemeral events (user presence and
+// typing) created before given time. It returns number of deleted
+// events.
+func (s *PostgresStorage) PruneEphemeralBefore(ctx context.Context, t time.Time) (int64, error) {
+	res, err := s.db.ExecContext(
+		ctx,
+		postgresPruneEphemeralEventsQuery,
+		t.Unix(),
+		string(service.BridgeUserJoin),
+		string(service.BridgeUserLeft),
+		string(service.BridgeUserTyping),
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to prune ephemeral events: %w", err)
+	}
+
+	return res.RowsAffected()
+}
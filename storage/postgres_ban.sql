@@ -0,0 +1,8 @@
+insert into bans
+    ( banid
+    , expireat )
+values
+    ( $1
+    , $2 )
+on conflict (banid) do update set
+    expireat = excluded.expireat;
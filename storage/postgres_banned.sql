@@ -0,0 +1,6 @@
+select count(*)
+from
+    bans
+where
+    banid = $1
+    and (expireat is null or expireat >= $2);
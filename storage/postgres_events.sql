@@ -0,0 +1,11 @@
+select eventid
+    , eventtype
+    , eventcreatedat
+    , eventheaders
+    , eventdata
+    , eventsequence
+from
+    events
+order by
+    eventcreatedat asc
+    , eventseq asc;
@@ -0,0 +1,25 @@
+select eventid
+    , eventtype
+    , eventcreatedat
+    , eventheaders
+    , eventdata
+    , eventsequence
+from
+    events
+where
+    eventtype = $1
+    and (
+        $2 = ''
+        or (eventsequence, eventseq) < (
+            select eventsequence
+                , eventseq
+            from
+                events
+            where
+                eventid = $2
+        )
+    )
+order by
+    eventsequence desc
+    , eventseq desc
+limit $3;
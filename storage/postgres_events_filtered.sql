@@ -0,0 +1,18 @@
+select eventid
+    , eventtype
+    , eventcreatedat
+    , eventheaders
+    , eventdata
+    , eventsequence
+from
+    events
+where
+    eventcreatedat >= $1
+    and eventcreatedat < $2
+    and (
+        cardinality($3::text[]) = 0
+        or eventtype = any($3::text[])
+    )
+order by
+    eventcreatedat asc
+    , eventseq asc;
@@ -0,0 +1,4 @@
+select greatest(
+    coalesce((select max(eventsequence) from events), 0)
+    , coalesce((select sequence from events_sequence_mark), 0)
+);
@@ -0,0 +1,11 @@
+create table if not exists events(
+    eventseq bigserial not null,
+    eventid text primary key,
+    eventtype text not null,
+    eventcreatedat bigint not null,
+    eventheaders jsonb not null,
+    eventdata bytea not null
+);
+
+create index if not exists events_createdat_idx
+    on events (eventcreatedat, eventseq);
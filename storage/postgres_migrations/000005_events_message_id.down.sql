@@ -0,0 +1,2 @@
+drop index if exists events_message_id_idx;
+drop function if exists event_message_id;
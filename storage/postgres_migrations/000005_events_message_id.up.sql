@@ -0,0 +1,7 @@
+-- Edits and deletions are looked up by ID of message they refer to.
+create or replace function event_message_id(data bytea) returns text
+    language sql immutable
+    as $$ select convert_from(data, 'UTF8')::jsonb ->> 'messageId' $$;
+
+create index if not exists events_message_id_idx
+    on events (event_message_id(eventdata));
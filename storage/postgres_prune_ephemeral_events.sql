@@ -0,0 +1,4 @@
+delete from events
+where
+    eventcreatedat < $1
+    and eventtype in ($2, $3, $4);
@@ -0,0 +1,3 @@
+delete from events
+where
+    eventcreatedat < $1;
@@ -0,0 +1,8 @@
+insert into revoked_sessions
+    ( sessionid
+    , expireat )
+values
+    ( $1
+    , $2 )
+on conflict (sessionid) do update set
+    expireat = greatest(revoked_sessions.expireat, excluded.expireat);
@@ -0,0 +1,58 @@
+select events.eventid
+    , events.eventtype
+    , events.eventcreatedat
+    , events.eventheaders
+    , coalesce(edit.data, events.eventdata)
+    , events.eventsequence
+from
+    events
+    cross join lateral (
+        select convert_from(events.eventdata, 'UTF8')::jsonb as data
+    ) as message
+    left join lateral (
+        select edited.data ->> 'content' as content
+            , convert_to(
+                (
+                    message.data
+                    || jsonb_build_object(
+                        'content', edited.data -> 'content'
+                        , 'html', coalesce(edited.data -> 'html', 'null')
+                        , 'mentions', coalesce(edited.data -> 'mentions', 'null')
+                    )
+                )::text
+                , 'UTF8'
+            ) as data
+        from
+            events as edits
+            cross join lateral (
+                select convert_from(edits.eventdata, 'UTF8')::jsonb as data
+            ) as edited
+        where
+            edits.eventtype = $4
+            and event_message_id(edits.eventdata) = events.eventid
+        order by
+            edits.eventsequence desc
+            , edits.eventseq desc
+        limit 1
+    ) as edit on true
+where
+    events.eventtype = $1
+    and (
+        message.data -> 'to' is null
+        or message.data -> 'from' ->> 'id' = $3
+        or message.data -> 'to' ->> 'id' = $3
+    )
+    and not exists (
+        select 1
+        from
+            events as deletes
+        where
+            deletes.eventtype = $5
+            and event_message_id(deletes.eventdata) = events.eventid
+    )
+    and to_tsvector('simple', coalesce(edit.content, message.data ->> 'content'))
+        @@ plainto_tsquery('simple', $2)
+order by
+    events.eventcreatedat desc
+    , events.eventseq desc
+limit $6;
@@ -0,0 +1,14 @@
+insert into events
+    ( eventid
+    , eventtype
+    , eventcreatedat
+    , eventheaders
+    , eventdata
+    , eventsequence )
+values
+    ( $1
+    , $2
+    , $3
+    , $4
+    , $5
+    , $6 );
@@ -0,0 +1,310 @@
+//go:build postgres
+
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+
+	"github.com/fenole/szmaterlok/service"
+)
+
+// testPostgresDSNVarName is env variable with connection string to
+// postgres database used by integration tests. Tests clean up events
+// table, so it shouldn't point to production database.
+const testPostgresDSNVarName = "S8K_TEST_POSTGRES"
+
+// testPostgresStorage returns postgres storage with empty tables.
+func testPostgresStorage(t *testing.T) *PostgresStorage {
+	t.Helper()
+
+	dsn := os.Getenv(testPostgresDSNVarName)
+	if dsn == "" {
+		t.Skipf("%s is not set", testPostgresDSNVarName)
+	}
+
+	ctx := context.Background()
+	s, err := NewPostgresStorage(ctx, dsn)
+	if err != nil {
+		t.Fatalf("failed to open storage: %s", err)
+	}
+
+	clean := func() {
+		if _, err := s.db.ExecContext(ctx, `truncate events, revoked_sessions, events_sequence_mark;`); err != nil {
+			t.Fatalf("failed to clean tables: %s", err)
+		}
+	}
+	clean()
+	t.Cleanup(func() {
+		clean()
+		s.db.Close()
+	})
+
+	return s
+}
+
+func TestPostgresStorageEvents(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+	s := testPostgresStorage(t)
+
+	want := []service.BridgeEvent{
+		testEvent(t, service.BridgeMessageSent, "1", 100, service.EventSentMessage{ID: "1", Content: "first"}),
+		testEvent(t, service.BridgeUserJoin, "2", 101, service.EventUserJoin{ID: "2"}),
+		testEvent(t, service.BridgeMessageSent, "3", 101, service.EventSentMessage{ID: "3", Content: "second"}),
+	}
+	for _, evt := range want {
+		is.NoErr(s.StoreEvent(ctx, evt))
+	}
+
+	c := make(chan service.BridgeEvent, len(want))
+	is.NoErr(s.Events(ctx, c))
+	close(c)
+
+	got := []service.BridgeEvent{}
+	for evt := range c {
+		got = append(got, evt)
+	}
+	is.Equal(got, want)
+}
+
+func TestPostgresStorageMessagesBefore(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+	s := testPostgresStorage(t)
+
+	for i, createdAt := range []int64{100, 101, 101, 102} {
+		id := strconv.Itoa(i + 1)
+		is.NoErr(s.StoreEvent(ctx, testEvent(t, service.BridgeMessageSent, id, createdAt, service.EventSentMessage{
+			ID:      id,
+			Content: "message " + id,
+		})))
+	}
+
+	ids := func(evts []service.BridgeEvent) []string {
+		res := []string{}
+		for _, evt := range evts {
+			res = append(res, evt.ID)
+		}
+		return res
+	}
+
+	latest, err := s.MessagesBefore(ctx, "", service.ChatChannelDefault, "", 2)
+	is.NoErr(err)
+	is.Equal(ids(latest), []string{"4", "3"})
+
+	older, err := s.MessagesBefore(ctx, "", service.ChatChannelDefault, "3", 10)
+	is.NoErr(err)
+	is.Equal(ids(older), []string{"2", "1"})
+
+	// Deleted messages are skipped and edited ones have content of
+	// their latest edit.
+	is.NoErr(s.StoreEvent(ctx, testEvent(t, service.BridgeMessageEdited, "edit", 103, service.EventMessageEdited{
+		ID:        "edit",
+		MessageID: "2",
+		Content:   "edited",
+	})))
+	is.NoErr(s.StoreEvent(ctx, testEvent(t, service.BridgeMessageDeleted, "delete", 104, service.EventMessageDeleted{
+		ID:        "delete",
+		MessageID: "3",
+	})))
+
+	got, err := s.MessagesBefore(ctx, "", service.ChatChannelDefault, "", 10)
+	is.NoErr(err)
+	is.Equal(ids(got), []string{"4", "2", "1"})
+
+	msg := service.EventSentMessage{}
+	is.NoErr(json.Unmarshal(got[1].Data, &msg))
+	is.Equal(msg.Content, "edited")
+
+	// Only messages of requested channel and direct messages of given
+	// user are returned, before the limit is applied.
+	janek := service.ChatUser{ID: "janek"}
+	for _, m := range []service.EventSentMessage{
+		{ID: "random", Channel: "random"},
+		{ID: "dm", Channel: "random", From: service.ChatUser{ID: "karol"}, To: &janek},
+		{ID: "own", Channel: "random", From: janek},
+	} {
+		is.NoErr(s.StoreEvent(ctx, testEvent(t, service.BridgeMessageSent, m.ID, 105, m)))
+	}
+
+	got, err = s.MessagesBefore(ctx, "janek", service.ChatChannelDefault, "", 3)
+	is.NoErr(err)
+	is.Equal(ids(got), []string{"dm", "4", "2"})
+
+	got, err = s.MessagesBefore(ctx, "karol", "random", "", 10)
+	is.NoErr(err)
+	is.Equal(ids(got), []string{"own", "dm", "random"})
+}
+
+func TestPostgresStorageReserveSequence(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+	s := testPostgresStorage(t)
+
+	evt := testEvent(t, service.BridgeMessageSent, "1", 100, service.EventSentMessage{ID: "1"})
+	evt.Sequence = 5
+	is.NoErr(s.StoreEvent(ctx, evt))
+
+	// Reserved sequence number is never moved back.
+	is.NoErr(s.ReserveSequence(ctx, 1000))
+	is.NoErr(s.ReserveSequence(ctx, 10))
+	last, err := s.LastSequence(ctx)
+	is.NoErr(err)
+	is.Equal(last, uint64(1000))
+
+	_, err = s.PruneBefore(ctx, time.Unix(200, 0))
+	is.NoErr(err)
+	last, err = s.LastSequence(ctx)
+	is.NoErr(err)
+	is.Equal(last, uint64(1000))
+}
+
+func TestPostgresStorageSearchMessages(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+	s := testPostgresStorage(t)
+
+	for i, content := range []string{"hello world", "goodbye world", "hello there"} {
+		id := strconv.Itoa(i + 1)
+		is.NoErr(s.StoreEvent(ctx, testEvent(t, service.BridgeMessageSent, id, int64(100+i), service.EventSentMessage{
+			ID:      id,
+			Content: content,
+		})))
+	}
+
+	janek := service.ChatUser{ID: "janek"}
+	is.NoErr(s.StoreEvent(ctx, testEvent(t, service.BridgeMessageSent, "dm", 200, service.EventSentMessage{
+		ID:      "dm",
+		From:    service.ChatUser{ID: "karol"},
+		To:      &janek,
+		Content: "hello there",
+	})))
+	is.NoErr(s.StoreEvent(ctx, testEvent(t, service.BridgeMessageEdited, "edit", 201, service.EventMessageEdited{
+		ID:        "edit",
+		MessageID: "2",
+		Content:   "hello again",
+	})))
+	is.NoErr(s.StoreEvent(ctx, testEvent(t, service.BridgeMessageDeleted, "delete", 202, service.EventMessageDeleted{
+		ID:        "delete",
+		MessageID: "3",
+	})))
+
+	got, err := s.SearchMessages(ctx, "", "hello world", 10)
+	is.NoErr(err)
+	is.Equal(len(got), 1)
+	is.Equal(got[0].ID, "1")
+
+	// Direct messages are found only by their author and recipient,
+	// edited messages by their current content and deleted messages
+	// aren't found at all.
+	got, err = s.SearchMessages(ctx, "", "hello", 10)
+	is.NoErr(err)
+	is.Equal(len(got), 2)
+	is.Equal(got[0].ID, "2")
+	is.Equal(got[1].ID, "1")
+
+	got, err = s.SearchMessages(ctx, "janek", "there", 10)
+	is.NoErr(err)
+	is.Equal(len(got), 1)
+	is.Equal(got[0].ID, "dm")
+
+	got, err = s.SearchMessages(ctx, "", "  ", 10)
+	is.NoErr(err)
+	is.Equal(len(got), 0)
+}
+
+func TestPostgresStorageRevokeSession(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+	s := testPostgresStorage(t)
+
+	now := time.Unix(1000, 0)
+	s.now = func() time.Time { return now }
+
+	is.NoErr(s.RevokeSession(ctx, "session", now.Add(time.Minute)))
+	revoked, err := s.SessionRevoked(ctx, "session")
+	is.NoErr(err)
+	is.True(revoked)
+
+	now = now.Add(time.Hour)
+	revoked, err = s.SessionRevoked(ctx, "session")
+	is.NoErr(err)
+	is.True(!revoked)
+}
+
+func TestPostgresStorageBan(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+	s := testPostgresStorage(t)
+
+	now := time.Unix(1000, 0)
+	s.now = func() time.Time { return now }
+
+	is.NoErr(s.Ban(ctx, "temporary", now.Add(time.Minute)))
+	is.NoErr(s.Ban(ctx, "permanent", time.Time{}))
+
+	now = now.Add(time.Hour)
+	banned, err := s.Banned(ctx, "temporary")
+	is.NoErr(err)
+	is.True(!banned)
+	banned, err = s.Banned(ctx, "permanent")
+	is.NoErr(err)
+	is.True(banned)
+
+	is.NoErr(s.Unban(ctx, "permanent"))
+	banned, err = s.Banned(ctx, "permanent")
+	is.NoErr(err)
+	is.True(!banned)
+}
+
+func TestPostgresStoragePrune(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+	s := testPostgresStorage(t)
+
+	is.NoErr(s.StoreEvent(ctx, testEvent(t, service.BridgeMessageSent, "old-msg", 100, service.EventSentMessage{ID: "old-msg"})))
+	is.NoErr(s.StoreEvent(ctx, testEvent(t, service.BridgeUserJoin, "old-join", 150, service.EventUserJoin{ID: "old-join"})))
+	is.NoErr(s.StoreEvent(ctx, testEvent(t, service.BridgeUserLeft, "new-left", 250, service.EventUserLeft{ID: "new-left"})))
+
+	n, err := s.PruneEphemeralBefore(ctx, time.Unix(200, 0))
+	is.NoErr(err)
+	is.Equal(n, int64(1))
+
+	n, err = s.PruneBefore(ctx, time.Unix(200, 0))
+	is.NoErr(err)
+	is.Equal(n, int64(1))
+}
+
+func TestPostgresStorageEventsFiltered(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+	s := testPostgresStorage(t)
+
+	is.NoErr(s.StoreEvents(ctx, []service.BridgeEvent{
+		testEvent(t, service.BridgeUserJoin, "join", 100, service.EventUserJoin{ID: "join"}),
+		testEvent(t, service.BridgeMessageSent, "msg1", 100, service.EventSentMessage{ID: "msg1"}),
+		testEvent(t, service.BridgeMessageSent, "msg2", 200, service.EventSentMessage{ID: "msg2"}),
+		testEvent(t, service.BridgeMessageSent, "msg3", 300, service.EventSentMessage{ID: "msg3"}),
+	}))
+
+	c := make(chan service.BridgeEvent, 4)
+	is.NoErr(s.EventsFiltered(ctx, service.EventFilter{
+		Types: []service.BridgeEventType{service.BridgeMessageSent},
+		Since: time.Unix(100, 0),
+		Until: time.Unix(300, 0),
+	}, c))
+	close(c)
+
+	got := []string{}
+	for evt := range c {
+		got = append(got, evt.ID)
+	}
+	is.Equal(got, []string{"msg1", "msg2"})
+}
@@ -4,8 +4,12 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"math"
+	"strings"
 	"sync"
+	"time"
 
 	"golang.org/x/exp/slices"
 
@@ -19,6 +23,10 @@ import (
 type SQLiteStorage struct {
 	mtx *sync.Mutex
 	db  *sql.DB
+
+	// now returns current time. It is used for expiration of
+	// revoked sessions.
+	now func() time.Time
 }
 
 // NewSQLiteStorage opens and migrates storage from given path.
@@ -28,6 +36,12 @@ func NewSQLiteStorage(ctx context.Context, path string) (*SQLiteStorage, error)
 		return nil, fmt.Errorf("failed to open sqlite db: %w", err)
 	}
 
+	// Every connection to in-memory database opens new database, so
+	// there must be only one of them.
+	if path == ":memory:" {
+		db.SetMaxOpenConns(1)
+	}
+
 	if err := migrateSQLite(db); err != nil {
 		return nil, fmt.Errorf("migration failed: %w", err)
 	}
@@ -39,6 +53,7 @@ func NewSQLiteStorage(ctx context.Context, path string) (*SQLiteStorage, error)
 	return &SQLiteStorage{
 		db:  db,
 		mtx: &sync.Mutex{},
+		now: time.Now,
 	}, nil
 }
 
@@ -63,6 +78,7 @@ func (s *SQLiteStorage) StoreEvent(ctx context.Context, evt service.BridgeEvent)
 		sql.Named("headers", headers),
 		sql.Named("createdat", evt.CreatedAt),
 		sql.Named("data", evt.Data),
+		sql.Named("sequence", int64(evt.Sequence)),
 	)
 	if err != nil {
 		return fmt.Errorf("failed to store event: %w", err)
@@ -71,57 +87,752 @@ func (s *SQLiteStorage) StoreEvent(ctx context.Context, evt service.BridgeEvent)
 	return nil
 }
 
+// StoreEvents stores given bridge events in sqlite event storage within
+// single transaction. Either all of the events are stored or none.
+func (s *SQLiteStorage) StoreEvents(ctx context.Context, evts []service.BridgeEvent) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, storeEventQuery)
+	if err != nil {
+		return fmt.Errorf("failed to prepare statement: %w", err)
+	}
+	defer stmt.Close()
+
+	for _, evt := range evts {
+		headers, err := json.Marshal(evt.Headers)
+		if err != nil {
+			return fmt.Errorf("failed to encode headers as json: %w", err)
+		}
+
+		if _, err := stmt.ExecContext(
+			ctx,
+			sql.Named("id", evt.ID),
+			sql.Named("type", evt.Name),
+			sql.Named("headers", headers),
+			sql.Named("createdat", evt.CreatedAt),
+			sql.Named("data", evt.Data),
+			sql.Named("sequence", int64(evt.Sequence)),
+		); err != nil {
+			return fmt.Errorf("failed to store event %s: %w", evt.ID, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
 //go:embed sqlite_events.sql
 var eventsQuery string
 
+// eventsBatchSize is number of events read by Events at once.
+const eventsBatchSize = 500
+
 // Events sends all events from state archive through given channels
-// grouped by their creation date.
+// grouped by their creation date. Events are read in batches and
+// storage isn't locked while they're sent, so slow reader doesn't
+// block storing of new events.
 func (s *SQLiteStorage) Events(ctx context.Context, c chan<- service.BridgeEvent) error {
+	createdAt, rowID := int64(math.MinInt64), int64(0)
+	for {
+		evts, lastCreatedAt, lastRowID, err := s.eventsBatch(ctx, createdAt, rowID)
+		if err != nil {
+			return err
+		}
+
+		for _, evt := range evts {
+			c <- evt
+		}
+
+		if len(evts) < eventsBatchSize {
+			return nil
+		}
+		createdAt, rowID = lastCreatedAt, lastRowID
+	}
+}
+
+// eventsBatch returns next batch of events, which are stored after
+// event with given creation date and row ID. It also returns creation
+// date and row ID of the last returned event.
+func (s *SQLiteStorage) eventsBatch(ctx context.Context, createdAt, rowID int64) ([]service.BridgeEvent, int64, int64, error) {
 	s.mtx.Lock()
 	defer s.mtx.Unlock()
 
-	rows, err := s.db.QueryContext(ctx, eventsQuery)
+	rows, err := s.db.QueryContext(
+		ctx,
+		eventsQuery,
+		sql.Named("createdat", createdAt),
+		sql.Named("rowid", rowID),
+		sql.Named("limit", eventsBatchSize),
+	)
+	if err != nil {
+		return nil, 0, 0, fmt.Errorf("failed to create query: %w", err)
+	}
+	defer rows.Close()
+
+	res := make([]service.BridgeEvent, 0, eventsBatchSize)
+	for rows.Next() {
+		evt, err := scanEvent(rows, &rowID)
+		if err != nil {
+			return nil, 0, 0, err
+		}
+
+		res = append(res, evt)
+		createdAt = evt.CreatedAt
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, 0, 0, fmt.Errorf("rows iteration failure: %w", err)
+	}
+
+	return res, createdAt, rowID, nil
+}
+
+//go:embed sqlite_events_filtered.sql
+var eventsFilteredQuery string
+
+// EventsFiltered sends events selected by given filter through given
+// channel grouped by their creation date.
+func (s *SQLiteStorage) EventsFiltered(ctx context.Context, filter service.EventFilter, c chan<- service.BridgeEvent) error {
+	since, until := filterRange(filter)
+	types, err := json.Marshal(filterTypes(filter))
+	if err != nil {
+		return fmt.Errorf("failed to encode event types: %w", err)
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	rows, err := s.db.QueryContext(
+		ctx,
+		eventsFilteredQuery,
+		sql.Named("since", since),
+		sql.Named("until", until),
+		sql.Named("alltypes", len(filter.Types) == 0),
+		sql.Named("types", string(types)),
+	)
 	if err != nil {
 		return fmt.Errorf("failed to create query: %w", err)
 	}
 	defer rows.Close()
 
+	for rows.Next() {
+		evt, err := scanEvent(rows)
+		if err != nil {
+			return err
+		}
+
+		c <- evt
+	}
+
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("rows iteration failure: %w", err)
+	}
+
+	return nil
+}
+
+// filterRange returns range of event creation dates selected by given
+// filter. Bounds are always set, so queries can use index on creation
+// date.
+func filterRange(filter service.EventFilter) (since, until int64) {
+	since, until = math.MinInt64, math.MaxInt64
+	if !filter.Since.IsZero() {
+		since = filter.Since.Unix()
+	}
+	if !filter.Until.IsZero() {
+		until = filter.Until.Unix()
+	}
+
+	return since, until
+}
+
+// filterTypes returns event types selected by given filter as strings.
+func filterTypes(filter service.EventFilter) []string {
+	res := make([]string, 0, len(filter.Types))
+	for _, t := range filter.Types {
+		res = append(res, string(t))
+	}
+
+	return res
+}
+
+// scanEvents scans all bridge events from given rows and closes them.
+func scanEvents(rows *sql.Rows) ([]service.BridgeEvent, error) {
+	defer rows.Close()
+
+	res := []service.BridgeEvent{}
+	for rows.Next() {
+		evt, err := scanEvent(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		res = append(res, evt)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows iteration failure: %w", err)
+	}
+
+	return res, nil
+}
+
+// scanEvent scans single bridge event from current row. Columns
+// following event columns are scanned into given extra destinations.
+func scanEvent(rows *sql.Rows, extra ...any) (service.BridgeEvent, error) {
 	var rawEvent struct {
 		name      string
 		id        string
 		headers   []byte
 		data      []byte
 		createdAt int64
+		sequence  int64
 	}
 
-	for rows.Next() {
-		if err := rows.Scan(
-			&rawEvent.id,
-			&rawEvent.name,
-			&rawEvent.createdAt,
-			&rawEvent.headers,
-			&rawEvent.data,
-		); err != nil {
-			return fmt.Errorf("failed to scan event: %w", err)
-		}
+	dest := append([]any{
+		&rawEvent.id,
+		&rawEvent.name,
+		&rawEvent.createdAt,
+		&rawEvent.headers,
+		&rawEvent.data,
+		&rawEvent.sequence,
+	}, extra...)
+	if err := rows.Scan(dest...); err != nil {
+		return service.BridgeEvent{}, fmt.Errorf("failed to scan event: %w", err)
+	}
 
-		headers := service.BridgeHeaders{}
-		if err := json.Unmarshal(rawEvent.headers, &headers); err != nil {
-			return fmt.Errorf("failed to parse event headers: %w", err)
-		}
+	headers := service.BridgeHeaders{}
+	if err := json.Unmarshal(rawEvent.headers, &headers); err != nil {
+		return service.BridgeEvent{}, fmt.Errorf("failed to parse event headers: %w", err)
+	}
 
-		c <- service.BridgeEvent{
-			Name:      service.BridgeEventType(rawEvent.name),
-			ID:        rawEvent.id,
-			Headers:   headers,
-			CreatedAt: rawEvent.createdAt,
-			Data:      slices.Clone(rawEvent.data),
-		}
+	return service.BridgeEvent{
+		Name:      service.BridgeEventType(rawEvent.name),
+		ID:        rawEvent.id,
+		Sequence:  uint64(rawEvent.sequence),
+		Headers:   headers,
+		CreatedAt: rawEvent.createdAt,
+		Data:      slices.Clone(rawEvent.data),
+	}, nil
+}
+
+//go:embed sqlite_events_before.sql
+var eventsBeforeQuery string
+
+//go:embed sqlite_messages_before.sql
+var messagesBeforeQuery string
+
+// MessagesBefore returns at most limit of message-sent events from given
+// chat channel and direct messages of user with given ID, which were
+// stored before event with given ID, in reverse order of their sequence
+// numbers. Empty before ID means that the newest messages are returned.
+// Deleted messages are skipped and edited ones have their current content.
+func (s *SQLiteStorage) MessagesBefore(ctx context.Context, userID, channel, before string, limit int) ([]service.BridgeEvent, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	rows, err := s.db.QueryContext(
+		ctx,
+		messagesBeforeQuery,
+		sql.Named("type", service.BridgeMessageSent),
+		sql.Named("edited", service.BridgeMessageEdited),
+		sql.Named("deleted", service.BridgeMessageDeleted),
+		sql.Named("before", before),
+		sql.Named("limit", limit),
+		sql.Named("userid", userID),
+		sql.Named("channel", channel),
+		sql.Named("defaultchannel", service.ChatChannelDefault),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create query: %w", err)
 	}
 
-	if err := rows.Err(); err != nil {
-		return fmt.Errorf("rows iteration failure: %w", err)
+	return scanEvents(rows)
+}
+
+// ModerationActionsBefore returns at most limit of moderation-action
+// events, which were stored before event with given ID, in reverse order
+// of their sequence numbers. Empty before ID means that the newest
+// actions are returned.
+func (s *SQLiteStorage) ModerationActionsBefore(ctx context.Context, before string, limit int) ([]service.BridgeEvent, error) {
+	return s.eventsBefore(ctx, service.BridgeModerationAction, before, limit)
+}
+
+// eventsBefore returns at most limit of events of given type, which were
+// stored before event with given ID, in reverse order of their sequence
+// numbers.
+func (s *SQLiteStorage) eventsBefore(ctx context.Context, t service.BridgeEventType, before string, limit int) ([]service.BridgeEvent, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	rows, err := s.db.QueryContext(
+		ctx,
+		eventsBeforeQuery,
+		sql.Named("type", t),
+		sql.Named("before", before),
+		sql.Named("limit", limit),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create query: %w", err)
+	}
+
+	return scanEvents(rows)
+}
+
+//go:embed sqlite_last_sequence.sql
+var lastSequenceQuery string
+
+// LastSequence returns the greatest sequence number of stored events
+// or reserved by event bridge. It's zero when there are neither.
+func (s *SQLiteStorage) LastSequence(ctx context.Context) (uint64, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	var res int64
+	if err := s.db.QueryRowContext(ctx, lastSequenceQuery).Scan(&res); err != nil {
+		return 0, fmt.Errorf("failed to read last sequence number: %w", err)
+	}
+
+	return uint64(res), nil
+}
+
+//go:embed sqlite_reserve_sequence.sql
+var reserveSequenceQuery string
+
+// ReserveSequence stores given sequence number as the highest one,
+// which event bridge could have assigned. Lower numbers than already
+// reserved one are ignored.
+func (s *SQLiteStorage) ReserveSequence(ctx context.Context, sequence uint64) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if _, err := s.db.ExecContext(
+		ctx,
+		reserveSequenceQuery,
+		sql.Named("sequence", int64(sequence)),
+	); err != nil {
+		return fmt.Errorf("failed to reserve sequence number: %w", err)
+	}
+
+	return nil
+}
+
+//go:embed sqlite_search_messages.sql
+var searchMessagesQuery string
+
+// SearchMessages returns at most limit of message-sent events, which
+// current content matches all words from given query, in reverse
+// chronological order. Deleted messages and direct messages, which
+// user with given ID neither sent nor received, are skipped. Edited
+// messages have their current content.
+func (s *SQLiteStorage) SearchMessages(ctx context.Context, userID, query string, limit int) ([]service.BridgeEvent, error) {
+	match := searchMatchExpression(query)
+	if match == "" {
+		return []service.BridgeEvent{}, nil
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	rows, err := s.db.QueryContext(
+		ctx,
+		searchMessagesQuery,
+		sql.Named("query", match),
+		sql.Named("userid", userID),
+		sql.Named("edited", service.BridgeMessageEdited),
+		sql.Named("limit", limit),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create query: %w", err)
+	}
+
+	return scanEvents(rows)
+}
+
+// searchMatchExpression turns user query into FTS5 match expression.
+// Every word is quoted, so FTS5 syntax from user input is treated as
+// plain text.
+func searchMatchExpression(query string) string {
+	words := strings.Fields(query)
+	for i, word := range words {
+		words[i] = `"` + strings.ReplaceAll(word, `"`, `""`) + `"`
+	}
+
+	return strings.Join(words, " ")
+}
+
+// Ping verifies that connection to the database is still alive.
+func (s *SQLiteStorage) Ping(ctx context.Context) error {
+	if err := s.db.PingContext(ctx); err != nil {
+		return fmt.Errorf("failed to ping sqlite db: %w", err)
+	}
+
+	return nil
+}
+
+//go:embed sqlite_revoke_session.sql
+var revokeSessionQuery string
+
+//go:embed sqlite_collect_revoked_sessions.sql
+var collectRevokedSessionsQuery string
+
+// RevokeSession adds session with given ID to the revocation list
+// until given expiration date of the session. Later expiration date
+// of already revoked session is kept. Entries of already expired
+// sessions are garbage collected.
+func (s *SQLiteStorage) RevokeSession(ctx context.Context, id string, expireAt time.Time) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if _, err := s.db.ExecContext(
+		ctx,
+		collectRevokedSessionsQuery,
+		sql.Named("now", s.now().Unix()),
+	); err != nil {
+		return fmt.Errorf("failed to collect revoked sessions: %w", err)
+	}
+
+	if _, err := s.db.ExecContext(
+		ctx,
+		revokeSessionQuery,
+		sql.Named("id", id),
+		sql.Named("expireat", expireAt.Unix()),
+	); err != nil {
+		return fmt.Errorf("failed to revoke session: %w", err)
 	}
 
 	return nil
 }
+
+//go:embed sqlite_session_revoked.sql
+var sessionRevokedQuery string
+
+// SessionRevoked reports whether session with given ID is revoked.
+func (s *SQLiteStorage) SessionRevoked(ctx context.Context, id string) (bool, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	var count int
+	if err := s.db.QueryRowContext(
+		ctx,
+		sessionRevokedQuery,
+		sql.Named("id", id),
+		sql.Named("now", s.now().Unix()),
+	).Scan(&count); err != nil {
+		return false, fmt.Errorf("failed to check session revocation: %w", err)
+	}
+
+	return count > 0, nil
+}
+
+//go:embed sqlite_ban.sql
+var banQuery string
+
+//go:embed sqlite_collect_bans.sql
+var collectBansQuery string
+
+// Ban bans given user ID or nickname until given expiration date.
+// Zero expiration date means permanent ban. Expired bans are garbage
+// collected.
+func (s *SQLiteStorage) Ban(ctx context.Context, id string, expireAt time.Time) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if _, err := s.db.ExecContext(
+		ctx,
+		collectBansQuery,
+		sql.Named("now", s.now().Unix()),
+	); err != nil {
+		return fmt.Errorf("failed to collect bans: %w", err)
+	}
+
+	if _, err := s.db.ExecContext(
+		ctx,
+		banQuery,
+		sql.Named("id", id),
+		sql.Named("expireat", banExpireAt(expireAt)),
+	); err != nil {
+		return fmt.Errorf("failed to ban: %w", err)
+	}
+
+	return nil
+}
+
+//go:embed sqlite_unban.sql
+var unbanQuery string
+
+// Unban lifts ban of given user ID or nickname.
+func (s *SQLiteStorage) Unban(ctx context.Context, id string) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if _, err := s.db.ExecContext(
+		ctx,
+		unbanQuery,
+		sql.Named("id", id),
+	); err != nil {
+		return fmt.Errorf("failed to unban: %w", err)
+	}
+
+	return nil
+}
+
+//go:embed sqlite_banned.sql
+var bannedQuery string
+
+// Banned reports whether given user ID or nickname is banned.
+func (s *SQLiteStorage) Banned(ctx context.Context, id string) (bool, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	var count int
+	if err := s.db.QueryRowContext(
+		ctx,
+		bannedQuery,
+		sql.Named("id", id),
+		sql.Named("now", s.now().Unix()),
+	).Scan(&count); err != nil {
+		return false, fmt.Errorf("failed to check ban: %w", err)
+	}
+
+	return count > 0, nil
+}
+
+//go:embed sqlite_mute.sql
+var muteQuery string
+
+//go:embed sqlite_collect_mutes.sql
+var collectMutesQuery string
+
+// Mute mutes given user ID until given expiration date. Expired mutes
+// are garbage collected.
+func (s *SQLiteStorage) Mute(ctx context.Context, id string, expireAt time.Time) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if _, err := s.db.ExecContext(
+		ctx,
+		collectMutesQuery,
+		sql.Named("now", s.now().Unix()),
+	); err != nil {
+		return fmt.Errorf("failed to collect mutes: %w", err)
+	}
+
+	if _, err := s.db.ExecContext(
+		ctx,
+		muteQuery,
+		sql.Named("id", id),
+		sql.Named("expireat", expireAt.Unix()),
+	); err != nil {
+		return fmt.Errorf("failed to mute: %w", err)
+	}
+
+	return nil
+}
+
+//go:embed sqlite_unmute.sql
+var unmuteQuery string
+
+// Unmute lifts mute of given user ID.
+func (s *SQLiteStorage) Unmute(ctx context.Context, id string) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if _, err := s.db.ExecContext(
+		ctx,
+		unmuteQuery,
+		sql.Named("id", id),
+	); err != nil {
+		return fmt.Errorf("failed to unmute: %w", err)
+	}
+
+	return nil
+}
+
+//go:embed sqlite_muted.sql
+var mutedQuery string
+
+// Muted returns expiration date of mute of given user ID.
+func (s *SQLiteStorage) Muted(ctx context.Context, id string) (time.Time, bool, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	var expireAt int64
+	err := s.db.QueryRowContext(
+		ctx,
+		mutedQuery,
+		sql.Named("id", id),
+		sql.Named("now", s.now().Unix()),
+	).Scan(&expireAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return time.Time{}, false, nil
+	}
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("failed to check mute: %w", err)
+	}
+
+	return time.Unix(expireAt, 0), true, nil
+}
+
+//go:embed sqlite_store_session_token.sql
+var storeSessionTokenQuery string
+
+//go:embed sqlite_collect_session_tokens.sql
+var collectSessionTokensQuery string
+
+// StoreSessionToken stores session state under given token. Tokens
+// of already expired session states are garbage collected.
+func (s *SQLiteStorage) StoreSessionToken(ctx context.Context, token string, state service.SessionState) error {
+	b, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("failed to encode session state: %w", err)
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if _, err := s.db.ExecContext(
+		ctx,
+		collectSessionTokensQuery,
+		sql.Named("now", s.now().Unix()),
+	); err != nil {
+		return fmt.Errorf("failed to collect session tokens: %w", err)
+	}
+
+	if _, err := s.db.ExecContext(
+		ctx,
+		storeSessionTokenQuery,
+		sql.Named("token", token),
+		sql.Named("state", string(b)),
+		sql.Named("expireat", state.ExpireAt.Unix()),
+	); err != nil {
+		return fmt.Errorf("failed to store session token: %w", err)
+	}
+
+	return nil
+}
+
+//go:embed sqlite_session_token.sql
+var sessionTokenQuery string
+
+// SessionToken returns session state stored under given token. It
+// returns service.ErrMissingSessionToken if there is no such token.
+func (s *SQLiteStorage) SessionToken(ctx context.Context, token string) (*service.SessionState, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	var data string
+	err := s.db.QueryRowContext(
+		ctx,
+		sessionTokenQuery,
+		sql.Named("token", token),
+	).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, service.ErrMissingSessionToken
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query session token: %w", err)
+	}
+
+	state := &service.SessionState{}
+	if err := json.Unmarshal([]byte(data), state); err != nil {
+		return nil, fmt.Errorf("failed to decode session state: %w", err)
+	}
+
+	return state, nil
+}
+
+//go:embed sqlite_delete_session_token.sql
+var deleteSessionTokenQuery string
+
+// DeleteSessionToken removes given token from storage.
+func (s *SQLiteStorage) DeleteSessionToken(ctx context.Context, token string) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if _, err := s.db.ExecContext(
+		ctx,
+		deleteSessionTokenQuery,
+		sql.Named("token", token),
+	); err != nil {
+		return fmt.Errorf("failed to delete session token: %w", err)
+	}
+
+	return nil
+}
+
+//go:embed sqlite_delete_user_session_tokens.sql
+var deleteUserSessionTokensQuery string
+
+// DeleteUserSessionTokens removes all tokens of session states with
+// given user ID from storage.
+func (s *SQLiteStorage) DeleteUserSessionTokens(ctx context.Context, userID string) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if _, err := s.db.ExecContext(
+		ctx,
+		deleteUserSessionTokensQuery,
+		sql.Named("userid", userID),
+	); err != nil {
+		return fmt.Errorf("failed to delete user session tokens: %w", err)
+	}
+
+	return nil
+}
+
+//go:embed sqlite_prune_events.sql
+var pruneEventsQuery string
+
+// PruneBefore deletes all events created before given time. It returns
+// number of deleted events.
+func (s *SQLiteStorage) PruneBefore(ctx context.Context, t time.Time) (int64, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	res, err := s.db.ExecContext(
+		ctx,
+		pruneEventsQuery,
+		sql.Named("before", t.Unix()),
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to prune events: %w", err)
+	}
+
+	return res.RowsAffected()
+}
+
+//go:embed sqlite_prune_ephemeral_events.sql
+var pruneEphemeralEventsQuery string
+
+// PruneEphemeralBefore deletes ephemeral events (user presence and
+// typing) created before given time. It returns number of deleted
+// events.
+func (s *SQLiteStorage) PruneEphemeralBefore(ctx context.Context, t time.Time) (int64, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	res, err := s.db.ExecContext(
+		ctx,
+		pruneEphemeralEventsQuery,
+		sql.Named("before", t.Unix()),
+		sql.Named("userjoin", service.BridgeUserJoin),
+		sql.Named("userleft", service.BridgeUserLeft),
+		sql.Named("usertyping", service.BridgeUserTyping),
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to prune ephemeral events: %w", err)
+	}
+
+	return res.RowsAffected()
+}
@@ -0,0 +1,8 @@
+insert into bans
+    ( banid
+    , expireat )
+values
+    ( :id
+    , :expireat )
+on conflict (banid) do update set
+    expireat = excluded.expireat;
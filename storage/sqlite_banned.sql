@@ -0,0 +1,6 @@
+select count(*)
+from
+    bans
+where
+    banid = :id
+    and (expireat is null or expireat >= :now);
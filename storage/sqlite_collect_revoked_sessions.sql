@@ -0,0 +1,3 @@
+delete from revoked_sessions
+where
+    expireat < :now;
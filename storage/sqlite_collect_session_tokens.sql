@@ -0,0 +1,3 @@
+delete from session_tokens
+where
+    expireat < :now;
@@ -0,0 +1,3 @@
+delete from session_tokens
+where
+    json_extract(state, '$.id') = :userid;
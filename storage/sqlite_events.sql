@@ -3,8 +3,13 @@ select eventid
     , eventcreatedat
     , eventheaders
     , eventdata
+    , eventsequence
+    , rowid
 from
     events
+where
+    (eventcreatedat, rowid) > (:createdat, :rowid)
 order by
-    eventcreatedat
-asc;
+    eventcreatedat asc
+    , rowid asc
+limit :limit;
@@ -0,0 +1,25 @@
+select eventid
+    , eventtype
+    , eventcreatedat
+    , eventheaders
+    , eventdata
+    , eventsequence
+from
+    events
+where
+    eventtype = :type
+    and (
+        :before = ''
+        or (eventsequence, rowid) < (
+            select eventsequence
+                , rowid
+            from
+                events
+            where
+                eventid = :before
+        )
+    )
+order by
+    eventsequence desc
+    , rowid desc
+limit :limit;
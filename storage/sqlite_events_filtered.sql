@@ -0,0 +1,18 @@
+select eventid
+    , eventtype
+    , eventcreatedat
+    , eventheaders
+    , eventdata
+    , eventsequence
+from
+    events
+where
+    eventcreatedat >= :since
+    and eventcreatedat < :until
+    and (
+        :alltypes
+        or eventtype in (select value from json_each(:types))
+    )
+order by
+    eventcreatedat asc
+    , rowid asc;
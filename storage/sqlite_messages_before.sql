@@ -0,0 +1,67 @@
+select events.eventid
+    , events.eventtype
+    , events.eventcreatedat
+    , events.eventheaders
+    , coalesce(
+        (
+            select json_set(
+                events.eventdata
+                , '$.content', json_extract(edits.eventdata, '$.content')
+                , '$.html', json_extract(edits.eventdata, '$.html')
+                , '$.mentions', json_extract(edits.eventdata, '$.mentions')
+            )
+            from
+                events as edits
+            where
+                edits.eventtype = :edited
+                -- Unary plus drops affinity of the column, so index of
+                -- message IDs can be used.
+                and json_extract(edits.eventdata, '$.messageId') = +events.eventid
+            order by
+                edits.eventsequence desc
+                , edits.rowid desc
+            limit 1
+        )
+        , events.eventdata
+    )
+    , events.eventsequence
+from
+    events
+where
+    events.eventtype = :type
+    -- Messages of given channel and direct messages of given user,
+    -- from any channel.
+    and (
+        (
+            json_extract(events.eventdata, '$.to') is null
+            and coalesce(nullif(json_extract(events.eventdata, '$.channel'), ''), :defaultchannel) = :channel
+        )
+        or (
+            json_extract(events.eventdata, '$.to') is not null
+            and json_extract(events.eventdata, '$.from.id') = :userid
+        )
+        or json_extract(events.eventdata, '$.to.id') = :userid
+    )
+    and (
+        :before = ''
+        or (events.eventsequence, events.rowid) < (
+            select eventsequence
+                , rowid
+            from
+                events
+            where
+                eventid = :before
+        )
+    )
+    and not exists (
+        select 1
+        from
+            events as deletes
+        where
+            deletes.eventtype = :deleted
+            and json_extract(deletes.eventdata, '$.messageId') = +events.eventid
+    )
+order by
+    events.eventsequence desc
+    , events.rowid desc
+limit :limit;
@@ -0,0 +1,2 @@
+drop trigger if exists events_messages_search_insert;
+drop table if exists messages_search;
@@ -0,0 +1,26 @@
+create virtual table if not exists messages_search using fts5(
+    eventid unindexed,
+    content
+);
+
+create trigger if not exists events_messages_search_insert
+after insert on events
+when new.eventtype = 'message-sent'
+begin
+    insert into messages_search
+        ( eventid
+        , content )
+    values
+        ( new.eventid
+        , json_extract(new.eventdata, '$.content') );
+end;
+
+insert into messages_search
+    ( eventid
+    , content )
+select eventid
+    , json_extract(eventdata, '$.content')
+from
+    events
+where
+    eventtype = 'message-sent';
@@ -0,0 +1,4 @@
+create table if not exists revoked_sessions(
+    sessionid text primary key,
+    expireat int not null
+);
@@ -0,0 +1,2 @@
+drop index if exists events_createdat_idx;
+drop trigger if exists events_messages_search_delete;
@@ -0,0 +1,11 @@
+create trigger if not exists events_messages_search_delete
+after delete on events
+when old.eventtype = 'message-sent'
+begin
+    delete from messages_search
+    where
+        eventid = old.eventid;
+end;
+
+create index if not exists events_createdat_idx
+    on events (eventcreatedat);
@@ -0,0 +1,4 @@
+create table if not exists bans(
+    banid text primary key,
+    expireat int
+);
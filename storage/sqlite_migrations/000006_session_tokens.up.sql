@@ -0,0 +1,5 @@
+create table if not exists session_tokens(
+    token text primary key,
+    state text not null,
+    expireat int not null
+);
@@ -0,0 +1,4 @@
+create table if not exists mutes(
+    userid text primary key,
+    expireat int not null
+);
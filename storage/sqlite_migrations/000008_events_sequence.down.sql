@@ -0,0 +1,2 @@
+drop index if exists events_sequence_idx;
+alter table events drop column eventsequence;
@@ -0,0 +1,10 @@
+alter table events add column eventsequence int not null default 0;
+
+-- Events stored before sequence numbers were introduced are numbered
+-- in the order they were inserted.
+update events
+set
+    eventsequence = rowid;
+
+create index if not exists events_sequence_idx
+    on events (eventsequence);
@@ -0,0 +1,2 @@
+drop trigger if exists events_messages_search_remove;
+drop trigger if exists events_messages_search_edit;
@@ -0,0 +1,54 @@
+create trigger if not exists events_messages_search_edit
+after insert on events
+when new.eventtype = 'message-edited'
+begin
+    update messages_search
+    set
+        content = json_extract(new.eventdata, '$.content')
+    where
+        eventid = json_extract(new.eventdata, '$.messageId');
+end;
+
+create trigger if not exists events_messages_search_remove
+after insert on events
+when new.eventtype = 'message-deleted'
+begin
+    delete from messages_search
+    where
+        eventid = json_extract(new.eventdata, '$.messageId');
+end;
+
+-- Messages edited or deleted before the triggers were introduced are
+-- indexed with their current content.
+update messages_search
+set
+    content = (
+        select json_extract(edits.eventdata, '$.content')
+        from
+            events as edits
+        where
+            edits.eventtype = 'message-edited'
+            and json_extract(edits.eventdata, '$.messageId') = messages_search.eventid
+        order by
+            edits.eventsequence desc
+            , edits.rowid desc
+        limit 1
+    )
+where
+    eventid in (
+        select json_extract(eventdata, '$.messageId')
+        from
+            events
+        where
+            eventtype = 'message-edited'
+    );
+
+delete from messages_search
+where
+    eventid in (
+        select json_extract(eventdata, '$.messageId')
+        from
+            events
+        where
+            eventtype = 'message-deleted'
+    );
@@ -0,0 +1 @@
+drop index if exists events_message_id_idx;
@@ -0,0 +1,3 @@
+-- Edits and deletions are looked up by ID of message they refer to.
+create index if not exists events_message_id_idx
+    on events (json_extract(eventdata, '$.messageId'));
@@ -0,0 +1 @@
+drop table if exists events_sequence_mark;
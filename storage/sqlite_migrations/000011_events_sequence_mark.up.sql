@@ -0,0 +1,6 @@
+-- Sequence numbers are assigned also to events, which aren't stored,
+-- so the highest reserved one is kept apart from events.
+create table if not exists events_sequence_mark(
+    id int primary key check (id = 0),
+    sequence int not null
+);
@@ -0,0 +1,8 @@
+insert into mutes
+    ( userid
+    , expireat )
+values
+    ( :id
+    , :expireat )
+on conflict (userid) do update set
+    expireat = excluded.expireat;
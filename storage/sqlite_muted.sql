@@ -0,0 +1,6 @@
+select expireat
+from
+    mutes
+where
+    userid = :id
+    and expireat > :now;
@@ -0,0 +1,4 @@
+delete from events
+where
+    eventcreatedat < :before
+    and eventtype in (:userjoin, :userleft, :usertyping);
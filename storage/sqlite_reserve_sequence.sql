@@ -0,0 +1,8 @@
+insert into events_sequence_mark
+    ( id
+    , sequence )
+values
+    ( 0
+    , :sequence )
+on conflict (id) do update set
+    sequence = max(events_sequence_mark.sequence, excluded.sequence);
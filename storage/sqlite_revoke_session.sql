@@ -0,0 +1,8 @@
+insert into revoked_sessions
+    ( sessionid
+    , expireat )
+values
+    ( :id
+    , :expireat )
+on conflict (sessionid) do update set
+    expireat = max(revoked_sessions.expireat, excluded.expireat);
@@ -0,0 +1,41 @@
+select events.eventid
+    , events.eventtype
+    , events.eventcreatedat
+    , events.eventheaders
+    , coalesce(
+        (
+            select json_set(
+                events.eventdata
+                , '$.content', json_extract(edits.eventdata, '$.content')
+                , '$.html', json_extract(edits.eventdata, '$.html')
+                , '$.mentions', json_extract(edits.eventdata, '$.mentions')
+            )
+            from
+                events as edits
+            where
+                edits.eventtype = :edited
+                -- Unary plus drops affinity of the column, so index of
+                -- message IDs can be used.
+                and json_extract(edits.eventdata, '$.messageId') = +events.eventid
+            order by
+                edits.eventsequence desc
+                , edits.rowid desc
+            limit 1
+        )
+        , events.eventdata
+    )
+    , events.eventsequence
+from
+    messages_search
+    join events on events.eventid = messages_search.eventid
+where
+    messages_search match :query
+    and (
+        json_extract(events.eventdata, '$.to') is null
+        or json_extract(events.eventdata, '$.from.id') = :userid
+        or json_extract(events.eventdata, '$.to.id') = :userid
+    )
+order by
+    events.eventcreatedat desc
+    , events.rowid desc
+limit :limit;
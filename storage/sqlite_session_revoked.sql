@@ -0,0 +1,6 @@
+select count(*)
+from
+    revoked_sessions
+where
+    sessionid = :id
+    and expireat >= :now;
@@ -0,0 +1,5 @@
+select state
+from
+    session_tokens
+where
+    token = :token;
@@ -3,10 +3,12 @@ insert into events
     , eventtype
     , eventcreatedat
     , eventheaders
-    , eventdata )
+    , eventdata
+    , eventsequence )
 values
     ( :id
     , :type
     , :createdat
     , :headers
-    , :data );
+    , :data
+    , :sequence );
@@ -0,0 +1,11 @@
+insert into session_tokens
+    ( token
+    , state
+    , expireat )
+values
+    ( :token
+    , :state
+    , :expireat )
+on conflict (token) do update set
+    state = excluded.state,
+    expireat = excluded.expireat;
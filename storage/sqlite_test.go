@@ -0,0 +1,1061 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/matryer/is"
+	"github.com/sirupsen/logrus"
+
+	"github.com/fenole/szmaterlok/service"
+)
+
+// testStorage returns in-memory sqlite storage.
+func testStorage(t *testing.T) *SQLiteStorage {
+	t.Helper()
+
+	s, err := NewSQLiteStorage(context.Background(), ":memory:")
+	if err != nil {
+		t.Fatalf("failed to open storage: %s", err)
+	}
+	t.Cleanup(func() {
+		s.db.Close()
+	})
+
+	return s
+}
+
+// testEvent returns bridge event with given data encoded as json.
+func testEvent(t *testing.T, name service.BridgeEventType, id string, createdAt int64, data interface{}) service.BridgeEvent {
+	t.Helper()
+
+	b, err := json.Marshal(data)
+	if err != nil {
+		t.Fatalf("failed to encode event data: %s", err)
+	}
+
+	return service.BridgeEvent{
+		Name:      name,
+		ID:        id,
+		CreatedAt: createdAt,
+		Headers: service.BridgeHeaders{
+			"Content-Type": "application/json; charset=utf-8",
+		},
+		Data: b,
+	}
+}
+
+func testLogger() *logrus.Logger {
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+	return log
+}
+
+func TestSQLiteStorageMessageEdits(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+	s := testStorage(t)
+
+	sentAt := time.Unix(1000, 0)
+	author := service.ChatUser{ID: "author", Nickname: "author"}
+
+	evts := []service.BridgeEvent{
+		testEvent(t, service.BridgeMessageSent, "msg", 1000, service.EventSentMessage{
+			ID:      "msg",
+			From:    author,
+			Content: "helo",
+			SentAt:  sentAt,
+		}),
+		// Latest edit is stored before the older one.
+		testEvent(t, service.BridgeMessageEdited, "edit2", 1002, service.EventMessageEdited{
+			ID:        "edit2",
+			MessageID: "msg",
+			Content:   "hello!",
+			EditedAt:  sentAt.Add(time.Second * 2),
+			From:      author,
+		}),
+		testEvent(t, service.BridgeMessageEdited, "edit1", 1002, service.EventMessageEdited{
+			ID:        "edit1",
+			MessageID: "msg",
+			Content:   "hello",
+			EditedAt:  sentAt.Add(time.Second),
+			From:      author,
+		}),
+	}
+	for _, evt := range evts {
+		is.NoErr(s.StoreEvent(ctx, evt))
+	}
+
+	log := testLogger()
+	messages := service.NewStateMessages(10)
+	router := service.NewBridgeEventRouter()
+	router.Hook(service.BridgeMessageSent, service.StateMessageSentHook(log, messages))
+	router.Hook(service.BridgeMessageEdited, service.StateMessageEditedHook(log, messages))
+
+	builder := service.StateBuilder{
+		Archive: s,
+		Handler: router,
+	}
+	is.NoErr(builder.Rebuild(ctx))
+
+	msg, err := messages.Message(ctx, "msg")
+	is.NoErr(err)
+	is.Equal(msg.Content, "hello!")
+	is.True(msg.EditedAt.Equal(sentAt.Add(time.Second * 2)))
+}
+
+func TestSQLiteStorageMessagesBefore(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+	s := testStorage(t)
+
+	// Some of messages share creation date, so they have to be ordered
+	// by insertion order.
+	for i, createdAt := range []int64{100, 101, 101, 101, 102} {
+		id := strconv.Itoa(i + 1)
+		is.NoErr(s.StoreEvent(ctx, testEvent(t, service.BridgeMessageSent, id, createdAt, service.EventSentMessage{
+			ID: id,
+		})))
+	}
+	is.NoErr(s.StoreEvent(ctx, testEvent(t, service.BridgeUserJoin, "join", 103, service.EventUserJoin{
+		ID: "join",
+	})))
+
+	ids := func(evts []service.BridgeEvent) []string {
+		res := []string{}
+		for _, evt := range evts {
+			res = append(res, evt.ID)
+		}
+		return res
+	}
+
+	got, err := s.MessagesBefore(ctx, "", service.ChatChannelDefault, "", 2)
+	is.NoErr(err)
+	is.Equal(ids(got), []string{"5", "4"})
+
+	got, err = s.MessagesBefore(ctx, "", service.ChatChannelDefault, "4", 2)
+	is.NoErr(err)
+	is.Equal(ids(got), []string{"3", "2"})
+
+	got, err = s.MessagesBefore(ctx, "", service.ChatChannelDefault, "2", 10)
+	is.NoErr(err)
+	is.Equal(ids(got), []string{"1"})
+
+	got, err = s.MessagesBefore(ctx, "", service.ChatChannelDefault, "1", 10)
+	is.NoErr(err)
+	is.Equal(len(got), 0)
+
+	// Deleted messages are skipped and edited ones have content, HTML
+	// and mentions of their latest edit.
+	mentions := []service.ChatUser{{ID: "karol", Nickname: "karol"}}
+	for i, content := range []string{"first edit", "second edit"} {
+		id := "edit-" + strconv.Itoa(i)
+		is.NoErr(s.StoreEvent(ctx, testEvent(t, service.BridgeMessageEdited, id, int64(104+i), service.EventMessageEdited{
+			ID:        id,
+			MessageID: "3",
+			Content:   content,
+			HTML:      "<p>" + content + "</p>",
+			Mentions:  mentions[:i],
+		})))
+	}
+	is.NoErr(s.StoreEvent(ctx, testEvent(t, service.BridgeMessageDeleted, "delete", 106, service.EventMessageDeleted{
+		ID:        "delete",
+		MessageID: "4",
+	})))
+
+	got, err = s.MessagesBefore(ctx, "", service.ChatChannelDefault, "", 3)
+	is.NoErr(err)
+	is.Equal(ids(got), []string{"5", "3", "2"})
+
+	msg := service.EventSentMessage{}
+	is.NoErr(json.Unmarshal(got[1].Data, &msg))
+	is.Equal(msg.ID, "3")
+	is.Equal(msg.Content, "second edit")
+	is.Equal(msg.HTML, "<p>second edit</p>")
+	is.Equal(msg.Mentions, mentions)
+
+	// Only messages of requested channel and direct messages of given
+	// user are returned, before the limit is applied.
+	janek := service.ChatUser{ID: "janek"}
+	for _, m := range []service.EventSentMessage{
+		{ID: "random", Channel: "random"},
+		{ID: "dm", Channel: "random", From: service.ChatUser{ID: "karol"}, To: &janek},
+		{ID: "own", Channel: "random", From: janek},
+	} {
+		is.NoErr(s.StoreEvent(ctx, testEvent(t, service.BridgeMessageSent, m.ID, 107, m)))
+	}
+
+	got, err = s.MessagesBefore(ctx, "janek", service.ChatChannelDefault, "", 3)
+	is.NoErr(err)
+	is.Equal(ids(got), []string{"dm", "5", "3"})
+
+	got, err = s.MessagesBefore(ctx, "other", service.ChatChannelDefault, "", 3)
+	is.NoErr(err)
+	is.Equal(ids(got), []string{"5", "3", "2"})
+
+	got, err = s.MessagesBefore(ctx, "karol", "random", "", 10)
+	is.NoErr(err)
+	is.Equal(ids(got), []string{"own", "dm", "random"})
+}
+
+func TestSQLiteStorageModerationActionsBefore(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+	s := testStorage(t)
+
+	for i, action := range []service.ModerationAction{service.ModerationBan, service.ModerationKick, service.ModerationMute} {
+		id := strconv.Itoa(i + 1)
+		is.NoErr(s.StoreEvent(ctx, testEvent(t, service.BridgeModerationAction, id, 100, service.EventModerationAction{
+			ID:     id,
+			Action: action,
+		})))
+	}
+	is.NoErr(s.StoreEvent(ctx, testEvent(t, service.BridgeMessageSent, "msg", 101, service.EventSentMessage{
+		ID: "msg",
+	})))
+
+	ids := func(evts []service.BridgeEvent) []string {
+		res := []string{}
+		for _, evt := range evts {
+			res = append(res, evt.ID)
+		}
+		return res
+	}
+
+	got, err := s.ModerationActionsBefore(ctx, "", 2)
+	is.NoErr(err)
+	is.Equal(ids(got), []string{"3", "2"})
+
+	got, err = s.ModerationActionsBefore(ctx, "2", 10)
+	is.NoErr(err)
+	is.Equal(ids(got), []string{"1"})
+}
+
+func TestSQLiteStorageSequence(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+	s := testStorage(t)
+
+	last, err := s.LastSequence(ctx)
+	is.NoErr(err)
+	is.Equal(last, uint64(0))
+
+	// Events are stored out of sequence order, and their creation dates
+	// disagree with sequence numbers.
+	for _, seq := range []uint64{3, 1, 5, 2, 4} {
+		id := strconv.FormatUint(seq, 10)
+		evt := testEvent(t, service.BridgeMessageSent, id, int64(100-seq), service.EventSentMessage{
+			ID: id,
+		})
+		evt.Sequence = seq
+		is.NoErr(s.StoreEvent(ctx, evt))
+	}
+
+	last, err = s.LastSequence(ctx)
+	is.NoErr(err)
+	is.Equal(last, uint64(5))
+
+	// Reserved sequence numbers count only when they're greater than
+	// the stored ones, and they're never moved back.
+	is.NoErr(s.ReserveSequence(ctx, 3))
+	last, err = s.LastSequence(ctx)
+	is.NoErr(err)
+	is.Equal(last, uint64(5))
+
+	is.NoErr(s.ReserveSequence(ctx, 1000))
+	is.NoErr(s.ReserveSequence(ctx, 10))
+	last, err = s.LastSequence(ctx)
+	is.NoErr(err)
+	is.Equal(last, uint64(1000))
+
+	ids := func(evts []service.BridgeEvent) []string {
+		res := []string{}
+		for _, evt := range evts {
+			is.Equal(evt.ID, strconv.FormatUint(evt.Sequence, 10)) // sequence number should be read
+			res = append(res, evt.ID)
+		}
+		return res
+	}
+
+	got, err := s.MessagesBefore(ctx, "", service.ChatChannelDefault, "", 2)
+	is.NoErr(err)
+	is.Equal(ids(got), []string{"5", "4"})
+
+	got, err = s.MessagesBefore(ctx, "", service.ChatChannelDefault, "4", 10)
+	is.NoErr(err)
+	is.Equal(ids(got), []string{"3", "2", "1"})
+}
+
+func TestSQLiteStorageSearchMessages(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+	s := testStorage(t)
+
+	for i, content := range []string{
+		"hello world",
+		"nothing to see here",
+		"Hello again",
+		"hello there, world",
+	} {
+		id := strconv.Itoa(i + 1)
+		is.NoErr(s.StoreEvent(ctx, testEvent(t, service.BridgeMessageSent, id, int64(100+i), service.EventSentMessage{
+			ID:      id,
+			Content: content,
+		})))
+	}
+
+	// Direct messages newer than public ones, so they would fill the
+	// limit, if they were filtered after the query.
+	karol := service.ChatUser{ID: "karol"}
+	janek := service.ChatUser{ID: "janek"}
+	for i, id := range []string{"dm-1", "dm-2"} {
+		is.NoErr(s.StoreEvent(ctx, testEvent(t, service.BridgeMessageSent, id, int64(200+i), service.EventSentMessage{
+			ID:      id,
+			From:    karol,
+			To:      &janek,
+			Content: "hello in private",
+		})))
+	}
+
+	// Edited messages are found by their current content and deleted
+	// ones aren't found at all.
+	is.NoErr(s.StoreEvent(ctx, testEvent(t, service.BridgeMessageSent, "edited", 300, service.EventSentMessage{
+		ID:      "edited",
+		Content: "helo with typo",
+	})))
+	is.NoErr(s.StoreEvent(ctx, testEvent(t, service.BridgeMessageEdited, "edit-1", 301, service.EventMessageEdited{
+		ID:        "edit-1",
+		MessageID: "edited",
+		Content:   "fixed typo",
+	})))
+	is.NoErr(s.StoreEvent(ctx, testEvent(t, service.BridgeMessageSent, "deleted", 302, service.EventSentMessage{
+		ID:      "deleted",
+		Content: "hello to be deleted",
+	})))
+	is.NoErr(s.StoreEvent(ctx, testEvent(t, service.BridgeMessageDeleted, "delete-1", 303, service.EventMessageDeleted{
+		ID:        "delete-1",
+		MessageID: "deleted",
+	})))
+
+	ids := func(evts []service.BridgeEvent) []string {
+		res := []string{}
+		for _, evt := range evts {
+			res = append(res, evt.ID)
+		}
+		return res
+	}
+
+	type testArgs struct {
+		userID string
+		query  string
+		limit  int
+		ids    []string
+	}
+
+	scenario := func(name string, args testArgs) (string, func(*testing.T)) {
+		return name, func(t *testing.T) {
+			is := is.New(t)
+
+			got, err := s.SearchMessages(ctx, args.userID, args.query, args.limit)
+			is.NoErr(err)
+			is.Equal(ids(got), args.ids)
+		}
+	}
+
+	t.Run(scenario("single word", testArgs{
+		query: "hello",
+		limit: 10,
+		ids:   []string{"4", "3", "1"},
+	}))
+	t.Run(scenario("all words", testArgs{
+		query: "world hello",
+		limit: 10,
+		ids:   []string{"4", "1"},
+	}))
+	t.Run(scenario("limit", testArgs{
+		query: "hello",
+		limit: 2,
+		ids:   []string{"4", "3"},
+	}))
+	t.Run(scenario("no matches", testArgs{
+		query: "goodbye",
+		limit: 10,
+		ids:   []string{},
+	}))
+	t.Run(scenario("direct messages of other users", testArgs{
+		userID: "other",
+		query:  "hello",
+		limit:  2,
+		ids:    []string{"4", "3"},
+	}))
+	t.Run(scenario("direct messages of recipient", testArgs{
+		userID: "janek",
+		query:  "hello",
+		limit:  3,
+		ids:    []string{"dm-2", "dm-1", "4"},
+	}))
+	t.Run(scenario("edited message", testArgs{
+		query: "typo",
+		limit: 10,
+		ids:   []string{"edited"},
+	}))
+	t.Run("edited content", func(t *testing.T) {
+		is := is.New(t)
+
+		got, err := s.SearchMessages(ctx, "", "typo", 10)
+		is.NoErr(err)
+		is.Equal(len(got), 1)
+
+		msg := service.EventSentMessage{}
+		is.NoErr(json.Unmarshal(got[0].Data, &msg))
+		is.Equal(msg.Content, "fixed typo")
+	})
+	t.Run(scenario("content before edit", testArgs{
+		query: "helo",
+		limit: 10,
+		ids:   []string{},
+	}))
+	t.Run(scenario("deleted message", testArgs{
+		query: "deleted",
+		limit: 10,
+		ids:   []string{},
+	}))
+	t.Run(scenario("fts syntax", testArgs{
+		query: `"hello OR nothing*`,
+		limit: 10,
+		ids:   []string{},
+	}))
+	t.Run(scenario("blank query", testArgs{
+		query: "  ",
+		limit: 10,
+		ids:   []string{},
+	}))
+}
+
+func TestSQLiteStoragePing(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+	s := testStorage(t)
+
+	is.NoErr(s.Ping(ctx))
+
+	is.NoErr(s.db.Close())
+	is.True(s.Ping(ctx) != nil)
+}
+
+func TestSQLiteStorageRebuildLastMessages(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+	s := testStorage(t)
+
+	sentAt := time.Unix(1000, 0)
+	for i := 1; i <= 3; i++ {
+		id := strconv.Itoa(i)
+		is.NoErr(s.StoreEvent(ctx, testEvent(t, service.BridgeMessageSent, id, int64(1000+i), service.EventSentMessage{
+			ID:      id,
+			Content: "message " + id,
+			SentAt:  sentAt.Add(time.Second * time.Duration(i)),
+		})))
+	}
+	is.NoErr(s.StoreEvent(ctx, testEvent(t, service.BridgeUserJoin, "join", 1004, service.EventUserJoin{
+		ID:   "join",
+		User: service.ChatUser{ID: "user", Nickname: "user"},
+	})))
+
+	// Buffer and online users start empty, as they do after restart.
+	buffer := service.NewLastMessagesBuffer(10, testLogger())
+	users := service.NewStateOnlineUsers()
+
+	router := service.NewBridgeEventRouter()
+	router.Hook(service.BridgeMessageSent, buffer)
+
+	builder := service.StateBuilder{
+		Archive: s,
+		Handler: router,
+	}
+	is.NoErr(builder.Rebuild(ctx))
+
+	ids := []string{}
+	for _, msg := range buffer.LastMessages(ctx, service.ChatChannelDefault, "") {
+		ids = append(ids, msg.ID)
+	}
+	is.Equal(ids, []string{"1", "2", "3"})
+
+	online, err := users.AllChatUsers(ctx)
+	is.NoErr(err)
+	is.Equal(len(online), 0)
+}
+
+func TestSQLiteStorageSequenceRestart(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "szmaterlok.sqlite3")
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+
+	// run starts event bridge numbering events after the previous
+	// run, sends given events and shuts the bridge down.
+	run := func(handler service.BridgeEventHandler, evts ...service.BridgeEvent) []service.BridgeEvent {
+		s, err := NewSQLiteStorage(ctx, path)
+		is.NoErr(err)
+		defer s.db.Close()
+
+		sequence, err := s.LastSequence(ctx)
+		is.NoErr(err)
+
+		handled := []service.BridgeEvent{}
+		bridge := service.NewBridge(ctx, service.BridgeBuilder{
+			Handler: service.BridgeEventHandlerFunc(func(ctx context.Context, evt service.BridgeEvent) {
+				handled = append(handled, evt)
+				if handler != nil {
+					handler.EventHook(ctx, evt)
+				}
+			}),
+			Logger:    log,
+			Storage:   s,
+			Persist:   service.PersistPolicyDefault().Persist,
+			Ordered:   true,
+			Sequence:  sequence,
+			Sequences: s,
+		})
+		for _, evt := range evts {
+			bridge.SendEvent(evt)
+		}
+		bridge.Shutdown(ctx)
+
+		// Retention can prune every stored event.
+		_, err = s.PruneBefore(ctx, time.Now().Add(time.Hour))
+		is.NoErr(err)
+
+		return handled
+	}
+
+	first := run(nil,
+		testEvent(t, service.BridgeMessageSent, "1", 100, service.EventSentMessage{ID: "1"}),
+		testEvent(t, service.BridgeUserJoin, "join", 101, service.EventUserJoin{ID: "join"}),
+	)
+	is.Equal(len(first), 2)
+	join := first[1]
+
+	// Client resuming from ID of join event, which hasn't been stored,
+	// receives messages sent after restart.
+	buffer := service.NewLastMessagesBuffer(10, log)
+	second := run(buffer,
+		testEvent(t, service.BridgeMessageSent, "2", 102, service.EventSentMessage{ID: "2"}),
+	)
+	is.Equal(len(second), 1)
+	is.True(second[0].Sequence > join.Sequence)
+
+	got := buffer.LastMessages(ctx, "", strconv.FormatUint(join.Sequence, 10))
+	is.Equal(len(got), 1)
+	is.Equal(got[0].ID, "2")
+}
+
+func TestSQLiteStorageRevokeSession(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+	s := testStorage(t)
+
+	now := time.Unix(1000, 0)
+	s.now = func() time.Time {
+		return now
+	}
+
+	is.NoErr(s.RevokeSession(ctx, "old", now.Add(time.Minute)))
+
+	revoked, err := s.SessionRevoked(ctx, "old")
+	is.NoErr(err)
+	is.True(revoked)
+
+	revoked, err = s.SessionRevoked(ctx, "other")
+	is.NoErr(err)
+	is.True(!revoked)
+
+	// Session has expired, so it doesn't have to be revoked anymore.
+	now = now.Add(time.Hour)
+	revoked, err = s.SessionRevoked(ctx, "old")
+	is.NoErr(err)
+	is.True(!revoked)
+
+	// Expired entries are collected on next revocation.
+	is.NoErr(s.RevokeSession(ctx, "new", now.Add(time.Minute)))
+
+	var count int
+	is.NoErr(s.db.QueryRowContext(ctx, "select count(*) from revoked_sessions").Scan(&count))
+	is.Equal(count, 1)
+
+	// Revocation with earlier expiration date keeps the later one.
+	is.NoErr(s.RevokeSession(ctx, "new", now.Add(time.Second)))
+	now = now.Add(time.Second * 30)
+	revoked, err = s.SessionRevoked(ctx, "new")
+	is.NoErr(err)
+	is.True(revoked)
+}
+
+func TestSQLiteStorageSessionTokens(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "szmaterlok.sqlite3")
+
+	open := func() *SQLiteStorage {
+		s, err := NewSQLiteStorage(ctx, path)
+		is.NoErr(err)
+		return s
+	}
+
+	now := time.Now()
+	state := service.SessionState{
+		Nickname:  "karol",
+		ID:        "uniqueid",
+		CreatedAt: now.Truncate(time.Second),
+		ExpireAt:  now.Add(time.Hour).Truncate(time.Second),
+	}
+
+	// Token encoded by one tokenizer is decoded by another one,
+	// which shares the same database.
+	first := open()
+	token, err := service.NewSessionSimpleTokenizerWithStore(first).TokenEncode(state)
+	is.NoErr(err)
+	is.NoErr(first.db.Close())
+
+	second := open()
+	t.Cleanup(func() {
+		second.db.Close()
+	})
+	got, err := service.NewSessionSimpleTokenizerWithStore(second).TokenDecode(token)
+	is.NoErr(err)
+	is.Equal(got.ID, state.ID)
+	is.Equal(got.Nickname, state.Nickname)
+	is.True(got.CreatedAt.Equal(state.CreatedAt))
+	is.True(got.ExpireAt.Equal(state.ExpireAt))
+
+	_, err = second.SessionToken(ctx, "other")
+	is.True(errors.Is(err, service.ErrMissingSessionToken))
+
+	is.NoErr(second.DeleteSessionToken(ctx, "other"))
+
+	// Expired tokens are collected with next stored token.
+	second.now = func() time.Time {
+		return now.Add(time.Hour * 2)
+	}
+	is.NoErr(second.StoreSessionToken(ctx, "new", service.SessionState{
+		ID:       "new",
+		ExpireAt: now.Add(time.Hour * 3),
+	}))
+
+	var count int
+	is.NoErr(second.db.QueryRowContext(ctx, "select count(*) from session_tokens").Scan(&count))
+	is.Equal(count, 1)
+
+	// All tokens of given user are deleted at once.
+	is.NoErr(second.StoreSessionToken(ctx, "copy", service.SessionState{
+		ID:       "new",
+		ExpireAt: now.Add(time.Hour * 3),
+	}))
+	is.NoErr(second.StoreSessionToken(ctx, "other", service.SessionState{
+		ID:       "other",
+		ExpireAt: now.Add(time.Hour * 3),
+	}))
+	is.NoErr(second.DeleteUserSessionTokens(ctx, "new"))
+
+	_, err = second.SessionToken(ctx, "new")
+	is.True(errors.Is(err, service.ErrMissingSessionToken))
+	_, err = second.SessionToken(ctx, "copy")
+	is.True(errors.Is(err, service.ErrMissingSessionToken))
+	_, err = second.SessionToken(ctx, "other")
+	is.NoErr(err)
+}
+
+func TestSQLiteStorageBan(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+	s := testStorage(t)
+
+	now := time.Unix(1000, 0)
+	s.now = func() time.Time {
+		return now
+	}
+
+	is.NoErr(s.Ban(ctx, "temporary", now.Add(time.Minute)))
+	is.NoErr(s.Ban(ctx, "permanent", time.Time{}))
+
+	for _, id := range []string{"temporary", "permanent"} {
+		banned, err := s.Banned(ctx, id)
+		is.NoErr(err)
+		is.True(banned)
+	}
+
+	banned, err := s.Banned(ctx, "other")
+	is.NoErr(err)
+	is.True(!banned)
+
+	// Temporary ban has expired, permanent one hasn't.
+	now = now.Add(time.Hour)
+	banned, err = s.Banned(ctx, "temporary")
+	is.NoErr(err)
+	is.True(!banned)
+	banned, err = s.Banned(ctx, "permanent")
+	is.NoErr(err)
+	is.True(banned)
+
+	// Expired bans are collected on next ban.
+	is.NoErr(s.Ban(ctx, "new", now.Add(time.Minute)))
+
+	var count int
+	is.NoErr(s.db.QueryRowContext(ctx, "select count(*) from bans").Scan(&count))
+	is.Equal(count, 2)
+
+	is.NoErr(s.Unban(ctx, "permanent"))
+	banned, err = s.Banned(ctx, "permanent")
+	is.NoErr(err)
+	is.True(!banned)
+}
+
+func TestSQLiteStorageMute(t *testing.T) {
+	is := is.New(t)
+	ctx := context.Background()
+	s := testStorage(t)
+
+	now := time.Unix(1000, 0)
+	s.now = func() time.Time {
+		return now
+	}
+
+	_, muted, err := s.Muted(ctx, "spammer")
+	is.NoErr(err)
+	is.True(!muted)
+
+	is.NoErr(s.Mute(ctx, "spammer", now.Add(time.Minute)))
+	is.NoErr(s.Mute(ctx, "other", now.Add(time.Hour)))
+
+	expireAt, muted, err := s.Muted(ctx, "spammer")
+	is.NoErr(err)
+	is.True(muted)
+	is.Equal(expireAt.Unix(), now.Add(time.Minute).Unix())
+
+	// Mute is lifted.
+	is.NoErr(s.Unmute(ctx, "other"))
+	_, muted, err = s.Muted(ctx, "other")
+	is.NoErr(err)
+	is.True(!muted)
+
+	// Mute expires.
+	now = now.Add(time.Minute)
+	_, muted, err = s.Muted(ctx, "spammer")
+	is.NoErr(err)
+	is.True(!muted)
+
+	// Expired mutes are collected on next mute.
+	is.NoErr(s.Mute(ctx, "new", now.Add(time.Minute)))
+
+	var count int
+	is.NoErr(s.db.QueryRowContext(ctx, "select count(*) from mutes").Scan(&count))
+	is.Equal(count, 1)
+}
+
+func TestSQLiteStoragePrune(t *testing.T) {
+	// storedIDs returns IDs of all events left in the storage.
+	storedIDs := func(t *testing.T, s *SQLiteStorage) []string {
+		t.Helper()
+
+		c := make(chan service.BridgeEvent, 16)
+		if err := s.Events(context.Background(), c); err != nil {
+			t.Fatalf("failed to read events: %s", err)
+		}
+		close(c)
+
+		res := []string{}
+		for evt := range c {
+			res = append(res, evt.ID)
+		}
+		return res
+	}
+
+	// fill stores events of different types and creation dates.
+	fill := func(t *testing.T, s *SQLiteStorage) {
+		t.Helper()
+
+		evts := []service.BridgeEvent{
+			testEvent(t, service.BridgeMessageSent, "old-msg", 100, service.EventSentMessage{ID: "old-msg", Content: "old message"}),
+			testEvent(t, service.BridgeUserJoin, "old-join", 150, service.EventUserJoin{ID: "old-join"}),
+			testEvent(t, service.BridgeMessageSent, "new-msg", 200, service.EventSentMessage{ID: "new-msg", Content: "new message"}),
+			testEvent(t, service.BridgeUserLeft, "new-left", 250, service.EventUserLeft{ID: "new-left"}),
+		}
+		for _, evt := range evts {
+			if err := s.StoreEvent(context.Background(), evt); err != nil {
+				t.Fatalf("failed to store event: %s", err)
+			}
+		}
+	}
+
+	t.Run("all events", func(t *testing.T) {
+		is := is.New(t)
+		ctx := context.Background()
+		s := testStorage(t)
+		fill(t, s)
+
+		n, err := s.PruneBefore(ctx, time.Unix(200, 0))
+		is.NoErr(err)
+		is.Equal(n, int64(2))
+		is.Equal(storedIDs(t, s), []string{"new-msg", "new-left"})
+
+		// Pruned messages are removed from search index as well.
+		var indexed int
+		is.NoErr(s.db.QueryRowContext(ctx, `select count(*) from messages_search;`).Scan(&indexed))
+		is.Equal(indexed, 1)
+
+		found, err := s.SearchMessages(ctx, "", "message", 10)
+		is.NoErr(err)
+		is.Equal(len(found), 1)
+		is.Equal(found[0].ID, "new-msg")
+	})
+
+	t.Run("ephemeral events", func(t *testing.T) {
+		is := is.New(t)
+		ctx := context.Background()
+		s := testStorage(t)
+		fill(t, s)
+
+		n, err := s.PruneEphemeralBefore(ctx, time.Unix(300, 0))
+		is.NoErr(err)
+		is.Equal(n, int64(2))
+		is.Equal(storedIDs(t, s), []string{"old-msg", "new-msg"})
+	})
+
+	t.Run("nothing to prune", func(t *testing.T) {
+		is := is.New(t)
+		s := testStorage(t)
+		fill(t, s)
+
+		n, err := s.PruneBefore(context.Background(), time.Unix(50, 0))
+		is.NoErr(err)
+		is.Equal(n, int64(0))
+		is.Equal(len(storedIDs(t, s)), 4)
+	})
+}
+
+// testEvents returns given number of message-sent events.
+func testEvents(t testing.TB, prefix string, n int) []service.BridgeEvent {
+	res := make([]service.BridgeEvent, 0, n)
+	for i := 0; i < n; i++ {
+		id := prefix + strconv.Itoa(i)
+		res = append(res, service.BridgeEvent{
+			Name:      service.BridgeMessageSent,
+			ID:        id,
+			CreatedAt: int64(i),
+			Headers: service.BridgeHeaders{
+				"Content-Type": "application/json; charset=utf-8",
+			},
+			Data: []byte(`{"id":"` + id + `","content":"message ` + id + `"}`),
+		})
+	}
+	return res
+}
+
+func TestSQLiteStorageStoreEvents(t *testing.T) {
+	const batch = 1000
+
+	t.Run("batch", func(t *testing.T) {
+		is := is.New(t)
+		ctx := context.Background()
+		s := testStorage(t)
+
+		evts := testEvents(t, "evt", batch)
+		is.NoErr(s.StoreEvents(ctx, evts))
+
+		c := make(chan service.BridgeEvent, batch)
+		is.NoErr(s.Events(ctx, c))
+		close(c)
+
+		got := []service.BridgeEvent{}
+		for evt := range c {
+			got = append(got, evt)
+		}
+		is.Equal(got, evts)
+
+		// Messages stored in batch are indexed for search.
+		found, err := s.SearchMessages(ctx, "", "evt999", 10)
+		is.NoErr(err)
+		is.Equal(len(found), 1)
+	})
+
+	t.Run("single transaction", func(t *testing.T) {
+		is := is.New(t)
+		ctx := context.Background()
+		s := testStorage(t)
+
+		// The last event duplicates ID of the first one, so the whole
+		// batch has to be rolled back.
+		evts := testEvents(t, "evt", batch)
+		evts = append(evts, evts[0])
+		is.True(s.StoreEvents(ctx, evts) != nil)
+
+		var count int
+		is.NoErr(s.db.QueryRowContext(ctx, `select count(*) from events;`).Scan(&count))
+		is.Equal(count, 0)
+	})
+
+	t.Run("slow reader", func(t *testing.T) {
+		is := is.New(t)
+		ctx := context.Background()
+		s := testStorage(t)
+
+		evts := testEvents(t, "evt", batch)
+		is.NoErr(s.StoreEvents(ctx, evts))
+
+		c := make(chan service.BridgeEvent)
+		errc := make(chan error, 1)
+		go func() {
+			defer close(c)
+			errc <- s.Events(ctx, c)
+		}()
+		is.Equal((<-c).ID, evts[0].ID)
+
+		// Events are stored while reader holds back the rest of them.
+		stored := make(chan error, 1)
+		go func() {
+			stored <- s.StoreEvent(ctx, testEvent(t, service.BridgeMessageSent, "late", batch, struct{}{}))
+		}()
+		select {
+		case err := <-stored:
+			is.NoErr(err)
+		case <-time.After(time.Second):
+			t.Fatal("event has not been stored during read")
+		}
+
+		n := 1
+		for range c {
+			n++
+		}
+		is.NoErr(<-errc)
+		is.Equal(n, batch+1)
+	})
+}
+
+// benchmarkStorage returns sqlite storage backed by temporary file.
+func benchmarkStorage(b *testing.B) *SQLiteStorage {
+	b.Helper()
+
+	s, err := NewSQLiteStorage(context.Background(), filepath.Join(b.TempDir(), "bench.sqlite3"))
+	if err != nil {
+		b.Fatalf("failed to open storage: %s", err)
+	}
+	b.Cleanup(func() {
+		s.db.Close()
+	})
+
+	return s
+}
+
+func BenchmarkSQLiteStorageStoreEvent(b *testing.B) {
+	ctx := context.Background()
+	s := benchmarkStorage(b)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, evt := range testEvents(b, strconv.Itoa(i)+"-", 100) {
+			if err := s.StoreEvent(ctx, evt); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func BenchmarkSQLiteStorageStoreEvents(b *testing.B) {
+	ctx := context.Background()
+	s := benchmarkStorage(b)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := s.StoreEvents(ctx, testEvents(b, strconv.Itoa(i)+"-", 100)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestSQLiteStorageEventsFiltered(t *testing.T) {
+	type testArgs struct {
+		name   string
+		filter service.EventFilter
+		want   []string
+	}
+
+	scenario := func(tt testArgs) (string, func(*testing.T)) {
+		return tt.name, func(t *testing.T) {
+			is := is.New(t)
+			ctx := context.Background()
+			s := testStorage(t)
+
+			evts := []service.BridgeEvent{
+				testEvent(t, service.BridgeUserJoin, "join", 100, service.EventUserJoin{ID: "join"}),
+				testEvent(t, service.BridgeMessageSent, "msg1", 100, service.EventSentMessage{ID: "msg1"}),
+				testEvent(t, service.BridgeMessageSent, "msg2", 200, service.EventSentMessage{ID: "msg2"}),
+				testEvent(t, service.BridgeMessageEdited, "edit", 250, service.EventMessageEdited{ID: "edit"}),
+				testEvent(t, service.BridgeMessageSent, "msg3", 300, service.EventSentMessage{ID: "msg3"}),
+				testEvent(t, service.BridgeUserLeft, "left", 400, service.EventUserLeft{ID: "left"}),
+			}
+			is.NoErr(s.StoreEvents(ctx, evts))
+
+			c := make(chan service.BridgeEvent, len(evts))
+			is.NoErr(s.EventsFiltered(ctx, tt.filter, c))
+			close(c)
+
+			got := []string{}
+			for evt := range c {
+				is.True(tt.filter.Match(evt)) // storage and in-memory filters agree
+				got = append(got, evt.ID)
+			}
+			is.Equal(got, tt.want)
+		}
+	}
+
+	t.Run(scenario(testArgs{
+		name:   "no filter",
+		filter: service.EventFilter{},
+		want:   []string{"join", "msg1", "msg2", "edit", "msg3", "left"},
+	}))
+	t.Run(scenario(testArgs{
+		name: "types",
+		filter: service.EventFilter{
+			Types: []service.BridgeEventType{service.BridgeMessageSent, service.BridgeMessageEdited},
+		},
+		want: []string{"msg1", "msg2", "edit", "msg3"},
+	}))
+	t.Run(scenario(testArgs{
+		name: "since",
+		filter: service.EventFilter{
+			Since: time.Unix(250, 0),
+		},
+		want: []string{"edit", "msg3", "left"},
+	}))
+	t.Run(scenario(testArgs{
+		name: "until",
+		filter: service.EventFilter{
+			Until: time.Unix(200, 0),
+		},
+		want: []string{"join", "msg1"},
+	}))
+	t.Run(scenario(testArgs{
+		name: "combination",
+		filter: service.EventFilter{
+			Types: []service.BridgeEventType{service.BridgeMessageSent},
+			Since: time.Unix(200, 0),
+			Until: time.Unix(400, 0),
+		},
+		want: []string{"msg2", "msg3"},
+	}))
+	t.Run(scenario(testArgs{
+		name: "empty range",
+		filter: service.EventFilter{
+			Since: time.Unix(300, 0),
+			Until: time.Unix(300, 0),
+		},
+		want: []string{},
+	}))
+}
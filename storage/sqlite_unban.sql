@@ -0,0 +1,3 @@
+delete from bans
+where
+    banid = :id;
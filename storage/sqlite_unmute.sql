@@ -0,0 +1,3 @@
+delete from mutes
+where
+    userid = :id;
@@ -0,0 +1,125 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/fenole/szmaterlok/service"
+)
+
+// Storage is event storage with all of the capabilities used by
+// szmaterlok. It is implemented by every storage backend.
+type Storage interface {
+	service.BridgeStorage
+	service.FilteredStateArchive
+	service.MessageHistory
+	service.ModerationLog
+	service.MessageSearch
+	service.Pinger
+	service.SessionRevoker
+	service.BanStore
+	service.BridgeSequenceStore
+	Pruner
+
+	// StoreEvents stores given events within single transaction.
+	StoreEvents(context.Context, []service.BridgeEvent) error
+
+	// LastSequence returns the greatest sequence number of stored
+	// events or reserved by event bridge, so event bridge can continue
+	// numbering after restart.
+	LastSequence(context.Context) (uint64, error)
+}
+
+// Pruner deletes old events from event storage.
+type Pruner interface {
+	// PruneBefore deletes all events created before given time.
+	PruneBefore(ctx context.Context, t time.Time) (int64, error)
+
+	// PruneEphemeralBefore deletes ephemeral events (user presence
+	// and typing) created before given time.
+	PruneEphemeralBefore(ctx context.Context, t time.Time) (int64, error)
+}
+
+// banExpireAt returns ban expiration date as unix epoch. Permanent
+// bans, with zero expiration date, are stored as NULL.
+func banExpireAt(t time.Time) sql.NullInt64 {
+	if t.IsZero() {
+		return sql.NullInt64{}
+	}
+
+	return sql.NullInt64{Int64: t.Unix(), Valid: true}
+}
+
+// ErrUnsupportedDSN is returned by Open for connection strings with
+// scheme of unknown storage backend.
+var ErrUnsupportedDSN = errors.New("unsupported storage connection string")
+
+// storageBackend is kind of storage selected by connection string.
+type storageBackend int
+
+const (
+	backendSQLite storageBackend = iota
+	backendPostgres
+)
+
+// sqliteMemory is sqlite path of in-memory database.
+const sqliteMemory = ":memory:"
+
+// Open opens and migrates storage selected by given connection string:
+//
+//   - ":memory:" opens in-memory sqlite database,
+//   - "postgres://" and "postgresql://" URLs open postgres database,
+//   - "sqlite://" URLs and plain file paths open sqlite database.
+//
+// Connection strings with any other scheme are rejected with
+// ErrUnsupportedDSN.
+func Open(ctx context.Context, dsn string) (Storage, error) {
+	backend, target, err := parseDSN(dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	switch backend {
+	case backendPostgres:
+		s, err := NewPostgresStorage(ctx, target)
+		if err != nil {
+			return nil, err
+		}
+		return s, nil
+	default:
+		s, err := NewSQLiteStorage(ctx, target)
+		if err != nil {
+			return nil, err
+		}
+		return s, nil
+	}
+}
+
+// parseDSN returns storage backend for given connection string along
+// with target, which should be passed to the backend.
+func parseDSN(dsn string) (storageBackend, string, error) {
+	if dsn == "" {
+		return 0, "", fmt.Errorf("%w: empty connection string", ErrUnsupportedDSN)
+	}
+	if dsn == sqliteMemory {
+		return backendSQLite, sqliteMemory, nil
+	}
+
+	scheme, rest, ok := strings.Cut(dsn, "://")
+	if !ok {
+		return backendSQLite, dsn, nil
+	}
+
+	switch strings.ToLower(scheme) {
+	case "postgres", "postgresql":
+		return backendPostgres, dsn, nil
+	case "sqlite":
+		return backendSQLite, rest, nil
+	default:
+		return 0, "", fmt.Errorf("%w: %s", ErrUnsupportedDSN, scheme)
+	}
+}